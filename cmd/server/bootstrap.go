@@ -0,0 +1,181 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/nats-io/nkeys"
+
+	"github.com/portswigger-tim/nats-k8s-oidc-callout/internal/embeddednats"
+)
+
+// bootstrapDevOptions holds the options for the bootstrap-dev subcommand.
+type bootstrapDevOptions struct {
+	dir      string
+	natsHost string
+	natsPort int
+	user     string
+	force    bool
+}
+
+// runBootstrapDev implements the "bootstrap-dev" subcommand.
+//
+// It generates everything needed to run the service against a local NATS server:
+//   - an account nkey, written as a credentials-style signing key file
+//   - a nats-server.conf with auth_callout configured for that key
+//   - an env file with the variables needed to run the service
+//
+// The env vars are also printed to stdout so they can be eval'd directly. Existing files are
+// left alone unless --force is given.
+func runBootstrapDev(args []string, stdout io.Writer) error {
+	opts := bootstrapDevOptions{}
+
+	fs := flag.NewFlagSet("bootstrap-dev", flag.ContinueOnError)
+	fs.StringVar(&opts.dir, "dir", filepath.Join("local-dev", "generated"), "directory to write the generated files to")
+	fs.StringVar(&opts.natsHost, "nats-host", "localhost", "hostname the service uses to reach NATS")
+	fs.IntVar(&opts.natsPort, "nats-port", 4222, "NATS client port")
+	fs.StringVar(&opts.user, "user", "auth-service", "NATS user the service connects as")
+	fs.BoolVar(&opts.force, "force", false, "overwrite existing generated files")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	signingKeyPath := filepath.Join(opts.dir, "signing.key")
+	confPath := filepath.Join(opts.dir, "nats-server.conf")
+	envPath := filepath.Join(opts.dir, "dev.env")
+
+	// Check every file up front so a refusal never leaves a half-written setup behind
+	if !opts.force {
+		for _, path := range []string{signingKeyPath, confPath, envPath} {
+			if _, err := os.Stat(path); err == nil {
+				return fmt.Errorf("%s already exists (use --force to overwrite)", path)
+			} else if !os.IsNotExist(err) {
+				return fmt.Errorf("failed to check %s: %w", path, err)
+			}
+		}
+	}
+
+	if err := os.MkdirAll(opts.dir, 0o750); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	// Generate the account key used to sign authorization responses
+	kp, err := nkeys.CreateAccount()
+	if err != nil {
+		return fmt.Errorf("failed to create account key: %w", err)
+	}
+	seed, err := kp.Seed()
+	if err != nil {
+		return fmt.Errorf("failed to get seed: %w", err)
+	}
+	pub, err := kp.PublicKey()
+	if err != nil {
+		return fmt.Errorf("failed to get public key: %w", err)
+	}
+
+	// Generate a random password for the service's own NATS connection
+	password, err := embeddednats.RandomPassword()
+	if err != nil {
+		return fmt.Errorf("failed to generate password: %w", err)
+	}
+
+	if err := writeFile(signingKeyPath, renderSigningKey(seed), 0o600); err != nil {
+		return err
+	}
+
+	if err := writeFile(confPath, renderNATSServerConfig(opts, password, pub), 0o600); err != nil {
+		return err
+	}
+
+	env := renderDevEnv(opts, password, signingKeyPath)
+	if err := writeFile(envPath, []byte(env), 0o600); err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(stdout, "# Generated local development setup\n"+
+		"# Account public key (auth_callout issuer): %s\n"+
+		"# Signing key:      %s\n"+
+		"# NATS config:      %s\n"+
+		"# Env file:         %s\n"+
+		"#\n"+
+		"# Start NATS:  nats-server -c %s\n"+
+		"# Then run the service with the following environment:\n"+
+		"%s",
+		pub, signingKeyPath, confPath, envPath, confPath, env)
+	return err
+}
+
+// renderSigningKey formats an account seed in the NATS credentials seed format
+// understood by nats.LoadSigningKeyFromFile.
+func renderSigningKey(seed []byte) []byte {
+	return []byte(fmt.Sprintf(`************************* IMPORTANT *************************
+NKEY Seed printed below can be used to sign and prove identity.
+NKEYs are sensitive and should be treated as secrets.
+
+-----BEGIN NKEY SEED-----
+%s
+------END NKEY SEED------
+
+*************************************************************
+`, seed))
+}
+
+// renderNATSServerConfig renders a nats-server.conf with auth_callout configured
+// to trust the generated account key.
+func renderNATSServerConfig(opts bootstrapDevOptions, password, issuer string) []byte {
+	return []byte(fmt.Sprintf(`# NATS Server Configuration for Local Development
+# Generated by: server bootstrap-dev
+
+port: %d
+http_port: 8222
+
+authorization {
+    # User the auth callout service connects as
+    users: [
+        { user: %q, password: %q }
+    ]
+
+    auth_callout {
+        # Account public key that signs authorization responses
+        issuer: %q
+
+        # Users allowed to handle auth requests
+        auth_users: [%q]
+    }
+}
+`, opts.natsPort, opts.user, password, issuer, opts.user))
+}
+
+// renderDevEnv renders the environment variables needed to run the service
+// against the generated configuration.
+func renderDevEnv(opts bootstrapDevOptions, password, signingKeyPath string) string {
+	jwksPath := filepath.Join(opts.dir, "jwks.json")
+	return fmt.Sprintf(`export NATS_URL=nats://%s:%d
+export NATS_USERNAME=%s
+export NATS_PASSWORD=%s
+export NATS_ACCOUNT='$G'
+export NATS_SIGNING_KEY_FILE=%s
+export K8S_IN_CLUSTER=false
+# ServiceAccount cache (out of cluster, so a kubeconfig is required)
+export KUBECONFIG=${KUBECONFIG:-$HOME/.kube/config}
+export JWT_AUDIENCE=nats
+export LOG_LEVEL=debug
+export LOG_FORMAT=console
+# Token validation (extract from your cluster):
+#   kubectl get --raw /openid/v1/jwks > %s
+#   kubectl get --raw /.well-known/openid-configuration | jq -r .issuer
+export JWKS_PATH=%s
+export JWT_ISSUER=https://kubernetes.default.svc.cluster.local
+`, opts.natsHost, opts.natsPort, opts.user, password, signingKeyPath, jwksPath, jwksPath)
+}
+
+// writeFile writes data to path, wrapping errors with the file name.
+func writeFile(path string, data []byte, perm os.FileMode) error {
+	if err := os.WriteFile(path, data, perm); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/nats-io/nkeys"
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/portswigger-tim/nats-k8s-oidc-callout/internal/config"
+	"github.com/portswigger-tim/nats-k8s-oidc-callout/internal/devoidc"
+	"github.com/portswigger-tim/nats-k8s-oidc-callout/internal/embeddednats"
+	"github.com/portswigger-tim/nats-k8s-oidc-callout/internal/k8s"
+	"github.com/portswigger-tim/nats-k8s-oidc-callout/internal/logging"
+)
+
+// startDevOIDC starts the embedded mock OIDC issuer and points JWT validation at it.
+func startDevOIDC(cfg *config.Config, logger *zap.Logger) (*devoidc.Server, error) {
+	srv, err := devoidc.New(cfg.DevOIDCAddr, logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start mock OIDC issuer: %w", err)
+	}
+	srv.Start()
+
+	cfg.JWKSUrl = srv.JWKSURL()
+	cfg.JWTIssuer = srv.Issuer()
+
+	logger.Warn("DEVELOPMENT ONLY: embedded mock OIDC issuer is issuing tokens to anyone",
+		zap.String("issuer", srv.Issuer()),
+		zap.String("jwks_url", srv.JWKSURL()),
+		zap.String("token_url", srv.Issuer()+devoidc.TokenPath+"?namespace=<ns>&serviceaccount=<sa>"))
+
+	return srv, nil
+}
+
+// startEmbeddedNATS starts the in-process NATS server, trusting the signing key as the
+// auth callout issuer, and points the service's own NATS connection at it.
+func startEmbeddedNATS(cfg *config.Config, signingKey nkeys.KeyPair, logger *zap.Logger) (*embeddednats.Server, error) {
+	issuer, err := signingKey.PublicKey()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get signing key public key: %w", err)
+	}
+
+	srv, err := embeddednats.Start(embeddednats.Options{
+		Host:   "127.0.0.1",
+		Port:   cfg.EmbeddedNATSPort,
+		Issuer: issuer,
+	}, logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start embedded NATS server: %w", err)
+	}
+
+	cfg.NatsURL = srv.ServiceURL()
+
+	logger.Warn("DEVELOPMENT ONLY: running embedded NATS server",
+		zap.String("client_url", srv.ClientURL()),
+		zap.String("service_url", logging.RedactNATSURL(cfg.NatsURL)),
+		zap.String("issuer", issuer))
+
+	return srv, nil
+}
+
+// newFakeClientset creates an in-memory Kubernetes API holding the ServiceAccounts
+// from FAKE_SERVICEACCOUNTS_FILE, for running integration tests against the real binary.
+func newFakeClientset(cfg *config.Config, logger *zap.Logger) (kubernetes.Interface, error) {
+	var objects []runtime.Object
+	if cfg.FakeServiceAccountsFile != "" {
+		accounts, err := k8s.LoadServiceAccountsFile(cfg.FakeServiceAccountsFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load fake ServiceAccounts: %w", err)
+		}
+		for _, sa := range accounts {
+			objects = append(objects, sa)
+		}
+	}
+
+	logger.Warn("FAKE MODE: using in-memory Kubernetes API instead of a cluster",
+		zap.String("serviceaccounts_file", cfg.FakeServiceAccountsFile),
+		zap.Int("serviceaccounts", len(objects)))
+
+	return fake.NewClientset(objects...), nil
+}
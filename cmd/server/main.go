@@ -3,35 +3,87 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"os"
 	"os/signal"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
+	"github.com/nats-io/nkeys"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/record"
 
 	"github.com/portswigger-tim/nats-k8s-oidc-callout/internal/auth"
 	"github.com/portswigger-tim/nats-k8s-oidc-callout/internal/config"
+	"github.com/portswigger-tim/nats-k8s-oidc-callout/internal/faults"
+	"github.com/portswigger-tim/nats-k8s-oidc-callout/internal/forwardauth"
+	"github.com/portswigger-tim/nats-k8s-oidc-callout/internal/grpcapi"
 	"github.com/portswigger-tim/nats-k8s-oidc-callout/internal/httpserver"
+	"github.com/portswigger-tim/nats-k8s-oidc-callout/internal/issuance"
 	"github.com/portswigger-tim/nats-k8s-oidc-callout/internal/jwt"
 	"github.com/portswigger-tim/nats-k8s-oidc-callout/internal/k8s"
+	"github.com/portswigger-tim/nats-k8s-oidc-callout/internal/lastauth"
 	"github.com/portswigger-tim/nats-k8s-oidc-callout/internal/logging"
 	"github.com/portswigger-tim/nats-k8s-oidc-callout/internal/nats"
+	"github.com/portswigger-tim/nats-k8s-oidc-callout/internal/notify"
+	"github.com/portswigger-tim/nats-k8s-oidc-callout/internal/permfeed"
+	"github.com/portswigger-tim/nats-k8s-oidc-callout/internal/permkv"
+	"github.com/portswigger-tim/nats-k8s-oidc-callout/internal/shadow"
+	"github.com/portswigger-tim/nats-k8s-oidc-callout/internal/standalone"
+	"github.com/portswigger-tim/nats-k8s-oidc-callout/internal/watchdog"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+	"golang.org/x/time/rate"
 )
 
+// Build information, set with -ldflags by the Makefile
+var (
+	version   = "dev"
+	commit    = "unknown"
+	buildDate = "unknown"
+)
+
+// loadSampleInterval is how often the request rate and worker utilization are sampled
+const loadSampleInterval = 10 * time.Second
+
 func main() {
-	if err := run(); err != nil {
+	var err error
+	switch subcommand(os.Args) {
+	case "bootstrap-dev":
+		err = runBootstrapDev(os.Args[2:], os.Stdout)
+	case "preflight":
+		err = runPreflight(os.Args[2:], os.Stdout)
+	case "print-nats-config":
+		err = runPrintNATSConfig(os.Args[2:], os.Stdout)
+	default:
+		err = run(os.Args[1:])
+	}
+
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "error: %v\n", err)
 		os.Exit(1)
 	}
 }
 
+// subcommand returns the subcommand name from the command line arguments,
+// or an empty string when the service should run normally.
+func subcommand(args []string) string {
+	if len(args) < 2 || strings.HasPrefix(args[1], "-") {
+		return ""
+	}
+	return args[1]
+}
+
 // initJWTValidator initializes the JWT validator from either file or URL.
 func initJWTValidator(cfg *config.Config, logger *zap.Logger) (*jwt.Validator, error) {
 	if cfg.JWKSPath != "" {
@@ -44,25 +96,82 @@ func initJWTValidator(cfg *config.Config, logger *zap.Logger) (*jwt.Validator, e
 	}
 
 	logger.Info("initializing JWT validator from URL", zap.String("jwks_url", cfg.JWKSUrl))
-	validator, err := jwt.NewValidatorFromURL(cfg.JWKSUrl, cfg.JWTIssuer, cfg.JWTAudience)
+	client, err := jwt.NewHTTPClient(jwt.HTTPClientOptions{
+		CAFile:        cfg.JWKSCAFile,
+		ProxyURL:      cfg.JWKSProxyURL,
+		MinTLSVersion: cfg.JWKSMinTLS,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create JWKS HTTP client: %w", err)
+	}
+	validator, err := jwt.NewValidatorFromURLWithOptions(cfg.JWKSUrl, cfg.JWTIssuer, cfg.JWTAudience, jwt.FetchOptions{
+		Client:     client,
+		Timeout:    cfg.JWKSTimeout,
+		Retries:    cfg.JWKSRetries,
+		Backoff:    cfg.JWKSBackoff,
+		Background: cfg.JWKSStartupGrace > 0,
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to create JWT validator from URL: %w", err)
 	}
+	if !validator.Loaded() {
+		_, message := validator.Stale(0)
+		logger.Warn("starting without a JWKS, retrying in the background",
+			zap.String("reason", message),
+			zap.Duration("startup_grace", cfg.JWKSStartupGrace))
+	}
 	return validator, nil
 }
 
 // initK8sClient initializes the Kubernetes client with config, clientset, and informer factory.
-func initK8sClient(cfg *config.Config, logger *zap.Logger) (*k8s.Client, informers.SharedInformerFactory, chan struct{}, error) {
+func initK8sClient(cfg *config.Config, logger *zap.Logger) (*k8s.Client, informers.SharedInformerFactory, kubernetes.Interface, error) {
 	logger.Info("initializing Kubernetes client")
 
-	// Get Kubernetes config
+	// Fake mode serves ServiceAccounts from an in-memory API seeded from a file
+	if cfg.FakeMode {
+		clientset, err := newFakeClientset(cfg, logger)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		informerFactory := informers.NewSharedInformerFactory(clientset, 0)
+		k8sClient, err := newK8sClient(cfg, informerFactory, logger)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		return k8sClient, informerFactory, clientset, nil
+	}
+
+	clientset, err := newClientset(cfg, logger)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	// Create informer factory, watching a single namespace when K8S_NAMESPACE is set
+	var factoryOpts []informers.SharedInformerOption
+	if cfg.K8sNamespace != "" {
+		logger.Info("watching ServiceAccounts in a single namespace", zap.String("namespace", cfg.K8sNamespace))
+		factoryOpts = append(factoryOpts, informers.WithNamespace(cfg.K8sNamespace))
+	}
+	informerFactory := informers.NewSharedInformerFactoryWithOptions(clientset, 0, factoryOpts...)
+
+	// Create K8s client with ServiceAccount cache
+	k8sClient, err := newK8sClient(cfg, informerFactory, logger)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return k8sClient, informerFactory, clientset, nil
+}
+
+// newClientset creates the Kubernetes clientset from the in-cluster config or KUBECONFIG.
+func newClientset(cfg *config.Config, logger *zap.Logger) (kubernetes.Interface, error) {
 	var k8sConfig *rest.Config
 	var err error
 	if cfg.K8sInCluster {
 		logger.Info("using in-cluster Kubernetes config")
 		k8sConfig, err = rest.InClusterConfig()
 		if err != nil {
-			return nil, nil, nil, fmt.Errorf("failed to get in-cluster config: %w", err)
+			return nil, fmt.Errorf("failed to get in-cluster config: %w", err)
 		}
 	} else {
 		logger.Info("using out-of-cluster Kubernetes config from KUBECONFIG")
@@ -71,44 +180,383 @@ func initK8sClient(cfg *config.Config, logger *zap.Logger) (*k8s.Client, informe
 		kubeConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, configOverrides)
 		k8sConfig, err = kubeConfig.ClientConfig()
 		if err != nil {
-			return nil, nil, nil, fmt.Errorf("failed to load kubeconfig: %w", err)
+			return nil, fmt.Errorf("failed to load kubeconfig: %w", err)
 		}
 	}
 
-	// Create clientset
 	clientset, err := kubernetes.NewForConfig(k8sConfig)
 	if err != nil {
-		return nil, nil, nil, fmt.Errorf("failed to create Kubernetes clientset: %w", err)
+		return nil, fmt.Errorf("failed to create Kubernetes clientset: %w", err)
 	}
+	return clientset, nil
+}
 
-	// Create informer factory
-	informerFactory := informers.NewSharedInformerFactory(clientset, 0)
-
-	// Create K8s client with ServiceAccount cache
+// newK8sClient creates the ServiceAccount client and applies the cache settings.
+func newK8sClient(cfg *config.Config, informerFactory informers.SharedInformerFactory, logger *zap.Logger) (*k8s.Client, error) {
 	k8sClient := k8s.NewClient(informerFactory, logger)
+	k8sClient.SetMissRetry(cfg.CacheMissRetry)
+	k8sClient.SetDefaultClass(k8s.Class(cfg.DefaultSAClass))
+	k8sClient.SetRequireOptIn(cfg.RequireOptIn)
+	k8sClient.SetSharedInbox(cfg.SharedInbox)
+	k8sClient.SetLimits(k8s.Limits{
+		MaxAnnotationLength: cfg.SAMaxAnnotationLength,
+		MaxSubjects:         cfg.SAMaxSubjects,
+	})
 
-	// Create stop channel for lifecycle management
-	stopCh := make(chan struct{})
+	if cfg.PermissionPolicyFile != "" {
+		policy, err := k8s.LoadPolicyFile(cfg.PermissionPolicyFile)
+		if err != nil {
+			return nil, fmt.Errorf("invalid PERMISSION_POLICY_FILE: %w", err)
+		}
+		if policy.UsesNodeSelector() && !cfg.WatchNodes {
+			return nil, fmt.Errorf("invalid PERMISSION_POLICY_FILE: profiles with nodeSelector require WATCH_NODES")
+		}
+		k8sClient.SetPolicy(policy)
+		logger.Info("loaded permission policy",
+			zap.String("file", cfg.PermissionPolicyFile),
+			zap.Int("profiles", len(policy.Profiles)))
+		for layer, subjects := range policy.InvalidSubjects() {
+			logger.Warn("dropped malformed subjects from the permission policy",
+				zap.String("layer", layer),
+				zap.Strings("subjects", subjects))
+		}
+	}
+
+	if cfg.SubjectPrefix != "" {
+		if err := k8sClient.SetSubjectPrefix(cfg.SubjectPrefix); err != nil {
+			return nil, fmt.Errorf("invalid SUBJECT_PREFIX: %w", err)
+		}
+		logger.Info("prefixing annotation subjects", zap.String("prefix", cfg.SubjectPrefix))
+	}
+
+	if cfg.WatchNamespaces {
+		if err := k8sClient.WatchNamespaces(informerFactory); err != nil {
+			return nil, err
+		}
+		logger.Info("watching namespaces for the nats.io/enabled annotation")
+	}
+
+	if cfg.WatchNodes {
+		if err := k8sClient.WatchNodes(informerFactory); err != nil {
+			return nil, err
+		}
+		logger.Info("watching nodes for node selectors", zap.Any("auth_node_selector", cfg.AuthNodeSelector))
+	}
 
-	return k8sClient, informerFactory, stopCh, nil
+	if cfg.WatchWorkloads {
+		if err := k8sClient.WatchWorkloads(informerFactory); err != nil {
+			return nil, err
+		}
+		logger.Info("watching pods and ReplicaSets for the {workload} of tokens")
+	}
+
+	if len(cfg.SAAnnotationAliases) > 0 {
+		if err := k8sClient.SetAnnotationAliases(cfg.SAAnnotationAliases); err != nil {
+			return nil, fmt.Errorf("invalid SA_ANNOTATION_ALIASES: %w", err)
+		}
+		logger.Info("accepting deprecated ServiceAccount annotation aliases",
+			zap.Any("aliases", cfg.SAAnnotationAliases))
+	}
+
+	if err := httpserver.RegisterNamespaceGrants(k8sClient.NamespaceGrants); err != nil {
+		return nil, fmt.Errorf("failed to register namespace grant metrics: %w", err)
+	}
+	return k8sClient, nil
 }
 
 // startK8sInformers starts the informer factory and waits for caches to sync.
-func startK8sInformers(factory informers.SharedInformerFactory, stopCh chan struct{}, logger *zap.Logger) {
+// A zero timeout waits indefinitely.
+func startK8sInformers(factory informers.SharedInformerFactory, stopCh chan struct{}, timeout time.Duration, logger *zap.Logger) error {
 	factory.Start(stopCh)
-	logger.Info("waiting for Kubernetes caches to sync")
-	factory.WaitForCacheSync(stopCh)
+
+	// Stop waiting when the timeout expires or the informers are stopped
+	waitCh := make(chan struct{})
+	go func() {
+		defer close(waitCh)
+		if timeout <= 0 {
+			<-stopCh
+			return
+		}
+		select {
+		case <-stopCh:
+		case <-time.After(timeout):
+		}
+	}()
+
+	logger.Info("waiting for Kubernetes caches to sync", zap.Duration("timeout", timeout))
+	for informerType, synced := range factory.WaitForCacheSync(waitCh) {
+		if !synced {
+			return fmt.Errorf("timed out after %s waiting for Kubernetes %v cache to sync (CACHE_SYNC_TIMEOUT)",
+				timeout, informerType)
+		}
+	}
 	logger.Info("Kubernetes caches synced")
+	return nil
+}
+
+// initPermissionsProvider initializes the permissions provider: a static file in standalone
+// mode, otherwise the Kubernetes ServiceAccount cache (waiting for the informer to sync).
+// The returned function stops the provider. With LAST_AUTH_ANNOTATION, the ServiceAccounts
+// recorded by tracker are annotated until it is stopped. The leader elector is nil unless
+// LEADER_ELECTION is enabled.
+func initPermissionsProvider(cfg *config.Config, jwtValidator *jwt.Validator, httpSrv *httpserver.Server, tracker *lastauth.Tracker, logger *zap.Logger) (auth.PermissionsProvider, *k8s.LeaderElector, func(), error) {
+	if cfg.Standalone() {
+		logger.Info("running in standalone mode without Kubernetes",
+			zap.String("permissions_file", cfg.PermissionsFile))
+		provider, err := standalone.LoadFile(cfg.PermissionsFile, logger)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to load permissions file: %w", err)
+		}
+		logger.Info("loaded static permissions", zap.Int("identities", provider.Len()))
+		httpSrv.AddStats("permissions", func() any {
+			return map[string]any{"source": "file", "identities": provider.Len()}
+		})
+
+		// Accept tokens from non-Kubernetes OIDC issuers, identified by subject
+		jwtValidator.SetRequireK8sClaims(false)
+		return provider, nil, func() {}, nil
+	}
+
+	// Initialize Kubernetes client
+	k8sClient, informerFactory, clientset, err := initK8sClient(cfg, logger)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	httpSrv.AddStats("permissions", func() any {
+		return map[string]any{
+			"source": "kubernetes", "serviceAccounts": k8sClient.CacheSize(), "synced": k8sClient.HasSynced(),
+			"storage": k8sClient.StorageStats(),
+		}
+	})
+	if cfg.PermissionPolicyFile != "" {
+		httpSrv.AddStats("permissionPolicy", func() any { return k8sClient.PolicyStatus() })
+	}
+
+	// Create stop channel and context for lifecycle management
+	stopCh := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	// Report annotation problems as events on the offending ServiceAccount
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: clientset.CoreV1().Events("")})
+	k8sClient.SetEventRecorder(broadcaster.NewRecorder(scheme.Scheme,
+		corev1.EventSource{Component: "nats-k8s-oidc-callout"}))
+
+	// Writers to the Kubernetes API; stopping waits for their last write, so a rolling
+	// restart neither loses the final batch of annotations nor leaves a stale health Lease
+	var writers sync.WaitGroup
+
+	// Run singleton subsystems on one replica only, while every replica authorizes
+	var elector *k8s.LeaderElector
+	if cfg.LeaderElection {
+		elector = k8s.NewLeaderElector(clientset, cfg.PodNamespace, cfg.LeaderElectionLease, cfg.PodName,
+			cfg.LeaderElectionLeaseDuration, logger.Named("leader-election"))
+		httpSrv.AddStats("leaderElection", func() any { return elector.Status() })
+	}
+
+	// Annotate ServiceAccounts with their last authentication. Under leader election, every
+	// replica relays its authentications and the leader annotates them all.
+	if cfg.LastAuthAnnotation {
+		annotator := k8s.NewLastAuthAnnotator(clientset)
+		if elector != nil {
+			relay := k8s.NewLastAuthRelay(clientset, cfg.PodNamespace, cfg.PodName, cfg.PodUID)
+			writers.Go(func() { tracker.RunRelay(ctx, relay, cfg.LastAuthAnnotationInterval) })
+			elector.Add("last-auth-annotation", func(ctx context.Context) {
+				tracker.Run(ctx, annotator, relay, cfg.LastAuthAnnotationInterval)
+			})
+		} else {
+			writers.Go(func() { tracker.Run(ctx, annotator, nil, cfg.LastAuthAnnotationInterval) })
+		}
+	}
+	// Write the computed permissions back onto ServiceAccounts, for checking with kubectl
+	if cfg.PermissionsAnnotation {
+		annotator := k8s.NewPermissionsAnnotator(k8sClient, clientset, logger.Named("permissions-annotation"))
+		if elector != nil {
+			elector.Add("permissions-annotation", func(ctx context.Context) {
+				annotator.Run(ctx, cfg.PermissionsAnnotationInterval)
+			})
+		} else {
+			writers.Go(func() { annotator.Run(ctx, cfg.PermissionsAnnotationInterval) })
+		}
+	}
+	if elector != nil {
+		writers.Go(func() { elector.Run(ctx) })
+	}
+
+	// Publish the instance's health on a Lease for operators without access to the pod network
+	if cfg.HealthLease {
+		lease := k8s.NewHealthLease(clientset, cfg.PodNamespace, cfg.PodName, cfg.PodUID, cfg.HealthLeaseInterval,
+			func() k8s.HealthReport {
+				status := httpSrv.Status()
+				return k8s.HealthReport{Status: status.Status, Version: status.Version, Health: status, Stats: httpSrv.Stats()}
+			}, logger.Named("health-lease"))
+		writers.Go(func() { lease.Run(ctx) })
+		logger.Info("publishing health on a Lease",
+			zap.String("lease", cfg.PodNamespace+"/"+cfg.PodName),
+			zap.Duration("interval", cfg.HealthLeaseInterval))
+	}
+
+	stop := func() {
+		cancel()
+		close(stopCh)
+		broadcaster.Shutdown()
+		writers.Wait()
+	}
+
+	// Track Kubernetes API reachability; the cache keeps serving while it is unreachable
+	if !cfg.FakeMode {
+		monitor := k8s.NewConnectionMonitor(cfg.K8sDegradedAfter, logger)
+		if err := k8sClient.OnWatchError(monitor.RecordError); err != nil {
+			stop()
+			return nil, nil, nil, fmt.Errorf("failed to register watch error handler: %w", err)
+		}
+		go monitor.Run(ctx, cfg.K8sProbeInterval, func(ctx context.Context) error {
+			return clientset.Discovery().RESTClient().Get().AbsPath("/version").Do(ctx).Error()
+		})
+		httpSrv.AddReadinessCheck("kubernetes", func() httpserver.CheckResult {
+			if degraded, message := monitor.Degraded(); degraded {
+				return httpserver.CheckResult{Status: httpserver.StatusDegraded, Message: message}
+			}
+			return httpserver.CheckResult{Status: httpserver.StatusOK}
+		})
+	}
+
+	// Restore the last cache snapshot so permissions can be served even if the API
+	// server is unavailable while restarting
+	restored := 0
+	if cfg.CacheSnapshotPath != "" {
+		restored, err = k8sClient.RestoreSnapshot(cfg.CacheSnapshotPath, cfg.CacheSnapshotMaxAge)
+		if err != nil {
+			logger.Warn("failed to restore cache snapshot; waiting for Kubernetes", zap.Error(err))
+		}
+	}
+
+	// Start informers and wait for cache sync; the callout subscription is not started
+	// until this succeeds (or a snapshot was restored), so a slow API server cannot cause
+	// a window of mass denials, unless CACHE_SYNC_FAILURE_POLICY=degraded
+	if err := startK8sInformers(informerFactory, stopCh, cfg.CacheSyncTimeout, logger); err != nil {
+		switch {
+		case restored > 0:
+			logger.Warn("serving ServiceAccount permissions from cache snapshot until Kubernetes is reachable",
+				zap.Error(err))
+		case cfg.CacheSyncPolicy == "degraded":
+			logger.Warn("starting before the ServiceAccount cache synced; authorizations are denied until it does",
+				zap.Error(err))
+		default:
+			stop()
+			return nil, nil, nil, err
+		}
+	}
+
+	// Degrade readiness until the informer syncs when serving from a snapshot or starting
+	// without the cache
+	if restored > 0 || !k8sClient.HasSynced() {
+		message := "ServiceAccount cache not yet synced, denying authorizations"
+		if restored > 0 {
+			message = "serving permissions from cache snapshot, Kubernetes not yet synced"
+		}
+		httpSrv.AddReadinessCheck("serviceaccount-cache", func() httpserver.CheckResult {
+			if !k8sClient.HasSynced() {
+				return httpserver.CheckResult{Status: httpserver.StatusDegraded, Message: message}
+			}
+			return httpserver.CheckResult{Status: httpserver.StatusOK}
+		})
+	}
+
+	if cfg.CacheSnapshotPath != "" {
+		startCacheSnapshots(ctx, cfg, k8sClient, stopCh, restored > 0)
+	}
+
+	return k8sClient, elector, stop, nil
+}
+
+// startCacheSnapshots periodically saves the cache snapshot. When a snapshot was restored,
+// entries deleted from Kubernetes while the service was down are pruned once the informer
+// syncs.
+func startCacheSnapshots(ctx context.Context, cfg *config.Config, k8sClient *k8s.Client, stopCh chan struct{}, restored bool) {
+	go func() {
+		if restored {
+			if !cache.WaitForCacheSync(stopCh, k8sClient.HasSynced) {
+				return
+			}
+			k8sClient.PruneUnlisted()
+		}
+		k8sClient.RunSnapshots(ctx, cfg.CacheSnapshotPath, cfg.CacheSnapshotInterval)
+	}()
+}
+
+// loadSigningKey loads the account signing key used to sign authorization responses.
+// With the embedded NATS server and no key file configured, an ephemeral key is generated.
+func loadSigningKey(cfg *config.Config, logger *zap.Logger) (nkeys.KeyPair, error) {
+	if cfg.NatsSigningKeyFile == "" && cfg.EmbeddedNATS {
+		logger.Info("generating ephemeral account signing key for embedded NATS server")
+		signingKey, err := nkeys.CreateAccount()
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate signing key: %w", err)
+		}
+		return signingKey, nil
+	}
+
+	// Load signing key from separate file
+	logger.Info("loading account signing key", zap.String("signing_key_file", cfg.NatsSigningKeyFile))
+	signingKey, err := nats.LoadSigningKeyFromFile(cfg.NatsSigningKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load signing key from file %s: %w",
+			cfg.NatsSigningKeyFile, err)
+	}
+	return signingKey, nil
+}
+
+// initAuthHandler creates the authorization handler, wrapping its dependencies with
+// fault injection when any FAULT_* setting is enabled.
+func initAuthHandler(cfg *config.Config, jwtValidator auth.JWTValidator, permProvider auth.PermissionsProvider, logger *zap.Logger) nats.AuthHandler {
+	faultCfg := faults.Config{
+		AuthLatency:     cfg.FaultAuthLatency,
+		JWKSFailureRate: cfg.FaultJWKSFailureRate,
+		CacheMissRate:   cfg.FaultCacheMissRate,
+	}
+	newHandler := func(v auth.JWTValidator, p auth.PermissionsProvider) *auth.Handler {
+		handler := auth.NewHandler(v, p)
+		handler.SetJetStreamAPIProtection(cfg.ProtectJetStreamAPI)
+		handler.SetDeclaredInboxes(cfg.DeclaredInbox)
+		handler.SetRequireTLS(cfg.RequireTLS)
+		handler.SetAllowedConnectionTypes(cfg.AllowedConnectionTypes)
+		handler.SetClusterName(cfg.ClusterName)
+		if !cfg.Standalone() {
+			handler.SetNamespace(cfg.K8sNamespace)
+			handler.SetDeniedNamespaces(cfg.DeniedNamespaces)
+			handler.SetPodInboxes(cfg.PodPrivateInbox)
+			handler.SetTokenInboxes(cfg.TokenPrivateInbox)
+			handler.SetAllowBearer(cfg.AllowBearerUsers)
+			handler.SetNodeSelector(cfg.AuthNodeSelector)
+		}
+		return handler
+	}
+
+	if !faultCfg.Enabled() {
+		return newHandler(jwtValidator, permProvider)
+	}
+
+	logger.Warn("FAULT INJECTION ENABLED: authorization requests will be delayed or fail deliberately",
+		zap.Duration("auth_latency", faultCfg.AuthLatency),
+		zap.Float64("jwks_failure_rate", faultCfg.JWKSFailureRate),
+		zap.Float64("cache_miss_rate", faultCfg.CacheMissRate))
+
+	injector := faults.New(faultCfg)
+	handler := newHandler(injector.WrapValidator(jwtValidator), injector.WrapPermissions(permProvider))
+	return injector.WrapHandler(handler)
 }
 
 // initNATSClient initializes the NATS client with signing key configuration.
-func initNATSClient(cfg *config.Config, authHandler *auth.Handler, logger *zap.Logger) (*nats.Client, error) {
+func initNATSClient(cfg *config.Config, authHandler nats.AuthHandler, signingKey nkeys.KeyPair, httpSrv *httpserver.Server, logger *zap.Logger) (*nats.Client, error) {
 	// Determine auth mode for logging
 	authMode := "URL-embedded"
 	if cfg.NatsUserCredsFile != "" {
 		authMode = "user-credentials"
 	} else if cfg.NatsToken != "" {
 		authMode = "token"
+	} else if cfg.NatsUsername != "" {
+		authMode = "username-password"
 	}
 
 	logger.Info("initializing NATS client",
@@ -124,26 +572,143 @@ func initNATSClient(cfg *config.Config, authHandler *auth.Handler, logger *zap.L
 		return nil, fmt.Errorf("failed to create NATS client: %w", err)
 	}
 
-	// Load signing key from separate file
-	logger.Info("loading account signing key", zap.String("signing_key_file", cfg.NatsSigningKeyFile))
-	signingKey, err := nats.LoadSigningKeyFromFile(cfg.NatsSigningKeyFile)
+	natsClient.SetUserPassword(cfg.NatsUsername, cfg.NatsPassword)
+
+	if err := configureNATSClient(cfg, natsClient, signingKey, cfg.NatsNewSigningKeyFile, cfg.NatsScopedKeysDir, cfg.NatsIssuerAccount, logger); err != nil {
+		return nil, err
+	}
+	if cfg.StatusSubject != "" {
+		natsClient.SetStatusEndpoint(cfg.StatusSubject, func() any { return httpSrv.Status() })
+	}
+
+	return natsClient, nil
+}
+
+// initIssuerClients initializes a NATS client for each additional callout issuer account in
+// NATS_ISSUERS_FILE. They share the primary client's settings and authorization handler.
+func initIssuerClients(cfg *config.Config, authHandler nats.AuthHandler, logger *zap.Logger) ([]*nats.Client, error) {
+	if cfg.NatsIssuersFile == "" {
+		return nil, nil
+	}
+
+	issuers, err := nats.LoadIssuersFile(cfg.NatsIssuersFile)
 	if err != nil {
-		return nil, fmt.Errorf("failed to load signing key from file %s: %w",
-			cfg.NatsSigningKeyFile, err)
+		return nil, err
 	}
+
+	clients := make([]*nats.Client, 0, len(issuers))
+	for _, issuer := range issuers {
+		if issuer.Account == cfg.NatsAccount {
+			return nil, fmt.Errorf("issuer %s: account is already served by NATS_ACCOUNT", issuer.Account)
+		}
+
+		logger.Info("initializing NATS client for additional issuer",
+			zap.String("account", issuer.Account),
+			zap.String("user_creds_file", issuer.UserCredsFile),
+			zap.String("signing_key_file", issuer.SigningKeyFile))
+
+		signingKey, err := nats.LoadSigningKeyFromFile(issuer.SigningKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("issuer %s: failed to load signing key from file %s: %w",
+				issuer.Account, issuer.SigningKeyFile, err)
+		}
+		issuerLogger := logger.With(zap.String("account", issuer.Account))
+		client, err := nats.NewClient(cfg.NatsURL, issuer.UserCredsFile, issuer.Token, issuer.Account, authHandler, issuerLogger)
+		if err != nil {
+			return nil, fmt.Errorf("issuer %s: failed to create NATS client: %w", issuer.Account, err)
+		}
+		if issuer.NewSigningKeyFile != "" && cfg.NatsAccountResolverURL == "" {
+			return nil, fmt.Errorf("issuer %s: newSigningKeyFile requires NATS_ACCOUNT_RESOLVER_URL", issuer.Account)
+		}
+		if err := configureNATSClient(cfg, client, signingKey, issuer.NewSigningKeyFile, issuer.ScopedKeysDir, issuer.IssuerAccount, issuerLogger); err != nil {
+			return nil, fmt.Errorf("issuer %s: %w", issuer.Account, err)
+		}
+		clients = append(clients, client)
+	}
+	return clients, nil
+}
+
+// configureNATSClient applies the signing keys and the user JWT settings shared by every issuer.
+func configureNATSClient(cfg *config.Config, natsClient *nats.Client, signingKey nkeys.KeyPair, newSigningKeyFile, scopedKeysDir, issuerAccount string, logger *zap.Logger) error {
 	natsClient.SetSigningKey(signingKey)
+	if newSigningKeyFile != "" {
+		newKey, err := nats.LoadSigningKeyFromFile(newSigningKeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load new signing key from file %s: %w", newSigningKeyFile, err)
+		}
+		natsClient.SetNewSigningKey(newKey)
+		public, _ := newKey.PublicKey()
+		logger.Info("loaded new signing key; signing with it once the issuer account lists it",
+			zap.String("new_signing_key_file", newSigningKeyFile),
+			zap.String("new_signing_key", public))
+	}
+	if scopedKeysDir != "" {
+		keys, err := nats.LoadScopedSigningKeys(scopedKeysDir)
+		if err != nil {
+			return fmt.Errorf("failed to load scoped signing keys: %w", err)
+		}
+		if err := natsClient.SetScopedSigningKeys(keys, issuerAccount); err != nil {
+			return fmt.Errorf("invalid issuer account: %w", err)
+		}
+		logger.Info("loaded scoped signing keys",
+			zap.String("dir", scopedKeysDir),
+			zap.Int("roles", len(keys)))
+	}
+	natsClient.SetIgnoreDiscoveredServers(cfg.NatsURLOnly)
+	natsClient.SetTokenExpiry(cfg.UserJWTTTL)
+	natsClient.SetRequestTimeout(cfg.AuthRequestTimeout)
+	natsClient.SetSlowThreshold(cfg.SlowAuthThreshold)
+	natsClient.SetLastAuthPerServiceAccount(cfg.LastAuthPerSA)
+	natsClient.SetResponderLimits(cfg.ResponderMaxMsgs, cfg.ResponderTTL)
+	natsClient.SetDefaultLimits(int64(cfg.UserMaxSubscriptions), int64(cfg.UserMaxPayload), int64(cfg.UserMaxData))
+	natsClient.SetDeniedSubjects(cfg.DeniedSubjects)
+	natsClient.SetRequireNkey(cfg.RequireNkeySignature)
+	if cfg.ErrorRateThreshold > 0 {
+		natsClient.SetErrorRateMonitor(nats.NewErrorRateMonitor(cfg.ErrorRateThreshold, cfg.ErrorRateWindow, cfg.ErrorRateMinRequests))
+	}
+	return nil
+}
 
-	return natsClient, nil
+// signingKeyRotationCheck reports the cutover of signing key rotations: degraded while an
+// issuer account JWT does not list its new signing key yet, so the old key still signs, and ok
+// once every rotating client signs with its new key.
+func signingKeyRotationCheck(natsClients []*nats.Client) httpserver.CheckResult {
+	var pending, done []string
+	for _, client := range natsClients {
+		rotation, ok := client.SigningKeyRotation()
+		if !ok {
+			continue
+		}
+		switch {
+		case !rotation.Checked:
+			pending = append(pending, fmt.Sprintf("account %s: issuer account JWT not fetched yet; signing with the old key", rotation.Account))
+		case !rotation.NewKeyTrusted:
+			pending = append(pending, fmt.Sprintf("account %s: issuer account does not list new signing key %s yet; signing with the old key", rotation.Account, rotation.NewKey))
+		case rotation.OldKeyTrusted:
+			done = append(done, fmt.Sprintf("account %s: signing with new key %s; old key %s can be removed from the account", rotation.Account, rotation.NewKey, rotation.OldKey))
+		default:
+			done = append(done, fmt.Sprintf("account %s: signing with new key %s; make it the signing key to finish", rotation.Account, rotation.NewKey))
+		}
+	}
+	if len(pending) > 0 {
+		return httpserver.CheckResult{Status: httpserver.StatusDegraded, Message: strings.Join(pending, "; ")}
+	}
+	return httpserver.CheckResult{Status: httpserver.StatusOK, Message: strings.Join(done, "; ")}
 }
 
-// waitForShutdown starts the HTTP server and waits for shutdown signal or server error.
-// Coordinates graceful shutdown of all services with timeout.
-func waitForShutdown(httpSrv *httpserver.Server, natsClient *nats.Client, logger *zap.Logger) error {
-	// Start HTTP server in a goroutine
-	serverErrors := make(chan error, 1)
+// waitForShutdown starts the HTTP server, and the gRPC server when set, and waits for shutdown
+// signal or server error. Coordinates graceful shutdown of all services with timeout.
+func waitForShutdown(httpSrv *httpserver.Server, grpcSrv *grpcapi.Server, natsClients []*nats.Client, logger *zap.Logger) error {
+	// Start the servers in goroutines
+	serverErrors := make(chan error, 2)
 	go func() {
 		serverErrors <- httpSrv.Start()
 	}()
+	if grpcSrv != nil {
+		go func() {
+			serverErrors <- grpcSrv.Start()
+		}()
+	}
 
 	logger.Info("all services started successfully")
 
@@ -162,9 +727,17 @@ func waitForShutdown(httpSrv *httpserver.Server, natsClient *nats.Client, logger
 		defer cancel()
 
 		// Shutdown in reverse order (NATS first, then HTTP)
-		logger.Info("shutting down NATS client")
-		if err := natsClient.Shutdown(ctx); err != nil {
-			logger.Error("failed to shutdown NATS client", zap.Error(err))
+		logger.Info("shutting down NATS clients", zap.Int("count", len(natsClients)))
+		for _, natsClient := range natsClients {
+			if err := natsClient.Shutdown(ctx); err != nil {
+				logger.Error("failed to shutdown NATS client", zap.Error(err))
+			}
+		}
+
+		if grpcSrv != nil {
+			if err := grpcSrv.Shutdown(ctx); err != nil {
+				logger.Error("failed to shutdown gRPC server gracefully", zap.Error(err))
+			}
 		}
 
 		logger.Info("shutting down HTTP server")
@@ -179,7 +752,20 @@ func waitForShutdown(httpSrv *httpserver.Server, natsClient *nats.Client, logger
 	return nil
 }
 
-func run() error {
+func run(args []string) error {
+	// Parse developer flags; each is a shorthand for its environment variable
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	embeddedNATS := fs.Bool("embedded-nats", false,
+		"start an in-process NATS server with auth callout configured (development only, same as EMBEDDED_NATS=true)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *embeddedNATS {
+		if err := os.Setenv("EMBEDDED_NATS", "true"); err != nil {
+			return fmt.Errorf("failed to set EMBEDDED_NATS: %w", err)
+		}
+	}
+
 	// Load configuration
 	cfg, err := config.Load()
 	if err != nil {
@@ -187,7 +773,7 @@ func run() error {
 	}
 
 	// Initialize logger
-	logger, err := initLogger(cfg.LogLevel)
+	logger, err := initLogger(cfg.LogLevel, cfg)
 	if err != nil {
 		return fmt.Errorf("failed to initialize logger: %w", err)
 	}
@@ -198,55 +784,483 @@ func run() error {
 		}
 	}()
 
+	resource := map[string]string{"service.name": "nats-k8s-oidc-callout", "service.version": version}
+	for key, value := range cfg.OTelResourceAttributes {
+		resource[key] = value
+	}
+
+	// Ship logs and audit records to an OpenTelemetry collector as well as stdout. Export
+	// failures are reported on stdout only.
+	var logOptions []zap.Option
+	if cfg.OTLPLogsEndpoint != "" {
+		exporter := logging.NewOTLPExporter(cfg.OTLPLogsEndpoint, resource, logger.Named("otlp"))
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			_ = exporter.Close(ctx)
+		}()
+		// The exported entries are those the stdout core enables
+		logOptions = append(logOptions, zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+			return zapcore.NewTee(core, exporter.Core(core))
+		}))
+		logger = logger.WithOptions(logOptions...)
+	}
+
+	// Export audit records to a SIEM, whatever the log level of stdout. Export failures are
+	// reported on stdout only.
+	if cfg.AuditExportSink != "" {
+		exporter, err := logging.NewAuditExporter(logging.AuditExportOptions{
+			Sink:           cfg.AuditExportSink,
+			Format:         cfg.AuditExportFormat,
+			Fields:         cfg.AuditExportFields,
+			Token:          cfg.AuditExportToken,
+			Version:        version,
+			Delivery:       cfg.AuditExportDelivery,
+			QueueSize:      cfg.AuditExportQueueSize,
+			FileMaxSize:    int64(cfg.AuditExportFileMaxSizeMB) << 20,
+			FileMaxBackups: cfg.AuditExportFileMaxBackups,
+			NATSCredsFile:  cfg.AuditExportNatsCredsFile,
+		}, logger.Named("audit-export"))
+		if err != nil {
+			return err
+		}
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			_ = exporter.Close(ctx)
+		}()
+		logger = logger.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+			return zapcore.NewTee(core, exporter.Core())
+		}))
+	}
+
+	// Drop the suppressed debug messages from stdout and the exports alike
+	if len(cfg.LogSuppressDebug) > 0 {
+		logger = logger.WithOptions(logging.SuppressDebug(cfg.LogSuppressDebug))
+	}
+
+	// The full effective configuration, with secrets masked, so a misconfiguration can be
+	// spotted from the first log line
 	logger.Info("starting nats-k8s-oidc-callout",
-		zap.String("port", fmt.Sprintf("%d", cfg.Port)),
-		zap.String("log_level", cfg.LogLevel),
-		zap.String("nats_url", logging.RedactNATSURL(cfg.NatsURL)),
-		zap.String("jwks_url", cfg.JWKSUrl),
+		zap.String("version", version),
+		zap.String("commit", commit),
+		zap.Any("config", logging.RedactConfig(cfg)),
 	)
 
+	// Initialize HTTP server; it starts serving once all services are running
+	httpSrv := httpserver.New(cfg.Port, logger)
+	httpSrv.SetBuildInfo(httpserver.BuildInfo{Version: version, Commit: commit, BuildDate: buildDate})
+
+	// Start the development mock OIDC issuer if requested
+	if cfg.DevOIDCAddr != "" {
+		devOIDC, err := startDevOIDC(cfg, logger)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = devOIDC.Shutdown(context.Background())
+		}()
+	}
+
 	// Initialize JWT validator
 	jwtValidator, err := initJWTValidator(cfg, logger)
 	if err != nil {
 		return err
 	}
 
-	// Initialize Kubernetes client
-	k8sClient, informerFactory, stopCh, err := initK8sClient(cfg, logger)
+	defer jwtValidator.Close()
+
+	// Stay not ready until the initial JWKS loads, or degrade readiness once the startup grace
+	// period passes without it. Degrade readiness when the JWKS has not been refreshed, as a
+	// rotated signing key would then cause every authorization to fail
+	if cfg.JWKSUrl != "" {
+		started := time.Now()
+		httpSrv.AddReadinessCheck("jwks", func() httpserver.CheckResult {
+			if !jwtValidator.Loaded() {
+				_, message := jwtValidator.Stale(0)
+				if time.Since(started) < cfg.JWKSStartupGrace {
+					return httpserver.CheckResult{Status: httpserver.StatusFailed, Message: message}
+				}
+				return httpserver.CheckResult{Status: httpserver.StatusDegraded, Message: message}
+			}
+			if cfg.JWKSStaleAfter > 0 {
+				if stale, message := jwtValidator.Stale(cfg.JWKSStaleAfter); stale {
+					return httpserver.CheckResult{Status: httpserver.StatusDegraded, Message: message}
+				}
+			}
+			return httpserver.CheckResult{Status: httpserver.StatusOK}
+		})
+	}
+
+	// Record the last authentication of each ServiceAccount, for finding unused NATS grants
+	tracker := lastauth.New(logger.Named("last-auth"))
+	httpSrv.Handle(lastauth.Path, tracker)
+
+	// Initialize permissions provider (Kubernetes or static file)
+	permProvider, elector, stopPermProvider, err := initPermissionsProvider(cfg, jwtValidator, httpSrv, tracker, logger)
 	if err != nil {
 		return err
 	}
-	defer close(stopCh)
+	defer stopPermProvider()
+	kubeClient, _ := permProvider.(*k8s.Client)
+	if cfg.CanaryPermissionsFile != "" {
+		canary, err := standalone.LoadFile(cfg.CanaryPermissionsFile, logger)
+		if err != nil {
+			return fmt.Errorf("failed to load canary permissions file: %w", err)
+		}
+		logger.Info("serving a share of identities from a canary permissions source",
+			zap.String("canary_permissions_file", cfg.CanaryPermissionsFile),
+			zap.Int("percent", cfg.CanaryPercent),
+			zap.Int("identities", canary.Len()))
+		permProvider = shadow.NewCanary(permProvider, canary, cfg.CanaryPercent)
+	}
+	if cfg.ShadowPermissionsFile != "" {
+		secondary, err := standalone.LoadFile(cfg.ShadowPermissionsFile, logger)
+		if err != nil {
+			return fmt.Errorf("failed to load shadow permissions file: %w", err)
+		}
+		logger.Info("comparing permissions with a shadow source",
+			zap.String("shadow_permissions_file", cfg.ShadowPermissionsFile),
+			zap.Int("identities", secondary.Len()))
+		permProvider = shadow.Wrap(permProvider, secondary, logger)
+	}
+
+	// Initialize authorization handler, with fault injection when configured
+	authHandler := initAuthHandler(cfg, jwtValidator, permProvider, logger)
+
+	// Post denials to a webhook, so platform teams hear about misconfigured workloads
+	if cfg.DenialWebhookURL != "" {
+		notifier, err := notify.New(cfg.DenialWebhookURL, cfg.DenialWebhookTemplate, cfg.DenialWebhookInterval,
+			cfg.DenialWebhookReasons, logger.Named("notify"))
+		if err != nil {
+			return err
+		}
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			_ = notifier.Close(ctx)
+		}()
+		authHandler = notifier.WrapHandler(authHandler)
+		logger.Info("notifying authorization denials to a webhook",
+			zap.Duration("interval", cfg.DenialWebhookInterval),
+			zap.Strings("reasons", cfg.DenialWebhookReasons))
+	}
 
-	// Start informers and wait for cache sync
-	startK8sInformers(informerFactory, stopCh, logger)
+	// Fail liveness when authorization requests get stuck, so Kubernetes restarts the pod
+	if cfg.AuthWatchdogThreshold > 0 {
+		wd := watchdog.New(cfg.AuthWatchdogThreshold)
+		authHandler = wd.WrapHandler(authHandler)
+		httpSrv.AddLivenessCheck("auth-watchdog", wd.Check)
 
-	// Initialize authorization handler
-	authHandler := auth.NewHandler(jwtValidator, k8sClient)
+		watchdogCtx, stopWatchdog := context.WithCancel(context.Background())
+		defer stopWatchdog()
+		go wd.Run(watchdogCtx, max(cfg.AuthWatchdogThreshold/2, time.Second))
+	}
 
-	// Initialize NATS client with signing key
-	natsClient, err := initNATSClient(cfg, authHandler, logger)
+	// Load the account signing key
+	signingKey, err := loadSigningKey(cfg, logger)
 	if err != nil {
 		return err
 	}
 
-	// Start NATS auth callout service
+	// Start the embedded NATS server if requested
+	if cfg.EmbeddedNATS {
+		embeddedServer, err := startEmbeddedNATS(cfg, signingKey, logger)
+		if err != nil {
+			return err
+		}
+		defer embeddedServer.Shutdown()
+	}
+
+	// Count the user JWTs issued per ServiceAccount, denying those over the issuance quota
+	issuances := issuance.New(cfg.IssuanceWindow, cfg.IssuanceQuota, logger.Named("issuance"))
+	httpSrv.Handle(issuance.Path, issuances)
+	issuanceCtx, stopIssuance := context.WithCancel(context.Background())
+	defer stopIssuance()
+	go issuances.Run(issuanceCtx)
+
+	// Initialize NATS client with signing key; only NATS authentications count as ServiceAccount use
+	natsHandler := tracker.WrapHandler(issuances.WrapHandler(authHandler))
+	natsClient, err := initNATSClient(cfg, natsHandler, signingKey, httpSrv, logger)
+	if err != nil {
+		return err
+	}
+	issuerClients, err := initIssuerClients(cfg, natsHandler, logger)
+	if err != nil {
+		return err
+	}
+	natsClients := append([]*nats.Client{natsClient}, issuerClients...)
+
+	// ServiceAccounts annotated nats.io/account can be placed in any issuer's account
+	for _, client := range natsClients {
+		client.SetAccountIssuers(natsClients)
+	}
+
+	// Authorization load across the clients, for autoscaling on the auth traffic itself
+	load := nats.NewLoad()
+	for _, client := range natsClients {
+		client.SetLoad(load)
+	}
+	loadCtx, stopLoad := context.WithCancel(context.Background())
+	defer stopLoad()
+	go load.Run(loadCtx, loadSampleInterval)
+	httpSrv.AddStats("load", func() any { return load.Stats() })
+
+	// One limiter across the clients, so the ceiling holds whichever account the requests reach
+	if cfg.AuthRateLimit > 0 {
+		limiter := rate.NewLimiter(rate.Limit(cfg.AuthRateLimit), cfg.AuthRateBurst)
+		for _, client := range natsClients {
+			client.SetRateLimiter(limiter)
+		}
+		httpserver.SetRateLimit(cfg.AuthRateLimit)
+		logger.Info("authorization rate limit enabled",
+			zap.Float64("requests_per_second", cfg.AuthRateLimit),
+			zap.Int("burst", cfg.AuthRateBurst))
+	}
+
+	// A corrupted key or a seed of the wrong type otherwise only shows as rejected responses
+	for _, client := range natsClients {
+		if err := client.CheckSigningKeys(); err != nil {
+			return fmt.Errorf("signing key self-verification failed: %w", err)
+		}
+	}
+	httpSrv.AddReadinessCheck("signing-keys", func() httpserver.CheckResult {
+		for _, client := range natsClients {
+			if err := client.SigningKeyError(); err != nil {
+				return httpserver.CheckResult{Status: httpserver.StatusFailed, Message: err.Error()}
+			}
+		}
+		return httpserver.CheckResult{Status: httpserver.StatusOK}
+	})
+	if cfg.SigningKeyCheckInterval > 0 {
+		keyCheckCtx, stopKeyCheck := context.WithCancel(context.Background())
+		defer stopKeyCheck()
+		for _, client := range natsClients {
+			go client.WatchSigningKeys(keyCheckCtx, cfg.SigningKeyCheckInterval)
+		}
+	}
+
+	// Keep the signing keys and user limits consistent with the account JWTs managed with nsc
+	if cfg.NatsAccountResolverURL != "" {
+		resolver := nats.NewAccountResolver(cfg.NatsAccountResolverURL)
+		resolverCtx, stopResolver := context.WithCancel(context.Background())
+		defer stopResolver()
+		for _, client := range natsClients {
+			if err := client.SyncAccount(resolverCtx, resolver); err != nil {
+				logger.Warn("failed to fetch account JWT from the resolver; using the configured signing keys and limits",
+					zap.Error(err))
+			}
+			go client.WatchAccount(resolverCtx, resolver, cfg.NatsAccountResolverInterval)
+		}
+		// The cutover signal of signing key rotations: degraded until the account trusts the new key
+		var rotating bool
+		for _, client := range natsClients {
+			_, ok := client.SigningKeyRotation()
+			rotating = rotating || ok
+		}
+		if rotating {
+			httpSrv.AddReadinessCheck("signing-key-rotation", func() httpserver.CheckResult {
+				return signingKeyRotationCheck(natsClients)
+			})
+			httpSrv.AddStats("signingKeyRotation", func() any {
+				var rotations []nats.SigningKeyRotation
+				for _, client := range natsClients {
+					if rotation, ok := client.SigningKeyRotation(); ok {
+						rotations = append(rotations, rotation)
+					}
+				}
+				return rotations
+			})
+		}
+		httpSrv.AddStats("accounts", func() any {
+			accounts := make([]nats.AccountStats, 0, len(natsClients))
+			for _, client := range natsClients {
+				if stats, ok := client.AccountStats(); ok {
+					accounts = append(accounts, stats)
+				}
+			}
+			return accounts
+		})
+		logger.Info("fetching issuer account JWTs from the account resolver",
+			zap.String("url", cfg.NatsAccountResolverURL),
+			zap.Duration("interval", cfg.NatsAccountResolverInterval))
+	}
+
+	// Connections per ServiceAccount from the system account's events, for MAX_CONNECTIONS_PER_SA
+	// and nats.io/max-connections
+	if cfg.NatsSystemCredsFile != "" {
+		registry := nats.NewConnectionRegistry(cfg.NatsURL, cfg.NatsSystemCredsFile, logger.Named("connections"))
+		accounts := make([]string, 0, len(natsClients))
+		for _, client := range natsClients {
+			accounts = append(accounts, client.Account())
+		}
+		if err := registry.Start(accounts); err != nil {
+			return err
+		}
+		defer registry.Close()
+		for _, client := range natsClients {
+			client.SetConnectionLimit(registry, cfg.MaxConnectionsPerSA)
+		}
+		httpSrv.AddStats("connections", func() any { return registry.Stats() })
+		logger.Info("tracking client connections per ServiceAccount",
+			zap.Int("max_connections_per_sa", cfg.MaxConnectionsPerSA))
+	}
+
+	// Traces are written at info level even when LOG_LEVEL hides other info messages
+	if len(cfg.AuthTrace) > 0 {
+		traceLogger, err := initLogger("info", cfg)
+		if err != nil {
+			return fmt.Errorf("failed to initialize trace logger: %w", err)
+		}
+		traceLogger = traceLogger.WithOptions(logOptions...).Named("trace")
+		for _, client := range natsClients {
+			client.SetTrace(traceLogger, cfg.AuthTrace)
+		}
+		logger.Warn("tracing matching authorizations in full; disable AUTH_TRACE once done",
+			zap.Strings("patterns", cfg.AuthTrace))
+	}
+
+	// Export a span per authorization, with the trace ID of its log lines and latency exemplar.
+	// Export failures are reported on stdout only.
+	if cfg.OTLPTracesEndpoint != "" {
+		spanExporter := logging.NewOTLPTraceExporter(cfg.OTLPTracesEndpoint, resource, logger.Named("otlp"))
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			_ = spanExporter.Close(ctx)
+		}()
+		for _, client := range natsClients {
+			client.SetSpanExporter(spanExporter)
+		}
+	}
+
+	// The access log is written at info level even when LOG_LEVEL hides other info messages
+	if cfg.AccessLog {
+		accessLogger, err := initLogger("info", cfg)
+		if err != nil {
+			return fmt.Errorf("failed to initialize access logger: %w", err)
+		}
+		accessLogger = accessLogger.WithOptions(logOptions...).Named("access")
+		for _, client := range natsClients {
+			client.SetAccessLogger(accessLogger)
+		}
+	}
+
+	// Start NATS auth callout services; permissions are synced and JWKS loaded at this point
 	ctx := context.Background()
-	if err := natsClient.Start(ctx); err != nil {
-		return fmt.Errorf("failed to start NATS client: %w", err)
+	for _, client := range natsClients {
+		if err := client.Start(ctx); err != nil {
+			return fmt.Errorf("failed to start NATS client: %w", err)
+		}
 	}
 
-	logger.Info("NATS auth callout service started successfully")
+	// Publish ServiceAccount permissions to a KV bucket, on the leader when elected
+	if cfg.PermissionsKVBucket != "" && kubeClient != nil {
+		openCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		publisher, err := permkv.New(openCtx, natsClient.Conn(), cfg.PermissionsKVBucket,
+			permissionsSource(kubeClient), logger.Named("permissions-kv"))
+		cancel()
+		if err != nil {
+			return err
+		}
+		if elector != nil {
+			elector.Add("permissions-kv", func(ctx context.Context) {
+				publisher.Run(ctx, cfg.PermissionsKVInterval)
+			})
+		} else {
+			publisherCtx, stopPublisher := context.WithCancel(ctx)
+			defer stopPublisher()
+			go publisher.Run(publisherCtx, cfg.PermissionsKVInterval)
+		}
+		logger.Info("publishing ServiceAccount permissions to a KV bucket",
+			zap.String("bucket", cfg.PermissionsKVBucket),
+			zap.Duration("interval", cfg.PermissionsKVInterval))
+	}
 
-	// Initialize HTTP server
-	httpSrv := httpserver.New(cfg.Port, logger)
+	// Publish permission change events for external systems following the policy
+	if cfg.PermissionsEventsSubject != "" && kubeClient != nil {
+		feed := permfeed.New(natsClient.Conn(), cfg.PermissionsEventsSubject, permissionsSource(kubeClient),
+			logger.Named("permissions-events"))
+		if elector != nil {
+			elector.Add("permissions-events", func(ctx context.Context) {
+				feed.Run(ctx, cfg.PermissionsEventsInterval)
+			})
+		} else {
+			feedCtx, stopFeed := context.WithCancel(ctx)
+			defer stopFeed()
+			go feed.Run(feedCtx, cfg.PermissionsEventsInterval)
+		}
+		logger.Info("publishing permission change events",
+			zap.String("subject", cfg.PermissionsEventsSubject+".>"),
+			zap.Duration("interval", cfg.PermissionsEventsInterval))
+	}
+
+	// A replica failing most authorizations leaves the queue group so healthy replicas serve them
+	if cfg.ErrorRateThreshold > 0 {
+		httpSrv.AddReadinessCheck("error-rate", func() httpserver.CheckResult {
+			for _, client := range natsClients {
+				if tripped, message := client.ErrorRateTripped(); tripped {
+					return httpserver.CheckResult{Status: httpserver.StatusFailed, Message: message}
+				}
+			}
+			return httpserver.CheckResult{Status: httpserver.StatusOK}
+		})
+		logger.Info("error rate readiness enabled",
+			zap.Float64("threshold", cfg.ErrorRateThreshold),
+			zap.Duration("window", cfg.ErrorRateWindow),
+			zap.Int("min_requests", cfg.ErrorRateMinRequests))
+	}
+
+	httpSrv.AddStats("nats", func() any {
+		connections := make([]nats.ConnectionStats, 0, len(natsClients))
+		for _, client := range natsClients {
+			connections = append(connections, client.ConnectionStats())
+		}
+		return connections
+	})
+
+	// A mismatched signing key or auth_callout block otherwise only shows as client timeouts
+	if cfg.AuthSelfTest {
+		selfTestCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		err := natsClient.SelfTest(selfTestCtx, cfg.AuthSelfTestCredsFile)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("auth callout self-test failed: %w", err)
+		}
+	}
+
+	logger.Info("NATS auth callout service started successfully", zap.Int("issuers", len(natsClients)))
+
+	// Serve the same authorization decisions to reverse proxies and over gRPC, for systems
+	// that do not speak NATS
+	if cfg.ForwardAuth {
+		httpSrv.Handle(forwardauth.Path, forwardauth.New(authHandler, cfg.DeniedSubjects, logger.Named("forward-auth")))
+	}
+	var grpcSrv *grpcapi.Server
+	if cfg.GRPCPort != 0 {
+		grpcSrv = grpcapi.New(cfg.GRPCPort, authHandler, cfg.DeniedSubjects, logger.Named("grpc"))
+	}
 
 	// Wait for shutdown signal and coordinate graceful shutdown
-	return waitForShutdown(httpSrv, natsClient, logger)
+	return waitForShutdown(httpSrv, grpcSrv, natsClients, logger)
 }
 
-// initLogger creates a zap logger based on the specified log level.
-func initLogger(level string) (*zap.Logger, error) {
+// permissionsSource returns the permissions computed for the cached ServiceAccounts, as
+// published by the permissions KV bucket and change events
+func permissionsSource(kubeClient *k8s.Client) func() (map[string]any, bool) {
+	return func() (map[string]any, bool) {
+		perms, synced := kubeClient.Permissions()
+		entries := make(map[string]any, len(perms))
+		for key, p := range perms {
+			entries[key] = p
+		}
+		return entries, synced
+	}
+}
+
+// initLogger creates a zap logger based on the specified log level, with the configured
+// format (JSON, or human-readable colored output for local development) and sampling.
+func initLogger(level string, cfg *config.Config) (*zap.Logger, error) {
 	// Parse log level
 	var zapLevel zapcore.Level
 	if err := zapLevel.UnmarshalText([]byte(level)); err != nil {
@@ -258,6 +1272,15 @@ func initLogger(level string) (*zap.Logger, error) {
 	loggerConfig.Level = zap.NewAtomicLevelAt(zapLevel)
 	loggerConfig.EncoderConfig.TimeKey = "timestamp"
 	loggerConfig.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+	if cfg.LogFormat == "console" {
+		loggerConfig.Encoding = "console"
+		loggerConfig.EncoderConfig.EncodeLevel = zapcore.CapitalColorLevelEncoder
+	}
+	loggerConfig.Sampling = nil
 
-	return loggerConfig.Build()
+	var options []zap.Option
+	if cfg.LogSamplingInitial > 0 {
+		options = append(options, logging.Sample(cfg.LogSamplingInitial, cfg.LogSamplingThereafter))
+	}
+	return loggerConfig.Build(options...)
 }
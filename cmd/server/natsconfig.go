@@ -0,0 +1,248 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	natsjwt "github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nkeys"
+
+	"github.com/portswigger-tim/nats-k8s-oidc-callout/internal/config"
+	"github.com/portswigger-tim/nats-k8s-oidc-callout/internal/nats"
+)
+
+// calloutIssuer is what the NATS side of one callout account is derived from
+type calloutIssuer struct {
+	account       string // NATS_ACCOUNT: account clients are assigned to
+	signingKey    string // public key of the signing key
+	credsFile     string // credentials the service connects with (operator mode)
+	scopedKeysDir string
+	issuerAccount string // account the scoped keys belong to, if not the signing key's
+}
+
+// runPrintNATSConfig implements the "print-nats-config" subcommand.
+//
+// It loads the configuration from the environment, as the service would, and prints the NATS
+// side of the setup derived from the configured keys: the authorization { auth_callout } block
+// of a server configured with a config file, or the nsc commands for operator mode. Public keys
+// copied by hand are the usual cause of a callout the server never uses or whose responses it
+// rejects.
+func runPrintNATSConfig(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("print-nats-config", flag.ContinueOnError)
+	mode := fs.String("mode", "auto", "server (nats-server.conf block), operator (nsc commands), or auto: operator when NATS_USER_CREDS_FILE is set")
+	authUser := fs.String("auth-user", "", "user the service connects as, for auth_users in server mode (default: NATS_USERNAME or the NATS_URL user)")
+	authAccount := fs.String("auth-account", "", "account of the auth users in server mode, when not the global account")
+	nscAccount := fs.String("nsc-account", "AUTH_SERVICE", "nsc name of the account of NATS_USER_CREDS_FILE, in operator mode")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	if cfg.EmbeddedNATS {
+		return errors.New("the embedded NATS server is configured by the service itself")
+	}
+
+	primary, err := loadCalloutIssuer(cfg.NatsAccount, cfg.NatsSigningKeyFile, cfg.NatsUserCredsFile, cfg.NatsScopedKeysDir, cfg.NatsIssuerAccount)
+	if err != nil {
+		return err
+	}
+	var issuers []calloutIssuer
+	if cfg.NatsIssuersFile != "" {
+		entries, err := nats.LoadIssuersFile(cfg.NatsIssuersFile)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			issuer, err := loadCalloutIssuer(entry.Account, entry.SigningKeyFile, entry.UserCredsFile, entry.ScopedKeysDir, entry.IssuerAccount)
+			if err != nil {
+				return fmt.Errorf("issuer %s: %w", entry.Account, err)
+			}
+			issuers = append(issuers, issuer)
+		}
+	}
+
+	if *mode == "auto" {
+		*mode = "server"
+		if cfg.NatsUserCredsFile != "" {
+			*mode = "operator"
+		}
+	}
+	switch *mode {
+	case "server":
+		// A server config holds a single auth_callout block
+		if len(issuers) > 0 {
+			return errors.New("NATS_ISSUERS_FILE requires operator mode, with one callout account per issuer")
+		}
+		user := *authUser
+		if user == "" {
+			user = cfg.NatsUsername
+		}
+		if u, err := url.Parse(cfg.NatsURL); user == "" && err == nil && u.User != nil {
+			user = u.User.Username()
+		}
+		if user == "" {
+			return errors.New("set -auth-user to the user the service connects as")
+		}
+		_, err = io.WriteString(stdout, renderAuthCalloutBlock(primary, user, *authAccount))
+		return err
+	case "operator":
+		out, err := renderNSCCommands(primary, *nscAccount)
+		if err != nil {
+			return err
+		}
+		for _, issuer := range issuers {
+			more, err := renderNSCCommands(issuer, "")
+			if err != nil {
+				return fmt.Errorf("issuer %s: %w", issuer.account, err)
+			}
+			out += "\n" + more
+		}
+		_, err = io.WriteString(stdout, out)
+		return err
+	default:
+		return fmt.Errorf("unknown mode %q: use server, operator or auto", *mode)
+	}
+}
+
+// loadCalloutIssuer reads the public key of a signing key
+func loadCalloutIssuer(account, signingKeyFile, credsFile, scopedKeysDir, issuerAccount string) (calloutIssuer, error) {
+	signingKey, err := nats.LoadSigningKeyFromFile(signingKeyFile)
+	if err != nil {
+		return calloutIssuer{}, fmt.Errorf("failed to load signing key from file %s: %w", signingKeyFile, err)
+	}
+	public, err := signingKey.PublicKey()
+	if err != nil {
+		return calloutIssuer{}, fmt.Errorf("failed to get signing key public key: %w", err)
+	}
+	return calloutIssuer{
+		account:       account,
+		signingKey:    public,
+		credsFile:     credsFile,
+		scopedKeysDir: scopedKeysDir,
+		issuerAccount: issuerAccount,
+	}, nil
+}
+
+// renderAuthCalloutBlock renders the authorization block of a nats-server.conf sending
+// authorization requests to the service
+func renderAuthCalloutBlock(issuer calloutIssuer, user, authAccount string) string {
+	var b strings.Builder
+	b.WriteString("# auth_callout for nats-server.conf\n")
+	b.WriteString("# Generated by: server print-nats-config\n")
+	if issuer.account != "$G" {
+		fmt.Fprintf(&b, "# Clients are assigned to account %s (NATS_ACCOUNT), which accounts {} must define\n", issuer.account)
+	}
+	b.WriteString("\nauthorization {\n")
+	b.WriteString("    auth_callout {\n")
+	b.WriteString("        # Public key of NATS_SIGNING_KEY_FILE, which signs authorization responses\n")
+	fmt.Fprintf(&b, "        issuer: %q\n\n", issuer.signingKey)
+	b.WriteString("        # User the service connects as\n")
+	fmt.Fprintf(&b, "        auth_users: [%q]\n", user)
+	if authAccount != "" {
+		b.WriteString("\n        # Account of the auth users\n")
+		fmt.Fprintf(&b, "        account: %q\n", authAccount)
+	}
+	b.WriteString("    }\n")
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// renderNSCCommands renders the nsc commands enabling the callout of an issuer in operator
+// mode. The callout account and its user are read from the issuer's credentials; nscAccount
+// is the callout account's name in nsc, left as a placeholder when empty.
+func renderNSCCommands(issuer calloutIssuer, nscAccount string) (string, error) {
+	if issuer.credsFile == "" {
+		return "", fmt.Errorf("operator mode requires user credentials, to find the callout account and user")
+	}
+	user, err := readCredsUser(issuer.credsFile)
+	if err != nil {
+		return "", err
+	}
+	calloutAccount := user.Issuer
+	if user.IssuerAccount != "" {
+		calloutAccount = user.IssuerAccount
+	}
+	if nscAccount == "" {
+		nscAccount = "<nsc name of " + calloutAccount + ">"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Auth callout of account %s, assigning clients to %s\n", calloutAccount, issuer.account)
+	b.WriteString("# Generated by: server print-nats-config\n")
+	if issuer.signingKey != calloutAccount {
+		b.WriteString("# The signing key is not the account's identity key, so add it as a signing key\n")
+		fmt.Fprintf(&b, "nsc edit account --name %s --sk %s\n", shellQuote(nscAccount), issuer.signingKey)
+	}
+	fmt.Fprintf(&b, "nsc edit authcallout --account %s --auth-user %s", shellQuote(nscAccount), user.Subject)
+	if issuer.account != calloutAccount {
+		fmt.Fprintf(&b, " --allowed-account %s", issuer.account)
+	}
+	b.WriteString("\n")
+
+	if issuer.scopedKeysDir != "" {
+		keys, err := nats.LoadScopedSigningKeys(issuer.scopedKeysDir)
+		if err != nil {
+			return "", err
+		}
+		owner := issuer.issuerAccount
+		if owner == "" {
+			owner = issuer.signingKey
+		}
+		fmt.Fprintf(&b, "# Scoped signing key roles of account %s; give each role its permissions with --allow-pub and --allow-sub\n", owner)
+		ownerName := nscAccount
+		if owner != calloutAccount {
+			ownerName = "<nsc name of " + owner + ">"
+		}
+		roles := make([]string, 0, len(keys))
+		for role := range keys {
+			roles = append(roles, role)
+		}
+		sort.Strings(roles)
+		for _, role := range roles {
+			public, err := keys[role].PublicKey()
+			if err != nil {
+				return "", fmt.Errorf("role %s: failed to get public key: %w", role, err)
+			}
+			fmt.Fprintf(&b, "nsc edit account --name %s --sk %s\n", shellQuote(ownerName), public)
+			fmt.Fprintf(&b, "nsc edit signing-key --account %s --sk %s --role %s\n", shellQuote(ownerName), public, shellQuote(role))
+		}
+	}
+	return b.String(), nil
+}
+
+// readCredsUser decodes the user JWT of a credentials file
+func readCredsUser(credsFile string) (*natsjwt.UserClaims, error) {
+	contents, err := os.ReadFile(credsFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read credentials file: %w", err)
+	}
+	token, err := natsjwt.ParseDecoratedJWT(contents)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse credentials file %s: %w", credsFile, err)
+	}
+	user, err := natsjwt.DecodeUserClaims(token)
+	if err != nil {
+		return nil, fmt.Errorf("invalid user JWT in credentials file %s: %w", credsFile, err)
+	}
+	if !nkeys.IsValidPublicAccountKey(user.Issuer) {
+		return nil, fmt.Errorf("user JWT in credentials file %s is not issued by an account", credsFile)
+	}
+	return user, nil
+}
+
+// shellQuote quotes a word for the shell when it contains anything but safe characters
+func shellQuote(word string) string {
+	if word != "" && strings.Trim(word, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_-.") == "" {
+		return word
+	}
+	return "'" + strings.ReplaceAll(word, "'", `'\''`) + "'"
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/portswigger-tim/nats-k8s-oidc-callout/internal/config"
+	"github.com/portswigger-tim/nats-k8s-oidc-callout/internal/nats"
+	"github.com/portswigger-tim/nats-k8s-oidc-callout/internal/standalone"
+	"go.uber.org/zap"
+)
+
+// errSkipped marks a preflight check that does not apply to the configuration
+var errSkipped = errors.New("skipped")
+
+// preflightCheck is one check of the preflight report, returning a short description of
+// what it verified
+type preflightCheck struct {
+	name string
+	run  func() (string, error)
+}
+
+// runPreflight implements the "preflight" subcommand.
+//
+// It loads the configuration from the environment, as the service would, and verifies what
+// the service needs to start: the signing keys, the NATS connection with the configured
+// credentials, the JWKS, and the Kubernetes RBAC permissions for the ServiceAccount cache.
+// A PASS/FAIL/SKIP report is printed to stdout, and the command fails if any check failed,
+// so it can gate a rollout from an init container or CI.
+func runPreflight(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("preflight", flag.ContinueOnError)
+	timeout := fs.Duration("timeout", 10*time.Second, "timeout of each network check")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintf(stdout, "FAIL  config: %v\n", err)
+		return errors.New("preflight failed: invalid configuration")
+	}
+	fmt.Fprintln(stdout, "PASS  config")
+
+	logger := zap.NewNop()
+	checks := []preflightCheck{
+		{"signing keys", func() (string, error) { return preflightSigningKeys(cfg) }},
+	}
+	checks = append(checks, preflightNATSChecks(cfg, *timeout, logger)...)
+	checks = append(checks,
+		preflightCheck{"jwks", func() (string, error) { return preflightJWKS(cfg, *timeout, logger) }},
+		preflightCheck{"permissions", func() (string, error) { return preflightPermissions(cfg, *timeout, logger) }},
+	)
+
+	failed := 0
+	for _, check := range checks {
+		detail, err := check.run()
+		switch {
+		case errors.Is(err, errSkipped):
+			fmt.Fprintf(stdout, "SKIP  %s: %s\n", check.name, detail)
+		case err != nil:
+			failed++
+			fmt.Fprintf(stdout, "FAIL  %s: %v\n", check.name, err)
+		default:
+			fmt.Fprintf(stdout, "PASS  %s: %s\n", check.name, detail)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("preflight failed: %d of %d checks failed", failed, len(checks)+1)
+	}
+	return nil
+}
+
+// preflightSigningKeys loads the account signing key and the scoped signing keys of every issuer
+func preflightSigningKeys(cfg *config.Config) (string, error) {
+	if cfg.NatsSigningKeyFile == "" && cfg.EmbeddedNATS {
+		return "an ephemeral key is generated for the embedded NATS server", errSkipped
+	}
+
+	signingKey, err := nats.LoadSigningKeyFromFile(cfg.NatsSigningKeyFile)
+	if err != nil {
+		return "", err
+	}
+	account, err := signingKey.PublicKey()
+	if err != nil {
+		return "", fmt.Errorf("failed to get public key: %w", err)
+	}
+	keys := 1
+	if cfg.NatsScopedKeysDir != "" {
+		scoped, err := nats.LoadScopedSigningKeys(cfg.NatsScopedKeysDir)
+		if err != nil {
+			return "", err
+		}
+		keys += len(scoped)
+	}
+
+	if cfg.NatsIssuersFile != "" {
+		issuers, err := nats.LoadIssuersFile(cfg.NatsIssuersFile)
+		if err != nil {
+			return "", err
+		}
+		for _, issuer := range issuers {
+			if _, err := nats.LoadSigningKeyFromFile(issuer.SigningKeyFile); err != nil {
+				return "", fmt.Errorf("issuer %s: %w", issuer.Account, err)
+			}
+			keys++
+			if issuer.ScopedKeysDir != "" {
+				scoped, err := nats.LoadScopedSigningKeys(issuer.ScopedKeysDir)
+				if err != nil {
+					return "", fmt.Errorf("issuer %s: %w", issuer.Account, err)
+				}
+				keys += len(scoped)
+			}
+		}
+	}
+	return fmt.Sprintf("%d keys loaded, signing as %s", keys, account), nil
+}
+
+// preflightNATSChecks returns a check connecting to NATS for the primary account and each
+// additional issuer, with the credentials the service would use
+func preflightNATSChecks(cfg *config.Config, timeout time.Duration, logger *zap.Logger) []preflightCheck {
+	if cfg.EmbeddedNATS {
+		return []preflightCheck{{"nats", func() (string, error) {
+			return "the embedded NATS server is started by the service", errSkipped
+		}}}
+	}
+
+	checks := []preflightCheck{{"nats", func() (string, error) {
+		client, err := nats.NewClient(cfg.NatsURL, cfg.NatsUserCredsFile, cfg.NatsToken, cfg.NatsAccount, nil, logger)
+		if err != nil {
+			return "", err
+		}
+		client.SetUserPassword(cfg.NatsUsername, cfg.NatsPassword)
+		client.SetIgnoreDiscoveredServers(cfg.NatsURLOnly)
+		return preflightConnect(client, timeout)
+	}}}
+
+	if cfg.NatsIssuersFile == "" {
+		return checks
+	}
+	issuers, err := nats.LoadIssuersFile(cfg.NatsIssuersFile)
+	if err != nil {
+		// Reported by the signing keys check
+		return checks
+	}
+	for _, issuer := range issuers {
+		checks = append(checks, preflightCheck{"nats (issuer " + issuer.Account + ")", func() (string, error) {
+			client, err := nats.NewClient(cfg.NatsURL, issuer.UserCredsFile, issuer.Token, issuer.Account, nil, logger)
+			if err != nil {
+				return "", err
+			}
+			client.SetIgnoreDiscoveredServers(cfg.NatsURLOnly)
+			return preflightConnect(client, timeout)
+		}})
+	}
+	return checks
+}
+
+// preflightConnect connects the client to NATS and disconnects again
+func preflightConnect(client *nats.Client, timeout time.Duration) (string, error) {
+	server, err := client.CheckConnection(timeout)
+	if err != nil {
+		return "", err
+	}
+	return "connected to " + server, nil
+}
+
+// preflightJWKS loads the JWKS once, without retries or starting in the background
+func preflightJWKS(cfg *config.Config, timeout time.Duration, logger *zap.Logger) (string, error) {
+	if cfg.DevOIDCAddr != "" {
+		return "the mock OIDC issuer is started by the service", errSkipped
+	}
+
+	strict := *cfg
+	strict.JWKSTimeout = timeout
+	strict.JWKSRetries = 0
+	strict.JWKSStartupGrace = 0
+	validator, err := initJWTValidator(&strict, logger)
+	if err != nil {
+		return "", err
+	}
+	validator.Close()
+
+	if cfg.JWKSPath != "" {
+		return "loaded " + cfg.JWKSPath, nil
+	}
+	return "fetched " + cfg.JWKSUrl, nil
+}
+
+// preflightPermissions loads the static permissions file in standalone mode, and otherwise
+// reviews the Kubernetes RBAC permissions the ServiceAccount cache needs
+func preflightPermissions(cfg *config.Config, timeout time.Duration, logger *zap.Logger) (string, error) {
+	if cfg.Standalone() {
+		provider, err := standalone.LoadFile(cfg.PermissionsFile, logger)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%d identities in %s", provider.Len(), cfg.PermissionsFile), nil
+	}
+	if cfg.FakeMode {
+		return "ServiceAccounts are served from FAKE_SERVICEACCOUNTS_FILE", errSkipped
+	}
+
+	clientset, err := newClientset(cfg, logger)
+	if err != nil {
+		return "", err
+	}
+
+	// Namespaces and nodes are cluster-scoped; the rest are reviewed in the watched namespace, or in
+	// every namespace when K8S_NAMESPACE is empty. Resources outside the core group name theirs.
+	type access struct{ verb, resource, namespace, group string }
+	required := []access{
+		{"list", "serviceaccounts", cfg.K8sNamespace, ""},
+		{"watch", "serviceaccounts", cfg.K8sNamespace, ""},
+		{"create", "events", cfg.K8sNamespace, ""},
+	}
+	if cfg.WatchNamespaces {
+		required = append(required, access{"list", "namespaces", "", ""}, access{"watch", "namespaces", "", ""})
+	}
+	if cfg.WatchNodes {
+		required = append(required, access{"list", "nodes", "", ""}, access{"watch", "nodes", "", ""})
+	}
+	if cfg.WatchWorkloads {
+		required = append(required,
+			access{"list", "pods", cfg.K8sNamespace, ""}, access{"watch", "pods", cfg.K8sNamespace, ""},
+			access{"list", "replicasets", cfg.K8sNamespace, "apps"}, access{"watch", "replicasets", cfg.K8sNamespace, "apps"})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	var denied []string
+	for _, a := range required {
+		review := &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &authorizationv1.ResourceAttributes{
+					Namespace: a.namespace,
+					Verb:      a.verb,
+					Group:     a.group,
+					Resource:  a.resource,
+				},
+			},
+		}
+		result, err := clientset.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, review, metav1.CreateOptions{})
+		if err != nil {
+			return "", fmt.Errorf("failed to review access to %s: %w", a.resource, err)
+		}
+		if !result.Status.Allowed {
+			denied = append(denied, a.verb+" "+a.resource)
+		}
+	}
+	if len(denied) > 0 {
+		return "", fmt.Errorf("RBAC denies %s", strings.Join(denied, ", "))
+	}
+	return fmt.Sprintf("RBAC allows all %d required actions", len(required)), nil
+}
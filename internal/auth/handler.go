@@ -2,6 +2,12 @@
 package auth
 
 import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
 	"github.com/portswigger-tim/nats-k8s-oidc-callout/internal/jwt"
 )
 
@@ -10,28 +16,210 @@ type JWTValidator interface {
 	Validate(token string) (*jwt.Claims, error)
 }
 
-// PermissionsProvider defines the interface for retrieving ServiceAccount permissions
+// PermissionsProvider defines the interface for retrieving ServiceAccount permissions.
+// For non-Kubernetes identities (standalone mode) namespace is empty and name is the token subject.
 type PermissionsProvider interface {
 	GetPermissions(namespace, name string) (pubPerms []string, subPerms []string, found bool)
 }
 
+// SyncStatus is implemented by permission providers that load their data asynchronously.
+// Before the provider has synced, a miss is reported as retryable rather than unknown.
+type SyncStatus interface {
+	HasSynced() bool
+}
+
+// AccessSwitch is implemented by permission providers that can disable access for an
+// identity that would otherwise be authorized, as a kill switch.
+type AccessSwitch interface {
+	Disabled(namespace, name string) bool
+}
+
+// BearerPolicy is implemented by permission providers that can request bearer user JWTs
+// for an identity, for clients that cannot sign the server nonce.
+type BearerPolicy interface {
+	Bearer(namespace, name string) bool
+}
+
+// ReadOnlyPolicy is implemented by permission providers that can mark an identity read-only,
+// for observers that must never publish. Read-only identities get no publish permissions; the
+// response permission of their class still lets them reply to requests they receive.
+type ReadOnlyPolicy interface {
+	ReadOnly(namespace, name string) bool
+}
+
+// DenyPolicy is implemented by permission providers that can deny an identity subjects its
+// permissions would otherwise grant, such as events.internal.> under events.>.
+type DenyPolicy interface {
+	DeniedSubjects(namespace, name string) (publish, subscribe []string)
+}
+
+// AccountPolicy is implemented by permission providers that can place an identity in a NATS
+// account other than the one its connection requested authorization in. Empty means the
+// default account.
+type AccountPolicy interface {
+	Account(namespace, name string) string
+}
+
+// TokenTTLPolicy is implemented by permission providers that can request a shorter lifetime
+// for the user JWTs issued to an identity. Zero means the default lifetime.
+type TokenTTLPolicy interface {
+	TokenTTL(namespace, name string) time.Duration
+}
+
+// LimitsPolicy is implemented by permission providers that can request NATS user limits for an
+// identity: the most subscriptions, the largest payload and the most pending data. Zero means
+// the default limit; requests only lower the configured defaults.
+type LimitsPolicy interface {
+	UserLimits(namespace, name string) (subs, payload, data int64)
+}
+
+// ConnectionLimitPolicy is implemented by permission providers that can limit the connections
+// an identity may hold open at once. Zero means the default limit; requests only lower it.
+type ConnectionLimitPolicy interface {
+	MaxConnections(namespace, name string) int
+}
+
+// UserLimits are the NATS user limits requested for an identity. Zero means the default.
+type UserLimits struct {
+	Subscriptions int64
+	Payload       int64
+	Data          int64
+}
+
+// ClassPolicy is implemented by permission providers that assign identities a request-reply
+// class (ClassResponder or ClassRequester), which shapes the response permission of their
+// user JWTs. An empty class means the default shape.
+type ClassPolicy interface {
+	Class(namespace, name string) string
+}
+
+// RolePolicy is implemented by permission providers that let identities select a scoped
+// signing key role. The role's permissions and limits are defined in the account JWT and
+// replace those of the user JWT. An empty role means no scoped signing key.
+type RolePolicy interface {
+	Role(namespace, name string) string
+}
+
+// JetStreamAdminPolicy is implemented by permission providers that can mark an identity as a
+// JetStream administrator, exempt from the JetStream API protection (see JetStreamAdminSubjects).
+type JetStreamAdminPolicy interface {
+	JetStreamAdmin(namespace, name string) bool
+}
+
+// JetStreamAdminSubjects are the destructive and administrative JetStream API operations denied
+// to identities that are not JetStream administrators, so that an over-broad publish grant such
+// as ">" cannot delete, purge or reconfigure streams and consumers.
+var JetStreamAdminSubjects = []string{
+	"$JS.API.STREAM.UPDATE.*",
+	"$JS.API.STREAM.DELETE.*",
+	"$JS.API.STREAM.PURGE.*",
+	"$JS.API.STREAM.MSG.DELETE.*",
+	"$JS.API.STREAM.RESTORE.*",
+	"$JS.API.STREAM.PEER.REMOVE.*",
+	"$JS.API.STREAM.LEADER.STEPDOWN.*",
+	"$JS.API.CONSUMER.DELETE.*.*",
+	"$JS.API.CONSUMER.LEADER.STEPDOWN.*.*",
+	"$JS.API.ACCOUNT.PURGE.*",
+	"$JS.API.META.LEADER.STEPDOWN",
+	"$JS.API.SERVER.REMOVE",
+}
+
+// TransportPolicy is implemented by permission providers that can require an identity's
+// connections to arrive over TLS, such as for every ServiceAccount of an annotated namespace.
+type TransportPolicy interface {
+	RequireTLS(namespace, name string) bool
+}
+
+// NodePolicy is implemented by permission providers that can restrict an identity to pods
+// scheduled on nodes with given labels, such as a trusted node pool. NodeLabels returns the
+// labels of a node, and whether the node is known.
+type NodePolicy interface {
+	NodeSelector(namespace, name string) map[string]string
+	NodeLabels(node string) (map[string]string, bool)
+}
+
+// WorkloadResolver is implemented by permission providers that know which workload, such as
+// a Deployment or StatefulSet, owns a pod. Workload returns the workload's name, and whether
+// it is known.
+type WorkloadResolver interface {
+	Workload(namespace, pod string) (string, bool)
+}
+
+// Connection types of AuthRequest.ConnectionType
+const (
+	ConnectionNATS      = "nats"
+	ConnectionWebSocket = "websocket"
+	ConnectionMQTT      = "mqtt"
+	ConnectionLeafnode  = "leafnode"
+
+	// ConnectionAPI marks requests made through the authorization API rather than by a NATS
+	// client, to which the transport requirements do not apply
+	ConnectionAPI = "api"
+)
+
+// Request-reply classes returned by ClassPolicy
+const (
+	// ClassResponder identities may send responses within the configured response limits
+	ClassResponder = "responder"
+	// ClassRequester identities only send requests and may not respond
+	ClassRequester = "requester"
+)
+
 // AuthRequest represents an authorization request
 type AuthRequest struct {
-	Token string
+	Token          string
+	ConnectionName string // name the client connected with, which may declare its inbox prefix
+	ConnectionType string // listener the client connected to (ConnectionNATS, ConnectionWebSocket, ...)
+	TLS            bool   // whether the connection arrived over TLS
 }
 
+// MaxTokenLength bounds the tokens the authorization APIs accept, well above the size of a
+// ServiceAccount token
+const MaxTokenLength = 16 * 1024
+
 // AuthResponse represents the authorization response
 type AuthResponse struct {
 	Allowed              bool
 	PublishPermissions   []string
 	SubscribePermissions []string
-	Error                string
+	PublishDenied        []string      // publish subjects denied even where PublishPermissions allow them
+	SubscribeDenied      []string      // subscribe subjects denied even where SubscribePermissions allow them
+	Bearer               bool          // issue a bearer user JWT, which is accepted without a nonce signature
+	ReadOnly             bool          // publish permissions were stripped for a read-only identity
+	TokenTTL             time.Duration // requested user JWT lifetime; zero for the default, never extends it
+	Class                string        // request-reply class (ClassResponder, ClassRequester or empty)
+	Limits               UserLimits    // requested user limits; never raise the configured defaults
+	MaxConnections       int           // requested limit of open connections; never raises the configured default
+	Role                 string        // scoped signing key role; empty for the default signing key
+	Account              string        // NATS account selected for the identity; empty for the default account
+	Identity             string        // namespace/serviceaccount, or the subject of non-Kubernetes tokens; empty until the token is validated
+	Namespace            string        // namespace of a validated ServiceAccount token
+	ServiceAccount       string        // name of a validated ServiceAccount token
+	Timings              Timings       // time spent in each stage, for slow authorization reports
+	Reason               ReasonCode
+}
+
+// Timings are the durations of the handler's stages
+type Timings struct {
+	Validation  time.Duration // JWT validation
+	Permissions time.Duration // everything after validation, mostly permission and policy lookups
 }
 
 // Handler handles authorization requests
 type Handler struct {
 	jwtValidator JWTValidator
 	permProvider PermissionsProvider
+	namespace    string            // when set, only ServiceAccounts in this namespace are authorized
+	deniedNS     map[string]bool   // infrastructure namespaces whose ServiceAccounts are never authorized
+	podInboxes   bool              // grant a per-pod private inbox instead of the ServiceAccount-wide one
+	tokenInboxes bool              // grant a per-token private inbox, keyed by the token ID
+	namedInboxes bool              // narrow the inbox grants to the prefix declared as the connection name
+	allowBearer  bool              // honour bearer requests from the permissions provider
+	protectJSAPI bool              // deny JetStreamAdminSubjects to identities that are not JetStream administrators
+	requireTLS   bool              // deny connections that did not arrive over TLS
+	connTypes    map[string]bool   // when set, only connections of these types are authorized
+	nodeSelector map[string]string // labels the node of every token's pod must have
+	cluster      string            // replaces {cluster} in granted and denied subjects
 }
 
 // NewHandler creates a new authorization handler
@@ -39,35 +227,246 @@ func NewHandler(jwtValidator JWTValidator, permProvider PermissionsProvider) *Ha
 	return &Handler{
 		jwtValidator: jwtValidator,
 		permProvider: permProvider,
+		protectJSAPI: true,
+	}
+}
+
+// SetNamespace restricts authorization to ServiceAccounts in a single namespace.
+// An empty namespace (the default) allows all namespaces.
+func (h *Handler) SetNamespace(namespace string) {
+	h.namespace = namespace
+}
+
+// SetDeniedNamespaces denies every ServiceAccount in the given namespaces, such as kube-system,
+// whatever its annotations grant.
+func (h *Handler) SetDeniedNamespaces(namespaces []string) {
+	h.deniedNS = make(map[string]bool, len(namespaces))
+	for _, namespace := range namespaces {
+		h.deniedNS[namespace] = true
+	}
+}
+
+// SetPodInboxes controls whether tokens bound to a pod are granted the per-pod private inbox
+// _INBOX_<namespace>_<serviceaccount>_<pod>.> instead of _INBOX_<namespace>_<serviceaccount>.>,
+// so replicas sharing a ServiceAccount cannot read each other's replies. Tokens without a pod
+// claim keep the ServiceAccount-wide inbox.
+func (h *Handler) SetPodInboxes(enabled bool) {
+	h.podInboxes = enabled
+}
+
+// SetTokenInboxes controls whether tokens with an ID (the jti claim) are granted the per-token
+// private inbox _INBOX_<namespace>_<serviceaccount>_<jti>.>, so that even connections of the
+// same pod cannot read each other's replies. It takes precedence over SetPodInboxes; tokens
+// without an ID keep the inbox they would be granted otherwise.
+func (h *Handler) SetTokenInboxes(enabled bool) {
+	h.tokenInboxes = enabled
+}
+
+// SetDeclaredInboxes controls whether clients may declare their inbox prefix as their
+// connection name. When the declared prefix falls within one of the inboxes the identity is
+// granted, every inbox grant is replaced by exactly <prefix>.>, so the connection only receives
+// replies sent to its own prefix. Connections with any other name keep their grants.
+func (h *Handler) SetDeclaredInboxes(enabled bool) {
+	h.namedInboxes = enabled
+}
+
+// SetAllowBearer controls whether identities the permissions provider marks as bearer
+// (see BearerPolicy) are issued bearer user JWTs. When disabled (the default) such
+// requests are ignored and a regular user JWT is issued.
+func (h *Handler) SetAllowBearer(allow bool) {
+	h.allowBearer = allow
+}
+
+// SetJetStreamAPIProtection controls whether JetStreamAdminSubjects are denied for publish to
+// identities the permissions provider does not mark as JetStream administrators (see
+// JetStreamAdminPolicy). It is enabled by default.
+func (h *Handler) SetJetStreamAPIProtection(enabled bool) {
+	h.protectJSAPI = enabled
+}
+
+// SetRequireTLS controls whether connections that did not arrive over TLS are denied, for every
+// identity. When disabled (the default) the permissions provider may still require TLS for
+// some identities (see TransportPolicy).
+func (h *Handler) SetRequireTLS(required bool) {
+	h.requireTLS = required
+}
+
+// SetNodeSelector restricts authorization to tokens bound to pods on nodes with all of the
+// given labels, in addition to those the permissions provider requires for an identity (see
+// NodePolicy). Tokens without a node claim are denied. An empty selector (the default)
+// allows every node.
+func (h *Handler) SetNodeSelector(selector map[string]string) {
+	h.nodeSelector = selector
+}
+
+// SetClusterName sets the name {cluster} is replaced with in subjects, so that one set of
+// annotations and policies can be applied to several clusters. While it is empty (the default)
+// subjects with {cluster} are not granted.
+func (h *Handler) SetClusterName(name string) {
+	h.cluster = name
+}
+
+// SetAllowedConnectionTypes restricts authorization to connections of the given types
+// (ConnectionNATS, ConnectionWebSocket, ConnectionMQTT or ConnectionLeafnode). An empty list
+// (the default) allows all types.
+func (h *Handler) SetAllowedConnectionTypes(types []string) {
+	h.connTypes = nil
+	if len(types) == 0 {
+		return
+	}
+	h.connTypes = make(map[string]bool, len(types))
+	for _, t := range types {
+		h.connTypes[t] = true
 	}
 }
 
 // Authorize processes an authorization request and returns the response
 func (h *Handler) Authorize(req *AuthRequest) *AuthResponse {
+	start := time.Now()
+	var validation time.Duration
+	var claims *jwt.Claims
+	resp := h.authorize(req, &validation, &claims)
+	if claims != nil {
+		resp.Namespace, resp.ServiceAccount = claims.Namespace, claims.ServiceAccount
+		resp.Identity = claims.Subject
+		if claims.Namespace != "" {
+			resp.Identity = claims.Namespace + "/" + claims.ServiceAccount
+		}
+	}
+	resp.Timings = Timings{Validation: validation, Permissions: time.Since(start) - validation}
+	return resp
+}
+
+// authorize makes the authorization decision, recording the time spent validating the JWT and
+// the claims of a valid token
+func (h *Handler) authorize(req *AuthRequest, validation *time.Duration, validClaims **jwt.Claims) *AuthResponse {
 	// Validate input
 	if req.Token == "" {
-		return &AuthResponse{
-			Allowed: false,
-			Error:   "authorization failed",
-		}
+		return deny(ReasonMissingToken)
 	}
 
 	// Validate JWT and extract claims
+	validationStart := time.Now()
 	claims, err := h.jwtValidator.Validate(req.Token)
+	*validation = time.Since(validationStart)
 	if err != nil {
-		// Generic error message to client, detailed logging would happen elsewhere
-		return &AuthResponse{
-			Allowed: false,
-			Error:   "authorization failed",
-		}
+		// Only the failure category is returned to the client, never the token contents
+		return deny(validationDenial(err))
 	}
+	*validClaims = claims
 
-	// Look up permissions from K8s ServiceAccount
-	pubPerms, subPerms, found := h.permProvider.GetPermissions(claims.Namespace, claims.ServiceAccount)
+	if h.namespace != "" && claims.Namespace != h.namespace {
+		return deny(ReasonNamespaceDenied)
+	}
+	if claims.Namespace != "" && h.deniedNS[claims.Namespace] {
+		return deny(ReasonSystemNamespace)
+	}
+
+	// Look up permissions from K8s ServiceAccount, or by subject for non-Kubernetes tokens
+	namespace, name := claims.Namespace, claims.ServiceAccount
+	if namespace == "" {
+		name = claims.Subject
+	}
+	if !h.transportAllowed(req, namespace, name) {
+		return deny(ReasonTransportDenied)
+	}
+	pubPerms, subPerms, found := h.permProvider.GetPermissions(namespace, name)
 	if !found {
-		return &AuthResponse{
-			Allowed: false,
-			Error:   "authorization failed",
+		if status, ok := h.permProvider.(SyncStatus); ok && !status.HasSynced() {
+			return deny(ReasonCacheNotSynced)
+		}
+		return deny(ReasonUnknownServiceAccount)
+	}
+	if access, ok := h.permProvider.(AccessSwitch); ok && access.Disabled(namespace, name) {
+		return deny(ReasonAccessDisabled)
+	}
+	if !h.nodeAllowed(claims.Node, namespace, name) {
+		return deny(ReasonNodeDenied)
+	}
+
+	// Templated grants, such as nodes.{node}.> for DaemonSets or apps.{namespace}.{workload}.>
+	// shared by the replicas of a Deployment, follow the token's pod
+	workload := h.workload(namespace, claims.Pod)
+	templates := [][2]string{
+		{namespacePlaceholder, namespace},
+		{serviceAccountPlaceholder, claims.ServiceAccount},
+		{workloadPlaceholder, workload},
+		{nodePlaceholder, claims.Node},
+		{clusterPlaceholder, h.cluster},
+	}
+	for _, template := range templates {
+		pubPerms = templated(pubPerms, template[0], template[1])
+		subPerms = templated(subPerms, template[0], template[1])
+	}
+
+	if namespace != "" {
+		switch {
+		case h.tokenInboxes && claims.ID != "":
+			subPerms = scopedInbox(subPerms, claims, claims.ID)
+		case h.podInboxes:
+			subPerms = scopedInbox(subPerms, claims, claims.Pod)
+		}
+	}
+	if h.namedInboxes {
+		subPerms = declaredInbox(subPerms, req.ConnectionName)
+	}
+
+	bearer := false
+	if policy, ok := h.permProvider.(BearerPolicy); ok && h.allowBearer {
+		bearer = policy.Bearer(namespace, name)
+	}
+
+	readOnly := false
+	if policy, ok := h.permProvider.(ReadOnlyPolicy); ok && policy.ReadOnly(namespace, name) {
+		readOnly = true
+		pubPerms = nil
+	}
+
+	var ttl time.Duration
+	if policy, ok := h.permProvider.(TokenTTLPolicy); ok {
+		ttl = policy.TokenTTL(namespace, name)
+	}
+
+	var class string
+	if policy, ok := h.permProvider.(ClassPolicy); ok {
+		class = policy.Class(namespace, name)
+	}
+
+	var limits UserLimits
+	if policy, ok := h.permProvider.(LimitsPolicy); ok {
+		limits.Subscriptions, limits.Payload, limits.Data = policy.UserLimits(namespace, name)
+	}
+
+	var maxConns int
+	if policy, ok := h.permProvider.(ConnectionLimitPolicy); ok {
+		maxConns = policy.MaxConnections(namespace, name)
+	}
+
+	// A scoped role's permissions come from the account JWT and cannot be stripped, so
+	// read-only identities are always issued with the default signing key
+	var role string
+	if policy, ok := h.permProvider.(RolePolicy); ok && !readOnly {
+		role = policy.Role(namespace, name)
+	}
+
+	var account string
+	if policy, ok := h.permProvider.(AccountPolicy); ok {
+		account = policy.Account(namespace, name)
+	}
+
+	var pubDenied, subDenied []string
+	if policy, ok := h.permProvider.(DenyPolicy); ok {
+		pubDenied, subDenied = policy.DeniedSubjects(namespace, name)
+		for _, template := range templates {
+			pubDenied = templatedDenied(pubDenied, template[0], template[1])
+			subDenied = templatedDenied(subDenied, template[0], template[1])
+		}
+	}
+	if h.protectJSAPI {
+		policy, ok := h.permProvider.(JetStreamAdminPolicy)
+		if !ok || !policy.JetStreamAdmin(namespace, name) {
+			// Concat copies, so the provider's lists are never appended to
+			pubDenied = slices.Concat(pubDenied, JetStreamAdminSubjects)
 		}
 	}
 
@@ -76,5 +475,225 @@ func (h *Handler) Authorize(req *AuthRequest) *AuthResponse {
 		Allowed:              true,
 		PublishPermissions:   pubPerms,
 		SubscribePermissions: subPerms,
+		PublishDenied:        pubDenied,
+		SubscribeDenied:      subDenied,
+		Bearer:               bearer,
+		ReadOnly:             readOnly,
+		TokenTTL:             ttl,
+		Class:                class,
+		Limits:               limits,
+		MaxConnections:       maxConns,
+		Role:                 role,
+		Account:              account,
+		Reason:               ReasonAllowed,
+	}
+}
+
+// transportAllowed reports whether the connection arrived over an allowed listener type, and
+// over TLS where that is required globally or for the identity
+func (h *Handler) transportAllowed(req *AuthRequest, namespace, name string) bool {
+	if req.ConnectionType == ConnectionAPI {
+		return true
+	}
+	if h.connTypes != nil && !h.connTypes[req.ConnectionType] {
+		return false
+	}
+	if req.TLS {
+		return true
+	}
+	if h.requireTLS {
+		return false
+	}
+	policy, ok := h.permProvider.(TransportPolicy)
+	return !ok || !policy.RequireTLS(namespace, name)
+}
+
+// nodeAllowed reports whether the token's node has the labels required of every identity and
+// of this one. When any label is required, tokens without a node and nodes the permissions
+// provider does not know are denied.
+func (h *Handler) nodeAllowed(node, namespace, name string) bool {
+	policy, ok := h.permProvider.(NodePolicy)
+	var required map[string]string
+	if ok {
+		required = policy.NodeSelector(namespace, name)
+	}
+	if len(h.nodeSelector) == 0 && len(required) == 0 {
+		return true
+	}
+	if !ok || node == "" {
+		return false
+	}
+	labels, found := policy.NodeLabels(node)
+	return found && labelsMatch(labels, h.nodeSelector) && labelsMatch(labels, required)
+}
+
+// labelsMatch reports whether labels has every key of selector with the same value
+func labelsMatch(labels, selector map[string]string) bool {
+	for key, value := range selector {
+		if actual, found := labels[key]; !found || actual != value {
+			return false
+		}
+	}
+	return true
+}
+
+// Placeholders replaced in granted subjects with the namespace and name of the token's
+// ServiceAccount, the workload owning its pod, the node its pod runs on and the cluster name
+const (
+	namespacePlaceholder      = "{namespace}"
+	serviceAccountPlaceholder = "{serviceaccount}"
+	workloadPlaceholder       = "{workload}"
+	nodePlaceholder           = "{node}"
+	clusterPlaceholder        = "{cluster}"
+)
+
+// workload returns the workload owning the token's pod, or "" when it is unknown
+func (h *Handler) workload(namespace, pod string) string {
+	resolver, ok := h.permProvider.(WorkloadResolver)
+	if !ok || namespace == "" || pod == "" {
+		return ""
+	}
+	workload, _ := resolver.Workload(namespace, pod)
+	return workload
+}
+
+// templated replaces placeholder in subjects with value, with "." replaced by "_" so that the
+// value is a single subject token (Kubernetes names cannot contain "_", so distinct values keep
+// distinct subjects). Subjects with the placeholder are dropped when the value is empty or
+// unusable, so templated grants fail closed.
+func templated(subjects []string, placeholder, value string) []string {
+	return substitute(subjects, placeholder, subjectToken(value))
+}
+
+// templatedDenied is templated for denied subjects, which must fail closed the other way: when
+// the value is empty or unusable, every subject token holding the placeholder becomes the "*"
+// wildcard, denying the subjects for every value rather than none.
+func templatedDenied(subjects []string, placeholder, value string) []string {
+	if token := subjectToken(value); token != "" {
+		return substitute(subjects, placeholder, token)
+	}
+	if !slices.ContainsFunc(subjects, func(subject string) bool { return strings.Contains(subject, placeholder) }) {
+		return subjects
+	}
+
+	result := make([]string, len(subjects))
+	for i, subject := range subjects {
+		tokens := strings.Split(subject, ".")
+		for j, token := range tokens {
+			if strings.Contains(token, placeholder) {
+				tokens[j] = "*"
+			}
+		}
+		result[i] = strings.Join(tokens, ".")
+	}
+	return result
+}
+
+// subjectToken returns value as a single subject token, or "" when it is empty or unusable
+func subjectToken(value string) string {
+	if value == "" || strings.ContainsAny(value, "_*>$ \t\r\n") {
+		return ""
+	}
+	return strings.ReplaceAll(value, ".", "_")
+}
+
+// substitute replaces placeholder in subjects with token, dropping the subjects with the
+// placeholder when token is empty
+func substitute(subjects []string, placeholder, token string) []string {
+	if !slices.ContainsFunc(subjects, func(subject string) bool { return strings.Contains(subject, placeholder) }) {
+		return subjects
+	}
+
+	// Copy rather than modify the provider's slice, which may be shared
+	result := make([]string, 0, len(subjects))
+	for _, subject := range subjects {
+		if strings.Contains(subject, placeholder) {
+			if token == "" {
+				continue
+			}
+			subject = strings.ReplaceAll(subject, placeholder, token)
+		}
+		result = append(result, subject)
+	}
+	return result
+}
+
+// scopedInbox replaces the ServiceAccount-wide private inbox in subPerms with the inbox
+// _INBOX_<namespace>_<serviceaccount>_<suffix>, where the suffix is the pod the token is bound
+// to or the token ID. Suffixes containing "." are left on the ServiceAccount-wide inbox, since
+// their inbox would fall under the inbox named by the first token, as are those containing
+// wildcards or whitespace.
+func scopedInbox(subPerms []string, claims *jwt.Claims, suffix string) []string {
+	if suffix == "" || strings.ContainsAny(suffix, ".*> \t\r\n") {
+		return subPerms
+	}
+
+	saInbox := fmt.Sprintf("_INBOX_%s_%s.>", claims.Namespace, claims.ServiceAccount)
+	scopedSubject := fmt.Sprintf("_INBOX_%s_%s_%s.>", claims.Namespace, claims.ServiceAccount, suffix)
+
+	// Copy rather than modify the provider's slice, which may be shared
+	result := make([]string, len(subPerms))
+	for i, subject := range subPerms {
+		if subject == saInbox {
+			subject = scopedSubject
+		}
+		result[i] = subject
+	}
+	return result
+}
+
+// declaredInbox replaces the inbox grants in subPerms (_INBOX.> and every _INBOX_ pattern) with
+// the inbox prefix the client declared, provided one of them covers it. Otherwise subPerms is
+// returned unchanged.
+func declaredInbox(subPerms []string, prefix string) []string {
+	if !isInbox(prefix) || strings.ContainsAny(prefix, "*> \t\r\n") ||
+		strings.HasSuffix(prefix, ".") || strings.Contains(prefix, "..") {
+		return subPerms
+	}
+
+	covered := false
+	result := make([]string, 0, len(subPerms))
+	for _, subject := range subPerms {
+		inbox, wildcard := strings.CutSuffix(subject, ".>")
+		if !wildcard || !isInbox(inbox) {
+			result = append(result, subject)
+			continue
+		}
+		if prefix == inbox || strings.HasPrefix(prefix, inbox+".") {
+			covered = true
+		}
+	}
+	if !covered {
+		return subPerms
+	}
+	return append(result, prefix+".>")
+}
+
+// isInbox reports whether a subject prefix is the standard inbox or a private or custom one
+func isInbox(prefix string) bool {
+	return prefix == "_INBOX" || strings.HasPrefix(prefix, "_INBOX.") || strings.HasPrefix(prefix, "_INBOX_")
+}
+
+// deny returns a denied response with the given reason
+func deny(reason ReasonCode) *AuthResponse {
+	return &AuthResponse{
+		Allowed: false,
+		Reason:  reason,
+	}
+}
+
+// validationDenial maps a token validation error to its denial reason
+func validationDenial(err error) ReasonCode {
+	switch {
+	case errors.Is(err, jwt.ErrExpiredToken):
+		return ReasonTokenExpired
+	case errors.Is(err, jwt.ErrInvalidSignature):
+		return ReasonInvalidSignature
+	case errors.Is(err, jwt.ErrInvalidIssuer):
+		return ReasonWrongIssuer
+	case errors.Is(err, jwt.ErrInvalidAudience):
+		return ReasonWrongAudience
+	default:
+		return ReasonInvalidToken
 	}
 }
@@ -2,7 +2,9 @@ package auth
 
 import (
 	"errors"
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/portswigger-tim/nats-k8s-oidc-callout/internal/jwt"
 )
@@ -59,8 +61,8 @@ func TestHandler_Authorize_Success(t *testing.T) {
 		t.Error("Expected authorization to be allowed")
 	}
 
-	if resp.Error != "" {
-		t.Errorf("Expected no error, got: %s", resp.Error)
+	if resp.Reason != ReasonAllowed {
+		t.Errorf("Reason = %q, want %q", resp.Reason, ReasonAllowed)
 	}
 
 	expectedPub := []string{"hakawai.>", "platform.events.>"}
@@ -78,34 +80,44 @@ func TestHandler_Authorize_Success(t *testing.T) {
 // TestHandler_Authorize_InvalidJWT tests JWT validation failures
 func TestHandler_Authorize_InvalidJWT(t *testing.T) {
 	tests := []struct {
-		name        string
-		jwtError    error
-		expectedMsg string
+		name           string
+		jwtError       error
+		expectedReason ReasonCode
 	}{
 		{
-			name:        "Expired token",
-			jwtError:    jwt.ErrExpiredToken,
-			expectedMsg: "authorization failed",
+			name:           "Expired token",
+			jwtError:       jwt.ErrExpiredToken,
+			expectedReason: ReasonTokenExpired,
+		},
+		{
+			name:           "Invalid signature",
+			jwtError:       jwt.ErrInvalidSignature,
+			expectedReason: ReasonInvalidSignature,
+		},
+		{
+			name:           "Invalid claims",
+			jwtError:       jwt.ErrInvalidClaims,
+			expectedReason: ReasonInvalidToken,
 		},
 		{
-			name:        "Invalid signature",
-			jwtError:    jwt.ErrInvalidSignature,
-			expectedMsg: "authorization failed",
+			name:           "Wrong audience",
+			jwtError:       fmt.Errorf("%w: %w: audience mismatch", jwt.ErrInvalidClaims, jwt.ErrInvalidAudience),
+			expectedReason: ReasonWrongAudience,
 		},
 		{
-			name:        "Invalid claims",
-			jwtError:    jwt.ErrInvalidClaims,
-			expectedMsg: "authorization failed",
+			name:           "Wrong issuer",
+			jwtError:       fmt.Errorf("%w: %w: issuer mismatch", jwt.ErrInvalidClaims, jwt.ErrInvalidIssuer),
+			expectedReason: ReasonWrongIssuer,
 		},
 		{
-			name:        "Missing K8s claims",
-			jwtError:    jwt.ErrMissingK8sClaims,
-			expectedMsg: "authorization failed",
+			name:           "Missing K8s claims",
+			jwtError:       jwt.ErrMissingK8sClaims,
+			expectedReason: ReasonInvalidToken,
 		},
 		{
-			name:        "Generic error",
-			jwtError:    errors.New("some validation error"),
-			expectedMsg: "authorization failed",
+			name:           "Generic error",
+			jwtError:       errors.New("some validation error"),
+			expectedReason: ReasonInvalidToken,
 		},
 	}
 
@@ -138,8 +150,8 @@ func TestHandler_Authorize_InvalidJWT(t *testing.T) {
 				t.Error("Expected authorization to be denied")
 			}
 
-			if resp.Error != tt.expectedMsg {
-				t.Errorf("Error = %q, want %q", resp.Error, tt.expectedMsg)
+			if resp.Reason != tt.expectedReason {
+				t.Errorf("Reason = %q, want %q", resp.Reason, tt.expectedReason)
 			}
 
 			if resp.PublishPermissions != nil {
@@ -184,8 +196,8 @@ func TestHandler_Authorize_ServiceAccountNotFound(t *testing.T) {
 		t.Error("Expected authorization to be denied")
 	}
 
-	if resp.Error != "authorization failed" {
-		t.Errorf("Error = %q, want %q", resp.Error, "authorization failed")
+	if resp.Reason != ReasonUnknownServiceAccount {
+		t.Errorf("Reason = %q, want %q", resp.Reason, ReasonUnknownServiceAccount)
 	}
 
 	if resp.PublishPermissions != nil {
@@ -226,8 +238,944 @@ func TestHandler_Authorize_EmptyToken(t *testing.T) {
 		t.Error("Expected authorization to be denied")
 	}
 
-	if resp.Error != "authorization failed" {
-		t.Errorf("Error = %q, want %q", resp.Error, "authorization failed")
+	if resp.Reason != ReasonMissingToken {
+		t.Errorf("Reason = %q, want %q", resp.Reason, ReasonMissingToken)
+	}
+}
+
+// TestHandler_Authorize_SubjectIdentity tests lookup by subject for non-Kubernetes tokens
+func TestHandler_Authorize_SubjectIdentity(t *testing.T) {
+	jwtValidator := &mockJWTValidator{
+		validateFunc: func(token string) (*jwt.Claims, error) {
+			return &jwt.Claims{Subject: "ci-runner@example.com"}, nil
+		},
+	}
+
+	permProvider := &mockPermissionsProvider{
+		getPermissionsFunc: func(namespace, name string) ([]string, []string, bool) {
+			if namespace == "" && name == "ci-runner@example.com" {
+				return []string{"ci.>"}, []string{"_INBOX.>"}, true
+			}
+			return nil, nil, false
+		},
+	}
+
+	handler := NewHandler(jwtValidator, permProvider)
+	resp := handler.Authorize(&AuthRequest{Token: "valid.jwt.token"})
+
+	if !resp.Allowed {
+		t.Fatal("Expected authorization to be allowed")
+	}
+	if !equalStringSlices(resp.PublishPermissions, []string{"ci.>"}) {
+		t.Errorf("PublishPermissions = %v, want %v", resp.PublishPermissions, []string{"ci.>"})
+	}
+}
+
+// TestHandler_Authorize_NamespaceRestriction tests the single-namespace restriction
+func TestHandler_Authorize_NamespaceRestriction(t *testing.T) {
+	permProvider := &mockPermissionsProvider{
+		getPermissionsFunc: func(namespace, name string) ([]string, []string, bool) {
+			return []string{namespace + ".>"}, []string{"_INBOX.>"}, true
+		},
+	}
+
+	tests := []struct {
+		name        string
+		namespace   string
+		wantAllowed bool
+		wantReason  ReasonCode
+	}{
+		{name: "same namespace", namespace: "production", wantAllowed: true, wantReason: ReasonAllowed},
+		{name: "other namespace", namespace: "staging", wantAllowed: false, wantReason: ReasonNamespaceDenied},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jwtValidator := &mockJWTValidator{
+				validateFunc: func(token string) (*jwt.Claims, error) {
+					return &jwt.Claims{Namespace: tt.namespace, ServiceAccount: "app"}, nil
+				},
+			}
+
+			handler := NewHandler(jwtValidator, permProvider)
+			handler.SetNamespace("production")
+
+			resp := handler.Authorize(&AuthRequest{Token: "valid.jwt.token"})
+			if resp.Allowed != tt.wantAllowed {
+				t.Errorf("Allowed = %v, want %v", resp.Allowed, tt.wantAllowed)
+			}
+			if resp.Reason != tt.wantReason {
+				t.Errorf("Reason = %q, want %q", resp.Reason, tt.wantReason)
+			}
+		})
+	}
+}
+
+// TestHandler_Authorize_DeniedNamespaces tests that infrastructure namespaces are denied
+// with their own reason
+func TestHandler_Authorize_DeniedNamespaces(t *testing.T) {
+	permProvider := &mockPermissionsProvider{
+		getPermissionsFunc: func(namespace, name string) ([]string, []string, bool) {
+			return []string{namespace + ".>"}, []string{"_INBOX.>"}, true
+		},
+	}
+
+	tests := []struct {
+		name       string
+		namespace  string
+		wantReason ReasonCode
+	}{
+		{name: "workload namespace", namespace: "production", wantReason: ReasonAllowed},
+		{name: "kube-system", namespace: "kube-system", wantReason: ReasonSystemNamespace},
+		{name: "kube-public", namespace: "kube-public", wantReason: ReasonSystemNamespace},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jwtValidator := &mockJWTValidator{
+				validateFunc: func(token string) (*jwt.Claims, error) {
+					return &jwt.Claims{Namespace: tt.namespace, ServiceAccount: "app"}, nil
+				},
+			}
+
+			handler := NewHandler(jwtValidator, permProvider)
+			handler.SetDeniedNamespaces([]string{"kube-system", "kube-public"})
+
+			resp := handler.Authorize(&AuthRequest{Token: "valid.jwt.token"})
+			if resp.Reason != tt.wantReason {
+				t.Errorf("Reason = %q, want %q", resp.Reason, tt.wantReason)
+			}
+		})
+	}
+}
+
+// TestHandler_Authorize_PodInboxes tests that pod-bound tokens get a per-pod private inbox
+func TestHandler_Authorize_PodInboxes(t *testing.T) {
+	subPerms := []string{"_INBOX.>", "_INBOX_production_app.>", "production.>"}
+	permProvider := &mockPermissionsProvider{
+		getPermissionsFunc: func(namespace, name string) ([]string, []string, bool) {
+			return []string{"production.>"}, subPerms, true
+		},
+	}
+
+	tests := []struct {
+		name      string
+		enabled   bool
+		pod       string
+		wantInbox string
+	}{
+		{name: "disabled", enabled: false, pod: "app-7d9f-x2k4p", wantInbox: "_INBOX_production_app.>"},
+		{name: "pod-bound token", enabled: true, pod: "app-7d9f-x2k4p", wantInbox: "_INBOX_production_app_app-7d9f-x2k4p.>"},
+		{name: "token without pod", enabled: true, pod: "", wantInbox: "_INBOX_production_app.>"},
+		{name: "pod name with dots", enabled: true, pod: "app.v2", wantInbox: "_INBOX_production_app.>"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jwtValidator := &mockJWTValidator{
+				validateFunc: func(token string) (*jwt.Claims, error) {
+					return &jwt.Claims{Namespace: "production", ServiceAccount: "app", Pod: tt.pod}, nil
+				},
+			}
+
+			handler := NewHandler(jwtValidator, permProvider)
+			handler.SetPodInboxes(tt.enabled)
+
+			resp := handler.Authorize(&AuthRequest{Token: "valid.jwt.token"})
+			if !resp.Allowed {
+				t.Fatalf("Expected authorization to be allowed, got %q", resp.Reason)
+			}
+			want := []string{"_INBOX.>", tt.wantInbox, "production.>"}
+			if !equalStringSlices(resp.SubscribePermissions, want) {
+				t.Errorf("SubscribePermissions = %v, want %v", resp.SubscribePermissions, want)
+			}
+		})
+	}
+
+	// The provider's slice must not be modified
+	if subPerms[1] != "_INBOX_production_app.>" {
+		t.Errorf("provider permissions modified: %v", subPerms)
+	}
+}
+
+// TestHandler_Authorize_NodeScoped tests that {node} is replaced with the token's node
+func TestHandler_Authorize_NodeScoped(t *testing.T) {
+	permProvider := &mockPermissionsProvider{
+		getPermissionsFunc: func(namespace, name string) ([]string, []string, bool) {
+			return []string{"monitoring.>", "nodes.{node}.metrics"}, []string{"_INBOX.>", "nodes.{node}.>"}, true
+		},
+	}
+
+	tests := []struct {
+		name    string
+		node    string
+		wantPub []string
+		wantSub []string
+	}{
+		{
+			name:    "token with node",
+			node:    "ip-10-0-1-17.ec2.internal",
+			wantPub: []string{"monitoring.>", "nodes.ip-10-0-1-17_ec2_internal.metrics"},
+			wantSub: []string{"_INBOX.>", "nodes.ip-10-0-1-17_ec2_internal.>"},
+		},
+		{
+			name:    "token without node",
+			wantPub: []string{"monitoring.>"},
+			wantSub: []string{"_INBOX.>"},
+		},
+		{
+			name:    "node with wildcard",
+			node:    "worker.>",
+			wantPub: []string{"monitoring.>"},
+			wantSub: []string{"_INBOX.>"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jwtValidator := &mockJWTValidator{
+				validateFunc: func(token string) (*jwt.Claims, error) {
+					return &jwt.Claims{Namespace: "monitoring", ServiceAccount: "node-exporter", Node: tt.node}, nil
+				},
+			}
+
+			resp := NewHandler(jwtValidator, permProvider).Authorize(&AuthRequest{Token: "valid.jwt.token"})
+			if !resp.Allowed {
+				t.Fatalf("Expected authorization to be allowed, got %q", resp.Reason)
+			}
+			if !equalStringSlices(resp.PublishPermissions, tt.wantPub) {
+				t.Errorf("PublishPermissions = %v, want %v", resp.PublishPermissions, tt.wantPub)
+			}
+			if !equalStringSlices(resp.SubscribePermissions, tt.wantSub) {
+				t.Errorf("SubscribePermissions = %v, want %v", resp.SubscribePermissions, tt.wantSub)
+			}
+		})
+	}
+}
+
+// workloadPermissionsProvider resolves the workloads of pods from a map keyed by "namespace/pod"
+type workloadPermissionsProvider struct {
+	mockPermissionsProvider
+	workloads map[string]string
+}
+
+func (p *workloadPermissionsProvider) Workload(namespace, pod string) (string, bool) {
+	workload, found := p.workloads[namespace+"/"+pod]
+	return workload, found
+}
+
+// TestHandler_Authorize_WorkloadScoped tests that {namespace} and {workload} are replaced with
+// the token's namespace and the workload owning its pod
+func TestHandler_Authorize_WorkloadScoped(t *testing.T) {
+	permProvider := &workloadPermissionsProvider{
+		mockPermissionsProvider: mockPermissionsProvider{
+			getPermissionsFunc: func(namespace, name string) ([]string, []string, bool) {
+				return []string{"apps.{namespace}.{workload}.>"}, []string{"_INBOX.>", "apps.{namespace}.>"}, true
+			},
+		},
+		workloads: map[string]string{"shop/checkout-7d9f-x2k4p": "checkout", "shop/web-0": "web.v2"},
+	}
+
+	tests := []struct {
+		name    string
+		pod     string
+		wantPub []string
+	}{
+		{name: "Deployment pod", pod: "checkout-7d9f-x2k4p", wantPub: []string{"apps.shop.checkout.>"}},
+		{name: "workload with dots", pod: "web-0", wantPub: []string{"apps.shop.web_v2.>"}},
+		{name: "unknown pod", pod: "batch-x9z", wantPub: []string{}},
+		{name: "token without pod", wantPub: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jwtValidator := &mockJWTValidator{
+				validateFunc: func(token string) (*jwt.Claims, error) {
+					return &jwt.Claims{Namespace: "shop", ServiceAccount: "app", Pod: tt.pod}, nil
+				},
+			}
+
+			resp := NewHandler(jwtValidator, permProvider).Authorize(&AuthRequest{Token: "valid.jwt.token"})
+			if !resp.Allowed {
+				t.Fatalf("Expected authorization to be allowed, got %q", resp.Reason)
+			}
+			if !equalStringSlices(resp.PublishPermissions, tt.wantPub) {
+				t.Errorf("PublishPermissions = %v, want %v", resp.PublishPermissions, tt.wantPub)
+			}
+			if wantSub := []string{"_INBOX.>", "apps.shop.>"}; !equalStringSlices(resp.SubscribePermissions, wantSub) {
+				t.Errorf("SubscribePermissions = %v, want %v", resp.SubscribePermissions, wantSub)
+			}
+		})
+	}
+}
+
+// TestHandler_Authorize_IdentityScoped tests that {serviceaccount} and {cluster} are replaced
+// with the token's ServiceAccount and the configured cluster name, in denied subjects too
+func TestHandler_Authorize_IdentityScoped(t *testing.T) {
+	jwtValidator := &mockJWTValidator{
+		validateFunc: func(token string) (*jwt.Claims, error) {
+			return &jwt.Claims{Namespace: "shop", ServiceAccount: "orders"}, nil
+		},
+	}
+	provider := &denyPermissionsProvider{
+		mockPermissionsProvider: mockPermissionsProvider{getPermissionsFunc: func(namespace, name string) ([]string, []string, bool) {
+			return []string{"orders.{namespace}.{serviceaccount}.>", "{cluster}.status"}, []string{"_INBOX.>"}, true
+		}},
+		pub: []string{"orders.{namespace}.{serviceaccount}.admin", "{cluster}-internal.>"},
+	}
+
+	tests := []struct {
+		name       string
+		cluster    string
+		wantPub    []string
+		wantDenied []string
+	}{
+		{
+			name:       "cluster name set",
+			cluster:    "prod.eu",
+			wantPub:    []string{"orders.shop.orders.>", "prod_eu.status"},
+			wantDenied: []string{"orders.shop.orders.admin", "prod_eu-internal.>"},
+		},
+		{
+			// Grants fail closed by being dropped, denials by matching every cluster
+			name:       "no cluster name",
+			wantPub:    []string{"orders.shop.orders.>"},
+			wantDenied: []string{"orders.shop.orders.admin", "*.>"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := NewHandler(jwtValidator, provider)
+			handler.SetJetStreamAPIProtection(false)
+			handler.SetClusterName(tt.cluster)
+
+			resp := handler.Authorize(&AuthRequest{Token: "valid.jwt.token"})
+			if !resp.Allowed {
+				t.Fatalf("Expected authorization to be allowed, got %q", resp.Reason)
+			}
+			if !equalStringSlices(resp.PublishPermissions, tt.wantPub) {
+				t.Errorf("PublishPermissions = %v, want %v", resp.PublishPermissions, tt.wantPub)
+			}
+			if !equalStringSlices(resp.PublishDenied, tt.wantDenied) {
+				t.Errorf("PublishDenied = %v, want %v", resp.PublishDenied, tt.wantDenied)
+			}
+		})
+	}
+	if provider.pub[0] != "orders.{namespace}.{serviceaccount}.admin" {
+		t.Errorf("provider's denied subjects modified: %v", provider.pub)
+	}
+}
+
+// TestHandler_Authorize_TokenInboxes tests the per-token private inbox and its fallbacks
+func TestHandler_Authorize_TokenInboxes(t *testing.T) {
+	permProvider := &mockPermissionsProvider{
+		getPermissionsFunc: func(namespace, name string) ([]string, []string, bool) {
+			return []string{"production.>"}, []string{"_INBOX.>", "_INBOX_production_app.>", "production.>"}, true
+		},
+	}
+
+	tests := []struct {
+		name       string
+		podInboxes bool
+		id         string
+		wantInbox  string
+	}{
+		{name: "token with ID", id: "0b6c1a52-3f4e", wantInbox: "_INBOX_production_app_0b6c1a52-3f4e.>"},
+		{name: "token ID over pod", podInboxes: true, id: "0b6c1a52-3f4e", wantInbox: "_INBOX_production_app_0b6c1a52-3f4e.>"},
+		{name: "token without ID", wantInbox: "_INBOX_production_app.>"},
+		{name: "token without ID falls back to pod", podInboxes: true, wantInbox: "_INBOX_production_app_app-7d9f-x2k4p.>"},
+		{name: "token ID with wildcard", id: "abc.>", wantInbox: "_INBOX_production_app.>"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jwtValidator := &mockJWTValidator{
+				validateFunc: func(token string) (*jwt.Claims, error) {
+					return &jwt.Claims{Namespace: "production", ServiceAccount: "app", Pod: "app-7d9f-x2k4p", ID: tt.id}, nil
+				},
+			}
+
+			handler := NewHandler(jwtValidator, permProvider)
+			handler.SetPodInboxes(tt.podInboxes)
+			handler.SetTokenInboxes(true)
+
+			resp := handler.Authorize(&AuthRequest{Token: "valid.jwt.token"})
+			if !resp.Allowed {
+				t.Fatalf("Expected authorization to be allowed, got %q", resp.Reason)
+			}
+			want := []string{"_INBOX.>", tt.wantInbox, "production.>"}
+			if !equalStringSlices(resp.SubscribePermissions, want) {
+				t.Errorf("SubscribePermissions = %v, want %v", resp.SubscribePermissions, want)
+			}
+		})
+	}
+}
+
+// TestHandler_Authorize_DeclaredInboxes tests narrowing the inbox grants to the prefix the
+// client declared as its connection name
+func TestHandler_Authorize_DeclaredInboxes(t *testing.T) {
+	jwtValidator := &mockJWTValidator{
+		validateFunc: func(token string) (*jwt.Claims, error) {
+			return &jwt.Claims{Namespace: "production", ServiceAccount: "app"}, nil
+		},
+	}
+	permProvider := &mockPermissionsProvider{
+		getPermissionsFunc: func(namespace, name string) ([]string, []string, bool) {
+			return []string{"production.>"}, []string{"_INBOX.>", "_INBOX_production_app.>", "production.>", "_INBOX_orders.api.>"}, true
+		},
+	}
+	granted := []string{"_INBOX.>", "_INBOX_production_app.>", "production.>", "_INBOX_orders.api.>"}
+
+	tests := []struct {
+		name           string
+		enabled        bool
+		connectionName string
+		want           []string
+	}{
+		{name: "disabled", connectionName: "_INBOX_production_app", want: granted},
+		{name: "private inbox", enabled: true, connectionName: "_INBOX_production_app", want: []string{"production.>", "_INBOX_production_app.>"}},
+		{name: "within the private inbox", enabled: true, connectionName: "_INBOX_production_app.worker1", want: []string{"production.>", "_INBOX_production_app.worker1.>"}},
+		{name: "custom inbox", enabled: true, connectionName: "_INBOX_orders.api", want: []string{"production.>", "_INBOX_orders.api.>"}},
+		{name: "another ServiceAccount's inbox", enabled: true, connectionName: "_INBOX_production_other", want: granted},
+		{name: "wildcard", enabled: true, connectionName: "_INBOX_production_app.*", want: granted},
+		{name: "ordinary connection name", enabled: true, connectionName: "orders-api", want: granted},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := NewHandler(jwtValidator, permProvider)
+			handler.SetDeclaredInboxes(tt.enabled)
+
+			resp := handler.Authorize(&AuthRequest{Token: "valid.jwt.token", ConnectionName: tt.connectionName})
+			if !resp.Allowed {
+				t.Fatalf("Expected authorization to be allowed, got %q", resp.Reason)
+			}
+			if !equalStringSlices(resp.SubscribePermissions, tt.want) {
+				t.Errorf("SubscribePermissions = %v, want %v", resp.SubscribePermissions, tt.want)
+			}
+		})
+	}
+}
+
+// switchablePermissionsProvider is a permissions provider with an access kill switch
+type switchablePermissionsProvider struct {
+	mockPermissionsProvider
+	disabled bool
+}
+
+func (p *switchablePermissionsProvider) Disabled(namespace, name string) bool {
+	return p.disabled
+}
+
+// TestHandler_Authorize_AccessDisabled tests that a disabled identity is denied despite a valid token
+func TestHandler_Authorize_AccessDisabled(t *testing.T) {
+	jwtValidator := &mockJWTValidator{
+		validateFunc: func(token string) (*jwt.Claims, error) {
+			return &jwt.Claims{Namespace: "production", ServiceAccount: "app"}, nil
+		},
+	}
+
+	tests := []struct {
+		name        string
+		disabled    bool
+		wantAllowed bool
+		wantReason  ReasonCode
+	}{
+		{name: "enabled", disabled: false, wantAllowed: true, wantReason: ReasonAllowed},
+		{name: "disabled", disabled: true, wantAllowed: false, wantReason: ReasonAccessDisabled},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			permProvider := &switchablePermissionsProvider{
+				mockPermissionsProvider: mockPermissionsProvider{
+					getPermissionsFunc: func(namespace, name string) ([]string, []string, bool) {
+						return []string{"production.>"}, []string{"_INBOX.>"}, true
+					},
+				},
+				disabled: tt.disabled,
+			}
+
+			resp := NewHandler(jwtValidator, permProvider).Authorize(&AuthRequest{Token: "valid.jwt.token"})
+			if resp.Allowed != tt.wantAllowed {
+				t.Errorf("Allowed = %v, want %v", resp.Allowed, tt.wantAllowed)
+			}
+			if resp.Reason != tt.wantReason {
+				t.Errorf("Reason = %q, want %q", resp.Reason, tt.wantReason)
+			}
+		})
+	}
+}
+
+// bearerPermissionsProvider is a permissions provider that requests bearer user JWTs
+type bearerPermissionsProvider struct {
+	mockPermissionsProvider
+}
+
+func (p *bearerPermissionsProvider) Bearer(namespace, name string) bool {
+	return true
+}
+
+// TestHandler_Authorize_Bearer tests that bearer requests are only honoured when allowed
+func TestHandler_Authorize_Bearer(t *testing.T) {
+	jwtValidator := &mockJWTValidator{
+		validateFunc: func(token string) (*jwt.Claims, error) {
+			return &jwt.Claims{Namespace: "production", ServiceAccount: "app"}, nil
+		},
+	}
+	permProvider := &bearerPermissionsProvider{
+		mockPermissionsProvider: mockPermissionsProvider{
+			getPermissionsFunc: func(namespace, name string) ([]string, []string, bool) {
+				return []string{"production.>"}, []string{"_INBOX.>"}, true
+			},
+		},
+	}
+
+	for _, allow := range []bool{false, true} {
+		handler := NewHandler(jwtValidator, permProvider)
+		handler.SetAllowBearer(allow)
+
+		resp := handler.Authorize(&AuthRequest{Token: "valid.jwt.token"})
+		if !resp.Allowed {
+			t.Fatalf("Expected authorization to be allowed, got %q", resp.Reason)
+		}
+		if resp.Bearer != allow {
+			t.Errorf("with SetAllowBearer(%v): Bearer = %v, want %v", allow, resp.Bearer, allow)
+		}
+	}
+}
+
+// readOnlyPermissionsProvider is a permissions provider that marks identities read-only
+type readOnlyPermissionsProvider struct {
+	mockPermissionsProvider
+	readOnly bool
+}
+
+func (p *readOnlyPermissionsProvider) ReadOnly(namespace, name string) bool {
+	return p.readOnly
+}
+
+func (p *readOnlyPermissionsProvider) Role(namespace, name string) string {
+	return "analytics"
+}
+
+// TestHandler_Authorize_ReadOnly tests that read-only identities get no publish permissions
+// and no scoped signing key role
+func TestHandler_Authorize_ReadOnly(t *testing.T) {
+	jwtValidator := &mockJWTValidator{
+		validateFunc: func(token string) (*jwt.Claims, error) {
+			return &jwt.Claims{Namespace: "production", ServiceAccount: "app"}, nil
+		},
+	}
+
+	for _, readOnly := range []bool{false, true} {
+		permProvider := &readOnlyPermissionsProvider{
+			mockPermissionsProvider: mockPermissionsProvider{
+				getPermissionsFunc: func(namespace, name string) ([]string, []string, bool) {
+					return []string{"production.>"}, []string{"production.>", "_INBOX.>"}, true
+				},
+			},
+			readOnly: readOnly,
+		}
+		handler := NewHandler(jwtValidator, permProvider)
+
+		resp := handler.Authorize(&AuthRequest{Token: "valid.jwt.token"})
+		if !resp.Allowed {
+			t.Fatalf("Expected authorization to be allowed, got %q", resp.Reason)
+		}
+		if resp.ReadOnly != readOnly {
+			t.Errorf("ReadOnly = %v, want %v", resp.ReadOnly, readOnly)
+		}
+		if got := len(resp.PublishPermissions) == 0; got != readOnly {
+			t.Errorf("with read-only %v: PublishPermissions = %v", readOnly, resp.PublishPermissions)
+		}
+		if len(resp.SubscribePermissions) != 2 {
+			t.Errorf("with read-only %v: SubscribePermissions = %v, want 2 subjects", readOnly, resp.SubscribePermissions)
+		}
+		if wantRole := map[bool]string{false: "analytics", true: ""}[readOnly]; resp.Role != wantRole {
+			t.Errorf("with read-only %v: Role = %q, want %q", readOnly, resp.Role, wantRole)
+		}
+	}
+}
+
+// jsAdminPermissionsProvider is a permissions provider that marks identities as JetStream
+// administrators
+type jsAdminPermissionsProvider struct {
+	mockPermissionsProvider
+	admin bool
+}
+
+func (p *jsAdminPermissionsProvider) JetStreamAdmin(namespace, name string) bool {
+	return p.admin
+}
+
+// TestHandler_Authorize_JetStreamAPIProtection tests that the JetStream admin API is denied
+// unless the identity is an administrator or the protection is disabled
+func TestHandler_Authorize_JetStreamAPIProtection(t *testing.T) {
+	jwtValidator := &mockJWTValidator{
+		validateFunc: func(token string) (*jwt.Claims, error) {
+			return &jwt.Claims{Namespace: "production", ServiceAccount: "app"}, nil
+		},
+	}
+	getPermissions := func(namespace, name string) ([]string, []string, bool) {
+		return []string{">"}, []string{"_INBOX.>"}, true
+	}
+
+	tests := []struct {
+		name       string
+		provider   PermissionsProvider
+		disabled   bool
+		wantDenied bool
+	}{
+		{name: "provider without policy", provider: &mockPermissionsProvider{getPermissionsFunc: getPermissions}, wantDenied: true},
+		{name: "not an administrator", provider: &jsAdminPermissionsProvider{mockPermissionsProvider{getPermissionsFunc: getPermissions}, false}, wantDenied: true},
+		{name: "administrator", provider: &jsAdminPermissionsProvider{mockPermissionsProvider{getPermissionsFunc: getPermissions}, true}},
+		{name: "protection disabled", provider: &mockPermissionsProvider{getPermissionsFunc: getPermissions}, disabled: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := NewHandler(jwtValidator, tt.provider)
+			if tt.disabled {
+				handler.SetJetStreamAPIProtection(false)
+			}
+
+			resp := handler.Authorize(&AuthRequest{Token: "valid.jwt.token"})
+			if !resp.Allowed {
+				t.Fatalf("Expected authorization to be allowed, got %q", resp.Reason)
+			}
+			if denied := equalStringSlices(resp.PublishDenied, JetStreamAdminSubjects); denied != tt.wantDenied {
+				t.Errorf("PublishDenied = %v, want JetStream admin subjects denied = %v", resp.PublishDenied, tt.wantDenied)
+			}
+		})
+	}
+}
+
+// denyPermissionsProvider is a permissions provider that denies its identities subjects
+type denyPermissionsProvider struct {
+	mockPermissionsProvider
+	pub, sub []string
+}
+
+func (p *denyPermissionsProvider) DeniedSubjects(namespace, name string) (publish, subscribe []string) {
+	return p.pub, p.sub
+}
+
+// TestHandler_Authorize_DeniedSubjects tests that the provider's denied subjects are returned
+// alongside the JetStream admin API protection
+func TestHandler_Authorize_DeniedSubjects(t *testing.T) {
+	jwtValidator := &mockJWTValidator{
+		validateFunc: func(token string) (*jwt.Claims, error) {
+			return &jwt.Claims{Namespace: "events", ServiceAccount: "consumer"}, nil
+		},
+	}
+	provider := &denyPermissionsProvider{
+		mockPermissionsProvider: mockPermissionsProvider{getPermissionsFunc: func(namespace, name string) ([]string, []string, bool) {
+			return []string{"events.>"}, []string{"events.>"}, true
+		}},
+		pub: []string{"events.internal.>"},
+		sub: []string{"events.internal.>"},
+	}
+	handler := NewHandler(jwtValidator, provider)
+
+	resp := handler.Authorize(&AuthRequest{Token: "valid.jwt.token"})
+	if !resp.Allowed {
+		t.Fatalf("Expected authorization to be allowed, got %q", resp.Reason)
+	}
+	wantPub := append([]string{"events.internal.>"}, JetStreamAdminSubjects...)
+	if !equalStringSlices(resp.PublishDenied, wantPub) {
+		t.Errorf("PublishDenied = %v, want %v", resp.PublishDenied, wantPub)
+	}
+	if !equalStringSlices(resp.SubscribeDenied, provider.sub) {
+		t.Errorf("SubscribeDenied = %v, want %v", resp.SubscribeDenied, provider.sub)
+	}
+	if len(provider.pub) != 1 {
+		t.Errorf("provider's denied subjects modified to %v", provider.pub)
+	}
+}
+
+// tlsPermissionsProvider is a permissions provider that requires TLS for its identities
+type tlsPermissionsProvider struct {
+	mockPermissionsProvider
+	requireTLS bool
+}
+
+func (p *tlsPermissionsProvider) RequireTLS(namespace, name string) bool {
+	return p.requireTLS
+}
+
+// TestHandler_Authorize_Transport tests that connections are denied unless they arrive over TLS
+// where required, globally or by the provider, and over an allowed listener type
+func TestHandler_Authorize_Transport(t *testing.T) {
+	jwtValidator := &mockJWTValidator{
+		validateFunc: func(token string) (*jwt.Claims, error) {
+			return &jwt.Claims{Namespace: "production", ServiceAccount: "app"}, nil
+		},
+	}
+	getPermissions := func(namespace, name string) ([]string, []string, bool) {
+		return []string{"production.>"}, []string{"_INBOX.>"}, true
+	}
+
+	tests := []struct {
+		name        string
+		requireTLS  bool
+		providerTLS bool
+		types       []string
+		req         AuthRequest
+		wantAllowed bool
+	}{
+		{name: "no requirements", req: AuthRequest{ConnectionType: ConnectionNATS}, wantAllowed: true},
+		{name: "TLS required globally", requireTLS: true, req: AuthRequest{ConnectionType: ConnectionNATS}},
+		{name: "TLS required globally over TLS", requireTLS: true, req: AuthRequest{ConnectionType: ConnectionNATS, TLS: true}, wantAllowed: true},
+		{name: "TLS required by provider", providerTLS: true, req: AuthRequest{ConnectionType: ConnectionNATS}},
+		{name: "TLS required by provider over TLS", providerTLS: true, req: AuthRequest{ConnectionType: ConnectionNATS, TLS: true}, wantAllowed: true},
+		{name: "allowed type", types: []string{ConnectionNATS, ConnectionLeafnode}, req: AuthRequest{ConnectionType: ConnectionLeafnode}, wantAllowed: true},
+		{name: "disallowed type", types: []string{ConnectionNATS}, req: AuthRequest{ConnectionType: ConnectionWebSocket, TLS: true}},
+		{name: "authorization API", requireTLS: true, providerTLS: true, types: []string{ConnectionNATS}, req: AuthRequest{ConnectionType: ConnectionAPI}, wantAllowed: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := NewHandler(jwtValidator, &tlsPermissionsProvider{
+				mockPermissionsProvider: mockPermissionsProvider{getPermissionsFunc: getPermissions},
+				requireTLS:              tt.providerTLS,
+			})
+			handler.SetRequireTLS(tt.requireTLS)
+			handler.SetAllowedConnectionTypes(tt.types)
+
+			tt.req.Token = "valid.jwt.token"
+			resp := handler.Authorize(&tt.req)
+			if resp.Allowed != tt.wantAllowed {
+				t.Errorf("Allowed = %v, want %v", resp.Allowed, tt.wantAllowed)
+			}
+			if !tt.wantAllowed && resp.Reason != ReasonTransportDenied {
+				t.Errorf("Reason = %q, want %q", resp.Reason, ReasonTransportDenied)
+			}
+		})
+	}
+}
+
+// nodePermissionsProvider is a permissions provider that restricts its identities to nodes
+type nodePermissionsProvider struct {
+	mockPermissionsProvider
+	selector map[string]string
+	nodes    map[string]map[string]string
+}
+
+func (p *nodePermissionsProvider) NodeSelector(namespace, name string) map[string]string {
+	return p.selector
+}
+
+func (p *nodePermissionsProvider) NodeLabels(node string) (map[string]string, bool) {
+	labels, found := p.nodes[node]
+	return labels, found
+}
+
+// TestHandler_Authorize_NodeSelector tests that tokens are denied unless their pod runs on a
+// node with the labels required globally and by the provider
+func TestHandler_Authorize_NodeSelector(t *testing.T) {
+	nodes := map[string]map[string]string{
+		"trusted-1":   {"trusted": "true", "pool": "secure"},
+		"untrusted-1": {"pool": "general"},
+	}
+	getPermissions := func(namespace, name string) ([]string, []string, bool) {
+		return []string{"production.>"}, []string{"_INBOX.>"}, true
+	}
+
+	tests := []struct {
+		name        string
+		global      map[string]string
+		provider    map[string]string
+		node        string
+		wantAllowed bool
+	}{
+		{name: "no selector", node: "", wantAllowed: true},
+		{name: "global selector matches", global: map[string]string{"trusted": "true"}, node: "trusted-1", wantAllowed: true},
+		{name: "global selector does not match", global: map[string]string{"trusted": "true"}, node: "untrusted-1"},
+		{name: "provider selector matches", provider: map[string]string{"pool": "secure"}, node: "trusted-1", wantAllowed: true},
+		{name: "provider selector does not match", provider: map[string]string{"pool": "secure"}, node: "untrusted-1"},
+		{name: "both selectors", global: map[string]string{"trusted": "true"}, provider: map[string]string{"pool": "general"}, node: "trusted-1"},
+		{name: "token without node", global: map[string]string{"trusted": "true"}, node: ""},
+		{name: "unknown node", provider: map[string]string{"pool": "secure"}, node: "deleted-1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jwtValidator := &mockJWTValidator{
+				validateFunc: func(token string) (*jwt.Claims, error) {
+					return &jwt.Claims{Namespace: "production", ServiceAccount: "app", Node: tt.node}, nil
+				},
+			}
+			handler := NewHandler(jwtValidator, &nodePermissionsProvider{
+				mockPermissionsProvider: mockPermissionsProvider{getPermissionsFunc: getPermissions},
+				selector:                tt.provider,
+				nodes:                   nodes,
+			})
+			handler.SetNodeSelector(tt.global)
+
+			resp := handler.Authorize(&AuthRequest{Token: "valid.jwt.token"})
+			if resp.Allowed != tt.wantAllowed {
+				t.Errorf("Allowed = %v, want %v", resp.Allowed, tt.wantAllowed)
+			}
+			if !tt.wantAllowed && resp.Reason != ReasonNodeDenied {
+				t.Errorf("Reason = %q, want %q", resp.Reason, ReasonNodeDenied)
+			}
+		})
+	}
+
+	// A global selector denies providers that cannot tell node labels
+	handler := NewHandler(&mockJWTValidator{
+		validateFunc: func(token string) (*jwt.Claims, error) {
+			return &jwt.Claims{Namespace: "production", ServiceAccount: "app", Node: "trusted-1"}, nil
+		},
+	}, &mockPermissionsProvider{getPermissionsFunc: getPermissions})
+	handler.SetNodeSelector(map[string]string{"trusted": "true"})
+	if resp := handler.Authorize(&AuthRequest{Token: "valid.jwt.token"}); resp.Allowed {
+		t.Error("Allowed = true without node labels, want denied")
+	}
+}
+
+// ttlPermissionsProvider is a permissions provider that requests a shorter user JWT lifetime
+type ttlPermissionsProvider struct {
+	mockPermissionsProvider
+	ttl time.Duration
+}
+
+func (p *ttlPermissionsProvider) TokenTTL(namespace, name string) time.Duration {
+	return p.ttl
+}
+
+// TestHandler_Authorize_TokenTTL tests that the provider's token lifetime is passed through
+func TestHandler_Authorize_TokenTTL(t *testing.T) {
+	jwtValidator := &mockJWTValidator{
+		validateFunc: func(token string) (*jwt.Claims, error) {
+			return &jwt.Claims{Namespace: "production", ServiceAccount: "app"}, nil
+		},
+	}
+	permProvider := &ttlPermissionsProvider{
+		mockPermissionsProvider: mockPermissionsProvider{
+			getPermissionsFunc: func(namespace, name string) ([]string, []string, bool) {
+				return []string{"production.>"}, []string{"_INBOX.>"}, true
+			},
+		},
+		ttl: time.Minute,
+	}
+
+	resp := NewHandler(jwtValidator, permProvider).Authorize(&AuthRequest{Token: "valid.jwt.token"})
+	if resp.TokenTTL != time.Minute {
+		t.Errorf("TokenTTL = %v, want %v", resp.TokenTTL, time.Minute)
+	}
+}
+
+// classPermissionsProvider is a permissions provider that assigns a request-reply class
+type classPermissionsProvider struct {
+	mockPermissionsProvider
+	class string
+}
+
+func (p *classPermissionsProvider) Class(namespace, name string) string {
+	return p.class
+}
+
+// TestHandler_Authorize_Class tests that the provider's request-reply class is passed through
+func TestHandler_Authorize_Class(t *testing.T) {
+	jwtValidator := &mockJWTValidator{
+		validateFunc: func(token string) (*jwt.Claims, error) {
+			return &jwt.Claims{Namespace: "production", ServiceAccount: "app"}, nil
+		},
+	}
+	permProvider := &classPermissionsProvider{
+		mockPermissionsProvider: mockPermissionsProvider{
+			getPermissionsFunc: func(namespace, name string) ([]string, []string, bool) {
+				return nil, []string{"_INBOX.>"}, true
+			},
+		},
+		class: ClassResponder,
+	}
+
+	resp := NewHandler(jwtValidator, permProvider).Authorize(&AuthRequest{Token: "valid.jwt.token"})
+	if resp.Class != ClassResponder {
+		t.Errorf("Class = %q, want %q", resp.Class, ClassResponder)
+	}
+}
+
+// limitsPermissionsProvider is a permissions provider that requests user limits
+type limitsPermissionsProvider struct {
+	mockPermissionsProvider
+}
+
+func (p *limitsPermissionsProvider) UserLimits(namespace, name string) (subs, payload, data int64) {
+	return 10, 1024, 0
+}
+
+// TestHandler_Authorize_UserLimits tests that the provider's user limits are passed through
+func TestHandler_Authorize_UserLimits(t *testing.T) {
+	jwtValidator := &mockJWTValidator{
+		validateFunc: func(token string) (*jwt.Claims, error) {
+			return &jwt.Claims{Namespace: "production", ServiceAccount: "app"}, nil
+		},
+	}
+	permProvider := &limitsPermissionsProvider{
+		mockPermissionsProvider: mockPermissionsProvider{
+			getPermissionsFunc: func(namespace, name string) ([]string, []string, bool) {
+				return []string{"production.>"}, []string{"_INBOX.>"}, true
+			},
+		},
+	}
+
+	resp := NewHandler(jwtValidator, permProvider).Authorize(&AuthRequest{Token: "valid.jwt.token"})
+	if want := (UserLimits{Subscriptions: 10, Payload: 1024}); resp.Limits != want {
+		t.Errorf("Limits = %+v, want %+v", resp.Limits, want)
+	}
+}
+
+// syncingPermissionsProvider is a permissions provider that reports its sync state
+type syncingPermissionsProvider struct {
+	mockPermissionsProvider
+	synced bool
+}
+
+func (p *syncingPermissionsProvider) HasSynced() bool {
+	return p.synced
+}
+
+// TestHandler_Authorize_CacheNotSynced tests that misses before sync are retryable
+func TestHandler_Authorize_CacheNotSynced(t *testing.T) {
+	jwtValidator := &mockJWTValidator{
+		validateFunc: func(token string) (*jwt.Claims, error) {
+			return &jwt.Claims{Namespace: "production", ServiceAccount: "app"}, nil
+		},
+	}
+
+	tests := []struct {
+		name       string
+		synced     bool
+		wantReason ReasonCode
+	}{
+		{name: "not synced", synced: false, wantReason: ReasonCacheNotSynced},
+		{name: "synced", synced: true, wantReason: ReasonUnknownServiceAccount},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			permProvider := &syncingPermissionsProvider{
+				mockPermissionsProvider: mockPermissionsProvider{
+					getPermissionsFunc: func(namespace, name string) ([]string, []string, bool) {
+						return nil, nil, false
+					},
+				},
+				synced: tt.synced,
+			}
+
+			resp := NewHandler(jwtValidator, permProvider).Authorize(&AuthRequest{Token: "valid.jwt.token"})
+			if resp.Allowed {
+				t.Fatal("Expected authorization to be denied")
+			}
+			if resp.Reason != tt.wantReason {
+				t.Errorf("Reason = %q, want %q", resp.Reason, tt.wantReason)
+			}
+		})
 	}
 }
 
@@ -243,3 +1191,117 @@ func equalStringSlices(a, b []string) bool {
 	}
 	return true
 }
+
+// rolePermissionsProvider is a permissions provider that selects a scoped signing key role
+type rolePermissionsProvider struct {
+	mockPermissionsProvider
+	role string
+}
+
+func (p *rolePermissionsProvider) Role(namespace, name string) string {
+	return p.role
+}
+
+// TestHandler_Authorize_Role tests that the provider's scoped signing key role is passed through
+func TestHandler_Authorize_Role(t *testing.T) {
+	jwtValidator := &mockJWTValidator{
+		validateFunc: func(token string) (*jwt.Claims, error) {
+			return &jwt.Claims{Namespace: "production", ServiceAccount: "app"}, nil
+		},
+	}
+	permProvider := &rolePermissionsProvider{
+		mockPermissionsProvider: mockPermissionsProvider{
+			getPermissionsFunc: func(namespace, name string) ([]string, []string, bool) {
+				return []string{"production.>"}, []string{"_INBOX.>"}, true
+			},
+		},
+		role: "orders-reader",
+	}
+
+	resp := NewHandler(jwtValidator, permProvider).Authorize(&AuthRequest{Token: "valid.jwt.token"})
+	if resp.Role != "orders-reader" {
+		t.Errorf("Role = %q, want %q", resp.Role, "orders-reader")
+	}
+}
+
+// TestHandler_Authorize_Timings tests that the time spent validating the JWT is reported
+func TestHandler_Authorize_Timings(t *testing.T) {
+	jwtValidator := &mockJWTValidator{
+		validateFunc: func(token string) (*jwt.Claims, error) {
+			time.Sleep(20 * time.Millisecond)
+			return &jwt.Claims{Namespace: "production", ServiceAccount: "app"}, nil
+		},
+	}
+	permProvider := &mockPermissionsProvider{
+		getPermissionsFunc: func(namespace, name string) ([]string, []string, bool) {
+			return []string{"production.>"}, []string{"_INBOX.>"}, true
+		},
+	}
+
+	resp := NewHandler(jwtValidator, permProvider).Authorize(&AuthRequest{Token: "valid.jwt.token"})
+	if resp.Timings.Validation < 20*time.Millisecond {
+		t.Errorf("Timings.Validation = %v, want at least 20ms", resp.Timings.Validation)
+	}
+	if resp.Timings.Permissions < 0 {
+		t.Errorf("Timings.Permissions = %v, want non-negative", resp.Timings.Permissions)
+	}
+}
+
+// TestHandler_Authorize_Identity tests that the identity of a validated token is reported,
+// including when the request is then denied
+func TestHandler_Authorize_Identity(t *testing.T) {
+	tests := []struct {
+		name         string
+		claims       *jwt.Claims
+		found        bool
+		wantIdentity string
+	}{
+		{
+			name:         "ServiceAccount",
+			claims:       &jwt.Claims{Namespace: "production", ServiceAccount: "app"},
+			found:        true,
+			wantIdentity: "production/app",
+		},
+		{
+			name:         "unknown ServiceAccount",
+			claims:       &jwt.Claims{Namespace: "production", ServiceAccount: "ghost"},
+			wantIdentity: "production/ghost",
+		},
+		{
+			name:         "non-Kubernetes subject",
+			claims:       &jwt.Claims{Subject: "ci-runner"},
+			found:        true,
+			wantIdentity: "ci-runner",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jwtValidator := &mockJWTValidator{
+				validateFunc: func(token string) (*jwt.Claims, error) { return tt.claims, nil },
+			}
+			permProvider := &mockPermissionsProvider{
+				getPermissionsFunc: func(namespace, name string) ([]string, []string, bool) {
+					return nil, nil, tt.found
+				},
+			}
+
+			resp := NewHandler(jwtValidator, permProvider).Authorize(&AuthRequest{Token: "valid.jwt.token"})
+			if resp.Identity != tt.wantIdentity {
+				t.Errorf("Identity = %q, want %q", resp.Identity, tt.wantIdentity)
+			}
+			if resp.Namespace != tt.claims.Namespace || resp.ServiceAccount != tt.claims.ServiceAccount {
+				t.Errorf("Namespace, ServiceAccount = %q, %q, want %q, %q",
+					resp.Namespace, resp.ServiceAccount, tt.claims.Namespace, tt.claims.ServiceAccount)
+			}
+		})
+	}
+
+	jwtValidator := &mockJWTValidator{
+		validateFunc: func(token string) (*jwt.Claims, error) { return nil, jwt.ErrExpiredToken },
+	}
+	resp := NewHandler(jwtValidator, &mockPermissionsProvider{}).Authorize(&AuthRequest{Token: "expired.jwt.token"})
+	if resp.Identity != "" {
+		t.Errorf("Identity = %q for an invalid token, want empty", resp.Identity)
+	}
+}
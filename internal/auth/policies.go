@@ -0,0 +1,157 @@
+package auth
+
+import "time"
+
+// Policies forwards the optional policies of a wrapped permissions provider, such as a fault
+// injecting or comparing wrapper, so that embedding it keeps every policy the wrapped provider
+// implements. A policy the wrapped provider does not implement reports the handler's default.
+// New optional policy interfaces must be forwarded here.
+type Policies struct {
+	Provider PermissionsProvider
+}
+
+var (
+	_ SyncStatus            = Policies{}
+	_ AccessSwitch          = Policies{}
+	_ BearerPolicy          = Policies{}
+	_ ReadOnlyPolicy        = Policies{}
+	_ DenyPolicy            = Policies{}
+	_ AccountPolicy         = Policies{}
+	_ TokenTTLPolicy        = Policies{}
+	_ LimitsPolicy          = Policies{}
+	_ ConnectionLimitPolicy = Policies{}
+	_ ClassPolicy           = Policies{}
+	_ RolePolicy            = Policies{}
+	_ JetStreamAdminPolicy  = Policies{}
+	_ TransportPolicy       = Policies{}
+	_ NodePolicy            = Policies{}
+	_ WorkloadResolver      = Policies{}
+)
+
+// HasSynced forwards the wrapped provider's sync state, if it has one
+func (p Policies) HasSynced() bool {
+	if status, ok := p.Provider.(SyncStatus); ok {
+		return status.HasSynced()
+	}
+	return true
+}
+
+// Disabled forwards the wrapped provider's access switch, if it has one
+func (p Policies) Disabled(namespace, name string) bool {
+	if access, ok := p.Provider.(AccessSwitch); ok {
+		return access.Disabled(namespace, name)
+	}
+	return false
+}
+
+// Bearer forwards the wrapped provider's bearer policy, if it has one
+func (p Policies) Bearer(namespace, name string) bool {
+	if policy, ok := p.Provider.(BearerPolicy); ok {
+		return policy.Bearer(namespace, name)
+	}
+	return false
+}
+
+// ReadOnly forwards the wrapped provider's read-only policy, if it has one
+func (p Policies) ReadOnly(namespace, name string) bool {
+	if policy, ok := p.Provider.(ReadOnlyPolicy); ok {
+		return policy.ReadOnly(namespace, name)
+	}
+	return false
+}
+
+// DeniedSubjects forwards the wrapped provider's deny policy, if it has one
+func (p Policies) DeniedSubjects(namespace, name string) (publish, subscribe []string) {
+	if policy, ok := p.Provider.(DenyPolicy); ok {
+		return policy.DeniedSubjects(namespace, name)
+	}
+	return nil, nil
+}
+
+// Account forwards the wrapped provider's account policy, if it has one
+func (p Policies) Account(namespace, name string) string {
+	if policy, ok := p.Provider.(AccountPolicy); ok {
+		return policy.Account(namespace, name)
+	}
+	return ""
+}
+
+// TokenTTL forwards the wrapped provider's token lifetime policy, if it has one
+func (p Policies) TokenTTL(namespace, name string) time.Duration {
+	if policy, ok := p.Provider.(TokenTTLPolicy); ok {
+		return policy.TokenTTL(namespace, name)
+	}
+	return 0
+}
+
+// UserLimits forwards the wrapped provider's user limits policy, if it has one
+func (p Policies) UserLimits(namespace, name string) (subs, payload, data int64) {
+	if policy, ok := p.Provider.(LimitsPolicy); ok {
+		return policy.UserLimits(namespace, name)
+	}
+	return 0, 0, 0
+}
+
+// MaxConnections forwards the wrapped provider's connection limit policy, if it has one
+func (p Policies) MaxConnections(namespace, name string) int {
+	if policy, ok := p.Provider.(ConnectionLimitPolicy); ok {
+		return policy.MaxConnections(namespace, name)
+	}
+	return 0
+}
+
+// Class forwards the wrapped provider's request-reply class policy, if it has one
+func (p Policies) Class(namespace, name string) string {
+	if policy, ok := p.Provider.(ClassPolicy); ok {
+		return policy.Class(namespace, name)
+	}
+	return ""
+}
+
+// Role forwards the wrapped provider's scoped signing key role policy, if it has one
+func (p Policies) Role(namespace, name string) string {
+	if policy, ok := p.Provider.(RolePolicy); ok {
+		return policy.Role(namespace, name)
+	}
+	return ""
+}
+
+// JetStreamAdmin forwards the wrapped provider's JetStream administrator policy, if it has one
+func (p Policies) JetStreamAdmin(namespace, name string) bool {
+	if policy, ok := p.Provider.(JetStreamAdminPolicy); ok {
+		return policy.JetStreamAdmin(namespace, name)
+	}
+	return false
+}
+
+// RequireTLS forwards the wrapped provider's transport policy, if it has one
+func (p Policies) RequireTLS(namespace, name string) bool {
+	if policy, ok := p.Provider.(TransportPolicy); ok {
+		return policy.RequireTLS(namespace, name)
+	}
+	return false
+}
+
+// NodeSelector forwards the wrapped provider's node policy, if it has one
+func (p Policies) NodeSelector(namespace, name string) map[string]string {
+	if policy, ok := p.Provider.(NodePolicy); ok {
+		return policy.NodeSelector(namespace, name)
+	}
+	return nil
+}
+
+// NodeLabels forwards the wrapped provider's node labels, if it has them
+func (p Policies) NodeLabels(node string) (map[string]string, bool) {
+	if policy, ok := p.Provider.(NodePolicy); ok {
+		return policy.NodeLabels(node)
+	}
+	return nil, false
+}
+
+// Workload forwards the wrapped provider's workload resolver, if it has one
+func (p Policies) Workload(namespace, pod string) (string, bool) {
+	if resolver, ok := p.Provider.(WorkloadResolver); ok {
+		return resolver.Workload(namespace, pod)
+	}
+	return "", false
+}
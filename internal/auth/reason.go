@@ -0,0 +1,83 @@
+package auth
+
+// ReasonCode is a machine-readable authorization outcome. It is set by the handler on
+// every response and used as-is in logs, metrics labels, audit records and (via
+// Message) the signed NATS authorization error response.
+type ReasonCode string
+
+// Reason codes
+const (
+	ReasonAllowed               ReasonCode = "allowed"
+	ReasonMissingToken          ReasonCode = "missing_token"
+	ReasonTokenExpired          ReasonCode = "token_expired"
+	ReasonInvalidSignature      ReasonCode = "invalid_signature"
+	ReasonWrongIssuer           ReasonCode = "wrong_issuer"
+	ReasonWrongAudience         ReasonCode = "wrong_audience"
+	ReasonInvalidToken          ReasonCode = "invalid_token"
+	ReasonUnknownServiceAccount ReasonCode = "unknown_serviceaccount"
+	ReasonCacheNotSynced        ReasonCode = "cache_not_synced"
+	ReasonNamespaceDenied       ReasonCode = "namespace_denied"
+	ReasonSystemNamespace       ReasonCode = "system_namespace"
+	ReasonAccessDisabled        ReasonCode = "access_disabled"
+	ReasonInternalError         ReasonCode = "internal_error"
+	ReasonDeadlineExceeded      ReasonCode = "deadline_exceeded"
+	ReasonUnknownRole           ReasonCode = "unknown_role"
+	ReasonTransportDenied       ReasonCode = "transport_denied"
+	ReasonNodeDenied            ReasonCode = "node_denied"
+	ReasonNkeyUnverified        ReasonCode = "nkey_unverified"
+	ReasonQuotaExceeded         ReasonCode = "quota_exceeded"
+	ReasonConnectionLimit       ReasonCode = "connection_limit"
+	ReasonRateLimited           ReasonCode = "rate_limited"
+	ReasonUnknownAccount        ReasonCode = "unknown_account"
+)
+
+// reasonMessages are the client-facing descriptions of each reason code
+var reasonMessages = map[ReasonCode]string{
+	ReasonAllowed:               "authorized",
+	ReasonMissingToken:          "authorization failed: no token provided",
+	ReasonTokenExpired:          "authorization failed: token expired",
+	ReasonInvalidSignature:      "authorization failed: token signature invalid",
+	ReasonWrongIssuer:           "authorization failed: token issuer not accepted",
+	ReasonWrongAudience:         "authorization failed: token audience not accepted",
+	ReasonInvalidToken:          "authorization failed: token invalid",
+	ReasonUnknownServiceAccount: "authorization failed: ServiceAccount not found",
+	ReasonCacheNotSynced:        "authorization failed: ServiceAccount cache not yet synced, retry",
+	ReasonNamespaceDenied:       "authorization failed: namespace not allowed",
+	ReasonSystemNamespace:       "authorization failed: infrastructure namespace not allowed",
+	ReasonAccessDisabled:        "authorization failed: NATS access disabled",
+	ReasonInternalError:         "authorization failed: internal error",
+	ReasonDeadlineExceeded:      "authorization failed: request deadline exceeded, retry",
+	ReasonUnknownRole:           "authorization failed: signing role not available",
+	ReasonTransportDenied:       "authorization failed: connection must use TLS or an allowed listener",
+	ReasonNodeDenied:            "authorization failed: pod not running on an allowed node",
+	ReasonNkeyUnverified:        "authorization failed: nkey signature of the server nonce missing or invalid",
+	ReasonQuotaExceeded:         "authorization failed: ServiceAccount issuance quota exceeded, retry later",
+	ReasonConnectionLimit:       "authorization failed: ServiceAccount connection limit reached",
+	ReasonRateLimited:           "authorization failed: too many authorization requests, retry later",
+	ReasonUnknownAccount:        "authorization failed: selected NATS account not served",
+}
+
+// Message returns the client-facing description of the reason code.
+func (r ReasonCode) Message() string {
+	if msg, ok := reasonMessages[r]; ok {
+		return msg
+	}
+	return "authorization failed"
+}
+
+// Known reports whether the reason code is one the handler sets.
+func (r ReasonCode) Known() bool {
+	_, ok := reasonMessages[r]
+	return ok
+}
+
+// Internal reports whether the reason code is a failure of the service itself rather than a
+// rejection of the client's credentials.
+func (r ReasonCode) Internal() bool {
+	switch r {
+	case ReasonInternalError, ReasonDeadlineExceeded, ReasonUnknownRole:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,44 @@
+package auth
+
+import "testing"
+
+func TestReasonCode_Message(t *testing.T) {
+	tests := []struct {
+		reason ReasonCode
+		want   string
+	}{
+		{reason: ReasonTokenExpired, want: "authorization failed: token expired"},
+		{reason: ReasonUnknownServiceAccount, want: "authorization failed: ServiceAccount not found"},
+		{reason: ReasonCode("something_new"), want: "authorization failed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.reason), func(t *testing.T) {
+			if got := tt.reason.Message(); got != tt.want {
+				t.Errorf("Message() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReasonCode_Internal(t *testing.T) {
+	for _, reason := range []ReasonCode{ReasonInternalError, ReasonDeadlineExceeded, ReasonUnknownRole} {
+		if !reason.Internal() {
+			t.Errorf("%s.Internal() = false, want true", reason)
+		}
+	}
+	for _, reason := range []ReasonCode{ReasonAllowed, ReasonTokenExpired, ReasonCacheNotSynced} {
+		if reason.Internal() {
+			t.Errorf("%s.Internal() = true, want false", reason)
+		}
+	}
+}
+
+func TestReasonCode_Known(t *testing.T) {
+	if !ReasonTransportDenied.Known() {
+		t.Error("ReasonTransportDenied.Known() = false, want true")
+	}
+	if ReasonCode("something_new").Known() {
+		t.Error("something_new.Known() = true, want false")
+	}
+}
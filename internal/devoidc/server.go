@@ -0,0 +1,243 @@
+// Package devoidc provides an in-process mock OIDC issuer for local development and
+// integration tests. It serves a JWKS endpoint and mints Kubernetes-style ServiceAccount
+// tokens, so the URL-based JWT validator (refresh, unknown key ID handling) can be
+// exercised without a Kubernetes cluster.
+//
+// This package is for development only: it issues tokens to anyone who asks.
+package devoidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+)
+
+const (
+	// JWKSPath is the path of the JWKS endpoint, matching the Kubernetes API server
+	JWKSPath = "/openid/v1/jwks"
+	// DiscoveryPath is the path of the OIDC discovery document
+	DiscoveryPath = "/.well-known/openid-configuration"
+	// TokenPath is the path of the token issuing endpoint
+	TokenPath = "/token"
+
+	// DefaultTokenTTL is the lifetime of tokens issued by the token endpoint
+	DefaultTokenTTL = time.Hour
+)
+
+// signingKey is an RSA key with its key ID
+type signingKey struct {
+	kid string
+	key *rsa.PrivateKey
+}
+
+// Server is an in-process mock OIDC issuer.
+type Server struct {
+	mu         sync.RWMutex
+	keys       []*signingKey // all keys are published; the last one signs new tokens
+	listener   net.Listener
+	httpServer *http.Server
+	issuer     string
+	logger     *zap.Logger
+}
+
+// New creates a mock OIDC server listening on addr (e.g. "127.0.0.1:8081" or "127.0.0.1:0").
+// The issuer URL is derived from the actual listen address. Call Start to begin serving.
+func New(addr string, logger *zap.Logger) (*Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+
+	s := &Server{
+		listener: listener,
+		issuer:   "http://" + listener.Addr().String(),
+		logger:   logger,
+	}
+
+	if err := s.RotateKey(); err != nil {
+		_ = listener.Close()
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(DiscoveryPath, s.handleDiscovery)
+	mux.HandleFunc(JWKSPath, s.handleJWKS)
+	mux.HandleFunc(TokenPath, s.handleToken)
+
+	s.httpServer = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	return s, nil
+}
+
+// Start begins serving requests in the background.
+func (s *Server) Start() {
+	go func() {
+		if err := s.httpServer.Serve(s.listener); err != nil && err != http.ErrServerClosed {
+			s.logger.Error("mock OIDC server failed", zap.Error(err))
+		}
+	}()
+}
+
+// Shutdown stops the server.
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.httpServer.Shutdown(ctx)
+}
+
+// Issuer returns the issuer URL placed in the iss claim of issued tokens.
+func (s *Server) Issuer() string {
+	return s.issuer
+}
+
+// JWKSURL returns the URL of the JWKS endpoint.
+func (s *Server) JWKSURL() string {
+	return s.issuer + JWKSPath
+}
+
+// RotateKey generates a new signing key. Previously generated keys stay published
+// in the JWKS, so tokens signed before the rotation remain valid.
+func (s *Server) RotateKey() error {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return fmt.Errorf("failed to generate signing key: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.keys = append(s.keys, &signingKey{
+		kid: fmt.Sprintf("dev-%d", len(s.keys)+1),
+		key: key,
+	})
+	return nil
+}
+
+// IssueToken mints a Kubernetes-style ServiceAccount token signed with the current key.
+func (s *Server) IssueToken(namespace, serviceAccount string, audience []string, ttl time.Duration) (string, error) {
+	s.mu.RLock()
+	current := s.keys[len(s.keys)-1]
+	s.mu.RUnlock()
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss": s.issuer,
+		"sub": fmt.Sprintf("system:serviceaccount:%s:%s", namespace, serviceAccount),
+		"aud": audience,
+		"iat": now.Unix(),
+		"nbf": now.Unix(),
+		"exp": now.Add(ttl).Unix(),
+		"kubernetes.io": map[string]interface{}{
+			"namespace": namespace,
+			"serviceaccount": map[string]string{
+				"name": serviceAccount,
+			},
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = current.kid
+
+	signed, err := token.SignedString(current.key)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign token: %w", err)
+	}
+	return signed, nil
+}
+
+// handleDiscovery serves a minimal OIDC discovery document.
+func (s *Server) handleDiscovery(w http.ResponseWriter, r *http.Request) {
+	s.writeJSON(w, map[string]interface{}{
+		"issuer":                                s.issuer,
+		"jwks_uri":                              s.JWKSURL(),
+		"response_types_supported":              []string{"id_token"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+	})
+}
+
+// handleJWKS serves the public halves of all signing keys.
+func (s *Server) handleJWKS(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	keys := make([]map[string]string, 0, len(s.keys))
+	for _, k := range s.keys {
+		keys = append(keys, map[string]string{
+			"kty": "RSA",
+			"alg": "RS256",
+			"use": "sig",
+			"kid": k.kid,
+			"n":   base64.RawURLEncoding.EncodeToString(k.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.key.E)).Bytes()),
+		})
+	}
+	s.mu.RUnlock()
+
+	s.writeJSON(w, map[string]interface{}{"keys": keys})
+}
+
+// handleToken issues a token for the ServiceAccount given in the query string:
+//
+//	GET /token?namespace=foo&serviceaccount=bar[&audience=nats][&ttl=10m]
+func (s *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	namespace := query.Get("namespace")
+	serviceAccount := query.Get("serviceaccount")
+	if namespace == "" || serviceAccount == "" {
+		http.Error(w, "namespace and serviceaccount are required", http.StatusBadRequest)
+		return
+	}
+
+	audience := []string{"nats"}
+	if aud := query.Get("audience"); aud != "" {
+		audience = strings.Split(aud, ",")
+	}
+
+	ttl := DefaultTokenTTL
+	if ttlParam := query.Get("ttl"); ttlParam != "" {
+		parsed, err := time.ParseDuration(ttlParam)
+		if err != nil {
+			http.Error(w, "invalid ttl", http.StatusBadRequest)
+			return
+		}
+		ttl = parsed
+	}
+
+	token, err := s.IssueToken(namespace, serviceAccount, audience, ttl)
+	if err != nil {
+		s.logger.Error("failed to issue token", zap.Error(err))
+		http.Error(w, "failed to issue token", http.StatusInternalServerError)
+		return
+	}
+
+	s.logger.Info("issued development token",
+		zap.String("namespace", namespace),
+		zap.String("serviceaccount", serviceAccount),
+		zap.Strings("audience", audience),
+		zap.Duration("ttl", ttl))
+
+	w.Header().Set("Content-Type", "application/jwt")
+	if _, err := w.Write([]byte(token)); err != nil {
+		s.logger.Error("failed to write token response", zap.Error(err))
+	}
+}
+
+// writeJSON encodes v as the JSON response body.
+func (s *Server) writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		s.logger.Error("failed to encode response", zap.Error(err))
+	}
+}
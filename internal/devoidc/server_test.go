@@ -0,0 +1,119 @@
+package devoidc
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/portswigger-tim/nats-k8s-oidc-callout/internal/jwt"
+)
+
+// startTestServer starts a mock OIDC server on an ephemeral port.
+func startTestServer(t *testing.T) *Server {
+	t.Helper()
+
+	srv, err := New("127.0.0.1:0", zap.NewNop())
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	srv.Start()
+	t.Cleanup(func() {
+		_ = srv.Shutdown(context.Background())
+	})
+	return srv
+}
+
+func TestServer_TokenValidatesAgainstJWKSURL(t *testing.T) {
+	srv := startTestServer(t)
+
+	validator, err := jwt.NewValidatorFromURL(srv.JWKSURL(), srv.Issuer(), "nats")
+	if err != nil {
+		t.Fatalf("NewValidatorFromURL() error = %v", err)
+	}
+
+	resp, err := http.Get(srv.Issuer() + TokenPath + "?namespace=foo&serviceaccount=bar")
+	if err != nil {
+		t.Fatalf("token request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("token request status = %d", resp.StatusCode)
+	}
+	token, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("failed to read token: %v", err)
+	}
+
+	claims, err := validator.Validate(string(token))
+	if err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	if claims.Namespace != "foo" || claims.ServiceAccount != "bar" {
+		t.Errorf("claims = %s/%s, want foo/bar", claims.Namespace, claims.ServiceAccount)
+	}
+	if claims.Subject != "system:serviceaccount:foo:bar" {
+		t.Errorf("Subject = %q", claims.Subject)
+	}
+}
+
+func TestServer_RotateKeyRefreshesUnknownKID(t *testing.T) {
+	srv := startTestServer(t)
+
+	validator, err := jwt.NewValidatorFromURL(srv.JWKSURL(), srv.Issuer(), "nats")
+	if err != nil {
+		t.Fatalf("NewValidatorFromURL() error = %v", err)
+	}
+
+	oldToken, err := srv.IssueToken("foo", "bar", []string{"nats"}, time.Hour)
+	if err != nil {
+		t.Fatalf("IssueToken() error = %v", err)
+	}
+
+	if err := srv.RotateKey(); err != nil {
+		t.Fatalf("RotateKey() error = %v", err)
+	}
+
+	// The validator has never seen the new key ID and must refresh the JWKS
+	newToken, err := srv.IssueToken("foo", "bar", []string{"nats"}, time.Hour)
+	if err != nil {
+		t.Fatalf("IssueToken() error = %v", err)
+	}
+	if _, err := validator.Validate(newToken); err != nil {
+		t.Errorf("Validate(new token) error = %v", err)
+	}
+
+	// Tokens signed with the previous key remain valid
+	if _, err := validator.Validate(oldToken); err != nil {
+		t.Errorf("Validate(old token) error = %v", err)
+	}
+}
+
+func TestServer_TokenEndpointErrors(t *testing.T) {
+	srv := startTestServer(t)
+
+	tests := []struct {
+		name  string
+		query string
+	}{
+		{name: "missing namespace", query: "?serviceaccount=bar"},
+		{name: "missing serviceaccount", query: "?namespace=foo"},
+		{name: "invalid ttl", query: "?namespace=foo&serviceaccount=bar&ttl=forever"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := http.Get(srv.Issuer() + TokenPath + tt.query)
+			if err != nil {
+				t.Fatalf("token request failed: %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusBadRequest {
+				t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusBadRequest)
+			}
+		})
+	}
+}
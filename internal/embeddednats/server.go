@@ -0,0 +1,131 @@
+// Package embeddednats runs an in-process NATS server preconfigured with auth callout,
+// so the whole local development stack can run from a single binary.
+package embeddednats
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"go.uber.org/zap"
+)
+
+const (
+	// ServiceUser is the NATS user the auth callout service connects as
+	ServiceUser = "auth-service"
+
+	// readyTimeout is how long to wait for the server to accept connections
+	readyTimeout = 10 * time.Second
+)
+
+// Options configures the embedded NATS server.
+type Options struct {
+	// Host and Port the server listens on for client connections
+	Host string
+	Port int
+
+	// Issuer is the account public key that signs authorization responses
+	Issuer string
+}
+
+// Server is an in-process NATS server with auth callout enabled.
+type Server struct {
+	ns       *server.Server
+	password string
+}
+
+// Start starts an embedded NATS server that delegates client authentication to the
+// auth callout service. The service itself authenticates as ServiceUser with a
+// randomly generated password (see ServiceURL).
+func Start(opts Options, logger *zap.Logger) (*Server, error) {
+	password, err := RandomPassword()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate service password: %w", err)
+	}
+
+	ns, err := server.NewServer(&server.Options{
+		ServerName: "embedded",
+		Host:       opts.Host,
+		Port:       opts.Port,
+		NoSigs:     true,
+		Users: []*server.User{
+			{Username: ServiceUser, Password: password},
+		},
+		AuthCallout: &server.AuthCallout{
+			Issuer:    opts.Issuer,
+			AuthUsers: []string{ServiceUser},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create embedded NATS server: %w", err)
+	}
+
+	ns.SetLoggerV2(&zapLogger{logger: logger.Named("nats-server").Sugar()}, false, false, false)
+
+	go ns.Start()
+	if !ns.ReadyForConnections(readyTimeout) {
+		ns.Shutdown()
+		return nil, fmt.Errorf("embedded NATS server not ready after %s", readyTimeout)
+	}
+
+	return &Server{ns: ns, password: password}, nil
+}
+
+// ClientURL returns the URL clients use to connect.
+func (s *Server) ClientURL() string {
+	return s.ns.ClientURL()
+}
+
+// ServiceURL returns the connection URL for the auth callout service,
+// with the service user credentials embedded.
+func (s *Server) ServiceURL() string {
+	u, err := url.Parse(s.ns.ClientURL())
+	if err != nil {
+		// ClientURL is always a valid nats:// URL
+		return s.ns.ClientURL()
+	}
+	u.User = url.UserPassword(ServiceUser, s.password)
+	return u.String()
+}
+
+// Addr returns the host:port the server accepts client connections on.
+func (s *Server) Addr() string {
+	addr, ok := s.ns.Addr().(*net.TCPAddr)
+	if !ok {
+		return ""
+	}
+	return net.JoinHostPort(addr.IP.String(), strconv.Itoa(addr.Port))
+}
+
+// Shutdown stops the server and waits for it to exit.
+func (s *Server) Shutdown() {
+	s.ns.Shutdown()
+	s.ns.WaitForShutdown()
+}
+
+// RandomPassword returns a random hex-encoded password, for the service's NATS user here and
+// in the configuration generated by bootstrap-dev.
+func RandomPassword() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// zapLogger adapts a zap logger to the nats-server Logger interface.
+type zapLogger struct {
+	logger *zap.SugaredLogger
+}
+
+func (l *zapLogger) Noticef(format string, v ...interface{}) { l.logger.Infof(format, v...) }
+func (l *zapLogger) Warnf(format string, v ...interface{})   { l.logger.Warnf(format, v...) }
+func (l *zapLogger) Fatalf(format string, v ...interface{})  { l.logger.Errorf(format, v...) }
+func (l *zapLogger) Errorf(format string, v ...interface{})  { l.logger.Errorf(format, v...) }
+func (l *zapLogger) Debugf(format string, v ...interface{})  { l.logger.Debugf(format, v...) }
+func (l *zapLogger) Tracef(format string, v ...interface{})  { l.logger.Debugf(format, v...) }
@@ -0,0 +1,181 @@
+package embeddednats
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	natsclient "github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+	"go.uber.org/zap"
+
+	"github.com/portswigger-tim/nats-k8s-oidc-callout/internal/auth"
+	"github.com/portswigger-tim/nats-k8s-oidc-callout/internal/nats"
+)
+
+// staticAuthHandler approves a single token with fixed permissions
+type staticAuthHandler struct {
+	token string
+}
+
+func (h *staticAuthHandler) Authorize(req *auth.AuthRequest) *auth.AuthResponse {
+	if req.Token != h.token {
+		return &auth.AuthResponse{Allowed: false, Reason: auth.ReasonUnknownServiceAccount}
+	}
+	return &auth.AuthResponse{
+		Allowed:              true,
+		PublishPermissions:   []string{"test.>"},
+		SubscribePermissions: []string{"_INBOX.>", "test.>"},
+	}
+}
+
+func TestEmbeddedServer_AuthCallout(t *testing.T) {
+	signingKey, err := nkeys.CreateAccount()
+	if err != nil {
+		t.Fatalf("failed to create account key: %v", err)
+	}
+	issuer, err := signingKey.PublicKey()
+	if err != nil {
+		t.Fatalf("failed to get public key: %v", err)
+	}
+
+	srv, err := Start(Options{Host: "127.0.0.1", Port: -1, Issuer: issuer}, zap.NewNop())
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer srv.Shutdown()
+
+	client, err := nats.NewClient(srv.ServiceURL(), "", "", "$G", &staticAuthHandler{token: "good-token"}, zap.NewNop())
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	client.SetSigningKey(signingKey)
+	if err := client.Start(context.Background()); err != nil {
+		t.Fatalf("client Start() error = %v", err)
+	}
+	defer client.Shutdown(context.Background())
+
+	// Authorized client can publish within its permissions
+	conn, err := natsclient.Connect(srv.ClientURL(), natsclient.Token("good-token"), natsclient.Timeout(5*time.Second))
+	if err != nil {
+		t.Fatalf("expected authorized connection, got %v", err)
+	}
+	defer conn.Close()
+
+	sub, err := conn.SubscribeSync("test.echo")
+	if err != nil {
+		t.Fatalf("SubscribeSync() error = %v", err)
+	}
+	if err := conn.Publish("test.echo", []byte("hello")); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	if _, err := sub.NextMsg(2 * time.Second); err != nil {
+		t.Errorf("expected to receive message, got %v", err)
+	}
+
+	// Unknown token is rejected
+	if badConn, err := natsclient.Connect(srv.ClientURL(), natsclient.Token("bad-token"), natsclient.Timeout(2*time.Second)); err == nil {
+		badConn.Close()
+		t.Error("expected connection with bad token to be rejected")
+	}
+}
+
+func TestEmbeddedServer_StatusEndpoint(t *testing.T) {
+	signingKey, err := nkeys.CreateAccount()
+	if err != nil {
+		t.Fatalf("failed to create account key: %v", err)
+	}
+	issuer, err := signingKey.PublicKey()
+	if err != nil {
+		t.Fatalf("failed to get public key: %v", err)
+	}
+
+	srv, err := Start(Options{Host: "127.0.0.1", Port: -1, Issuer: issuer}, zap.NewNop())
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer srv.Shutdown()
+
+	client, err := nats.NewClient(srv.ServiceURL(), "", "", "$G", &staticAuthHandler{token: "good-token"}, zap.NewNop())
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	client.SetSigningKey(signingKey)
+	client.SetStatusEndpoint("test.status", func() any {
+		return map[string]string{"version": "v1.2.3"}
+	})
+	if err := client.Start(context.Background()); err != nil {
+		t.Fatalf("client Start() error = %v", err)
+	}
+	defer client.Shutdown(context.Background())
+
+	conn, err := natsclient.Connect(srv.ClientURL(), natsclient.Token("good-token"), natsclient.Timeout(5*time.Second))
+	if err != nil {
+		t.Fatalf("expected authorized connection, got %v", err)
+	}
+	defer conn.Close()
+
+	msg, err := conn.Request("test.status", nil, 2*time.Second)
+	if err != nil {
+		t.Fatalf("status request error = %v", err)
+	}
+	if got := string(msg.Data); got != `{"version":"v1.2.3"}` {
+		t.Errorf("status response = %s, want version JSON", got)
+	}
+}
+
+func TestEmbeddedServer_SelfTest(t *testing.T) {
+	signingKey, err := nkeys.CreateAccount()
+	if err != nil {
+		t.Fatalf("failed to create account key: %v", err)
+	}
+	issuer, err := signingKey.PublicKey()
+	if err != nil {
+		t.Fatalf("failed to get public key: %v", err)
+	}
+	otherKey, err := nkeys.CreateAccount()
+	if err != nil {
+		t.Fatalf("failed to create account key: %v", err)
+	}
+
+	srv, err := Start(Options{Host: "127.0.0.1", Port: -1, Issuer: issuer}, zap.NewNop())
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer srv.Shutdown()
+
+	tests := []struct {
+		name    string
+		key     nkeys.KeyPair
+		wantErr bool
+	}{
+		{name: "matching signing key", key: signingKey},
+		{name: "signing key not the issuer", key: otherKey, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := nats.NewClient(srv.ServiceURL(), "", "", "$G", &staticAuthHandler{token: "good-token"}, zap.NewNop())
+			if err != nil {
+				t.Fatalf("NewClient() error = %v", err)
+			}
+			client.SetSigningKey(tt.key)
+			if err := client.Start(context.Background()); err != nil {
+				t.Fatalf("client Start() error = %v", err)
+			}
+			defer client.Shutdown(context.Background())
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			err = client.SelfTest(ctx, "")
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "rejected the signed authorization response") {
+					t.Errorf("SelfTest() error = %v, want signing key rejection", err)
+				}
+			} else if err != nil {
+				t.Errorf("SelfTest() error = %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+// Package faults provides config-gated fault injection for resilience testing.
+// Faults are injected by wrapping the components the authorization path depends on,
+// so alerting, timeouts and client retry behavior can be verified in staging.
+package faults
+
+import (
+	"errors"
+	"math/rand/v2"
+	"time"
+
+	"github.com/portswigger-tim/nats-k8s-oidc-callout/internal/auth"
+	"github.com/portswigger-tim/nats-k8s-oidc-callout/internal/jwt"
+	"github.com/portswigger-tim/nats-k8s-oidc-callout/internal/nats"
+)
+
+// ErrInjectedJWKSFailure is returned by the validator when a JWKS failure is injected
+var ErrInjectedJWKSFailure = errors.New("injected fault: JWKS unavailable")
+
+// Config configures which faults are injected
+type Config struct {
+	// AuthLatency is added to every authorization request
+	AuthLatency time.Duration
+	// JWKSFailureRate is the fraction (0-1) of token validations that fail as if the JWKS were unavailable
+	JWKSFailureRate float64
+	// CacheMissRate is the fraction (0-1) of permission lookups that miss as if the ServiceAccount were unknown
+	CacheMissRate float64
+}
+
+// Enabled reports whether any fault is configured.
+func (c Config) Enabled() bool {
+	return c.AuthLatency > 0 || c.JWKSFailureRate > 0 || c.CacheMissRate > 0
+}
+
+// Injector wraps components to inject the configured faults.
+type Injector struct {
+	cfg    Config
+	chance func() float64 // returns a value in [0, 1)
+}
+
+// New creates an injector for the given configuration.
+func New(cfg Config) *Injector {
+	return &Injector{cfg: cfg, chance: rand.Float64}
+}
+
+// WrapHandler adds AuthLatency to every authorization request.
+func (i *Injector) WrapHandler(h nats.AuthHandler) nats.AuthHandler {
+	if i.cfg.AuthLatency <= 0 {
+		return h
+	}
+	return &slowHandler{next: h, latency: i.cfg.AuthLatency}
+}
+
+// WrapValidator fails a JWKSFailureRate fraction of token validations.
+func (i *Injector) WrapValidator(v auth.JWTValidator) auth.JWTValidator {
+	if i.cfg.JWKSFailureRate <= 0 {
+		return v
+	}
+	return &failingValidator{next: v, injector: i}
+}
+
+// WrapPermissions makes a CacheMissRate fraction of permission lookups miss.
+func (i *Injector) WrapPermissions(p auth.PermissionsProvider) auth.PermissionsProvider {
+	if i.cfg.CacheMissRate <= 0 {
+		return p
+	}
+	return &missingPermissions{Policies: auth.Policies{Provider: p}, next: p, injector: i}
+}
+
+// inject reports whether a fault with the given rate should be injected now
+func (i *Injector) inject(rate float64) bool {
+	return i.chance() < rate
+}
+
+// slowHandler delays authorization requests
+type slowHandler struct {
+	next    nats.AuthHandler
+	latency time.Duration
+}
+
+func (h *slowHandler) Authorize(req *auth.AuthRequest) *auth.AuthResponse {
+	time.Sleep(h.latency)
+	return h.next.Authorize(req)
+}
+
+// failingValidator randomly fails token validation
+type failingValidator struct {
+	next     auth.JWTValidator
+	injector *Injector
+}
+
+func (v *failingValidator) Validate(token string) (*jwt.Claims, error) {
+	if v.injector.inject(v.injector.cfg.JWKSFailureRate) {
+		return nil, ErrInjectedJWKSFailure
+	}
+	return v.next.Validate(token)
+}
+
+// missingPermissions randomly reports ServiceAccounts as not found, forwarding the wrapped
+// provider's policies
+type missingPermissions struct {
+	auth.Policies
+	next     auth.PermissionsProvider
+	injector *Injector
+}
+
+func (p *missingPermissions) GetPermissions(namespace, name string) (pubPerms, subPerms []string, found bool) {
+	if p.injector.inject(p.injector.cfg.CacheMissRate) {
+		return nil, nil, false
+	}
+	return p.next.GetPermissions(namespace, name)
+}
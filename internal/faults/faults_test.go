@@ -0,0 +1,104 @@
+package faults
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/portswigger-tim/nats-k8s-oidc-callout/internal/auth"
+	"github.com/portswigger-tim/nats-k8s-oidc-callout/internal/jwt"
+)
+
+type stubValidator struct{}
+
+func (stubValidator) Validate(token string) (*jwt.Claims, error) {
+	return &jwt.Claims{Namespace: "default", ServiceAccount: "app"}, nil
+}
+
+type stubPermissions struct{}
+
+func (stubPermissions) GetPermissions(namespace, name string) ([]string, []string, bool) {
+	return []string{"default.>"}, []string{"_INBOX.>"}, true
+}
+
+func (stubPermissions) DeniedSubjects(namespace, name string) ([]string, []string) {
+	return []string{"default.admin.>"}, nil
+}
+
+type stubHandler struct{}
+
+func (stubHandler) Authorize(req *auth.AuthRequest) *auth.AuthResponse {
+	return &auth.AuthResponse{Allowed: true}
+}
+
+func TestConfig_Enabled(t *testing.T) {
+	if (Config{}).Enabled() {
+		t.Error("empty config should not be enabled")
+	}
+	if !(Config{CacheMissRate: 0.1}).Enabled() {
+		t.Error("config with cache miss rate should be enabled")
+	}
+}
+
+func TestInjector_WrapValidator(t *testing.T) {
+	tests := []struct {
+		name    string
+		rate    float64
+		chance  float64
+		wantErr bool
+	}{
+		{name: "disabled", rate: 0, chance: 0, wantErr: false},
+		{name: "roll below rate fails", rate: 0.5, chance: 0.2, wantErr: true},
+		{name: "roll above rate passes", rate: 0.5, chance: 0.7, wantErr: false},
+		{name: "always fails", rate: 1, chance: 0.99, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := New(Config{JWKSFailureRate: tt.rate})
+			i.chance = func() float64 { return tt.chance }
+
+			_, err := i.WrapValidator(stubValidator{}).Validate("token")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrInjectedJWKSFailure) {
+				t.Errorf("error = %v, want ErrInjectedJWKSFailure", err)
+			}
+		})
+	}
+}
+
+func TestInjector_WrapPermissions(t *testing.T) {
+	i := New(Config{CacheMissRate: 0.5})
+
+	i.chance = func() float64 { return 0.1 }
+	if _, _, found := i.WrapPermissions(stubPermissions{}).GetPermissions("default", "app"); found {
+		t.Error("expected injected cache miss")
+	}
+
+	i.chance = func() float64 { return 0.9 }
+	if _, _, found := i.WrapPermissions(stubPermissions{}).GetPermissions("default", "app"); !found {
+		t.Error("expected lookup to pass through")
+	}
+
+	// Policies of the wrapped provider are forwarded
+	deny, _ := i.WrapPermissions(stubPermissions{}).(auth.DenyPolicy).DeniedSubjects("default", "app")
+	if len(deny) != 1 {
+		t.Errorf("DeniedSubjects() = %v, want the wrapped provider's", deny)
+	}
+}
+
+func TestInjector_WrapHandler(t *testing.T) {
+	latency := 50 * time.Millisecond
+	h := New(Config{AuthLatency: latency}).WrapHandler(stubHandler{})
+
+	start := time.Now()
+	resp := h.Authorize(&auth.AuthRequest{Token: "token"})
+	if elapsed := time.Since(start); elapsed < latency {
+		t.Errorf("Authorize() took %v, want at least %v", elapsed, latency)
+	}
+	if !resp.Allowed {
+		t.Error("expected wrapped handler response to be returned")
+	}
+}
@@ -0,0 +1,128 @@
+// Package forwardauth serves authorization decisions to reverse proxies, such as Traefik's
+// ForwardAuth middleware or NGINX auth_request, so HTTP services can accept the same workload
+// identities as NATS.
+package forwardauth
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nuid"
+	"go.uber.org/zap"
+
+	"github.com/portswigger-tim/nats-k8s-oidc-callout/internal/auth"
+	httpmetrics "github.com/portswigger-tim/nats-k8s-oidc-callout/internal/httpserver"
+	"github.com/portswigger-tim/nats-k8s-oidc-callout/internal/nats"
+)
+
+// Path is where the endpoint is served on the HTTP server
+const Path = "/forward-auth"
+
+// Response headers describing an allowed identity. Subject lists are comma-separated.
+const (
+	HeaderIdentity       = "X-Auth-Identity"
+	HeaderNamespace      = "X-Auth-Namespace"
+	HeaderServiceAccount = "X-Auth-Service-Account"
+	HeaderRole           = "X-Auth-Role"
+	HeaderPublishAllow   = "X-Auth-Publish-Allow"
+	HeaderPublishDeny    = "X-Auth-Publish-Deny"
+	HeaderSubscribeAllow = "X-Auth-Subscribe-Allow"
+	HeaderSubscribeDeny  = "X-Auth-Subscribe-Deny"
+	HeaderReason         = "X-Auth-Reason"
+	HeaderRequestID      = "X-Auth-Request-Id"
+)
+
+// Handler validates the bearer token of a request forwarded by a reverse proxy. It answers
+// 200 with headers describing the identity and its permissions, which the proxy can pass on
+// to the upstream service, or 401 when the token is missing or denied.
+type Handler struct {
+	handler        nats.AuthHandler
+	deniedSubjects []string // subjects denied to every identity, as in issued user JWTs
+	logger         *zap.Logger
+}
+
+// New creates a forward-auth handler authorizing tokens with handler. deniedSubjects are
+// added to the denied publish and subscribe subjects of every identity, as they are to the
+// user JWTs issued to NATS clients.
+func New(handler nats.AuthHandler, deniedSubjects []string, logger *zap.Logger) *Handler {
+	return &Handler{handler: handler, deniedSubjects: deniedSubjects, logger: logger}
+}
+
+// ServeHTTP authorizes the bearer token of a forwarded request
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	token = strings.TrimSpace(token)
+	if !found || token == "" || len(token) > auth.MaxTokenLength {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="nats-k8s-oidc-callout"`)
+		http.Error(w, "bearer token required", http.StatusUnauthorized)
+		return
+	}
+
+	start := time.Now()
+	requestID := nuid.Next()
+	authResp := h.handler.Authorize(&auth.AuthRequest{
+		Token:          token,
+		ConnectionType: auth.ConnectionAPI,
+	})
+	httpmetrics.RecordAPIAuthRequest("forward_auth", authResp.Allowed, string(authResp.Reason))
+
+	h.logger.With(zap.String("request_id", requestID)).Named("audit").Info("authorization decision",
+		zap.Bool("allowed", authResp.Allowed),
+		zap.String("reason", string(authResp.Reason)),
+		zap.String("identity", authResp.Identity),
+		zap.String("role", authResp.Role),
+		zap.String("api", "forward_auth"),
+		zap.String("client_host", clientHost(r)),
+		zap.String("forwarded_uri", r.Header.Get("X-Forwarded-Uri")),
+		zap.Duration("duration", time.Since(start)))
+
+	header := w.Header()
+	header.Set(HeaderReason, string(authResp.Reason))
+	header.Set(HeaderRequestID, requestID)
+	if !authResp.Allowed {
+		header.Set("WWW-Authenticate", `Bearer realm="nats-k8s-oidc-callout", error="invalid_token"`)
+		http.Error(w, authResp.Reason.Message(), http.StatusUnauthorized)
+		return
+	}
+
+	// Headers are only set when there is a value to report
+	setHeader := func(name string, values ...[]string) {
+		var joined []string
+		for _, v := range values {
+			for _, value := range v {
+				if value != "" {
+					joined = append(joined, value)
+				}
+			}
+		}
+		if len(joined) > 0 {
+			header.Set(name, strings.Join(joined, ","))
+		}
+	}
+	setHeader(HeaderIdentity, []string{authResp.Identity})
+	setHeader(HeaderNamespace, []string{authResp.Namespace})
+	setHeader(HeaderServiceAccount, []string{authResp.ServiceAccount})
+	setHeader(HeaderRole, []string{authResp.Role})
+	setHeader(HeaderPublishAllow, authResp.PublishPermissions)
+	setHeader(HeaderPublishDeny, authResp.PublishDenied, h.deniedSubjects)
+	setHeader(HeaderSubscribeAllow, authResp.SubscribePermissions)
+	setHeader(HeaderSubscribeDeny, authResp.SubscribeDenied, h.deniedSubjects)
+	w.WriteHeader(http.StatusOK)
+}
+
+// clientHost returns the address of the original client as reported by the proxy, or of the
+// proxy itself
+func clientHost(r *http.Request) string {
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		first, _, _ := strings.Cut(forwarded, ",")
+		return strings.TrimSpace(first)
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
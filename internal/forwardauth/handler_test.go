@@ -0,0 +1,92 @@
+package forwardauth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/portswigger-tim/nats-k8s-oidc-callout/internal/auth"
+)
+
+// tokenHandler allows the token "valid" and denies everything else
+type tokenHandler struct{}
+
+func (tokenHandler) Authorize(req *auth.AuthRequest) *auth.AuthResponse {
+	if req.Token != "valid" || req.ConnectionType != auth.ConnectionAPI {
+		return &auth.AuthResponse{Reason: auth.ReasonTokenExpired}
+	}
+	return &auth.AuthResponse{
+		Allowed:              true,
+		PublishPermissions:   []string{"orders.>"},
+		SubscribePermissions: []string{"orders.>", "_INBOX.>"},
+		Identity:             "orders/api",
+		Namespace:            "orders",
+		ServiceAccount:       "api",
+		Reason:               auth.ReasonAllowed,
+	}
+}
+
+func TestHandler(t *testing.T) {
+	handler := New(tokenHandler{}, []string{"$SYS.>"}, zap.NewNop())
+
+	tests := []struct {
+		name          string
+		authorization string
+		wantStatus    int
+		wantHeaders   map[string]string
+	}{
+		{
+			name:          "allowed",
+			authorization: "Bearer valid",
+			wantStatus:    http.StatusOK,
+			wantHeaders: map[string]string{
+				HeaderIdentity:       "orders/api",
+				HeaderNamespace:      "orders",
+				HeaderServiceAccount: "api",
+				HeaderRole:           "",
+				HeaderPublishAllow:   "orders.>",
+				HeaderPublishDeny:    "$SYS.>",
+				HeaderSubscribeAllow: "orders.>,_INBOX.>",
+				HeaderSubscribeDeny:  "$SYS.>",
+				HeaderReason:         "allowed",
+			},
+		},
+		{
+			name:          "denied",
+			authorization: "Bearer expired",
+			wantStatus:    http.StatusUnauthorized,
+			wantHeaders: map[string]string{
+				HeaderIdentity:     "",
+				HeaderPublishAllow: "",
+				HeaderReason:       "token_expired",
+			},
+		},
+		{name: "missing token", wantStatus: http.StatusUnauthorized},
+		{name: "not a bearer token", authorization: "Basic dXNlcjpwYXNz", wantStatus: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, Path, nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+				t.Error("expected a WWW-Authenticate challenge")
+			}
+			for name, want := range tt.wantHeaders {
+				if got := rec.Header().Get(name); got != want {
+					t.Errorf("%s = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
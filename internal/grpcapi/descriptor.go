@@ -0,0 +1,123 @@
+package grpcapi
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// ServiceName is the full name of the Authorizer service
+const ServiceName = "natsk8soidc.v1.Authorizer"
+
+// The descriptors of api/natsk8soidc/v1/authorizer.proto, which must be kept in step with it
+var (
+	requestDesc  protoreflect.MessageDescriptor
+	responseDesc protoreflect.MessageDescriptor
+
+	fieldToken          protoreflect.FieldDescriptor
+	fieldConnectionName protoreflect.FieldDescriptor
+)
+
+func init() {
+	file, err := protodesc.NewFile(authorizerProto(), protoregistry.GlobalFiles)
+	if err != nil {
+		panic(fmt.Sprintf("invalid authorizer descriptor: %v", err))
+	}
+	// Registered for gRPC reflection
+	if err := protoregistry.GlobalFiles.RegisterFile(file); err != nil {
+		panic(fmt.Sprintf("failed to register authorizer descriptor: %v", err))
+	}
+
+	requestDesc = file.Messages().ByName("AuthorizeRequest")
+	responseDesc = file.Messages().ByName("AuthorizeResponse")
+	fieldToken = requestDesc.Fields().ByName("token")
+	fieldConnectionName = requestDesc.Fields().ByName("connection_name")
+}
+
+// authorizerProto describes the Authorizer service and its messages
+func authorizerProto() *descriptorpb.FileDescriptorProto {
+	field := func(name string, number int32, typ descriptorpb.FieldDescriptorProto_Type, repeated bool) *descriptorpb.FieldDescriptorProto {
+		label := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL
+		if repeated {
+			label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED
+		}
+		return &descriptorpb.FieldDescriptorProto{
+			Name:   proto.String(name),
+			Number: proto.Int32(number),
+			Label:  label.Enum(),
+			Type:   typ.Enum(),
+		}
+	}
+	str := descriptorpb.FieldDescriptorProto_TYPE_STRING
+
+	return &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("natsk8soidc/v1/authorizer.proto"),
+		Package: proto.String("natsk8soidc.v1"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{
+			{
+				Name: proto.String("AuthorizeRequest"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("token", 1, str, false),
+					field("connection_name", 2, str, false),
+				},
+			},
+			{
+				Name: proto.String("AuthorizeResponse"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("allowed", 1, descriptorpb.FieldDescriptorProto_TYPE_BOOL, false),
+					field("reason", 2, str, false),
+					field("message", 3, str, false),
+					field("identity", 4, str, false),
+					field("namespace", 5, str, false),
+					field("service_account", 6, str, false),
+					field("role", 7, str, false),
+					field("publish_allow", 8, str, true),
+					field("publish_deny", 9, str, true),
+					field("subscribe_allow", 10, str, true),
+					field("subscribe_deny", 11, str, true),
+					field("request_id", 12, str, false),
+				},
+			},
+		},
+		Service: []*descriptorpb.ServiceDescriptorProto{{
+			Name: proto.String("Authorizer"),
+			Method: []*descriptorpb.MethodDescriptorProto{{
+				Name:       proto.String("Authorize"),
+				InputType:  proto.String(".natsk8soidc.v1.AuthorizeRequest"),
+				OutputType: proto.String(".natsk8soidc.v1.AuthorizeResponse"),
+			}},
+		}},
+	}
+}
+
+// serviceDesc registers the Authorizer service with a gRPC server
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*any)(nil),
+	Methods: []grpc.MethodDesc{{
+		MethodName: "Authorize",
+		Handler: func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+			req := dynamicpb.NewMessage(requestDesc)
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+			s := srv.(*Server)
+			if interceptor == nil {
+				return s.authorize(ctx, req)
+			}
+			info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + ServiceName + "/Authorize"}
+			return interceptor(ctx, req, info, func(ctx context.Context, req any) (any, error) {
+				return s.authorize(ctx, req.(*dynamicpb.Message))
+			})
+		},
+	}},
+	Metadata: "natsk8soidc/v1/authorizer.proto",
+}
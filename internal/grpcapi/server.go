@@ -0,0 +1,170 @@
+// Package grpcapi serves the authorization pipeline over gRPC, so that HTTP gateways and gRPC
+// services can apply the same ServiceAccount-to-subject policy as NATS without speaking NATS.
+//
+// The service is described by api/natsk8soidc/v1/authorizer.proto. Its messages are built
+// from the descriptor at runtime, so the package needs no generated code, and the descriptor
+// is served over gRPC reflection for tools such as grpcurl.
+package grpcapi
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/nats-io/nuid"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	"github.com/portswigger-tim/nats-k8s-oidc-callout/internal/auth"
+	httpmetrics "github.com/portswigger-tim/nats-k8s-oidc-callout/internal/httpserver"
+	"github.com/portswigger-tim/nats-k8s-oidc-callout/internal/nats"
+)
+
+// Server serves the Authorizer service, with the standard gRPC health service and reflection
+type Server struct {
+	addr           string
+	handler        nats.AuthHandler
+	deniedSubjects []string // subjects denied to every identity, as in issued user JWTs
+	logger         *zap.Logger
+	grpcServer     *grpc.Server
+	health         *health.Server
+}
+
+// New creates a server listening on port that authorizes tokens with handler. deniedSubjects
+// are added to the denied publish and subscribe subjects of every decision, as they are to
+// the user JWTs issued to NATS clients.
+func New(port int, handler nats.AuthHandler, deniedSubjects []string, logger *zap.Logger) *Server {
+	s := &Server{
+		addr:           fmt.Sprintf(":%d", port),
+		handler:        handler,
+		deniedSubjects: deniedSubjects,
+		logger:         logger,
+		grpcServer:     grpc.NewServer(),
+		health:         health.NewServer(),
+	}
+	s.grpcServer.RegisterService(&serviceDesc, s)
+	healthpb.RegisterHealthServer(s.grpcServer, s.health)
+	reflection.Register(s.grpcServer)
+	return s
+}
+
+// Start listens on the server's port and serves until Shutdown is called.
+func (s *Server) Start() error {
+	s.logger.Info("starting gRPC server", zap.String("addr", s.addr))
+
+	listener, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return fmt.Errorf("gRPC server failed: %w", err)
+	}
+	return s.Serve(listener)
+}
+
+// Serve serves on listener until Shutdown is called.
+func (s *Server) Serve(listener net.Listener) error {
+	if err := s.grpcServer.Serve(listener); err != nil && err != grpc.ErrServerStopped {
+		return fmt.Errorf("gRPC server failed: %w", err)
+	}
+	return nil
+}
+
+// Shutdown stops accepting calls and waits for those in progress, closing them when the
+// context ends first.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.logger.Info("shutting down gRPC server")
+	s.health.Shutdown()
+
+	stopped := make(chan struct{})
+	go func() {
+		s.grpcServer.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		s.grpcServer.Stop()
+		return ctx.Err()
+	}
+}
+
+// authorize handles an Authorize call. Denials are decisions rather than errors: only a
+// malformed request fails the call.
+func (s *Server) authorize(ctx context.Context, req *dynamicpb.Message) (*dynamicpb.Message, error) {
+	token := req.Get(fieldToken).String()
+	if token == "" || len(token) > auth.MaxTokenLength {
+		return nil, status.Error(codes.InvalidArgument, "a token of at most 16KiB is required")
+	}
+
+	start := time.Now()
+	requestID := nuid.Next()
+	logger := s.logger.With(zap.String("request_id", requestID))
+	authResp := s.handler.Authorize(&auth.AuthRequest{
+		Token:          token,
+		ConnectionName: req.Get(fieldConnectionName).String(),
+		ConnectionType: auth.ConnectionAPI,
+	})
+	httpmetrics.RecordAPIAuthRequest("grpc", authResp.Allowed, string(authResp.Reason))
+
+	clientHost := ""
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		clientHost = p.Addr.String()
+		if host, _, err := net.SplitHostPort(clientHost); err == nil {
+			clientHost = host
+		}
+	}
+	logger.Named("audit").Info("authorization decision",
+		zap.Bool("allowed", authResp.Allowed),
+		zap.String("reason", string(authResp.Reason)),
+		zap.String("identity", authResp.Identity),
+		zap.String("role", authResp.Role),
+		zap.String("api", "grpc"),
+		zap.String("client_host", clientHost),
+		zap.Duration("duration", time.Since(start)))
+
+	return s.decision(authResp, requestID), nil
+}
+
+// decision builds the AuthorizeResponse of a decision
+func (s *Server) decision(authResp *auth.AuthResponse, requestID string) *dynamicpb.Message {
+	resp := dynamicpb.NewMessage(responseDesc)
+	setString := func(field, value string) {
+		if value != "" {
+			resp.Set(responseDesc.Fields().ByName(protoreflect.Name(field)), protoreflect.ValueOfString(value))
+		}
+	}
+	setList := func(field string, values ...[]string) {
+		list := resp.Mutable(responseDesc.Fields().ByName(protoreflect.Name(field))).List()
+		for _, subjects := range values {
+			for _, subject := range subjects {
+				list.Append(protoreflect.ValueOfString(subject))
+			}
+		}
+	}
+
+	resp.Set(responseDesc.Fields().ByName("allowed"), protoreflect.ValueOfBool(authResp.Allowed))
+	setString("reason", string(authResp.Reason))
+	setString("message", authResp.Reason.Message())
+	setString("identity", authResp.Identity)
+	setString("namespace", authResp.Namespace)
+	setString("service_account", authResp.ServiceAccount)
+	setString("request_id", requestID)
+	if !authResp.Allowed {
+		return resp
+	}
+
+	setString("role", authResp.Role)
+	setList("publish_allow", authResp.PublishPermissions)
+	setList("publish_deny", authResp.PublishDenied, s.deniedSubjects)
+	setList("subscribe_allow", authResp.SubscribePermissions)
+	setList("subscribe_deny", authResp.SubscribeDenied, s.deniedSubjects)
+	return resp
+}
@@ -0,0 +1,137 @@
+package grpcapi
+
+import (
+	"context"
+	"net"
+	"slices"
+	"testing"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	"github.com/portswigger-tim/nats-k8s-oidc-callout/internal/auth"
+)
+
+// tokenHandler allows the token "valid" and denies everything else
+type tokenHandler struct {
+	last *auth.AuthRequest
+}
+
+func (h *tokenHandler) Authorize(req *auth.AuthRequest) *auth.AuthResponse {
+	h.last = req
+	if req.Token != "valid" {
+		return &auth.AuthResponse{Reason: auth.ReasonTokenExpired}
+	}
+	return &auth.AuthResponse{
+		Allowed:              true,
+		PublishPermissions:   []string{"orders.>"},
+		SubscribePermissions: []string{"orders.>", "_INBOX.>"},
+		PublishDenied:        []string{"orders.admin.>"},
+		Identity:             "orders/api",
+		Namespace:            "orders",
+		ServiceAccount:       "api",
+		Reason:               auth.ReasonAllowed,
+	}
+}
+
+// dial serves a server for handler over an in-memory listener and connects a client to it
+func dial(t *testing.T, handler *tokenHandler) *grpc.ClientConn {
+	t.Helper()
+	listener := bufconn.Listen(1024 * 1024)
+	server := New(0, handler, []string{"$SYS.>"}, zap.NewNop())
+	go func() { _ = server.Serve(listener) }()
+	t.Cleanup(func() { _ = server.Shutdown(context.Background()) })
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+// authorize calls Authorize with a token
+func authorize(conn *grpc.ClientConn, token string) (*dynamicpb.Message, error) {
+	req := dynamicpb.NewMessage(requestDesc)
+	req.Set(fieldToken, protoreflect.ValueOfString(token))
+	req.Set(fieldConnectionName, protoreflect.ValueOfString("orders-api"))
+	resp := dynamicpb.NewMessage(responseDesc)
+	err := conn.Invoke(context.Background(), "/"+ServiceName+"/Authorize", req, resp)
+	return resp, err
+}
+
+// repeated returns the values of a repeated string field
+func repeated(m *dynamicpb.Message, name string) []string {
+	list := m.Get(responseDesc.Fields().ByName(protoreflect.Name(name))).List()
+	values := make([]string, list.Len())
+	for i := range values {
+		values[i] = list.Get(i).String()
+	}
+	return values
+}
+
+func TestServer_Authorize(t *testing.T) {
+	handler := &tokenHandler{}
+	conn := dial(t, handler)
+
+	resp, err := authorize(conn, "valid")
+	if err != nil {
+		t.Fatalf("Authorize() error = %v", err)
+	}
+	field := func(name string) protoreflect.Value {
+		return resp.Get(responseDesc.Fields().ByName(protoreflect.Name(name)))
+	}
+	if !field("allowed").Bool() || field("reason").String() != "allowed" || field("identity").String() != "orders/api" ||
+		field("service_account").String() != "api" || field("request_id").String() == "" {
+		t.Errorf("response = %v, want an allowed decision for orders/api", resp)
+	}
+	for name, want := range map[string][]string{
+		"publish_allow":   {"orders.>"},
+		"publish_deny":    {"orders.admin.>", "$SYS.>"},
+		"subscribe_allow": {"orders.>", "_INBOX.>"},
+		"subscribe_deny":  {"$SYS.>"},
+	} {
+		if got := repeated(resp, name); !slices.Equal(got, want) {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+	if handler.last.ConnectionType != auth.ConnectionAPI || handler.last.ConnectionName != "orders-api" {
+		t.Errorf("request = %+v, want an API request with the connection name", handler.last)
+	}
+
+	resp, err = authorize(conn, "expired")
+	if err != nil {
+		t.Fatalf("Authorize() error = %v", err)
+	}
+	if field("allowed").Bool() || field("reason").String() != "token_expired" || field("message").String() == "" {
+		t.Errorf("response = %v, want a token_expired denial", resp)
+	}
+	if got := repeated(resp, "publish_allow"); len(got) != 0 {
+		t.Errorf("publish_allow = %q, want no permissions for a denial", got)
+	}
+
+	if _, err := authorize(conn, ""); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Authorize() without a token error = %v, want InvalidArgument", err)
+	}
+}
+
+func TestServer_Health(t *testing.T) {
+	conn := dial(t, &tokenHandler{})
+
+	resp, err := healthpb.NewHealthClient(conn).Check(context.Background(), &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	if resp.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("Status = %v, want SERVING", resp.Status)
+	}
+}
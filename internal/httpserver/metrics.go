@@ -2,7 +2,10 @@
 package httpserver
 
 import (
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
@@ -17,19 +20,727 @@ var (
 		},
 		[]string{"namespace", "serviceaccount", "annotation", "pattern"},
 	)
+
+	// deniedInternalSubjectsTotal counts NATS internal subjects denied by ServiceAccount annotations
+	deniedInternalSubjectsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "nats_auth_denied_internal_subjects_total",
+			Help: "Total number of NATS internal subjects denied by ServiceAccount annotations",
+		},
+		[]string{"namespace", "serviceaccount", "annotation", "pattern"},
+	)
+
+	// deprecatedAnnotationsTotal counts ServiceAccount annotations read through a deprecated alias
+	deprecatedAnnotationsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "nats_auth_deprecated_annotations_total",
+			Help: "Total number of ServiceAccount annotations read through a deprecated alias",
+		},
+		[]string{"alias", "annotation"},
+	)
+
+	// permissionChangesTotal counts changes of the permissions computed for cached ServiceAccounts
+	permissionChangesTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "nats_auth_permission_changes_total",
+			Help: "Total number of changes of the permissions computed for a cached ServiceAccount",
+		},
+		[]string{"namespace", "serviceaccount"},
+	)
+
+	// shadowComparisonsTotal counts permission lookups compared with the shadow permissions source
+	shadowComparisonsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "nats_auth_shadow_comparisons_total",
+			Help: "Total number of permission lookups compared with the shadow permissions source, by result",
+		},
+		[]string{"result"},
+	)
+
+	// canaryLookupsTotal counts permission lookups by canary pipeline
+	canaryLookupsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "nats_auth_canary_lookups_total",
+			Help: "Total number of permission lookups by canary pipeline and whether the identity was found",
+		},
+		[]string{"pipeline", "found"},
+	)
+
+	// denialNotificationsTotal counts denial webhook notifications by outcome
+	denialNotificationsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "nats_auth_denial_notifications_total",
+			Help: "Total number of authorization denials by webhook notification outcome (sent, failed, dropped, suppressed)",
+		},
+		[]string{"result"},
+	)
+
+	// annotationLimitExceededTotal counts ServiceAccount annotations truncated or ignored for exceeding a limit
+	annotationLimitExceededTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "nats_auth_annotation_limit_exceeded_total",
+			Help: "Total number of ServiceAccount annotations truncated or ignored for exceeding a configured limit",
+		},
+		[]string{"namespace", "serviceaccount", "annotation", "limit"},
+	)
+
+	// authRequestsTotal counts authorization decisions by result, reason code and the NATS
+	// cluster the request came from
+	authRequestsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "nats_auth_requests_total",
+			Help: "Total number of authorization requests by result, reason code and NATS cluster",
+		},
+		[]string{"result", "reason", "cluster"},
+	)
+
+	// apiAuthRequestsTotal counts authorization API decisions by API (grpc, forward_auth), result and reason code
+	apiAuthRequestsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "nats_auth_api_requests_total",
+			Help: "Total number of authorization API requests by API (grpc, forward_auth), result and reason code",
+		},
+		[]string{"api", "result", "reason"},
+	)
+
+	// authRequestDuration measures authorization latency from receipt to response by result
+	// and NATS cluster. Observations carry the trace ID as an exemplar, linking a latency
+	// bucket to the span and log lines of a representative request.
+	authRequestDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "nats_auth_request_duration_seconds",
+			Help:    "Authorization request latency from receipt to response, by result and NATS cluster",
+			Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2, 5},
+		},
+		[]string{"result", "cluster"},
+	)
+
+	// natsServerInfo identifies the NATS server each account's connection is attached to
+	natsServerInfo = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "nats_auth_nats_server_info",
+			Help: "NATS server and cluster each account's callout connection is attached to (always 1)",
+		},
+		[]string{"account", "server", "cluster"},
+	)
+
+	// issuedSubjects measures how many subjects each issued user JWT allows, by direction
+	issuedSubjects = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "nats_auth_issued_subjects",
+			Help:    "Number of subjects allowed by each issued user JWT, by direction (publish or subscribe)",
+			Buckets: []float64{1, 2, 5, 10, 25, 50, 100, 250, 500, 1000},
+		},
+		[]string{"direction"},
+	)
+
+	// namespaceGrantedSubjectsDesc and namespaceMaxGrantedSubjectsDesc describe the per-namespace
+	// grant gauges, computed from the permission cache at scrape time
+	namespaceGrantedSubjectsDesc = prometheus.NewDesc(
+		"nats_auth_namespace_granted_subjects",
+		"Subjects granted to all cached ServiceAccounts of a namespace, by direction (publish or subscribe)",
+		[]string{"namespace", "direction"}, nil,
+	)
+	namespaceMaxGrantedSubjectsDesc = prometheus.NewDesc(
+		"nats_auth_namespace_max_granted_subjects",
+		"Most subjects (publish and subscribe) granted to a single cached ServiceAccount of a namespace",
+		[]string{"namespace"}, nil,
+	)
+
+	// bearerUsersTotal counts bearer user JWTs issued
+	bearerUsersTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "nats_auth_bearer_users_total",
+			Help: "Total number of bearer user JWTs issued",
+		},
+	)
+
+	// k8sAPIErrorsTotal counts failed interactions with the Kubernetes API (watch errors and probes)
+	k8sAPIErrorsTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "nats_auth_k8s_api_errors_total",
+			Help: "Total number of failed Kubernetes API watch or probe requests",
+		},
+	)
+
+	// k8sWatchErrorsTotal counts failed list-watch requests of the informers by resource
+	k8sWatchErrorsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "nats_auth_k8s_watch_errors_total",
+			Help: "Total number of failed Kubernetes list or watch requests, by informer resource",
+		},
+		[]string{"resource"},
+	)
+
+	// k8sEventsTotal counts informer events by resource and type (add, update, delete or resync)
+	k8sEventsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "nats_auth_k8s_events_total",
+			Help: "Total number of Kubernetes informer events handled, by resource and type (add, update, delete, resync)",
+		},
+		[]string{"resource", "type"},
+	)
+
+	// k8sEventLag measures how long after a change on the API server its event is handled
+	k8sEventLag = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "nats_auth_k8s_event_lag_seconds",
+			Help:    "Time from an object's last change on the Kubernetes API server to its informer event being handled",
+			Buckets: []float64{.5, 1, 2, 5, 10, 30, 60, 120, 300},
+		},
+		[]string{"resource"},
+	)
+
+	// k8sDegraded is 1 while the Kubernetes API is unreachable and permissions may be stale
+	k8sDegraded = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "nats_auth_k8s_degraded",
+			Help: "Whether the Kubernetes API has been unreachable longer than the degraded threshold (1) or not (0)",
+		},
+	)
+
+	// leader is 1 while the instance holds the leader election Lease
+	leader = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "nats_auth_leader",
+			Help: "Whether the instance is the elected leader running singleton subsystems (1) or not (0)",
+		},
+	)
+
+	// errorRateTripped is 1 while a client has left the callout queue group after too many
+	// internal errors
+	errorRateTripped = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "nats_auth_error_rate_tripped",
+			Help: "Whether the client for an account has left the callout queue group because too many authorizations failed with internal errors (1) or not (0)",
+		},
+		[]string{"account"},
+	)
+
+	// authPanicsTotal counts panics recovered in the authorization path
+	authPanicsTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "nats_auth_panics_total",
+			Help: "Total number of panics recovered while handling authorization requests",
+		},
+	)
+
+	// deadlineExceededTotal counts authorization requests abandoned after the server stopped waiting
+	deadlineExceededTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "nats_auth_deadline_exceeded_total",
+			Help: "Total number of authorization requests abandoned because the NATS server deadline had passed",
+		},
+	)
+
+	// stuckAuthRequests is the number of authorization requests pending beyond the watchdog threshold
+	stuckAuthRequests = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "nats_auth_stuck_requests",
+			Help: "Number of authorization requests pending longer than the watchdog threshold",
+		},
+	)
+
+	// authRequestsInFlight is the number of authorization requests being handled
+	authRequestsInFlight = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "nats_auth_requests_in_flight",
+			Help: "Number of authorization requests received from NATS and not yet answered",
+		},
+	)
+
+	// authWorkers is the number of callout services handling authorization requests
+	authWorkers = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "nats_auth_workers",
+			Help: "Number of workers handling authorization requests, one per account in the callout queue group",
+		},
+	)
+
+	// authWorkerBusySeconds is the time workers spent handling authorization requests
+	authWorkerBusySeconds = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "nats_auth_worker_busy_seconds_total",
+			Help: "Total time workers spent handling authorization requests",
+		},
+	)
+
+	// authRequestRate is the rate of authorization requests over the last sampling interval
+	authRequestRate = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "nats_auth_request_rate",
+			Help: "Authorization requests handled per second over the last sampling interval",
+		},
+	)
+
+	// authWorkerUtilization is the fraction of worker time spent busy over the last sampling interval
+	authWorkerUtilization = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "nats_auth_worker_utilization",
+			Help: "Fraction of worker time spent handling authorization requests over the last sampling interval (0-1)",
+		},
+	)
+
+	// namespaceLastAuthSuccess is the time of the last successful authorization per namespace
+	namespaceLastAuthSuccess = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "nats_auth_namespace_last_success_timestamp_seconds",
+			Help: "Unix time of the last successful authorization of a ServiceAccount in the namespace",
+		},
+		[]string{"namespace"},
+	)
+
+	// serviceAccountLastAuthSuccess is the time of the last successful authorization per ServiceAccount
+	serviceAccountLastAuthSuccess = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "nats_auth_serviceaccount_last_success_timestamp_seconds",
+			Help: "Unix time of the last successful authorization of the ServiceAccount",
+		},
+		[]string{"namespace", "serviceaccount"},
+	)
+
+	// serviceAccountIssuancesTotal counts the user JWTs issued per recently active ServiceAccount
+	serviceAccountIssuancesTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "nats_auth_serviceaccount_issuances_total",
+			Help: "Total number of user JWTs issued to the ServiceAccount; series of idle ServiceAccounts are removed",
+		},
+		[]string{"namespace", "serviceaccount"},
+	)
+
+	// issuanceQuotaExceededTotal counts authorizations denied for exceeding the issuance quota
+	issuanceQuotaExceededTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "nats_auth_issuance_quota_exceeded_total",
+			Help: "Total number of authorizations denied because the ServiceAccount exceeded its issuance quota",
+		},
+		[]string{"namespace"},
+	)
+
+	// registryConnections is the number of client connections the connection registry tracks
+	registryConnections = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "nats_auth_registry_connections",
+			Help: "Client connections tracked by the connection registry from system account events",
+		},
+	)
+
+	// connectionLimitExceededTotal counts authorizations denied for reaching the connection limit
+	connectionLimitExceededTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "nats_auth_connection_limit_exceeded_total",
+			Help: "Total number of authorizations denied because the ServiceAccount reached its connection limit",
+		},
+		[]string{"namespace"},
+	)
+
+	// throttledTotal counts authorization requests denied by the global rate limit
+	throttledTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "nats_auth_throttled_total",
+			Help: "Total number of authorization requests denied by the global rate limit",
+		},
+	)
+
+	// rateLimit is the configured global rate limit, in requests per second
+	rateLimit = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "nats_auth_rate_limit",
+			Help: "Configured global authorization rate limit in requests per second (0 = disabled)",
+		},
+	)
+
+	// auditExportedTotal counts audit records delivered to the audit export sink
+	auditExportedTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "nats_auth_audit_exported_total",
+			Help: "Total number of audit records delivered to the audit export sink",
+		},
+	)
+
+	// auditDroppedTotal counts audit records the audit export gave up on, by cause
+	auditDroppedTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "nats_auth_audit_dropped_total",
+			Help: "Total number of audit records dropped by the audit export (queue_full, send_failed, format_error)",
+		},
+		[]string{"cause"},
+	)
+
+	// auditRetriesTotal counts retried deliveries of audit record batches
+	auditRetriesTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "nats_auth_audit_retries_total",
+			Help: "Total number of retried deliveries of audit record batches",
+		},
+	)
+
+	// auditQueued is the number of audit records waiting for export
+	auditQueued = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "nats_auth_audit_queued",
+			Help: "Number of audit records waiting for export",
+		},
+	)
+
+	// jwksLastRefresh is the time of the last successful JWKS load
+	jwksLastRefresh = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "nats_auth_jwks_last_refresh_timestamp_seconds",
+			Help: "Unix time of the last successful JWKS load",
+		},
+	)
+
+	// signingKeyValid is whether the signing keys of a callout account passed their last
+	// self-verification
+	signingKeyValid = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "nats_auth_signing_key_valid",
+			Help: "Whether the signing keys of each callout account passed their last self-verification (1) or not (0)",
+		},
+		[]string{"account"},
+	)
+
+	// signingKeyTrusted is whether the issuer account JWT lists the old and new signing keys of a
+	// signing key rotation
+	signingKeyTrusted = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "nats_auth_signing_key_trusted",
+			Help: "Whether the issuer account JWT lists the old or new signing key of a key rotation (1) or not (0)",
+		},
+		[]string{"account", "key"},
+	)
+
+	// accountJWTLastRefresh is the time the issuer account's JWT was last fetched from the
+	// account resolver, by callout account
+	accountJWTLastRefresh = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "nats_auth_account_jwt_last_refresh_timestamp_seconds",
+			Help: "Unix time the issuer account's JWT was last fetched from the account resolver",
+		},
+		[]string{"account"},
+	)
 )
 
-// IncrementFilteredSubjects increments the counter for a filtered internal subject
-func IncrementFilteredSubjects(namespace, serviceaccount, annotation, subject string) {
-	pattern := "_INBOX"
-	if strings.HasPrefix(subject, "_REPLY") {
-		pattern = "_REPLY"
+// jwksLastRefreshTime is the Unix time of the last successful JWKS load, for /debug/stats
+var jwksLastRefreshTime atomic.Int64
+
+// SetJWKSLastRefresh records the time of the last successful JWKS load
+func SetJWKSLastRefresh(t time.Time) {
+	jwksLastRefresh.Set(float64(t.Unix()))
+	jwksLastRefreshTime.Store(t.Unix())
+}
+
+// SetSigningKeyValid records the result of a callout account's signing key self-verification
+func SetSigningKeyValid(account string, valid bool) {
+	if valid {
+		signingKeyValid.WithLabelValues(account).Set(1)
+	} else {
+		signingKeyValid.WithLabelValues(account).Set(0)
 	}
+}
+
+// SetSigningKeyTrusted records whether the issuer account JWT of a callout account lists the
+// "old" or "new" signing key of a key rotation
+func SetSigningKeyTrusted(account, key string, trusted bool) {
+	if trusted {
+		signingKeyTrusted.WithLabelValues(account, key).Set(1)
+	} else {
+		signingKeyTrusted.WithLabelValues(account, key).Set(0)
+	}
+}
+
+// SetAccountJWTLastRefresh records the time the issuer account JWT of a callout account was
+// last fetched from the account resolver
+func SetAccountJWTLastRefresh(account string, t time.Time) {
+	accountJWTLastRefresh.WithLabelValues(account).Set(float64(t.Unix()))
+}
+
+// SetLastAuthSuccess records the time of a successful authorization for the namespace and,
+// when serviceaccount is not empty, for the ServiceAccount
+func SetLastAuthSuccess(namespace, serviceaccount string, t time.Time) {
+	namespaceLastAuthSuccess.WithLabelValues(namespace).Set(float64(t.Unix()))
+	if serviceaccount != "" {
+		serviceAccountLastAuthSuccess.WithLabelValues(namespace, serviceaccount).Set(float64(t.Unix()))
+	}
+}
+
+// IncrementServiceAccountIssuances increments the counter of user JWTs issued to a ServiceAccount
+func IncrementServiceAccountIssuances(namespace, serviceaccount string) {
+	serviceAccountIssuancesTotal.WithLabelValues(namespace, serviceaccount).Inc()
+}
+
+// DeleteServiceAccountIssuances removes the issuance counter of an idle ServiceAccount
+func DeleteServiceAccountIssuances(namespace, serviceaccount string) {
+	serviceAccountIssuancesTotal.DeleteLabelValues(namespace, serviceaccount)
+}
+
+// IncrementIssuanceQuotaExceeded increments the counter of authorizations denied over the issuance quota
+func IncrementIssuanceQuotaExceeded(namespace string) {
+	issuanceQuotaExceededTotal.WithLabelValues(namespace).Inc()
+}
+
+// SetRegistryConnections records the number of client connections the connection registry tracks
+func SetRegistryConnections(n int) {
+	registryConnections.Set(float64(n))
+}
+
+// IncrementConnectionLimitExceeded increments the counter of authorizations denied at the connection limit
+func IncrementConnectionLimitExceeded(namespace string) {
+	connectionLimitExceededTotal.WithLabelValues(namespace).Inc()
+}
+
+// IncrementThrottled increments the counter of authorization requests denied by the rate limit
+func IncrementThrottled() {
+	throttledTotal.Inc()
+}
+
+// SetRateLimit records the configured global authorization rate limit
+func SetRateLimit(requestsPerSecond float64) {
+	rateLimit.Set(requestsPerSecond)
+}
+
+// AddAuditExported adds to the counter of audit records delivered to the export sink
+func AddAuditExported(records int) {
+	auditExportedTotal.Add(float64(records))
+}
+
+// AddAuditDropped adds to the counter of audit records dropped by the export for a cause
+func AddAuditDropped(cause string, records int) {
+	auditDroppedTotal.WithLabelValues(cause).Add(float64(records))
+}
+
+// IncrementAuditRetries increments the counter of retried audit record batches
+func IncrementAuditRetries() {
+	auditRetriesTotal.Inc()
+}
+
+// SetAuditQueued records the number of audit records waiting for export
+func SetAuditQueued(records int) {
+	auditQueued.Set(float64(records))
+}
+
+// IncrementAuthPanics increments the recovered authorization panic counter
+func IncrementAuthPanics() {
+	authPanicsTotal.Inc()
+}
+
+// IncrementDeadlineExceeded increments the counter of authorization requests abandoned past their deadline
+func IncrementDeadlineExceeded() {
+	deadlineExceededTotal.Inc()
+}
+
+// SetStuckAuthRequests sets the number of stuck authorization requests
+func SetStuckAuthRequests(count int) {
+	stuckAuthRequests.Set(float64(count))
+}
+
+// SetAuthRequestsInFlight sets the number of authorization requests being handled
+func SetAuthRequestsInFlight(count int) {
+	authRequestsInFlight.Set(float64(count))
+}
+
+// SetAuthWorkers sets the number of workers handling authorization requests
+func SetAuthWorkers(count int) {
+	authWorkers.Set(float64(count))
+}
+
+// AddAuthWorkerBusy adds time workers spent handling authorization requests
+func AddAuthWorkerBusy(d time.Duration) {
+	authWorkerBusySeconds.Add(d.Seconds())
+}
+
+// SetAuthLoad sets the authorization request rate and worker utilization of the last sampling interval
+func SetAuthLoad(requestsPerSecond, utilization float64) {
+	authRequestRate.Set(requestsPerSecond)
+	authWorkerUtilization.Set(utilization)
+}
 
+// IncrementK8sAPIErrors increments the Kubernetes API error counter
+func IncrementK8sAPIErrors() {
+	k8sAPIErrorsTotal.Inc()
+}
+
+// IncrementK8sWatchErrors increments the failed list-watch counter of an informer resource
+func IncrementK8sWatchErrors(resource string) {
+	k8sWatchErrorsTotal.WithLabelValues(resource).Inc()
+}
+
+// RecordK8sEvent increments the informer event counter
+func RecordK8sEvent(resource, eventType string) {
+	k8sEventsTotal.WithLabelValues(resource, eventType).Inc()
+}
+
+// ObserveK8sEventLag records the delay between a change on the API server and its handling
+func ObserveK8sEventLag(resource string, lag time.Duration) {
+	k8sEventLag.WithLabelValues(resource).Observe(lag.Seconds())
+}
+
+// SetK8sDegraded sets the Kubernetes degraded gauge
+func SetK8sDegraded(degraded bool) {
+	if degraded {
+		k8sDegraded.Set(1)
+	} else {
+		k8sDegraded.Set(0)
+	}
+}
+
+// SetLeader sets the leader gauge
+func SetLeader(leading bool) {
+	if leading {
+		leader.Set(1)
+	} else {
+		leader.Set(0)
+	}
+}
+
+// SetNATSServer records the NATS server an account's connection is attached to, replacing the
+// previous one. An empty server removes the account's series, as when the client shuts down.
+func SetNATSServer(account, server, cluster string) {
+	natsServerInfo.DeletePartialMatch(prometheus.Labels{"account": account})
+	if server != "" {
+		natsServerInfo.WithLabelValues(account, server, cluster).Set(1)
+	}
+}
+
+// SetErrorRateTripped sets whether the client for an account has left the callout queue group
+func SetErrorRateTripped(account string, tripped bool) {
+	if tripped {
+		errorRateTripped.WithLabelValues(account).Set(1)
+	} else {
+		errorRateTripped.WithLabelValues(account).Set(0)
+	}
+}
+
+// RecordAuthRequest increments the authorization request counter for a decision on a request
+// from a NATS cluster ("" when the server is not clustered)
+func RecordAuthRequest(allowed bool, reason, cluster string) {
+	result := "denied"
+	if allowed {
+		result = "allowed"
+	}
+	authRequestsTotal.WithLabelValues(result, reason, cluster).Inc()
+}
+
+// RecordAPIAuthRequest increments the authorization API request counter for a decision
+func RecordAPIAuthRequest(api string, allowed bool, reason string) {
+	result := "denied"
+	if allowed {
+		result = "allowed"
+	}
+	apiAuthRequestsTotal.WithLabelValues(api, result, reason).Inc()
+}
+
+// ObserveAuthDuration records the latency of an authorization, with its trace ID as exemplar
+func ObserveAuthDuration(allowed bool, cluster string, d time.Duration, traceID string) {
+	result := "denied"
+	if allowed {
+		result = "allowed"
+	}
+	observer := authRequestDuration.WithLabelValues(result, cluster)
+	if exemplar, ok := observer.(prometheus.ExemplarObserver); ok && traceID != "" {
+		exemplar.ObserveWithExemplar(d.Seconds(), prometheus.Labels{"trace_id": traceID})
+		return
+	}
+	observer.Observe(d.Seconds())
+}
+
+// ObserveIssuedSubjects records the number of publish and subscribe subjects an issued user JWT allows
+func ObserveIssuedSubjects(publish, subscribe int) {
+	issuedSubjects.WithLabelValues("publish").Observe(float64(publish))
+	issuedSubjects.WithLabelValues("subscribe").Observe(float64(subscribe))
+}
+
+// NamespaceGrants summarizes the subjects granted to the ServiceAccounts of a namespace
+type NamespaceGrants struct {
+	Publish     int // publish subjects across all ServiceAccounts
+	Subscribe   int // subscribe subjects across all ServiceAccounts
+	MaxSubjects int // most publish and subscribe subjects of a single ServiceAccount
+}
+
+// namespaceGrantsCollector exports NamespaceGrants by namespace, read from its source at each scrape
+type namespaceGrantsCollector struct {
+	source func() map[string]NamespaceGrants
+}
+
+func (c namespaceGrantsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- namespaceGrantedSubjectsDesc
+	ch <- namespaceMaxGrantedSubjectsDesc
+}
+
+func (c namespaceGrantsCollector) Collect(ch chan<- prometheus.Metric) {
+	for namespace, grants := range c.source() {
+		ch <- prometheus.MustNewConstMetric(namespaceGrantedSubjectsDesc, prometheus.GaugeValue, float64(grants.Publish), namespace, "publish")
+		ch <- prometheus.MustNewConstMetric(namespaceGrantedSubjectsDesc, prometheus.GaugeValue, float64(grants.Subscribe), namespace, "subscribe")
+		ch <- prometheus.MustNewConstMetric(namespaceMaxGrantedSubjectsDesc, prometheus.GaugeValue, float64(grants.MaxSubjects), namespace)
+	}
+}
+
+// RegisterNamespaceGrants exports the per-namespace grant gauges, calling source at each scrape.
+// Only one source can be registered.
+func RegisterNamespaceGrants(source func() map[string]NamespaceGrants) error {
+	return prometheus.Register(namespaceGrantsCollector{source: source})
+}
+
+// IncrementBearerUsers increments the counter of bearer user JWTs issued
+func IncrementBearerUsers() {
+	bearerUsersTotal.Inc()
+}
+
+// IncrementDeprecatedAnnotations increments the counter for an annotation read through an alias
+func IncrementDeprecatedAnnotations(alias, annotation string) {
+	deprecatedAnnotationsTotal.WithLabelValues(alias, annotation).Inc()
+}
+
+// IncrementPermissionChanges increments the counter for a change of a ServiceAccount's permissions
+func IncrementPermissionChanges(namespace, serviceaccount string) {
+	permissionChangesTotal.WithLabelValues(namespace, serviceaccount).Inc()
+}
+
+// RecordShadowComparison increments the counter for a comparison with the shadow permissions source
+func RecordShadowComparison(result string) {
+	shadowComparisonsTotal.WithLabelValues(result).Inc()
+}
+
+// RecordCanaryLookup increments the counter for a permission lookup served by a canary pipeline
+func RecordCanaryLookup(pipeline string, found bool) {
+	canaryLookupsTotal.WithLabelValues(pipeline, strconv.FormatBool(found)).Inc()
+}
+
+// RecordDenialNotification increments the counter for a denial webhook notification outcome
+func RecordDenialNotification(result string) {
+	denialNotificationsTotal.WithLabelValues(result).Inc()
+}
+
+// IncrementAnnotationLimitExceeded increments the counter for an annotation over a limit
+func IncrementAnnotationLimitExceeded(namespace, serviceaccount, annotation, limit string) {
+	annotationLimitExceededTotal.WithLabelValues(namespace, serviceaccount, annotation, limit).Inc()
+}
+
+// IncrementFilteredSubjects increments the counter for a filtered internal subject
+func IncrementFilteredSubjects(namespace, serviceaccount, annotation, subject string) {
 	filteredSubjectsTotal.WithLabelValues(
 		namespace,
 		serviceaccount,
 		annotation,
-		pattern,
+		internalPattern(subject),
 	).Inc()
 }
+
+// IncrementDeniedInternalSubjects increments the counter for an internal subject kept in a deny list
+func IncrementDeniedInternalSubjects(namespace, serviceaccount, annotation, subject string) {
+	deniedInternalSubjectsTotal.WithLabelValues(
+		namespace,
+		serviceaccount,
+		annotation,
+		internalPattern(subject),
+	).Inc()
+}
+
+// internalPattern returns the pattern label of an internal subject: _REPLY or _INBOX
+func internalPattern(subject string) string {
+	if strings.HasPrefix(subject, "_REPLY") {
+		return "_REPLY"
+	}
+	return "_INBOX"
+}
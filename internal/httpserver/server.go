@@ -5,21 +5,80 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"os"
+	"sync"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.uber.org/zap"
 )
 
+// Readiness check statuses
+const (
+	// StatusOK means the dependency is working normally
+	StatusOK = "ok"
+	// StatusDegraded means the service still works but with reduced guarantees (still ready)
+	StatusDegraded = "degraded"
+	// StatusFailed means the service cannot serve requests (not ready)
+	StatusFailed = "failed"
+)
+
 // Server provides HTTP endpoints for health checks and metrics.
 type Server struct {
 	httpServer *http.Server
+	mux        *http.ServeMux
 	logger     *zap.Logger
+
+	mu         sync.RWMutex
+	checks     map[string]ReadinessCheck
+	liveChecks map[string]LivenessCheck
+	stats      map[string]StatsProvider
+	build      BuildInfo
+}
+
+// BuildInfo identifies the running build of the service.
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"buildDate"`
 }
 
 // HealthResponse represents the JSON response from the health endpoint.
 type HealthResponse struct {
-	Healthy bool `json:"healthy"`
+	Healthy bool              `json:"healthy"`
+	Errors  map[string]string `json:"errors,omitempty"`
+}
+
+// LivenessCheck returns an error when the process is unhealthy and should be restarted.
+type LivenessCheck func() error
+
+// CheckResult is the outcome of a single readiness check.
+type CheckResult struct {
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+// ReadinessCheck reports the current state of one dependency.
+type ReadinessCheck func() CheckResult
+
+// ReadyResponse represents the JSON response from the readiness endpoint.
+type ReadyResponse struct {
+	Ready  bool                   `json:"ready"`
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+// StatusResponse combines liveness, readiness and build information, for status endpoints
+// outside the HTTP server.
+type StatusResponse struct {
+	BuildInfo
+	Instance string                 `json:"instance,omitempty"`
+	Healthy  bool                   `json:"healthy"`
+	Ready    bool                   `json:"ready"`
+	Status   string                 `json:"status"`
+	Checks   map[string]CheckResult `json:"checks"`
+	Errors   map[string]string      `json:"errors,omitempty"`
 }
 
 // New creates a new HTTP server with health and metrics endpoints.
@@ -34,16 +93,76 @@ func New(port int, logger *zap.Logger) *Server {
 			WriteTimeout: 10 * time.Second,
 			IdleTimeout:  120 * time.Second,
 		},
-		logger: logger,
+		mux:        mux,
+		logger:     logger,
+		checks:     make(map[string]ReadinessCheck),
+		liveChecks: make(map[string]LivenessCheck),
+		stats:      make(map[string]StatsProvider),
 	}
 
 	// Register endpoints
 	mux.HandleFunc("/health", s.handleHealth)
-	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/livez", s.handleHealth)
+	mux.HandleFunc("/readyz", s.handleReady)
+	mux.HandleFunc("/statusz", s.handleStatus)
+	mux.HandleFunc("/debug/stats", s.handleStats)
+	// OpenMetrics is negotiated for scrapers that ask for it, as exemplars are only exposed in that format
+	mux.Handle("/metrics", promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer,
+		promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{EnableOpenMetrics: true})))
 
 	return s
 }
 
+// Handle registers an additional endpoint, such as the forward-auth endpoint.
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+// AddReadinessCheck registers a named check evaluated by the readiness endpoint.
+func (s *Server) AddReadinessCheck(name string, check ReadinessCheck) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.checks[name] = check
+}
+
+// AddLivenessCheck registers a named check evaluated by the liveness endpoints.
+// Liveness checks should only fail for faults that a restart fixes, never for
+// unavailable dependencies.
+func (s *Server) AddLivenessCheck(name string, check LivenessCheck) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.liveChecks[name] = check
+}
+
+// SetBuildInfo sets the build information reported by Status.
+func (s *Server) SetBuildInfo(build BuildInfo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.build = build
+}
+
+// Status evaluates the liveness and readiness checks and returns them with the build
+// information and host name.
+func (s *Server) Status() StatusResponse {
+	live := s.liveness()
+	ready := s.readiness()
+
+	s.mu.RLock()
+	build := s.build
+	s.mu.RUnlock()
+
+	instance, _ := os.Hostname()
+	return StatusResponse{
+		BuildInfo: build,
+		Instance:  instance,
+		Healthy:   live.Healthy,
+		Ready:     ready.Ready,
+		Status:    ready.Status,
+		Checks:    ready.Checks,
+		Errors:    live.Errors,
+	}
+}
+
 // Start begins listening for HTTP requests.
 // This is a blocking call that returns when the server shuts down.
 func (s *Server) Start() error {
@@ -62,14 +181,93 @@ func (s *Server) Shutdown(ctx context.Context) error {
 	return s.httpServer.Shutdown(ctx)
 }
 
-// handleHealth returns a simple liveness check.
-// Returns 200 OK with {"healthy": true} if the HTTP server is responding.
+// handleHealth evaluates all liveness checks (served on /health and /livez).
+// Returns 200 with {"healthy": true} when every check passes and 503 otherwise.
 func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	response := s.liveness()
+
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
+	if response.Healthy {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
 
-	response := HealthResponse{Healthy: true}
 	if err := json.NewEncoder(w).Encode(response); err != nil {
 		s.logger.Error("failed to encode health response", zap.Error(err))
 	}
 }
+
+// liveness runs all liveness checks
+func (s *Server) liveness() HealthResponse {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	response := HealthResponse{Healthy: true}
+	for name, check := range s.liveChecks {
+		if err := check(); err != nil {
+			if response.Errors == nil {
+				response.Errors = make(map[string]string)
+			}
+			response.Errors[name] = err.Error()
+			response.Healthy = false
+		}
+	}
+	if !response.Healthy {
+		s.logger.Warn("liveness check failed", zap.Any("errors", response.Errors))
+	}
+	return response
+}
+
+// handleReady evaluates all readiness checks.
+// Returns 200 when no check has failed (status "ok" or "degraded") and 503 otherwise.
+func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
+	response := s.readiness()
+
+	w.Header().Set("Content-Type", "application/json")
+	if response.Ready {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		s.logger.Error("failed to encode readiness response", zap.Error(err))
+	}
+}
+
+// handleStatus serves the combined liveness, readiness and build information, as answered
+// on STATUS_SUBJECT. It always returns 200, leaving the verdicts to the body.
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s.Status()); err != nil {
+		s.logger.Error("failed to encode status response", zap.Error(err))
+	}
+}
+
+// readiness runs all checks and combines them into an overall status
+func (s *Server) readiness() ReadyResponse {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	response := ReadyResponse{
+		Ready:  true,
+		Status: StatusOK,
+		Checks: make(map[string]CheckResult, len(s.checks)),
+	}
+	for name, check := range s.checks {
+		result := check()
+		response.Checks[name] = result
+
+		switch result.Status {
+		case StatusFailed:
+			response.Ready = false
+			response.Status = StatusFailed
+		case StatusDegraded:
+			if response.Status == StatusOK {
+				response.Status = StatusDegraded
+			}
+		}
+	}
+	return response
+}
@@ -0,0 +1,269 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestServer_Readyz(t *testing.T) {
+	tests := []struct {
+		name       string
+		checks     map[string]CheckResult
+		wantCode   int
+		wantStatus string
+	}{
+		{
+			name:       "no checks",
+			checks:     nil,
+			wantCode:   http.StatusOK,
+			wantStatus: StatusOK,
+		},
+		{
+			name: "degraded stays ready",
+			checks: map[string]CheckResult{
+				"kubernetes": {Status: StatusDegraded, Message: "API unreachable"},
+				"jwks":       {Status: StatusOK},
+			},
+			wantCode:   http.StatusOK,
+			wantStatus: StatusDegraded,
+		},
+		{
+			name: "failed is not ready",
+			checks: map[string]CheckResult{
+				"kubernetes": {Status: StatusDegraded},
+				"nats":       {Status: StatusFailed},
+			},
+			wantCode:   http.StatusServiceUnavailable,
+			wantStatus: StatusFailed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := New(0, zap.NewNop())
+			for name, result := range tt.checks {
+				result := result
+				s.AddReadinessCheck(name, func() CheckResult { return result })
+			}
+
+			rec := httptest.NewRecorder()
+			s.httpServer.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+			if rec.Code != tt.wantCode {
+				t.Errorf("status code = %d, want %d", rec.Code, tt.wantCode)
+			}
+
+			var resp ReadyResponse
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if resp.Status != tt.wantStatus {
+				t.Errorf("Status = %q, want %q", resp.Status, tt.wantStatus)
+			}
+			if len(resp.Checks) != len(tt.checks) {
+				t.Errorf("got %d checks, want %d", len(resp.Checks), len(tt.checks))
+			}
+		})
+	}
+}
+
+func TestServer_Livez(t *testing.T) {
+	s := New(0, zap.NewNop())
+
+	var stuck error
+	s.AddLivenessCheck("watchdog", func() error { return stuck })
+
+	for _, path := range []string{"/health", "/livez"} {
+		rec := httptest.NewRecorder()
+		s.httpServer.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s status code = %d, want %d", path, rec.Code, http.StatusOK)
+		}
+	}
+
+	stuck = errors.New("1 authorization request(s) pending longer than 30s")
+	rec := httptest.NewRecorder()
+	s.httpServer.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/livez", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status code = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+
+	var resp HealthResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if resp.Healthy || resp.Errors["watchdog"] == "" {
+		t.Errorf("got %+v, want unhealthy with watchdog error", resp)
+	}
+}
+
+func TestServer_Status(t *testing.T) {
+	s := New(0, zap.NewNop())
+	s.SetBuildInfo(BuildInfo{Version: "v1.2.3", Commit: "abc1234", BuildDate: "2026-01-01T00:00:00Z"})
+	s.AddReadinessCheck("kubernetes", func() CheckResult { return CheckResult{Status: StatusDegraded} })
+	s.AddLivenessCheck("watchdog", func() error { return errors.New("stuck") })
+
+	status := s.Status()
+	if status.Version != "v1.2.3" || status.Commit != "abc1234" {
+		t.Errorf("build info = %+v, want v1.2.3/abc1234", status.BuildInfo)
+	}
+	if !status.Ready || status.Status != StatusDegraded {
+		t.Errorf("got ready %v status %q, want ready and %q", status.Ready, status.Status, StatusDegraded)
+	}
+	if status.Healthy || status.Errors["watchdog"] != "stuck" {
+		t.Errorf("got healthy %v errors %v, want unhealthy with watchdog error", status.Healthy, status.Errors)
+	}
+
+	data, err := json.Marshal(status)
+	if err != nil {
+		t.Fatalf("failed to encode status: %v", err)
+	}
+	var decoded map[string]any
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("failed to decode status: %v", err)
+	}
+	if decoded["version"] != "v1.2.3" {
+		t.Errorf("version field = %v, want build info flattened into the response", decoded["version"])
+	}
+
+	// The same status is served on /statusz, with 200 whatever the verdicts
+	rec := httptest.NewRecorder()
+	s.httpServer.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/statusz", nil))
+	var served StatusResponse
+	if err := json.NewDecoder(rec.Body).Decode(&served); err != nil {
+		t.Fatalf("failed to decode /statusz response: %v", err)
+	}
+	if rec.Code != http.StatusOK || served.Version != "v1.2.3" || served.Healthy {
+		t.Errorf("/statusz = %d %+v, want 200 with the unhealthy status", rec.Code, served)
+	}
+}
+
+func TestServer_MetricsExemplars(t *testing.T) {
+	s := New(0, zap.NewNop())
+	ObserveAuthDuration(true, "eu-west", 5*time.Millisecond, "4bf92f3577b34da6a3ce929d0e0e4736")
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Accept", "application/openmetrics-text; version=1.0.0")
+	rec := httptest.NewRecorder()
+	s.httpServer.Handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status code = %d, want %d", rec.Code, http.StatusOK)
+	}
+	body := rec.Body.String()
+	if !strings.Contains(body, `nats_auth_request_duration_seconds_bucket{cluster="eu-west",result="allowed"`) {
+		t.Error("metrics missing the authorization latency histogram")
+	}
+	if !strings.Contains(body, `# {trace_id="4bf92f3577b34da6a3ce929d0e0e4736"}`) {
+		t.Errorf("metrics missing the trace ID exemplar:\n%s", body)
+	}
+}
+
+func TestServer_MetricsNATSServer(t *testing.T) {
+	s := New(0, zap.NewNop())
+	SetNATSServer("ACCOUNT_A", "nats-0", "eu-west")
+	SetNATSServer("ACCOUNT_A", "nats-1", "eu-west")
+	SetNATSServer("ACCOUNT_B", "nats-2", "eu-west")
+	SetNATSServer("ACCOUNT_B", "", "")
+
+	rec := httptest.NewRecorder()
+	s.httpServer.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := rec.Body.String()
+	if !strings.Contains(body, `nats_auth_nats_server_info{account="ACCOUNT_A",cluster="eu-west",server="nats-1"} 1`) {
+		t.Errorf("metrics missing the server ACCOUNT_A is connected to:\n%s", body)
+	}
+	if strings.Contains(body, `server="nats-0"`) || strings.Contains(body, `account="ACCOUNT_B"`) {
+		t.Error("expected reconnected and shut down connections to drop their previous server")
+	}
+}
+
+func TestServer_MetricsNamespaceGrants(t *testing.T) {
+	s := New(0, zap.NewNop())
+	err := RegisterNamespaceGrants(func() map[string]NamespaceGrants {
+		return map[string]NamespaceGrants{"orders": {Publish: 4, Subscribe: 6, MaxSubjects: 6}}
+	})
+	if err != nil {
+		t.Fatalf("RegisterNamespaceGrants() error = %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	s.httpServer.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := rec.Body.String()
+	for _, want := range []string{
+		`nats_auth_namespace_granted_subjects{direction="publish",namespace="orders"} 4`,
+		`nats_auth_namespace_granted_subjects{direction="subscribe",namespace="orders"} 6`,
+		`nats_auth_namespace_max_granted_subjects{namespace="orders"} 6`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics missing %q", want)
+		}
+	}
+}
+
+func TestServer_MetricsLastAuthSuccess(t *testing.T) {
+	s := New(0, zap.NewNop())
+	SetLastAuthSuccess("payments", "", time.Unix(1700000000, 0))
+	SetLastAuthSuccess("shipping", "courier", time.Unix(1700000100, 0))
+
+	rec := httptest.NewRecorder()
+	s.httpServer.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := rec.Body.String()
+	for _, want := range []string{
+		`nats_auth_namespace_last_success_timestamp_seconds{namespace="payments"} 1.7e+09`,
+		`nats_auth_namespace_last_success_timestamp_seconds{namespace="shipping"} 1.7000001e+09`,
+		`nats_auth_serviceaccount_last_success_timestamp_seconds{namespace="shipping",serviceaccount="courier"} 1.7000001e+09`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics missing %q", want)
+		}
+	}
+	if strings.Contains(body, `serviceaccount_last_success_timestamp_seconds{namespace="payments"`) {
+		t.Error("expected no per-ServiceAccount series without a ServiceAccount")
+	}
+}
+
+func TestServer_Stats(t *testing.T) {
+	s := New(0, zap.NewNop())
+	s.SetBuildInfo(BuildInfo{Version: "v1.2.3"})
+	s.AddStats("permissions", func() any { return map[string]any{"serviceAccounts": 42} })
+	RecordAuthRequest(true, "allowed", "")
+	RecordAuthRequest(false, "token_expired", "eu-west")
+	SetJWKSLastRefresh(time.Now().Add(-time.Minute))
+
+	rec := httptest.NewRecorder()
+	s.httpServer.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/stats", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status code = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	var stats struct {
+		Version  string         `json:"version"`
+		Status   string         `json:"status"`
+		Auth     AuthStats      `json:"auth"`
+		JWKS     *JWKSStats     `json:"jwks"`
+		Sections map[string]any `json:"sections"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&stats); err != nil {
+		t.Fatalf("failed to decode stats: %v", err)
+	}
+	if stats.Version != "v1.2.3" || stats.Status != StatusOK {
+		t.Errorf("version %q status %q, want v1.2.3 and %q", stats.Version, stats.Status, StatusOK)
+	}
+	// Counters are shared by every test in the package, so only lower bounds hold
+	if stats.Auth.Allowed < 1 || stats.Auth.Denied < 1 || stats.Auth.Reasons["token_expired"] < 1 {
+		t.Errorf("auth = %+v, want at least one allowed and one token_expired denial", stats.Auth)
+	}
+	if stats.JWKS == nil || stats.JWKS.AgeSeconds < 60 {
+		t.Errorf("jwks = %+v, want an age of at least 60s", stats.JWKS)
+	}
+	if permissions, _ := stats.Sections["permissions"].(map[string]any); permissions["serviceAccounts"] != float64(42) {
+		t.Errorf("permissions section = %v, want 42 ServiceAccounts", stats.Sections["permissions"])
+	}
+}
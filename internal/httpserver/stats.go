@@ -0,0 +1,121 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// StatsProvider returns one named section of the /debug/stats snapshot. It must be safe to
+// call concurrently and should return quickly.
+type StatsProvider func() any
+
+// AuthStats are the authorization decisions counted since the service started
+type AuthStats struct {
+	Allowed int64            `json:"allowed"`
+	Denied  int64            `json:"denied"`
+	Reasons map[string]int64 `json:"reasons,omitempty"` // decisions by reason code
+}
+
+// JWKSStats describe the last successful JWKS load
+type JWKSStats struct {
+	LastRefresh time.Time `json:"lastRefresh"`
+	AgeSeconds  float64   `json:"ageSeconds"`
+}
+
+// StatsResponse is the JSON snapshot served on /debug/stats, for debugging with
+// kubectl exec and curl when no Prometheus is at hand.
+type StatsResponse struct {
+	BuildInfo
+	Instance string         `json:"instance,omitempty"`
+	Time     time.Time      `json:"time"`
+	Status   string         `json:"status"` // overall readiness status
+	Auth     AuthStats      `json:"auth"`
+	JWKS     *JWKSStats     `json:"jwks,omitempty"` // absent when the JWKS is not fetched from a URL
+	Sections map[string]any `json:"sections,omitempty"`
+}
+
+// AddStats registers a named section of the /debug/stats snapshot.
+func (s *Server) AddStats(name string, provider StatsProvider) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats[name] = provider
+}
+
+// Stats returns the /debug/stats snapshot.
+func (s *Server) Stats() StatsResponse {
+	ready := s.readiness()
+	now := time.Now()
+
+	s.mu.RLock()
+	build := s.build
+	sections := make(map[string]any, len(s.stats))
+	for name, provider := range s.stats {
+		sections[name] = provider()
+	}
+	s.mu.RUnlock()
+
+	instance, _ := os.Hostname()
+	response := StatsResponse{
+		BuildInfo: build,
+		Instance:  instance,
+		Time:      now.UTC(),
+		Status:    ready.Status,
+		Auth:      authStats(s.logger),
+		Sections:  sections,
+	}
+	if refreshed := jwksLastRefreshTime.Load(); refreshed != 0 {
+		last := time.Unix(refreshed, 0).UTC()
+		response.JWKS = &JWKSStats{LastRefresh: last, AgeSeconds: now.Sub(last).Round(time.Second).Seconds()}
+	}
+	return response
+}
+
+// handleStats serves the /debug/stats snapshot.
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(s.Stats()); err != nil {
+		s.logger.Error("failed to encode stats response", zap.Error(err))
+	}
+}
+
+// authStats reads the authorization decision counters from the metrics registry
+func authStats(logger *zap.Logger) AuthStats {
+	stats := AuthStats{Reasons: make(map[string]int64)}
+
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		// Gather returns what it could collect along with the error
+		logger.Warn("failed to gather some metrics for stats", zap.Error(err))
+	}
+	for _, family := range families {
+		if family.GetName() != "nats_auth_requests_total" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			var result, reason string
+			for _, label := range metric.GetLabel() {
+				switch label.GetName() {
+				case "result":
+					result = label.GetValue()
+				case "reason":
+					reason = label.GetValue()
+				}
+			}
+			count := int64(metric.GetCounter().GetValue())
+			if result == "allowed" {
+				stats.Allowed += count
+			} else {
+				stats.Denied += count
+			}
+			stats.Reasons[reason] += count
+		}
+	}
+	return stats
+}
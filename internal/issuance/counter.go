@@ -0,0 +1,250 @@
+// Package issuance counts the user JWTs issued to each ServiceAccount over a sliding window,
+// and optionally denies ServiceAccounts over a quota, so a single workload stuck in a reconnect
+// loop cannot monopolize the callout.
+package issuance
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/portswigger-tim/nats-k8s-oidc-callout/internal/auth"
+	httpmetrics "github.com/portswigger-tim/nats-k8s-oidc-callout/internal/httpserver"
+	"github.com/portswigger-tim/nats-k8s-oidc-callout/internal/nats"
+)
+
+// Path is where the issuance counts are served on the HTTP server
+const Path = "/debug/issuance"
+
+// slots is the number of slots the window is divided into; the window slides one slot at a time
+const slots = 10
+
+// ServiceAccount is the issuance count of a ServiceAccount
+type ServiceAccount struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Window    int    `json:"window"`    // user JWTs issued in the current window
+	Denied    uint64 `json:"denied"`    // authorizations denied over the quota since the counter started
+	Total     uint64 `json:"total"`     // user JWTs issued since the counter started
+	LastIssue string `json:"lastIssue"` // time of the last issuance, RFC 3339
+}
+
+// Snapshot lists the ServiceAccounts issued user JWTs in the current window
+type Snapshot struct {
+	Since           time.Time        `json:"since"`
+	Window          string           `json:"window"`
+	Quota           int              `json:"quota,omitempty"`
+	ServiceAccounts []ServiceAccount `json:"serviceAccounts"`
+}
+
+// key identifies a ServiceAccount
+type key struct {
+	namespace, name string
+}
+
+// slot counts the issuances of one slot of the window
+type slot struct {
+	index int64 // slot number since the Unix epoch
+	count int
+}
+
+// entry is the issuance state of a ServiceAccount
+type entry struct {
+	slots  [slots]slot
+	total  uint64
+	denied uint64
+	last   time.Time
+}
+
+// Counter counts the user JWTs issued to each ServiceAccount over a sliding window. With a
+// quota, ServiceAccounts that were issued that many in the window are denied with
+// auth.ReasonQuotaExceeded until older issuances leave the window.
+type Counter struct {
+	window time.Duration
+	quota  int
+	since  time.Time
+	logger *zap.Logger
+	now    func() time.Time
+
+	mu      sync.Mutex
+	entries map[key]*entry
+}
+
+// New creates a counter over window. A quota of zero only counts.
+func New(window time.Duration, quota int, logger *zap.Logger) *Counter {
+	return &Counter{
+		window:  window,
+		quota:   quota,
+		since:   time.Now(),
+		logger:  logger,
+		now:     time.Now,
+		entries: make(map[key]*entry),
+	}
+}
+
+// WrapHandler counts the user JWTs issued through h, denying ServiceAccounts over the quota.
+func (c *Counter) WrapHandler(h nats.AuthHandler) nats.AuthHandler {
+	return &countingHandler{next: h, counter: c}
+}
+
+// slotIndex returns the slot a time falls into
+func (c *Counter) slotIndex(t time.Time) int64 {
+	width := max(c.window/slots, time.Millisecond)
+	return t.UnixNano() / int64(width)
+}
+
+// count returns the issuances of an entry in the window ending in the current slot
+func (e *entry) count(current int64) int {
+	n := 0
+	for _, s := range e.slots {
+		if current-s.index < slots {
+			n += s.count
+		}
+	}
+	return n
+}
+
+// Take records an issuance for a ServiceAccount, unless the ServiceAccount already reached
+// the quota in the window, and reports whether it was recorded.
+func (c *Counter) Take(namespace, name string) bool {
+	now := c.now()
+	current := c.slotIndex(now)
+
+	c.mu.Lock()
+	k := key{namespace, name}
+	e, found := c.entries[k]
+	if !found {
+		e = &entry{}
+		c.entries[k] = e
+	}
+	if c.quota > 0 && e.count(current) >= c.quota {
+		e.denied++
+		first := e.denied == 1
+		c.mu.Unlock()
+
+		httpmetrics.IncrementIssuanceQuotaExceeded(namespace)
+		// Warn once per burst; the entry is forgotten once the ServiceAccount goes idle
+		if first {
+			c.logger.Warn("ServiceAccount exceeded its issuance quota",
+				zap.String("namespace", namespace),
+				zap.String("serviceaccount", name),
+				zap.Int("quota", c.quota),
+				zap.Duration("window", c.window))
+		}
+		return false
+	}
+	s := &e.slots[current%slots]
+	if s.index != current {
+		*s = slot{index: current}
+	}
+	s.count++
+	e.total++
+	e.last = now
+	c.mu.Unlock()
+
+	httpmetrics.IncrementServiceAccountIssuances(namespace, name)
+	return true
+}
+
+// Snapshot returns the ServiceAccounts issued user JWTs in the current window, sorted by
+// namespace and name.
+func (c *Counter) Snapshot() Snapshot {
+	current := c.slotIndex(c.now())
+
+	c.mu.Lock()
+	accounts := make([]ServiceAccount, 0, len(c.entries))
+	for k, e := range c.entries {
+		if n := e.count(current); n > 0 {
+			accounts = append(accounts, ServiceAccount{
+				Namespace: k.namespace,
+				Name:      k.name,
+				Window:    n,
+				Denied:    e.denied,
+				Total:     e.total,
+				LastIssue: e.last.UTC().Format(time.RFC3339),
+			})
+		}
+	}
+	c.mu.Unlock()
+
+	sort.Slice(accounts, func(i, j int) bool {
+		if accounts[i].Namespace != accounts[j].Namespace {
+			return accounts[i].Namespace < accounts[j].Namespace
+		}
+		return accounts[i].Name < accounts[j].Name
+	})
+	return Snapshot{Since: c.since.UTC(), Window: c.window.String(), Quota: c.quota, ServiceAccounts: accounts}
+}
+
+// ServeHTTP serves the snapshot as JSON
+func (c *Counter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(c.Snapshot()); err != nil {
+		c.logger.Error("failed to encode issuance counts", zap.Error(err))
+	}
+}
+
+// Run forgets the ServiceAccounts issued nothing in the last window, every window, until the
+// context is cancelled. Their per-ServiceAccount metric series are deleted along with them, so
+// the metric only covers recently active ServiceAccounts.
+func (c *Counter) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.window)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.prune()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// prune forgets the ServiceAccounts issued nothing in the window
+func (c *Counter) prune() {
+	current := c.slotIndex(c.now())
+
+	c.mu.Lock()
+	var idle []key
+	for k, e := range c.entries {
+		if e.count(current) == 0 {
+			delete(c.entries, k)
+			idle = append(idle, k)
+		}
+	}
+	c.mu.Unlock()
+
+	for _, k := range idle {
+		httpmetrics.DeleteServiceAccountIssuances(k.namespace, k.name)
+	}
+}
+
+// countingHandler counts the user JWTs issued to ServiceAccounts
+type countingHandler struct {
+	next    nats.AuthHandler
+	counter *Counter
+}
+
+func (h *countingHandler) Authorize(req *auth.AuthRequest) *auth.AuthResponse {
+	resp := h.next.Authorize(req)
+	// Non-Kubernetes identities have no ServiceAccount to count
+	if !resp.Allowed || resp.Namespace == "" || resp.ServiceAccount == "" {
+		return resp
+	}
+	if !h.counter.Take(resp.Namespace, resp.ServiceAccount) {
+		return &auth.AuthResponse{
+			Identity:       resp.Identity,
+			Namespace:      resp.Namespace,
+			ServiceAccount: resp.ServiceAccount,
+			Timings:        resp.Timings,
+			Reason:         auth.ReasonQuotaExceeded,
+		}
+	}
+	return resp
+}
@@ -0,0 +1,102 @@
+package issuance
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/portswigger-tim/nats-k8s-oidc-callout/internal/auth"
+)
+
+// identityHandler allows the token named after a namespace/serviceaccount identity
+type identityHandler struct{}
+
+func (identityHandler) Authorize(req *auth.AuthRequest) *auth.AuthResponse {
+	switch req.Token {
+	case "orders/api":
+		return &auth.AuthResponse{Allowed: true, Identity: req.Token, Namespace: "orders", ServiceAccount: "api", Reason: auth.ReasonAllowed}
+	case "billing/worker":
+		return &auth.AuthResponse{Allowed: true, Identity: req.Token, Namespace: "billing", ServiceAccount: "worker", Reason: auth.ReasonAllowed}
+	case "oidc-user":
+		return &auth.AuthResponse{Allowed: true, Identity: req.Token, Reason: auth.ReasonAllowed}
+	}
+	return &auth.AuthResponse{Identity: "denied/sa", Namespace: "denied", ServiceAccount: "sa", Reason: auth.ReasonAccessDisabled}
+}
+
+func TestCounter_Quota(t *testing.T) {
+	counter := New(time.Minute, 2, zap.NewNop())
+	now := time.Unix(1700000000, 0)
+	counter.now = func() time.Time { return now }
+	handler := counter.WrapHandler(identityHandler{})
+
+	authorize := func(token string) *auth.AuthResponse {
+		return handler.Authorize(&auth.AuthRequest{Token: token})
+	}
+
+	for i := range 2 {
+		if resp := authorize("orders/api"); !resp.Allowed {
+			t.Fatalf("authorization %d denied with %q, want allowed under the quota", i+1, resp.Reason)
+		}
+	}
+	resp := authorize("orders/api")
+	if resp.Allowed || resp.Reason != auth.ReasonQuotaExceeded || resp.Identity != "orders/api" {
+		t.Errorf("third authorization = %+v, want denied with %q for orders/api", resp, auth.ReasonQuotaExceeded)
+	}
+
+	// Other ServiceAccounts and non-Kubernetes identities have their own, or no, quota
+	if resp := authorize("billing/worker"); !resp.Allowed {
+		t.Errorf("billing/worker denied with %q, want allowed", resp.Reason)
+	}
+	for range 3 {
+		if resp := authorize("oidc-user"); !resp.Allowed {
+			t.Errorf("oidc-user denied with %q, want allowed", resp.Reason)
+		}
+	}
+
+	// Issuances leave the window one slot at a time
+	now = now.Add(50 * time.Second)
+	if resp := authorize("orders/api"); resp.Allowed {
+		t.Error("orders/api allowed before its issuances left the window")
+	}
+	now = now.Add(15 * time.Second)
+	if resp := authorize("orders/api"); !resp.Allowed {
+		t.Errorf("orders/api denied with %q after its issuances left the window", resp.Reason)
+	}
+}
+
+func TestCounter_Snapshot(t *testing.T) {
+	counter := New(time.Minute, 0, zap.NewNop())
+	now := time.Unix(1700000000, 0)
+	counter.now = func() time.Time { return now }
+	handler := counter.WrapHandler(identityHandler{})
+
+	for _, token := range []string{"orders/api", "orders/api", "orders/api", "billing/worker", "oidc-user", "denied/sa"} {
+		if resp := handler.Authorize(&auth.AuthRequest{Token: token}); token != "denied/sa" && !resp.Allowed {
+			t.Fatalf("%s denied with %q without a quota", token, resp.Reason)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	counter.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, Path, nil))
+	var snapshot Snapshot
+	if err := json.NewDecoder(rec.Body).Decode(&snapshot); err != nil {
+		t.Fatalf("invalid snapshot %s: %v", rec.Body, err)
+	}
+	if snapshot.Window != "1m0s" || len(snapshot.ServiceAccounts) != 2 {
+		t.Fatalf("snapshot = %+v, want billing/worker and orders/api over 1m0s", snapshot)
+	}
+	if sa := snapshot.ServiceAccounts[1]; sa.Namespace != "orders" || sa.Window != 3 || sa.Total != 3 {
+		t.Errorf("ServiceAccounts[1] = %+v, want orders/api with 3 issuances", sa)
+	}
+
+	// ServiceAccounts idle for a window are forgotten, keeping their totals out of the snapshot
+	now = now.Add(time.Minute)
+	counter.prune()
+	if snapshot := counter.Snapshot(); len(snapshot.ServiceAccounts) != 0 || len(counter.entries) != 0 {
+		t.Errorf("after an idle window: snapshot = %+v, %d entries, want none", snapshot, len(counter.entries))
+	}
+}
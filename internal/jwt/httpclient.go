@@ -0,0 +1,63 @@
+package jwt
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+// HTTPClientOptions configure the HTTP client the JWKS is fetched with. The zero value is a
+// client trusting the system CAs and using the proxy from HTTPS_PROXY and NO_PROXY.
+type HTTPClientOptions struct {
+	// CAFile is a PEM bundle of CAs trusted in addition to the system CAs, for OIDC discovery
+	// endpoints behind a private CA
+	CAFile string
+	// ProxyURL is the proxy requests go through, overriding the environment
+	ProxyURL string
+	// MinTLSVersion is the lowest TLS version accepted: "1.2" or "1.3" (default: Go's default)
+	MinTLSVersion string
+}
+
+// NewHTTPClient builds the HTTP client for fetching the JWKS.
+func NewHTTPClient(opts HTTPClientOptions) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{}
+
+	if opts.CAFile != "" {
+		pem, err := os.ReadFile(opts.CAFile) //nolint:gosec // path comes from configuration
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", opts.CAFile)
+		}
+		transport.TLSClientConfig.RootCAs = pool
+	}
+
+	switch opts.MinTLSVersion {
+	case "":
+	case "1.2":
+		transport.TLSClientConfig.MinVersion = tls.VersionTLS12
+	case "1.3":
+		transport.TLSClientConfig.MinVersion = tls.VersionTLS13
+	default:
+		return nil, fmt.Errorf("unsupported minimum TLS version %q (want 1.2 or 1.3)", opts.MinTLSVersion)
+	}
+
+	if opts.ProxyURL != "" {
+		proxy, err := url.Parse(opts.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL: %w", err)
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+
+	return &http.Client{Transport: transport}, nil
+}
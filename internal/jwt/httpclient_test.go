@@ -0,0 +1,69 @@
+package jwt
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewHTTPClient_CAFile(t *testing.T) {
+	jwksData, err := os.ReadFile(filepath.Join("..", "..", "testdata", "jwks.json"))
+	if err != nil {
+		t.Fatalf("failed to read JWKS: %v", err)
+	}
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(jwksData)
+	}))
+	defer server.Close()
+
+	// The test server's certificate is not trusted by the system CAs
+	client, err := NewHTTPClient(HTTPClientOptions{})
+	if err != nil {
+		t.Fatalf("NewHTTPClient() error = %v", err)
+	}
+	if _, err := NewValidatorFromURLWithClient(server.URL, "https://test-issuer.com", "test-audience", client); err == nil {
+		t.Fatal("expected an untrusted certificate to fail")
+	}
+
+	caFile := filepath.Join(t.TempDir(), "ca.crt")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(caFile, caPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	client, err = NewHTTPClient(HTTPClientOptions{CAFile: caFile, MinTLSVersion: "1.2"})
+	if err != nil {
+		t.Fatalf("NewHTTPClient() error = %v", err)
+	}
+	validator, err := NewValidatorFromURLWithClient(server.URL, "https://test-issuer.com", "test-audience", client)
+	if err != nil {
+		t.Fatalf("expected the CA file to be trusted, got %v", err)
+	}
+	validator.jwks.EndBackground()
+}
+
+func TestNewHTTPClient_InvalidOptions(t *testing.T) {
+	emptyCA := filepath.Join(t.TempDir(), "empty.crt")
+	if err := os.WriteFile(emptyCA, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		opts HTTPClientOptions
+	}{
+		{name: "missing CA file", opts: HTTPClientOptions{CAFile: filepath.Join(t.TempDir(), "missing.crt")}},
+		{name: "CA file without certificates", opts: HTTPClientOptions{CAFile: emptyCA}},
+		{name: "unsupported TLS version", opts: HTTPClientOptions{MinTLSVersion: "1.1"}},
+		{name: "invalid proxy URL", opts: HTTPClientOptions{ProxyURL: "http://proxy:port"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewHTTPClient(tt.opts); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
@@ -2,14 +2,19 @@
 package jwt
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/MicahParks/keyfunc/v2"
 	"github.com/golang-jwt/jwt/v5"
+
+	httpmetrics "github.com/portswigger-tim/nats-k8s-oidc-callout/internal/httpserver"
 )
 
 // Validator handles JWT validation using JWKS keys.
@@ -18,12 +23,26 @@ type Validator struct {
 	issuer   string
 	audience string
 	timeFunc func() time.Time // Injectable time function for testing
+
+	// requireK8sClaims rejects tokens without the kubernetes.io claim (default: true)
+	requireK8sClaims bool
+
+	// JWKS refresh tracking, used to report a stale key set
+	refreshMu   sync.RWMutex
+	lastRefresh time.Time
+	refreshErr  error // most recent refresh failure since lastRefresh
+
+	cancel context.CancelFunc // ends background refreshes, nil for file-based validators
 }
 
 // Claims represents the validated JWT claims including Kubernetes-specific fields.
 type Claims struct {
+	Subject        string
 	Namespace      string
 	ServiceAccount string
+	Pod            string // pod the token is bound to, empty for tokens not bound to a pod
+	Node           string // node the token's pod runs on, empty for tokens without the node claim
+	ID             string // unique token ID (jti), empty when the token has none
 	Issuer         string
 	Audience       []string
 	ExpiresAt      time.Time
@@ -37,34 +56,114 @@ var (
 	ErrInvalidSignature = errors.New("invalid token signature")
 	ErrInvalidClaims    = errors.New("invalid token claims")
 	ErrMissingK8sClaims = errors.New("missing kubernetes claims")
+
+	// Specific claims failures; both also match ErrInvalidClaims
+	ErrInvalidIssuer   = errors.New("issuer not accepted")
+	ErrInvalidAudience = errors.New("audience not accepted")
 )
 
 // NewValidatorFromURL creates a new JWT validator that fetches JWKS from an HTTP URL.
 // This is the production constructor that fetches JWKS with automatic refresh.
 // The keyfunc library handles caching and periodic refresh automatically.
 func NewValidatorFromURL(jwksURL, issuer, audience string) (*Validator, error) {
-	// Fetch JWKS from URL with automatic refresh
-	// keyfunc.Get() handles:
-	// - HTTP fetching
-	// - Automatic refresh (default 1 hour)
-	// - Caching
-	// - Error handling and retries
-	jwks, err := keyfunc.Get(jwksURL, keyfunc.Options{
-		RefreshInterval:   time.Hour,        // Refresh keys every hour
-		RefreshRateLimit:  time.Minute * 5,  // Rate limit refreshes to once per 5 minutes
-		RefreshTimeout:    time.Second * 10, // Timeout for refresh requests
-		RefreshUnknownKID: true,             // Refresh if we encounter an unknown key ID
-	})
-	if err != nil {
-		return nil, fmt.Errorf("failed to fetch JWKS from URL: %w", err)
+	return NewValidatorFromURLWithClient(jwksURL, issuer, audience, nil)
+}
+
+// NewValidatorFromURLWithClient is NewValidatorFromURL fetching the JWKS with the given HTTP
+// client (see NewHTTPClient), or http.DefaultClient when nil.
+func NewValidatorFromURLWithClient(jwksURL, issuer, audience string, client *http.Client) (*Validator, error) {
+	return NewValidatorFromURLWithOptions(jwksURL, issuer, audience, FetchOptions{Client: client})
+}
+
+// maxFetchBackoff caps the delay between JWKS fetch attempts
+const maxFetchBackoff = time.Minute
+
+// FetchOptions configure how the JWKS is fetched from a URL. The zero value fetches with
+// http.DefaultClient, a 10s timeout per request and no retries.
+type FetchOptions struct {
+	// Client is the HTTP client the JWKS is fetched with (default: http.DefaultClient)
+	Client *http.Client
+	// Timeout bounds each JWKS request, including background refreshes (default: 10s)
+	Timeout time.Duration
+	// Retries is the number of further attempts when the initial fetch fails
+	Retries int
+	// Backoff is the delay before the first retry, doubled for each further retry up to a
+	// minute (default: 1s)
+	Backoff time.Duration
+	// Background starts the validator without keys when every initial attempt failed, and keeps
+	// retrying in the background until the key set loads. Every token is rejected until then;
+	// see Loaded.
+	Background bool
+}
+
+// NewValidatorFromURLWithOptions is NewValidatorFromURL with the fetch policy in opts.
+func NewValidatorFromURLWithOptions(jwksURL, issuer, audience string, opts FetchOptions) (*Validator, error) {
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	if opts.Backoff <= 0 {
+		opts.Backoff = time.Second
 	}
 
-	return &Validator{
-		jwks:     jwks,
+	v := &Validator{
 		issuer:   issuer,
 		audience: audience,
 		timeFunc: time.Now, // Default to real time
-	}, nil
+
+		requireK8sClaims: true,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	v.cancel = cancel
+
+	// keyfunc handles caching, the hourly refresh and refreshing on unknown key IDs; the initial
+	// fetch is retried here
+	options := keyfunc.Options{
+		Ctx:                 ctx,
+		Client:              opts.Client,
+		RefreshInterval:     time.Hour,       // Refresh keys every hour
+		RefreshRateLimit:    time.Minute * 5, // Rate limit refreshes to once per 5 minutes
+		RefreshTimeout:      opts.Timeout,
+		RefreshUnknownKID:   true, // Refresh if we encounter an unknown key ID
+		RefreshErrorHandler: v.recordRefreshError,
+		ResponseExtractor:   v.extractJWKS,
+	}
+	backoff := opts.Backoff
+	for attempt := 0; ; attempt++ {
+		// The last attempt starts without keys on failure when retrying in the background
+		options.TolerateInitialJWKHTTPError = opts.Background && attempt == opts.Retries
+		jwks, err := keyfunc.Get(jwksURL, options)
+		if err == nil {
+			v.jwks = jwks
+			break
+		}
+		if attempt == opts.Retries {
+			cancel()
+			return nil, fmt.Errorf("failed to fetch JWKS from URL after %d attempts: %w", attempt+1, err)
+		}
+		v.recordRefreshError(err)
+		time.Sleep(backoff)
+		backoff = min(backoff*2, maxFetchBackoff)
+	}
+
+	if !v.Loaded() {
+		go v.retryInitialFetch(ctx, backoff)
+	}
+	return v, nil
+}
+
+// retryInitialFetch refreshes the JWKS with backoff until a key set loads, for validators
+// started without keys
+func (v *Validator) retryInitialFetch(ctx context.Context, backoff time.Duration) {
+	for !v.Loaded() {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		// Failures are reported to the refresh error handler
+		_ = v.jwks.Refresh(ctx, keyfunc.RefreshOptions{IgnoreRateLimit: true})
+		backoff = min(backoff*2, maxFetchBackoff)
+	}
 }
 
 // NewValidatorFromFile creates a new JWT validator that loads JWKS from a file.
@@ -82,12 +181,17 @@ func NewValidatorFromFile(jwksPath, issuer, audience string) (*Validator, error)
 		return nil, fmt.Errorf("failed to parse JWKS: %w", err)
 	}
 
-	return &Validator{
+	v := &Validator{
 		jwks:     jwks,
 		issuer:   issuer,
 		audience: audience,
 		timeFunc: time.Now, // Default to real time
-	}, nil
+
+		requireK8sClaims: true,
+	}
+	v.recordRefresh()
+
+	return v, nil
 }
 
 // SetTimeFunc sets a custom time function for testing purposes.
@@ -95,6 +199,82 @@ func (v *Validator) SetTimeFunc(fn func() time.Time) {
 	v.timeFunc = fn
 }
 
+// SetRequireK8sClaims controls whether tokens must carry the kubernetes.io claim.
+// When disabled, tokens from non-Kubernetes OIDC issuers are accepted and identified
+// by their subject claim only (Namespace and ServiceAccount are left empty).
+func (v *Validator) SetRequireK8sClaims(require bool) {
+	v.requireK8sClaims = require
+}
+
+// Close stops refreshing the JWKS in the background.
+func (v *Validator) Close() {
+	if v.cancel != nil {
+		v.cancel()
+	}
+}
+
+// Loaded reports whether a key set has been loaded. A validator started in the background
+// (see FetchOptions) rejects every token until it has.
+func (v *Validator) Loaded() bool {
+	v.refreshMu.RLock()
+	defer v.refreshMu.RUnlock()
+	return !v.lastRefresh.IsZero()
+}
+
+// Stale reports whether the JWKS has not been refreshed successfully within maxAge,
+// with a description including the most recent refresh error. A rotated signing key
+// is only picked up by a refresh, so a stale JWKS can cause every token to be rejected.
+func (v *Validator) Stale(maxAge time.Duration) (bool, string) {
+	v.refreshMu.RLock()
+	defer v.refreshMu.RUnlock()
+
+	var message string
+	if v.lastRefresh.IsZero() {
+		message = "JWKS not loaded"
+	} else {
+		age := v.timeFunc().Sub(v.lastRefresh)
+		if age <= maxAge {
+			return false, ""
+		}
+		message = "JWKS not refreshed for " + age.Truncate(time.Second).String()
+	}
+	if v.refreshErr != nil {
+		message += ": " + v.refreshErr.Error()
+	}
+	return true, message
+}
+
+// recordRefresh records a successful JWKS load
+func (v *Validator) recordRefresh() {
+	now := v.timeFunc()
+	v.refreshMu.Lock()
+	v.lastRefresh = now
+	v.refreshErr = nil
+	v.refreshMu.Unlock()
+
+	httpmetrics.SetJWKSLastRefresh(now)
+}
+
+// recordRefreshError records a failed background JWKS refresh
+func (v *Validator) recordRefreshError(err error) {
+	v.refreshMu.Lock()
+	defer v.refreshMu.Unlock()
+	v.refreshErr = err
+}
+
+// extractJWKS reads a JWKS response, recording the refresh as successful once the key set parses.
+// Parse failures are left to keyfunc, which reports them to the refresh error handler.
+func (v *Validator) extractJWKS(ctx context.Context, resp *http.Response) (json.RawMessage, error) {
+	raw, err := keyfunc.ResponseExtractorStatusOK(ctx, resp)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := keyfunc.NewJSON(raw); err == nil {
+		v.recordRefresh()
+	}
+	return raw, nil
+}
+
 // Validate validates a JWT token and returns the extracted claims.
 // This is an alias for ValidateToken to match the auth.JWTValidator interface.
 func (v *Validator) Validate(token string) (*Claims, error) {
@@ -161,7 +341,7 @@ func (v *Validator) validateStandardClaims(claims jwt.MapClaims) error {
 func validateIssuer(claims jwt.MapClaims, expectedIssuer string) error {
 	iss, ok := claims["iss"].(string)
 	if !ok || iss != expectedIssuer {
-		return fmt.Errorf("%w: issuer mismatch (expected %q, got %q)", ErrInvalidClaims, expectedIssuer, iss)
+		return fmt.Errorf("%w: %w: issuer mismatch (expected %q, got %q)", ErrInvalidClaims, ErrInvalidIssuer, expectedIssuer, iss)
 	}
 	return nil
 }
@@ -170,7 +350,7 @@ func validateIssuer(claims jwt.MapClaims, expectedIssuer string) error {
 func validateAudience(claims jwt.MapClaims, expectedAudience string) error {
 	aud, ok := claims["aud"]
 	if !ok {
-		return fmt.Errorf("%w: missing audience", ErrInvalidClaims)
+		return fmt.Errorf("%w: %w: missing audience", ErrInvalidClaims, ErrInvalidAudience)
 	}
 
 	// Audience can be string or []string
@@ -185,7 +365,7 @@ func validateAudience(claims jwt.MapClaims, expectedAudience string) error {
 			}
 		}
 	default:
-		return fmt.Errorf("%w: invalid audience format", ErrInvalidClaims)
+		return fmt.Errorf("%w: %w: invalid audience format", ErrInvalidClaims, ErrInvalidAudience)
 	}
 
 	// Check if expected audience is in the list
@@ -197,7 +377,7 @@ func validateAudience(claims jwt.MapClaims, expectedAudience string) error {
 		}
 	}
 	if !found {
-		return fmt.Errorf("%w: audience mismatch (expected %q)", ErrInvalidClaims, expectedAudience)
+		return fmt.Errorf("%w: %w: audience mismatch (expected %q)", ErrInvalidClaims, ErrInvalidAudience, expectedAudience)
 	}
 
 	return nil
@@ -276,6 +456,28 @@ func extractServiceAccountName(k8sMap map[string]interface{}) (string, error) {
 	return saName, nil
 }
 
+// extractPodName extracts the optional pod name from kubernetes.io map.
+// Tokens projected into a pod carry it; tokens requested for the ServiceAccount alone do not.
+func extractPodName(k8sMap map[string]interface{}) string {
+	podMap, ok := k8sMap["pod"].(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	podName, _ := podMap["name"].(string)
+	return podName
+}
+
+// extractNodeName extracts the optional node name from kubernetes.io map. Tokens projected
+// into a pod carry it on Kubernetes 1.30 and later.
+func extractNodeName(k8sMap map[string]interface{}) string {
+	nodeMap, ok := k8sMap["node"].(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	nodeName, _ := nodeMap["name"].(string)
+	return nodeName
+}
+
 // extractAudienceList extracts the audience claim and converts it to a string slice.
 func extractAudienceList(claims jwt.MapClaims) []string {
 	aud, ok := claims["aud"]
@@ -304,7 +506,10 @@ func (v *Validator) extractK8sClaims(claims jwt.MapClaims) (*Claims, error) {
 	// Extract kubernetes.io map
 	k8sMap, err := extractK8sMap(claims)
 	if err != nil {
-		return nil, err
+		if _, present := claims["kubernetes.io"]; present || v.requireK8sClaims {
+			return nil, err
+		}
+		return extractSubjectClaims(claims)
 	}
 
 	// Extract namespace
@@ -325,15 +530,48 @@ func (v *Validator) extractK8sClaims(claims jwt.MapClaims) (*Claims, error) {
 		issuer = "" // Default to empty string if not present
 	}
 
+	// Subject is optional for Kubernetes tokens, and so is the token ID
+	subject, _ := claims["sub"].(string)
+	tokenID, _ := claims["jti"].(string)
+
 	// Build Claims struct
 	result := &Claims{
+		Subject:        subject,
 		Namespace:      namespace,
 		ServiceAccount: saName,
+		Pod:            extractPodName(k8sMap),
+		Node:           extractNodeName(k8sMap),
+		ID:             tokenID,
 		Issuer:         issuer,
 		Audience:       extractAudienceList(claims),
 	}
+	setTimeClaims(result, claims)
+
+	return result, nil
+}
+
+// extractSubjectClaims builds Claims for a token without Kubernetes claims,
+// identified solely by its subject.
+func extractSubjectClaims(claims jwt.MapClaims) (*Claims, error) {
+	subject, ok := claims["sub"].(string)
+	if !ok || subject == "" {
+		return nil, fmt.Errorf("%w: subject claim missing or empty", ErrInvalidClaims)
+	}
 
-	// Extract time claims
+	issuer, _ := claims["iss"].(string)
+
+	result := &Claims{
+		Subject:  subject,
+		Issuer:   issuer,
+		Audience: extractAudienceList(claims),
+	}
+	setTimeClaims(result, claims)
+
+	return result, nil
+}
+
+// setTimeClaims copies the exp, iat and nbf claims into result.
+func setTimeClaims(result *Claims, claims jwt.MapClaims) {
 	if exp, ok := claims["exp"].(float64); ok {
 		result.ExpiresAt = time.Unix(int64(exp), 0)
 	}
@@ -343,8 +581,6 @@ func (v *Validator) extractK8sClaims(claims jwt.MapClaims) (*Claims, error) {
 	if nbf, ok := claims["nbf"].(float64); ok {
 		result.NotBefore = time.Unix(int64(nbf), 0)
 	}
-
-	return result, nil
 }
 
 // IsExpiredError checks if the error is due to token expiration.
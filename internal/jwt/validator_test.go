@@ -1,10 +1,22 @@
 package jwt
 
 import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	jwtlib "github.com/golang-jwt/jwt/v5"
 )
 
 func TestNewValidatorFromFile_LoadsJWKS(t *testing.T) {
@@ -182,6 +194,9 @@ func TestValidateToken_WrongIssuer(t *testing.T) {
 	if !IsClaimsError(err) {
 		t.Errorf("expected claims validation error, got %v", err)
 	}
+	if !errors.Is(err, ErrInvalidIssuer) {
+		t.Errorf("expected ErrInvalidIssuer, got %v", err)
+	}
 }
 
 func TestValidateToken_WrongAudience(t *testing.T) {
@@ -220,6 +235,9 @@ func TestValidateToken_WrongAudience(t *testing.T) {
 	if !IsClaimsError(err) {
 		t.Errorf("expected claims validation error, got %v", err)
 	}
+	if !errors.Is(err, ErrInvalidAudience) {
+		t.Errorf("expected ErrInvalidAudience, got %v", err)
+	}
 }
 
 func TestValidateToken_MissingK8sClaims(t *testing.T) {
@@ -228,3 +246,252 @@ func TestValidateToken_MissingK8sClaims(t *testing.T) {
 	// For now, we'll skip this and implement it later with a mock token
 	t.Skip("Need to create test token without K8s claims")
 }
+
+func TestValidateToken_NonKubernetesToken(t *testing.T) {
+	key, jwksPath := writeTestJWKS(t)
+	now := time.Now()
+	token := signTestToken(t, key, jwtlib.MapClaims{
+		"iss": "https://idp.example.com",
+		"aud": "nats",
+		"sub": "ci-runner@example.com",
+		"exp": now.Add(time.Hour).Unix(),
+		"iat": now.Unix(),
+	})
+
+	validator, err := NewValidatorFromFile(jwksPath, "https://idp.example.com", "nats")
+	if err != nil {
+		t.Fatalf("failed to create validator: %v", err)
+	}
+
+	// Kubernetes claims are required by default
+	if _, err := validator.ValidateToken(token); !errors.Is(err, ErrMissingK8sClaims) {
+		t.Fatalf("expected missing k8s claims error, got %v", err)
+	}
+
+	validator.SetRequireK8sClaims(false)
+	claims, err := validator.ValidateToken(token)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if claims.Subject != "ci-runner@example.com" {
+		t.Errorf("Subject = %q, want %q", claims.Subject, "ci-runner@example.com")
+	}
+	if claims.Namespace != "" || claims.ServiceAccount != "" {
+		t.Errorf("expected empty namespace and service account, got %q/%q", claims.Namespace, claims.ServiceAccount)
+	}
+}
+
+func TestValidateToken_PodClaim(t *testing.T) {
+	key, jwksPath := writeTestJWKS(t)
+	now := time.Now()
+	token := signTestToken(t, key, jwtlib.MapClaims{
+		"iss": "https://kubernetes.default.svc",
+		"aud": "nats",
+		"sub": "system:serviceaccount:production:app",
+		"exp": now.Add(time.Hour).Unix(),
+		"iat": now.Unix(),
+		"jti": "0b6c1a52-3f4e-4d7a-9e1b-6f0c2d8a7e11",
+		"kubernetes.io": map[string]interface{}{
+			"namespace":      "production",
+			"serviceaccount": map[string]interface{}{"name": "app", "uid": "1"},
+			"pod":            map[string]interface{}{"name": "app-7d9f-x2k4p", "uid": "2"},
+			"node":           map[string]interface{}{"name": "ip-10-0-1-17.ec2.internal", "uid": "3"},
+		},
+	})
+
+	validator, err := NewValidatorFromFile(jwksPath, "https://kubernetes.default.svc", "nats")
+	if err != nil {
+		t.Fatalf("failed to create validator: %v", err)
+	}
+
+	claims, err := validator.ValidateToken(token)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if claims.Pod != "app-7d9f-x2k4p" {
+		t.Errorf("Pod = %q, want %q", claims.Pod, "app-7d9f-x2k4p")
+	}
+	if claims.Node != "ip-10-0-1-17.ec2.internal" {
+		t.Errorf("Node = %q, want %q", claims.Node, "ip-10-0-1-17.ec2.internal")
+	}
+	if claims.ID != "0b6c1a52-3f4e-4d7a-9e1b-6f0c2d8a7e11" {
+		t.Errorf("ID = %q, want the jti claim", claims.ID)
+	}
+}
+
+func TestValidateToken_NonKubernetesTokenMissingSubject(t *testing.T) {
+	key, jwksPath := writeTestJWKS(t)
+	token := signTestToken(t, key, jwtlib.MapClaims{
+		"iss": "https://idp.example.com",
+		"aud": "nats",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	})
+
+	validator, err := NewValidatorFromFile(jwksPath, "https://idp.example.com", "nats")
+	if err != nil {
+		t.Fatalf("failed to create validator: %v", err)
+	}
+	validator.SetRequireK8sClaims(false)
+
+	if _, err := validator.ValidateToken(token); !IsClaimsError(err) {
+		t.Errorf("expected claims error, got %v", err)
+	}
+}
+
+// testKeyID is the key ID used for locally generated test tokens
+const testKeyID = "test-key"
+
+// writeTestJWKS generates an RSA key and writes its public half as a JWKS file.
+func writeTestJWKS(t *testing.T) (*rsa.PrivateKey, string) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	jwks := map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"alg": "RS256",
+			"use": "sig",
+			"kid": testKeyID,
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}},
+	}
+	data, err := json.Marshal(jwks)
+	if err != nil {
+		t.Fatalf("failed to marshal JWKS: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("failed to write JWKS: %v", err)
+	}
+	return key, path
+}
+
+// signTestToken signs claims with key using RS256.
+func signTestToken(t *testing.T, key *rsa.PrivateKey, claims jwtlib.MapClaims) string {
+	t.Helper()
+
+	token := jwtlib.NewWithClaims(jwtlib.SigningMethodRS256, claims)
+	token.Header["kid"] = testKeyID
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+	return signed
+}
+
+func TestValidator_Stale(t *testing.T) {
+	jwksPath := filepath.Join("..", "..", "testdata", "jwks.json")
+	validator, err := NewValidatorFromFile(jwksPath, "https://test-issuer.com", "test-audience")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if stale, _ := validator.Stale(time.Hour); stale {
+		t.Error("expected freshly loaded JWKS not to be stale")
+	}
+
+	// Without a successful refresh the JWKS becomes stale, reporting the last refresh error
+	validator.recordRefreshError(errors.New("connection refused"))
+	validator.SetTimeFunc(func() time.Time { return time.Now().Add(2 * time.Hour) })
+	stale, message := validator.Stale(time.Hour)
+	if !stale {
+		t.Fatal("expected JWKS to be stale after threshold")
+	}
+	if !strings.Contains(message, "connection refused") {
+		t.Errorf("expected message to include refresh error, got %q", message)
+	}
+
+	// A successful refresh clears the stale state
+	validator.recordRefresh()
+	if stale, _ := validator.Stale(time.Hour); stale {
+		t.Error("expected JWKS not to be stale after refresh")
+	}
+}
+
+func TestNewValidatorFromURL_RecordsRefresh(t *testing.T) {
+	jwksData, err := os.ReadFile(filepath.Join("..", "..", "testdata", "jwks.json"))
+	if err != nil {
+		t.Fatalf("failed to read JWKS: %v", err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(jwksData)
+	}))
+	defer server.Close()
+
+	validator, err := NewValidatorFromURL(server.URL, "https://test-issuer.com", "test-audience")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer validator.jwks.EndBackground()
+
+	if stale, message := validator.Stale(time.Hour); stale {
+		t.Errorf("expected JWKS fetched at startup not to be stale: %s", message)
+	}
+}
+
+// flakyJWKSServer serves the test JWKS after failing the first failures requests
+func flakyJWKSServer(t *testing.T, failures int32) *httptest.Server {
+	t.Helper()
+	jwksData, err := os.ReadFile(filepath.Join("..", "..", "testdata", "jwks.json"))
+	if err != nil {
+		t.Fatalf("failed to read JWKS: %v", err)
+	}
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) <= failures {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write(jwksData)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestNewValidatorFromURLWithOptions_Retries(t *testing.T) {
+	opts := FetchOptions{Retries: 2, Backoff: time.Millisecond}
+
+	validator, err := NewValidatorFromURLWithOptions(flakyJWKSServer(t, 2).URL, "https://test-issuer.com", "test-audience", opts)
+	if err != nil {
+		t.Fatalf("expected the third attempt to succeed, got %v", err)
+	}
+	validator.Close()
+	if !validator.Loaded() {
+		t.Error("expected the JWKS to be loaded")
+	}
+
+	_, err = NewValidatorFromURLWithOptions(flakyJWKSServer(t, 3).URL, "https://test-issuer.com", "test-audience", opts)
+	if err == nil || !strings.Contains(err.Error(), "after 3 attempts") {
+		t.Errorf("expected an error after 3 attempts, got %v", err)
+	}
+}
+
+func TestNewValidatorFromURLWithOptions_Background(t *testing.T) {
+	opts := FetchOptions{Backoff: time.Millisecond, Background: true}
+	validator, err := NewValidatorFromURLWithOptions(flakyJWKSServer(t, 3).URL, "https://test-issuer.com", "test-audience", opts)
+	if err != nil {
+		t.Fatalf("expected the validator to start without keys, got %v", err)
+	}
+	defer validator.Close()
+
+	if validator.Loaded() {
+		t.Fatal("expected the JWKS not to be loaded after a failed fetch")
+	}
+	if stale, message := validator.Stale(time.Hour); !stale || !strings.Contains(message, "not loaded") {
+		t.Errorf("Stale() = %v, %q; want not loaded", stale, message)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !validator.Loaded() {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the JWKS to load in the background")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,27 @@
+package k8s
+
+import (
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// AnnotationAccount is the annotation key selecting the NATS account a ServiceAccount's
+// clients are placed in, for deployments issuing for several accounts. It must name an
+// account the deployment has an issuer for.
+const AnnotationAccount = "nats.io/account"
+
+// Account returns the NATS account a ServiceAccount selects, or "" for the default account
+func (c *Cache) Account(namespace, name string) string {
+	perms, found := c.lookup(namespace, name)
+	if !found {
+		return ""
+	}
+	return perms.Account
+}
+
+// account returns the account a ServiceAccount selects with its annotation. Accounts no issuer
+// serves are kept as-is so that authorization fails closed rather than using the default account.
+func account(sa *corev1.ServiceAccount) string {
+	return strings.TrimSpace(sa.Annotations[AnnotationAccount])
+}
@@ -3,12 +3,17 @@ package k8s
 
 import (
 	"fmt"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	httpmetrics "github.com/portswigger-tim/nats-k8s-oidc-callout/internal/httpserver"
 	"go.uber.org/zap"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
 )
 
 const (
@@ -16,43 +21,203 @@ const (
 	AnnotationAllowedPubSubjects = "nats.io/allowed-pub-subjects"
 	// AnnotationAllowedSubSubjects is the annotation key for allowed NATS subscribe subjects.
 	AnnotationAllowedSubSubjects = "nats.io/allowed-sub-subjects"
+	// AnnotationDeniedPubSubjects is the annotation key for NATS publish subjects denied even
+	// where the allowed subjects would grant them.
+	AnnotationDeniedPubSubjects = "nats.io/denied-pub-subjects"
+	// AnnotationDeniedSubSubjects is the annotation key for NATS subscribe subjects denied even
+	// where the allowed subjects would grant them.
+	AnnotationDeniedSubSubjects = "nats.io/denied-sub-subjects"
+	// AnnotationSharedInbox is the annotation key that, set to "false", omits the shared
+	// _INBOX.> subscribe grant, leaving only the ServiceAccount's private inbox, or set to
+	// "true", keeps it when SetSharedInbox(false) omits it by default.
+	AnnotationSharedInbox = "nats.io/shared-inbox"
+	// AnnotationInboxPrefix is the annotation key for a custom inbox prefix the ServiceAccount may subscribe to.
+	AnnotationInboxPrefix = "nats.io/inbox-prefix"
+	// AnnotationEnabled is the annotation key that, set to "false" on a ServiceAccount or
+	// namespace, denies NATS access even with a valid token.
+	AnnotationEnabled = "nats.io/enabled"
+	// AnnotationBearer is the annotation key that, set to "true", requests bearer user JWTs
+	// for clients that cannot sign the server nonce.
+	AnnotationBearer = "nats.io/bearer"
+	// AnnotationReadOnly is the annotation key that, set to "true", strips all publish
+	// permissions, for observers that must never inject messages.
+	AnnotationReadOnly = "nats.io/read-only"
+	// AnnotationTokenTTL is the annotation key for a shorter lifetime of the user JWTs issued
+	// to a ServiceAccount, as a Go duration (e.g. "1m").
+	AnnotationTokenTTL = "nats.io/token-ttl"
 )
 
+// minTokenTTL is the shortest nats.io/token-ttl accepted; user JWT expiry has one-second resolution
+const minTokenTTL = time.Second
+
+// Limit names reported in logs, metrics and events when an annotation exceeds a limit
+const (
+	LimitAnnotationLength = "annotation_length"
+	LimitSubjectCount     = "subject_count"
+)
+
+// Limits bounds what a single ServiceAccount annotation can contribute, so one
+// pathological ServiceAccount cannot bloat the cache or every JWT issued for it.
+// Zero values disable a limit.
+type Limits struct {
+	// MaxAnnotationLength is the longest subject annotation value accepted, in bytes
+	MaxAnnotationLength int
+	// MaxSubjects is the most subjects taken from each subject annotation
+	MaxSubjects int
+}
+
 // Permissions represents the NATS publish and subscribe permissions for a ServiceAccount
 type Permissions struct {
-	Publish   []string
-	Subscribe []string
+	Publish       []string      `json:"publish"`
+	Subscribe     []string      `json:"subscribe"`
+	DenyPublish   []string      `json:"denyPublish,omitempty"`   // publish subjects denied by annotation
+	DenySubscribe []string      `json:"denySubscribe,omitempty"` // subscribe subjects denied by annotation
+	InboxPrefix   string        `json:"inboxPrefix,omitempty"`   // custom inbox prefix, if granted
+	Disabled      bool          `json:"disabled,omitempty"`      // NATS access disabled by annotation
+	Bearer        bool          `json:"bearer,omitempty"`        // bearer user JWTs requested by annotation
+	ReadOnly      bool          `json:"readOnly,omitempty"`      // publish permissions stripped by annotation
+	TokenTTL      time.Duration `json:"tokenTTL,omitempty"`      // shorter user JWT lifetime requested by annotation
+	Class         Class         `json:"class,omitempty"`         // request-reply class
+	Limits        UserLimits    `json:"limits,omitzero"`         // NATS user limits requested by annotation
+	Role          string        `json:"role,omitempty"`          // scoped signing key role
+	Account       string        `json:"account,omitempty"`       // NATS account selected by annotation
+	JSAdmin       bool          `json:"jsAdmin,omitempty"`       // exempt from the JetStream API protection
+	Profile       string        `json:"profile,omitempty"`       // permission profile selected by annotation
+
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"` // node labels required by the profile
 }
 
-// Cache is a thread-safe in-memory cache of ServiceAccount permissions
+// Cache is a thread-safe in-memory cache of ServiceAccount permissions. Reads take the
+// current view without locking; writes and configuration are serialized by the lock.
 type Cache struct {
-	mu     sync.RWMutex
-	cache  map[string]*Permissions // key: "namespace/name"
-	logger *zap.Logger
+	mu           sync.RWMutex
+	view         atomic.Pointer[view] // cached permissions and namespace annotations
+	subjects     *subjectTable        // subject lists shared by the cached permissions
+	aliases      map[string][]string  // canonical annotation key -> deprecated alias keys
+	nsLayers     map[string]Layer     // namespace levels of the permission chain
+	policy       *Policy              // cluster defaults and profiles, if configured
+	limits       Limits
+	defaultClass Class                // class of ServiceAccounts without a nats.io/class annotation
+	requireOptIn bool                 // disable ServiceAccounts not annotated nats.io/enabled: "true"
+	sharedInbox  bool                 // grant _INBOX.> to ServiceAccounts without a nats.io/shared-inbox annotation
+	prefix       string               // subject prefix template for annotation subjects, if configured
+	recorder     record.EventRecorder // optional, for events on ServiceAccounts
+	logger       *zap.Logger
 }
 
 // NewCache creates a new empty ServiceAccount cache
 func NewCache(logger *zap.Logger) *Cache {
-	return &Cache{
-		cache:  make(map[string]*Permissions),
-		logger: logger,
+	c := &Cache{
+		subjects:    newSubjectTable(),
+		nsLayers:    make(map[string]Layer),
+		sharedInbox: true,
+		logger:      logger,
+	}
+	c.view.Store(&view{})
+	return c
+}
+
+// SetAnnotationAliases configures alternate annotation keys, mapped to the canonical key they
+// stand in for (AnnotationAllowedPubSubjects or AnnotationAllowedSubSubjects). An alias is only
+// read when the canonical annotation is absent, and each use is logged and counted so that
+// migrations away from the old names can be tracked. It only affects ServiceAccounts cached
+// after the call.
+func (c *Cache) SetAnnotationAliases(aliases map[string]string) error {
+	byCanonical := make(map[string][]string)
+	for alias, canonical := range aliases {
+		if canonical != AnnotationAllowedPubSubjects && canonical != AnnotationAllowedSubSubjects {
+			return fmt.Errorf("annotation alias %q: unknown annotation %q (want %s or %s)",
+				alias, canonical, AnnotationAllowedPubSubjects, AnnotationAllowedSubSubjects)
+		}
+		if alias == AnnotationAllowedPubSubjects || alias == AnnotationAllowedSubSubjects {
+			return fmt.Errorf("annotation alias %q: cannot alias a canonical annotation", alias)
+		}
+		byCanonical[canonical] = append(byCanonical[canonical], alias)
+	}
+	// Sorted so the alias used is deterministic when a ServiceAccount has several
+	for _, list := range byCanonical {
+		slices.Sort(list)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.aliases = byCanonical
+	return nil
+}
+
+// SetLimits configures the annotation limits. It only affects ServiceAccounts cached after the call.
+func (c *Cache) SetLimits(limits Limits) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.limits = limits
+}
+
+// SetRequireOptIn controls whether ServiceAccounts must be annotated nats.io/enabled: "true"
+// to get NATS access at all. When enabled, ServiceAccounts without the annotation are disabled
+// as if annotated "false", so a valid token alone no longer grants the namespace scope. It
+// only affects ServiceAccounts cached after the call.
+func (c *Cache) SetRequireOptIn(required bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.requireOptIn = required
+}
+
+// SetSharedInbox controls whether ServiceAccounts without a nats.io/shared-inbox annotation are
+// granted the shared _INBOX.> subscription (the default) alongside their private inbox. Without
+// it, replies can only be received on _INBOX_<namespace>_<serviceaccount>.>, so clients must use
+// that inbox prefix. It only affects ServiceAccounts cached after the call.
+func (c *Cache) SetSharedInbox(enabled bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sharedInbox = enabled
+}
+
+// SetPolicy configures the cluster defaults and profiles of the permission chain. It only
+// affects ServiceAccounts cached after the call.
+func (c *Cache) SetPolicy(policy *Policy) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.policy = policy
+}
+
+// SetSubjectPrefix rewrites the subjects granted by namespace and ServiceAccount annotations
+// into prefix+subject, enforcing a naming convention when annotations use short subjects.
+// "{namespace}" in the prefix is replaced with the ServiceAccount's namespace, e.g.
+// "prod-eu.{namespace}.". Subjects that already carry the prefix and "$" system subjects are
+// left unchanged. It only affects ServiceAccounts cached after the call.
+func (c *Cache) SetSubjectPrefix(prefix string) error {
+	if prefix != "" {
+		if err := validateSubjectPrefix(prefix); err != nil {
+			return err
+		}
 	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.prefix = prefix
+	return nil
+}
+
+// SetEventRecorder sets the recorder used to report annotation problems as events on the
+// ServiceAccount, where its owners will see them.
+func (c *Cache) SetEventRecorder(recorder record.EventRecorder) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.recorder = recorder
 }
 
 // Get retrieves the permissions for a ServiceAccount by namespace and name.
 // Returns (pubPerms, subPerms, found) where found indicates if the SA exists in cache.
 func (c *Cache) Get(namespace, name string) (pubPerms, subPerms []string, found bool) {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
-
+	v := c.view.Load()
 	key := makeKey(namespace, name)
-	perms, found := c.cache[key]
+	perms, found := v.get(key)
 	if !found {
 		c.logger.Debug("ServiceAccount NOT found in cache",
 			zap.String("namespace", namespace),
 			zap.String("name", name),
 			zap.String("key", key),
-			zap.Int("cache_size", len(c.cache)))
+			zap.Int("cache_size", v.size))
 		return nil, nil, false
 	}
 
@@ -66,14 +231,31 @@ func (c *Cache) Get(namespace, name string) (pubPerms, subPerms []string, found
 	return perms.Publish, perms.Subscribe, true
 }
 
-// upsert adds or updates a ServiceAccount in the cache
-func (c *Cache) upsert(sa *corev1.ServiceAccount) {
+// peek looks up permissions without logging, for repeated lookups while retrying a miss
+func (c *Cache) peek(namespace, name string) (pubPerms, subPerms []string, found bool) {
+	perms, found := c.lookup(namespace, name)
+	if !found {
+		return nil, nil, false
+	}
+	return perms.Publish, perms.Subscribe, true
+}
+
+// Upsert adds or updates a ServiceAccount in the cache
+func (c *Cache) Upsert(sa *corev1.ServiceAccount) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
 	key := makeKey(sa.Namespace, sa.Name)
-	perms := buildPermissions(sa, c.logger)
-	c.cache[key] = perms
+	perms := c.buildPermissions(sa)
+	c.share(perms)
+	current := c.view.Load()
+	if old, found := current.get(key); found {
+		c.logPermissionChange(sa, old, perms)
+		c.unshare(old)
+	}
+	edit := current.edit()
+	edit.set(key, perms)
+	c.view.Store(edit.view())
 
 	c.logger.Debug("ServiceAccount added to cache",
 		zap.String("namespace", sa.Namespace),
@@ -81,101 +263,634 @@ func (c *Cache) upsert(sa *corev1.ServiceAccount) {
 		zap.String("key", key),
 		zap.Int("pub_perms_count", len(perms.Publish)),
 		zap.Int("sub_perms_count", len(perms.Subscribe)),
-		zap.Int("cache_size", len(c.cache)))
+		zap.Int("cache_size", c.view.Load().size))
 }
 
-// delete removes a ServiceAccount from the cache
-func (c *Cache) delete(namespace, name string) {
+// Delete removes a ServiceAccount from the cache
+func (c *Cache) Delete(namespace, name string) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
 	key := makeKey(namespace, name)
-	delete(c.cache, key)
+	current := c.view.Load()
+	if perms, found := current.get(key); found {
+		c.unshare(perms)
+		edit := current.edit()
+		edit.remove(key)
+		c.view.Store(edit.view())
+	}
+}
+
+// lookup returns the permissions cached for a ServiceAccount, from the current view
+func (c *Cache) lookup(namespace, name string) (*Permissions, bool) {
+	return c.view.Load().get(makeKey(namespace, name))
+}
+
+// share replaces the subject lists of permissions about to be cached with shared copies
+func (c *Cache) share(perms *Permissions) {
+	perms.Publish = c.subjects.intern(perms.Publish)
+	perms.Subscribe = c.subjects.intern(perms.Subscribe)
+}
+
+// unshare releases the subject lists of permissions removed from the cache
+func (c *Cache) unshare(perms *Permissions) {
+	c.subjects.release(perms.Publish)
+	c.subjects.release(perms.Subscribe)
+}
+
+// Disabled reports whether NATS access is disabled for a ServiceAccount by its own
+// nats.io/enabled annotation or by its namespace's
+func (c *Cache) Disabled(namespace, name string) bool {
+	v := c.view.Load()
+	if v.disabled[namespace] {
+		return true
+	}
+	perms, found := v.get(makeKey(namespace, name))
+	return found && perms.Disabled
+}
+
+// Bearer reports whether a ServiceAccount requests bearer user JWTs with the nats.io/bearer annotation
+func (c *Cache) Bearer(namespace, name string) bool {
+	perms, found := c.lookup(namespace, name)
+	return found && perms.Bearer
+}
+
+// ReadOnly reports whether a ServiceAccount is marked read-only with the nats.io/read-only annotation
+func (c *Cache) ReadOnly(namespace, name string) bool {
+	perms, found := c.lookup(namespace, name)
+	return found && perms.ReadOnly
+}
+
+// DeniedSubjects returns the subjects a ServiceAccount is denied with the
+// nats.io/denied-pub-subjects and nats.io/denied-sub-subjects annotations
+func (c *Cache) DeniedSubjects(namespace, name string) (publish, subscribe []string) {
+	perms, found := c.lookup(namespace, name)
+	if !found {
+		return nil, nil
+	}
+	return perms.DenyPublish, perms.DenySubscribe
+}
+
+// TokenTTL returns the user JWT lifetime a ServiceAccount requests with the nats.io/token-ttl
+// annotation, or zero for the default
+func (c *Cache) TokenTTL(namespace, name string) time.Duration {
+	perms, found := c.lookup(namespace, name)
+	if !found {
+		return 0
+	}
+	return perms.TokenTTL
+}
+
+// UpsertNamespace records a namespace's annotations: whether nats.io/enabled disables NATS
+// access for all of its ServiceAccounts, whether nats.io/require-tls requires their
+// connections to use TLS, and its level of the permission chain. It reports
+// whether the namespace's subjects changed, in which case its ServiceAccounts must be upserted
+// again to pick them up.
+func (c *Cache) UpsertNamespace(ns *corev1.Namespace) bool {
+	enabled, err := accessEnabled(ns.Annotations)
+	if err != nil {
+		c.logger.Warn("Problem with namespace annotations",
+			zap.String("namespace", ns.Name),
+			zap.Error(err))
+	}
+	tlsRequired, err := requireTLS(ns.Annotations)
+	if err != nil {
+		c.logger.Warn("Problem with namespace annotations",
+			zap.String("namespace", ns.Name),
+			zap.Error(err))
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	current := c.view.Load()
+	if !enabled && !current.disabled[ns.Name] {
+		c.logger.Info("NATS access disabled for namespace", zap.String("namespace", ns.Name))
+	}
+	if current.disabled[ns.Name] != !enabled || current.tlsRequired[ns.Name] != tlsRequired {
+		edit := current.edit()
+		edit.namespace(ns.Name, !enabled, tlsRequired)
+		c.view.Store(edit.view())
+	}
+
+	layer := c.namespaceLayer(ns)
+	old, existed := c.nsLayers[ns.Name]
+	if len(layer.Publish) == 0 && len(layer.Subscribe) == 0 && layer.Strategy == StrategyMerge {
+		// Contributes nothing to the chain
+		delete(c.nsLayers, ns.Name)
+		return existed
+	}
+	c.nsLayers[ns.Name] = layer
+	return !existed || !layersEqual(old, layer)
+}
+
+// DeleteNamespace forgets a deleted namespace's annotations
+func (c *Cache) DeleteNamespace(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if current := c.view.Load(); current.disabled[name] || current.tlsRequired[name] {
+		edit := current.edit()
+		edit.namespace(name, false, false)
+		c.view.Store(edit.view())
+	}
+	delete(c.nsLayers, name)
+}
+
+// Len returns the number of cached ServiceAccounts
+func (c *Cache) Len() int {
+	return c.view.Load().size
+}
+
+// StorageStats measures how the subjects of the cached permissions are stored
+func (c *Cache) StorageStats() StorageStats {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.subjects.stats()
+}
+
+// NamespaceGrants summarizes the subjects granted to the cached ServiceAccounts of each namespace
+func (c *Cache) NamespaceGrants() map[string]httpmetrics.NamespaceGrants {
+	grants := make(map[string]httpmetrics.NamespaceGrants)
+	c.view.Load().each(func(key string, perms *Permissions) {
+		namespace, _, _ := strings.Cut(key, "/")
+		g := grants[namespace]
+		g.Publish += len(perms.Publish)
+		g.Subscribe += len(perms.Subscribe)
+		g.MaxSubjects = max(g.MaxSubjects, len(perms.Publish)+len(perms.Subscribe))
+		grants[namespace] = g
+	})
+	return grants
+}
+
+// entries returns a copy of the cached permissions keyed by "namespace/name"
+func (c *Cache) entries() map[string]*Permissions {
+	v := c.view.Load()
+	entries := make(map[string]*Permissions, v.size)
+	v.each(func(key string, perms *Permissions) {
+		entries[key] = perms
+	})
+	return entries
+}
+
+// effectiveEntries returns a copy of the cached permissions keyed by "namespace/name", marked
+// disabled where the namespace disables NATS access
+func (c *Cache) effectiveEntries() map[string]Permissions {
+	v := c.view.Load()
+	entries := make(map[string]Permissions, v.size)
+	v.each(func(key string, perms *Permissions) {
+		namespace, _, _ := strings.Cut(key, "/")
+		effective := *perms
+		effective.Disabled = effective.Disabled || v.disabled[namespace]
+		entries[key] = effective
+	})
+	return entries
+}
+
+// restore adds entries that are not already cached. Entries delivered by the informer
+// are newer than any snapshot, so they are never overwritten.
+func (c *Cache) restore(entries map[string]*Permissions) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	current := c.view.Load()
+	edit := current.edit()
+	restored := 0
+	for key, perms := range entries {
+		if _, exists := current.get(key); exists || perms == nil {
+			continue
+		}
+		c.share(perms)
+		edit.set(key, perms)
+		restored++
+	}
+	c.view.Store(edit.view())
+	return restored
+}
+
+// retain removes every entry for which keep returns false, returning the keys removed
+func (c *Cache) retain(keep func(key string) bool) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	current := c.view.Load()
+	edit := current.edit()
+	var removed []string
+	current.each(func(key string, perms *Permissions) {
+		if !keep(key) {
+			c.unshare(perms)
+			edit.remove(key)
+			removed = append(removed, key)
+		}
+	})
+	c.view.Store(edit.view())
+	return removed
 }
 
 // buildPermissions constructs NATS permissions from a ServiceAccount's annotations
-func buildPermissions(sa *corev1.ServiceAccount, logger *zap.Logger) *Permissions {
+func (c *Cache) buildPermissions(sa *corev1.ServiceAccount) *Permissions {
 	perms := &Permissions{}
 
-	// Default: namespace scope (always included)
+	enabled, err := accessEnabled(sa.Annotations)
+	if err != nil {
+		c.warn(sa, "InvalidAnnotation", err.Error())
+	}
+	if _, annotated := sa.Annotations[AnnotationEnabled]; !annotated && c.requireOptIn {
+		enabled = false
+	}
+	perms.Disabled = !enabled
+
+	// Bearer JWTs weaken authentication, so only an explicit boolean true requests them
+	if value, ok := sa.Annotations[AnnotationBearer]; ok {
+		bearer, err := strconv.ParseBool(strings.TrimSpace(value))
+		if err != nil {
+			c.warn(sa, "InvalidAnnotation", fmt.Sprintf("annotation %s value %q is not a boolean; ignoring it", AnnotationBearer, value))
+		}
+		perms.Bearer = bearer
+	}
+
+	// An unparseable value fails closed: the ServiceAccount was meant to be read-only
+	if value, ok := sa.Annotations[AnnotationReadOnly]; ok {
+		readOnly, err := strconv.ParseBool(strings.TrimSpace(value))
+		if err != nil {
+			c.warn(sa, "InvalidAnnotation", fmt.Sprintf("annotation %s value %q is not a boolean; treating it as true", AnnotationReadOnly, value))
+			readOnly = true
+		}
+		perms.ReadOnly = readOnly
+	}
+
+	if value, ok := sa.Annotations[AnnotationTokenTTL]; ok {
+		ttl, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil || ttl < minTokenTTL {
+			c.warn(sa, "InvalidAnnotation", fmt.Sprintf(
+				"annotation %s value %q is not a duration of at least %s; ignoring it", AnnotationTokenTTL, value, minTokenTTL))
+		} else {
+			perms.TokenTTL = ttl
+		}
+	}
+
+	perms.Class = c.class(sa)
+	perms.Limits = c.userLimits(sa)
+	perms.Role = role(sa)
+	perms.Account = account(sa)
+	perms.JSAdmin = c.jetStreamAdmin(sa)
+	perms.Profile = sa.Annotations[AnnotationProfile]
+	perms.NodeSelector = c.nodeSelector(sa)
+
+	// Default: namespace scope (always included, except for publishing by responders)
 	defaultSubject := fmt.Sprintf("%s.>", sa.Namespace)
 	// Publish: Only namespace scope (response publishing handled via Resp field in auth callout)
 	perms.Publish = []string{defaultSubject}
+	if perms.Class == ClassResponder {
+		perms.Publish = []string{}
+	}
 	// Subscribe: Inbox patterns first, then namespace scope
-	// - _INBOX.> for default convenience (works with standard NATS clients)
+	// - _INBOX.> for default convenience (works with standard NATS clients), unless omitted by
+	//   nats.io/shared-inbox or SetSharedInbox
 	// - _INBOX_<namespace>_<serviceaccount>.> for private inbox pattern (enhanced security)
 	//   Note: Uses underscore separators to prevent _INBOX.> from matching the private inbox
 	privateInbox := fmt.Sprintf("_INBOX_%s_%s.>", sa.Namespace, sa.Name)
-	perms.Subscribe = []string{"_INBOX.>", privateInbox, defaultSubject}
+	perms.Subscribe = []string{privateInbox, defaultSubject}
+	if c.grantSharedInbox(sa) {
+		perms.Subscribe = append([]string{"_INBOX.>"}, perms.Subscribe...)
+	}
 
-	// Add additional subjects from annotations
-	if pubAnnotation, ok := sa.Annotations[AnnotationAllowedPubSubjects]; ok {
-		additionalPub, filteredPub := parseSubjects(pubAnnotation)
-		if len(filteredPub) > 0 {
-			logger.Warn("Filtered NATS internal subjects from ServiceAccount annotation",
-				zap.String("namespace", sa.Namespace),
-				zap.String("serviceaccount", sa.Name),
-				zap.String("annotation", AnnotationAllowedPubSubjects),
-				zap.Strings("filtered", filteredPub))
+	// Custom inbox prefix, granted after the generated private inbox
+	if prefix, ok := c.inboxPrefix(sa); ok {
+		perms.InboxPrefix = prefix
+		perms.Subscribe = append(perms.Subscribe, prefix+".>")
+	}
 
-			// Increment metrics for each filtered subject
-			for _, subject := range filteredPub {
-				httpmetrics.IncrementFilteredSubjects(sa.Namespace, sa.Name, AnnotationAllowedPubSubjects, subject)
-			}
+	// Add additional subjects from the permission chain: cluster defaults, namespace
+	// annotations, profile and ServiceAccount annotations
+	pub, sub := mergeLayers(c.layers(sa)...)
+	perms.Publish = append(perms.Publish, pub...)
+	perms.Subscribe = append(perms.Subscribe, sub...)
+
+	// Requesters only receive replies, on their inboxes
+	if perms.Class == ClassRequester {
+		perms.Subscribe = inboxSubjects(perms.Subscribe)
+	}
+
+	// Denied subjects carve exceptions out of the allowed subjects, whatever grants them
+	perms.DenyPublish = prefixSubjects(c.prefix, sa.Namespace, c.deniedAnnotationSubjects(sa, AnnotationDeniedPubSubjects))
+	perms.DenySubscribe = prefixSubjects(c.prefix, sa.Namespace, c.deniedAnnotationSubjects(sa, AnnotationDeniedSubSubjects))
+
+	// Drop duplicates and subjects covered by a broader wildcard, keeping issued JWTs small
+	var droppedPub, droppedSub []string
+	perms.Publish, droppedPub = normalizeSubjects(perms.Publish)
+	perms.Subscribe, droppedSub = normalizeSubjects(perms.Subscribe)
+	perms.DenyPublish, _ = normalizeSubjects(perms.DenyPublish)
+	perms.DenySubscribe, _ = normalizeSubjects(perms.DenySubscribe)
+	if len(droppedPub) > 0 || len(droppedSub) > 0 {
+		c.logger.Debug("Dropped redundant subjects from ServiceAccount permissions",
+			zap.String("namespace", sa.Namespace),
+			zap.String("serviceaccount", sa.Name),
+			zap.Strings("publish", droppedPub),
+			zap.Strings("subscribe", droppedSub))
+	}
+
+	return perms
+}
+
+// annotationSubjects returns the additional subjects granted by a ServiceAccount annotation,
+// with NATS internal subjects filtered out and the configured limits applied.
+func (c *Cache) annotationSubjects(sa *corev1.ServiceAccount, key string) []string {
+	value, ok := c.limitedAnnotation(sa, key)
+	if !ok {
+		return nil
+	}
+
+	subjects, filtered := parseSubjects(value)
+	if len(filtered) > 0 {
+		c.logger.Warn("Filtered NATS internal subjects from ServiceAccount annotation",
+			zap.String("namespace", sa.Namespace),
+			zap.String("serviceaccount", sa.Name),
+			zap.String("annotation", key),
+			zap.Strings("filtered", filtered))
+
+		// Increment metrics for each filtered subject
+		for _, subject := range filtered {
+			httpmetrics.IncrementFilteredSubjects(sa.Namespace, sa.Name, key, subject)
 		}
-		perms.Publish = append(perms.Publish, additionalPub...)
 	}
 
-	if subAnnotation, ok := sa.Annotations[AnnotationAllowedSubSubjects]; ok {
-		additionalSub, filteredSub := parseSubjects(subAnnotation)
-		if len(filteredSub) > 0 {
-			logger.Warn("Filtered NATS internal subjects from ServiceAccount annotation",
-				zap.String("namespace", sa.Namespace),
-				zap.String("serviceaccount", sa.Name),
-				zap.String("annotation", AnnotationAllowedSubSubjects),
-				zap.Strings("filtered", filteredSub))
+	return c.limitSubjects(sa, key, subjects)
+}
 
-			// Increment metrics for each filtered subject
-			for _, subject := range filteredSub {
-				httpmetrics.IncrementFilteredSubjects(sa.Namespace, sa.Name, AnnotationAllowedSubSubjects, subject)
-			}
+// deniedAnnotationSubjects returns the subjects denied by a ServiceAccount annotation, with the
+// configured limits applied. Unlike granted subjects, NATS internal subjects are kept: dropping
+// a deny of _INBOX.> would leave the inbox the ServiceAccount is otherwise granted open.
+func (c *Cache) deniedAnnotationSubjects(sa *corev1.ServiceAccount, key string) []string {
+	value, ok := c.limitedAnnotation(sa, key)
+	if !ok {
+		return nil
+	}
+
+	subjects := splitSubjects(value)
+	var internal []string
+	for _, subject := range subjects {
+		if isInternalSubject(subject) {
+			internal = append(internal, subject)
+			httpmetrics.IncrementDeniedInternalSubjects(sa.Namespace, sa.Name, key, subject)
 		}
-		perms.Subscribe = append(perms.Subscribe, additionalSub...)
+	}
+	if len(internal) > 0 {
+		c.logger.Warn("ServiceAccount annotation denies NATS internal subjects; replies on them will not be delivered",
+			zap.String("namespace", sa.Namespace),
+			zap.String("serviceaccount", sa.Name),
+			zap.String("annotation", key),
+			zap.Strings("denied", internal))
 	}
 
-	return perms
+	return c.limitSubjects(sa, key, subjects)
+}
+
+// limitedAnnotation returns a subject annotation's value, unless it is longer than
+// MaxAnnotationLength, in which case it is ignored entirely.
+func (c *Cache) limitedAnnotation(sa *corev1.ServiceAccount, key string) (string, bool) {
+	value, ok := c.annotation(sa, key)
+	if !ok {
+		return "", false
+	}
+
+	if c.limits.MaxAnnotationLength > 0 && len(value) > c.limits.MaxAnnotationLength {
+		c.limitExceeded(sa, key, LimitAnnotationLength, fmt.Sprintf(
+			"annotation %s is %d bytes, over the limit of %d; ignoring it",
+			key, len(value), c.limits.MaxAnnotationLength))
+		return "", false
+	}
+	return value, true
+}
+
+// limitSubjects drops the subjects of an annotation beyond MaxSubjects.
+func (c *Cache) limitSubjects(sa *corev1.ServiceAccount, key string, subjects []string) []string {
+	if c.limits.MaxSubjects > 0 && len(subjects) > c.limits.MaxSubjects {
+		c.limitExceeded(sa, key, LimitSubjectCount, fmt.Sprintf(
+			"annotation %s lists %d subjects, over the limit of %d; ignoring %v",
+			key, len(subjects), c.limits.MaxSubjects, subjects[c.limits.MaxSubjects:]))
+		subjects = subjects[:c.limits.MaxSubjects]
+	}
+	return subjects
+}
+
+// grantSharedInbox reports whether a ServiceAccount is granted the shared _INBOX.>
+// subscription: as its nats.io/shared-inbox annotation says, or by default. An unparseable
+// value fails closed, omitting it. Must be called with the cache lock held.
+func (c *Cache) grantSharedInbox(sa *corev1.ServiceAccount) bool {
+	value, ok := sa.Annotations[AnnotationSharedInbox]
+	if !ok {
+		return c.sharedInbox
+	}
+	shared, err := strconv.ParseBool(strings.TrimSpace(value))
+	if err != nil {
+		c.warn(sa, "InvalidAnnotation", fmt.Sprintf("annotation %s value %q is not a boolean; omitting the shared inbox", AnnotationSharedInbox, value))
+		return false
+	}
+	return shared
 }
 
-// parseSubjects parses a comma-separated list of NATS subjects from an annotation value.
+// inboxPrefix returns the ServiceAccount's custom inbox prefix, if it declares a valid one
+// that does not overlap the prefix already granted to another cached ServiceAccount. Must be
+// called with the cache lock held.
+func (c *Cache) inboxPrefix(sa *corev1.ServiceAccount) (string, bool) {
+	prefix, ok := sa.Annotations[AnnotationInboxPrefix]
+	if !ok {
+		return "", false
+	}
+	prefix = strings.TrimSpace(prefix)
+
+	if err := validateInboxPrefix(prefix); err != nil {
+		c.warn(sa, "InvalidInboxPrefix", fmt.Sprintf("annotation %s ignored: %v", AnnotationInboxPrefix, err))
+		return "", false
+	}
+
+	key := makeKey(sa.Namespace, sa.Name)
+	overlapping, overlapped := "", ""
+	c.view.Load().each(func(otherKey string, other *Permissions) {
+		if overlapping == "" && otherKey != key && other.InboxPrefix != "" && prefixesOverlap(prefix, other.InboxPrefix) {
+			overlapping, overlapped = otherKey, other.InboxPrefix
+		}
+	})
+	if overlapping != "" {
+		c.warn(sa, "InvalidInboxPrefix", fmt.Sprintf(
+			"annotation %s ignored: %q overlaps inbox prefix %q of ServiceAccount %s",
+			AnnotationInboxPrefix, prefix, overlapped, overlapping))
+		return "", false
+	}
+
+	return prefix, true
+}
+
+// validateInboxPrefix checks that a custom inbox prefix is a literal subject starting with
+// "_INBOX_". The rest of its first token may not contain "_", so it can never overlap the
+// generated _INBOX_<namespace>_<serviceaccount> inboxes or the shared _INBOX.> pattern.
+func validateInboxPrefix(prefix string) error {
+	if strings.ContainsAny(prefix, " \t\r\n") {
+		return fmt.Errorf("inbox prefix %q must not contain whitespace", prefix)
+	}
+
+	tokens := strings.Split(prefix, ".")
+	for _, token := range tokens {
+		if token == "" || token == "*" || token == ">" {
+			return fmt.Errorf("inbox prefix %q must be a literal subject without wildcards or empty tokens", prefix)
+		}
+	}
+
+	name, ok := strings.CutPrefix(tokens[0], "_INBOX_")
+	if !ok || name == "" || strings.Contains(name, "_") {
+		return fmt.Errorf("inbox prefix %q must start with _INBOX_<name>, where name contains no underscores", prefix)
+	}
+	return nil
+}
+
+// prefixesOverlap reports whether the subjects under two inbox prefixes intersect,
+// i.e. one prefix is a token-wise prefix of the other
+func prefixesOverlap(a, b string) bool {
+	aTokens, bTokens := strings.Split(a, "."), strings.Split(b, ".")
+	n := min(len(aTokens), len(bTokens))
+	return slices.Equal(aTokens[:n], bTokens[:n])
+}
+
+// limitExceeded reports an annotation over a configured limit in the logs, metrics and
+// ServiceAccount events
+func (c *Cache) limitExceeded(sa *corev1.ServiceAccount, key, limit, message string) {
+	httpmetrics.IncrementAnnotationLimitExceeded(sa.Namespace, sa.Name, key, limit)
+	c.warn(sa, "AnnotationLimitExceeded", message)
+}
+
+// warn logs a problem with a ServiceAccount's annotations and, when an event recorder is
+// set, reports it as a Warning event on the ServiceAccount
+func (c *Cache) warn(sa *corev1.ServiceAccount, reason, message string) {
+	c.logger.Warn("Problem with ServiceAccount annotations",
+		zap.String("namespace", sa.Namespace),
+		zap.String("serviceaccount", sa.Name),
+		zap.String("reason", reason),
+		zap.String("detail", message))
+
+	if c.recorder != nil {
+		c.recorder.Event(sa, corev1.EventTypeWarning, reason, message)
+	}
+}
+
+// annotation returns the value of a canonical annotation, falling back to its configured
+// aliases. Use of an alias is logged and counted.
+func (c *Cache) annotation(sa *corev1.ServiceAccount, key string) (string, bool) {
+	if value, ok := sa.Annotations[key]; ok {
+		return value, true
+	}
+
+	for _, alias := range c.aliases[key] {
+		if value, ok := sa.Annotations[alias]; ok {
+			c.logger.Warn("ServiceAccount uses deprecated annotation alias",
+				zap.String("namespace", sa.Namespace),
+				zap.String("serviceaccount", sa.Name),
+				zap.String("alias", alias),
+				zap.String("annotation", key))
+			httpmetrics.IncrementDeprecatedAnnotations(alias, key)
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// parseSubjects parses a list of NATS subjects from an annotation value (see splitSubjects).
 // Filters out any _INBOX and _REPLY patterns as those are automatically managed by NATS.
 // Returns both the parsed subjects and a list of filtered subjects.
 func parseSubjects(annotation string) (subjects, filtered []string) {
-	if annotation == "" {
-		return []string{}, []string{}
-	}
-
-	parts := strings.Split(annotation, ",")
+	parts := splitSubjects(annotation)
 	subjects = make([]string, 0, len(parts))
 	filtered = make([]string, 0)
 
-	for _, part := range parts {
-		trimmed := strings.TrimSpace(part)
-		if trimmed == "" {
-			continue
-		}
-
+	for _, subject := range parts {
 		// Filter out NATS internal patterns (automatically managed)
-		if strings.HasPrefix(trimmed, "_INBOX") || strings.HasPrefix(trimmed, "_REPLY") {
-			filtered = append(filtered, trimmed)
+		if isInternalSubject(subject) {
+			filtered = append(filtered, subject)
 			continue
 		}
 
-		subjects = append(subjects, trimmed)
+		subjects = append(subjects, subject)
 	}
 
 	return subjects, filtered
 }
 
+// splitSubjects splits an annotation value into trimmed, unquoted subjects (see
+// splitAnnotationList), skipping empty items.
+func splitSubjects(annotation string) []string {
+	if annotation == "" {
+		return []string{}
+	}
+
+	parts := splitAnnotationList(annotation)
+	subjects := make([]string, 0, len(parts))
+	for _, part := range parts {
+		if trimmed := unquote(strings.TrimSpace(part)); trimmed != "" {
+			subjects = append(subjects, trimmed)
+		}
+	}
+	return subjects
+}
+
+// isInternalSubject reports whether a subject is an _INBOX or _REPLY pattern, which are
+// managed automatically and never granted from annotations
+func isInternalSubject(subject string) bool {
+	return strings.HasPrefix(subject, "_INBOX") || strings.HasPrefix(subject, "_REPLY")
+}
+
+// splitAnnotationList splits an annotation value into its items. Values may be comma or
+// newline separated, a YAML block list ("- subject" per line, as produced by multi-line
+// strings in manifests and Helm charts) or a YAML flow list ("[a.>, b.*]"). Items are not
+// trimmed or unquoted.
+func splitAnnotationList(annotation string) []string {
+	trimmed := strings.TrimSpace(annotation)
+
+	// YAML flow list
+	if strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]") {
+		return strings.Split(trimmed[1:len(trimmed)-1], ",")
+	}
+
+	// YAML block list; lines without a "- " marker are kept as-is
+	if strings.HasPrefix(trimmed, "-") {
+		lines := strings.Split(trimmed, "\n")
+		for i, line := range lines {
+			line = strings.TrimSpace(line)
+			if strings.HasPrefix(line, "#") {
+				line = ""
+			}
+			lines[i] = strings.TrimPrefix(line, "-")
+		}
+		return lines
+	}
+
+	return strings.FieldsFunc(annotation, func(r rune) bool {
+		return r == ',' || r == '\n'
+	})
+}
+
+// unquote removes matching single or double quotes around a YAML list item
+func unquote(item string) string {
+	if len(item) >= 2 && (item[0] == '"' || item[0] == '\'') && item[len(item)-1] == item[0] {
+		return strings.TrimSpace(item[1 : len(item)-1])
+	}
+	return item
+}
+
+// accessEnabled reports whether an object's nats.io/enabled annotation allows NATS access.
+// A value that is not a boolean is returned as an error and treated as disabled, so a
+// mistyped kill switch fails closed.
+func accessEnabled(annotations map[string]string) (bool, error) {
+	value, ok := annotations[AnnotationEnabled]
+	if !ok {
+		return true, nil
+	}
+	enabled, err := strconv.ParseBool(strings.TrimSpace(value))
+	if err != nil {
+		return false, fmt.Errorf("annotation %s value %q is not a boolean; NATS access disabled", AnnotationEnabled, value)
+	}
+	return enabled, nil
+}
+
 // makeKey creates a cache key from namespace and name
 func makeKey(namespace, name string) string {
 	return fmt.Sprintf("%s/%s", namespace, name)
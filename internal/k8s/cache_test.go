@@ -1,11 +1,16 @@
 package k8s
 
 import (
+	"slices"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"go.uber.org/zap"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
 )
 
 // TestCache_Get tests retrieving ServiceAccount permissions from cache
@@ -34,7 +39,7 @@ func TestCache_Get(t *testing.T) {
 						},
 					},
 				}
-				c.upsert(sa)
+				c.Upsert(sa)
 			},
 			wantPubPerms: []string{"hakawai.>", "platform.events.>", "shared.metrics.*"},
 			wantSubPerms: []string{"_INBOX.>", "_INBOX_hakawai_hakawai-litellm-proxy.>", "hakawai.>", "platform.commands.*", "shared.status"},
@@ -54,7 +59,7 @@ func TestCache_Get(t *testing.T) {
 						},
 					},
 				}
-				c.upsert(sa)
+				c.Upsert(sa)
 			},
 			wantPubPerms: []string{"default.>", "external.>"},
 			wantSubPerms: []string{"_INBOX.>", "_INBOX_default_test-sa.>", "default.>"},
@@ -74,7 +79,7 @@ func TestCache_Get(t *testing.T) {
 						},
 					},
 				}
-				c.upsert(sa)
+				c.Upsert(sa)
 			},
 			wantPubPerms: []string{"production.>"},
 			wantSubPerms: []string{"_INBOX.>", "_INBOX_production_minimal-sa.>", "production.>"},
@@ -129,7 +134,7 @@ func TestCache_Upsert(t *testing.T) {
 			},
 		},
 	}
-	cache.upsert(sa1)
+	cache.Upsert(sa1)
 
 	pubPerms, _, found := cache.Get("default", "test-sa")
 	if !found {
@@ -149,7 +154,7 @@ func TestCache_Upsert(t *testing.T) {
 			},
 		},
 	}
-	cache.upsert(sa2)
+	cache.Upsert(sa2)
 
 	pubPerms, _, found = cache.Get("default", "test-sa")
 	if !found {
@@ -160,6 +165,533 @@ func TestCache_Upsert(t *testing.T) {
 	}
 }
 
+// TestCache_AnnotationAliases tests that deprecated annotation keys stand in for canonical ones
+func TestCache_AnnotationAliases(t *testing.T) {
+	cache := NewCache(zap.NewNop())
+	err := cache.SetAnnotationAliases(map[string]string{
+		"messaging.company.com/pub-subjects": AnnotationAllowedPubSubjects,
+		"messaging.company.com/sub-subjects": AnnotationAllowedSubSubjects,
+	})
+	if err != nil {
+		t.Fatalf("SetAnnotationAliases() error = %v", err)
+	}
+
+	// Alias used when the canonical annotation is absent
+	cache.Upsert(&corev1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "legacy",
+			Namespace: "default",
+			Annotations: map[string]string{
+				"messaging.company.com/pub-subjects": "legacy.>",
+				"messaging.company.com/sub-subjects": "legacy.events.*",
+			},
+		},
+	})
+	pubPerms, subPerms, _ := cache.Get("default", "legacy")
+	if !equalStringSlices(pubPerms, []string{"default.>", "legacy.>"}) {
+		t.Errorf("pubPerms = %v, want [default.> legacy.>]", pubPerms)
+	}
+	if subPerms[len(subPerms)-1] != "legacy.events.*" {
+		t.Errorf("subPerms = %v, want legacy.events.* last", subPerms)
+	}
+
+	// Canonical annotation takes precedence over its alias
+	cache.Upsert(&corev1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "migrating",
+			Namespace: "default",
+			Annotations: map[string]string{
+				"messaging.company.com/pub-subjects": "legacy.>",
+				AnnotationAllowedPubSubjects:         "current.>",
+			},
+		},
+	})
+	pubPerms, _, _ = cache.Get("default", "migrating")
+	if !equalStringSlices(pubPerms, []string{"default.>", "current.>"}) {
+		t.Errorf("pubPerms = %v, want [default.> current.>]", pubPerms)
+	}
+
+	// Aliases must map to a known annotation
+	if err := cache.SetAnnotationAliases(map[string]string{"old/key": "nats.io/unknown"}); err == nil {
+		t.Error("expected error for alias of unknown annotation")
+	}
+}
+
+// TestCache_Limits tests that oversized annotations are ignored and excess subjects dropped
+func TestCache_Limits(t *testing.T) {
+	cache := NewCache(zap.NewNop())
+	cache.SetLimits(Limits{MaxAnnotationLength: 40, MaxSubjects: 2})
+	recorder := record.NewFakeRecorder(10)
+	cache.SetEventRecorder(recorder)
+
+	cache.Upsert(&corev1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "noisy",
+			Namespace: "default",
+			Annotations: map[string]string{
+				AnnotationAllowedPubSubjects: "a.>, b.>, c.>",
+				AnnotationAllowedSubSubjects: strings.Repeat("events.", 10) + ">",
+			},
+		},
+	})
+
+	pubPerms, subPerms, found := cache.Get("default", "noisy")
+	if !found {
+		t.Fatal("Expected ServiceAccount to be cached despite exceeding limits")
+	}
+	if !equalStringSlices(pubPerms, []string{"default.>", "a.>", "b.>"}) {
+		t.Errorf("pubPerms = %v, want [default.> a.> b.>]", pubPerms)
+	}
+	if !equalStringSlices(subPerms, []string{"_INBOX.>", "_INBOX_default_noisy.>", "default.>"}) {
+		t.Errorf("subPerms = %v, want defaults only", subPerms)
+	}
+
+	// One warning event per exceeded limit
+	for range 2 {
+		select {
+		case event := <-recorder.Events:
+			if !strings.HasPrefix(event, "Warning AnnotationLimitExceeded") {
+				t.Errorf("unexpected event %q", event)
+			}
+		default:
+			t.Fatal("expected a warning event for each exceeded limit")
+		}
+	}
+}
+
+// TestCache_UserLimits tests parsing of the user limit annotations
+func TestCache_UserLimits(t *testing.T) {
+	cache := NewCache(zap.NewNop())
+	recorder := record.NewFakeRecorder(10)
+	cache.SetEventRecorder(recorder)
+
+	cache.Upsert(&corev1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "api",
+			Namespace: "orders",
+			Annotations: map[string]string{
+				AnnotationMaxSubscriptions: "50",
+				AnnotationMaxPayload:       " 65536 ",
+				AnnotationMaxData:          "-1",
+				AnnotationMaxConnections:   "10",
+			},
+		},
+	})
+
+	if got, want := cache.UserLimits("orders", "api"), (UserLimits{Subscriptions: 50, Payload: 65536, Connections: 10}); got != want {
+		t.Errorf("UserLimits() = %+v, want %+v", got, want)
+	}
+	if got := cache.MaxConnections("orders", "api"); got != 10 {
+		t.Errorf("MaxConnections() = %d, want 10", got)
+	}
+	if got := cache.UserLimits("orders", "missing"); got != (UserLimits{}) {
+		t.Errorf("UserLimits() for unknown ServiceAccount = %+v, want zero", got)
+	}
+
+	select {
+	case event := <-recorder.Events:
+		if !strings.HasPrefix(event, "Warning InvalidAnnotation") {
+			t.Errorf("unexpected event %q", event)
+		}
+	default:
+		t.Error("expected a warning event for the invalid max-data annotation")
+	}
+}
+
+// TestCache_Role tests that the scoped signing key role is read from the annotation
+func TestCache_Role(t *testing.T) {
+	cache := NewCache(zap.NewNop())
+	cache.Upsert(&corev1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "api",
+			Namespace:   "orders",
+			Annotations: map[string]string{AnnotationRole: " orders-reader "},
+		},
+	})
+	cache.Upsert(&corev1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{Name: "worker", Namespace: "orders"},
+	})
+
+	if got := cache.Role("orders", "api"); got != "orders-reader" {
+		t.Errorf("Role() = %q, want %q", got, "orders-reader")
+	}
+	if got := cache.Role("orders", "worker"); got != "" {
+		t.Errorf("Role() without annotation = %q, want empty", got)
+	}
+	if got := cache.Role("orders", "missing"); got != "" {
+		t.Errorf("Role() for unknown ServiceAccount = %q, want empty", got)
+	}
+}
+
+// TestCache_SubjectPrefix tests that annotation subjects are rewritten into the configured prefix
+func TestCache_SubjectPrefix(t *testing.T) {
+	cache := NewCache(zap.NewNop())
+	if err := cache.SetSubjectPrefix("prod.{namespace}."); err != nil {
+		t.Fatalf("SetSubjectPrefix() error = %v", err)
+	}
+	if err := cache.SetSubjectPrefix("prod"); err == nil {
+		t.Error("SetSubjectPrefix() expected error for a prefix without a trailing \".\"")
+	}
+
+	cache.Upsert(&corev1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "api",
+			Namespace: "orders",
+			Annotations: map[string]string{
+				AnnotationAllowedPubSubjects: "events.>, prod.orders.audit",
+				AnnotationAllowedSubSubjects: "commands.*",
+			},
+		},
+	})
+
+	pubPerms, subPerms, _ := cache.Get("orders", "api")
+	if want := []string{"orders.>", "prod.orders.events.>", "prod.orders.audit"}; !equalStringSlices(pubPerms, want) {
+		t.Errorf("pubPerms = %v, want %v", pubPerms, want)
+	}
+	if want := []string{"_INBOX.>", "_INBOX_orders_api.>", "orders.>", "prod.orders.commands.*"}; !equalStringSlices(subPerms, want) {
+		t.Errorf("subPerms = %v, want %v", subPerms, want)
+	}
+}
+
+// TestCache_InboxPrefix tests custom inbox prefix grants and collision checks
+func TestCache_InboxPrefix(t *testing.T) {
+	cache := NewCache(zap.NewNop())
+	newSA := func(namespace, name, prefix string) *corev1.ServiceAccount {
+		return &corev1.ServiceAccount{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Namespace:   namespace,
+				Annotations: map[string]string{AnnotationInboxPrefix: prefix},
+			},
+		}
+	}
+
+	cache.Upsert(newSA("orders", "api", "_INBOX_orders.api"))
+	_, subPerms, _ := cache.Get("orders", "api")
+	if !equalStringSlices(subPerms, []string{"_INBOX.>", "_INBOX_orders_api.>", "orders.>", "_INBOX_orders.api.>"}) {
+		t.Errorf("subPerms = %v, want custom inbox prefix granted", subPerms)
+	}
+
+	// Re-applying the same ServiceAccount keeps its prefix
+	cache.Upsert(newSA("orders", "api", "_INBOX_orders.api"))
+	if _, subPerms, _ := cache.Get("orders", "api"); len(subPerms) != 4 {
+		t.Errorf("subPerms = %v, want custom inbox prefix kept on update", subPerms)
+	}
+
+	tests := []struct {
+		name   string
+		prefix string
+	}{
+		{name: "overlaps another ServiceAccount's prefix", prefix: "_INBOX_orders"},
+		{name: "shared inbox", prefix: "_INBOX"},
+		{name: "generated private inbox", prefix: "_INBOX_orders_api"},
+		{name: "not an inbox", prefix: "orders.replies"},
+		{name: "wildcard", prefix: "_INBOX_billing.*"},
+		{name: "empty token", prefix: "_INBOX_billing..replies"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache.Upsert(newSA("billing", "worker", tt.prefix))
+			_, subPerms, _ := cache.Get("billing", "worker")
+			if !equalStringSlices(subPerms, []string{"_INBOX.>", "_INBOX_billing_worker.>", "billing.>"}) {
+				t.Errorf("subPerms = %v, want prefix %q rejected", subPerms, tt.prefix)
+			}
+		})
+	}
+}
+
+// TestCache_Disabled tests the nats.io/enabled kill switch on ServiceAccounts and namespaces
+func TestCache_Disabled(t *testing.T) {
+	cache := NewCache(zap.NewNop())
+	newSA := func(name, enabled string) *corev1.ServiceAccount {
+		sa := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "orders"}}
+		if enabled != "" {
+			sa.Annotations = map[string]string{AnnotationEnabled: enabled}
+		}
+		return sa
+	}
+
+	cache.Upsert(newSA("api", ""))
+	cache.Upsert(newSA("worker", "false"))
+	cache.Upsert(newSA("cron", "True"))
+	cache.Upsert(newSA("typo", "nope"))
+
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{name: "api", want: false},
+		{name: "worker", want: true},
+		{name: "cron", want: false},
+		{name: "typo", want: true}, // invalid values fail closed
+		{name: "unknown", want: false},
+	}
+	for _, tt := range tests {
+		if got := cache.Disabled("orders", tt.name); got != tt.want {
+			t.Errorf("Disabled(orders, %s) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// A disabled namespace disables every ServiceAccount in it, until re-enabled
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:        "orders",
+		Annotations: map[string]string{AnnotationEnabled: "false"},
+	}}
+	cache.UpsertNamespace(ns)
+	if !cache.Disabled("orders", "api") {
+		t.Error("expected ServiceAccount disabled by its namespace")
+	}
+	if entries := cache.effectiveEntries(); !entries["orders/api"].Disabled {
+		t.Error("expected effective permissions disabled by the namespace")
+	}
+	ns.Annotations[AnnotationEnabled] = "true"
+	cache.UpsertNamespace(ns)
+	if cache.Disabled("orders", "api") {
+		t.Error("expected ServiceAccount enabled after namespace re-enabled")
+	}
+
+	cache.UpsertNamespace(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:        "billing",
+		Annotations: map[string]string{AnnotationEnabled: "false"},
+	}})
+	cache.DeleteNamespace("billing")
+	if cache.Disabled("billing", "api") {
+		t.Error("expected deleted namespace to be forgotten")
+	}
+}
+
+// TestCache_RequireOptIn tests that only ServiceAccounts annotated nats.io/enabled: "true" are
+// enabled when opt-in is required
+func TestCache_RequireOptIn(t *testing.T) {
+	cache := NewCache(zap.NewNop())
+	cache.SetRequireOptIn(true)
+	for name, enabled := range map[string]string{"api": "true", "worker": "false", "typo": "nope"} {
+		cache.Upsert(&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   "orders",
+			Annotations: map[string]string{AnnotationEnabled: enabled},
+		}})
+	}
+	cache.Upsert(&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "default", Namespace: "orders"}})
+
+	for name, want := range map[string]bool{"api": false, "worker": true, "typo": true, "default": true} {
+		if got := cache.Disabled("orders", name); got != want {
+			t.Errorf("Disabled(orders, %s) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+// TestCache_SharedInbox tests the nats.io/shared-inbox annotation and the default it overrides
+func TestCache_SharedInbox(t *testing.T) {
+	for _, sharedByDefault := range []bool{true, false} {
+		cache := NewCache(zap.NewNop())
+		cache.SetSharedInbox(sharedByDefault)
+		for name, value := range map[string]string{"on": "true", "off": "false", "typo": "nope"} {
+			cache.Upsert(&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Namespace:   "orders",
+				Annotations: map[string]string{AnnotationSharedInbox: value},
+			}})
+		}
+		cache.Upsert(&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "default", Namespace: "orders"}})
+
+		for name, want := range map[string]bool{"on": true, "off": false, "typo": false, "default": sharedByDefault} {
+			_, sub, _ := cache.Get("orders", name)
+			if got := slices.Contains(sub, "_INBOX.>"); got != want {
+				t.Errorf("default %v: %s subscribe = %v, want _INBOX.> granted = %v", sharedByDefault, name, sub, want)
+			}
+			if private := "_INBOX_orders_" + name + ".>"; !slices.Contains(sub, private) {
+				t.Errorf("default %v: %s subscribe = %v, want the private inbox %s", sharedByDefault, name, sub, private)
+			}
+		}
+	}
+}
+
+// TestCache_RequireTLS tests the nats.io/require-tls namespace annotation
+func TestCache_RequireTLS(t *testing.T) {
+	cache := NewCache(zap.NewNop())
+	tests := []struct {
+		value string
+		want  bool
+	}{
+		{value: "true", want: true},
+		{value: "false", want: false},
+		{value: "typo", want: true}, // invalid values fail closed
+	}
+	for _, tt := range tests {
+		cache.UpsertNamespace(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+			Name:        "orders",
+			Annotations: map[string]string{AnnotationRequireTLS: tt.value},
+		}})
+		if got := cache.RequireTLS("orders", "api"); got != tt.want {
+			t.Errorf("with %q: RequireTLS() = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+
+	cache.DeleteNamespace("orders")
+	if cache.RequireTLS("orders", "api") {
+		t.Error("expected deleted namespace to be forgotten")
+	}
+	if cache.RequireTLS("billing", "api") {
+		t.Error("expected unannotated namespace not to require TLS")
+	}
+}
+
+// TestCache_Bearer tests the nats.io/bearer annotation
+func TestCache_Bearer(t *testing.T) {
+	cache := NewCache(zap.NewNop())
+	tests := []struct {
+		value string
+		want  bool
+	}{
+		{value: "true", want: true},
+		{value: "false", want: false},
+		{value: "yes", want: false}, // not a boolean, ignored
+	}
+	for _, tt := range tests {
+		cache.Upsert(&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{
+			Name:        "web",
+			Namespace:   "frontend",
+			Annotations: map[string]string{AnnotationBearer: tt.value},
+		}})
+		if got := cache.Bearer("frontend", "web"); got != tt.want {
+			t.Errorf("Bearer() with %q = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+
+	if cache.Bearer("frontend", "unknown") {
+		t.Error("expected Bearer() = false for unknown ServiceAccount")
+	}
+}
+
+// TestCache_ReadOnly tests the nats.io/read-only annotation
+func TestCache_ReadOnly(t *testing.T) {
+	cache := NewCache(zap.NewNop())
+	tests := []struct {
+		value string
+		want  bool
+	}{
+		{value: "true", want: true},
+		{value: "false", want: false},
+		{value: "yes", want: true}, // not a boolean, fails closed
+	}
+	for _, tt := range tests {
+		cache.Upsert(&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{
+			Name:        "dashboard",
+			Namespace:   "analytics",
+			Annotations: map[string]string{AnnotationReadOnly: tt.value},
+		}})
+		if got := cache.ReadOnly("analytics", "dashboard"); got != tt.want {
+			t.Errorf("ReadOnly() with %q = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+
+	if cache.ReadOnly("analytics", "unknown") {
+		t.Error("expected ReadOnly() = false for unknown ServiceAccount")
+	}
+}
+
+// TestCache_DeniedSubjects tests the nats.io/denied-pub-subjects and nats.io/denied-sub-subjects
+// annotations
+func TestCache_DeniedSubjects(t *testing.T) {
+	cache := NewCache(zap.NewNop())
+	cache.Upsert(&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{
+		Name:      "consumer",
+		Namespace: "events",
+		Annotations: map[string]string{
+			AnnotationAllowedPubSubjects: "events.>",
+			AnnotationAllowedSubSubjects: "events.>",
+			AnnotationDeniedPubSubjects:  "events.internal.>,events.internal.audit",
+			AnnotationDeniedSubSubjects:  "events.internal.>",
+		},
+	}})
+
+	pub, sub := cache.DeniedSubjects("events", "consumer")
+	if !slices.Equal(pub, []string{"events.internal.>"}) {
+		t.Errorf("denied publish = %v, want [events.internal.>]", pub)
+	}
+	if !slices.Equal(sub, []string{"events.internal.>"}) {
+		t.Errorf("denied subscribe = %v, want [events.internal.>]", sub)
+	}
+	// The allowed subjects are unchanged; the server applies the deny list on top
+	if allowed, _, _ := cache.Get("events", "consumer"); !slices.Contains(allowed, "events.>") {
+		t.Errorf("publish = %v, want events.> still allowed", allowed)
+	}
+
+	if pub, sub := cache.DeniedSubjects("events", "unknown"); pub != nil || sub != nil {
+		t.Errorf("DeniedSubjects() = %v, %v for unknown ServiceAccount, want none", pub, sub)
+	}
+}
+
+// TestCache_DeniedInternalSubjects tests that denies of NATS internal subjects are kept rather
+// than filtered like grants, which would leave the shared inbox open
+func TestCache_DeniedInternalSubjects(t *testing.T) {
+	cache := NewCache(zap.NewNop())
+	cache.Upsert(&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{
+		Name:      "publisher",
+		Namespace: "events",
+		Annotations: map[string]string{
+			AnnotationAllowedSubSubjects: "events.>",
+			AnnotationDeniedPubSubjects:  "_REPLY.>",
+			AnnotationDeniedSubSubjects:  "_INBOX.>, events.internal.>",
+		},
+	}})
+
+	pub, sub := cache.DeniedSubjects("events", "publisher")
+	if !slices.Equal(pub, []string{"_REPLY.>"}) {
+		t.Errorf("denied publish = %v, want [_REPLY.>]", pub)
+	}
+	if !slices.Equal(sub, []string{"_INBOX.>", "events.internal.>"}) {
+		t.Errorf("denied subscribe = %v, want [_INBOX.> events.internal.>]", sub)
+	}
+}
+
+// TestCache_Account tests the nats.io/account annotation
+func TestCache_Account(t *testing.T) {
+	cache := NewCache(zap.NewNop())
+	cache.Upsert(&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{
+		Name:        "invoicer",
+		Namespace:   "billing",
+		Annotations: map[string]string{AnnotationAccount: " BILLING "},
+	}})
+	cache.Upsert(&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "frontend"}})
+
+	if got := cache.Account("billing", "invoicer"); got != "BILLING" {
+		t.Errorf("Account() = %q, want BILLING", got)
+	}
+	if got := cache.Account("frontend", "web"); got != "" {
+		t.Errorf("Account() = %q without the annotation, want the default account", got)
+	}
+	if got := cache.Account("billing", "unknown"); got != "" {
+		t.Errorf("Account() = %q for unknown ServiceAccount, want none", got)
+	}
+}
+
+// TestCache_TokenTTL tests the nats.io/token-ttl annotation
+func TestCache_TokenTTL(t *testing.T) {
+	cache := NewCache(zap.NewNop())
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{value: "1m", want: time.Minute},
+		{value: " 30s ", want: 30 * time.Second},
+		{value: "500ms", want: 0}, // below the minimum, ignored
+		{value: "-1m", want: 0},
+		{value: "soon", want: 0},
+	}
+	for _, tt := range tests {
+		cache.Upsert(&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{
+			Name:        "payments",
+			Namespace:   "billing",
+			Annotations: map[string]string{AnnotationTokenTTL: tt.value},
+		}})
+		if got := cache.TokenTTL("billing", "payments"); got != tt.want {
+			t.Errorf("TokenTTL() with %q = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
 // TestCache_Delete tests removing ServiceAccounts from cache
 func TestCache_Delete(t *testing.T) {
 	cache := NewCache(zap.NewNop())
@@ -174,7 +706,7 @@ func TestCache_Delete(t *testing.T) {
 			},
 		},
 	}
-	cache.upsert(sa)
+	cache.Upsert(sa)
 
 	// Verify it exists
 	_, _, found := cache.Get("default", "test-sa")
@@ -183,7 +715,7 @@ func TestCache_Delete(t *testing.T) {
 	}
 
 	// Delete it
-	cache.delete("default", "test-sa")
+	cache.Delete("default", "test-sa")
 
 	// Verify it's gone
 	_, _, found = cache.Get("default", "test-sa")
@@ -254,6 +786,30 @@ func TestParseSubjects(t *testing.T) {
 			wantSubjects: []string{"platform.events.>"},
 			wantFiltered: []string{"_INBOX.>", "_REPLY.>", "_INBOX_custom.>"},
 		},
+		{
+			name:         "YAML block list",
+			annotation:   "- platform.events.>\n- \"shared.metrics.*\"\n# audit\n- 'audit.>'\n",
+			wantSubjects: []string{"platform.events.>", "shared.metrics.*", "audit.>"},
+			wantFiltered: []string{},
+		},
+		{
+			name:         "YAML flow list",
+			annotation:   "[platform.events.>, \"shared.metrics.*\"]",
+			wantSubjects: []string{"platform.events.>", "shared.metrics.*"},
+			wantFiltered: []string{},
+		},
+		{
+			name:         "YAML block list filters internal patterns",
+			annotation:   "- _INBOX.>\n- platform.events.>",
+			wantSubjects: []string{"platform.events.>"},
+			wantFiltered: []string{"_INBOX.>"},
+		},
+		{
+			name:         "Newline separated",
+			annotation:   "platform.events.>\nshared.metrics.*\n",
+			wantSubjects: []string{"platform.events.>", "shared.metrics.*"},
+			wantFiltered: []string{},
+		},
 		{
 			name:         "Only internal patterns",
 			annotation:   "_INBOX.>, _REPLY.>",
@@ -287,3 +843,123 @@ func equalStringSlices(a, b []string) bool {
 	}
 	return true
 }
+
+// TestCache_NamespaceGrants tests the per-namespace summary of granted subjects
+func TestCache_NamespaceGrants(t *testing.T) {
+	cache := NewCache(zap.NewNop())
+	cache.Upsert(&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "small", Namespace: "orders"}})
+	cache.Upsert(&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{
+		Name:        "large",
+		Namespace:   "orders",
+		Annotations: map[string]string{AnnotationAllowedPubSubjects: "platform.a, platform.b"},
+	}})
+	cache.Upsert(&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "billing"}})
+
+	grants := cache.NamespaceGrants()
+	if len(grants) != 2 {
+		t.Fatalf("NamespaceGrants() has %d namespaces, want 2", len(grants))
+	}
+	// Each ServiceAccount has "{ns}.>" to publish and "_INBOX.>", its private inbox and "{ns}.>" to subscribe
+	orders := grants["orders"]
+	if orders.Publish != 4 || orders.Subscribe != 6 || orders.MaxSubjects != 6 {
+		t.Errorf("orders grants = %+v, want publish 4, subscribe 6, max 6", orders)
+	}
+	if billing := grants["billing"]; billing.Publish != 1 || billing.Subscribe != 3 || billing.MaxSubjects != 4 {
+		t.Errorf("billing grants = %+v, want publish 1, subscribe 3, max 4", billing)
+	}
+
+	cache.Delete("billing", "app")
+	if _, found := cache.NamespaceGrants()["billing"]; found {
+		t.Error("expected no grants for a namespace without ServiceAccounts")
+	}
+}
+
+// TestCache_StorageStats tests that identical subject lists are stored once and released with
+// the last ServiceAccount using them
+func TestCache_StorageStats(t *testing.T) {
+	cache := NewCache(zap.NewNop())
+	for i := range 100 {
+		cache.Upsert(&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{
+			Name:        "app-" + strconv.Itoa(i),
+			Namespace:   "orders",
+			Annotations: map[string]string{AnnotationAllowedPubSubjects: "platform.a"},
+		}})
+	}
+
+	// One publish list for all, and a subscribe list each for their private inboxes, made of
+	// "orders.>", "platform.a", "_INBOX.>" and the inboxes
+	stats := cache.StorageStats()
+	if stats.Lists != 101 || stats.ListRefs != 200 || stats.Subjects != 103 {
+		t.Errorf("StorageStats() = %+v, want 101 lists, 200 list refs and 103 subjects", stats)
+	}
+	if stats.Bytes >= stats.UnsharedBytes {
+		t.Errorf("StorageStats() bytes = %d, want less than the %d unshared", stats.Bytes, stats.UnsharedBytes)
+	}
+
+	pub1, _, _ := cache.Get("orders", "app-1")
+	pub2, _, _ := cache.Get("orders", "app-2")
+	if &pub1[0] != &pub2[0] {
+		t.Error("expected ServiceAccounts with identical publish subjects to share them")
+	}
+	if cap(pub1) != len(pub1) {
+		t.Errorf("shared publish list has capacity %d, want %d so that appending copies it", cap(pub1), len(pub1))
+	}
+
+	// An updated ServiceAccount moves to a new list, leaving the shared one in place
+	cache.Upsert(&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{
+		Name:        "app-0",
+		Namespace:   "orders",
+		Annotations: map[string]string{AnnotationAllowedPubSubjects: "platform.b"},
+	}})
+	if stats := cache.StorageStats(); stats.Lists != 102 || stats.ListRefs != 200 {
+		t.Errorf("StorageStats() after update = %+v, want 102 lists and 200 list refs", stats)
+	}
+	if pub, _, _ := cache.Get("orders", "app-1"); !slices.Equal(pub, []string{"orders.>", "platform.a"}) {
+		t.Errorf("Get(app-1) publish = %v after another ServiceAccount changed", pub)
+	}
+
+	for i := range 100 {
+		cache.Delete("orders", "app-"+strconv.Itoa(i))
+	}
+	if stats := cache.StorageStats(); stats != (StorageStats{}) {
+		t.Errorf("StorageStats() with an empty cache = %+v, want nothing stored", stats)
+	}
+}
+
+// TestCache_ConcurrentReads tests that reads see either the old or the new permissions while
+// ServiceAccounts are being updated (run with -race)
+func TestCache_ConcurrentReads(t *testing.T) {
+	cache := NewCache(zap.NewNop())
+	sa := func(subject string) *corev1.ServiceAccount {
+		return &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{
+			Name:        "app",
+			Namespace:   "orders",
+			Annotations: map[string]string{AnnotationAllowedPubSubjects: subject},
+		}}
+	}
+	cache.Upsert(sa("platform.a"))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := range 1000 {
+			cache.Upsert(sa("platform." + strconv.Itoa(i%2)))
+			cache.Upsert(&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "other-" + strconv.Itoa(i), Namespace: "orders"}})
+		}
+	}()
+
+	for {
+		select {
+		case <-done:
+			if cache.Len() != 1001 {
+				t.Errorf("Len() = %d, want 1001", cache.Len())
+			}
+			return
+		default:
+		}
+		pub, _, found := cache.Get("orders", "app")
+		if !found || len(pub) != 2 || pub[0] != "orders.>" {
+			t.Fatalf("Get() = %v, %v during updates, want the namespace and one platform subject", pub, found)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package k8s
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// AnnotationClass is the annotation key selecting the ServiceAccount's request-reply class
+// ("responder" or "requester"), which shapes its permissions and response permission.
+const AnnotationClass = "nats.io/class"
+
+// Class is the request-reply role of a ServiceAccount
+type Class string
+
+// Request-reply classes
+const (
+	// ClassStandard publishes and subscribes within its namespace and may send one response
+	// per request received (the default)
+	ClassStandard Class = ""
+	// ClassResponder serves requests: it has no default namespace publish scope and sends
+	// responses within the configured response limits
+	ClassResponder Class = "responder"
+	// ClassRequester sends requests: it may only subscribe to its inboxes and cannot respond
+	ClassRequester Class = "requester"
+)
+
+// ParseClass parses a request-reply class; an empty value is ClassStandard
+func ParseClass(value string) (Class, error) {
+	switch class := Class(strings.TrimSpace(value)); class {
+	case ClassStandard, ClassResponder, ClassRequester:
+		return class, nil
+	default:
+		return "", fmt.Errorf("unknown class %q (want %s or %s)", value, ClassResponder, ClassRequester)
+	}
+}
+
+// SetDefaultClass sets the class of ServiceAccounts without a nats.io/class annotation. It
+// only affects ServiceAccounts cached after the call.
+func (c *Cache) SetDefaultClass(class Class) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.defaultClass = class
+}
+
+// Class returns the request-reply class of a ServiceAccount
+func (c *Cache) Class(namespace, name string) Class {
+	perms, found := c.lookup(namespace, name)
+	if !found {
+		return ClassStandard
+	}
+	return perms.Class
+}
+
+// class returns the class a ServiceAccount selects with its annotation, or the default class.
+// Must be called with the cache lock held.
+func (c *Cache) class(sa *corev1.ServiceAccount) Class {
+	value, ok := sa.Annotations[AnnotationClass]
+	if !ok {
+		return c.defaultClass
+	}
+	class, err := ParseClass(value)
+	if err != nil {
+		c.warn(sa, "InvalidAnnotation", fmt.Sprintf("annotation %s: %v; using the default class", AnnotationClass, err))
+		return c.defaultClass
+	}
+	return class
+}
+
+// inboxSubjects returns the inbox subjects among subscribe permissions, the only subscriptions
+// a requester needs to receive replies
+func inboxSubjects(subjects []string) []string {
+	inboxes := make([]string, 0, len(subjects))
+	for _, subject := range subjects {
+		if strings.HasPrefix(subject, "_INBOX") {
+			inboxes = append(inboxes, subject)
+		}
+	}
+	return inboxes
+}
@@ -0,0 +1,99 @@
+package k8s
+
+import (
+	"testing"
+
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TestCache_Class tests the permission shape of each request-reply class
+func TestCache_Class(t *testing.T) {
+	tests := []struct {
+		name         string
+		defaultClass Class
+		annotations  map[string]string
+		wantClass    Class
+		wantPub      []string
+		wantSub      []string
+	}{
+		{
+			name:        "standard",
+			annotations: map[string]string{AnnotationAllowedSubSubjects: "platform.events"},
+			wantClass:   ClassStandard,
+			wantPub:     []string{"orders.>"},
+			wantSub:     []string{"_INBOX.>", "_INBOX_orders_sa.>", "orders.>", "platform.events"},
+		},
+		{
+			name: "responder has no namespace publish scope",
+			annotations: map[string]string{
+				AnnotationClass:              "responder",
+				AnnotationAllowedPubSubjects: "orders.audit",
+			},
+			wantClass: ClassResponder,
+			wantPub:   []string{"orders.audit"},
+			wantSub:   []string{"_INBOX.>", "_INBOX_orders_sa.>", "orders.>"},
+		},
+		{
+			name: "requester only subscribes to inboxes",
+			annotations: map[string]string{
+				AnnotationClass:              "requester",
+				AnnotationAllowedSubSubjects: "platform.events",
+				AnnotationInboxPrefix:        "_INBOX_orders",
+			},
+			wantClass: ClassRequester,
+			wantPub:   []string{"orders.>"},
+			wantSub:   []string{"_INBOX.>", "_INBOX_orders_sa.>", "_INBOX_orders.>"},
+		},
+		{
+			name:         "default class",
+			defaultClass: ClassRequester,
+			wantClass:    ClassRequester,
+			wantPub:      []string{"orders.>"},
+			wantSub:      []string{"_INBOX.>", "_INBOX_orders_sa.>"},
+		},
+		{
+			name:         "invalid class falls back to the default",
+			defaultClass: ClassResponder,
+			annotations:  map[string]string{AnnotationClass: "server"},
+			wantClass:    ClassResponder,
+			wantPub:      []string{},
+			wantSub:      []string{"_INBOX.>", "_INBOX_orders_sa.>", "orders.>"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := NewCache(zap.NewNop())
+			cache.SetDefaultClass(tt.defaultClass)
+			cache.Upsert(&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{
+				Name:        "sa",
+				Namespace:   "orders",
+				Annotations: tt.annotations,
+			}})
+
+			if class := cache.Class("orders", "sa"); class != tt.wantClass {
+				t.Errorf("Class() = %q, want %q", class, tt.wantClass)
+			}
+			pub, sub, _ := cache.Get("orders", "sa")
+			if !equalStringSlices(pub, tt.wantPub) {
+				t.Errorf("pub = %v, want %v", pub, tt.wantPub)
+			}
+			if !equalStringSlices(sub, tt.wantSub) {
+				t.Errorf("sub = %v, want %v", sub, tt.wantSub)
+			}
+		})
+	}
+}
+
+func TestParseClass(t *testing.T) {
+	for value, want := range map[string]Class{"": ClassStandard, "responder": ClassResponder, " requester ": ClassRequester} {
+		if got, err := ParseClass(value); err != nil || got != want {
+			t.Errorf("ParseClass(%q) = %q, %v; want %q", value, got, err, want)
+		}
+	}
+	if _, err := ParseClass("server"); err == nil {
+		t.Error("ParseClass(\"server\") expected error")
+	}
+}
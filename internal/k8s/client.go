@@ -3,20 +3,31 @@ package k8s
 import (
 	"context"
 	"fmt"
+	"time"
 
+	httpmetrics "github.com/portswigger-tim/nats-k8s-oidc-callout/internal/httpserver"
 	"go.uber.org/zap"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 )
 
+// missRetryInterval is how often the cache is re-checked while retrying a miss
+const missRetryInterval = 25 * time.Millisecond
+
 // Client manages Kubernetes ServiceAccount watching and caching
 type Client struct {
-	cache    *Cache
-	informer cache.SharedIndexInformer
-	stopCh   chan struct{}
-	logger   *zap.Logger
+	cache        *Cache
+	informer     cache.SharedIndexInformer
+	nsInformer   cache.SharedIndexInformer // nil unless namespaces are watched
+	nodeInformer cache.SharedIndexInformer // nil unless nodes are watched
+	podInformer  cache.SharedIndexInformer // nil unless workloads are watched
+	rsInformer   cache.SharedIndexInformer // nil unless workloads are watched
+	stopCh       chan struct{}
+	missRetry    time.Duration
+	logger       *zap.Logger
 }
 
 // NewClient creates a new Kubernetes client with ServiceAccount informer
@@ -34,14 +45,15 @@ func NewClient(factory informers.SharedInformerFactory, logger *zap.Logger) *Cli
 	}
 
 	// Register event handlers
-	_, err := informer.AddEventHandler(&cache.ResourceEventHandlerFuncs{
-		AddFunc: func(obj interface{}) {
+	_, err := informer.AddEventHandler(&cache.ResourceEventHandlerDetailedFuncs{
+		AddFunc: func(obj interface{}, isInInitialList bool) {
 			sa, ok := obj.(*corev1.ServiceAccount)
 			if !ok {
 				runtime.HandleError(fmt.Errorf("unexpected object type: %T", obj))
 				return
 			}
-			client.cache.upsert(sa)
+			recordAdd(resourceServiceAccount, sa, isInInitialList)
+			client.cache.Upsert(sa)
 		},
 		UpdateFunc: func(oldObj, newObj interface{}) {
 			sa, ok := newObj.(*corev1.ServiceAccount)
@@ -49,7 +61,10 @@ func NewClient(factory informers.SharedInformerFactory, logger *zap.Logger) *Cli
 				runtime.HandleError(fmt.Errorf("unexpected object type: %T", newObj))
 				return
 			}
-			client.cache.upsert(sa)
+			if old, ok := oldObj.(*corev1.ServiceAccount); ok {
+				recordUpdate(resourceServiceAccount, old, sa)
+			}
+			client.cache.Upsert(sa)
 		},
 		DeleteFunc: func(obj interface{}) {
 			sa, ok := obj.(*corev1.ServiceAccount)
@@ -66,7 +81,8 @@ func NewClient(factory informers.SharedInformerFactory, logger *zap.Logger) *Cli
 					return
 				}
 			}
-			client.cache.delete(sa.Namespace, sa.Name)
+			httpmetrics.RecordK8sEvent(resourceServiceAccount, "delete")
+			client.cache.Delete(sa.Namespace, sa.Name)
 		},
 	})
 
@@ -77,9 +93,285 @@ func NewClient(factory informers.SharedInformerFactory, logger *zap.Logger) *Cli
 	return client
 }
 
-// GetPermissions retrieves the NATS permissions for a ServiceAccount
+// WatchNamespaces also watches namespaces from factory, so that a nats.io/enabled: "false"
+// annotation on a namespace disables NATS access for all of its ServiceAccounts. The factory
+// must not be restricted to a single namespace. It must be called before the informers are started.
+func (c *Client) WatchNamespaces(factory informers.SharedInformerFactory) error {
+	informer := factory.Core().V1().Namespaces().Informer()
+
+	_, err := informer.AddEventHandler(&cache.ResourceEventHandlerDetailedFuncs{
+		AddFunc: func(obj interface{}, isInInitialList bool) {
+			ns, ok := obj.(*corev1.Namespace)
+			if !ok {
+				runtime.HandleError(fmt.Errorf("unexpected object type: %T", obj))
+				return
+			}
+			recordAdd(resourceNamespace, ns, isInInitialList)
+			c.upsertNamespace(ns)
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			ns, ok := newObj.(*corev1.Namespace)
+			if !ok {
+				runtime.HandleError(fmt.Errorf("unexpected object type: %T", newObj))
+				return
+			}
+			if old, ok := oldObj.(*corev1.Namespace); ok {
+				recordUpdate(resourceNamespace, old, ns)
+			}
+			c.upsertNamespace(ns)
+		},
+		DeleteFunc: func(obj interface{}) {
+			ns, ok := obj.(*corev1.Namespace)
+			if !ok {
+				tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+				if !ok {
+					runtime.HandleError(fmt.Errorf("unexpected object type: %T", obj))
+					return
+				}
+				ns, ok = tombstone.Obj.(*corev1.Namespace)
+				if !ok {
+					runtime.HandleError(fmt.Errorf("tombstone contained unexpected object: %T", tombstone.Obj))
+					return
+				}
+			}
+			httpmetrics.RecordK8sEvent(resourceNamespace, "delete")
+			c.cache.DeleteNamespace(ns.Name)
+			c.resyncNamespace(ns.Name)
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to add namespace event handler: %w", err)
+	}
+	if err := informer.SetWatchErrorHandler(func(_ *cache.Reflector, err error) {
+		c.logger.Warn("namespace watch failed", zap.Error(err))
+		httpmetrics.IncrementK8sWatchErrors(resourceNamespace)
+	}); err != nil {
+		return fmt.Errorf("failed to register namespace watch error handler: %w", err)
+	}
+
+	c.nsInformer = informer
+	return nil
+}
+
+// SetPolicy configures the cluster defaults and profiles of the permission chain (see
+// Cache.SetPolicy). It must be called before the informer is started.
+func (c *Client) SetPolicy(policy *Policy) {
+	c.cache.SetPolicy(policy)
+}
+
+// PolicyStatus reports whether the permission policy is in effect, or nil when none is set.
+func (c *Client) PolicyStatus() *PolicyStatus {
+	return c.cache.PolicyStatus()
+}
+
+// upsertNamespace records a namespace and, when its subjects changed, rebuilds the
+// permissions of its ServiceAccounts
+func (c *Client) upsertNamespace(ns *corev1.Namespace) {
+	if c.cache.UpsertNamespace(ns) {
+		c.resyncNamespace(ns.Name)
+	}
+}
+
+// resyncNamespace upserts every known ServiceAccount in a namespace again, so that their
+// permissions reflect the namespace's current level of the permission chain
+func (c *Client) resyncNamespace(namespace string) {
+	objs, err := c.informer.GetIndexer().ByIndex(cache.NamespaceIndex, namespace)
+	if err != nil {
+		runtime.HandleError(fmt.Errorf("failed to list ServiceAccounts in namespace %s: %w", namespace, err))
+		return
+	}
+	for _, obj := range objs {
+		if sa, ok := obj.(*corev1.ServiceAccount); ok {
+			c.cache.Upsert(sa)
+		}
+	}
+}
+
+// OnWatchError registers a callback for errors that break the ServiceAccount watch.
+// It must be called before the informer is started.
+func (c *Client) OnWatchError(fn func(err error)) error {
+	return c.informer.SetWatchErrorHandler(func(_ *cache.Reflector, err error) {
+		c.logger.Warn("ServiceAccount watch failed", zap.Error(err))
+		httpmetrics.IncrementK8sWatchErrors(resourceServiceAccount)
+		fn(err)
+	})
+}
+
+// SetMissRetry sets how long a cache miss is retried before the ServiceAccount is
+// reported as not found. This covers the window between a ServiceAccount being created
+// and the informer delivering it. Zero disables retries.
+func (c *Client) SetMissRetry(d time.Duration) {
+	c.missRetry = d
+}
+
+// SetAnnotationAliases configures deprecated annotation keys accepted in place of the
+// canonical ones (see Cache.SetAnnotationAliases). It must be called before the informer is started.
+func (c *Client) SetAnnotationAliases(aliases map[string]string) error {
+	return c.cache.SetAnnotationAliases(aliases)
+}
+
+// SetLimits configures the annotation limits (see Cache.SetLimits). It must be called
+// before the informer is started.
+func (c *Client) SetLimits(limits Limits) {
+	c.cache.SetLimits(limits)
+}
+
+// SetRequireOptIn controls whether ServiceAccounts need nats.io/enabled: "true" for NATS
+// access (see Cache.SetRequireOptIn). It must be called before the informer is started.
+func (c *Client) SetRequireOptIn(required bool) {
+	c.cache.SetRequireOptIn(required)
+}
+
+// SetSharedInbox controls whether ServiceAccounts are granted the shared _INBOX.> subscription
+// unless annotated otherwise (see Cache.SetSharedInbox). It must be called before the informer
+// is started.
+func (c *Client) SetSharedInbox(enabled bool) {
+	c.cache.SetSharedInbox(enabled)
+}
+
+// SetDefaultClass sets the class of ServiceAccounts without a nats.io/class annotation (see
+// Cache.SetDefaultClass). It must be called before the informer is started.
+func (c *Client) SetDefaultClass(class Class) {
+	c.cache.SetDefaultClass(class)
+}
+
+// SetSubjectPrefix configures the prefix applied to annotation subjects (see
+// Cache.SetSubjectPrefix). It must be called before the informer is started.
+func (c *Client) SetSubjectPrefix(prefix string) error {
+	return c.cache.SetSubjectPrefix(prefix)
+}
+
+// SetEventRecorder sets the recorder for ServiceAccount events (see Cache.SetEventRecorder).
+// It must be called before the informer is started.
+func (c *Client) SetEventRecorder(recorder record.EventRecorder) {
+	c.cache.SetEventRecorder(recorder)
+}
+
+// HasSynced reports whether the initial ServiceAccount list (and namespace, node, pod and
+// ReplicaSet lists, when they are watched) has been loaded into the cache.
+func (c *Client) HasSynced() bool {
+	for _, informer := range []cache.SharedIndexInformer{c.nsInformer, c.nodeInformer, c.podInformer, c.rsInformer} {
+		if informer != nil && !informer.HasSynced() {
+			return false
+		}
+	}
+	return c.informer.HasSynced()
+}
+
+// Disabled reports whether NATS access is disabled for a ServiceAccount by a nats.io/enabled
+// annotation on the ServiceAccount or its namespace.
+func (c *Client) Disabled(namespace, name string) bool {
+	return c.cache.Disabled(namespace, name)
+}
+
+// Bearer reports whether a ServiceAccount requests bearer user JWTs with the nats.io/bearer annotation.
+func (c *Client) Bearer(namespace, name string) bool {
+	return c.cache.Bearer(namespace, name)
+}
+
+// ReadOnly reports whether a ServiceAccount is marked read-only with the nats.io/read-only annotation.
+func (c *Client) ReadOnly(namespace, name string) bool {
+	return c.cache.ReadOnly(namespace, name)
+}
+
+// DeniedSubjects returns the subjects a ServiceAccount is denied with the
+// nats.io/denied-pub-subjects and nats.io/denied-sub-subjects annotations.
+func (c *Client) DeniedSubjects(namespace, name string) (publish, subscribe []string) {
+	return c.cache.DeniedSubjects(namespace, name)
+}
+
+// TokenTTL returns the user JWT lifetime a ServiceAccount requests with the nats.io/token-ttl
+// annotation, or zero for the default.
+func (c *Client) TokenTTL(namespace, name string) time.Duration {
+	return c.cache.TokenTTL(namespace, name)
+}
+
+// Class returns the request-reply class of a ServiceAccount, selected with the nats.io/class
+// annotation or the default class.
+func (c *Client) Class(namespace, name string) string {
+	return string(c.cache.Class(namespace, name))
+}
+
+// UserLimits returns the NATS user limits (subscriptions, payload and data; zero when not set)
+// a ServiceAccount requests with the nats.io/max-* annotations.
+func (c *Client) UserLimits(namespace, name string) (subs, payload, data int64) {
+	limits := c.cache.UserLimits(namespace, name)
+	return limits.Subscriptions, limits.Payload, limits.Data
+}
+
+// MaxConnections returns the most open connections a ServiceAccount requests with the
+// nats.io/max-connections annotation, or zero for the default.
+func (c *Client) MaxConnections(namespace, name string) int {
+	return c.cache.MaxConnections(namespace, name)
+}
+
+// Role returns the scoped signing key role a ServiceAccount selects with the nats.io/role
+// annotation, or "" for none.
+func (c *Client) Role(namespace, name string) string {
+	return c.cache.Role(namespace, name)
+}
+
+// Account returns the NATS account a ServiceAccount selects with the nats.io/account
+// annotation, or "" for the default account.
+func (c *Client) Account(namespace, name string) string {
+	return c.cache.Account(namespace, name)
+}
+
+// JetStreamAdmin reports whether a ServiceAccount is exempt from the JetStream API protection
+// by the nats.io/js-admin annotation.
+func (c *Client) JetStreamAdmin(namespace, name string) bool {
+	return c.cache.JetStreamAdmin(namespace, name)
+}
+
+// RequireTLS reports whether a ServiceAccount's connections must arrive over TLS, by the
+// nats.io/require-tls annotation of its namespace.
+func (c *Client) RequireTLS(namespace, name string) bool {
+	return c.cache.RequireTLS(namespace, name)
+}
+
+// GetPermissions retrieves the NATS permissions for a ServiceAccount.
+// Misses are retried for up to the configured miss retry window once the cache has synced;
+// before that, misses are returned immediately so callers can report a retryable denial.
 func (c *Client) GetPermissions(namespace, name string) (pubPerms, subPerms []string, found bool) {
-	return c.cache.Get(namespace, name)
+	pubPerms, subPerms, found = c.cache.Get(namespace, name)
+	if found || c.missRetry <= 0 || !c.HasSynced() {
+		return pubPerms, subPerms, found
+	}
+
+	deadline := time.Now().Add(c.missRetry)
+	for time.Now().Before(deadline) {
+		time.Sleep(missRetryInterval)
+		if pubPerms, subPerms, found = c.cache.peek(namespace, name); found {
+			c.logger.Debug("ServiceAccount found after retrying cache miss",
+				zap.String("namespace", namespace),
+				zap.String("name", name))
+			return pubPerms, subPerms, true
+		}
+	}
+	return nil, nil, false
+}
+
+// CacheSize returns the number of cached ServiceAccounts
+func (c *Client) CacheSize() int {
+	return c.cache.Len()
+}
+
+// StorageStats measures how the subjects of the cached permissions are stored, to show the
+// memory saved by sharing identical subject lists between ServiceAccounts
+func (c *Client) StorageStats() StorageStats {
+	return c.cache.StorageStats()
+}
+
+// Permissions returns the permissions computed for every cached ServiceAccount, keyed by
+// "namespace/name", and whether the cache has synced so that the set is complete.
+func (c *Client) Permissions() (map[string]Permissions, bool) {
+	synced := c.HasSynced()
+	return c.cache.effectiveEntries(), synced
+}
+
+// NamespaceGrants summarizes the subjects granted to the cached ServiceAccounts of each namespace
+func (c *Client) NamespaceGrants() map[string]httpmetrics.NamespaceGrants {
+	return c.cache.NamespaceGrants()
 }
 
 // Shutdown gracefully shuts down the client
@@ -132,7 +132,7 @@ func TestClient_GetPermissions(t *testing.T) {
 			},
 		},
 	}
-	client.cache.upsert(sa)
+	client.cache.Upsert(sa)
 
 	pubPerms, subPerms, found := client.GetPermissions("default", "test-sa")
 	if !found {
@@ -169,3 +169,158 @@ func TestClient_Shutdown(t *testing.T) {
 		t.Errorf("Shutdown failed: %v", err)
 	}
 }
+
+// TestClient_HasSynced tests that sync state reflects the informer
+func TestClient_HasSynced(t *testing.T) {
+	fakeClient := fake.NewSimpleClientset()
+	informerFactory := informers.NewSharedInformerFactory(fakeClient, 0)
+	client := NewClient(informerFactory, zap.NewNop())
+
+	if client.HasSynced() {
+		t.Error("expected HasSynced() = false before informer start")
+	}
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	informerFactory.Start(stopCh)
+	informerFactory.WaitForCacheSync(stopCh)
+
+	if !client.HasSynced() {
+		t.Error("expected HasSynced() = true after cache sync")
+	}
+}
+
+// TestClient_WatchNamespaces tests that namespace annotations disable access once watched
+func TestClient_WatchNamespaces(t *testing.T) {
+	fakeClient := fake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+			Name:        "orders",
+			Annotations: map[string]string{AnnotationEnabled: "false"},
+		}},
+		&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "orders"}},
+	)
+	informerFactory := informers.NewSharedInformerFactory(fakeClient, 0)
+	client := NewClient(informerFactory, zap.NewNop())
+	if err := client.WatchNamespaces(informerFactory); err != nil {
+		t.Fatalf("WatchNamespaces() error = %v", err)
+	}
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	informerFactory.Start(stopCh)
+	informerFactory.WaitForCacheSync(stopCh)
+
+	if !client.HasSynced() {
+		t.Fatal("expected HasSynced() = true after cache sync")
+	}
+	if _, _, found := client.GetPermissions("orders", "api"); !found {
+		t.Fatal("expected ServiceAccount in a disabled namespace to still be cached")
+	}
+	if !client.Disabled("orders", "api") {
+		t.Error("expected ServiceAccount disabled by its namespace annotation")
+	}
+}
+
+// TestClient_NamespaceSubjects tests that ServiceAccounts pick up namespace subject annotations
+func TestClient_NamespaceSubjects(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	fakeClient := fake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "orders"}},
+		&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "orders"}},
+	)
+	informerFactory := informers.NewSharedInformerFactory(fakeClient, 0)
+	client := NewClient(informerFactory, zap.NewNop())
+	if err := client.WatchNamespaces(informerFactory); err != nil {
+		t.Fatalf("WatchNamespaces() error = %v", err)
+	}
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	informerFactory.Start(stopCh)
+	informerFactory.WaitForCacheSync(stopCh)
+
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:        "orders",
+		Annotations: map[string]string{AnnotationAllowedPubSubjects: "shared.orders.>"},
+	}}
+	if _, err := fakeClient.CoreV1().Namespaces().Update(ctx, ns, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("Failed to update namespace: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	pubPerms, _, _ := client.GetPermissions("orders", "api")
+	if !equalStringSlices(pubPerms, []string{"orders.>", "shared.orders.>"}) {
+		t.Errorf("pubPerms = %v, want namespace subjects inherited", pubPerms)
+	}
+}
+
+// TestClient_MissRetry tests that a miss is retried until the ServiceAccount arrives
+func TestClient_MissRetry(t *testing.T) {
+	fakeClient := fake.NewSimpleClientset()
+	informerFactory := informers.NewSharedInformerFactory(fakeClient, 0)
+	client := NewClient(informerFactory, zap.NewNop())
+	client.SetMissRetry(time.Second)
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	informerFactory.Start(stopCh)
+	informerFactory.WaitForCacheSync(stopCh)
+
+	// Simulate the informer delivering a just-created ServiceAccount during the lookup
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		client.cache.Upsert(&corev1.ServiceAccount{
+			ObjectMeta: metav1.ObjectMeta{Name: "new-sa", Namespace: "default"},
+		})
+	}()
+
+	if _, _, found := client.GetPermissions("default", "new-sa"); !found {
+		t.Error("expected ServiceAccount created during retry window to be found")
+	}
+
+	// Genuinely unknown ServiceAccounts are reported after the window
+	client.SetMissRetry(50 * time.Millisecond)
+	start := time.Now()
+	if _, _, found := client.GetPermissions("default", "missing-sa"); found {
+		t.Error("expected unknown ServiceAccount to be not found")
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("expected lookup to retry for the window, returned after %v", elapsed)
+	}
+}
+
+// TestClient_WatchNodes tests that node labels are known once nodes are watched
+func TestClient_WatchNodes(t *testing.T) {
+	fakeClient := fake.NewSimpleClientset(&corev1.Node{ObjectMeta: metav1.ObjectMeta{
+		Name:   "trusted-1",
+		Labels: map[string]string{"trusted": "true"},
+	}})
+	informerFactory := informers.NewSharedInformerFactory(fakeClient, 0)
+	client := NewClient(informerFactory, zap.NewNop())
+
+	// Without the node informer no node is known, so node restrictions fail closed
+	if _, found := client.NodeLabels("trusted-1"); found {
+		t.Error("NodeLabels() found a node before nodes are watched")
+	}
+
+	if err := client.WatchNodes(informerFactory); err != nil {
+		t.Fatalf("WatchNodes() error = %v", err)
+	}
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	informerFactory.Start(stopCh)
+	informerFactory.WaitForCacheSync(stopCh)
+
+	if !client.HasSynced() {
+		t.Error("HasSynced() = false after the node informer synced")
+	}
+	labels, found := client.NodeLabels("trusted-1")
+	if !found || labels["trusted"] != "true" {
+		t.Errorf("NodeLabels(trusted-1) = %v, %v; want trusted=true", labels, found)
+	}
+	if _, found := client.NodeLabels("deleted-1"); found {
+		t.Error("NodeLabels(deleted-1) found an unknown node")
+	}
+}
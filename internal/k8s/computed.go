@@ -0,0 +1,157 @@
+package k8s
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Annotations the service writes, when enabled, with the permissions it computed for a
+// ServiceAccount. They are status, not input: changing them has no effect.
+const (
+	// AnnotationComputedPermissions is the computed permissions, as JSON
+	AnnotationComputedPermissions = "nats.io/computed-permissions"
+	// AnnotationComputedPermissionsHash is a short SHA-256 of the computed permissions
+	AnnotationComputedPermissionsHash = "nats.io/computed-permissions-hash"
+)
+
+// PermissionsAnnotator writes the permissions computed for each cached ServiceAccount back
+// onto it, so developers can check what the service derived from their annotations with
+// kubectl. Only ServiceAccounts whose hash annotation is out of date are patched.
+type PermissionsAnnotator struct {
+	client    *Client
+	clientset kubernetes.Interface
+	logger    *zap.Logger
+
+	failing bool // whether the last reconciliation failed, so failures are logged once
+}
+
+// NewPermissionsAnnotator creates an annotator for the ServiceAccounts cached by client,
+// patching them through clientset
+func NewPermissionsAnnotator(client *Client, clientset kubernetes.Interface, logger *zap.Logger) *PermissionsAnnotator {
+	return &PermissionsAnnotator{client: client, clientset: clientset, logger: logger}
+}
+
+// Run reconciles the annotations every interval until the context is cancelled
+func (a *PermissionsAnnotator) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		err := a.Reconcile(ctx)
+		switch {
+		case err != nil && !a.failing && ctx.Err() == nil:
+			a.logger.Warn("failed to annotate ServiceAccounts with their permissions; retrying every interval",
+				zap.Error(err))
+		case err == nil && a.failing:
+			a.logger.Info("annotating ServiceAccounts with their permissions again")
+		}
+		a.failing = err != nil
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Reconcile patches the ServiceAccounts whose computed permissions annotations are missing
+// or out of date. Nothing is written until the cache has synced. Every ServiceAccount is
+// attempted; the first error is returned along with the number of failures.
+func (a *PermissionsAnnotator) Reconcile(ctx context.Context) error {
+	entries, synced := a.client.Permissions()
+	if !synced {
+		return nil
+	}
+
+	var firstErr error
+	var patched, failed int
+	for _, obj := range a.client.informer.GetStore().List() {
+		sa, ok := obj.(*corev1.ServiceAccount)
+		if !ok {
+			continue
+		}
+		perms, found := entries[sa.Namespace+"/"+sa.Name]
+		if !found {
+			continue
+		}
+		value, hash, err := computedAnnotations(perms)
+		if err != nil {
+			return err
+		}
+		if sa.Annotations[AnnotationComputedPermissionsHash] == hash {
+			continue
+		}
+		if err := a.annotate(ctx, sa.Namespace, sa.Name, value, hash); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			failed++
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		patched++
+	}
+
+	if patched > 0 {
+		a.logger.Debug("annotated ServiceAccounts with their permissions", zap.Int("patched", patched))
+	}
+	if firstErr != nil {
+		return fmt.Errorf("%d ServiceAccounts not annotated: %w", failed, firstErr)
+	}
+	return nil
+}
+
+// annotate sets a ServiceAccount's computed permissions annotations. A ServiceAccount
+// deleted since it was listed is skipped.
+func (a *PermissionsAnnotator) annotate(ctx context.Context, namespace, name, value, hash string) error {
+	patch, err := json.Marshal(map[string]any{
+		"metadata": map[string]any{
+			"annotations": map[string]string{
+				AnnotationComputedPermissions:     value,
+				AnnotationComputedPermissionsHash: hash,
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = a.clientset.CoreV1().ServiceAccounts(namespace).Patch(ctx, name, types.MergePatchType, patch,
+		metav1.PatchOptions{FieldManager: fieldManager})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to annotate ServiceAccount %s/%s: %w", namespace, name, err)
+	}
+	return nil
+}
+
+// computedAnnotations renders permissions as compact JSON, without escaping the > wildcard,
+// and its short hash
+func computedAnnotations(perms Permissions) (value, hash string, err error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(perms); err != nil {
+		return "", "", fmt.Errorf("failed to encode permissions: %w", err)
+	}
+	data := bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+	sum := sha256.Sum256(data)
+	return string(data), hex.EncodeToString(sum[:8]), nil
+}
@@ -0,0 +1,70 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// TestPermissionsAnnotator tests that computed permissions are written back once per change
+func TestPermissionsAnnotator(t *testing.T) {
+	fakeClient := fake.NewSimpleClientset(&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{
+		Name:        "api",
+		Namespace:   "orders",
+		Annotations: map[string]string{AnnotationAllowedPubSubjects: "shared.>"},
+	}})
+	informerFactory := informers.NewSharedInformerFactory(fakeClient, 0)
+	client := NewClient(informerFactory, zap.NewNop())
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	informerFactory.Start(stopCh)
+	informerFactory.WaitForCacheSync(stopCh)
+
+	annotator := NewPermissionsAnnotator(client, fakeClient, zap.NewNop())
+	ctx := context.Background()
+	if err := annotator.Reconcile(ctx); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	sa, err := fakeClient.CoreV1().ServiceAccounts("orders").Get(ctx, "api", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"publish":["orders.>","shared.>"],"subscribe":["_INBOX.>","_INBOX_orders_api.>","orders.>"]}`
+	if got := sa.Annotations[AnnotationComputedPermissions]; got != want {
+		t.Errorf("%s = %s, want %s", AnnotationComputedPermissions, got, want)
+	}
+	if len(sa.Annotations[AnnotationComputedPermissionsHash]) != 16 {
+		t.Errorf("%s = %q, want a 16 character hash", AnnotationComputedPermissionsHash, sa.Annotations[AnnotationComputedPermissionsHash])
+	}
+	if sa.Annotations[AnnotationAllowedPubSubjects] != "shared.>" {
+		t.Errorf("annotations = %v, want the existing annotations kept", sa.Annotations)
+	}
+
+	// Once the informer sees the annotation, unchanged permissions are not patched again
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		obj, exists, _ := client.informer.GetStore().GetByKey("orders/api")
+		if exists && obj.(*corev1.ServiceAccount).Annotations[AnnotationComputedPermissionsHash] != "" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("informer did not observe the annotation")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	fakeClient.ClearActions()
+	if err := annotator.Reconcile(ctx); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	for _, action := range fakeClient.Actions() {
+		if action.GetVerb() == "patch" {
+			t.Errorf("unchanged permissions patched again: %v", action)
+		}
+	}
+}
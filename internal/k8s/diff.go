@@ -0,0 +1,86 @@
+package k8s
+
+import (
+	"maps"
+	"slices"
+
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+
+	httpmetrics "github.com/portswigger-tim/nats-k8s-oidc-callout/internal/httpserver"
+)
+
+// permissionDiff is the difference between two computed permissions of a ServiceAccount
+type permissionDiff struct {
+	addedPub, removedPub []string
+	addedSub, removedSub []string
+	settings             []string // other settings that changed, by annotation name
+}
+
+// diffPermissions compares the permissions computed for a ServiceAccount before and after a change
+func diffPermissions(old, updated *Permissions) permissionDiff {
+	var d permissionDiff
+	d.addedPub, d.removedPub = diffSubjects(old.Publish, updated.Publish)
+	d.addedSub, d.removedSub = diffSubjects(old.Subscribe, updated.Subscribe)
+
+	for _, setting := range []struct {
+		name    string
+		changed bool
+	}{
+		{"enabled", old.Disabled != updated.Disabled},
+		{"bearer", old.Bearer != updated.Bearer},
+		{"read-only", old.ReadOnly != updated.ReadOnly},
+		{"denied-subjects", !slices.Equal(old.DenyPublish, updated.DenyPublish) || !slices.Equal(old.DenySubscribe, updated.DenySubscribe)},
+		{"token-ttl", old.TokenTTL != updated.TokenTTL},
+		{"class", old.Class != updated.Class},
+		{"limits", old.Limits != updated.Limits},
+		{"role", old.Role != updated.Role},
+		{"account", old.Account != updated.Account},
+		{"js-admin", old.JSAdmin != updated.JSAdmin},
+		{"node-selector", !maps.Equal(old.NodeSelector, updated.NodeSelector)},
+	} {
+		if setting.changed {
+			d.settings = append(d.settings, setting.name)
+		}
+	}
+	return d
+}
+
+// diffSubjects returns the subjects only in updated and those only in old
+func diffSubjects(old, updated []string) (added, removed []string) {
+	for _, subject := range updated {
+		if !slices.Contains(old, subject) {
+			added = append(added, subject)
+		}
+	}
+	for _, subject := range old {
+		if !slices.Contains(updated, subject) {
+			removed = append(removed, subject)
+		}
+	}
+	return added, removed
+}
+
+// empty reports whether nothing changed
+func (d permissionDiff) empty() bool {
+	return len(d.addedPub) == 0 && len(d.removedPub) == 0 &&
+		len(d.addedSub) == 0 && len(d.removedSub) == 0 && len(d.settings) == 0
+}
+
+// logPermissionChange logs and counts a change of a cached ServiceAccount's permissions, as an
+// audit trail of privilege changes made by editing annotations
+func (c *Cache) logPermissionChange(sa *corev1.ServiceAccount, old, updated *Permissions) {
+	d := diffPermissions(old, updated)
+	if d.empty() {
+		return
+	}
+	c.logger.Info("ServiceAccount permissions changed",
+		zap.String("namespace", sa.Namespace),
+		zap.String("name", sa.Name),
+		zap.Strings("added_publish", d.addedPub),
+		zap.Strings("removed_publish", d.removedPub),
+		zap.Strings("added_subscribe", d.addedSub),
+		zap.Strings("removed_subscribe", d.removedSub),
+		zap.Strings("changed_settings", d.settings))
+	httpmetrics.IncrementPermissionChanges(sa.Namespace, sa.Name)
+}
@@ -0,0 +1,69 @@
+package k8s
+
+import (
+	"reflect"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TestDiffPermissions tests the subjects and settings reported as changed
+func TestDiffPermissions(t *testing.T) {
+	old := &Permissions{
+		Publish:   []string{"orders.>", "events.>"},
+		Subscribe: []string{"_INBOX.>", "orders.>"},
+	}
+	updated := &Permissions{
+		Publish:   []string{"orders.>", ">"},
+		Subscribe: []string{"_INBOX.>", "orders.>"},
+		Bearer:    true,
+		JSAdmin:   true,
+	}
+
+	d := diffPermissions(old, updated)
+	if !reflect.DeepEqual(d.addedPub, []string{">"}) || !reflect.DeepEqual(d.removedPub, []string{"events.>"}) {
+		t.Errorf("publish diff = +%v -%v, want +[>] -[events.>]", d.addedPub, d.removedPub)
+	}
+	if len(d.addedSub) != 0 || len(d.removedSub) != 0 {
+		t.Errorf("subscribe diff = +%v -%v, want none", d.addedSub, d.removedSub)
+	}
+	if !reflect.DeepEqual(d.settings, []string{"bearer", "js-admin"}) {
+		t.Errorf("settings = %v, want [bearer js-admin]", d.settings)
+	}
+
+	if !diffPermissions(old, old).empty() {
+		t.Error("expected identical permissions to have an empty diff")
+	}
+}
+
+// TestCache_LogsPermissionChanges tests that only changes of a cached ServiceAccount are logged
+func TestCache_LogsPermissionChanges(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	cache := NewCache(zap.New(core))
+
+	sa := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{
+		Name:        "api",
+		Namespace:   "orders",
+		Annotations: map[string]string{AnnotationAllowedPubSubjects: "events.>"},
+	}}
+	cache.Upsert(sa)
+	cache.Upsert(sa)
+	if n := logs.FilterMessage("ServiceAccount permissions changed").Len(); n != 0 {
+		t.Fatalf("logged %d changes for a new and an unchanged ServiceAccount, want 0", n)
+	}
+
+	sa.Annotations = map[string]string{AnnotationAllowedPubSubjects: "events.>, payments.>"}
+	cache.Upsert(sa)
+	changes := logs.FilterMessage("ServiceAccount permissions changed").All()
+	if len(changes) != 1 {
+		t.Fatalf("logged %d changes, want 1", len(changes))
+	}
+	fields := changes[0].ContextMap()
+	if added, _ := fields["added_publish"].([]interface{}); len(added) != 1 || added[0] != "payments.>" {
+		t.Errorf("added_publish = %v, want [payments.>]", fields["added_publish"])
+	}
+}
@@ -0,0 +1,62 @@
+package k8s
+
+import (
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	httpmetrics "github.com/portswigger-tim/nats-k8s-oidc-callout/internal/httpserver"
+)
+
+// Informer resources, as reported in metrics
+const (
+	resourceServiceAccount = "serviceaccount"
+	resourceNamespace      = "namespace"
+	resourceNode           = "node"
+	resourcePod            = "pod"
+	resourceReplicaSet     = "replicaset"
+)
+
+// recordAdd counts an add event. Objects created while the informer is running have their
+// delivery lag observed; those from the initial list were created long before.
+func recordAdd(resource string, obj metav1.Object, isInInitialList bool) {
+	httpmetrics.RecordK8sEvent(resource, "add")
+	if !isInInitialList {
+		observeLag(resource, obj)
+	}
+}
+
+// recordUpdate counts an update event. An update without a new resource version is a
+// periodic resync or a relist after a broken watch rather than a change, and has no lag.
+func recordUpdate(resource string, oldObj, newObj metav1.Object) {
+	if oldObj.GetResourceVersion() == newObj.GetResourceVersion() {
+		httpmetrics.RecordK8sEvent(resource, "resync")
+		return
+	}
+	httpmetrics.RecordK8sEvent(resource, "update")
+	observeLag(resource, newObj)
+}
+
+// observeLag records how long after its last change on the API server an object's event
+// reached the handler
+func observeLag(resource string, obj metav1.Object) {
+	if lag, ok := eventLag(obj, time.Now()); ok {
+		httpmetrics.ObserveK8sEventLag(resource, lag)
+	}
+}
+
+// eventLag returns the time since the object's last recorded change: the latest of its
+// creation and managed field timestamps. These have second precision and come from the API
+// server's clock, so the lag is approximate and never negative.
+func eventLag(obj metav1.Object, now time.Time) (time.Duration, bool) {
+	changed := obj.GetCreationTimestamp().Time
+	for _, entry := range obj.GetManagedFields() {
+		if entry.Time != nil && entry.Time.After(changed) {
+			changed = entry.Time.Time
+		}
+	}
+	if changed.IsZero() {
+		return 0, false
+	}
+	return max(now.Sub(changed), 0), true
+}
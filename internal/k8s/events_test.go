@@ -0,0 +1,63 @@
+package k8s
+
+import (
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestEventLag(t *testing.T) {
+	now := time.Date(2024, 1, 27, 10, 30, 0, 0, time.UTC)
+	at := func(ago time.Duration) *metav1.Time {
+		t := metav1.NewTime(now.Add(-ago))
+		return &t
+	}
+
+	tests := []struct {
+		name    string
+		meta    metav1.ObjectMeta
+		wantLag time.Duration
+		wantOK  bool
+	}{
+		{
+			name:   "no timestamps",
+			wantOK: false,
+		},
+		{
+			name:    "created",
+			meta:    metav1.ObjectMeta{CreationTimestamp: *at(3 * time.Second)},
+			wantLag: 3 * time.Second,
+			wantOK:  true,
+		},
+		{
+			name: "latest managed field change",
+			meta: metav1.ObjectMeta{
+				CreationTimestamp: *at(time.Hour),
+				ManagedFields: []metav1.ManagedFieldsEntry{
+					{Manager: "kubectl", Time: at(10 * time.Minute)},
+					{Manager: "argocd", Time: at(2 * time.Second)},
+					{Manager: "unknown"},
+				},
+			},
+			wantLag: 2 * time.Second,
+			wantOK:  true,
+		},
+		{
+			name:    "API server clock ahead",
+			meta:    metav1.ObjectMeta{CreationTimestamp: *at(-time.Second)},
+			wantLag: 0,
+			wantOK:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lag, ok := eventLag(&corev1.ServiceAccount{ObjectMeta: tt.meta}, now)
+			if lag != tt.wantLag || ok != tt.wantOK {
+				t.Errorf("eventLag() = %v, %v; want %v, %v", lag, ok, tt.wantLag, tt.wantOK)
+			}
+		})
+	}
+}
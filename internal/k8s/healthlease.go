@@ -0,0 +1,204 @@
+package k8s
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Annotations of the health Lease
+const (
+	// AnnotationHealthStatus is the overall readiness status: ok, degraded or failed
+	AnnotationHealthStatus = "nats.io/status"
+	// AnnotationHealthVersion is the version of the running build
+	AnnotationHealthVersion = "nats.io/version"
+	// AnnotationHealth is the liveness and readiness checks, as JSON
+	AnnotationHealth = "nats.io/health"
+	// AnnotationHealthStats is the stats snapshot served on /debug/stats, as JSON
+	AnnotationHealthStats = "nats.io/stats"
+)
+
+// LabelHealthLease marks the health Leases, so they can be listed with a label selector
+const LabelHealthLease = "nats.io/health-lease"
+
+// leaseRequestTimeout bounds each request to the Kubernetes API
+const leaseRequestTimeout = 10 * time.Second
+
+// HealthReport is the state of the instance published on its health Lease
+type HealthReport struct {
+	Status  string // overall readiness status
+	Version string
+	Health  any // marshalled into the nats.io/health annotation
+	Stats   any // marshalled into the nats.io/stats annotation
+}
+
+// HealthLease publishes the health of the instance on a coordination.k8s.io Lease named
+// after the pod, so operators and controllers can observe it through the Kubernetes API
+// alone. The Lease is renewed every interval and expires after three missed renewals; it is
+// owned by the pod, when its UID is known, so it is garbage collected with it.
+type HealthLease struct {
+	clientset kubernetes.Interface
+	namespace string
+	name      string
+	podUID    string
+	interval  time.Duration
+	report    func() HealthReport
+	logger    *zap.Logger
+	now       func() time.Time
+
+	failing bool // whether the last publication failed, so failures are logged once
+}
+
+// NewHealthLease creates a health Lease for the pod name in namespace, published with the
+// report every interval. podUID may be empty.
+func NewHealthLease(clientset kubernetes.Interface, namespace, name, podUID string, interval time.Duration,
+	report func() HealthReport, logger *zap.Logger) *HealthLease {
+	return &HealthLease{
+		clientset: clientset,
+		namespace: namespace,
+		name:      name,
+		podUID:    podUID,
+		interval:  interval,
+		report:    report,
+		logger:    logger,
+		now:       time.Now,
+	}
+}
+
+// Run publishes the health Lease every interval until the context is cancelled, then deletes
+// it so a stopped instance does not linger.
+func (l *HealthLease) Run(ctx context.Context) {
+	ticker := time.NewTicker(l.interval)
+	defer ticker.Stop()
+
+	l.publishLogged(ctx)
+	for {
+		select {
+		case <-ticker.C:
+			l.publishLogged(ctx)
+		case <-ctx.Done():
+			deleteCtx, cancel := context.WithTimeout(context.Background(), leaseRequestTimeout)
+			defer cancel()
+			if err := l.Delete(deleteCtx); err != nil {
+				l.logger.Warn("failed to delete health Lease", zap.String("lease", l.name), zap.Error(err))
+			}
+			return
+		}
+	}
+}
+
+// publishLogged publishes the Lease, logging the first of consecutive failures and the recovery
+func (l *HealthLease) publishLogged(ctx context.Context) {
+	reqCtx, cancel := context.WithTimeout(ctx, leaseRequestTimeout)
+	defer cancel()
+	err := l.Publish(reqCtx)
+	switch {
+	case err != nil && !l.failing:
+		l.logger.Warn("failed to publish health Lease; retrying every interval",
+			zap.String("lease", l.name), zap.Error(err))
+	case err == nil && l.failing:
+		l.logger.Info("health Lease published again", zap.String("lease", l.name))
+	}
+	l.failing = err != nil
+}
+
+// Publish creates or renews the Lease with the current health report.
+func (l *HealthLease) Publish(ctx context.Context) error {
+	annotations, err := l.annotations()
+	if err != nil {
+		return err
+	}
+	now := metav1.NewMicroTime(l.now())
+	leases := l.clientset.CoordinationV1().Leases(l.namespace)
+
+	lease, err := leases.Get(ctx, l.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		durationSeconds := int32(3 * l.interval / time.Second)
+		lease = &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            l.name,
+				Namespace:       l.namespace,
+				Labels:          map[string]string{LabelHealthLease: "true"},
+				Annotations:     annotations,
+				OwnerReferences: podOwnerReferences(l.name, l.podUID),
+			},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       &l.name,
+				LeaseDurationSeconds: &durationSeconds,
+				AcquireTime:          &now,
+				RenewTime:            &now,
+			},
+		}
+		_, err = leases.Create(ctx, lease, metav1.CreateOptions{FieldManager: fieldManager})
+		if err != nil {
+			return fmt.Errorf("failed to create health Lease %s/%s: %w", l.namespace, l.name, err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get health Lease %s/%s: %w", l.namespace, l.name, err)
+	}
+
+	if lease.Annotations == nil {
+		lease.Annotations = make(map[string]string, len(annotations))
+	}
+	for key, value := range annotations {
+		lease.Annotations[key] = value
+	}
+	lease.Spec.RenewTime = &now
+	_, err = leases.Update(ctx, lease, metav1.UpdateOptions{FieldManager: fieldManager})
+	if err != nil {
+		return fmt.Errorf("failed to renew health Lease %s/%s: %w", l.namespace, l.name, err)
+	}
+	return nil
+}
+
+// Delete removes the Lease. A Lease that does not exist is not an error.
+func (l *HealthLease) Delete(ctx context.Context) error {
+	err := l.clientset.CoordinationV1().Leases(l.namespace).Delete(ctx, l.name, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// annotations renders the current health report
+func (l *HealthLease) annotations() (map[string]string, error) {
+	report := l.report()
+	health, err := json.Marshal(report.Health)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode health: %w", err)
+	}
+	stats, err := json.Marshal(report.Stats)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode stats: %w", err)
+	}
+	return map[string]string{
+		AnnotationHealthStatus:  report.Status,
+		AnnotationHealthVersion: report.Version,
+		AnnotationHealth:        string(health),
+		AnnotationHealthStats:   string(stats),
+	}, nil
+}
+
+// podOwnerReferences makes the pod the owner of a per-pod object, so it is garbage collected
+// with the pod. There is no owner when the pod's UID is unknown.
+func podOwnerReferences(name, uid string) []metav1.OwnerReference {
+	if uid == "" {
+		return nil
+	}
+	return []metav1.OwnerReference{{
+		APIVersion: "v1",
+		Kind:       "Pod",
+		Name:       name,
+		UID:        types.UID(uid),
+	}}
+}
@@ -0,0 +1,81 @@
+package k8s
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// TestHealthLease tests that the Lease is created, renewed with the latest report and deleted
+func TestHealthLease(t *testing.T) {
+	fakeClient := fake.NewSimpleClientset()
+	report := HealthReport{
+		Status:  "ok",
+		Version: "v1.4.0",
+		Health:  map[string]any{"ready": true},
+		Stats:   map[string]any{"auth": map[string]int{"allowed": 3}},
+	}
+	lease := NewHealthLease(fakeClient, "nats", "callout-7d9f-abcde", "pod-uid", 30*time.Second,
+		func() HealthReport { return report }, zap.NewNop())
+	created := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	lease.now = func() time.Time { return created }
+	ctx := context.Background()
+
+	if err := lease.Publish(ctx); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	got, err := fakeClient.CoordinationV1().Leases("nats").Get(ctx, "callout-7d9f-abcde", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Annotations[AnnotationHealthStatus] != "ok" || got.Annotations[AnnotationHealthVersion] != "v1.4.0" {
+		t.Errorf("annotations = %v, want status ok and version v1.4.0", got.Annotations)
+	}
+	var health map[string]any
+	if err := json.Unmarshal([]byte(got.Annotations[AnnotationHealth]), &health); err != nil || health["ready"] != true {
+		t.Errorf("%s = %q, want the health report", AnnotationHealth, got.Annotations[AnnotationHealth])
+	}
+	if got.Labels[LabelHealthLease] != "true" {
+		t.Errorf("labels = %v, want %s", got.Labels, LabelHealthLease)
+	}
+	if *got.Spec.HolderIdentity != "callout-7d9f-abcde" || *got.Spec.LeaseDurationSeconds != 90 {
+		t.Errorf("spec = %+v, want the pod as holder and a 90s duration", got.Spec)
+	}
+	if len(got.OwnerReferences) != 1 || got.OwnerReferences[0].Kind != "Pod" || got.OwnerReferences[0].UID != "pod-uid" {
+		t.Errorf("ownerReferences = %v, want the pod", got.OwnerReferences)
+	}
+
+	// Renewals update the report and renew time, keeping the acquire time
+	report.Status = "degraded"
+	renewed := created.Add(30 * time.Second)
+	lease.now = func() time.Time { return renewed }
+	if err := lease.Publish(ctx); err != nil {
+		t.Fatalf("Publish() renewal error = %v", err)
+	}
+	got, err = fakeClient.CoordinationV1().Leases("nats").Get(ctx, "callout-7d9f-abcde", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Annotations[AnnotationHealthStatus] != "degraded" {
+		t.Errorf("%s = %q, want degraded", AnnotationHealthStatus, got.Annotations[AnnotationHealthStatus])
+	}
+	if !got.Spec.RenewTime.Time.Equal(renewed) || !got.Spec.AcquireTime.Time.Equal(created) {
+		t.Errorf("renewTime = %v, acquireTime = %v, want %v and %v", got.Spec.RenewTime, got.Spec.AcquireTime, renewed, created)
+	}
+
+	if err := lease.Delete(ctx); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := fakeClient.CoordinationV1().Leases("nats").Get(ctx, "callout-7d9f-abcde", metav1.GetOptions{}); err == nil {
+		t.Error("expected the Lease to be deleted")
+	}
+	// Deleting again is not an error
+	if err := lease.Delete(ctx); err != nil {
+		t.Errorf("Delete() of a missing Lease error = %v", err)
+	}
+}
@@ -0,0 +1,144 @@
+package k8s
+
+import (
+	"hash/maphash"
+	"slices"
+	"strings"
+)
+
+// stringHeaderSize is the size of a string in a slice's backing array: a pointer and a
+// length, on 64-bit platforms
+const stringHeaderSize = 16
+
+// subjectTable shares identical subject lists, and the subjects in them, between cached
+// ServiceAccounts. Most ServiceAccounts get the same cluster, namespace and profile subjects,
+// so without sharing a cluster with tens of thousands of ServiceAccounts holds as many copies
+// of them. Lists are reference counted and forgotten once no cached ServiceAccount uses them.
+// Shared lists are clipped, so appending to one always copies it, and must not be modified.
+type subjectTable struct {
+	seed     maphash.Seed
+	lists    map[uint64][]*sharedList // key: hash of the subjects, colliding lists in one bucket
+	subjects map[string]*sharedSubject
+}
+
+type sharedList struct {
+	subjects []string
+	refs     int // cached permissions using the list
+}
+
+type sharedSubject struct {
+	subject string
+	refs    int // shared lists containing the subject
+}
+
+// StorageStats describes how the subjects of the cached permissions are stored. Bytes
+// counts subject data and list backing arrays, excluding the table itself; UnsharedBytes
+// is what the same lists would take stored separately for every ServiceAccount.
+type StorageStats struct {
+	Lists         int `json:"lists"`         // distinct subject lists stored
+	ListRefs      int `json:"listRefs"`      // subject lists used by cached permissions
+	Subjects      int `json:"subjects"`      // distinct subjects stored
+	Bytes         int `json:"bytes"`         // bytes stored with sharing
+	UnsharedBytes int `json:"unsharedBytes"` // bytes that would be stored without sharing
+}
+
+func newSubjectTable() *subjectTable {
+	return &subjectTable{
+		seed:     maphash.MakeSeed(),
+		lists:    make(map[uint64][]*sharedList),
+		subjects: make(map[string]*sharedSubject),
+	}
+}
+
+// hash hashes a subject list; subjects cannot contain the zero byte separating them
+func (t *subjectTable) hash(subjects []string) uint64 {
+	var h maphash.Hash
+	h.SetSeed(t.seed)
+	for _, subject := range subjects {
+		h.WriteString(subject)
+		h.WriteByte(0)
+	}
+	return h.Sum64()
+}
+
+// intern returns the shared copy of subjects, adding it if no cached permissions use an
+// identical list. Every call must be paired with a release once the list is no longer used.
+func (t *subjectTable) intern(subjects []string) []string {
+	if len(subjects) == 0 {
+		return subjects
+	}
+
+	key := t.hash(subjects)
+	for _, list := range t.lists[key] {
+		if slices.Equal(list.subjects, subjects) {
+			list.refs++
+			return list.subjects
+		}
+	}
+
+	// Subjects are often substrings of annotation values, so the table keeps its own copies
+	// rather than holding whole annotations in memory
+	shared := make([]string, len(subjects))
+	for i, subject := range subjects {
+		entry, found := t.subjects[subject]
+		if !found {
+			entry = &sharedSubject{subject: strings.Clone(subject)}
+			t.subjects[entry.subject] = entry
+		}
+		entry.refs++
+		shared[i] = entry.subject
+	}
+	t.lists[key] = append(t.lists[key], &sharedList{subjects: shared, refs: 1})
+	return shared
+}
+
+// release gives up one use of a list returned by intern
+func (t *subjectTable) release(subjects []string) {
+	if len(subjects) == 0 {
+		return
+	}
+
+	key := t.hash(subjects)
+	bucket := t.lists[key]
+	i := slices.IndexFunc(bucket, func(list *sharedList) bool { return slices.Equal(list.subjects, subjects) })
+	if i < 0 {
+		return
+	}
+	list := bucket[i]
+	if list.refs--; list.refs > 0 {
+		return
+	}
+
+	if bucket = slices.Delete(bucket, i, i+1); len(bucket) == 0 {
+		delete(t.lists, key)
+	} else {
+		t.lists[key] = bucket
+	}
+	for _, subject := range list.subjects {
+		entry := t.subjects[subject]
+		if entry.refs--; entry.refs == 0 {
+			delete(t.subjects, subject)
+		}
+	}
+}
+
+// stats measures the table
+func (t *subjectTable) stats() StorageStats {
+	stats := StorageStats{Subjects: len(t.subjects)}
+	for _, entry := range t.subjects {
+		stats.Bytes += len(entry.subject)
+	}
+	for _, bucket := range t.lists {
+		for _, list := range bucket {
+			size := len(list.subjects) * stringHeaderSize
+			stats.Bytes += size
+			for _, subject := range list.subjects {
+				size += len(subject)
+			}
+			stats.Lists++
+			stats.ListRefs += list.refs
+			stats.UnsharedBytes += list.refs * size
+		}
+	}
+	return stats
+}
@@ -0,0 +1,113 @@
+package k8s
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// AnnotationJSConsume is the annotation key listing JetStream streams the ServiceAccount may
+	// consume from, as "STREAM" (any consumer) or "STREAM/CONSUMER" (an existing durable consumer).
+	AnnotationJSConsume = "nats.io/js-consume"
+	// AnnotationJSPublish is the annotation key listing subjects the ServiceAccount may publish
+	// to JetStream streams on.
+	AnnotationJSPublish = "nats.io/js-publish"
+	// AnnotationJSAdmin is the annotation key that, set to "true", exempts the ServiceAccount
+	// from the denial of the destructive JetStream API operations.
+	AnnotationJSAdmin = "nats.io/js-admin"
+)
+
+// jsAPIInfo is the JetStream account info request, made by most clients when they start
+const jsAPIInfo = "$JS.API.INFO"
+
+// jetStreamSubjects expands the ServiceAccount's JetStream annotations into the publish
+// subjects they require. Deliveries and API replies arrive on inboxes, which are always
+// granted, so no subscribe subjects are needed. Invalid entries are ignored and reported.
+func (c *Cache) jetStreamSubjects(sa *corev1.ServiceAccount) []string {
+	var publish []string
+
+	for _, entry := range c.annotationSubjects(sa, AnnotationJSConsume) {
+		stream, consumer, bound := strings.Cut(entry, "/")
+		if err := validateJSName(stream); err != nil {
+			c.warn(sa, "InvalidAnnotation", fmt.Sprintf("annotation %s entry %q: stream %v; ignoring it", AnnotationJSConsume, entry, err))
+			continue
+		}
+		if bound {
+			if err := validateJSName(consumer); err != nil {
+				c.warn(sa, "InvalidAnnotation", fmt.Sprintf("annotation %s entry %q: consumer %v; ignoring it", AnnotationJSConsume, entry, err))
+				continue
+			}
+		}
+		publish = append(publish, jsConsumeSubjects(stream, consumer)...)
+	}
+
+	if subjects := c.annotationSubjects(sa, AnnotationJSPublish); len(subjects) > 0 {
+		publish = append(publish, jsAPIInfo)
+		publish = append(publish, subjects...)
+	}
+
+	return publish
+}
+
+// JetStreamAdmin reports whether a ServiceAccount is a JetStream administrator by the
+// nats.io/js-admin annotation
+func (c *Cache) JetStreamAdmin(namespace, name string) bool {
+	perms, found := c.lookup(namespace, name)
+	return found && perms.JSAdmin
+}
+
+// jetStreamAdmin parses the ServiceAccount's nats.io/js-admin annotation. As it lifts a
+// protection, only an explicit boolean true makes the ServiceAccount an administrator.
+func (c *Cache) jetStreamAdmin(sa *corev1.ServiceAccount) bool {
+	value, ok := sa.Annotations[AnnotationJSAdmin]
+	if !ok {
+		return false
+	}
+	admin, err := strconv.ParseBool(strings.TrimSpace(value))
+	if err != nil {
+		c.warn(sa, "InvalidAnnotation", fmt.Sprintf("annotation %s value %q is not a boolean; ignoring it", AnnotationJSAdmin, value))
+	}
+	return admin
+}
+
+// jsConsumeSubjects returns the publish subjects needed to consume from a stream. With a
+// consumer name, access is limited to binding to that existing durable consumer; without
+// one, the ServiceAccount may create and use consumers of its own.
+func jsConsumeSubjects(stream, consumer string) []string {
+	if consumer != "" {
+		return []string{
+			jsAPIInfo,
+			"$JS.API.STREAM.INFO." + stream,
+			"$JS.API.CONSUMER.INFO." + stream + "." + consumer,
+			"$JS.API.CONSUMER.MSG.NEXT." + stream + "." + consumer,
+			"$JS.ACK." + stream + "." + consumer + ".>",
+			"$JS.FC." + stream + ".>",
+		}
+	}
+	return []string{
+		jsAPIInfo,
+		"$JS.API.STREAM.INFO." + stream,
+		"$JS.API.CONSUMER.CREATE." + stream,
+		"$JS.API.CONSUMER.CREATE." + stream + ".>",
+		"$JS.API.CONSUMER.DURABLE.CREATE." + stream + ".*",
+		"$JS.API.CONSUMER.INFO." + stream + ".*",
+		"$JS.API.CONSUMER.MSG.NEXT." + stream + ".*",
+		"$JS.ACK." + stream + ".>",
+		"$JS.FC." + stream + ".>",
+	}
+}
+
+// validateJSName checks a stream or consumer name against the JetStream naming rules: it must
+// be non-empty and contain no whitespace, ".", "*", ">" or path separators
+func validateJSName(name string) error {
+	if name == "" {
+		return fmt.Errorf("name is empty")
+	}
+	if strings.ContainsAny(name, " \t\r\n.*>/\\") {
+		return fmt.Errorf("name %q must not contain whitespace, \".\", \"*\", \">\" or path separators", name)
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package k8s
+
+import (
+	"testing"
+
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TestCache_JetStream tests expansion of the JetStream annotations into publish permissions
+func TestCache_JetStream(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		wantPub     []string
+	}{
+		{
+			name:        "consume any consumer",
+			annotations: map[string]string{AnnotationJSConsume: "ORDERS"},
+			wantPub: []string{
+				"orders.>",
+				"$JS.API.INFO",
+				"$JS.API.STREAM.INFO.ORDERS",
+				"$JS.API.CONSUMER.CREATE.ORDERS",
+				"$JS.API.CONSUMER.CREATE.ORDERS.>",
+				"$JS.API.CONSUMER.DURABLE.CREATE.ORDERS.*",
+				"$JS.API.CONSUMER.INFO.ORDERS.*",
+				"$JS.API.CONSUMER.MSG.NEXT.ORDERS.*",
+				"$JS.ACK.ORDERS.>",
+				"$JS.FC.ORDERS.>",
+			},
+		},
+		{
+			name:        "consume durable consumer",
+			annotations: map[string]string{AnnotationJSConsume: "ORDERS/billing"},
+			wantPub: []string{
+				"orders.>",
+				"$JS.API.INFO",
+				"$JS.API.STREAM.INFO.ORDERS",
+				"$JS.API.CONSUMER.INFO.ORDERS.billing",
+				"$JS.API.CONSUMER.MSG.NEXT.ORDERS.billing",
+				"$JS.ACK.ORDERS.billing.>",
+				"$JS.FC.ORDERS.>",
+			},
+		},
+		{
+			name:        "publish",
+			annotations: map[string]string{AnnotationJSPublish: "events.orders.>"},
+			wantPub:     []string{"orders.>", "$JS.API.INFO", "events.orders.>"},
+		},
+		{
+			name:        "invalid names ignored",
+			annotations: map[string]string{AnnotationJSConsume: "ORDERS.v2, ORDERS/, */billing"},
+			wantPub:     []string{"orders.>"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := NewCache(zap.NewNop())
+			cache.Upsert(&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{
+				Name:        "sa",
+				Namespace:   "orders",
+				Annotations: tt.annotations,
+			}})
+
+			pub, sub, _ := cache.Get("orders", "sa")
+			if !equalStringSlices(pub, tt.wantPub) {
+				t.Errorf("pub = %v, want %v", pub, tt.wantPub)
+			}
+			if wantSub := []string{"_INBOX.>", "_INBOX_orders_sa.>", "orders.>"}; !equalStringSlices(sub, wantSub) {
+				t.Errorf("sub = %v, want %v", sub, wantSub)
+			}
+		})
+	}
+}
+
+// TestCache_JetStreamAdmin tests the nats.io/js-admin annotation
+func TestCache_JetStreamAdmin(t *testing.T) {
+	cache := NewCache(zap.NewNop())
+	tests := []struct {
+		value string
+		want  bool
+	}{
+		{value: "true", want: true},
+		{value: "false", want: false},
+		{value: "admin", want: false}, // not a boolean, ignored
+	}
+	for _, tt := range tests {
+		cache.Upsert(&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{
+			Name:        "stream-manager",
+			Namespace:   "platform",
+			Annotations: map[string]string{AnnotationJSAdmin: tt.value},
+		}})
+		if got := cache.JetStreamAdmin("platform", "stream-manager"); got != tt.want {
+			t.Errorf("JetStreamAdmin() with %q = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+
+	if cache.JetStreamAdmin("platform", "unknown") {
+		t.Error("expected JetStreamAdmin() = false for unknown ServiceAccount")
+	}
+}
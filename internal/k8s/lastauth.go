@@ -0,0 +1,53 @@
+package k8s
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// AnnotationLastAuthenticated is the annotation key the service writes, when enabled, with
+// the time a ServiceAccount last authenticated to NATS (RFC 3339, UTC).
+const AnnotationLastAuthenticated = "nats.io/last-authenticated"
+
+// fieldManager identifies the service's changes to ServiceAccounts
+const fieldManager = "nats-k8s-oidc-callout"
+
+// LastAuthAnnotator writes the nats.io/last-authenticated annotation onto ServiceAccounts
+type LastAuthAnnotator struct {
+	clientset kubernetes.Interface
+}
+
+// NewLastAuthAnnotator creates an annotator patching ServiceAccounts through clientset
+func NewLastAuthAnnotator(clientset kubernetes.Interface) *LastAuthAnnotator {
+	return &LastAuthAnnotator{clientset: clientset}
+}
+
+// AnnotateLastAuthenticated sets a ServiceAccount's nats.io/last-authenticated annotation to
+// at. A ServiceAccount deleted since it authenticated is skipped.
+func (a *LastAuthAnnotator) AnnotateLastAuthenticated(ctx context.Context, namespace, name string, at time.Time) error {
+	patch, err := json.Marshal(map[string]any{
+		"metadata": map[string]any{
+			"annotations": map[string]string{AnnotationLastAuthenticated: at.UTC().Format(time.RFC3339)},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = a.clientset.CoreV1().ServiceAccounts(namespace).Patch(ctx, name, types.MergePatchType, patch,
+		metav1.PatchOptions{FieldManager: fieldManager})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to annotate ServiceAccount %s/%s: %w", namespace, name, err)
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// TestLastAuthAnnotator tests that the last authentication is merged into the annotations
+func TestLastAuthAnnotator(t *testing.T) {
+	fakeClient := fake.NewSimpleClientset(&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{
+		Name:        "api",
+		Namespace:   "orders",
+		Annotations: map[string]string{AnnotationAllowedPubSubjects: "shared.>"},
+	}})
+	annotator := NewLastAuthAnnotator(fakeClient)
+	ctx := context.Background()
+
+	at := time.Date(2026, 3, 1, 12, 30, 0, 0, time.FixedZone("CET", 3600))
+	if err := annotator.AnnotateLastAuthenticated(ctx, "orders", "api", at); err != nil {
+		t.Fatalf("AnnotateLastAuthenticated() error = %v", err)
+	}
+	sa, err := fakeClient.CoreV1().ServiceAccounts("orders").Get(ctx, "api", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := sa.Annotations[AnnotationLastAuthenticated]; got != "2026-03-01T11:30:00Z" {
+		t.Errorf("%s = %q, want the UTC time", AnnotationLastAuthenticated, got)
+	}
+	if sa.Annotations[AnnotationAllowedPubSubjects] != "shared.>" {
+		t.Errorf("annotations = %v, want the existing annotations kept", sa.Annotations)
+	}
+
+	// A ServiceAccount deleted since it authenticated is skipped
+	if err := annotator.AnnotateLastAuthenticated(ctx, "orders", "deleted", at); err != nil {
+		t.Errorf("AnnotateLastAuthenticated() of a deleted ServiceAccount error = %v", err)
+	}
+}
+
+// TestLastAuthRelay tests that the latest authentication relayed by any replica is collected
+func TestLastAuthRelay(t *testing.T) {
+	fakeClient := fake.NewSimpleClientset()
+	ctx := context.Background()
+	earlier := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	later := earlier.Add(time.Hour)
+
+	first := NewLastAuthRelay(fakeClient, "nats", "callout-0", "pod-uid")
+	second := NewLastAuthRelay(fakeClient, "nats", "callout-1", "")
+	if err := first.Publish(ctx, map[string]time.Time{"orders/api": earlier, "billing/worker": later}); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	if err := second.Publish(ctx, map[string]time.Time{"orders/api": earlier}); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	// Publishing again replaces the replica's authentications
+	if err := second.Publish(ctx, map[string]time.Time{"orders/api": later}); err != nil {
+		t.Fatalf("Publish() update error = %v", err)
+	}
+
+	got, err := first.Collect(ctx)
+	if err != nil {
+		t.Fatalf("Collect() error = %v", err)
+	}
+	if len(got) != 2 || !got["orders/api"].Equal(later) || !got["billing/worker"].Equal(later) {
+		t.Errorf("Collect() = %v, want the latest authentication of both", got)
+	}
+}
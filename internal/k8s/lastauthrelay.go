@@ -0,0 +1,109 @@
+package k8s
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// LabelLastAuthRelay marks the Leases relaying each replica's authentications to the leader
+const LabelLastAuthRelay = "nats.io/last-auth-relay"
+
+// LastAuthRelay shares the ServiceAccount authentications seen by each replica with the
+// leader annotating ServiceAccounts, through a Lease per pod holding them as JSON in the
+// nats.io/last-authenticated annotation. Authentications are keyed by namespace/name.
+type LastAuthRelay struct {
+	clientset kubernetes.Interface
+	namespace string
+	name      string
+	podName   string
+	podUID    string
+}
+
+// NewLastAuthRelay creates the relay of the pod podName in namespace. podUID may be empty.
+func NewLastAuthRelay(clientset kubernetes.Interface, namespace, podName, podUID string) *LastAuthRelay {
+	return &LastAuthRelay{
+		clientset: clientset,
+		namespace: namespace,
+		name:      podName + "-last-auth",
+		podName:   podName,
+		podUID:    podUID,
+	}
+}
+
+// Publish replaces the authentications relayed by this replica
+func (r *LastAuthRelay) Publish(ctx context.Context, accounts map[string]time.Time) error {
+	data, err := json.Marshal(accounts)
+	if err != nil {
+		return err
+	}
+	now := metav1.NewMicroTime(time.Now())
+	leases := r.clientset.CoordinationV1().Leases(r.namespace)
+
+	lease, err := leases.Get(ctx, r.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		lease = &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            r.name,
+				Namespace:       r.namespace,
+				Labels:          map[string]string{LabelLastAuthRelay: "true"},
+				Annotations:     map[string]string{AnnotationLastAuthenticated: string(data)},
+				OwnerReferences: podOwnerReferences(r.podName, r.podUID),
+			},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity: &r.podName,
+				RenewTime:      &now,
+			},
+		}
+		_, err = leases.Create(ctx, lease, metav1.CreateOptions{FieldManager: fieldManager})
+		if err != nil {
+			return fmt.Errorf("failed to create last authentication relay %s/%s: %w", r.namespace, r.name, err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get last authentication relay %s/%s: %w", r.namespace, r.name, err)
+	}
+
+	if lease.Annotations == nil {
+		lease.Annotations = make(map[string]string, 1)
+	}
+	lease.Annotations[AnnotationLastAuthenticated] = string(data)
+	lease.Spec.RenewTime = &now
+	_, err = leases.Update(ctx, lease, metav1.UpdateOptions{FieldManager: fieldManager})
+	if err != nil {
+		return fmt.Errorf("failed to update last authentication relay %s/%s: %w", r.namespace, r.name, err)
+	}
+	return nil
+}
+
+// Collect returns the latest authentication of each ServiceAccount relayed by any replica.
+// Relays that cannot be decoded are skipped.
+func (r *LastAuthRelay) Collect(ctx context.Context) (map[string]time.Time, error) {
+	list, err := r.clientset.CoordinationV1().Leases(r.namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: LabelLastAuthRelay + "=true",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list last authentication relays in %s: %w", r.namespace, err)
+	}
+
+	merged := make(map[string]time.Time)
+	for _, lease := range list.Items {
+		var accounts map[string]time.Time
+		if err := json.Unmarshal([]byte(lease.Annotations[AnnotationLastAuthenticated]), &accounts); err != nil {
+			continue
+		}
+		for key, at := range accounts {
+			if at.After(merged[key]) {
+				merged[key] = at
+			}
+		}
+	}
+	return merged, nil
+}
@@ -0,0 +1,178 @@
+package k8s
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+
+	httpmetrics "github.com/portswigger-tim/nats-k8s-oidc-callout/internal/httpserver"
+)
+
+// LeaderStatus describes the leader election, for /debug/stats
+type LeaderStatus struct {
+	Identity string   `json:"identity"`
+	Leader   string   `json:"leader"` // identity of the current leader, empty while unknown
+	Leading  bool     `json:"leading"`
+	Tasks    []string `json:"tasks"`
+}
+
+// singletonTask is a subsystem run only by the leader
+type singletonTask struct {
+	name string
+	run  func(ctx context.Context)
+}
+
+// LeaderElector runs the subsystems that must run once per fleet, such as the ServiceAccount
+// annotator, on the replica holding a coordination.k8s.io Lease. Authorization itself is
+// unaffected and keeps being shared by every replica through the NATS queue group.
+type LeaderElector struct {
+	clientset     kubernetes.Interface
+	namespace     string
+	name          string
+	identity      string
+	leaseDuration time.Duration
+	logger        *zap.Logger
+
+	mu      sync.Mutex
+	tasks   []singletonTask
+	leader  string
+	leading bool
+	term    context.Context // cancelled when the current term ends; nil while not leading
+	running sync.WaitGroup  // tasks of the current term
+}
+
+// NewLeaderElector creates an elector contending for the Lease name in namespace as identity
+// (the pod name). A leader that fails to renew the Lease for leaseDuration loses it.
+func NewLeaderElector(clientset kubernetes.Interface, namespace, name, identity string, leaseDuration time.Duration,
+	logger *zap.Logger) *LeaderElector {
+	return &LeaderElector{
+		clientset:     clientset,
+		namespace:     namespace,
+		name:          name,
+		identity:      identity,
+		leaseDuration: leaseDuration,
+		logger:        logger,
+	}
+}
+
+// Add registers a subsystem run while the instance leads. Its context is cancelled when
+// leadership is lost or the elector stops. A task added while leading starts at once.
+func (e *LeaderElector) Add(name string, run func(ctx context.Context)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	task := singletonTask{name: name, run: run}
+	e.tasks = append(e.tasks, task)
+	if e.leading {
+		e.start(task)
+	}
+}
+
+// Run contends for leadership until the context is cancelled, running the tasks whenever
+// the instance leads. The Lease is released on cancellation, so another replica takes over
+// without waiting for it to expire; Run returns once the tasks have stopped.
+func (e *LeaderElector) Run(ctx context.Context) {
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta:  metav1.ObjectMeta{Name: e.name, Namespace: e.namespace},
+		Client:     e.clientset.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: e.identity},
+	}
+	config := leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		Name:            e.name,
+		LeaseDuration:   e.leaseDuration,
+		RenewDeadline:   e.leaseDuration * 2 / 3,
+		RetryPeriod:     e.leaseDuration / 5,
+		ReleaseOnCancel: true,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: e.lead,
+			OnStoppedLeading: e.stopLeading,
+			OnNewLeader:      e.newLeader,
+		},
+	}
+
+	// RunOrDie returns when leadership is lost; contend again until stopped
+	for ctx.Err() == nil {
+		leaderelection.RunOrDie(ctx, config)
+		// The tasks of the last term must stop before another can start. The term's context
+		// is cancelled by now, so a late lead cannot start them after the lock is released.
+		e.mu.Lock()
+		e.mu.Unlock() //nolint:staticcheck // empty critical section orders Wait after a concurrent lead
+		e.running.Wait()
+	}
+}
+
+// Leading reports whether the instance is the leader
+func (e *LeaderElector) Leading() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.leading
+}
+
+// Status returns the state of the election
+func (e *LeaderElector) Status() LeaderStatus {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	tasks := make([]string, 0, len(e.tasks))
+	for _, task := range e.tasks {
+		tasks = append(tasks, task.name)
+	}
+	return LeaderStatus{Identity: e.identity, Leader: e.leader, Leading: e.leading, Tasks: tasks}
+}
+
+// lead starts the tasks for a term that lasts until ctx is cancelled
+func (e *LeaderElector) lead(ctx context.Context) {
+	e.mu.Lock()
+	if ctx.Err() != nil {
+		// Leadership was lost before the callback ran
+		e.mu.Unlock()
+		return
+	}
+	e.leading = true
+	e.term = ctx
+	for _, task := range e.tasks {
+		e.start(task)
+	}
+	tasks := len(e.tasks)
+	e.mu.Unlock()
+
+	httpmetrics.SetLeader(true)
+	e.logger.Info("elected leader; starting singleton subsystems", zap.String("lease", e.name),
+		zap.Int("tasks", tasks))
+}
+
+// start runs a task for the current term. The lock must be held.
+func (e *LeaderElector) start(task singletonTask) {
+	ctx := e.term
+	e.running.Go(func() { task.run(ctx) })
+}
+
+// stopLeading records the loss of leadership
+func (e *LeaderElector) stopLeading() {
+	e.mu.Lock()
+	wasLeading := e.leading
+	e.leading = false
+	e.term = nil
+	e.mu.Unlock()
+
+	httpmetrics.SetLeader(false)
+	if wasLeading {
+		e.logger.Info("no longer leader; stopping singleton subsystems", zap.String("lease", e.name))
+	}
+}
+
+// newLeader records the identity of the current leader
+func (e *LeaderElector) newLeader(identity string) {
+	e.mu.Lock()
+	e.leader = identity
+	e.mu.Unlock()
+
+	if identity != e.identity {
+		e.logger.Info("leader elected", zap.String("lease", e.name), zap.String("leader", identity))
+	}
+}
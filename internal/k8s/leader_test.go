@@ -0,0 +1,81 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// TestLeaderElector tests that the tasks run while leading and the Lease is released on stop
+func TestLeaderElector(t *testing.T) {
+	fakeClient := fake.NewSimpleClientset()
+	elector := NewLeaderElector(fakeClient, "nats", "callout-leader", "callout-0", time.Second, zap.NewNop())
+
+	started := make(chan struct{})
+	stopped := make(chan struct{})
+	elector.Add("annotator", func(ctx context.Context) {
+		close(started)
+		<-ctx.Done()
+		close(stopped)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		elector.Run(ctx)
+		close(done)
+	}()
+
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("task not started after acquiring the Lease")
+	}
+	if !elector.Leading() {
+		t.Error("Leading() = false while the task runs")
+	}
+
+	// Tasks added while leading start at once
+	lateStarted := make(chan struct{})
+	elector.Add("publisher", func(ctx context.Context) {
+		close(lateStarted)
+		<-ctx.Done()
+	})
+	select {
+	case <-lateStarted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("task added while leading not started")
+	}
+	status := elector.Status()
+	if !status.Leading || len(status.Tasks) != 2 || status.Tasks[0] != "annotator" {
+		t.Errorf("Status() = %+v, want leading the annotator and publisher", status)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run() did not return after cancellation")
+	}
+	select {
+	case <-stopped:
+	default:
+		t.Error("task still running after Run() returned")
+	}
+	if elector.Leading() {
+		t.Error("Leading() = true after stopping")
+	}
+
+	// The Lease is released for another replica to take over
+	lease, err := fakeClient.CoordinationV1().Leases("nats").Get(context.Background(), "callout-leader", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lease.Spec.HolderIdentity != nil && *lease.Spec.HolderIdentity != "" {
+		t.Errorf("holderIdentity = %q, want the Lease released", *lease.Spec.HolderIdentity)
+	}
+}
@@ -0,0 +1,108 @@
+package k8s
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	httpmetrics "github.com/portswigger-tim/nats-k8s-oidc-callout/internal/httpserver"
+)
+
+// ConnectionMonitor tracks whether the Kubernetes API is reachable. Failures are reported
+// by the informer's watch error handler and by a periodic probe; once the API has been
+// failing for longer than the degraded threshold the instance is marked degraded. The
+// cache keeps serving the last known permissions throughout.
+type ConnectionMonitor struct {
+	mu            sync.RWMutex
+	failingSince  time.Time
+	lastError     error
+	degradedAfter time.Duration
+	now           func() time.Time
+	logger        *zap.Logger
+}
+
+// NewConnectionMonitor creates a monitor that reports degraded after the API has been
+// unreachable for degradedAfter.
+func NewConnectionMonitor(degradedAfter time.Duration, logger *zap.Logger) *ConnectionMonitor {
+	return &ConnectionMonitor{
+		degradedAfter: degradedAfter,
+		now:           time.Now,
+		logger:        logger,
+	}
+}
+
+// RecordError records a failed interaction with the Kubernetes API.
+func (m *ConnectionMonitor) RecordError(err error) {
+	m.mu.Lock()
+	if m.failingSince.IsZero() {
+		m.failingSince = m.now()
+		m.logger.Warn("Kubernetes API unreachable; serving ServiceAccount permissions from cache",
+			zap.Error(err))
+	}
+	m.lastError = err
+	m.mu.Unlock()
+
+	httpmetrics.IncrementK8sAPIErrors()
+	m.updateMetrics()
+}
+
+// RecordSuccess records a successful interaction with the Kubernetes API.
+func (m *ConnectionMonitor) RecordSuccess() {
+	m.mu.Lock()
+	if !m.failingSince.IsZero() {
+		m.logger.Info("Kubernetes API reachable again",
+			zap.Duration("outage", m.now().Sub(m.failingSince)))
+	}
+	m.failingSince = time.Time{}
+	m.lastError = nil
+	m.mu.Unlock()
+
+	m.updateMetrics()
+}
+
+// Degraded reports whether the API has been failing for longer than the threshold,
+// with a description of the failure.
+func (m *ConnectionMonitor) Degraded() (bool, string) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.failingSince.IsZero() {
+		return false, ""
+	}
+	failingFor := m.now().Sub(m.failingSince)
+	if failingFor < m.degradedAfter {
+		return false, ""
+	}
+	return true, "Kubernetes API unreachable for " + failingFor.Truncate(time.Second).String() +
+		", permissions may be stale: " + m.lastError.Error()
+}
+
+// Run probes the API every interval until ctx is cancelled.
+func (m *ConnectionMonitor) Run(ctx context.Context, interval time.Duration, probe func(context.Context) error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			probeCtx, cancel := context.WithTimeout(ctx, interval)
+			err := probe(probeCtx)
+			cancel()
+			if err != nil {
+				m.RecordError(err)
+			} else {
+				m.RecordSuccess()
+			}
+		}
+	}
+}
+
+// updateMetrics publishes the current degraded state
+func (m *ConnectionMonitor) updateMetrics() {
+	degraded, _ := m.Degraded()
+	httpmetrics.SetK8sDegraded(degraded)
+}
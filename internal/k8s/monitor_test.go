@@ -0,0 +1,43 @@
+package k8s
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestConnectionMonitor_Degraded(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	m := NewConnectionMonitor(time.Minute, zap.NewNop())
+	m.now = func() time.Time { return now }
+
+	if degraded, _ := m.Degraded(); degraded {
+		t.Fatal("expected healthy monitor initially")
+	}
+
+	// Short outages are tolerated
+	m.RecordError(errors.New("connection refused"))
+	now = now.Add(30 * time.Second)
+	if degraded, _ := m.Degraded(); degraded {
+		t.Error("expected not degraded before threshold")
+	}
+
+	// Repeated errors do not reset the outage start
+	m.RecordError(errors.New("connection refused"))
+	now = now.Add(45 * time.Second)
+	degraded, message := m.Degraded()
+	if !degraded {
+		t.Fatal("expected degraded after threshold")
+	}
+	if message == "" {
+		t.Error("expected degraded message")
+	}
+
+	// Recovery clears the degraded state
+	m.RecordSuccess()
+	if degraded, _ := m.Degraded(); degraded {
+		t.Error("expected not degraded after success")
+	}
+}
@@ -0,0 +1,67 @@
+package k8s
+
+import (
+	"fmt"
+
+	httpmetrics "github.com/portswigger-tim/nats-k8s-oidc-callout/internal/httpserver"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+)
+
+// NodeSelector returns the node labels a ServiceAccount's pods must run on, required by the
+// profile it selects, or nil for any node
+func (c *Cache) NodeSelector(namespace, name string) map[string]string {
+	perms, found := c.lookup(namespace, name)
+	if !found {
+		return nil
+	}
+	return perms.NodeSelector
+}
+
+// UsesNodeSelector reports whether any profile restricts its ServiceAccounts to labelled nodes
+func (p *Policy) UsesNodeSelector() bool {
+	for _, profile := range p.Profiles {
+		if len(profile.NodeSelector) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// WatchNodes also watches nodes from factory, so that tokens can be restricted to pods on nodes
+// with given labels (see NodeLabels). It must be called before the informers are started.
+func (c *Client) WatchNodes(factory informers.SharedInformerFactory) error {
+	informer := factory.Core().V1().Nodes().Informer()
+	if err := informer.SetWatchErrorHandler(func(_ *cache.Reflector, err error) {
+		c.logger.Warn("node watch failed", zap.Error(err))
+		httpmetrics.IncrementK8sWatchErrors(resourceNode)
+	}); err != nil {
+		return fmt.Errorf("failed to register node watch error handler: %w", err)
+	}
+	c.nodeInformer = informer
+	return nil
+}
+
+// NodeSelector returns the node labels a ServiceAccount's pods must run on, or nil for any node
+func (c *Client) NodeSelector(namespace, name string) map[string]string {
+	return c.cache.NodeSelector(namespace, name)
+}
+
+// NodeLabels returns the labels of a node, and whether it is known. No node is known unless
+// nodes are watched, so node restrictions fail closed.
+func (c *Client) NodeLabels(name string) (map[string]string, bool) {
+	if c.nodeInformer == nil {
+		return nil, false
+	}
+	obj, exists, err := c.nodeInformer.GetStore().GetByKey(name)
+	if err != nil || !exists {
+		return nil, false
+	}
+	node, ok := obj.(*corev1.Node)
+	if !ok {
+		return nil, false
+	}
+	return node.Labels, true
+}
@@ -0,0 +1,337 @@
+package k8s
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/portswigger-tim/nats-k8s-oidc-callout/internal/natssubject"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// AnnotationProfile is the annotation key selecting a permission profile from the policy file.
+	AnnotationProfile = "nats.io/profile"
+	// AnnotationStrategy is the annotation key for how a namespace's or ServiceAccount's
+	// subjects combine with the levels before it ("merge" or "replace").
+	AnnotationStrategy = "nats.io/permissions-strategy"
+)
+
+// Strategy controls how a level of the permission chain combines with the levels before it
+type Strategy string
+
+// Merge strategies
+const (
+	// StrategyMerge adds the level's subjects to those inherited (the default)
+	StrategyMerge Strategy = "merge"
+	// StrategyReplace discards the inherited subjects in favour of the level's own
+	StrategyReplace Strategy = "replace"
+)
+
+// Layer is one level of the permission chain: cluster defaults, namespace annotations,
+// profile or ServiceAccount annotations, applied in that order
+type Layer struct {
+	Publish   []string `json:"publish,omitempty"`
+	Subscribe []string `json:"subscribe,omitempty"`
+	Strategy  Strategy `json:"strategy,omitempty"`
+	// NodeSelector restricts the ServiceAccounts selecting a profile to pods on nodes with
+	// these labels (profiles only)
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+}
+
+// Policy holds the cluster-wide levels of the permission chain
+type Policy struct {
+	// Defaults are granted to every ServiceAccount unless a later level replaces them
+	Defaults Layer `json:"defaults"`
+	// Profiles are named layers selected with the nats.io/profile annotation
+	Profiles map[string]Layer `json:"profiles"`
+
+	source   string              // file the policy was loaded from
+	loadedAt time.Time           // when the policy was loaded
+	invalid  map[string][]string // malformed subjects dropped at load, by layer
+}
+
+// PolicyStatus reports whether the permission policy is in effect: when it was loaded, how many
+// cached ServiceAccounts each profile applies to, and the malformed subjects dropped from it.
+// Profiles that no ServiceAccount selects are listed with zero, and profiles that
+// ServiceAccounts select but the policy does not define are listed separately.
+type PolicyStatus struct {
+	Source          string         `json:"source"`
+	LoadedAt        time.Time      `json:"loadedAt"`
+	ServiceAccounts int            `json:"serviceAccounts"` // ServiceAccounts the defaults apply to
+	Profiles        map[string]int `json:"profiles"`
+	UnknownProfiles map[string]int `json:"unknownProfiles,omitempty"`
+	// InvalidSubjects are the malformed subjects dropped from the policy, by layer ("defaults"
+	// or "profiles/<name>")
+	InvalidSubjects map[string][]string `json:"invalidSubjects,omitempty"`
+}
+
+// LoadPolicyFile reads the cluster defaults and profiles of the permission chain from a YAML file:
+//
+//	defaults:
+//	  subscribe: ["platform.announcements"]
+//	profiles:
+//	  metrics-reader:
+//	    strategy: replace
+//	    subscribe: ["metrics.>"]
+//	  payments:
+//	    publish: ["payments.>"]
+//	    nodeSelector: {trusted: "true"}
+//
+// NATS internal subjects fail the load. Malformed subjects, such as "orders..created", are
+// dropped and reported by InvalidSubjects, so the rest of the policy still applies.
+func LoadPolicyFile(path string) (*Policy, error) {
+	data, err := os.ReadFile(path) //nolint:gosec // path comes from configuration
+	if err != nil {
+		return nil, fmt.Errorf("failed to read permission policy file: %w", err)
+	}
+
+	policy := &Policy{}
+	if err := yaml.UnmarshalStrict(data, policy); err != nil {
+		return nil, fmt.Errorf("failed to parse permission policy file: %w", err)
+	}
+
+	if err := policy.Defaults.validate(); err != nil {
+		return nil, fmt.Errorf("defaults: %w", err)
+	}
+	if len(policy.Defaults.NodeSelector) > 0 {
+		return nil, fmt.Errorf("defaults: nodeSelector is only supported on profiles")
+	}
+	for name, profile := range policy.Profiles {
+		if err := profile.validate(); err != nil {
+			return nil, fmt.Errorf("profile %q: %w", name, err)
+		}
+	}
+
+	var dropped []string
+	if policy.Defaults, dropped = policy.Defaults.dropInvalid(); len(dropped) > 0 {
+		policy.addInvalid("defaults", dropped)
+	}
+	for name, profile := range policy.Profiles {
+		if policy.Profiles[name], dropped = profile.dropInvalid(); len(dropped) > 0 {
+			policy.addInvalid("profiles/"+name, dropped)
+		}
+	}
+	policy.source, policy.loadedAt = path, time.Now()
+	return policy, nil
+}
+
+// InvalidSubjects returns the malformed subjects dropped from the policy, by layer ("defaults"
+// or "profiles/<name>")
+func (p *Policy) InvalidSubjects() map[string][]string {
+	return p.invalid
+}
+
+// addInvalid records the malformed subjects dropped from a layer
+func (p *Policy) addInvalid(layer string, subjects []string) {
+	if p.invalid == nil {
+		p.invalid = make(map[string][]string)
+	}
+	p.invalid[layer] = subjects
+}
+
+// PolicyStatus reports the status of the permission policy, or nil when none is set
+func (c *Cache) PolicyStatus() *PolicyStatus {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.policy == nil {
+		return nil
+	}
+	status := &PolicyStatus{
+		Source:          c.policy.source,
+		LoadedAt:        c.policy.loadedAt,
+		ServiceAccounts: c.view.Load().size,
+		Profiles:        make(map[string]int, len(c.policy.Profiles)),
+		InvalidSubjects: c.policy.invalid,
+	}
+	for name := range c.policy.Profiles {
+		status.Profiles[name] = 0
+	}
+	c.view.Load().each(func(_ string, perms *Permissions) {
+		if perms.Profile == "" {
+			return
+		}
+		if _, found := c.policy.Profiles[perms.Profile]; found {
+			status.Profiles[perms.Profile]++
+			return
+		}
+		if status.UnknownProfiles == nil {
+			status.UnknownProfiles = make(map[string]int)
+		}
+		status.UnknownProfiles[perms.Profile]++
+	})
+	return status
+}
+
+// validate checks a layer's strategy and rejects NATS internal subjects, which are managed automatically
+func (l Layer) validate() error {
+	if _, err := parseStrategy(string(l.Strategy)); err != nil {
+		return err
+	}
+	for _, subject := range append(append([]string{}, l.Publish...), l.Subscribe...) {
+		if isInternalSubject(subject) {
+			return fmt.Errorf("subject %q is managed automatically and cannot be granted", subject)
+		}
+	}
+	return nil
+}
+
+// dropInvalid returns the layer without its malformed subjects, and the subjects dropped
+func (l Layer) dropInvalid() (Layer, []string) {
+	var dropped []string
+	keep := func(subjects []string) []string {
+		valid := make([]string, 0, len(subjects))
+		for _, subject := range subjects {
+			if natssubject.Valid(subject) {
+				valid = append(valid, subject)
+			} else {
+				dropped = append(dropped, subject)
+			}
+		}
+		return valid
+	}
+	l.Publish, l.Subscribe = keep(l.Publish), keep(l.Subscribe)
+	return l, dropped
+}
+
+// parseStrategy parses a merge strategy, defaulting to merge when empty
+func parseStrategy(value string) (Strategy, error) {
+	switch Strategy(value) {
+	case "", StrategyMerge:
+		return StrategyMerge, nil
+	case StrategyReplace:
+		return StrategyReplace, nil
+	default:
+		return "", fmt.Errorf("unknown permissions strategy %q (want %s or %s)", value, StrategyMerge, StrategyReplace)
+	}
+}
+
+// mergeLayers applies the layers in order, each adding to or replacing the subjects before it
+func mergeLayers(layers ...Layer) (publish, subscribe []string) {
+	for _, layer := range layers {
+		if layer.Strategy == StrategyReplace {
+			publish, subscribe = nil, nil
+		}
+		publish = append(publish, layer.Publish...)
+		subscribe = append(subscribe, layer.Subscribe...)
+	}
+	return publish, subscribe
+}
+
+// layersEqual reports whether two layers grant the same subjects with the same strategy
+func layersEqual(a, b Layer) bool {
+	return a.Strategy == b.Strategy && slices.Equal(a.Publish, b.Publish) && slices.Equal(a.Subscribe, b.Subscribe)
+}
+
+// namespaceLayer builds a namespace's level of the permission chain from its annotations.
+// Problems are logged; an invalid strategy falls back to merge.
+func (c *Cache) namespaceLayer(ns *corev1.Namespace) Layer {
+	layer := Layer{
+		Publish:   c.namespaceSubjects(ns, AnnotationAllowedPubSubjects),
+		Subscribe: c.namespaceSubjects(ns, AnnotationAllowedSubSubjects),
+	}
+
+	strategy, err := parseStrategy(ns.Annotations[AnnotationStrategy])
+	if err != nil {
+		c.logger.Warn("Problem with namespace annotations",
+			zap.String("namespace", ns.Name),
+			zap.Error(err))
+		strategy = StrategyMerge
+	}
+	layer.Strategy = strategy
+	return layer
+}
+
+// namespaceSubjects returns the subjects granted by a namespace annotation, with NATS
+// internal subjects filtered out and the configured limits applied
+func (c *Cache) namespaceSubjects(ns *corev1.Namespace, key string) []string {
+	value, ok := ns.Annotations[key]
+	if !ok {
+		return nil
+	}
+
+	if c.limits.MaxAnnotationLength > 0 && len(value) > c.limits.MaxAnnotationLength {
+		c.logger.Warn("Namespace annotation exceeds limit; ignoring it",
+			zap.String("namespace", ns.Name),
+			zap.String("annotation", key),
+			zap.String("limit", LimitAnnotationLength))
+		return nil
+	}
+
+	subjects, filtered := parseSubjects(value)
+	if len(filtered) > 0 {
+		c.logger.Warn("Filtered NATS internal subjects from namespace annotation",
+			zap.String("namespace", ns.Name),
+			zap.String("annotation", key),
+			zap.Strings("filtered", filtered))
+	}
+
+	if c.limits.MaxSubjects > 0 && len(subjects) > c.limits.MaxSubjects {
+		c.logger.Warn("Namespace annotation exceeds limit; ignoring extra subjects",
+			zap.String("namespace", ns.Name),
+			zap.String("annotation", key),
+			zap.String("limit", LimitSubjectCount),
+			zap.Strings("ignored", subjects[c.limits.MaxSubjects:]))
+		subjects = subjects[:c.limits.MaxSubjects]
+	}
+	return subjects
+}
+
+// nodeSelector returns the node labels required by the profile a ServiceAccount selects, if
+// any. Must be called with the cache lock held.
+func (c *Cache) nodeSelector(sa *corev1.ServiceAccount) map[string]string {
+	name, ok := sa.Annotations[AnnotationProfile]
+	if !ok || c.policy == nil {
+		return nil
+	}
+	return c.policy.Profiles[name].NodeSelector
+}
+
+// layers returns the permission chain for a ServiceAccount: cluster defaults, namespace
+// annotations, profile and the ServiceAccount's own annotations, including its JetStream
+// grants. Must be called with the cache lock held.
+func (c *Cache) layers(sa *corev1.ServiceAccount) []Layer {
+	var layers []Layer
+	if c.policy != nil {
+		layers = append(layers, c.policy.Defaults)
+	}
+	if layer, ok := c.nsLayers[sa.Namespace]; ok {
+		layers = append(layers, c.prefixLayer(sa.Namespace, layer))
+	}
+
+	if name, ok := sa.Annotations[AnnotationProfile]; ok {
+		profile, found := Layer{}, false
+		if c.policy != nil {
+			profile, found = c.policy.Profiles[name]
+		}
+		if found {
+			layers = append(layers, profile)
+		} else {
+			c.warn(sa, "UnknownProfile", fmt.Sprintf("annotation %s: profile %q is not defined; ignoring it", AnnotationProfile, name))
+		}
+	}
+
+	strategy, err := parseStrategy(sa.Annotations[AnnotationStrategy])
+	if err != nil {
+		c.warn(sa, "InvalidAnnotation", fmt.Sprintf("annotation %s: %v; merging", AnnotationStrategy, err))
+		strategy = StrategyMerge
+	}
+	layers = append(layers, c.prefixLayer(sa.Namespace, Layer{
+		Publish:   append(c.annotationSubjects(sa, AnnotationAllowedPubSubjects), c.jetStreamSubjects(sa)...),
+		Subscribe: c.annotationSubjects(sa, AnnotationAllowedSubSubjects),
+		Strategy:  strategy,
+	}))
+	return layers
+}
+
+// prefixLayer applies the configured subject prefix to a layer built from annotations. Must be
+// called with the cache lock held.
+func (c *Cache) prefixLayer(namespace string, layer Layer) Layer {
+	layer.Publish = prefixSubjects(c.prefix, namespace, layer.Publish)
+	layer.Subscribe = prefixSubjects(c.prefix, namespace, layer.Subscribe)
+	return layer
+}
@@ -0,0 +1,235 @@
+package k8s
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestLoadPolicyFile(t *testing.T) {
+	tests := []struct {
+		name         string
+		content      string
+		wantProfiles int
+		wantInvalid  map[string][]string
+		wantErr      bool
+	}{
+		{
+			name: "defaults and profiles",
+			content: `defaults:
+  subscribe: ["platform.announcements"]
+profiles:
+  metrics-reader:
+    strategy: replace
+    subscribe: ["metrics.>"]
+  publisher:
+    publish: ["events.>"]
+`,
+			wantProfiles: 2,
+		},
+		{
+			name:    "unknown strategy",
+			content: "profiles:\n  broken:\n    strategy: union\n",
+			wantErr: true,
+		},
+		{
+			name:    "internal subject",
+			content: "defaults:\n  subscribe: [\"_INBOX.>\"]\n",
+			wantErr: true,
+		},
+		{
+			name:         "profile node selector",
+			content:      "profiles:\n  payments:\n    publish: [\"payments.>\"]\n    nodeSelector: {trusted: \"true\"}\n",
+			wantProfiles: 1,
+		},
+		{
+			name:         "malformed subject",
+			content:      "profiles:\n  payments:\n    publish: [\"payments.>\", \"payments..created\"]\n",
+			wantProfiles: 1,
+			wantInvalid:  map[string][]string{"profiles/payments": {"payments..created"}},
+		},
+		{
+			name:    "defaults node selector",
+			content: "defaults:\n  nodeSelector: {trusted: \"true\"}\n",
+			wantErr: true,
+		},
+		{
+			name:    "unknown field",
+			content: "default:\n  subscribe: [\"a.>\"]\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "policy.yaml")
+			if err := os.WriteFile(path, []byte(tt.content), 0o600); err != nil {
+				t.Fatalf("failed to write policy file: %v", err)
+			}
+
+			policy, err := LoadPolicyFile(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadPolicyFile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && len(policy.Profiles) != tt.wantProfiles {
+				t.Errorf("got %d profiles, want %d", len(policy.Profiles), tt.wantProfiles)
+			}
+			if err == nil && !reflect.DeepEqual(policy.InvalidSubjects(), tt.wantInvalid) {
+				t.Errorf("InvalidSubjects() = %v, want %v", policy.InvalidSubjects(), tt.wantInvalid)
+			}
+		})
+	}
+}
+
+// TestCache_NodeSelector tests that ServiceAccounts inherit the node selector of their profile
+func TestCache_NodeSelector(t *testing.T) {
+	cache := NewCache(zap.NewNop())
+	policy := &Policy{Profiles: map[string]Layer{
+		"payments": {Publish: []string{"payments.>"}, NodeSelector: map[string]string{"trusted": "true"}},
+		"reader":   {Subscribe: []string{"metrics.>"}},
+	}}
+	cache.SetPolicy(policy)
+	if !policy.UsesNodeSelector() {
+		t.Error("UsesNodeSelector() = false, want true")
+	}
+
+	for name, profile := range map[string]string{"api": "payments", "dashboard": "reader"} {
+		cache.Upsert(&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   "orders",
+			Annotations: map[string]string{AnnotationProfile: profile},
+		}})
+	}
+	if got := cache.NodeSelector("orders", "api"); got["trusted"] != "true" || len(got) != 1 {
+		t.Errorf("NodeSelector(api) = %v, want trusted=true", got)
+	}
+	if got := cache.NodeSelector("orders", "dashboard"); got != nil {
+		t.Errorf("NodeSelector(dashboard) = %v, want none", got)
+	}
+	if got := cache.NodeSelector("orders", "missing"); got != nil {
+		t.Errorf("NodeSelector(missing) = %v, want none", got)
+	}
+}
+
+func TestMergeLayers(t *testing.T) {
+	defaults := Layer{Subscribe: []string{"platform.announcements"}}
+	namespace := Layer{Publish: []string{"team.>"}}
+
+	pub, sub := mergeLayers(defaults, namespace, Layer{Publish: []string{"events.>"}})
+	if !equalStringSlices(pub, []string{"team.>", "events.>"}) || !equalStringSlices(sub, []string{"platform.announcements"}) {
+		t.Errorf("merge: got pub %v sub %v", pub, sub)
+	}
+
+	pub, sub = mergeLayers(defaults, namespace, Layer{Subscribe: []string{"metrics.>"}, Strategy: StrategyReplace})
+	if len(pub) != 0 || !equalStringSlices(sub, []string{"metrics.>"}) {
+		t.Errorf("replace: got pub %v sub %v", pub, sub)
+	}
+}
+
+// TestCache_PermissionChain tests the precedence of defaults, namespace, profile and ServiceAccount levels
+func TestCache_PermissionChain(t *testing.T) {
+	cache := NewCache(zap.NewNop())
+	cache.SetPolicy(&Policy{
+		Defaults: Layer{Subscribe: []string{"platform.announcements"}},
+		Profiles: map[string]Layer{
+			"metrics-reader": {Subscribe: []string{"metrics.>"}, Strategy: StrategyReplace},
+		},
+	})
+	cache.UpsertNamespace(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:        "orders",
+		Annotations: map[string]string{AnnotationAllowedPubSubjects: "shared.orders.>"},
+	}})
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		wantPub     []string
+		wantSub     []string
+	}{
+		{
+			name:        "all levels merged",
+			annotations: map[string]string{AnnotationAllowedSubSubjects: "billing.events"},
+			wantPub:     []string{"orders.>", "shared.orders.>"},
+			wantSub:     []string{"_INBOX.>", "_INBOX_orders_sa.>", "orders.>", "platform.announcements", "billing.events"},
+		},
+		{
+			name:        "profile replaces defaults and namespace",
+			annotations: map[string]string{AnnotationProfile: "metrics-reader"},
+			wantPub:     []string{"orders.>"},
+			wantSub:     []string{"_INBOX.>", "_INBOX_orders_sa.>", "orders.>", "metrics.>"},
+		},
+		{
+			name: "ServiceAccount replaces everything inherited",
+			annotations: map[string]string{
+				AnnotationProfile:            "metrics-reader",
+				AnnotationStrategy:           "replace",
+				AnnotationAllowedPubSubjects: "audit.>",
+			},
+			wantPub: []string{"orders.>", "audit.>"},
+			wantSub: []string{"_INBOX.>", "_INBOX_orders_sa.>", "orders.>"},
+		},
+		{
+			name:        "unknown profile ignored",
+			annotations: map[string]string{AnnotationProfile: "missing"},
+			wantPub:     []string{"orders.>", "shared.orders.>"},
+			wantSub:     []string{"_INBOX.>", "_INBOX_orders_sa.>", "orders.>", "platform.announcements"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache.Upsert(&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{
+				Name:        "sa",
+				Namespace:   "orders",
+				Annotations: tt.annotations,
+			}})
+			pub, sub, _ := cache.Get("orders", "sa")
+			if !equalStringSlices(pub, tt.wantPub) {
+				t.Errorf("pub = %v, want %v", pub, tt.wantPub)
+			}
+			if !equalStringSlices(sub, tt.wantSub) {
+				t.Errorf("sub = %v, want %v", sub, tt.wantSub)
+			}
+		})
+	}
+}
+
+func TestCache_PolicyStatus(t *testing.T) {
+	cache := NewCache(zap.NewNop())
+	if status := cache.PolicyStatus(); status != nil {
+		t.Fatalf("PolicyStatus() without a policy = %+v, want nil", status)
+	}
+
+	cache.SetPolicy(&Policy{
+		Profiles: map[string]Layer{
+			"metrics-reader": {Subscribe: []string{"metrics.>"}},
+			"unused":         {Subscribe: []string{"unused.>"}},
+		},
+	})
+	for name, profile := range map[string]string{"a": "metrics-reader", "b": "metrics-reader", "c": "missing", "d": ""} {
+		cache.Upsert(&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   "orders",
+			Annotations: map[string]string{AnnotationProfile: profile},
+		}})
+	}
+
+	status := cache.PolicyStatus()
+	if status.ServiceAccounts != 4 {
+		t.Errorf("ServiceAccounts = %d, want 4", status.ServiceAccounts)
+	}
+	if status.Profiles["metrics-reader"] != 2 {
+		t.Errorf("Profiles[metrics-reader] = %d, want 2", status.Profiles["metrics-reader"])
+	}
+	if count, found := status.Profiles["unused"]; !found || count != 0 {
+		t.Errorf("Profiles[unused] = %d, %v; want 0 listed", count, found)
+	}
+	if status.UnknownProfiles["missing"] != 1 || len(status.UnknownProfiles) != 1 {
+		t.Errorf("UnknownProfiles = %v, want missing: 1", status.UnknownProfiles)
+	}
+}
@@ -0,0 +1,27 @@
+package k8s
+
+import (
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// AnnotationRole is the annotation key selecting a scoped signing key role. The role's
+// permissions and limits are defined in the account JWT, so the ServiceAccount's subject
+// annotations do not apply to its user JWTs.
+const AnnotationRole = "nats.io/role"
+
+// Role returns the scoped signing key role a ServiceAccount selects, or "" for none
+func (c *Cache) Role(namespace, name string) string {
+	perms, found := c.lookup(namespace, name)
+	if !found {
+		return ""
+	}
+	return perms.Role
+}
+
+// role returns the role a ServiceAccount selects with its annotation. Unknown roles are kept
+// as-is so that authorization fails closed rather than falling back to annotation permissions.
+func role(sa *corev1.ServiceAccount) string {
+	return strings.TrimSpace(sa.Annotations[AnnotationRole])
+}
@@ -0,0 +1,66 @@
+package k8s
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// documentSeparator splits multi-document YAML on "---" lines
+var documentSeparator = regexp.MustCompile(`(?m)^---\s*$`)
+
+// seedDocument holds the fields needed to tell a ServiceAccount from a List
+type seedDocument struct {
+	Kind  string                  `json:"kind"`
+	Items []corev1.ServiceAccount `json:"items"`
+}
+
+// LoadServiceAccountsFile reads ServiceAccount manifests used to seed an in-memory
+// Kubernetes API. The file may contain multiple YAML documents separated by "---",
+// each either a ServiceAccount or a List of ServiceAccounts (as printed by
+// `kubectl get serviceaccounts -o yaml`).
+func LoadServiceAccountsFile(path string) ([]*corev1.ServiceAccount, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ServiceAccounts file: %w", err)
+	}
+
+	var accounts []*corev1.ServiceAccount
+	for i, doc := range documentSeparator.Split(string(data), -1) {
+		if len(bytes.TrimSpace([]byte(doc))) == 0 {
+			continue
+		}
+
+		var meta seedDocument
+		if err := yaml.Unmarshal([]byte(doc), &meta); err != nil {
+			return nil, fmt.Errorf("failed to parse document %d: %w", i+1, err)
+		}
+
+		switch meta.Kind {
+		case "List", "ServiceAccountList":
+			for j := range meta.Items {
+				accounts = append(accounts, &meta.Items[j])
+			}
+		case "ServiceAccount":
+			sa := &corev1.ServiceAccount{}
+			if err := yaml.Unmarshal([]byte(doc), sa); err != nil {
+				return nil, fmt.Errorf("failed to parse document %d: %w", i+1, err)
+			}
+			accounts = append(accounts, sa)
+		default:
+			return nil, fmt.Errorf("document %d: unsupported kind %q (want ServiceAccount or List)", i+1, meta.Kind)
+		}
+	}
+
+	for _, sa := range accounts {
+		if sa.Namespace == "" || sa.Name == "" {
+			return nil, fmt.Errorf("ServiceAccount %q must have metadata.name and metadata.namespace", sa.Name)
+		}
+	}
+
+	return accounts, nil
+}
@@ -0,0 +1,102 @@
+package k8s
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadServiceAccountsFile(t *testing.T) {
+	tests := []struct {
+		name      string
+		content   string
+		wantNames []string
+		wantErr   bool
+	}{
+		{
+			name: "multiple documents",
+			content: `apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: api
+  namespace: orders
+  annotations:
+    nats.io/allowed-pub-subjects: "events.>"
+---
+apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: worker
+  namespace: orders
+`,
+			wantNames: []string{"orders/api", "orders/worker"},
+		},
+		{
+			name: "kubectl list output",
+			content: `apiVersion: v1
+kind: List
+items:
+- apiVersion: v1
+  kind: ServiceAccount
+  metadata:
+    name: api
+    namespace: billing
+`,
+			wantNames: []string{"billing/api"},
+		},
+		{
+			name:      "empty file",
+			content:   "",
+			wantNames: nil,
+		},
+		{
+			name: "unsupported kind",
+			content: `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: cm
+  namespace: default
+`,
+			wantErr: true,
+		},
+		{
+			name: "missing namespace",
+			content: `apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: api
+`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "serviceaccounts.yaml")
+			if err := os.WriteFile(path, []byte(tt.content), 0600); err != nil {
+				t.Fatalf("failed to write file: %v", err)
+			}
+
+			accounts, err := LoadServiceAccountsFile(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadServiceAccountsFile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if len(accounts) != len(tt.wantNames) {
+				t.Fatalf("got %d ServiceAccounts, want %d", len(accounts), len(tt.wantNames))
+			}
+			for i, sa := range accounts {
+				if got := makeKey(sa.Namespace, sa.Name); got != tt.wantNames[i] {
+					t.Errorf("ServiceAccount[%d] = %s, want %s", i, got, tt.wantNames[i])
+				}
+			}
+		})
+	}
+
+	if _, err := LoadServiceAccountsFile(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("expected error for missing file")
+	}
+}
@@ -0,0 +1,139 @@
+package k8s
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// snapshotVersion is the format version written to snapshot files
+const snapshotVersion = 1
+
+// snapshotFile is the on-disk representation of the permission cache
+type snapshotFile struct {
+	Version int                     `json:"version"`
+	SavedAt time.Time               `json:"savedAt"`
+	Entries map[string]*Permissions `json:"entries"` // key: "namespace/name"
+}
+
+// SaveSnapshot writes the permission cache to path so it can be restored after a restart.
+// Nothing is written until the informer has synced, so a partially loaded cache never
+// replaces a complete snapshot. The file is replaced atomically.
+func (c *Client) SaveSnapshot(path string) error {
+	if !c.HasSynced() {
+		return nil
+	}
+
+	data, err := json.Marshal(snapshotFile{
+		Version: snapshotVersion,
+		SavedAt: time.Now().UTC(),
+		Entries: c.cache.entries(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode cache snapshot: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("failed to create cache snapshot: %w", err)
+	}
+	defer func() {
+		_ = os.Remove(tmp.Name())
+	}()
+
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("failed to write cache snapshot: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("failed to write cache snapshot: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write cache snapshot: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace cache snapshot: %w", err)
+	}
+	return nil
+}
+
+// RestoreSnapshot loads a snapshot written by SaveSnapshot into the cache, so permissions
+// can be served before the informer has synced. A missing file is not an error. Snapshots
+// older than maxAge are ignored; zero accepts any age. Returns the number of entries restored.
+func (c *Client) RestoreSnapshot(path string, maxAge time.Duration) (int, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		c.logger.Info("no cache snapshot to restore", zap.String("path", path))
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read cache snapshot: %w", err)
+	}
+
+	var snapshot snapshotFile
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return 0, fmt.Errorf("failed to parse cache snapshot: %w", err)
+	}
+	if snapshot.Version != snapshotVersion {
+		return 0, fmt.Errorf("unsupported cache snapshot version %d", snapshot.Version)
+	}
+
+	age := time.Since(snapshot.SavedAt)
+	if maxAge > 0 && age > maxAge {
+		c.logger.Warn("ignoring cache snapshot older than CACHE_SNAPSHOT_MAX_AGE",
+			zap.String("path", path),
+			zap.Duration("age", age.Truncate(time.Second)))
+		return 0, nil
+	}
+
+	restored := c.cache.restore(snapshot.Entries)
+	c.logger.Info("restored ServiceAccount permissions from cache snapshot",
+		zap.String("path", path),
+		zap.Int("entries", restored),
+		zap.Duration("age", age.Truncate(time.Second)))
+	return restored, nil
+}
+
+// PruneUnlisted removes cache entries that the informer has not listed, such as
+// ServiceAccounts restored from a snapshot that were deleted while the service was down.
+// It must only be called once the informer has synced. Returns the number of entries removed.
+func (c *Client) PruneUnlisted() int {
+	store := c.informer.GetStore()
+	removed := c.cache.retain(func(key string) bool {
+		_, exists, err := store.GetByKey(key)
+		return exists || err != nil
+	})
+	if len(removed) > 0 {
+		c.logger.Info("removed ServiceAccounts no longer present in Kubernetes",
+			zap.Strings("keys", removed))
+	}
+	return len(removed)
+}
+
+// RunSnapshots saves the cache to path every interval until ctx is cancelled,
+// then saves it one final time.
+func (c *Client) RunSnapshots(ctx context.Context, path string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			if err := c.SaveSnapshot(path); err != nil {
+				c.logger.Warn("failed to save final cache snapshot", zap.Error(err))
+			}
+			return
+		case <-ticker.C:
+			if err := c.SaveSnapshot(path); err != nil {
+				c.logger.Warn("failed to save cache snapshot", zap.Error(err))
+			}
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package k8s
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// TestClient_SnapshotRoundTrip tests that a saved snapshot restores permissions before sync
+// and that entries deleted from Kubernetes are pruned once the informer syncs
+func TestClient_SnapshotRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.json")
+
+	// Save a snapshot from a synced client
+	source := fake.NewSimpleClientset(
+		&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{
+			Name:        "kept",
+			Namespace:   "default",
+			Annotations: map[string]string{AnnotationAllowedPubSubjects: "events.>"},
+		}},
+		&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "deleted", Namespace: "default"}},
+	)
+	sourceFactory := informers.NewSharedInformerFactory(source, 0)
+	sourceClient := NewClient(sourceFactory, zap.NewNop())
+	sourceStop := make(chan struct{})
+	sourceFactory.Start(sourceStop)
+	sourceFactory.WaitForCacheSync(sourceStop)
+	if err := sourceClient.SaveSnapshot(path); err != nil {
+		t.Fatalf("SaveSnapshot() error = %v", err)
+	}
+	close(sourceStop)
+
+	// Restore into a fresh client whose informer has not started
+	restartedAPI := fake.NewSimpleClientset(
+		&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{
+			Name:        "kept",
+			Namespace:   "default",
+			Annotations: map[string]string{AnnotationAllowedPubSubjects: "events.>"},
+		}},
+	)
+	factory := informers.NewSharedInformerFactory(restartedAPI, 0)
+	client := NewClient(factory, zap.NewNop())
+
+	restored, err := client.RestoreSnapshot(path, time.Hour)
+	if err != nil {
+		t.Fatalf("RestoreSnapshot() error = %v", err)
+	}
+	if restored != 2 {
+		t.Errorf("RestoreSnapshot() restored %d entries, want 2", restored)
+	}
+
+	pubPerms, _, found := client.GetPermissions("default", "kept")
+	if !found {
+		t.Fatal("expected restored ServiceAccount to be found before sync")
+	}
+	if len(pubPerms) != 2 || pubPerms[1] != "events.>" {
+		t.Errorf("Got pubPerms = %v, want [default.> events.>]", pubPerms)
+	}
+
+	// Once synced, ServiceAccounts deleted while the service was down are removed
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	factory.Start(stopCh)
+	factory.WaitForCacheSync(stopCh)
+
+	if removed := client.PruneUnlisted(); removed != 1 {
+		t.Errorf("PruneUnlisted() removed %d entries, want 1", removed)
+	}
+	if _, _, found := client.GetPermissions("default", "deleted"); found {
+		t.Error("expected deleted ServiceAccount to be pruned after sync")
+	}
+	if _, _, found := client.GetPermissions("default", "kept"); !found {
+		t.Error("expected listed ServiceAccount to remain after sync")
+	}
+}
+
+// TestClient_RestoreSnapshot tests missing, stale and unsynced snapshot handling
+func TestClient_RestoreSnapshot(t *testing.T) {
+	dir := t.TempDir()
+
+	t.Run("missing file", func(t *testing.T) {
+		client := NewClient(informers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0), zap.NewNop())
+		restored, err := client.RestoreSnapshot(filepath.Join(dir, "missing.json"), time.Hour)
+		if err != nil || restored != 0 {
+			t.Errorf("RestoreSnapshot() = %d, %v, want 0, nil", restored, err)
+		}
+	})
+
+	t.Run("older than max age", func(t *testing.T) {
+		path := filepath.Join(dir, "stale.json")
+		data := `{"version":1,"savedAt":"2020-01-01T00:00:00Z","entries":{"default/sa":{"publish":["default.>"],"subscribe":[]}}}`
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		client := NewClient(informers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0), zap.NewNop())
+		restored, err := client.RestoreSnapshot(path, time.Hour)
+		if err != nil || restored != 0 {
+			t.Errorf("RestoreSnapshot() = %d, %v, want 0, nil", restored, err)
+		}
+	})
+
+	t.Run("corrupt file", func(t *testing.T) {
+		path := filepath.Join(dir, "corrupt.json")
+		if err := os.WriteFile(path, []byte("{not json"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		client := NewClient(informers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0), zap.NewNop())
+		if _, err := client.RestoreSnapshot(path, 0); err == nil {
+			t.Error("expected error for corrupt snapshot")
+		}
+	})
+
+	t.Run("save before sync is skipped", func(t *testing.T) {
+		path := filepath.Join(dir, "unsynced.json")
+		client := NewClient(informers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0), zap.NewNop())
+		if err := client.SaveSnapshot(path); err != nil {
+			t.Fatalf("SaveSnapshot() error = %v", err)
+		}
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Error("expected no snapshot to be written before sync")
+		}
+	})
+}
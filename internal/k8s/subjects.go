@@ -0,0 +1,114 @@
+package k8s
+
+import (
+	"fmt"
+	"strings"
+)
+
+// prefixNamespacePlaceholder is replaced with the ServiceAccount's namespace in a subject prefix
+const prefixNamespacePlaceholder = "{namespace}"
+
+// normalizeSubjects removes duplicate subjects and subjects already covered by a broader
+// wildcard in the same list (e.g. "orders.created" when "orders.>" is present), keeping the
+// order of the remaining subjects. Returns the normalized list and the subjects dropped.
+func normalizeSubjects(subjects []string) (normalized, dropped []string) {
+	normalized = make([]string, 0, len(subjects))
+	seen := make(map[string]bool, len(subjects))
+
+	for i, subject := range subjects {
+		subject = strings.TrimSpace(subject)
+		if subject == "" {
+			continue
+		}
+		if seen[subject] || coveredByOther(subject, subjects, i) {
+			dropped = append(dropped, subject)
+			continue
+		}
+		seen[subject] = true
+		normalized = append(normalized, subject)
+	}
+
+	return normalized, dropped
+}
+
+// coveredByOther reports whether subjects[index] is covered by a different subject in the list
+func coveredByOther(subject string, subjects []string, index int) bool {
+	for i, other := range subjects {
+		other = strings.TrimSpace(other)
+		if i == index || other == subject {
+			continue
+		}
+		if subjectCovers(other, subject) {
+			return true
+		}
+	}
+	return false
+}
+
+// subjectCovers reports whether every subject matched by narrow is also matched by broad,
+// using NATS wildcard rules ("*" matches one token, ">" one or more trailing tokens).
+// Subjects with a queue group (containing a space) only cover themselves.
+func subjectCovers(broad, narrow string) bool {
+	if strings.Contains(broad, " ") || strings.Contains(narrow, " ") {
+		return false
+	}
+
+	broadTokens := strings.Split(broad, ".")
+	narrowTokens := strings.Split(narrow, ".")
+
+	for i, token := range broadTokens {
+		if token == ">" {
+			return len(narrowTokens) > i
+		}
+		if i >= len(narrowTokens) {
+			return false
+		}
+		switch narrowToken := narrowTokens[i]; {
+		case token == "*":
+			if narrowToken == ">" {
+				return false
+			}
+		case token != narrowToken:
+			return false
+		}
+	}
+
+	return len(broadTokens) == len(narrowTokens)
+}
+
+// validateSubjectPrefix checks a subject prefix template: literal tokens, optionally containing
+// the {namespace} placeholder, ending with "."
+func validateSubjectPrefix(prefix string) error {
+	if !strings.HasSuffix(prefix, ".") {
+		return fmt.Errorf("subject prefix %q must end with \".\"", prefix)
+	}
+	for _, token := range strings.Split(strings.TrimSuffix(prefix, "."), ".") {
+		if token == "" || strings.ContainsAny(token, "*> \t\r\n") || strings.HasPrefix(token, "$") {
+			return fmt.Errorf("subject prefix %q must be literal tokens without wildcards, whitespace or a leading \"$\"", prefix)
+		}
+	}
+	if strings.Contains(strings.ReplaceAll(prefix, prefixNamespacePlaceholder, ""), "{") {
+		return fmt.Errorf("subject prefix %q: only the %s placeholder is supported", prefix, prefixNamespacePlaceholder)
+	}
+	return nil
+}
+
+// prefixSubjects rewrites subjects into the form prefix+subject, with {namespace} in the prefix
+// replaced by namespace. Subjects already carrying the prefix, system subjects starting with
+// "$" (such as the JetStream API) and the unprefixed _INBOX and _REPLY subjects are left
+// unchanged. An empty prefix returns subjects as is.
+func prefixSubjects(prefix, namespace string, subjects []string) []string {
+	if prefix == "" || len(subjects) == 0 {
+		return subjects
+	}
+	prefix = strings.ReplaceAll(prefix, prefixNamespacePlaceholder, namespace)
+
+	result := make([]string, len(subjects))
+	for i, subject := range subjects {
+		if !strings.HasPrefix(subject, "$") && !isInternalSubject(subject) && !strings.HasPrefix(subject, prefix) {
+			subject = prefix + subject
+		}
+		result[i] = subject
+	}
+	return result
+}
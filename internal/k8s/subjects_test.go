@@ -0,0 +1,101 @@
+package k8s
+
+import "testing"
+
+func TestSubjectCovers(t *testing.T) {
+	tests := []struct {
+		broad, narrow string
+		want          bool
+	}{
+		{broad: "orders.>", narrow: "orders.created", want: true},
+		{broad: "orders.>", narrow: "orders.*.eu", want: true},
+		{broad: "orders.>", narrow: "orders.>", want: true},
+		{broad: "orders.>", narrow: "orders", want: false},
+		{broad: "orders.*", narrow: "orders.created", want: true},
+		{broad: "orders.*", narrow: "orders.>", want: false},
+		{broad: "orders.*", narrow: "orders.created.eu", want: false},
+		{broad: "*.created", narrow: "orders.created", want: true},
+		{broad: ">", narrow: "anything.at.all", want: true},
+		{broad: "orders.created", narrow: "orders.*", want: false},
+		{broad: "orders.> workers", narrow: "orders.created", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.broad+" covers "+tt.narrow, func(t *testing.T) {
+			if got := subjectCovers(tt.broad, tt.narrow); got != tt.want {
+				t.Errorf("subjectCovers(%q, %q) = %v, want %v", tt.broad, tt.narrow, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeSubjects(t *testing.T) {
+	tests := []struct {
+		name        string
+		subjects    []string
+		want        []string
+		wantDropped []string
+	}{
+		{
+			name:     "no redundancy",
+			subjects: []string{"default.>", "platform.events.*"},
+			want:     []string{"default.>", "platform.events.*"},
+		},
+		{
+			name:        "duplicates collapsed",
+			subjects:    []string{"a.>", "b.*", "a.>"},
+			want:        []string{"a.>", "b.*"},
+			wantDropped: []string{"a.>"},
+		},
+		{
+			name:        "covered by broader wildcard",
+			subjects:    []string{"default.>", "default.orders.*", "platform.events.created", "platform.events.*"},
+			want:        []string{"default.>", "platform.events.*"},
+			wantDropped: []string{"default.orders.*", "platform.events.created"},
+		},
+		{
+			name:     "private inbox kept alongside _INBOX.>",
+			subjects: []string{"_INBOX.>", "_INBOX_default_app.>", "default.>"},
+			want:     []string{"_INBOX.>", "_INBOX_default_app.>", "default.>"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, dropped := normalizeSubjects(tt.subjects)
+			if !equalStringSlices(got, tt.want) {
+				t.Errorf("normalizeSubjects() = %v, want %v", got, tt.want)
+			}
+			if !equalStringSlices(dropped, tt.wantDropped) {
+				t.Errorf("normalizeSubjects() dropped = %v, want %v", dropped, tt.wantDropped)
+			}
+		})
+	}
+}
+
+func TestPrefixSubjects(t *testing.T) {
+	subjects := []string{"events.>", "prod.orders.audit", "$JS.API.INFO", "_INBOX.>", "jobs.* workers"}
+
+	got := prefixSubjects("prod.{namespace}.", "orders", subjects)
+	want := []string{"prod.orders.events.>", "prod.orders.audit", "$JS.API.INFO", "_INBOX.>", "prod.orders.jobs.* workers"}
+	if !equalStringSlices(got, want) {
+		t.Errorf("prefixSubjects() = %v, want %v", got, want)
+	}
+
+	if got := prefixSubjects("", "orders", subjects); !equalStringSlices(got, subjects) {
+		t.Errorf("prefixSubjects() with empty prefix = %v, want unchanged", got)
+	}
+}
+
+func TestValidateSubjectPrefix(t *testing.T) {
+	for _, prefix := range []string{"prod.", "prod-eu.{namespace}.", "{namespace}.apps."} {
+		if err := validateSubjectPrefix(prefix); err != nil {
+			t.Errorf("validateSubjectPrefix(%q) error = %v", prefix, err)
+		}
+	}
+	for _, prefix := range []string{"prod", "prod..", "*.", "prod.>.", "$SYS.", "{cluster}.{namespace}."} {
+		if err := validateSubjectPrefix(prefix); err == nil {
+			t.Errorf("validateSubjectPrefix(%q) expected error", prefix)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package k8s
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// AnnotationRequireTLS is the namespace annotation key that, set to "true", denies connections
+// of the namespace's ServiceAccounts that did not arrive over TLS.
+const AnnotationRequireTLS = "nats.io/require-tls"
+
+// RequireTLS reports whether a ServiceAccount's namespace requires TLS connections by the
+// nats.io/require-tls annotation
+func (c *Cache) RequireTLS(namespace, name string) bool {
+	return c.view.Load().tlsRequired[namespace]
+}
+
+// requireTLS parses a namespace's nats.io/require-tls annotation. As it enforces a protection,
+// an invalid value requires TLS.
+func requireTLS(annotations map[string]string) (bool, error) {
+	value, ok := annotations[AnnotationRequireTLS]
+	if !ok {
+		return false, nil
+	}
+	required, err := strconv.ParseBool(strings.TrimSpace(value))
+	if err != nil {
+		return true, fmt.Errorf("annotation %s value %q is not a boolean; TLS required", AnnotationRequireTLS, value)
+	}
+	return required, nil
+}
@@ -0,0 +1,77 @@
+package k8s
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// AnnotationMaxSubscriptions is the annotation key for the most subscriptions a
+	// ServiceAccount's connections may hold.
+	AnnotationMaxSubscriptions = "nats.io/max-subscriptions"
+	// AnnotationMaxPayload is the annotation key for the largest message payload, in bytes, a
+	// ServiceAccount's connections may publish.
+	AnnotationMaxPayload = "nats.io/max-payload"
+	// AnnotationMaxData is the annotation key for the most data, in bytes, a ServiceAccount's
+	// connections may have pending.
+	AnnotationMaxData = "nats.io/max-data"
+	// AnnotationMaxConnections is the annotation key for the most connections a ServiceAccount
+	// may hold open at once across the NATS cluster.
+	AnnotationMaxConnections = "nats.io/max-connections"
+)
+
+// UserLimits are the NATS user limits a ServiceAccount requests by annotation. Zero means
+// the annotation is absent and the configured default applies.
+type UserLimits struct {
+	Subscriptions int64 `json:"subscriptions,omitempty"`
+	Payload       int64 `json:"payload,omitempty"`
+	Data          int64 `json:"data,omitempty"`
+	Connections   int64 `json:"connections,omitempty"`
+}
+
+// UserLimits returns the NATS user limits a ServiceAccount requests by annotation
+func (c *Cache) UserLimits(namespace, name string) UserLimits {
+	perms, found := c.lookup(namespace, name)
+	if !found {
+		return UserLimits{}
+	}
+	return perms.Limits
+}
+
+// MaxConnections returns the most open connections a ServiceAccount requests with the
+// nats.io/max-connections annotation, or zero for the default
+func (c *Cache) MaxConnections(namespace, name string) int {
+	perms, found := c.lookup(namespace, name)
+	if !found {
+		return 0
+	}
+	return int(perms.Limits.Connections)
+}
+
+// userLimits parses the ServiceAccount's limit annotations. Values that are not positive
+// integers are ignored and reported.
+func (c *Cache) userLimits(sa *corev1.ServiceAccount) UserLimits {
+	return UserLimits{
+		Subscriptions: c.limitAnnotation(sa, AnnotationMaxSubscriptions),
+		Payload:       c.limitAnnotation(sa, AnnotationMaxPayload),
+		Data:          c.limitAnnotation(sa, AnnotationMaxData),
+		Connections:   c.limitAnnotation(sa, AnnotationMaxConnections),
+	}
+}
+
+// limitAnnotation parses a single limit annotation, returning zero when it is absent or invalid
+func (c *Cache) limitAnnotation(sa *corev1.ServiceAccount, key string) int64 {
+	value, ok := sa.Annotations[key]
+	if !ok {
+		return 0
+	}
+	limit, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	if err != nil || limit < 1 {
+		c.warn(sa, "InvalidAnnotation", fmt.Sprintf("annotation %s value %q is not a positive integer; ignoring it", key, value))
+		return 0
+	}
+	return limit
+}
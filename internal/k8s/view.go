@@ -0,0 +1,117 @@
+package k8s
+
+import (
+	"hash/maphash"
+	"maps"
+)
+
+// viewShards is the number of shards the cached permissions are split across. A write copies
+// only the shard it changes, so its cost stays small however many ServiceAccounts are cached.
+const viewShards = 256
+
+var viewSeed = maphash.MakeSeed()
+
+// view is an immutable snapshot of the cached permissions and namespace annotations.
+// Authorizations read the current view through an atomic pointer without locking, while
+// writers, serialized by the cache lock, edit a copy and publish it, so reads never wait on
+// annotation churn. Views and the permissions in them must not be modified once published.
+type view struct {
+	shards      [viewShards]map[string]*Permissions // key: "namespace/name"
+	size        int
+	disabled    map[string]bool // namespaces with NATS access disabled by annotation
+	tlsRequired map[string]bool // namespaces requiring TLS connections by annotation
+}
+
+// shardOf returns the shard holding a key
+func shardOf(key string) int {
+	return int(maphash.String(viewSeed, key) % viewShards)
+}
+
+// get returns the permissions cached for a key
+func (v *view) get(key string) (*Permissions, bool) {
+	perms, found := v.shards[shardOf(key)][key]
+	return perms, found
+}
+
+// each calls fn for every cached ServiceAccount, in no particular order
+func (v *view) each(fn func(key string, perms *Permissions)) {
+	for _, shard := range v.shards {
+		for key, perms := range shard {
+			fn(key, perms)
+		}
+	}
+}
+
+// edit starts a copy of the view to change
+func (v *view) edit() *viewEdit {
+	return &viewEdit{next: *v}
+}
+
+// viewEdit is a copy of a view being changed. Each shard and namespace map is copied at most
+// once, so a batch of changes costs no more than the parts of the view it touches.
+type viewEdit struct {
+	next      view
+	copied    [viewShards]bool
+	copiedNSs bool
+}
+
+// set caches permissions for a key, replacing any already cached
+func (e *viewEdit) set(key string, perms *Permissions) {
+	shard := e.shard(key)
+	if _, found := shard[key]; !found {
+		e.next.size++
+	}
+	shard[key] = perms
+}
+
+// remove forgets the permissions cached for a key
+func (e *viewEdit) remove(key string) {
+	shard := e.shard(key)
+	if _, found := shard[key]; found {
+		delete(shard, key)
+		e.next.size--
+	}
+}
+
+// shard returns the writable copy of the shard holding a key
+func (e *viewEdit) shard(key string) map[string]*Permissions {
+	i := shardOf(key)
+	if !e.copied[i] {
+		shard := make(map[string]*Permissions, len(e.next.shards[i])+1)
+		maps.Copy(shard, e.next.shards[i])
+		e.next.shards[i] = shard
+		e.copied[i] = true
+	}
+	return e.next.shards[i]
+}
+
+// namespace records a namespace's annotations, or forgets them when neither is set
+func (e *viewEdit) namespace(name string, disabled, tlsRequired bool) {
+	if !e.copiedNSs {
+		e.next.disabled = maps.Clone(e.next.disabled)
+		e.next.tlsRequired = maps.Clone(e.next.tlsRequired)
+		if e.next.disabled == nil {
+			e.next.disabled = make(map[string]bool)
+		}
+		if e.next.tlsRequired == nil {
+			e.next.tlsRequired = make(map[string]bool)
+		}
+		e.copiedNSs = true
+	}
+	setFlag(e.next.disabled, name, disabled)
+	setFlag(e.next.tlsRequired, name, tlsRequired)
+}
+
+// setFlag adds a key to a set when set is true and removes it otherwise
+func setFlag(flags map[string]bool, key string, set bool) {
+	if set {
+		flags[key] = true
+	} else {
+		delete(flags, key)
+	}
+}
+
+// view returns the edited view, ready to publish
+func (e *viewEdit) view() *view {
+	return &e.next
+}
@@ -0,0 +1,54 @@
+package k8s
+
+import (
+	"strconv"
+	"testing"
+)
+
+// TestView_Edit tests that editing a view leaves the published view unchanged
+func TestView_Edit(t *testing.T) {
+	edit := (&view{}).edit()
+	for i := range 1000 {
+		edit.set("shop/app-"+strconv.Itoa(i), &Permissions{Role: "app"})
+	}
+	edit.namespace("shop", true, false)
+	published := edit.view()
+	if published.size != 1000 || !published.disabled["shop"] {
+		t.Fatalf("view size = %d, disabled = %v; want 1000 and shop disabled", published.size, published.disabled)
+	}
+
+	edit = published.edit()
+	edit.set("shop/app-0", &Permissions{Role: "admin"})
+	edit.set("shop/new", &Permissions{})
+	edit.remove("shop/app-1")
+	edit.remove("shop/missing")
+	edit.namespace("shop", false, true)
+	next := edit.view()
+
+	if perms, _ := published.get("shop/app-0"); perms.Role != "app" {
+		t.Errorf("published view role = %q after an edit, want app", perms.Role)
+	}
+	if _, found := published.get("shop/app-1"); !found || published.size != 1000 {
+		t.Error("expected an edit to leave the published view's entries in place")
+	}
+	if published.tlsRequired["shop"] || !published.disabled["shop"] {
+		t.Error("expected an edit to leave the published view's namespaces in place")
+	}
+
+	if perms, _ := next.get("shop/app-0"); perms.Role != "admin" {
+		t.Errorf("edited view role = %q, want admin", perms.Role)
+	}
+	if _, found := next.get("shop/app-1"); found {
+		t.Error("expected the removed entry to be gone from the edited view")
+	}
+	if next.size != 1000 || next.disabled["shop"] || !next.tlsRequired["shop"] {
+		t.Errorf("edited view size = %d, namespaces %v %v; want 1000, enabled, TLS required",
+			next.size, next.disabled, next.tlsRequired)
+	}
+
+	count := 0
+	next.each(func(string, *Permissions) { count++ })
+	if count != next.size {
+		t.Errorf("each() visited %d entries, want %d", count, next.size)
+	}
+}
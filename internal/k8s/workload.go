@@ -0,0 +1,115 @@
+package k8s
+
+import (
+	"fmt"
+	"time"
+
+	httpmetrics "github.com/portswigger-tim/nats-k8s-oidc-callout/internal/httpserver"
+	"go.uber.org/zap"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+)
+
+// WatchWorkloads also watches pods and ReplicaSets from factory, so that the workload owning
+// a token's pod can be resolved (see Workload). Only their names and owner references are
+// kept, so watching every pod stays cheap. It must be called before the informers are started.
+func (c *Client) WatchWorkloads(factory informers.SharedInformerFactory) error {
+	podInformer := factory.Core().V1().Pods().Informer()
+	rsInformer := factory.Apps().V1().ReplicaSets().Informer()
+
+	for resource, informer := range map[string]cache.SharedIndexInformer{
+		resourcePod:        podInformer,
+		resourceReplicaSet: rsInformer,
+	} {
+		if err := informer.SetTransform(ownersOnly); err != nil {
+			return fmt.Errorf("failed to register %s transform: %w", resource, err)
+		}
+		if err := informer.SetWatchErrorHandler(func(_ *cache.Reflector, err error) {
+			c.logger.Warn(resource+" watch failed", zap.Error(err))
+			httpmetrics.IncrementK8sWatchErrors(resource)
+		}); err != nil {
+			return fmt.Errorf("failed to register %s watch error handler: %w", resource, err)
+		}
+	}
+
+	c.podInformer, c.rsInformer = podInformer, rsInformer
+	return nil
+}
+
+// ownersOnly strips pods and ReplicaSets down to the metadata needed to resolve workloads
+func ownersOnly(obj any) (any, error) {
+	switch o := obj.(type) {
+	case *corev1.Pod:
+		return &corev1.Pod{ObjectMeta: ownerMeta(&o.ObjectMeta)}, nil
+	case *appsv1.ReplicaSet:
+		return &appsv1.ReplicaSet{ObjectMeta: ownerMeta(&o.ObjectMeta)}, nil
+	}
+	return obj, nil
+}
+
+// ownerMeta copies the identifying metadata and owner references of an object
+func ownerMeta(meta *metav1.ObjectMeta) metav1.ObjectMeta {
+	return metav1.ObjectMeta{
+		Name:              meta.Name,
+		Namespace:         meta.Namespace,
+		UID:               meta.UID,
+		ResourceVersion:   meta.ResourceVersion,
+		CreationTimestamp: meta.CreationTimestamp,
+		OwnerReferences:   meta.OwnerReferences,
+	}
+}
+
+// Workload returns the name of the workload owning a pod, and whether it is known: the
+// Deployment of a pod owned by a ReplicaSet, or else the pod's controller, such as a
+// StatefulSet, DaemonSet or Job. Pods without a controller, and pods or ReplicaSets not in
+// the cache, have no known workload; pods are retried for the miss retry window, like
+// ServiceAccounts. No workload is known unless workloads are watched.
+func (c *Client) Workload(namespace, pod string) (string, bool) {
+	if c.podInformer == nil {
+		return "", false
+	}
+
+	obj, exists := getByKey(c.podInformer, namespace+"/"+pod)
+	if !exists && c.missRetry > 0 && c.HasSynced() {
+		deadline := time.Now().Add(c.missRetry)
+		for !exists && time.Now().Before(deadline) {
+			time.Sleep(missRetryInterval)
+			obj, exists = getByKey(c.podInformer, namespace+"/"+pod)
+		}
+	}
+	p, ok := obj.(*corev1.Pod)
+	if !exists || !ok {
+		return "", false
+	}
+
+	owner := metav1.GetControllerOf(p)
+	if owner == nil {
+		return "", false
+	}
+	if owner.Kind != "ReplicaSet" {
+		return owner.Name, true
+	}
+
+	// Deployments own their pods through a ReplicaSet per revision
+	obj, exists = getByKey(c.rsInformer, namespace+"/"+owner.Name)
+	rs, ok := obj.(*appsv1.ReplicaSet)
+	if !exists || !ok {
+		return "", false
+	}
+	if deployment := metav1.GetControllerOf(rs); deployment != nil && deployment.Kind == "Deployment" {
+		return deployment.Name, true
+	}
+	return rs.Name, true
+}
+
+// getByKey returns an object from an informer's store, and whether it exists
+func getByKey(informer cache.SharedIndexInformer, key string) (any, bool) {
+	obj, exists, err := informer.GetStore().GetByKey(key)
+	if err != nil {
+		return nil, false
+	}
+	return obj, exists
+}
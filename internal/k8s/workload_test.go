@@ -0,0 +1,88 @@
+package k8s
+
+import (
+	"testing"
+
+	"go.uber.org/zap"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// controlledBy returns owner references naming kind/name as the controller
+func controlledBy(kind, name string) []metav1.OwnerReference {
+	controller := true
+	return []metav1.OwnerReference{{Kind: kind, Name: name, Controller: &controller}}
+}
+
+// TestClient_Workload tests that pods resolve to the workload owning them
+func TestClient_Workload(t *testing.T) {
+	fakeClient := fake.NewSimpleClientset(
+		&appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{
+			Name: "checkout-7d9f", Namespace: "shop", OwnerReferences: controlledBy("Deployment", "checkout"),
+		}},
+		&appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{Name: "legacy", Namespace: "shop"}},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "checkout-7d9f-x2k4p", Namespace: "shop", OwnerReferences: controlledBy("ReplicaSet", "checkout-7d9f"),
+			},
+			Spec: corev1.PodSpec{NodeName: "worker-1"},
+		},
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name: "legacy-abcde", Namespace: "shop", OwnerReferences: controlledBy("ReplicaSet", "legacy"),
+		}},
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name: "db-0", Namespace: "shop", OwnerReferences: controlledBy("StatefulSet", "db"),
+		}},
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name: "orphan-rs-pod", Namespace: "shop", OwnerReferences: controlledBy("ReplicaSet", "deleted"),
+		}},
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "debug", Namespace: "shop"}},
+	)
+	informerFactory := informers.NewSharedInformerFactory(fakeClient, 0)
+	client := NewClient(informerFactory, zap.NewNop())
+
+	// Without the pod informer no workload is known
+	if _, found := client.Workload("shop", "db-0"); found {
+		t.Error("Workload() found a workload before workloads are watched")
+	}
+
+	if err := client.WatchWorkloads(informerFactory); err != nil {
+		t.Fatalf("WatchWorkloads() error = %v", err)
+	}
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	informerFactory.Start(stopCh)
+	informerFactory.WaitForCacheSync(stopCh)
+
+	if !client.HasSynced() {
+		t.Error("HasSynced() = false after the pod and ReplicaSet informers synced")
+	}
+
+	tests := []struct {
+		pod       string
+		want      string
+		wantFound bool
+	}{
+		{pod: "checkout-7d9f-x2k4p", want: "checkout", wantFound: true},
+		{pod: "legacy-abcde", want: "legacy", wantFound: true},
+		{pod: "db-0", want: "db", wantFound: true},
+		{pod: "orphan-rs-pod"},
+		{pod: "debug"},
+		{pod: "deleted-pod"},
+	}
+	for _, tt := range tests {
+		got, found := client.Workload("shop", tt.pod)
+		if got != tt.want || found != tt.wantFound {
+			t.Errorf("Workload(shop, %s) = %q, %v; want %q, %v", tt.pod, got, found, tt.want, tt.wantFound)
+		}
+	}
+
+	// Only the metadata needed to resolve workloads is kept
+	obj, _, _ := client.podInformer.GetStore().GetByKey("shop/checkout-7d9f-x2k4p")
+	if pod := obj.(*corev1.Pod); pod.Spec.NodeName != "" {
+		t.Errorf("cached pod spec = %+v, want it stripped", pod.Spec)
+	}
+}
@@ -0,0 +1,273 @@
+// Package lastauth records when each ServiceAccount last authenticated to NATS, so hygiene
+// tooling can find ServiceAccounts whose NATS grants are no longer used.
+package lastauth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/portswigger-tim/nats-k8s-oidc-callout/internal/auth"
+	"github.com/portswigger-tim/nats-k8s-oidc-callout/internal/nats"
+)
+
+// Path is where the last authentications are served on the HTTP server
+const Path = "/debug/last-auth"
+
+// annotateTimeout bounds each annotation request
+const annotateTimeout = 10 * time.Second
+
+// Annotator writes the last authentication time onto a ServiceAccount
+type Annotator interface {
+	AnnotateLastAuthenticated(ctx context.Context, namespace, name string, at time.Time) error
+}
+
+// Relay shares the authentications recorded by every replica with the one annotating
+// ServiceAccounts, when only the elected leader annotates. Authentications are keyed by
+// namespace/name.
+type Relay interface {
+	Publish(ctx context.Context, accounts map[string]time.Time) error
+	Collect(ctx context.Context) (map[string]time.Time, error)
+}
+
+// ServiceAccount is the last successful authentication of a ServiceAccount
+type ServiceAccount struct {
+	Namespace         string    `json:"namespace"`
+	Name              string    `json:"name"`
+	LastAuthenticated time.Time `json:"lastAuthenticated"`
+}
+
+// Snapshot lists the ServiceAccounts that authenticated since the tracker started
+type Snapshot struct {
+	Since           time.Time        `json:"since"`
+	ServiceAccounts []ServiceAccount `json:"serviceAccounts"`
+}
+
+// key identifies a ServiceAccount
+type key struct {
+	namespace, name string
+}
+
+// entry is the tracked state of a ServiceAccount
+type entry struct {
+	last      time.Time // last successful authentication
+	annotated time.Time // last authentication written by the annotator
+}
+
+// Tracker records the last successful NATS authentication of each ServiceAccount in memory,
+// and optionally annotates the ServiceAccounts with it. Annotations are written in batches
+// every interval rather than on each authentication, so busy ServiceAccounts do not flood the
+// Kubernetes API, and are accurate to within the interval.
+type Tracker struct {
+	since  time.Time
+	logger *zap.Logger
+	now    func() time.Time
+
+	mu      sync.Mutex
+	entries map[key]*entry
+}
+
+// New creates a tracker
+func New(logger *zap.Logger) *Tracker {
+	return &Tracker{
+		since:   time.Now(),
+		logger:  logger,
+		now:     time.Now,
+		entries: make(map[key]*entry),
+	}
+}
+
+// WrapHandler records the successful authentications made by h.
+func (t *Tracker) WrapHandler(h nats.AuthHandler) nats.AuthHandler {
+	return &trackingHandler{next: h, tracker: t}
+}
+
+// Record notes a successful authentication of a ServiceAccount.
+func (t *Tracker) Record(namespace, name string) {
+	t.RecordAt(namespace, name, t.now())
+}
+
+// RecordAt notes a successful authentication of a ServiceAccount at a given time, such as
+// one relayed by another replica. Times before the last recorded authentication are ignored.
+func (t *Tracker) RecordAt(namespace, name string, at time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	k := key{namespace, name}
+	e, found := t.entries[k]
+	if !found {
+		e = &entry{}
+		t.entries[k] = e
+	}
+	if at.After(e.last) {
+		e.last = at
+	}
+}
+
+// Snapshot returns the ServiceAccounts that authenticated since the tracker started, sorted
+// by namespace and name.
+func (t *Tracker) Snapshot() Snapshot {
+	t.mu.Lock()
+	accounts := make([]ServiceAccount, 0, len(t.entries))
+	for k, e := range t.entries {
+		accounts = append(accounts, ServiceAccount{Namespace: k.namespace, Name: k.name, LastAuthenticated: e.last.UTC()})
+	}
+	t.mu.Unlock()
+
+	sort.Slice(accounts, func(i, j int) bool {
+		if accounts[i].Namespace != accounts[j].Namespace {
+			return accounts[i].Namespace < accounts[j].Namespace
+		}
+		return accounts[i].Name < accounts[j].Name
+	})
+	return Snapshot{Since: t.since.UTC(), ServiceAccounts: accounts}
+}
+
+// ServeHTTP serves the snapshot as JSON
+func (t *Tracker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(t.Snapshot()); err != nil {
+		t.logger.Error("failed to encode last authentications", zap.Error(err))
+	}
+}
+
+// Run annotates the ServiceAccounts that authenticated since the last run every interval,
+// until the context is cancelled, then annotates them a last time. With a relay, the
+// authentications relayed by the other replicas are annotated as well.
+func (t *Tracker) Run(ctx context.Context, annotator Annotator, relay Relay, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	annotate := func(ctx context.Context) {
+		if relay != nil {
+			t.collect(ctx, relay)
+		}
+		t.Annotate(ctx, annotator)
+	}
+	for {
+		select {
+		case <-ticker.C:
+			annotate(ctx)
+		case <-ctx.Done():
+			final, cancel := context.WithTimeout(context.Background(), annotateTimeout)
+			annotate(final)
+			cancel()
+			return
+		}
+	}
+}
+
+// RunRelay publishes the authentications of the last two intervals to the relay every
+// interval until the context is cancelled, then publishes them a last time. Each is relayed
+// at least twice, so the leader collecting every interval sees it.
+func (t *Tracker) RunRelay(ctx context.Context, relay Relay, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	publish := func(ctx context.Context) {
+		reqCtx, cancel := context.WithTimeout(ctx, annotateTimeout)
+		defer cancel()
+		if err := relay.Publish(reqCtx, t.recent(t.now().Add(-2*interval))); err != nil {
+			t.logger.Warn("failed to relay last authentications to the leader", zap.Error(err))
+		}
+	}
+	for {
+		select {
+		case <-ticker.C:
+			publish(ctx)
+		case <-ctx.Done():
+			publish(context.Background())
+			return
+		}
+	}
+}
+
+// recent returns the authentications after a time, keyed by namespace/name
+func (t *Tracker) recent(after time.Time) map[string]time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	accounts := make(map[string]time.Time)
+	for k, e := range t.entries {
+		if e.last.After(after) {
+			accounts[k.namespace+"/"+k.name] = e.last
+		}
+	}
+	return accounts
+}
+
+// collect records the authentications relayed by every replica
+func (t *Tracker) collect(ctx context.Context, relay Relay) {
+	reqCtx, cancel := context.WithTimeout(ctx, annotateTimeout)
+	defer cancel()
+	accounts, err := relay.Collect(reqCtx)
+	if err != nil {
+		t.logger.Warn("failed to collect the last authentications relayed by other replicas", zap.Error(err))
+		return
+	}
+	for identity, at := range accounts {
+		if namespace, name, ok := strings.Cut(identity, "/"); ok {
+			t.RecordAt(namespace, name, at)
+		}
+	}
+}
+
+// Annotate writes the last authentication of each ServiceAccount that authenticated since it
+// was last annotated. Failures are retried on the next run.
+func (t *Tracker) Annotate(ctx context.Context, annotator Annotator) {
+	type pending struct {
+		key
+		last time.Time
+	}
+	t.mu.Lock()
+	var due []pending
+	for k, e := range t.entries {
+		if e.last.After(e.annotated) {
+			due = append(due, pending{k, e.last})
+		}
+	}
+	t.mu.Unlock()
+
+	var failed int
+	var lastErr error
+	for _, p := range due {
+		reqCtx, cancel := context.WithTimeout(ctx, annotateTimeout)
+		err := annotator.AnnotateLastAuthenticated(reqCtx, p.namespace, p.name, p.last)
+		cancel()
+		if err != nil {
+			failed++
+			lastErr = err
+			continue
+		}
+		t.mu.Lock()
+		if e, found := t.entries[p.key]; found && p.last.After(e.annotated) {
+			e.annotated = p.last
+		}
+		t.mu.Unlock()
+	}
+	if failed > 0 {
+		t.logger.Warn("failed to annotate ServiceAccounts with their last authentication",
+			zap.Int("failed", failed),
+			zap.Int("annotated", len(due)-failed),
+			zap.Error(lastErr))
+	}
+}
+
+// trackingHandler records the successful authentications of ServiceAccounts
+type trackingHandler struct {
+	next    nats.AuthHandler
+	tracker *Tracker
+}
+
+func (h *trackingHandler) Authorize(req *auth.AuthRequest) *auth.AuthResponse {
+	resp := h.next.Authorize(req)
+	// Non-Kubernetes identities have no ServiceAccount to record
+	if resp.Allowed && resp.Namespace != "" && resp.ServiceAccount != "" {
+		h.tracker.Record(resp.Namespace, resp.ServiceAccount)
+	}
+	return resp
+}
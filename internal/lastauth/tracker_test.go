@@ -0,0 +1,140 @@
+package lastauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/portswigger-tim/nats-k8s-oidc-callout/internal/auth"
+)
+
+// identityHandler allows the token named after a namespace/serviceaccount identity
+type identityHandler struct{}
+
+func (identityHandler) Authorize(req *auth.AuthRequest) *auth.AuthResponse {
+	switch req.Token {
+	case "orders/api":
+		return &auth.AuthResponse{Allowed: true, Identity: req.Token, Namespace: "orders", ServiceAccount: "api"}
+	case "billing/worker":
+		return &auth.AuthResponse{Allowed: true, Identity: req.Token, Namespace: "billing", ServiceAccount: "worker"}
+	case "oidc-user":
+		return &auth.AuthResponse{Allowed: true, Identity: req.Token}
+	}
+	return &auth.AuthResponse{Identity: "denied/sa", Namespace: "denied", ServiceAccount: "sa", Reason: auth.ReasonAccessDisabled}
+}
+
+// recordingAnnotator records annotations, failing for the failing ServiceAccount
+type recordingAnnotator struct {
+	annotated map[string]time.Time
+	failing   string
+}
+
+func (a *recordingAnnotator) AnnotateLastAuthenticated(_ context.Context, namespace, name string, at time.Time) error {
+	if namespace+"/"+name == a.failing {
+		return errors.New("forbidden")
+	}
+	a.annotated[namespace+"/"+name] = at
+	return nil
+}
+
+func TestTracker(t *testing.T) {
+	annotator := &recordingAnnotator{annotated: make(map[string]time.Time), failing: "billing/worker"}
+	tracker := New(zap.NewNop())
+	now := time.Unix(1700000000, 0)
+	tracker.now = func() time.Time { return now }
+
+	handler := tracker.WrapHandler(identityHandler{})
+	for _, token := range []string{"orders/api", "billing/worker", "oidc-user", "denied/sa"} {
+		handler.Authorize(&auth.AuthRequest{Token: token})
+	}
+
+	// Only the ServiceAccounts allowed are tracked, sorted
+	rec := httptest.NewRecorder()
+	tracker.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, Path, nil))
+	var snapshot Snapshot
+	if err := json.NewDecoder(rec.Body).Decode(&snapshot); err != nil {
+		t.Fatalf("invalid snapshot %s: %v", rec.Body, err)
+	}
+	if len(snapshot.ServiceAccounts) != 2 || snapshot.ServiceAccounts[0].Namespace != "billing" ||
+		snapshot.ServiceAccounts[1].Name != "api" || !snapshot.ServiceAccounts[1].LastAuthenticated.Equal(now) {
+		t.Errorf("ServiceAccounts = %+v, want billing/worker and orders/api", snapshot.ServiceAccounts)
+	}
+
+	tracker.Annotate(context.Background(), annotator)
+	if len(annotator.annotated) != 1 || !annotator.annotated["orders/api"].Equal(now) {
+		t.Errorf("annotated = %v, want orders/api", annotator.annotated)
+	}
+
+	// Only ServiceAccounts that authenticated since their last annotation, or whose
+	// annotation failed, are annotated again
+	annotator.failing = ""
+	delete(annotator.annotated, "orders/api")
+	tracker.Annotate(context.Background(), annotator)
+	if _, found := annotator.annotated["orders/api"]; found || len(annotator.annotated) != 1 {
+		t.Errorf("annotated = %v, want only the retried billing/worker", annotator.annotated)
+	}
+
+	now = now.Add(time.Hour)
+	handler.Authorize(&auth.AuthRequest{Token: "orders/api"})
+	tracker.Annotate(context.Background(), annotator)
+	if !annotator.annotated["orders/api"].Equal(now) {
+		t.Errorf("orders/api annotated at %v, want %v", annotator.annotated["orders/api"], now)
+	}
+}
+
+// memoryRelay keeps the authentications published by each replica
+type memoryRelay struct {
+	replicas map[string]map[string]time.Time
+	replica  string
+}
+
+func (r *memoryRelay) Publish(_ context.Context, accounts map[string]time.Time) error {
+	r.replicas[r.replica] = accounts
+	return nil
+}
+
+func (r *memoryRelay) Collect(context.Context) (map[string]time.Time, error) {
+	merged := make(map[string]time.Time)
+	for _, accounts := range r.replicas {
+		for identity, at := range accounts {
+			if at.After(merged[identity]) {
+				merged[identity] = at
+			}
+		}
+	}
+	return merged, nil
+}
+
+// TestTracker_Relay tests that the leader annotates the authentications relayed by other replicas
+func TestTracker_Relay(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	replicas := make(map[string]map[string]time.Time)
+
+	follower := New(zap.NewNop())
+	follower.now = func() time.Time { return now }
+	follower.Record("orders", "api")
+	follower.RecordAt("billing", "worker", now.Add(-3*time.Hour))
+
+	// Only recent authentications are relayed
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	follower.RunRelay(ctx, &memoryRelay{replicas: replicas, replica: "follower"}, time.Hour)
+	if got := replicas["follower"]; len(got) != 1 || !got["orders/api"].Equal(now) {
+		t.Errorf("relayed = %v, want orders/api", got)
+	}
+
+	leader := New(zap.NewNop())
+	leader.RecordAt("orders", "api", now.Add(-time.Minute))
+	leader.RecordAt("billing", "worker", now)
+	annotator := &recordingAnnotator{annotated: make(map[string]time.Time)}
+	leader.Run(ctx, annotator, &memoryRelay{replicas: replicas, replica: "leader"}, time.Hour)
+	if !annotator.annotated["orders/api"].Equal(now) || !annotator.annotated["billing/worker"].Equal(now) {
+		t.Errorf("annotated = %v, want the latest authentication of both", annotator.annotated)
+	}
+}
@@ -0,0 +1,469 @@
+package logging
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	httpmetrics "github.com/portswigger-tim/nats-k8s-oidc-callout/internal/httpserver"
+)
+
+// Audit export defaults
+const (
+	auditQueueSize       = 4096
+	auditBatchSize       = 256
+	auditFlushInterval   = time.Second
+	auditSendTimeout     = 5 * time.Second
+	auditRetries         = 3
+	auditMaxRetryBackoff = 30 * time.Second
+)
+
+// auditRetryBackoff is the wait before the first retry of a failed batch, doubling with each
+// further attempt; a variable so tests can shorten it
+var auditRetryBackoff = 500 * time.Millisecond
+
+// Audit export formats
+const (
+	AuditFormatJSON = "json"
+	AuditFormatCEF  = "cef"
+)
+
+// Audit export delivery guarantees
+const (
+	// AuditDeliveryBestEffort never holds up authorizations: records beyond the queue are
+	// dropped, and a batch is dropped after a few failed attempts
+	AuditDeliveryBestEffort = "best-effort"
+	// AuditDeliveryAtLeastOnce drops no records: authorizations wait for room in the queue, and
+	// failed batches are retried until delivered, so a record may be delivered more than once
+	AuditDeliveryAtLeastOnce = "at-least-once"
+)
+
+// Causes of dropped audit records, the cause label of nats_auth_audit_dropped_total
+const (
+	auditDropQueueFull   = "queue_full"
+	auditDropSendFailed  = "send_failed"
+	auditDropFormatError = "format_error"
+)
+
+// auditLogger is the name of the logger writing the authorization decisions
+const auditLogger = "audit"
+
+// AuditExportOptions configure an AuditExporter
+type AuditExportOptions struct {
+	// Sink is where records are sent: "stdout", a file path, tcp://host:port or udp://host:port
+	// for newline-delimited records (one datagram each over UDP), an http(s) URL records are
+	// POSTed to in newline-delimited batches, or nats://host:port/subject (tls:// for TLS) to
+	// publish each record on subject
+	Sink string
+	// Format is AuditFormatJSON or AuditFormatCEF
+	Format string
+	// Fields maps JSON output fields to audit record fields (plus "timestamp" and "message"),
+	// renaming them for a SIEM schema such as ECS; only mapped fields are exported. When empty,
+	// every field is exported under its own name.
+	Fields map[string]string
+	// Token is sent as the Authorization header of HTTP sinks, e.g. "Splunk <token>"
+	Token string
+	// Version is the product version of CEF records
+	Version string
+	// Delivery is AuditDeliveryBestEffort (the default) or AuditDeliveryAtLeastOnce
+	Delivery string
+	// QueueSize is the number of records buffered for export (default 4096)
+	QueueSize int
+	// FileMaxSize rotates a file sink once it would grow beyond this many bytes (0 = never),
+	// keeping FileMaxBackups rotated files as <path>.1 (the newest) to <path>.<FileMaxBackups>
+	FileMaxSize    int64
+	FileMaxBackups int
+	// NATSCredsFile is the user credentials file of NATS sinks
+	NATSCredsFile string
+}
+
+// AuditSink delivers batches of formatted records. Write is only called from the exporter's
+// goroutine; an error fails the whole batch, which is then retried.
+type AuditSink interface {
+	Write(records [][]byte) error
+	Close() error
+}
+
+// AuditExporter ships the audit logger's authorization decisions to a SIEM in JSON or CEF.
+// Records are queued and sent in batches from a background goroutine. With best-effort
+// delivery, failed batches are retried a few times and records beyond the queue are dropped
+// rather than blocking the authorization path, which still logs them to stdout. With
+// at-least-once delivery, nothing is dropped while the process runs: authorizations wait for
+// room in the queue and batches are retried until the sink accepts them.
+type AuditExporter struct {
+	sink        AuditSink
+	format      func(auditRecord) ([]byte, error)
+	atLeastOnce bool
+	errors      *zap.Logger // reports export failures; must not write to this exporter
+
+	queue chan auditRecord
+	stop  chan struct{} // closed by Close to send the queued records and finish
+	abort chan struct{} // closed when Close gives up, to stop retrying
+	done  chan struct{}
+
+	closeOnce sync.Once
+	abortOnce sync.Once
+	mu        sync.Mutex
+	dropped   int  // records dropped from the full queue since the last report
+	failing   bool // whether the last batch failed, or is being retried after failing repeatedly
+}
+
+// auditRecord is one audit log entry
+type auditRecord struct {
+	time    time.Time
+	message string
+	fields  map[string]interface{}
+}
+
+// NewAuditExporter opens the sink of the options and starts an exporter for it. Export
+// failures are reported to errLogger, which must not include the exporter.
+func NewAuditExporter(opts AuditExportOptions, errLogger *zap.Logger) (*AuditExporter, error) {
+	if opts.Delivery == AuditDeliveryAtLeastOnce {
+		if u, err := url.Parse(opts.Sink); err == nil && (u.Scheme == "tcp" || u.Scheme == "udp") {
+			return nil, fmt.Errorf("%s delivery needs a sink that acknowledges records, not %s", AuditDeliveryAtLeastOnce, u.Scheme)
+		}
+	}
+	sink, err := OpenAuditSink(opts)
+	if err != nil {
+		return nil, err
+	}
+	e, err := NewAuditExporterWithSink(sink, opts, errLogger)
+	if err != nil {
+		_ = sink.Close()
+		return nil, err
+	}
+	return e, nil
+}
+
+// NewAuditExporterWithSink starts an exporter delivering to sink; opts.Sink and the options of
+// the built-in sinks are ignored. The exporter closes the sink when it is closed.
+func NewAuditExporterWithSink(sink AuditSink, opts AuditExportOptions, errLogger *zap.Logger) (*AuditExporter, error) {
+	queueSize := opts.QueueSize
+	if queueSize <= 0 {
+		queueSize = auditQueueSize
+	}
+	e := &AuditExporter{
+		sink:   sink,
+		errors: errLogger,
+		queue:  make(chan auditRecord, queueSize),
+		stop:   make(chan struct{}),
+		abort:  make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+
+	switch opts.Format {
+	case AuditFormatJSON, "":
+		e.format = func(r auditRecord) ([]byte, error) { return auditJSON(r, opts.Fields) }
+	case AuditFormatCEF:
+		e.format = func(r auditRecord) ([]byte, error) { return auditCEF(r, opts.Version), nil }
+	default:
+		return nil, fmt.Errorf("unsupported audit export format %q (want %s or %s)", opts.Format, AuditFormatJSON, AuditFormatCEF)
+	}
+	switch opts.Delivery {
+	case AuditDeliveryBestEffort, "":
+	case AuditDeliveryAtLeastOnce:
+		e.atLeastOnce = true
+	default:
+		return nil, fmt.Errorf("unsupported audit export delivery %q (want %s or %s)", opts.Delivery, AuditDeliveryBestEffort, AuditDeliveryAtLeastOnce)
+	}
+
+	go e.run()
+	return e, nil
+}
+
+// Core returns a zapcore.Core exporting the audit logger's records, whatever the log level
+// of stdout, for teeing with the stdout core
+func (e *AuditExporter) Core() zapcore.Core {
+	return &auditCore{exporter: e}
+}
+
+// Close sends the queued records and stops the exporter, giving up when the context ends.
+// Records still queued or being retried then are dropped.
+func (e *AuditExporter) Close(ctx context.Context) error {
+	e.closeOnce.Do(func() { close(e.stop) })
+	select {
+	case <-e.done:
+		return e.sink.Close()
+	case <-ctx.Done():
+		e.abortOnce.Do(func() { close(e.abort) })
+		return fmt.Errorf("audit export did not finish: %w", ctx.Err())
+	}
+}
+
+// enqueue queues a record for export. When the queue is full, the record is dropped, or with
+// at-least-once delivery the caller waits until there is room or the exporter has stopped.
+func (e *AuditExporter) enqueue(record auditRecord) {
+	select {
+	case e.queue <- record:
+		httpmetrics.SetAuditQueued(len(e.queue))
+		return
+	default:
+	}
+	if e.atLeastOnce {
+		select {
+		case e.queue <- record:
+			httpmetrics.SetAuditQueued(len(e.queue))
+			return
+		case <-e.done:
+		}
+	}
+	httpmetrics.AddAuditDropped(auditDropQueueFull, 1)
+	e.mu.Lock()
+	e.dropped++
+	e.mu.Unlock()
+}
+
+// run batches queued records until the exporter is closed
+func (e *AuditExporter) run() {
+	defer close(e.done)
+
+	ticker := time.NewTicker(auditFlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]auditRecord, 0, auditBatchSize)
+	send := func() {
+		if len(batch) > 0 {
+			e.send(batch)
+			batch = batch[:0]
+		}
+		httpmetrics.SetAuditQueued(len(e.queue))
+	}
+
+	for {
+		select {
+		case record := <-e.queue:
+			batch = append(batch, record)
+			if len(batch) >= auditBatchSize {
+				send()
+			}
+		case <-ticker.C:
+			send()
+		case <-e.stop:
+			for {
+				select {
+				case record := <-e.queue:
+					batch = append(batch, record)
+					if len(batch) >= auditBatchSize {
+						send()
+					}
+				default:
+					send()
+					return
+				}
+			}
+		}
+	}
+}
+
+// send formats and delivers a batch with retries. Failures are reported once until a batch
+// succeeds again, so an unreachable sink does not flood the logs.
+func (e *AuditExporter) send(batch []auditRecord) {
+	lines := make([][]byte, 0, len(batch))
+	for _, record := range batch {
+		line, err := e.format(record)
+		if err != nil {
+			httpmetrics.AddAuditDropped(auditDropFormatError, 1)
+			e.errors.Warn("failed to format audit record; dropping it", zap.Error(err))
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) == 0 {
+		return
+	}
+
+	err := e.sink.Write(lines)
+	for attempt := 1; err != nil && e.retry(attempt, err); attempt++ {
+		httpmetrics.IncrementAuditRetries()
+		err = e.sink.Write(lines)
+	}
+	if err == nil {
+		httpmetrics.AddAuditExported(len(lines))
+	} else {
+		httpmetrics.AddAuditDropped(auditDropSendFailed, len(lines))
+	}
+
+	e.mu.Lock()
+	dropped, wasFailing := e.dropped, e.failing
+	e.dropped, e.failing = 0, err != nil
+	e.mu.Unlock()
+
+	switch {
+	case err != nil && (!wasFailing || e.atLeastOnce):
+		e.errors.Warn("failed to export audit records; dropping the batch",
+			zap.Int("records", len(lines)), zap.Error(err))
+	case err == nil && wasFailing:
+		e.errors.Info("audit export recovered")
+	}
+	if dropped > 0 {
+		e.errors.Warn("dropped audit records while the export queue was full", zap.Int("records", dropped))
+	}
+}
+
+// retry waits before another attempt at a failed batch and reports whether to make it.
+// Best-effort delivery gives up after a few attempts, or at once when the exporter is closing
+// so shutdown is not held up; at-least-once delivery keeps trying, with the backoff capped,
+// until Close gives up.
+func (e *AuditExporter) retry(attempt int, err error) bool {
+	if !e.atLeastOnce && attempt >= auditRetries {
+		return false
+	}
+	if e.atLeastOnce && attempt == auditRetries {
+		// Waiting on the sink holds up authorizations once the queue fills, so say so early
+		e.mu.Lock()
+		wasFailing := e.failing
+		e.failing = true
+		e.mu.Unlock()
+		if !wasFailing {
+			e.errors.Warn("failed to export audit records; retrying until the sink accepts them", zap.Error(err))
+		}
+	}
+	stop := e.stop
+	if e.atLeastOnce {
+		stop = e.abort
+	}
+	backoff := min(auditRetryBackoff<<min(attempt-1, 6), auditMaxRetryBackoff)
+	select {
+	case <-time.After(backoff):
+		return true
+	case <-stop:
+		return false
+	}
+}
+
+// auditCore is a zapcore.Core converting the audit logger's entries to audit records
+type auditCore struct {
+	exporter *AuditExporter
+	fields   []zapcore.Field
+}
+
+func (c *auditCore) Enabled(zapcore.Level) bool {
+	return true
+}
+
+func (c *auditCore) With(fields []zapcore.Field) zapcore.Core {
+	clone := *c
+	clone.fields = append(append([]zapcore.Field(nil), c.fields...), fields...)
+	return &clone
+}
+
+func (c *auditCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if ent.LoggerName == auditLogger {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *auditCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	enc := zapcore.NewMapObjectEncoder()
+	for _, field := range c.fields {
+		field.AddTo(enc)
+	}
+	for _, field := range fields {
+		field.AddTo(enc)
+	}
+	c.exporter.enqueue(auditRecord{time: ent.Time, message: ent.Message, fields: enc.Fields})
+	return nil
+}
+
+func (c *auditCore) Sync() error {
+	return nil
+}
+
+// auditJSON formats a record as a JSON object, renaming its fields by the mapping if one is set
+func auditJSON(r auditRecord, mapping map[string]string) ([]byte, error) {
+	source := func(name string) (interface{}, bool) {
+		switch name {
+		case "timestamp":
+			return r.time.UTC().Format(time.RFC3339Nano), true
+		case "message":
+			return r.message, true
+		}
+		value, ok := r.fields[name]
+		if d, isDuration := value.(time.Duration); isDuration {
+			return d.Seconds(), ok
+		}
+		return value, ok
+	}
+
+	out := make(map[string]interface{}, len(r.fields)+2)
+	if len(mapping) == 0 {
+		for name := range r.fields {
+			out[name], _ = source(name)
+		}
+		out["timestamp"], _ = source("timestamp")
+		out["message"], _ = source("message")
+	} else {
+		for target, name := range mapping {
+			if value, ok := source(name); ok {
+				out[target] = value
+			}
+		}
+	}
+	return json.Marshal(out)
+}
+
+// auditCEF formats a record in ArcSight Common Event Format, with the reason code as the
+// signature ID
+func auditCEF(r auditRecord, version string) []byte {
+	str := func(name string) string {
+		if value, ok := r.fields[name]; ok {
+			return fmt.Sprint(value)
+		}
+		return ""
+	}
+	allowed, _ := r.fields["allowed"].(bool)
+	name, severity, outcome := "authorization denied", 5, "failure"
+	if allowed {
+		name, severity, outcome = "authorization allowed", 1, "success"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "CEF:0|PortSwigger|nats-k8s-oidc-callout|%s|%s|%s|%d|",
+		cefHeader(version), cefHeader(str("reason")), name, severity)
+
+	ext := []struct{ key, value string }{
+		{"rt", fmt.Sprint(r.time.UnixMilli())},
+		{"outcome", outcome},
+		{"reason", str("reason")},
+		{"src", str("client_host")},
+		{"suser", str("identity")},
+		{"cs1Label", "requestId"}, {"cs1", str("request_id")},
+		{"cs2Label", "account"}, {"cs2", str("account")},
+		{"cs3Label", "clientName"}, {"cs3", str("client_name")},
+		{"cs4Label", "userNkey"}, {"cs4", str("user_nkey")},
+		{"cs5Label", "role"}, {"cs5", str("role")},
+	}
+	first := true
+	for i, e := range ext {
+		// Labels are only written with their value
+		if e.value == "" || (strings.HasSuffix(e.key, "Label") && ext[i+1].value == "") {
+			continue
+		}
+		if !first {
+			b.WriteByte(' ')
+		}
+		first = false
+		b.WriteString(e.key)
+		b.WriteByte('=')
+		b.WriteString(cefExtension(e.value))
+	}
+	return []byte(b.String())
+}
+
+// cefHeader escapes a CEF header field
+func cefHeader(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\n", " ", "\r", " ").Replace(s)
+}
+
+// cefExtension escapes a CEF extension value
+func cefExtension(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\n", `\n`, "\r", `\r`).Replace(s)
+}
@@ -0,0 +1,332 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	natsclient "github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// auditStdout is where the stdout sink writes, replaced in tests
+var auditStdout io.Writer = os.Stdout
+
+// OpenAuditSink opens the sink named by opts.Sink
+func OpenAuditSink(opts AuditExportOptions) (AuditSink, error) {
+	target := opts.Sink
+	if target == "stdout" {
+		return &writerSink{w: auditStdout}, nil
+	}
+	u, err := url.Parse(target)
+	if err != nil || u.Scheme == "" {
+		// A plain path
+		return openFileSink(target, opts.FileMaxSize, opts.FileMaxBackups, opts.Delivery == AuditDeliveryAtLeastOnce)
+	}
+	switch u.Scheme {
+	case "tcp", "udp":
+		if u.Host == "" {
+			return nil, fmt.Errorf("audit export sink %s has no address", target)
+		}
+		return &socketSink{network: u.Scheme, address: u.Host}, nil
+	case "http", "https":
+		return &httpSink{url: target, token: opts.Token, client: &http.Client{Timeout: auditSendTimeout}}, nil
+	case "nats", "tls":
+		return openNATSSink(u, opts.NATSCredsFile, opts.Delivery == AuditDeliveryAtLeastOnce)
+	default:
+		return nil, fmt.Errorf("unsupported audit export sink scheme %q (want stdout, a path, tcp, udp, http, https or nats)", u.Scheme)
+	}
+}
+
+// newlineDelimited joins records into one newline-terminated buffer
+func newlineDelimited(records [][]byte) []byte {
+	var buf bytes.Buffer
+	for _, record := range records {
+		buf.Write(record)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
+
+// writerSink writes newline-delimited records to a writer, such as stdout
+type writerSink struct {
+	w io.Writer
+}
+
+func (s *writerSink) Write(records [][]byte) error {
+	_, err := s.w.Write(newlineDelimited(records))
+	return err
+}
+
+func (s *writerSink) Close() error {
+	return nil
+}
+
+// fileSink appends newline-delimited records to a file, rotating it by size when maxSize is
+// set. Each batch is written whole to one file, so a file may exceed maxSize by a batch.
+type fileSink struct {
+	path       string
+	maxSize    int64 // 0 = never rotate
+	maxBackups int
+	sync       bool // sync each batch to disk before acknowledging it
+
+	file *os.File
+	size int64
+}
+
+// openFileSink opens the file at path for appending
+func openFileSink(path string, maxSize int64, maxBackups int, sync bool) (*fileSink, error) {
+	s := &fileSink{path: path, maxSize: maxSize, maxBackups: maxBackups, sync: sync}
+	if err := s.open(); err != nil {
+		return nil, fmt.Errorf("failed to open audit export file: %w", err)
+	}
+	return s, nil
+}
+
+// open opens the file, creating it if needed
+func (s *fileSink) open() error {
+	file, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600) //nolint:gosec // path comes from configuration
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	s.file, s.size = file, info.Size()
+	return nil
+}
+
+func (s *fileSink) Write(records [][]byte) error {
+	data := newlineDelimited(records)
+	if s.maxSize > 0 && s.size > 0 && s.size+int64(len(data)) > s.maxSize {
+		if err := s.rotate(); err != nil {
+			return fmt.Errorf("failed to rotate audit export file: %w", err)
+		}
+	}
+	if s.file == nil {
+		// A previous rotation closed the file but failed to reopen it
+		if err := s.open(); err != nil {
+			return err
+		}
+	}
+
+	n, err := s.file.Write(data)
+	s.size += int64(n)
+	if err != nil {
+		return err
+	}
+	if s.sync {
+		return s.file.Sync()
+	}
+	return nil
+}
+
+// rotate renames the file to <path>.1, shifting older backups up and removing the oldest, and
+// opens a new file
+func (s *fileSink) rotate() error {
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+	s.file = nil
+
+	backup := func(n int) string { return fmt.Sprintf("%s.%d", s.path, n) }
+	if err := os.Remove(backup(s.maxBackups)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	for n := s.maxBackups - 1; n >= 1; n-- {
+		if err := os.Rename(backup(n), backup(n+1)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	if s.maxBackups > 0 {
+		if err := os.Rename(s.path, backup(1)); err != nil {
+			return err
+		}
+	} else if err := os.Remove(s.path); err != nil {
+		return err
+	}
+	return s.open()
+}
+
+func (s *fileSink) Close() error {
+	if s.file == nil {
+		return nil
+	}
+	return s.file.Close()
+}
+
+// socketSink sends records over TCP, newline-delimited on one connection that is re-dialled
+// after a failure, or over UDP, one datagram per record
+type socketSink struct {
+	network string
+	address string
+	conn    net.Conn
+}
+
+func (s *socketSink) Write(records [][]byte) error {
+	if s.conn == nil {
+		conn, err := net.DialTimeout(s.network, s.address, auditSendTimeout)
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+	if err := s.conn.SetWriteDeadline(time.Now().Add(auditSendTimeout)); err != nil {
+		return s.reset(err)
+	}
+
+	if s.network == "udp" {
+		for _, record := range records {
+			if _, err := s.conn.Write(record); err != nil {
+				return s.reset(err)
+			}
+		}
+		return nil
+	}
+	if _, err := s.conn.Write(newlineDelimited(records)); err != nil {
+		return s.reset(err)
+	}
+	return nil
+}
+
+// reset closes the connection after a failure, so the next write dials again
+func (s *socketSink) reset(err error) error {
+	_ = s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+func (s *socketSink) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.Close()
+}
+
+// httpSink POSTs batches of newline-delimited records
+type httpSink struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+func (s *httpSink) Write(records [][]byte) error {
+	body := bytes.Join(records, []byte{'\n'})
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	if s.token != "" {
+		req.Header.Set("Authorization", s.token)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		// Drop the URL from the error, since it may carry credentials
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return urlErr.Err
+		}
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("audit sink returned %s", resp.Status)
+	}
+	return nil
+}
+
+func (s *httpSink) Close() error {
+	return nil
+}
+
+// natsSink publishes each record as a message on a subject. A batch is delivered once the
+// server has received it, or with JetStream once a stream has stored every record.
+type natsSink struct {
+	conn    *natsclient.Conn
+	js      jetstream.JetStream // nil unless records must be stored by a stream
+	subject string
+}
+
+// openNATSSink connects to the server of a nats://host:port/subject URL. The connection is
+// retried in the background, so an unreachable server fails the first batches rather than
+// startup.
+func openNATSSink(u *url.URL, credsFile string, jetStream bool) (*natsSink, error) {
+	subject := strings.TrimPrefix(u.Path, "/")
+	if u.Host == "" || subject == "" {
+		return nil, fmt.Errorf("audit export sink must be nats://host:port/subject")
+	}
+	server := *u
+	server.Path = ""
+
+	opts := []natsclient.Option{
+		natsclient.Name("nats-k8s-oidc-callout-audit"),
+		natsclient.Timeout(auditSendTimeout),
+		natsclient.MaxReconnects(-1),
+		natsclient.RetryOnFailedConnect(true),
+	}
+	if credsFile != "" {
+		opts = append(opts, natsclient.UserCredentials(credsFile))
+	}
+	conn, err := natsclient.Connect(server.String(), opts...)
+	if err != nil {
+		// The URL is left out of the error, since it may carry credentials
+		return nil, fmt.Errorf("failed to connect to the audit export NATS server: %w", err)
+	}
+
+	s := &natsSink{conn: conn, subject: subject}
+	if jetStream {
+		if s.js, err = jetstream.New(conn); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to create JetStream context for audit export: %w", err)
+		}
+	}
+	return s, nil
+}
+
+func (s *natsSink) Write(records [][]byte) error {
+	if s.js == nil {
+		for _, record := range records {
+			if err := s.conn.Publish(s.subject, record); err != nil {
+				return err
+			}
+		}
+		return s.conn.FlushTimeout(auditSendTimeout)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), auditSendTimeout)
+	defer cancel()
+	acks := make([]jetstream.PubAckFuture, 0, len(records))
+	for _, record := range records {
+		ack, err := s.js.PublishAsync(s.subject, record)
+		if err != nil {
+			return err
+		}
+		acks = append(acks, ack)
+	}
+	for _, ack := range acks {
+		select {
+		case <-ack.Ok():
+		case err := <-ack.Err():
+			return err
+		case <-ctx.Done():
+			return fmt.Errorf("timed out waiting for JetStream to store audit records")
+		}
+	}
+	return nil
+}
+
+func (s *natsSink) Close() error {
+	return s.conn.Drain()
+}
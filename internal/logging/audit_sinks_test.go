@@ -0,0 +1,196 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	natsclient "github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"go.uber.org/zap"
+)
+
+// flakySink fails the first writes, then records the batches it accepts
+type flakySink struct {
+	mu       sync.Mutex
+	failures int
+	writes   int
+	records  []string
+}
+
+func (s *flakySink) Write(records [][]byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.writes++
+	if s.writes <= s.failures {
+		return errors.New("sink unavailable")
+	}
+	for _, record := range records {
+		s.records = append(s.records, string(record))
+	}
+	return nil
+}
+
+func (s *flakySink) Close() error {
+	return nil
+}
+
+func TestAuditExporter_AtLeastOnce(t *testing.T) {
+	defer func(backoff time.Duration) { auditRetryBackoff = backoff }(auditRetryBackoff)
+	auditRetryBackoff = time.Millisecond
+
+	// More failures than best-effort delivery retries
+	sink := &flakySink{failures: auditRetries + 2}
+	exporter, err := NewAuditExporterWithSink(sink, AuditExportOptions{Delivery: AuditDeliveryAtLeastOnce, QueueSize: 1}, zap.NewNop())
+	if err != nil {
+		t.Fatalf("NewAuditExporterWithSink() error = %v", err)
+	}
+	// Beyond the queue, records wait for room rather than being dropped
+	for range 3 {
+		logDecision(exporter)
+	}
+	if err := exporter.Close(context.Background()); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if len(sink.records) != 3 {
+		t.Errorf("delivered %d records after %d attempts, want 3", len(sink.records), sink.writes)
+	}
+}
+
+func TestAuditExporter_BestEffortDropsFailedBatch(t *testing.T) {
+	defer func(backoff time.Duration) { auditRetryBackoff = backoff }(auditRetryBackoff)
+	auditRetryBackoff = time.Millisecond
+
+	sink := &flakySink{failures: auditRetries}
+	exporter, err := NewAuditExporterWithSink(sink, AuditExportOptions{}, zap.NewNop())
+	if err != nil {
+		t.Fatalf("NewAuditExporterWithSink() error = %v", err)
+	}
+	logDecision(exporter)
+
+	// Closing gives up retrying at once, so wait for the flush to run its course
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		sink.mu.Lock()
+		writes := sink.writes
+		sink.mu.Unlock()
+		if writes >= auditRetries || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := exporter.Close(context.Background()); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if sink.writes != auditRetries || len(sink.records) != 0 {
+		t.Errorf("writes = %d, records = %v; want the batch dropped after %d attempts", sink.writes, sink.records, auditRetries)
+	}
+}
+
+func TestAuditExporter_Stdout(t *testing.T) {
+	var buf bytes.Buffer
+	defer func(w *os.File) { auditStdout = w }(os.Stdout)
+	auditStdout = &buf
+
+	exporter, err := NewAuditExporter(AuditExportOptions{Sink: "stdout", Format: AuditFormatCEF}, zap.NewNop())
+	if err != nil {
+		t.Fatalf("NewAuditExporter() error = %v", err)
+	}
+	logDecision(exporter)
+	if err := exporter.Close(context.Background()); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if !strings.HasPrefix(buf.String(), "CEF:0|") || !strings.HasSuffix(buf.String(), "\n") {
+		t.Errorf("stdout = %q, want one CEF record", buf.String())
+	}
+}
+
+func TestFileSink_Rotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	sink, err := openFileSink(path, 10, 2, false)
+	if err != nil {
+		t.Fatalf("openFileSink() error = %v", err)
+	}
+	for i := range 4 {
+		// Each batch is 9 bytes, so every batch after the first rotates the file
+		if err := sink.Write([][]byte{[]byte(fmt.Sprintf("record-%d", i))}); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		path:        "record-3\n",
+		path + ".1": "record-2\n",
+		path + ".2": "record-1\n",
+	}
+	for file, content := range want {
+		data, err := os.ReadFile(file)
+		if err != nil || string(data) != content {
+			t.Errorf("%s = %q (%v), want %q", filepath.Base(file), data, err, content)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("want at most 2 backups, found %s.3", filepath.Base(path))
+	}
+}
+
+func TestNATSSink_JetStream(t *testing.T) {
+	srv, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: -1, JetStream: true, StoreDir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Start()
+	if !srv.ReadyForConnections(5 * time.Second) {
+		t.Fatal("NATS server not ready")
+	}
+	t.Cleanup(srv.Shutdown)
+
+	conn, err := natsclient.Connect(srv.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(conn.Close)
+	js, _ := jetstream.New(conn)
+	ctx := context.Background()
+	stream, err := js.CreateStream(ctx, jetstream.StreamConfig{Name: "AUDIT", Subjects: []string{"audit.>"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exporter, err := NewAuditExporter(AuditExportOptions{
+		Sink:     srv.ClientURL() + "/audit.decisions",
+		Delivery: AuditDeliveryAtLeastOnce,
+	}, zap.NewNop())
+	if err != nil {
+		t.Fatalf("NewAuditExporter() error = %v", err)
+	}
+	logDecision(exporter)
+	if err := exporter.Close(context.Background()); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	msg, err := stream.GetLastMsgForSubject(ctx, "audit.decisions")
+	if err != nil {
+		t.Fatalf("record not stored: %v", err)
+	}
+	if !strings.Contains(string(msg.Data), `"reason":"unknown_serviceaccount"`) {
+		t.Errorf("stored record = %s, want the decision", msg.Data)
+	}
+}
@@ -0,0 +1,150 @@
+package logging
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// logDecision writes an authorization decision to the exporter's core, and a record from
+// another logger that must not be exported
+func logDecision(exporter *AuditExporter) {
+	logger := zap.New(exporter.Core()).With(zap.String("request_id", "req-1"))
+	logger.Info("not an audit record")
+	logger.Named("audit").Info("authorization decision",
+		zap.Bool("allowed", false),
+		zap.String("reason", "unknown_serviceaccount"),
+		zap.String("identity", "orders/api"),
+		zap.String("account", "APP"),
+		zap.String("client_host", "10.0.3.17"),
+		zap.String("client_name", "orders=api"))
+}
+
+func TestAuditExporter_FileJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	exporter, err := NewAuditExporter(AuditExportOptions{
+		Sink:   path,
+		Format: AuditFormatJSON,
+		Fields: map[string]string{"@timestamp": "timestamp", "event.reason": "reason", "user.name": "identity", "source.ip": "client_host"},
+	}, zap.NewNop())
+	if err != nil {
+		t.Fatalf("NewAuditExporter() error = %v", err)
+	}
+	logDecision(exporter)
+	if err := exporter.Close(context.Background()); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("exported %d records, want 1: %s", len(lines), data)
+	}
+	var record map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &record); err != nil {
+		t.Fatalf("record %s is not JSON: %v", lines[0], err)
+	}
+	if len(record) != 4 || record["event.reason"] != "unknown_serviceaccount" || record["user.name"] != "orders/api" ||
+		record["source.ip"] != "10.0.3.17" || record["@timestamp"] == nil {
+		t.Errorf("record = %v, want only the mapped fields", record)
+	}
+}
+
+func TestAuditExporter_HTTPCEF(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		attempts int
+		body     string
+	)
+	sink := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts++
+		if attempts == 1 {
+			// The first attempt fails and is retried
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if got := r.Header.Get("Authorization"); got != "Splunk s3cret" {
+			t.Errorf("Authorization = %q, want the token", got)
+		}
+		data, _ := io.ReadAll(r.Body)
+		body = string(data)
+	}))
+	defer sink.Close()
+
+	exporter, err := NewAuditExporter(AuditExportOptions{
+		Sink:    sink.URL,
+		Format:  AuditFormatCEF,
+		Token:   "Splunk s3cret",
+		Version: "v1.2.3",
+	}, zap.NewNop())
+	if err != nil {
+		t.Fatalf("NewAuditExporter() error = %v", err)
+	}
+	logDecision(exporter)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mu.Lock()
+		done := body != ""
+		mu.Unlock()
+		if done || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if err := exporter.Close(context.Background()); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	wantPrefix := "CEF:0|PortSwigger|nats-k8s-oidc-callout|v1.2.3|unknown_serviceaccount|authorization denied|5|rt="
+	if !strings.HasPrefix(body, wantPrefix) {
+		t.Errorf("record = %q, want prefix %q", body, wantPrefix)
+	}
+	for _, want := range []string{"outcome=failure", "src=10.0.3.17", "suser=orders/api", "cs1Label=requestId cs1=req-1", `cs3=orders\=api`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("record = %q, want it to contain %q", body, want)
+		}
+	}
+	if strings.Contains(body, "cs5Label") {
+		t.Errorf("record = %q, want no label for the empty role", body)
+	}
+}
+
+func TestNewAuditExporter_Invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		opts AuditExportOptions
+	}{
+		{name: "unknown format", opts: AuditExportOptions{Sink: filepath.Join(t.TempDir(), "audit.log"), Format: "leef"}},
+		{name: "unknown scheme", opts: AuditExportOptions{Sink: "ftp://siem:21"}},
+		{name: "socket without address", opts: AuditExportOptions{Sink: "tcp://"}},
+		{name: "unwritable file", opts: AuditExportOptions{Sink: filepath.Join(t.TempDir(), "missing", "audit.log")}},
+		{name: "unknown delivery", opts: AuditExportOptions{Sink: filepath.Join(t.TempDir(), "audit.log"), Delivery: "exactly-once"}},
+		{name: "at-least-once over udp", opts: AuditExportOptions{Sink: "udp://siem:514", Delivery: AuditDeliveryAtLeastOnce}},
+		{name: "nats without subject", opts: AuditExportOptions{Sink: "nats://nats:4222"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewAuditExporter(tt.opts, zap.NewNop()); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
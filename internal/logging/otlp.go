@@ -0,0 +1,435 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// OTLP export defaults
+const (
+	otlpQueueSize     = 4096
+	otlpBatchSize     = 512
+	otlpFlushInterval = 2 * time.Second
+	otlpSendTimeout   = 5 * time.Second
+)
+
+// OTLPExporter ships log entries to an OpenTelemetry collector over OTLP/HTTP with JSON
+// encoding. Entries are queued and sent in batches from a background goroutine; when the
+// collector falls behind, entries beyond the queue are dropped rather than blocking the
+// authorization path, which still logs to stdout.
+type OTLPExporter struct {
+	*otlpBatcher[otlpLogRecord]
+	resource []otlpKeyValue
+}
+
+// NewOTLPExporter starts an exporter sending to the OTLP/HTTP logs endpoint (for example
+// http://otel-collector:4318/v1/logs), describing every entry with the resource attributes.
+// Export failures are reported to errLogger, which must not include the exporter.
+func NewOTLPExporter(endpoint string, resource map[string]string, errLogger *zap.Logger) *OTLPExporter {
+	e := &OTLPExporter{resource: otlpResourceAttributes(resource)}
+	e.otlpBatcher = newOTLPBatcher(endpoint, "log", e.encode, errLogger)
+	return e
+}
+
+// Core returns a zapcore.Core exporting entries enabled by the level, for teeing with the
+// stdout core
+func (e *OTLPExporter) Core(level zapcore.LevelEnabler) zapcore.Core {
+	return &otlpCore{LevelEnabler: level, exporter: e}
+}
+
+// encode encodes a batch as an OTLP ExportLogsServiceRequest, grouping records by logger name
+// into instrumentation scopes (audit records have the scope "audit")
+func (e *OTLPExporter) encode(batch []otlpLogRecord) ([]byte, error) {
+	var scopes []otlpScopeLogs
+	index := make(map[string]int)
+	for _, record := range batch {
+		i, ok := index[record.scope]
+		if !ok {
+			i = len(scopes)
+			index[record.scope] = i
+			scopes = append(scopes, otlpScopeLogs{Scope: otlpScope{Name: record.scope}})
+		}
+		scopes[i].LogRecords = append(scopes[i].LogRecords, record)
+	}
+
+	return json.Marshal(otlpExportRequest{ResourceLogs: []otlpResourceLogs{{
+		Resource:  otlpResource{Attributes: e.resource},
+		ScopeLogs: scopes,
+	}}})
+}
+
+// otlpBatcher queues items and posts them in batches to an OTLP/HTTP endpoint from a
+// background goroutine, dropping items beyond the queue rather than blocking the caller
+type otlpBatcher[T any] struct {
+	endpoint string
+	signal   string // "log" or "span", in failure reports
+	encode   func([]T) ([]byte, error)
+	client   *http.Client
+	errors   *zap.Logger // reports export failures; must not write to an exporter
+
+	queue   chan T
+	flushes chan chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+
+	closeOnce sync.Once
+	mu        sync.Mutex
+	dropped   int  // items dropped since the last report
+	failing   bool // whether the last export failed
+}
+
+// newOTLPBatcher starts a batcher posting the batches encode produces to the endpoint
+func newOTLPBatcher[T any](endpoint, signal string, encode func([]T) ([]byte, error), errLogger *zap.Logger) *otlpBatcher[T] {
+	b := &otlpBatcher[T]{
+		endpoint: endpoint,
+		signal:   signal,
+		encode:   encode,
+		client:   &http.Client{Timeout: otlpSendTimeout},
+		errors:   errLogger,
+		queue:    make(chan T, otlpQueueSize),
+		flushes:  make(chan chan struct{}),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go b.run()
+	return b
+}
+
+// Flush sends all queued items, returning once they are sent or the exporter is closed
+func (b *otlpBatcher[T]) Flush() {
+	flushed := make(chan struct{})
+	select {
+	case b.flushes <- flushed:
+		<-flushed
+	case <-b.done:
+	}
+}
+
+// Close sends the queued items and stops the exporter, giving up when the context ends
+func (b *otlpBatcher[T]) Close(ctx context.Context) error {
+	b.closeOnce.Do(func() { close(b.stop) })
+	select {
+	case <-b.done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("OTLP %s export did not finish: %w", b.signal, ctx.Err())
+	}
+}
+
+// enqueue queues an item for export, dropping it when the queue is full
+func (b *otlpBatcher[T]) enqueue(item T) {
+	select {
+	case b.queue <- item:
+	default:
+		b.mu.Lock()
+		b.dropped++
+		b.mu.Unlock()
+	}
+}
+
+// run batches queued items until the exporter is closed
+func (b *otlpBatcher[T]) run() {
+	defer close(b.done)
+
+	ticker := time.NewTicker(otlpFlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]T, 0, otlpBatchSize)
+	send := func() {
+		batch = b.drain(batch)
+		if len(batch) > 0 {
+			b.send(batch)
+			batch = batch[:0]
+		}
+	}
+
+	for {
+		select {
+		case item := <-b.queue:
+			batch = append(batch, item)
+			if len(batch) >= otlpBatchSize {
+				b.send(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			send()
+		case flushed := <-b.flushes:
+			send()
+			close(flushed)
+		case <-b.stop:
+			send()
+			return
+		}
+	}
+}
+
+// drain moves the queued items into the batch, sending full batches as they fill
+func (b *otlpBatcher[T]) drain(batch []T) []T {
+	for {
+		select {
+		case item := <-b.queue:
+			batch = append(batch, item)
+			if len(batch) >= otlpBatchSize {
+				b.send(batch)
+				batch = batch[:0]
+			}
+		default:
+			return batch
+		}
+	}
+}
+
+// send exports a batch. Failures are reported once until an export succeeds again, so an
+// unreachable collector does not flood the logs.
+func (b *otlpBatcher[T]) send(batch []T) {
+	err := b.post(batch)
+
+	b.mu.Lock()
+	dropped, wasFailing := b.dropped, b.failing
+	b.dropped, b.failing = 0, err != nil
+	b.mu.Unlock()
+
+	switch {
+	case err != nil && !wasFailing:
+		b.errors.Warn(fmt.Sprintf("failed to export %ss over OTLP; dropping the batch", b.signal),
+			zap.String("endpoint", b.endpoint), zap.Int("entries", len(batch)), zap.Error(err))
+	case err == nil && wasFailing:
+		b.errors.Info(fmt.Sprintf("OTLP %s export recovered", b.signal), zap.String("endpoint", b.endpoint))
+	}
+	if dropped > 0 {
+		b.errors.Warn(fmt.Sprintf("dropped %ss while the OTLP export queue was full", b.signal), zap.Int("entries", dropped))
+	}
+}
+
+// post sends an encoded batch to the endpoint
+func (b *otlpBatcher[T]) post(batch []T) error {
+	body, err := b.encode(batch)
+	if err != nil {
+		return fmt.Errorf("failed to encode %ss: %w", b.signal, err)
+	}
+
+	resp, err := b.client.Post(b.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("collector returned %s", resp.Status)
+	}
+	return nil
+}
+
+// otlpResourceAttributes converts resource attributes to OTLP attributes, sorted by key
+func otlpResourceAttributes(resource map[string]string) []otlpKeyValue {
+	keys := make([]string, 0, len(resource))
+	for key := range resource {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	attrs := make([]otlpKeyValue, 0, len(keys))
+	for _, key := range keys {
+		attrs = append(attrs, otlpKeyValue{Key: key, Value: otlpAnyValue{StringValue: ptr(resource[key])}})
+	}
+	return attrs
+}
+
+// otlpCore is a zapcore.Core converting entries to OTLP log records
+type otlpCore struct {
+	zapcore.LevelEnabler
+	exporter *OTLPExporter
+	fields   []zapcore.Field
+}
+
+func (c *otlpCore) With(fields []zapcore.Field) zapcore.Core {
+	clone := *c
+	clone.fields = append(append([]zapcore.Field(nil), c.fields...), fields...)
+	return &clone
+}
+
+func (c *otlpCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *otlpCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	enc := zapcore.NewMapObjectEncoder()
+	for _, field := range c.fields {
+		field.AddTo(enc)
+	}
+	for _, field := range fields {
+		field.AddTo(enc)
+	}
+	if ent.Stack != "" {
+		enc.AddString("exception.stacktrace", ent.Stack)
+	}
+
+	record := otlpLogRecord{
+		scope:          ent.LoggerName,
+		TimeUnixNano:   strconv.FormatInt(ent.Time.UnixNano(), 10),
+		SeverityNumber: otlpSeverity(ent.Level),
+		SeverityText:   ent.Level.CapitalString(),
+		Body:           otlpAnyValue{StringValue: ptr(ent.Message)},
+	}
+	// The trace context correlates the record with its span rather than being an attribute
+	if traceID, ok := enc.Fields[TraceIDKey].(string); ok {
+		record.TraceID = traceID
+		delete(enc.Fields, TraceIDKey)
+	}
+	if spanID, ok := enc.Fields[SpanIDKey].(string); ok {
+		record.SpanID = spanID
+		delete(enc.Fields, SpanIDKey)
+	}
+	record.Attributes = otlpAttributes(enc.Fields)
+
+	c.exporter.enqueue(record)
+	return nil
+}
+
+func (c *otlpCore) Sync() error {
+	c.exporter.Flush()
+	return nil
+}
+
+// otlpSeverity maps a zap level to an OTLP severity number
+func otlpSeverity(level zapcore.Level) int {
+	switch {
+	case level <= zapcore.DebugLevel:
+		return 5
+	case level == zapcore.InfoLevel:
+		return 9
+	case level == zapcore.WarnLevel:
+		return 13
+	case level == zapcore.ErrorLevel:
+		return 17
+	default:
+		return 21
+	}
+}
+
+// otlpAttributes converts encoded zap fields to OTLP attributes, sorted by key
+func otlpAttributes(fields map[string]interface{}) []otlpKeyValue {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	attrs := make([]otlpKeyValue, 0, len(keys))
+	for _, key := range keys {
+		attrs = append(attrs, otlpKeyValue{Key: key, Value: otlpValue(fields[key])})
+	}
+	return attrs
+}
+
+// otlpValue converts a value produced by zapcore.MapObjectEncoder to an OTLP value. Durations
+// are in seconds, matching the stdout encoding.
+func otlpValue(value interface{}) otlpAnyValue {
+	switch v := value.(type) {
+	case string:
+		return otlpAnyValue{StringValue: ptr(v)}
+	case bool:
+		return otlpAnyValue{BoolValue: ptr(v)}
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, uintptr:
+		return otlpAnyValue{IntValue: ptr(fmt.Sprint(v))}
+	case float32:
+		return otlpDouble(float64(v))
+	case float64:
+		return otlpDouble(v)
+	case time.Duration:
+		return otlpDouble(v.Seconds())
+	case time.Time:
+		return otlpAnyValue{StringValue: ptr(v.Format(time.RFC3339Nano))}
+	case []interface{}:
+		values := make([]otlpAnyValue, 0, len(v))
+		for _, element := range v {
+			values = append(values, otlpValue(element))
+		}
+		return otlpAnyValue{ArrayValue: &otlpArrayValue{Values: values}}
+	case map[string]interface{}:
+		return otlpAnyValue{KvlistValue: &otlpKvlistValue{Values: otlpAttributes(v)}}
+	default:
+		return otlpAnyValue{StringValue: ptr(fmt.Sprint(v))}
+	}
+}
+
+// otlpDouble converts a float, sending values JSON cannot represent as strings
+func otlpDouble(v float64) otlpAnyValue {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return otlpAnyValue{StringValue: ptr(strconv.FormatFloat(v, 'g', -1, 64))}
+	}
+	return otlpAnyValue{DoubleValue: ptr(v)}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+// OTLP/JSON ExportLogsServiceRequest, as specified by opentelemetry-proto. 64-bit integers
+// are encoded as strings, trace and span IDs in hex.
+type otlpExportRequest struct {
+	ResourceLogs []otlpResourceLogs `json:"resourceLogs"`
+}
+
+type otlpResourceLogs struct {
+	Resource  otlpResource    `json:"resource"`
+	ScopeLogs []otlpScopeLogs `json:"scopeLogs"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeLogs struct {
+	Scope      otlpScope       `json:"scope"`
+	LogRecords []otlpLogRecord `json:"logRecords"`
+}
+
+type otlpScope struct {
+	Name string `json:"name,omitempty"`
+}
+
+type otlpLogRecord struct {
+	scope          string
+	TimeUnixNano   string         `json:"timeUnixNano"`
+	SeverityNumber int            `json:"severityNumber"`
+	SeverityText   string         `json:"severityText"`
+	Body           otlpAnyValue   `json:"body"`
+	Attributes     []otlpKeyValue `json:"attributes,omitempty"`
+	TraceID        string         `json:"traceId,omitempty"`
+	SpanID         string         `json:"spanId,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue *string          `json:"stringValue,omitempty"`
+	BoolValue   *bool            `json:"boolValue,omitempty"`
+	IntValue    *string          `json:"intValue,omitempty"`
+	DoubleValue *float64         `json:"doubleValue,omitempty"`
+	ArrayValue  *otlpArrayValue  `json:"arrayValue,omitempty"`
+	KvlistValue *otlpKvlistValue `json:"kvlistValue,omitempty"`
+}
+
+type otlpArrayValue struct {
+	Values []otlpAnyValue `json:"values"`
+}
+
+type otlpKvlistValue struct {
+	Values []otlpKeyValue `json:"values"`
+}
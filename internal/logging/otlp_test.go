@@ -0,0 +1,130 @@
+package logging
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestOTLPExporter(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		requests []otlpExportRequest
+	)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/logs" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("unexpected request %s %s", r.URL.Path, r.Header.Get("Content-Type"))
+		}
+		var req otlpExportRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("failed to decode export request: %v", err)
+		}
+		mu.Lock()
+		requests = append(requests, req)
+		mu.Unlock()
+	}))
+	defer collector.Close()
+
+	exporter := NewOTLPExporter(collector.URL+"/v1/logs", map[string]string{
+		"service.name":       "nats-k8s-oidc-callout",
+		"k8s.namespace.name": "nats",
+	}, zap.NewNop())
+	trace := NewTraceContext()
+	logger := zap.New(exporter.Core(zapcore.InfoLevel)).With(zap.String("request_id", "req-1"))
+	logger = logger.With(trace.Fields()...)
+
+	logger.Debug("not exported")
+	logger.Named("audit").Info("authorization decision",
+		zap.Bool("allowed", true),
+		zap.Int("subjects", 3),
+		zap.Duration("elapsed", 1500*time.Millisecond),
+		zap.Strings("publish", []string{"orders.>"}))
+	if err := logger.Sync(); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if err := exporter.Close(context.Background()); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(requests) != 1 || len(requests[0].ResourceLogs) != 1 {
+		t.Fatalf("got %d export requests, want 1 with one resource", len(requests))
+	}
+	resourceLogs := requests[0].ResourceLogs[0]
+
+	resource := map[string]string{}
+	for _, kv := range resourceLogs.Resource.Attributes {
+		resource[kv.Key] = *kv.Value.StringValue
+	}
+	if resource["service.name"] != "nats-k8s-oidc-callout" || resource["k8s.namespace.name"] != "nats" {
+		t.Errorf("resource attributes = %v", resource)
+	}
+
+	if len(resourceLogs.ScopeLogs) != 1 || resourceLogs.ScopeLogs[0].Scope.Name != "audit" {
+		t.Fatalf("scope logs = %+v, want one audit scope", resourceLogs.ScopeLogs)
+	}
+	records := resourceLogs.ScopeLogs[0].LogRecords
+	if len(records) != 1 {
+		t.Fatalf("got %d records, want 1", len(records))
+	}
+	record := records[0]
+	if *record.Body.StringValue != "authorization decision" || record.SeverityNumber != 9 || record.SeverityText != "INFO" {
+		t.Errorf("record = %+v", record)
+	}
+	if record.TraceID != trace.TraceID || record.SpanID != trace.SpanID {
+		t.Errorf("record trace context = %s/%s, want %s/%s", record.TraceID, record.SpanID, trace.TraceID, trace.SpanID)
+	}
+
+	attrs := map[string]otlpAnyValue{}
+	for _, kv := range record.Attributes {
+		attrs[kv.Key] = kv.Value
+	}
+	if v := attrs["request_id"].StringValue; v == nil || *v != "req-1" {
+		t.Errorf("request_id = %v, want req-1", v)
+	}
+	if _, ok := attrs[TraceIDKey]; ok {
+		t.Error("trace_id exported as an attribute, want only the record's trace ID")
+	}
+	if v := attrs["allowed"].BoolValue; v == nil || !*v {
+		t.Errorf("allowed = %v, want true", v)
+	}
+	if v := attrs["subjects"].IntValue; v == nil || *v != "3" {
+		t.Errorf("subjects = %v, want \"3\"", v)
+	}
+	if v := attrs["elapsed"].DoubleValue; v == nil || *v != 1.5 {
+		t.Errorf("elapsed = %v, want 1.5", v)
+	}
+	if v := attrs["publish"].ArrayValue; v == nil || len(v.Values) != 1 || *v.Values[0].StringValue != "orders.>" {
+		t.Errorf("publish = %+v, want [orders.>]", v)
+	}
+}
+
+func TestOTLPExporter_CollectorUnavailable(t *testing.T) {
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer collector.Close()
+
+	exporter := NewOTLPExporter(collector.URL, nil, zap.NewNop())
+	logger := zap.New(exporter.Core(zapcore.InfoLevel))
+
+	// Logging must not fail or block while the collector rejects exports
+	logger.Info("first")
+	_ = logger.Sync()
+	logger.Info("second")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := exporter.Close(ctx); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	_ = logger.Sync() // a flush after close returns immediately
+}
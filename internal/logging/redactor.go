@@ -3,7 +3,10 @@
 package logging
 
 import (
+	"encoding/json"
+	"reflect"
 	"strings"
+	"time"
 )
 
 // RedactNATSURL redacts passwords from NATS connection URLs
@@ -89,3 +92,134 @@ func RedactSensitiveFields(data map[string]interface{}) map[string]interface{} {
 
 	return result
 }
+
+// sensitiveMessageFields mark the fields of protocol messages holding credentials, such as the
+// "pass", "sig" and "auth_token" connect options of a NATS authorization request
+var sensitiveMessageFields = []string{"pass", "pwd", "secret", "token", "jwt", "sig", "authorization", "api_key", "apikey"}
+
+// RedactJSON returns the JSON form of a message, as nested maps and slices, with the string
+// values of sensitive fields redacted at any depth: JWTs shortened with RedactJWT, anything
+// else masked. Other values, including booleans such as bearer_token, are kept. It returns
+// nil if the message cannot be encoded.
+func RedactJSON(message any) any {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return nil
+	}
+	var decoded any
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return nil
+	}
+	return redactJSONValue(decoded, false)
+}
+
+// redactJSONValue redacts a decoded JSON value, the value of a sensitive field when sensitive
+func redactJSONValue(value any, sensitive bool) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, field := range v {
+			v[key] = redactJSONValue(field, sensitive || isSensitiveMessageField(key))
+		}
+		return v
+	case []any:
+		for i, item := range v {
+			v[i] = redactJSONValue(item, sensitive)
+		}
+		return v
+	case string:
+		if !sensitive || v == "" {
+			return v
+		}
+		if strings.Count(v, ".") == 2 {
+			return RedactJWT(v)
+		}
+		return "***"
+	default:
+		return value
+	}
+}
+
+// isSensitiveMessageField reports whether a message field name marks a credential
+func isSensitiveMessageField(name string) bool {
+	lower := strings.ToLower(name)
+	for _, word := range sensitiveMessageFields {
+		if strings.Contains(lower, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// sensitiveConfigWords mark configuration fields holding secrets, unless the field names a
+// file, directory or path holding them
+var sensitiveConfigWords = []string{"password", "passwd", "secret", "token", "seed", "webhookurl"}
+
+// RedactConfig returns the exported fields of a configuration struct by field name, for
+// logging the effective configuration. Non-empty secret fields are masked, credentials in URLs
+// redacted, sensitive map entries masked and durations formatted.
+func RedactConfig(config any) map[string]any {
+	v := reflect.Indirect(reflect.ValueOf(config))
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+
+	result := make(map[string]any, v.NumField())
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		result[field.Name] = redactConfigValue(field.Name, v.Field(i))
+	}
+	return result
+}
+
+// redactConfigValue returns the loggable form of one configuration field
+func redactConfigValue(name string, value reflect.Value) any {
+	if d, ok := value.Interface().(time.Duration); ok {
+		return d.String()
+	}
+
+	switch value.Kind() {
+	case reflect.String:
+		s := value.String()
+		if s != "" && isSensitiveConfigName(name) {
+			return "***"
+		}
+		if strings.Contains(s, "://") {
+			// NATS_URL may list several servers
+			urls := strings.Split(s, ",")
+			for i, u := range urls {
+				urls[i] = RedactNATSURL(u)
+			}
+			return strings.Join(urls, ",")
+		}
+		return s
+	case reflect.Map:
+		if value.Type().Key().Kind() != reflect.String {
+			return value.Interface()
+		}
+		entries := make(map[string]interface{}, value.Len())
+		iter := value.MapRange()
+		for iter.Next() {
+			entries[iter.Key().String()] = iter.Value().Interface()
+		}
+		return RedactSensitiveFields(entries)
+	default:
+		return value.Interface()
+	}
+}
+
+// isSensitiveConfigName reports whether a configuration field name marks a secret value
+func isSensitiveConfigName(name string) bool {
+	lower := strings.ToLower(name)
+	if strings.HasSuffix(lower, "file") || strings.HasSuffix(lower, "dir") || strings.HasSuffix(lower, "path") {
+		return false
+	}
+	for _, word := range sensitiveConfigWords {
+		if strings.Contains(lower, word) {
+			return true
+		}
+	}
+	return false
+}
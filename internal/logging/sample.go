@@ -0,0 +1,41 @@
+package logging
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// accessLogger is the name of the logger writing one line per authorization
+const accessLogger = "access"
+
+// Sample returns an option sampling repeated messages each second: the first initial entries
+// with a given level and message are written, then every thereafter-th. The access log and the
+// audit records are never sampled, since every authorization must leave one line. Applied before
+// the export tees, it samples stdout only.
+func Sample(initial, thereafter int) zap.Option {
+	return zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return &sampleCore{
+			Core:    core,
+			sampled: zapcore.NewSamplerWithOptions(core, time.Second, initial, thereafter),
+		}
+	})
+}
+
+// sampleCore samples entries, except those of the access and audit loggers
+type sampleCore struct {
+	zapcore.Core // unsampled
+	sampled      zapcore.Core
+}
+
+func (c *sampleCore) With(fields []zapcore.Field) zapcore.Core {
+	return &sampleCore{Core: c.Core.With(fields), sampled: c.sampled.With(fields)}
+}
+
+func (c *sampleCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if entry.LoggerName == accessLogger || entry.LoggerName == auditLogger {
+		return c.Core.Check(entry, checked)
+	}
+	return c.sampled.Check(entry, checked)
+}
@@ -0,0 +1,30 @@
+package logging
+
+import (
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// TestSample tests that repeated messages are sampled, except those of the access and audit
+// loggers
+func TestSample(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	logger := zap.New(core).WithOptions(Sample(2, 100))
+
+	for range 10 {
+		logger.Info("authorization")
+		logger.Named("access").Info("authorization")
+		logger.With(zap.String("request_id", "r1")).Named("audit").Info("authorization decision")
+	}
+
+	counts := map[string]int{}
+	for _, entry := range logs.All() {
+		counts[entry.LoggerName]++
+	}
+	if counts[""] != 2 || counts["access"] != 10 || counts["audit"] != 10 {
+		t.Errorf("logged %v, want 2 sampled entries and every access and audit entry", counts)
+	}
+}
@@ -0,0 +1,126 @@
+package logging
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Log fields carrying the trace context. The OTLP exporter sends them as the trace and span
+// IDs of the log record rather than as attributes.
+const (
+	TraceIDKey = "trace_id"
+	SpanIDKey  = "span_id"
+)
+
+// TraceContext identifies an operation in W3C trace context form, so that its log lines,
+// latency exemplar and exported span can be correlated
+type TraceContext struct {
+	TraceID string // 32 lowercase hex digits
+	SpanID  string // 16 lowercase hex digits
+}
+
+// NewTraceContext returns the context of a span starting a new trace, with random IDs
+func NewTraceContext() TraceContext {
+	var id [24]byte
+	_, _ = rand.Read(id[:]) // never fails
+	return TraceContext{TraceID: hex.EncodeToString(id[:16]), SpanID: hex.EncodeToString(id[16:])}
+}
+
+// Fields returns the log fields carrying the trace context
+func (t TraceContext) Fields() []zap.Field {
+	return []zap.Field{zap.String(TraceIDKey, t.TraceID), zap.String(SpanIDKey, t.SpanID)}
+}
+
+// Span is a finished server span to export
+type Span struct {
+	TraceContext
+	Name       string
+	Start      time.Time
+	End        time.Time
+	Error      string // status message of a failed operation; empty unless it failed
+	Attributes map[string]string
+}
+
+// OTLPTraceExporter ships spans to an OpenTelemetry collector over OTLP/HTTP with JSON
+// encoding, queued, batched and dropped under load like log entries (see OTLPExporter)
+type OTLPTraceExporter struct {
+	*otlpBatcher[otlpSpan]
+	resource []otlpKeyValue
+}
+
+// NewOTLPTraceExporter starts an exporter sending to the OTLP/HTTP traces endpoint (for example
+// http://otel-collector:4318/v1/traces), describing every span with the resource attributes.
+// Export failures are reported to errLogger.
+func NewOTLPTraceExporter(endpoint string, resource map[string]string, errLogger *zap.Logger) *OTLPTraceExporter {
+	e := &OTLPTraceExporter{resource: otlpResourceAttributes(resource)}
+	e.otlpBatcher = newOTLPBatcher(endpoint, "span", e.encode, errLogger)
+	return e
+}
+
+// Export queues a finished span, dropping it when the queue is full
+func (e *OTLPTraceExporter) Export(span Span) {
+	record := otlpSpan{
+		TraceID:           span.TraceID,
+		SpanID:            span.SpanID,
+		Name:              span.Name,
+		Kind:              otlpSpanKindServer,
+		StartTimeUnixNano: strconv.FormatInt(span.Start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(span.End.UnixNano(), 10),
+		Attributes:        otlpResourceAttributes(span.Attributes),
+	}
+	if span.Error != "" {
+		record.Status = otlpStatus{Code: otlpStatusError, Message: span.Error}
+	}
+	e.enqueue(record)
+}
+
+// encode encodes a batch as an OTLP ExportTraceServiceRequest
+func (e *OTLPTraceExporter) encode(batch []otlpSpan) ([]byte, error) {
+	return json.Marshal(otlpTraceExportRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: e.resource},
+		ScopeSpans: []otlpScopeSpans{{Spans: batch}},
+	}}})
+}
+
+// OTLP span kind and status codes
+const (
+	otlpSpanKindServer = 2
+	otlpStatusError    = 2
+)
+
+// OTLP/JSON ExportTraceServiceRequest, as specified by opentelemetry-proto. Trace and span IDs
+// are hex encoded.
+type otlpTraceExportRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
@@ -0,0 +1,102 @@
+package logging
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestNewTraceContext(t *testing.T) {
+	first, second := NewTraceContext(), NewTraceContext()
+	if !regexp.MustCompile(`^[0-9a-f]{32}$`).MatchString(first.TraceID) {
+		t.Errorf("TraceID = %q, want 32 hex digits", first.TraceID)
+	}
+	if !regexp.MustCompile(`^[0-9a-f]{16}$`).MatchString(first.SpanID) {
+		t.Errorf("SpanID = %q, want 16 hex digits", first.SpanID)
+	}
+	if first == second {
+		t.Error("NewTraceContext() returned the same IDs twice")
+	}
+}
+
+func TestOTLPTraceExporter(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		requests []otlpTraceExportRequest
+	)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("unexpected request %s %s", r.URL.Path, r.Header.Get("Content-Type"))
+		}
+		var req otlpTraceExportRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("failed to decode export request: %v", err)
+		}
+		mu.Lock()
+		requests = append(requests, req)
+		mu.Unlock()
+	}))
+	defer collector.Close()
+
+	exporter := NewOTLPTraceExporter(collector.URL+"/v1/traces", map[string]string{
+		"service.name": "nats-k8s-oidc-callout",
+	}, zap.NewNop())
+
+	trace := NewTraceContext()
+	start := time.Unix(1700000000, 0)
+	exporter.Export(Span{
+		TraceContext: trace,
+		Name:         "authorize",
+		Start:        start,
+		End:          start.Add(5 * time.Millisecond),
+		Attributes:   map[string]string{"result": "allowed"},
+	})
+	exporter.Export(Span{
+		TraceContext: NewTraceContext(),
+		Name:         "authorize",
+		Start:        start,
+		End:          start,
+		Error:        "internal_error",
+	})
+	if err := exporter.Close(context.Background()); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(requests) != 1 || len(requests[0].ResourceSpans) != 1 || len(requests[0].ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("got %d export requests, want 1 with one resource and scope", len(requests))
+	}
+	resourceSpans := requests[0].ResourceSpans[0]
+	if attrs := resourceSpans.Resource.Attributes; len(attrs) != 1 || *attrs[0].Value.StringValue != "nats-k8s-oidc-callout" {
+		t.Errorf("resource attributes = %+v", attrs)
+	}
+
+	spans := resourceSpans.ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(spans))
+	}
+	span := spans[0]
+	if span.TraceID != trace.TraceID || span.SpanID != trace.SpanID || span.Name != "authorize" || span.Kind != otlpSpanKindServer {
+		t.Errorf("span = %+v", span)
+	}
+	if span.StartTimeUnixNano != "1700000000000000000" || span.EndTimeUnixNano != "1700000000005000000" {
+		t.Errorf("span times = %s-%s", span.StartTimeUnixNano, span.EndTimeUnixNano)
+	}
+	if len(span.Attributes) != 1 || span.Attributes[0].Key != "result" || *span.Attributes[0].Value.StringValue != "allowed" {
+		t.Errorf("span attributes = %+v", span.Attributes)
+	}
+	if span.Status.Code != 0 {
+		t.Errorf("span status = %+v, want unset", span.Status)
+	}
+	if status := spans[1].Status; status.Code != otlpStatusError || status.Message != "internal_error" {
+		t.Errorf("failed span status = %+v, want an error", status)
+	}
+}
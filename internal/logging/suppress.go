@@ -0,0 +1,37 @@
+package logging
+
+import (
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// SuppressDebug returns an option dropping debug entries with one of the given messages, such
+// as the per-authorization "ServiceAccount found in cache", so that debug level can be enabled
+// in production without every authorization writing several lines. Entries at other levels
+// are never dropped. Applied after the export tees, it suppresses the messages everywhere.
+func SuppressDebug(messages []string) zap.Option {
+	suppressed := make(map[string]bool, len(messages))
+	for _, message := range messages {
+		suppressed[message] = true
+	}
+	return zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return &suppressCore{Core: core, suppressed: suppressed}
+	})
+}
+
+// suppressCore drops debug entries with suppressed messages
+type suppressCore struct {
+	zapcore.Core
+	suppressed map[string]bool
+}
+
+func (c *suppressCore) With(fields []zapcore.Field) zapcore.Core {
+	return &suppressCore{Core: c.Core.With(fields), suppressed: c.suppressed}
+}
+
+func (c *suppressCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if entry.Level == zapcore.DebugLevel && c.suppressed[entry.Message] {
+		return checked
+	}
+	return c.Core.Check(entry, checked)
+}
@@ -0,0 +1,28 @@
+package logging
+
+import (
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// TestSuppressDebug tests that only debug entries with suppressed messages are dropped
+func TestSuppressDebug(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	logger := zap.New(core).WithOptions(SuppressDebug([]string{"ServiceAccount found in cache"}))
+
+	logger.Debug("ServiceAccount found in cache")
+	logger.With(zap.String("namespace", "orders")).Debug("ServiceAccount found in cache")
+	logger.Debug("ServiceAccount NOT found in cache")
+	logger.Info("ServiceAccount found in cache")
+
+	entries := logs.All()
+	if len(entries) != 2 {
+		t.Fatalf("logged %d entries, want 2: %v", len(entries), entries)
+	}
+	if entries[0].Message != "ServiceAccount NOT found in cache" || entries[1].Level != zapcore.InfoLevel {
+		t.Errorf("logged %v, want the unsuppressed debug entry and the info entry", entries)
+	}
+}
@@ -4,26 +4,36 @@ package nats
 import (
 	"bufio"
 	"context"
+	"encoding/json"
 	"fmt"
 	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/nats-io/jwt/v2"
 	natsclient "github.com/nats-io/nats.go"
 	"github.com/nats-io/nkeys"
+	"github.com/nats-io/nuid"
 	"github.com/synadia-io/callout.go"
 	"go.uber.org/zap"
+	"golang.org/x/time/rate"
 
 	"github.com/portswigger-tim/nats-k8s-oidc-callout/internal/auth"
+	httpmetrics "github.com/portswigger-tim/nats-k8s-oidc-callout/internal/httpserver"
 	"github.com/portswigger-tim/nats-k8s-oidc-callout/internal/logging"
 )
 
 const (
 	// DefaultTokenExpiry is the default expiry time for generated NATS user tokens
 	DefaultTokenExpiry = 5 * time.Minute
+
+	// DefaultRequestTimeout is how long the NATS server waits for an authorization response
+	// by default (its auth_timeout)
+	DefaultRequestTimeout = 2 * time.Second
 )
 
 // AuthHandler defines the interface for authorization
@@ -36,12 +46,55 @@ type Client struct {
 	url         string
 	credsFile   string // User credentials file (optional)
 	token       string // Token for authentication (optional)
+	username    string // Username for authentication, with password (optional)
+	password    string
 	account     string // NATS account to assign authenticated clients to
 	authHandler AuthHandler
+	tokenExpiry time.Duration // lifetime of issued user JWTs, and the most a ServiceAccount may request
+	reqTimeout  time.Duration // how long the server waits for a response (0 = no deadline)
+	slowAfter   time.Duration // authorizations slower than this log their stage timings (0 = never)
+	accessLog   *zap.Logger   // one line per authorization regardless of the log level (nil = disabled)
+	spans       SpanExporter  // one span per authorization (nil = disabled)
+	lastAuthSA  bool          // record the last successful authorization per ServiceAccount, not just per namespace
+	urlOnly     bool          // dial the configured URL for every connection, ignoring discovered servers
 	conn        *natsclient.Conn
-	service     *callout.AuthorizationService
 	signingKey  nkeys.KeyPair
 	logger      *zap.Logger
+
+	newSigningKey nkeys.KeyPair // signing key a rotation moves to once the account trusts it (nil = none)
+
+	serviceMu sync.Mutex                    // guards service and closed, as the service is restarted
+	service   *callout.AuthorizationService // nil while out of the queue group
+	closed    bool
+	errorRate *ErrorRateMonitor // leaves the queue group while it is tripped (nil = disabled)
+	load      *Load             // authorization load shared with the other clients (nil = disabled)
+
+	scopedKeys    map[string]nkeys.KeyPair // scoped signing keys by role
+	issuerAccount string                   // account the scoped signing keys belong to
+
+	accountJWT atomic.Pointer[accountState] // issuer account from the account resolver (nil = not fetched)
+	keyCheck   atomic.Pointer[keyCheck]     // last signing key self-verification (nil = not run)
+
+	accountIssuers map[string]*Client // other issuers' clients by account, for nats.io/account
+
+	traceLog      *zap.Logger // redacted traces of matching authorizations (nil = disabled)
+	tracePatterns []string
+
+	limits      jwt.NatsLimits // default user limits; identities may only lower them
+	respMaxMsgs int            // responses a responder may send per request received
+	respTTL     time.Duration  // how long a responder may take to respond (0 = no limit)
+
+	deniedSubjects []string // denied for publish and subscribe in every user JWT
+	requireNkey    bool     // deny clients that do not sign the server nonce, unless issued bearer JWTs
+
+	connections *ConnectionRegistry // open connections per identity (nil = not tracked)
+	limiter     *rate.Limiter       // global authorization rate limit, shared by the clients (nil = none)
+	maxConns    int                 // most open connections per ServiceAccount (0 = no limit)
+
+	statusSubject string     // subject answered with the service status, if set
+	status        func() any // status reported on statusSubject
+
+	probe atomic.Pointer[selfTestProbe] // running self-test, if any
 }
 
 // NewClient creates a new NATS auth callout client.
@@ -54,6 +107,8 @@ type Client struct {
 //     Pass non-empty userCredsFile path, empty token.
 //  3. Token authentication: Static token for connection
 //     Pass empty userCredsFile, non-empty token.
+//  4. Username and password: set with SetUserPassword() instead of embedding them in the URL,
+//     where they would leak into logs and status output. Pass empty userCredsFile and token.
 //
 // The account parameter specifies which NATS account authenticated clients will be assigned to.
 // Use "$G" for the global account, or specify a specific account name like "APP" for multi-tenancy.
@@ -97,36 +152,150 @@ func NewClient(natsURL, userCredsFile, token, account string, authHandler AuthHa
 		token:       token,
 		account:     account, // NATS account for authenticated clients
 		authHandler: authHandler,
+		tokenExpiry: DefaultTokenExpiry,
+		reqTimeout:  DefaultRequestTimeout,
+		limits:      jwt.NatsLimits{Subs: jwt.NoLimit, Data: jwt.NoLimit, Payload: jwt.NoLimit},
+		respMaxMsgs: 1,
 		logger:      logger,
 	}, nil
 }
 
+// SetTokenExpiry sets the lifetime of issued user JWTs (default DefaultTokenExpiry).
+// ServiceAccounts may request a shorter lifetime, never a longer one.
+func (c *Client) SetTokenExpiry(d time.Duration) {
+	c.tokenExpiry = d
+}
+
+// SetRequestTimeout sets how long the NATS server waits for an authorization response
+// (default DefaultRequestTimeout). Requests still being handled after it are abandoned
+// without signing a response; zero disables the deadline. The callout library does not
+// expose the request's own expiry, so the deadline is measured from receipt and should
+// match the server's auth_timeout.
+func (c *Client) SetRequestTimeout(d time.Duration) {
+	c.reqTimeout = d
+}
+
+// SetSlowThreshold sets the latency above which an authorization logs a warning with the time
+// spent in each stage; zero disables the warning.
+func (c *Client) SetSlowThreshold(d time.Duration) {
+	c.slowAfter = d
+}
+
+// SetUserPassword sets a username and password to authenticate the NATS connection with,
+// instead of credentials embedded in the URL. It is ignored when a user credentials file or
+// token is configured.
+func (c *Client) SetUserPassword(username, password string) {
+	c.username = username
+	c.password = password
+}
+
+// SetIgnoreDiscoveredServers makes every connection and reconnection dial the configured URL,
+// resolving its hostname anew, instead of the server URLs the cluster advertises. Those are pod
+// IPs that go stale when NATS pods are rescheduled.
+func (c *Client) SetIgnoreDiscoveredServers(ignore bool) {
+	c.urlOnly = ignore
+}
+
+// SetAccessLogger enables the access log: one compact line per authorization, written to the
+// logger regardless of the service's log level. A nil logger disables it.
+func (c *Client) SetAccessLogger(logger *zap.Logger) {
+	c.accessLog = logger
+}
+
+// SpanExporter exports the span of each authorization
+type SpanExporter interface {
+	Export(span logging.Span)
+}
+
+// SetSpanExporter exports a span for every authorization, with the trace ID its log lines and
+// latency exemplar carry. A nil exporter disables it.
+func (c *Client) SetSpanExporter(exporter SpanExporter) {
+	c.spans = exporter
+}
+
+// SetLastAuthPerServiceAccount controls whether the time of the last successful authorization
+// is exported per ServiceAccount as well as per namespace. It is off by default, as the
+// per-ServiceAccount gauge has one series for every ServiceAccount that ever connects.
+func (c *Client) SetLastAuthPerServiceAccount(enabled bool) {
+	c.lastAuthSA = enabled
+}
+
+// SetDefaultLimits sets the NATS user limits applied to every issued user JWT: the most
+// subscriptions, the largest payload and the most pending data in bytes. jwt.NoLimit (-1, the
+// default) leaves a limit unset. Identities may request lower limits, never higher ones.
+func (c *Client) SetDefaultLimits(subs, payload, data int64) {
+	c.limits = jwt.NatsLimits{Subs: subs, Payload: payload, Data: data}
+}
+
+// SetDeniedSubjects sets subjects that are never granted, whatever the identity's
+// permissions: they are denied for both publish and subscribe in every user JWT signed with
+// the default signing key. Deny entries take precedence over allow entries in NATS, so they
+// also hold against wildcards such as ">". User JWTs of scoped roles carry no permissions, so
+// the role's scope must deny them instead.
+func (c *Client) SetDeniedSubjects(subjects []string) {
+	c.deniedSubjects = subjects
+}
+
+// SetResponderLimits sets the response permission of responder identities (see
+// auth.ClassResponder): how many responses they may send per request received, and for how
+// long after the request (zero for no limit). The default is one response with no time limit.
+func (c *Client) SetResponderLimits(maxMsgs int, ttl time.Duration) {
+	c.respMaxMsgs = maxMsgs
+	c.respTTL = ttl
+}
+
+// SetRateLimiter denies authorization requests beyond the limiter's rate and burst with
+// auth.ReasonRateLimited, without calling the handler, as a backstop against mass reconnection.
+// The limiter is shared by the clients of all accounts to make the limit global. A nil limiter
+// disables it.
+func (c *Client) SetRateLimiter(limiter *rate.Limiter) {
+	c.limiter = limiter
+}
+
+// SetStatusEndpoint answers requests on subject with the JSON encoding of status(), so the
+// service can be probed over NATS without HTTP access to the pod. It must be called before Start.
+func (c *Client) SetStatusEndpoint(subject string, status func() any) {
+	c.statusSubject = subject
+	c.status = status
+}
+
 // SetSigningKey sets the signing key for the client (useful for testing)
 func (c *Client) SetSigningKey(key nkeys.KeyPair) {
 	c.signingKey = key
 }
 
+// SetScopedSigningKeys sets the scoped signing keys identities may select by role (see
+// auth.RolePolicy). Their permissions and limits are defined by the role's scope in the account
+// JWT, so a leaked scoped key can only issue users with those. issuerAccount is the public key
+// of the account the keys belong to; when empty, it is the public key of the signing key, which
+// must then be the account's identity key. It must be called before Start.
+func (c *Client) SetScopedSigningKeys(keys map[string]nkeys.KeyPair, issuerAccount string) error {
+	if issuerAccount != "" && !nkeys.IsValidPublicAccountKey(issuerAccount) {
+		return fmt.Errorf("issuer account %q is not an account public key", issuerAccount)
+	}
+	c.scopedKeys = keys
+	c.issuerAccount = issuerAccount
+	return nil
+}
+
 // Start connects to NATS and starts the auth callout service
 func (c *Client) Start(ctx context.Context) error {
 	// Verify signing key is set
 	if c.signingKey == nil {
 		return fmt.Errorf("signing key not set; call SetSigningKey() before Start()")
 	}
+	if len(c.scopedKeys) > 0 && c.issuerAccount == "" {
+		issuer, err := c.issuer()
+		if err != nil {
+			return err
+		}
+		c.issuerAccount = issuer
+	}
 
-	// Build connection options with preallocated capacity
-	opts := make([]natsclient.Option, 0, 4)
-	opts = append(opts,
-		natsclient.Timeout(5*time.Second),
-		natsclient.Name("nats-k8s-oidc-callout"),
-	)
-
-	// Add authentication based on configured method
-	// Priority: User credentials > Token > URL-embedded credentials
-	authOpts, err := c.configureAuthentication()
+	opts, err := c.connectOptions()
 	if err != nil {
-		return fmt.Errorf("failed to configure authentication: %w", err)
+		return err
 	}
-	opts = append(opts, authOpts...)
 
 	// Connect to NATS
 	conn, err := natsclient.Connect(c.url, opts...)
@@ -134,102 +303,523 @@ func (c *Client) Start(ctx context.Context) error {
 		return fmt.Errorf("failed to connect to NATS (url=%s, user_creds_file=%s): %w", c.url, c.credsFile, err)
 	}
 	c.conn = conn
+	httpmetrics.SetNATSServer(c.account, conn.ConnectedServerName(), conn.ConnectedClusterName())
 
-	// Create authorizer function that bridges NATS and our auth handler
-	authorizer := func(req *jwt.AuthorizationRequest) (string, error) {
-		// Extract JWT token from request
-		// The token is provided by the client in the connection options
-		// For now, we'll extract it from the ConnectOptions if available
-		token := c.extractToken(req)
-
-		if token == "" {
-			// Reject requests without a token by not returning a JWT
-			// This causes the connection to timeout
-			c.logger.Debug("auth request rejected: no token provided",
-				zap.String("user_nkey", req.UserNkey))
-			return "", fmt.Errorf("no token provided")
-		}
+	// Create auth callout service
+	service, err := c.newService()
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to create authorization service: %w", err)
+	}
 
-		// Call our auth handler
-		authReq := &auth.AuthRequest{
-			Token: token,
+	c.serviceMu.Lock()
+	c.service = service
+	c.serviceMu.Unlock()
+	c.trackWorker(true)
+
+	if c.statusSubject != "" {
+		if err := c.startStatusEndpoint(); err != nil {
+			_ = service.Stop()
+			c.trackWorker(false)
+			conn.Close()
+			return err
 		}
+	}
+	return nil
+}
+
+// newService creates the auth callout service, joining the callout queue group
+func (c *Client) newService() (*callout.AuthorizationService, error) {
+	signer := callout.ResponseSignerKey(c.signingKey)
+	if c.newSigningKey != nil {
+		// The signing key changes at the rotation's cutover
+		signer = callout.ResponseSigner(c.signResponse)
+	}
+	return callout.NewAuthorizationService(
+		c.conn,
+		callout.Authorizer(c.safeAuthorize),
+		signer,
+	)
+}
 
-		c.logger.Debug("calling auth handler with token")
-		authResp := c.authHandler.Authorize(authReq)
+// startStatusEndpoint subscribes to the status subject. Every instance answers, so a request
+// gets the first reply and a request collecting several replies gets one per instance.
+func (c *Client) startStatusEndpoint() error {
+	_, err := c.conn.Subscribe(c.statusSubject, func(msg *natsclient.Msg) {
+		if msg.Reply == "" {
+			return
+		}
+		data, err := json.Marshal(c.status())
+		if err != nil {
+			c.logger.Error("failed to encode status response", zap.Error(err))
+			return
+		}
+		if err := msg.Respond(data); err != nil {
+			c.logger.Warn("failed to send status response", zap.Error(err))
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to status subject %s: %w", c.statusSubject, err)
+	}
 
-		c.logger.Debug("auth handler response",
-			zap.Bool("allowed", authResp.Allowed),
-			zap.Strings("publish_permissions", authResp.PublishPermissions),
-			zap.Strings("subscribe_permissions", authResp.SubscribePermissions))
+	c.logger.Info("answering status requests over NATS", zap.String("subject", c.statusSubject))
+	return nil
+}
 
-		// If denied, reject by not returning a JWT
-		if !authResp.Allowed {
-			c.logger.Debug("auth request denied",
-				zap.String("user_nkey", req.UserNkey))
-			return "", fmt.Errorf("authorization failed")
+// safeAuthorize assigns a request ID and trace context and runs authorize, converting a panic
+// into a denial so that one malformed request cannot crash the service and drop every
+// in-flight authentication.
+func (c *Client) safeAuthorize(req *jwt.AuthorizationRequest) (encodedJWT string, err error) {
+	received := time.Now()
+	requestID := nuid.Next()
+	trace := logging.NewTraceContext()
+	if c.load != nil {
+		defer c.load.begin()()
+	}
+	// The server the client connected to, to attribute traffic in multi-cluster deployments
+	logger := c.logger.With(zap.String("request_id", requestID),
+		zap.String("nats_server", req.Server.Name),
+		zap.String("nats_cluster", req.Server.Cluster)).With(trace.Fields()...)
+
+	defer func() {
+		if r := recover(); r != nil {
+			httpmetrics.IncrementAuthPanics()
+			logger.Error("recovered from panic in authorization handler",
+				zap.Any("panic", r),
+				zap.String("user_nkey", req.UserNkey),
+				zap.Stack("stack"))
+
+			authResp := &auth.AuthResponse{Allowed: false, Reason: auth.ReasonInternalError}
+			c.recordDecision(logger, req, authResp, "")
+			c.recordOutcome(authResp.Reason)
+			encodedJWT, err = "", denialError(authResp.Reason, requestID)
 		}
+	}()
 
-		// Build NATS user claims
-		uc := jwt.NewUserClaims(req.UserNkey)
+	return c.authorize(req, requestID, trace, received, logger)
+}
 
-		// Set the audience to the configured NATS account
-		// This enables multi-tenancy by assigning clients to specific accounts
-		uc.Audience = c.account
+// authorize bridges a NATS authorization request to the auth handler and builds the
+// signed user claims for allowed requests. All log lines carry the request ID and trace context.
+func (c *Client) authorize(req *jwt.AuthorizationRequest, requestID string, trace logging.TraceContext, received time.Time, logger *zap.Logger) (string, error) {
+	if encoded, ok, err := c.selfTestResponse(req, logger); ok {
+		return encoded, err
+	}
 
-		uc.Pub.Allow.Add(authResp.PublishPermissions...)
-		uc.Sub.Allow.Add(authResp.SubscribePermissions...)
+	var stages authStages
+	defer func() {
+		httpmetrics.ObserveAuthDuration(stages.reason == auth.ReasonAllowed, req.Server.Cluster, time.Since(received), trace.TraceID)
+		c.logAccess(req, requestID, trace, received, &stages)
+		c.exportSpan(req, requestID, trace, received, &stages)
+		c.warnIfSlow(logger, received, &stages)
+		c.recordOutcome(stages.reason)
+	}()
+
+	// Extract JWT token from request
+	// The token is provided by the client in the connection options
+	// For now, we'll extract it from the ConnectOptions if available
+	token := extractToken(logger, req)
+
+	var authResp *auth.AuthResponse
+	if token == "" {
+		// Reject requests without a token without calling the handler
+		authResp = &auth.AuthResponse{Allowed: false, Reason: auth.ReasonMissingToken}
+	} else if c.limiter != nil && !c.limiter.Allow() {
+		// Beyond the limit, deny at once rather than queue: the client retries later
+		httpmetrics.IncrementThrottled()
+		authResp = &auth.AuthResponse{Allowed: false, Reason: auth.ReasonRateLimited}
+	} else {
+		logger.Debug("calling auth handler with token")
+		handlerStart := time.Now()
+		authResp = c.authHandler.Authorize(&auth.AuthRequest{
+			Token:          token,
+			ConnectionName: req.ConnectOptions.Name,
+			ConnectionType: connectionType(req.ClientInformation),
+			TLS:            req.TLS != nil,
+		})
+		stages.handler, stages.timings = time.Since(handlerStart), authResp.Timings
+		stages.identity = authResp.Identity
+	}
 
-		// Enable response permissions (equivalent to allow_responses: true)
-		// This allows responders to publish to reply subjects during request handling
-		// MaxMsgs: 1 = allow one response per request (NATS default)
-		// Expires: 0 = no time limit
-		uc.Resp = &jwt.ResponsePermission{
-			MaxMsgs: 1,
-			Expires: 0,
+	logger.Debug("auth handler response",
+		zap.Bool("allowed", authResp.Allowed),
+		zap.String("reason", string(authResp.Reason)),
+		zap.Strings("publish_permissions", authResp.PublishPermissions),
+		zap.Strings("subscribe_permissions", authResp.SubscribePermissions))
+
+	// The server has given up on a response by now, so don't sign one or record a success
+	if elapsed := time.Since(received); c.reqTimeout > 0 && elapsed > c.reqTimeout {
+		httpmetrics.IncrementDeadlineExceeded()
+		logger.Warn("abandoning authorization request past its deadline",
+			zap.Duration("elapsed", elapsed),
+			zap.Duration("timeout", c.reqTimeout),
+			zap.String("handler_reason", string(authResp.Reason)))
+		authResp = overrideDenial(authResp, auth.ReasonDeadlineExceeded)
+	}
+
+	issuer := c
+	if authResp.Allowed {
+		if issuer = c.accountIssuer(authResp.Account); issuer == nil {
+			// Placing the client in this account instead would cross a tenant boundary
+			logger.Warn("no issuer for the selected account", zap.String("selected_account", authResp.Account))
+			issuer = c
+			authResp = overrideDenial(authResp, auth.ReasonUnknownAccount)
 		}
+	}
 
-		uc.Expires = time.Now().Add(DefaultTokenExpiry).Unix()
+	// Falling back to the default signing key would grant the annotation permissions instead
+	if authResp.Allowed && authResp.Role != "" && issuer.scopedKey(authResp.Role) == nil {
+		logger.Warn("no scoped signing key for role", zap.String("role", authResp.Role))
+		authResp = overrideDenial(authResp, auth.ReasonUnknownRole)
+	}
 
-		c.logger.Debug("built user claims",
-			zap.String("subject", uc.Subject),
-			zap.String("audience", uc.Audience),
-			zap.Any("pub_allow", uc.Pub.Allow),
-			zap.Any("sub_allow", uc.Sub.Allow),
-			zap.Int64("expires", uc.Expires))
+	// The token alone is not enough when the client must prove it holds its nkey
+	nkey := verifyNkey(req)
+	if authResp.Allowed && (nkey == NkeyInvalid || (c.requireNkey && nkey == NkeyAbsent && !authResp.Bearer)) {
+		logger.Warn("client did not prove possession of its nkey",
+			zap.String("nkey", nkey),
+			zap.String("client_nkey", req.ConnectOptions.Nkey))
+		authResp = overrideDenial(authResp, auth.ReasonNkeyUnverified)
+	}
 
-		// Encode and return JWT
-		encodedJWT, err := uc.Encode(c.signingKey)
-		if err != nil {
-			c.logger.Error("failed to encode auth response JWT",
-				zap.Error(err),
-				zap.String("user_nkey", req.UserNkey))
-			return "", err
+	if authResp.Allowed && c.connectionLimitReached(authResp) {
+		logger.Warn("ServiceAccount reached its connection limit",
+			zap.Int("connections", c.connections.Count(authResp.Identity)))
+		httpmetrics.IncrementConnectionLimitExceeded(authResp.Namespace)
+		authResp = overrideDenial(authResp, auth.ReasonConnectionLimit)
+	}
+
+	c.recordDecision(logger, req, authResp, nkey)
+	stages.reason, stages.account = authResp.Reason, authResp.Account
+
+	// If denied, return the reason in the signed error response
+	if !authResp.Allowed {
+		c.logTrace(req, token, requestID, authResp, nil)
+		return "", denialError(authResp.Reason, requestID)
+	}
+
+	// Build NATS user claims
+	signingStart := time.Now()
+	uc := jwt.NewUserClaims(req.UserNkey)
+
+	// Set the audience to the configured NATS account, or the account the ServiceAccount selects
+	// This enables multi-tenancy by assigning clients to specific accounts
+	uc.Audience = issuer.account
+
+	// The server reports the connection's user as the JWT's name, which the connection
+	// registry attributes it to the identity by
+	if c.connections != nil {
+		uc.Name = authResp.Identity
+	}
+
+	expiry := c.tokenExpiry
+	if authResp.TokenTTL > 0 && authResp.TokenTTL < expiry {
+		expiry = authResp.TokenTTL
+	}
+	uc.Expires = time.Now().Add(expiry).Unix()
+
+	signingKey, issuerAccount := issuer.activeSigningKey()
+	if issuerAccount != "" {
+		uc.IssuerAccount = issuerAccount
+	}
+	if authResp.Role != "" {
+		// The role's scope supplies permissions and limits; the server rejects scoped
+		// user JWTs that set any of their own
+		signingKey = issuer.scopedKey(authResp.Role)
+		uc.IssuerAccount = issuer.issuerAccount
+		uc.UserPermissionLimits = jwt.UserPermissionLimits{}
+	} else {
+		issuer.setPermissions(uc, authResp)
+	}
+
+	logger.Debug("built user claims",
+		zap.String("subject", uc.Subject),
+		zap.String("audience", uc.Audience),
+		zap.Any("pub_allow", uc.Pub.Allow),
+		zap.Any("sub_allow", uc.Sub.Allow),
+		zap.Int64("expires", uc.Expires),
+		zap.Bool("bearer", uc.BearerToken))
+	c.logTrace(req, token, requestID, authResp, uc)
+
+	// Encode and return JWT
+	encodedJWT, err := uc.Encode(signingKey)
+	stages.signing = time.Since(signingStart)
+	if err != nil {
+		stages.reason = auth.ReasonInternalError
+		logger.Error("failed to encode auth response JWT",
+			zap.Error(err),
+			zap.String("user_nkey", req.UserNkey))
+		return "", err
+	}
+
+	logger.Debug("encoded auth response JWT",
+		zap.Int("jwt_length", len(encodedJWT)))
+
+	// Non-Kubernetes identities have no namespace to record
+	if authResp.Namespace != "" {
+		serviceAccount := ""
+		if c.lastAuthSA {
+			serviceAccount = authResp.ServiceAccount
 		}
+		httpmetrics.SetLastAuthSuccess(authResp.Namespace, serviceAccount, time.Now())
+	}
 
-		c.logger.Debug("encoded auth response JWT",
-			zap.Int("jwt_length", len(encodedJWT)))
+	return encodedJWT, nil
+}
 
-		return encodedJWT, nil
+// authStages records where an authorization spent its time
+type authStages struct {
+	reason   auth.ReasonCode
+	identity string        // identity of a validated token, kept when the decision is overridden
+	account  string        // account selected by the identity, empty for the client's own
+	handler  time.Duration // total time in the auth handler
+	timings  auth.Timings  // the handler's own breakdown
+	signing  time.Duration // building and signing the user JWT
+}
+
+// result returns the outcome of an authorization, "allowed" or "denied"
+func (s *authStages) result() string {
+	if s.reason == auth.ReasonAllowed {
+		return "allowed"
 	}
+	return "denied"
+}
 
-	// Create auth callout service
-	service, err := callout.NewAuthorizationService(
-		conn,
-		callout.Authorizer(authorizer),
-		callout.ResponseSignerKey(c.signingKey),
+// stageAccount returns the account an authorization assigned the client to
+func (c *Client) stageAccount(stages *authStages) string {
+	if stages.account != "" {
+		return stages.account
+	}
+	return c.account
+}
+
+// logAccess writes the access log line of an authorization
+func (c *Client) logAccess(req *jwt.AuthorizationRequest, requestID string, trace logging.TraceContext, received time.Time, stages *authStages) {
+	if c.accessLog == nil {
+		return
+	}
+	c.accessLog.Info("authorization",
+		zap.String("request_id", requestID),
+		zap.String(logging.TraceIDKey, trace.TraceID),
+		zap.String("identity", stages.identity),
+		zap.String("account", c.stageAccount(stages)),
+		zap.String("client_host", req.ClientInformation.Host),
+		zap.String("nats_server", req.Server.Name),
+		zap.String("result", stages.result()),
+		zap.String("reason", string(stages.reason)),
+		zap.Duration("duration", time.Since(received)))
+}
+
+// exportSpan exports the span of an authorization, from receipt to response. Denials are
+// expected outcomes; only internal errors, including panics that left no reason, mark the
+// span as failed.
+func (c *Client) exportSpan(req *jwt.AuthorizationRequest, requestID string, trace logging.TraceContext, received time.Time, stages *authStages) {
+	if c.spans == nil {
+		return
+	}
+	span := logging.Span{
+		TraceContext: trace,
+		Name:         "authorize",
+		Start:        received,
+		End:          time.Now(),
+		Attributes: map[string]string{
+			"request_id":   requestID,
+			"identity":     stages.identity,
+			"account":      c.stageAccount(stages),
+			"client_host":  req.ClientInformation.Host,
+			"nats_server":  req.Server.Name,
+			"nats_cluster": req.Server.Cluster,
+			"result":       stages.result(),
+			"reason":       string(stages.reason),
+		},
+	}
+	if stages.reason == auth.ReasonInternalError || stages.reason == "" {
+		span.Error = auth.ReasonInternalError.Message()
+	}
+	c.spans.Export(span)
+}
+
+// warnIfSlow logs the stage timings of an authorization slower than the slow threshold, so
+// tail latency can be investigated without debug logging
+func (c *Client) warnIfSlow(logger *zap.Logger, received time.Time, stages *authStages) {
+	total := time.Since(received)
+	if c.slowAfter <= 0 || total < c.slowAfter {
+		return
+	}
+	logger.Warn("slow authorization",
+		zap.Duration("total", total),
+		zap.Duration("threshold", c.slowAfter),
+		zap.String("reason", string(stages.reason)),
+		zap.Duration("handler", stages.handler),
+		zap.Duration("jwt_validation", stages.timings.Validation),
+		zap.Duration("permissions", stages.timings.Permissions),
+		zap.Duration("signing", stages.signing))
+}
+
+// setPermissions sets the permissions and limits of user claims issued with the default
+// signing key
+func (c *Client) setPermissions(uc *jwt.UserClaims, authResp *auth.AuthResponse) {
+	// An empty allow list places no restriction at all, so deny everything instead
+	uc.Pub.Allow.Add(authResp.PublishPermissions...)
+	if len(authResp.PublishPermissions) == 0 {
+		uc.Pub.Deny.Add(">")
+	}
+	uc.Sub.Allow.Add(authResp.SubscribePermissions...)
+	if len(authResp.SubscribePermissions) == 0 {
+		uc.Sub.Deny.Add(">")
+	}
+	uc.Pub.Deny.Add(authResp.PublishDenied...)
+	uc.Sub.Deny.Add(authResp.SubscribeDenied...)
+	uc.Pub.Deny.Add(c.deniedSubjects...)
+	uc.Sub.Deny.Add(c.deniedSubjects...)
+
+	uc.Resp = c.responsePermission(authResp.Class)
+	uc.NatsLimits = c.userLimits(authResp.Limits)
+	httpmetrics.ObserveIssuedSubjects(len(uc.Pub.Allow), len(uc.Sub.Allow))
+
+	// Bearer JWTs are accepted without a nonce signature, for clients that cannot sign it
+	if authResp.Bearer {
+		uc.BearerToken = true
+		httpmetrics.IncrementBearerUsers()
+	}
+}
+
+// userLimits combines the default user limits with those requested for an identity, which
+// only take effect when lower than the default
+func (c *Client) userLimits(requested auth.UserLimits) jwt.NatsLimits {
+	limits := c.defaultLimits()
+	return jwt.NatsLimits{
+		Subs:    lowerLimit(limits.Subs, requested.Subscriptions),
+		Payload: lowerLimit(limits.Payload, requested.Payload),
+		Data:    lowerLimit(limits.Data, requested.Data),
+	}
+}
+
+// lowerLimit returns the requested limit when it is set and lower than the default
+func lowerLimit(def, requested int64) int64 {
+	if requested > 0 && (def == jwt.NoLimit || requested < def) {
+		return requested
+	}
+	return def
+}
+
+// responsePermission returns the response permission for a request-reply class. Responders
+// get the configured response limits and requesters none. Everyone else may send one
+// response per request with no time limit (equivalent to allow_responses: true), so that
+// replies work without publish permissions on inbox subjects.
+func (c *Client) responsePermission(class string) *jwt.ResponsePermission {
+	switch class {
+	case auth.ClassRequester:
+		return nil
+	case auth.ClassResponder:
+		return &jwt.ResponsePermission{MaxMsgs: c.respMaxMsgs, Expires: c.respTTL}
+	default:
+		return &jwt.ResponsePermission{MaxMsgs: 1, Expires: 0}
+	}
+}
+
+// recordDecision writes the audit record and metrics for an authorization decision.
+func (c *Client) recordDecision(logger *zap.Logger, req *jwt.AuthorizationRequest, authResp *auth.AuthResponse, nkey string) {
+	httpmetrics.RecordAuthRequest(authResp.Allowed, string(authResp.Reason), req.Server.Cluster)
+	account := c.account
+	if authResp.Account != "" {
+		account = authResp.Account
+	}
+
+	logger.Named("audit").Info("authorization decision",
+		zap.Bool("allowed", authResp.Allowed),
+		zap.String("reason", string(authResp.Reason)),
+		zap.String("identity", authResp.Identity),
+		zap.Bool("bearer", authResp.Bearer),
+		zap.Bool("read_only", authResp.ReadOnly),
+		zap.String("role", authResp.Role),
+		zap.String("account", account),
+		zap.String("user_nkey", req.UserNkey),
+		zap.String("client_nkey", req.ConnectOptions.Nkey),
+		zap.String("nkey", nkey),
+		zap.String("client_host", req.ClientInformation.Host),
+		zap.String("client_name", req.ClientInformation.Name))
+}
+
+// overrideDenial denies a request for reason after the handler decided it, keeping the identity
+// and selected account so that the audit record still attributes the denial
+func overrideDenial(authResp *auth.AuthResponse, reason auth.ReasonCode) *auth.AuthResponse {
+	return &auth.AuthResponse{
+		Allowed:        false,
+		Identity:       authResp.Identity,
+		Namespace:      authResp.Namespace,
+		ServiceAccount: authResp.ServiceAccount,
+		Account:        authResp.Account,
+		Reason:         reason,
+	}
+}
+
+// denialError builds the error returned in the signed authorization response. The request ID
+// lets a denial reported by the NATS server be matched to the auth service's logs.
+func denialError(reason auth.ReasonCode, requestID string) error {
+	return fmt.Errorf("%s (request_id: %s)", reason.Message(), requestID)
+}
+
+// connectOptions builds the NATS connection options, including authentication
+func (c *Client) connectOptions() ([]natsclient.Option, error) {
+	// Build connection options with preallocated capacity
+	opts := make([]natsclient.Option, 0, 8)
+	opts = append(opts,
+		natsclient.Timeout(5*time.Second),
+		natsclient.Name("nats-k8s-oidc-callout"),
+		// Keep reconnecting however long NATS is away; the hostname is resolved on every attempt
+		natsclient.MaxReconnects(-1),
+		natsclient.DisconnectErrHandler(func(_ *natsclient.Conn, err error) {
+			// Closing the connection also reports a disconnect, without an error
+			if err != nil {
+				c.logger.Warn("disconnected from NATS", zap.Error(err))
+			}
+		}),
+		natsclient.ReconnectHandler(func(conn *natsclient.Conn) {
+			c.logger.Info("reconnected to NATS", zap.String("server", conn.ConnectedUrlRedacted()),
+				zap.String("nats_server", conn.ConnectedServerName()),
+				zap.String("nats_cluster", conn.ConnectedClusterName()))
+			httpmetrics.SetNATSServer(c.account, conn.ConnectedServerName(), conn.ConnectedClusterName())
+		}),
 	)
+
+	// Add authentication based on configured method
+	// Priority: User credentials > Token > Username and password > URL-embedded credentials
+	authOpts, err := c.configureAuthentication()
 	if err != nil {
-		conn.Close()
-		return fmt.Errorf("failed to create authorization service: %w", err)
+		return nil, fmt.Errorf("failed to configure authentication: %w", err)
 	}
+	opts = append(opts, authOpts...)
 
-	c.service = service
-	return nil
+	if c.urlOnly {
+		dialer, err := newURLDialer(c.url, 5*time.Second)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, natsclient.SetCustomDialer(dialer), natsclient.SkipHostLookup())
+	}
+	return opts, nil
+}
+
+// CheckConnection connects to NATS with the client's credentials, without starting the callout
+// service, and closes the connection again. It returns the server connected to.
+func (c *Client) CheckConnection(timeout time.Duration) (string, error) {
+	opts, err := c.connectOptions()
+	if err != nil {
+		return "", err
+	}
+	opts = append(opts, natsclient.Timeout(timeout), natsclient.NoReconnect())
+
+	conn, err := natsclient.Connect(c.url, opts...)
+	if err != nil {
+		return "", fmt.Errorf("failed to connect to NATS: %w", err)
+	}
+	defer conn.Close()
+	return conn.ConnectedUrlRedacted(), nil
 }
 
 // configureAuthentication configures NATS connection authentication options based on the configured method.
-// Priority: User credentials > Token > URL-embedded credentials
+// Priority: User credentials > Token > Username and password > URL-embedded credentials
 func (c *Client) configureAuthentication() ([]natsclient.Option, error) {
 	var opts []natsclient.Option
 
@@ -246,6 +836,13 @@ func (c *Client) configureAuthentication() ([]natsclient.Option, error) {
 		return opts, nil
 	}
 
+	if c.username != "" {
+		c.logger.Info("using username and password for NATS authentication",
+			zap.String("username", c.username))
+		opts = append(opts, natsclient.UserInfo(c.username, c.password))
+		return opts, nil
+	}
+
 	// URL-embedded credentials
 	c.logger.Info("using URL-embedded credentials for NATS authentication (username/password in URL)")
 
@@ -275,16 +872,57 @@ func (c *Client) configureAuthentication() ([]natsclient.Option, error) {
 	return opts, nil
 }
 
+// ConnectionStats describe a client's NATS connection, for the stats endpoint
+type ConnectionStats struct {
+	Account    string `json:"account"`
+	State      string `json:"state"` // nats.go connection status, e.g. CONNECTED or RECONNECTING
+	Server     string `json:"server,omitempty"`
+	ServerName string `json:"serverName,omitempty"` // name of the NATS server connected to
+	Cluster    string `json:"cluster,omitempty"`    // its cluster, if clustered
+	Reconnects uint64 `json:"reconnects"`
+}
+
+// ConnectionStats returns the state of the NATS connection. It must not be called
+// concurrently with Start.
+func (c *Client) ConnectionStats() ConnectionStats {
+	stats := ConnectionStats{Account: c.account, State: "NOT_STARTED"}
+	if c.conn != nil {
+		stats.State = c.conn.Status().String()
+		stats.Server = c.conn.ConnectedUrlRedacted()
+		stats.ServerName = c.conn.ConnectedServerName()
+		stats.Cluster = c.conn.ConnectedClusterName()
+		stats.Reconnects = c.conn.Stats().Reconnects
+	}
+	return stats
+}
+
+// Conn returns the NATS connection, or nil before Start. It must not be called concurrently
+// with Start.
+func (c *Client) Conn() *natsclient.Conn {
+	return c.conn
+}
+
+// Account returns the NATS account the client assigns authorized clients to
+func (c *Client) Account() string {
+	return c.account
+}
+
 // Shutdown gracefully shuts down the client
 func (c *Client) Shutdown(ctx context.Context) error {
+	c.serviceMu.Lock()
+	c.closed = true
 	if c.service != nil {
 		if err := c.service.Stop(); err != nil {
 			c.logger.Error("failed to stop NATS service", zap.Error(err))
 		}
+		c.service = nil
+		c.trackWorker(false)
 	}
+	c.serviceMu.Unlock()
 
 	if c.conn != nil {
 		c.conn.Close()
+		httpmetrics.SetNATSServer(c.account, "", "")
 	}
 
 	return nil
@@ -355,6 +993,33 @@ func LoadSigningKeyFromFile(path string) (nkeys.KeyPair, error) {
 	return kp, nil
 }
 
+// LoadScopedSigningKeys loads one scoped signing key per file in dir, keyed by role: the file
+// name without an optional ".nk" extension. Files whose names start with "." are skipped, so a
+// mounted Kubernetes Secret can be used directly.
+func LoadScopedSigningKeys(dir string) (map[string]nkeys.KeyPair, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read scoped signing key directory: %w", err)
+	}
+
+	keys := make(map[string]nkeys.KeyPair)
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		role := strings.TrimSuffix(entry.Name(), ".nk")
+		kp, err := LoadSigningKeyFromFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("role %s: %w", role, err)
+		}
+		keys[role] = kp
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no scoped signing keys found in %s", dir)
+	}
+	return keys, nil
+}
+
 // LoadSigningKeyFromCredsFile parses a NATS credentials file and extracts the account seed
 // Credentials file format:
 //
@@ -418,24 +1083,34 @@ func isSeedSectionEnd(line string) bool {
 
 // extractToken extracts the JWT token from the authorization request
 // The token should be provided by the client in the connection options
-func (c *Client) extractToken(req *jwt.AuthorizationRequest) string {
-	c.logger.Debug("extracting token from auth request",
+func extractToken(logger *zap.Logger, req *jwt.AuthorizationRequest) string {
+	logger.Debug("extracting token from auth request",
 		zap.String("jwt_field", logging.RedactJWT(req.ConnectOptions.JWT)),
 		zap.String("token_field", logging.RedactJWT(req.ConnectOptions.Token)),
 		zap.String("username", req.ConnectOptions.Username))
 
 	// Check for JWT in connect options (standard field)
 	if req.ConnectOptions.JWT != "" {
-		c.logger.Debug("token found in JWT field")
+		logger.Debug("token found in JWT field")
 		return req.ConnectOptions.JWT
 	}
 
 	// Alternative: check for auth_token field
 	if req.ConnectOptions.Token != "" {
-		c.logger.Debug("token found in Token field")
+		logger.Debug("token found in Token field")
 		return req.ConnectOptions.Token
 	}
 
-	c.logger.Debug("no token found in auth request")
+	logger.Debug("no token found in auth request")
 	return ""
 }
+
+// connectionType returns the listener a client connected to, as one of the auth.Connection*
+// types. Leafnode connections report the type of the listener too, so their kind takes
+// precedence.
+func connectionType(info jwt.ClientInformation) string {
+	if info.Kind == "Leafnode" {
+		return auth.ConnectionLeafnode
+	}
+	return info.Type
+}
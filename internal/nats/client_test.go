@@ -3,15 +3,21 @@ package nats
 import (
 	"context"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/nats-io/jwt/v2"
+	natsclient "github.com/nats-io/nats.go"
 	"github.com/nats-io/nkeys"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	"golang.org/x/time/rate"
 
 	internalAuth "github.com/portswigger-tim/nats-k8s-oidc-callout/internal/auth"
+	"github.com/portswigger-tim/nats-k8s-oidc-callout/internal/logging"
 )
 
 // Mock auth handler for testing
@@ -80,7 +86,7 @@ func TestClient_AuthorizationFailure(t *testing.T) {
 		authorizeFunc: func(req *internalAuth.AuthRequest) *internalAuth.AuthResponse {
 			return &internalAuth.AuthResponse{
 				Allowed: false,
-				Error:   "authorization failed",
+				Reason:  internalAuth.ReasonUnknownServiceAccount,
 			}
 		},
 	}
@@ -95,8 +101,8 @@ func TestClient_AuthorizationFailure(t *testing.T) {
 		t.Error("Expected authorization to be denied")
 	}
 
-	if resp.Error != "authorization failed" {
-		t.Errorf("Expected error message, got: %s", resp.Error)
+	if resp.Reason != internalAuth.ReasonUnknownServiceAccount {
+		t.Errorf("Reason = %q, want %q", resp.Reason, internalAuth.ReasonUnknownServiceAccount)
 	}
 }
 
@@ -167,13 +173,12 @@ func TestExtractToken(t *testing.T) {
 		},
 	}
 
-	// Create a minimal client for testing with a no-op logger
+	// No-op logger for testing
 	logger := zap.NewNop()
-	client := &Client{logger: logger}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := client.extractToken(tt.request)
+			got := extractToken(logger, tt.request)
 			if got != tt.wantJWT {
 				t.Errorf("extractToken() = %q, want %q", got, tt.wantJWT)
 			}
@@ -211,7 +216,7 @@ func TestClient_AuthorizerFunction(t *testing.T) {
 			authHandler: func(req *internalAuth.AuthRequest) *internalAuth.AuthResponse {
 				return &internalAuth.AuthResponse{
 					Allowed: false,
-					Error:   "authorization failed",
+					Reason:  internalAuth.ReasonUnknownServiceAccount,
 				}
 			},
 			wantError:  true,
@@ -263,7 +268,7 @@ func TestClient_AuthorizerFunction(t *testing.T) {
 			}
 
 			// Call the internal authorizer logic (simulate)
-			token := client.extractToken(req)
+			token := extractToken(logger, req)
 
 			if token == "" {
 				// Should be rejected
@@ -313,6 +318,389 @@ func TestClient_AuthorizerFunction(t *testing.T) {
 	}
 }
 
+// TestClient_SafeAuthorizeRecoversPanic tests that a panicking handler results in a denial
+func TestClient_SafeAuthorizeRecoversPanic(t *testing.T) {
+	authHandler := &mockAuthHandler{
+		authorizeFunc: func(req *internalAuth.AuthRequest) *internalAuth.AuthResponse {
+			panic("malformed request")
+		},
+	}
+
+	client, err := NewClient("nats://localhost:4222", "", "", "$G", authHandler, zap.NewNop())
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	signingKey, _ := nkeys.CreateAccount()
+	client.SetSigningKey(signingKey)
+
+	userKey, _ := nkeys.CreateUser()
+	userPubKey, _ := userKey.PublicKey()
+	req := &jwt.AuthorizationRequest{
+		UserNkey:       userPubKey,
+		ConnectOptions: jwt.ConnectOptions{JWT: "valid.jwt.token"},
+	}
+
+	encoded, err := client.safeAuthorize(req)
+	if err == nil {
+		t.Fatal("Expected error from panicking handler")
+	}
+	if encoded != "" {
+		t.Errorf("Expected no user JWT, got %q", encoded)
+	}
+	if !strings.HasPrefix(err.Error(), internalAuth.ReasonInternalError.Message()+" (request_id: ") {
+		t.Errorf("Got error %q, want %q with request ID", err.Error(), internalAuth.ReasonInternalError.Message())
+	}
+}
+
+// TestClient_RequestDeadline tests that requests handled past the deadline are abandoned
+func TestClient_RequestDeadline(t *testing.T) {
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		wantErr bool
+	}{
+		{name: "past deadline", timeout: 10 * time.Millisecond, wantErr: true},
+		{name: "deadline disabled", timeout: 0, wantErr: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authHandler := &mockAuthHandler{
+				authorizeFunc: func(req *internalAuth.AuthRequest) *internalAuth.AuthResponse {
+					time.Sleep(50 * time.Millisecond)
+					return &internalAuth.AuthResponse{
+						Allowed:              true,
+						PublishPermissions:   []string{"test.>"},
+						SubscribePermissions: []string{"_INBOX.>"},
+						Reason:               internalAuth.ReasonAllowed,
+					}
+				},
+			}
+
+			client, err := NewClient("nats://localhost:4222", "", "", "$G", authHandler, zap.NewNop())
+			if err != nil {
+				t.Fatalf("Failed to create client: %v", err)
+			}
+			signingKey, _ := nkeys.CreateAccount()
+			client.SetSigningKey(signingKey)
+			client.SetRequestTimeout(tt.timeout)
+
+			userKey, _ := nkeys.CreateUser()
+			userPubKey, _ := userKey.PublicKey()
+			encoded, err := client.safeAuthorize(&jwt.AuthorizationRequest{
+				UserNkey:       userPubKey,
+				ConnectOptions: jwt.ConnectOptions{JWT: "valid.jwt.token"},
+			})
+
+			if !tt.wantErr {
+				if err != nil || encoded == "" {
+					t.Fatalf("Expected authorization to succeed, got %v", err)
+				}
+				return
+			}
+			if encoded != "" {
+				t.Errorf("Expected no user JWT, got %q", encoded)
+			}
+			if err == nil || !strings.HasPrefix(err.Error(), internalAuth.ReasonDeadlineExceeded.Message()) {
+				t.Errorf("Got error %v, want %q", err, internalAuth.ReasonDeadlineExceeded.Message())
+			}
+		})
+	}
+}
+
+// TestClient_SlowAuthorization tests that authorizations over the threshold log their stage timings
+func TestClient_SlowAuthorization(t *testing.T) {
+	authHandler := &mockAuthHandler{
+		authorizeFunc: func(req *internalAuth.AuthRequest) *internalAuth.AuthResponse {
+			time.Sleep(20 * time.Millisecond)
+			return &internalAuth.AuthResponse{
+				Allowed:              true,
+				PublishPermissions:   []string{"test.>"},
+				SubscribePermissions: []string{"_INBOX.>"},
+				Timings:              internalAuth.Timings{Validation: 15 * time.Millisecond},
+				Reason:               internalAuth.ReasonAllowed,
+			}
+		},
+	}
+
+	for _, threshold := range []time.Duration{10 * time.Millisecond, time.Second} {
+		core, logs := observer.New(zapcore.WarnLevel)
+		client, err := NewClient("nats://localhost:4222", "", "", "$G", authHandler, zap.New(core))
+		if err != nil {
+			t.Fatalf("Failed to create client: %v", err)
+		}
+		signingKey, _ := nkeys.CreateAccount()
+		client.SetSigningKey(signingKey)
+		client.SetSlowThreshold(threshold)
+
+		userKey, _ := nkeys.CreateUser()
+		userPubKey, _ := userKey.PublicKey()
+		if _, err := client.safeAuthorize(&jwt.AuthorizationRequest{
+			UserNkey:       userPubKey,
+			ConnectOptions: jwt.ConnectOptions{JWT: "valid.jwt.token"},
+		}); err != nil {
+			t.Fatalf("Expected authorization to succeed, got %v", err)
+		}
+
+		slow := logs.FilterMessage("slow authorization").All()
+		if threshold == time.Second {
+			if len(slow) != 0 {
+				t.Errorf("threshold %v: expected no slow authorization warning, got %d", threshold, len(slow))
+			}
+			continue
+		}
+		if len(slow) != 1 {
+			t.Fatalf("threshold %v: expected one slow authorization warning, got %d", threshold, len(slow))
+		}
+		fields := slow[0].ContextMap()
+		if fields["jwt_validation"] != 15*time.Millisecond {
+			t.Errorf("jwt_validation = %v, want 15ms", fields["jwt_validation"])
+		}
+		if handler, _ := fields["handler"].(time.Duration); handler < 20*time.Millisecond {
+			t.Errorf("handler = %v, want at least 20ms", fields["handler"])
+		}
+		if fields["reason"] != string(internalAuth.ReasonAllowed) {
+			t.Errorf("reason = %v, want %q", fields["reason"], internalAuth.ReasonAllowed)
+		}
+	}
+}
+
+// TestClient_OverrideKeepsIdentity tests that denials the client makes after the handler allowed
+// the request are audited with the identity
+func TestClient_OverrideKeepsIdentity(t *testing.T) {
+	tests := []struct {
+		name        string
+		resp        internalAuth.AuthResponse
+		requireNkey bool
+		wantReason  internalAuth.ReasonCode
+	}{
+		{
+			name:       "unknown role",
+			resp:       internalAuth.AuthResponse{Role: "unknown"},
+			wantReason: internalAuth.ReasonUnknownRole,
+		},
+		{
+			name:        "nkey unverified",
+			requireNkey: true,
+			wantReason:  internalAuth.ReasonNkeyUnverified,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authHandler := &mockAuthHandler{
+				authorizeFunc: func(req *internalAuth.AuthRequest) *internalAuth.AuthResponse {
+					resp := tt.resp
+					resp.Allowed = true
+					resp.Identity = "production/app"
+					resp.Namespace = "production"
+					resp.ServiceAccount = "app"
+					resp.Reason = internalAuth.ReasonAllowed
+					return &resp
+				},
+			}
+			core, logs := observer.New(zapcore.InfoLevel)
+			client, err := NewClient("nats://localhost:4222", "", "", "$G", authHandler, zap.New(core))
+			if err != nil {
+				t.Fatalf("Failed to create client: %v", err)
+			}
+			signingKey, _ := nkeys.CreateAccount()
+			client.SetSigningKey(signingKey)
+			client.SetRequireNkey(tt.requireNkey)
+
+			userKey, _ := nkeys.CreateUser()
+			userPubKey, _ := userKey.PublicKey()
+			if _, err := client.safeAuthorize(&jwt.AuthorizationRequest{
+				UserNkey:       userPubKey,
+				ConnectOptions: jwt.ConnectOptions{JWT: "valid.jwt.token"},
+			}); err == nil {
+				t.Fatal("Expected authorization to be denied")
+			}
+
+			decisions := logs.FilterMessage("authorization decision").All()
+			if len(decisions) != 1 {
+				t.Fatalf("expected one audit record, got %d", len(decisions))
+			}
+			fields := decisions[0].ContextMap()
+			if fields["reason"] != string(tt.wantReason) || fields["identity"] != "production/app" {
+				t.Errorf("audited %s for %q, want %s for production/app", fields["reason"], fields["identity"], tt.wantReason)
+			}
+		})
+	}
+}
+
+// TestClient_AccessLog tests that each authorization writes one access log line, including
+// denials the client makes after the handler allowed the request
+func TestClient_AccessLog(t *testing.T) {
+	authHandler := &mockAuthHandler{
+		authorizeFunc: func(req *internalAuth.AuthRequest) *internalAuth.AuthResponse {
+			return &internalAuth.AuthResponse{
+				Allowed:              true,
+				PublishPermissions:   []string{"test.>"},
+				SubscribePermissions: []string{"_INBOX.>"},
+				Role:                 "unknown",
+				Identity:             "production/app",
+				Reason:               internalAuth.ReasonAllowed,
+			}
+		},
+	}
+
+	core, logs := observer.New(zapcore.InfoLevel)
+	client, err := NewClient("nats://localhost:4222", "", "", "$G", authHandler, zap.NewNop())
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	signingKey, _ := nkeys.CreateAccount()
+	client.SetSigningKey(signingKey)
+	client.SetAccessLogger(zap.New(core))
+
+	userKey, _ := nkeys.CreateUser()
+	userPubKey, _ := userKey.PublicKey()
+	req := &jwt.AuthorizationRequest{UserNkey: userPubKey, ConnectOptions: jwt.ConnectOptions{JWT: "valid.jwt.token"}}
+	req.ClientInformation.Host = "10.0.0.7"
+	req.Server.Name = "nats-1"
+	if _, err := client.safeAuthorize(req); err == nil {
+		t.Fatal("Expected authorization with an unknown role to be denied")
+	}
+
+	entries := logs.All()
+	if len(entries) != 1 {
+		t.Fatalf("expected one access log line, got %d", len(entries))
+	}
+	fields := entries[0].ContextMap()
+	want := map[string]interface{}{
+		"identity":    "production/app",
+		"client_host": "10.0.0.7",
+		"nats_server": "nats-1",
+		"result":      "denied",
+		"reason":      string(internalAuth.ReasonUnknownRole),
+	}
+	for key, value := range want {
+		if fields[key] != value {
+			t.Errorf("%s = %v, want %v", key, fields[key], value)
+		}
+	}
+	if fields["request_id"] == "" {
+		t.Error("expected a request_id")
+	}
+	if traceID, _ := fields["trace_id"].(string); len(traceID) != 32 {
+		t.Errorf("trace_id = %v, want a 32 digit trace ID", fields["trace_id"])
+	}
+	if _, ok := fields["duration"].(time.Duration); !ok {
+		t.Errorf("duration = %v, want a duration", fields["duration"])
+	}
+}
+
+// recordedSpans records exported spans
+type recordedSpans struct {
+	spans []logging.Span
+}
+
+func (r *recordedSpans) Export(span logging.Span) {
+	r.spans = append(r.spans, span)
+}
+
+// TestClient_Span tests that each authorization exports a span with the trace ID of its log lines
+func TestClient_Span(t *testing.T) {
+	authHandler := &mockAuthHandler{
+		authorizeFunc: func(req *internalAuth.AuthRequest) *internalAuth.AuthResponse {
+			return &internalAuth.AuthResponse{
+				Allowed:              true,
+				PublishPermissions:   []string{"test.>"},
+				SubscribePermissions: []string{"_INBOX.>"},
+				Identity:             "production/app",
+				Reason:               internalAuth.ReasonAllowed,
+			}
+		},
+	}
+
+	core, logs := observer.New(zapcore.InfoLevel)
+	client, err := NewClient("nats://localhost:4222", "", "", "$G", authHandler, zap.NewNop())
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	signingKey, _ := nkeys.CreateAccount()
+	client.SetSigningKey(signingKey)
+	client.SetAccessLogger(zap.New(core))
+	spans := &recordedSpans{}
+	client.SetSpanExporter(spans)
+
+	userKey, _ := nkeys.CreateUser()
+	userPubKey, _ := userKey.PublicKey()
+	req := &jwt.AuthorizationRequest{UserNkey: userPubKey, ConnectOptions: jwt.ConnectOptions{JWT: "valid.jwt.token"}}
+	req.Server.Name = "nats-1"
+	if _, err := client.safeAuthorize(req); err != nil {
+		t.Fatalf("safeAuthorize() error = %v", err)
+	}
+
+	if len(spans.spans) != 1 || len(logs.All()) != 1 {
+		t.Fatalf("got %d spans and %d access log lines, want one of each", len(spans.spans), len(logs.All()))
+	}
+	span, fields := spans.spans[0], logs.All()[0].ContextMap()
+	if span.TraceID == "" || span.TraceID != fields["trace_id"] {
+		t.Errorf("span trace ID = %q, want the access log's %v", span.TraceID, fields["trace_id"])
+	}
+	if span.Name != "authorize" || span.Error != "" || span.End.Before(span.Start) {
+		t.Errorf("span = %+v, want a successful authorize span", span)
+	}
+	if span.Attributes["result"] != "allowed" || span.Attributes["identity"] != "production/app" ||
+		span.Attributes["nats_server"] != "nats-1" || span.Attributes["request_id"] != fields["request_id"] {
+		t.Errorf("span attributes = %v", span.Attributes)
+	}
+}
+
+// TestClient_BearerUser tests that bearer responses produce bearer user JWTs
+func TestClient_BearerUser(t *testing.T) {
+	for _, bearer := range []bool{false, true} {
+		authHandler := &mockAuthHandler{
+			authorizeFunc: func(req *internalAuth.AuthRequest) *internalAuth.AuthResponse {
+				return &internalAuth.AuthResponse{
+					Allowed:              true,
+					PublishPermissions:   []string{"test.>"},
+					SubscribePermissions: []string{"_INBOX.>"},
+					Bearer:               bearer,
+					Reason:               internalAuth.ReasonAllowed,
+				}
+			},
+		}
+
+		client, err := NewClient("nats://localhost:4222", "", "", "$G", authHandler, zap.NewNop())
+		if err != nil {
+			t.Fatalf("Failed to create client: %v", err)
+		}
+		signingKey, _ := nkeys.CreateAccount()
+		client.SetSigningKey(signingKey)
+
+		userKey, _ := nkeys.CreateUser()
+		userPubKey, _ := userKey.PublicKey()
+		req := &jwt.AuthorizationRequest{
+			UserNkey:       userPubKey,
+			ConnectOptions: jwt.ConnectOptions{JWT: "valid.jwt.token"},
+		}
+
+		encoded, err := client.safeAuthorize(req)
+		if err != nil {
+			t.Fatalf("Expected authorization to succeed, got %v", err)
+		}
+		uc, err := jwt.DecodeUserClaims(encoded)
+		if err != nil {
+			t.Fatalf("Failed to decode user claims: %v", err)
+		}
+		if uc.BearerToken != bearer {
+			t.Errorf("BearerToken = %v, want %v", uc.BearerToken, bearer)
+		}
+	}
+}
+
+// TestDenialError tests that denials carry the reason message and request ID
+func TestDenialError(t *testing.T) {
+	err := denialError(internalAuth.ReasonTokenExpired, "REQ123")
+	want := "authorization failed: token expired (request_id: REQ123)"
+	if err.Error() != want {
+		t.Errorf("denialError() = %q, want %q", err.Error(), want)
+	}
+}
+
 // TestClient_NewClient tests client creation edge cases
 func TestClient_NewClient(t *testing.T) {
 	authHandler := &mockAuthHandler{
@@ -395,6 +783,388 @@ func TestClient_UserClaimsExpiration(t *testing.T) {
 	}
 }
 
+// TestClient_TokenTTL tests that a requested lifetime can shorten but never extend user JWTs
+func TestClient_TokenTTL(t *testing.T) {
+	tests := []struct {
+		name string
+		ttl  time.Duration
+		want time.Duration
+	}{
+		{name: "default", ttl: 0, want: 2 * time.Minute},
+		{name: "shorter", ttl: 30 * time.Second, want: 30 * time.Second},
+		{name: "longer is capped", ttl: time.Hour, want: 2 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authHandler := &mockAuthHandler{
+				authorizeFunc: func(req *internalAuth.AuthRequest) *internalAuth.AuthResponse {
+					return &internalAuth.AuthResponse{Allowed: true, TokenTTL: tt.ttl, Reason: internalAuth.ReasonAllowed}
+				},
+			}
+			client, err := NewClient("nats://localhost:4222", "", "", "$G", authHandler, zap.NewNop())
+			if err != nil {
+				t.Fatalf("Failed to create client: %v", err)
+			}
+			signingKey, _ := nkeys.CreateAccount()
+			client.SetSigningKey(signingKey)
+			client.SetTokenExpiry(2 * time.Minute)
+
+			userKey, _ := nkeys.CreateUser()
+			userPubKey, _ := userKey.PublicKey()
+			before := time.Now()
+			encoded, err := client.safeAuthorize(&jwt.AuthorizationRequest{
+				UserNkey:       userPubKey,
+				ConnectOptions: jwt.ConnectOptions{JWT: "valid.jwt.token"},
+			})
+			if err != nil {
+				t.Fatalf("Expected authorization to succeed, got %v", err)
+			}
+			uc, err := jwt.DecodeUserClaims(encoded)
+			if err != nil {
+				t.Fatalf("Failed to decode user claims: %v", err)
+			}
+
+			if got := time.Unix(uc.Expires, 0).Sub(before.Truncate(time.Second)); got < tt.want || got > tt.want+time.Second {
+				t.Errorf("expiry in %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestClient_ResponseClass tests the user claim shape for each request-reply class
+func TestClient_ResponseClass(t *testing.T) {
+	tests := []struct {
+		name     string
+		class    string
+		pubPerms []string
+		wantResp *jwt.ResponsePermission
+		wantDeny bool
+	}{
+		{name: "standard", pubPerms: []string{"orders.>"}, wantResp: &jwt.ResponsePermission{MaxMsgs: 1}},
+		{name: "responder", class: internalAuth.ClassResponder, wantResp: &jwt.ResponsePermission{MaxMsgs: 5, Expires: 10 * time.Second}, wantDeny: true},
+		{name: "requester", class: internalAuth.ClassRequester, pubPerms: []string{"orders.>"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authHandler := &mockAuthHandler{
+				authorizeFunc: func(req *internalAuth.AuthRequest) *internalAuth.AuthResponse {
+					return &internalAuth.AuthResponse{
+						Allowed:              true,
+						PublishPermissions:   tt.pubPerms,
+						SubscribePermissions: []string{"_INBOX.>"},
+						Class:                tt.class,
+						Reason:               internalAuth.ReasonAllowed,
+					}
+				},
+			}
+			client, err := NewClient("nats://localhost:4222", "", "", "$G", authHandler, zap.NewNop())
+			if err != nil {
+				t.Fatalf("Failed to create client: %v", err)
+			}
+			signingKey, _ := nkeys.CreateAccount()
+			client.SetSigningKey(signingKey)
+			client.SetResponderLimits(5, 10*time.Second)
+
+			userKey, _ := nkeys.CreateUser()
+			userPubKey, _ := userKey.PublicKey()
+			encoded, err := client.safeAuthorize(&jwt.AuthorizationRequest{
+				UserNkey:       userPubKey,
+				ConnectOptions: jwt.ConnectOptions{JWT: "valid.jwt.token"},
+			})
+			if err != nil {
+				t.Fatalf("Expected authorization to succeed, got %v", err)
+			}
+			uc, err := jwt.DecodeUserClaims(encoded)
+			if err != nil {
+				t.Fatalf("Failed to decode user claims: %v", err)
+			}
+
+			switch {
+			case tt.wantResp == nil && uc.Resp != nil:
+				t.Errorf("Resp = %+v, want none", uc.Resp)
+			case tt.wantResp != nil && (uc.Resp == nil || *uc.Resp != *tt.wantResp):
+				t.Errorf("Resp = %+v, want %+v", uc.Resp, tt.wantResp)
+			}
+			// An empty allow list would otherwise allow publishing anywhere
+			if denied := contains(uc.Pub.Deny, ">"); denied != tt.wantDeny {
+				t.Errorf("publish deny-all = %v, want %v", denied, tt.wantDeny)
+			}
+		})
+	}
+}
+
+// TestClient_UserLimits tests that default user limits apply and requests only lower them
+func TestClient_UserLimits(t *testing.T) {
+	tests := []struct {
+		name      string
+		requested internalAuth.UserLimits
+		want      jwt.NatsLimits
+	}{
+		{name: "defaults", want: jwt.NatsLimits{Subs: 100, Payload: 1024, Data: jwt.NoLimit}},
+		{
+			name:      "lower requests apply",
+			requested: internalAuth.UserLimits{Subscriptions: 10, Payload: 512, Data: 4096},
+			want:      jwt.NatsLimits{Subs: 10, Payload: 512, Data: 4096},
+		},
+		{
+			name:      "higher requests are capped",
+			requested: internalAuth.UserLimits{Subscriptions: 1000, Payload: 1 << 20},
+			want:      jwt.NatsLimits{Subs: 100, Payload: 1024, Data: jwt.NoLimit},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authHandler := &mockAuthHandler{
+				authorizeFunc: func(req *internalAuth.AuthRequest) *internalAuth.AuthResponse {
+					return &internalAuth.AuthResponse{Allowed: true, Limits: tt.requested, Reason: internalAuth.ReasonAllowed}
+				},
+			}
+			client, err := NewClient("nats://localhost:4222", "", "", "$G", authHandler, zap.NewNop())
+			if err != nil {
+				t.Fatalf("Failed to create client: %v", err)
+			}
+			signingKey, _ := nkeys.CreateAccount()
+			client.SetSigningKey(signingKey)
+			client.SetDefaultLimits(100, 1024, jwt.NoLimit)
+
+			userKey, _ := nkeys.CreateUser()
+			userPubKey, _ := userKey.PublicKey()
+			encoded, err := client.safeAuthorize(&jwt.AuthorizationRequest{
+				UserNkey:       userPubKey,
+				ConnectOptions: jwt.ConnectOptions{JWT: "valid.jwt.token"},
+			})
+			if err != nil {
+				t.Fatalf("Expected authorization to succeed, got %v", err)
+			}
+			uc, err := jwt.DecodeUserClaims(encoded)
+			if err != nil {
+				t.Fatalf("Failed to decode user claims: %v", err)
+			}
+
+			if uc.NatsLimits != tt.want {
+				t.Errorf("limits = %+v, want %+v", uc.NatsLimits, tt.want)
+			}
+		})
+	}
+}
+
+// TestClient_DeniedSubjects tests that the denied subjects are denied for publish and
+// subscribe in every user JWT, even when the identity is allowed everything, along with the
+// identity's own denied subjects
+func TestClient_DeniedSubjects(t *testing.T) {
+	authHandler := &mockAuthHandler{
+		authorizeFunc: func(req *internalAuth.AuthRequest) *internalAuth.AuthResponse {
+			return &internalAuth.AuthResponse{
+				Allowed:              true,
+				PublishPermissions:   []string{">"},
+				SubscribePermissions: []string{">"},
+				PublishDenied:        []string{"$JS.API.STREAM.PURGE.*"},
+				SubscribeDenied:      []string{"events.internal.>"},
+				Reason:               internalAuth.ReasonAllowed,
+			}
+		},
+	}
+	client, err := NewClient("nats://localhost:4222", "", "", "$G", authHandler, zap.NewNop())
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	signingKey, _ := nkeys.CreateAccount()
+	client.SetSigningKey(signingKey)
+	denied := []string{"$SYS.>", "$JS.API.STREAM.DELETE.*"}
+	client.SetDeniedSubjects(denied)
+
+	userKey, _ := nkeys.CreateUser()
+	userPubKey, _ := userKey.PublicKey()
+	encoded, err := client.safeAuthorize(&jwt.AuthorizationRequest{
+		UserNkey:       userPubKey,
+		ConnectOptions: jwt.ConnectOptions{JWT: "valid.jwt.token"},
+	})
+	if err != nil {
+		t.Fatalf("Expected authorization to succeed, got %v", err)
+	}
+	uc, err := jwt.DecodeUserClaims(encoded)
+	if err != nil {
+		t.Fatalf("Failed to decode user claims: %v", err)
+	}
+
+	for _, subject := range denied {
+		if !contains(uc.Pub.Deny, subject) {
+			t.Errorf("Pub.Deny = %v, want it to contain %q", uc.Pub.Deny, subject)
+		}
+		if !contains(uc.Sub.Deny, subject) {
+			t.Errorf("Sub.Deny = %v, want it to contain %q", uc.Sub.Deny, subject)
+		}
+	}
+	if !contains(uc.Pub.Deny, "$JS.API.STREAM.PURGE.*") || contains(uc.Sub.Deny, "$JS.API.STREAM.PURGE.*") {
+		t.Errorf("Pub.Deny = %v, Sub.Deny = %v; want the identity's denied publish subjects in Pub.Deny only", uc.Pub.Deny, uc.Sub.Deny)
+	}
+	if !contains(uc.Sub.Deny, "events.internal.>") || contains(uc.Pub.Deny, "events.internal.>") {
+		t.Errorf("Pub.Deny = %v, Sub.Deny = %v; want the identity's denied subscribe subjects in Sub.Deny only", uc.Pub.Deny, uc.Sub.Deny)
+	}
+	if !contains(uc.Pub.Allow, ">") || !contains(uc.Sub.Allow, ">") {
+		t.Errorf("allow lists = %v, %v; want the identity's permissions kept", uc.Pub.Allow, uc.Sub.Allow)
+	}
+}
+
+// TestClient_RateLimit tests that requests beyond the rate limit are denied without calling
+// the handler
+func TestClient_RateLimit(t *testing.T) {
+	calls := 0
+	authHandler := &mockAuthHandler{
+		authorizeFunc: func(req *internalAuth.AuthRequest) *internalAuth.AuthResponse {
+			calls++
+			return &internalAuth.AuthResponse{Allowed: true, Reason: internalAuth.ReasonAllowed}
+		},
+	}
+	client, err := NewClient("nats://localhost:4222", "", "", "$G", authHandler, zap.NewNop())
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	signingKey, _ := nkeys.CreateAccount()
+	client.SetSigningKey(signingKey)
+	// A burst of one that never refills
+	client.SetRateLimiter(rate.NewLimiter(0, 1))
+
+	request := func() error {
+		userKey, _ := nkeys.CreateUser()
+		userPubKey, _ := userKey.PublicKey()
+		_, err := client.safeAuthorize(&jwt.AuthorizationRequest{
+			UserNkey:       userPubKey,
+			ConnectOptions: jwt.ConnectOptions{JWT: "valid.jwt.token"},
+		})
+		return err
+	}
+
+	if err := request(); err != nil {
+		t.Fatalf("Expected the first authorization to succeed, got %v", err)
+	}
+	err = request()
+	if err == nil || !strings.HasPrefix(err.Error(), internalAuth.ReasonRateLimited.Message()) {
+		t.Errorf("Got error %v, want %q", err, internalAuth.ReasonRateLimited.Message())
+	}
+	if calls != 1 {
+		t.Errorf("handler called %d times, want 1", calls)
+	}
+}
+
+// TestClient_ScopedRole tests that identities selecting a role get user JWTs signed with
+// the role's scoped signing key and no permissions of their own
+func TestClient_ScopedRole(t *testing.T) {
+	accountKey, _ := nkeys.CreateAccount()
+	accountPubKey, _ := accountKey.PublicKey()
+	scopedKey, _ := nkeys.CreateAccount()
+	scopedPubKey, _ := scopedKey.PublicKey()
+
+	tests := []struct {
+		name    string
+		role    string
+		wantErr bool
+	}{
+		{name: "scoped role", role: "orders-reader"},
+		{name: "unknown role", role: "orders-writer", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authHandler := &mockAuthHandler{
+				authorizeFunc: func(req *internalAuth.AuthRequest) *internalAuth.AuthResponse {
+					return &internalAuth.AuthResponse{
+						Allowed:              true,
+						PublishPermissions:   []string{"orders.>"},
+						SubscribePermissions: []string{"_INBOX.>"},
+						Bearer:               true,
+						Role:                 tt.role,
+						Reason:               internalAuth.ReasonAllowed,
+					}
+				},
+			}
+
+			client, err := NewClient("nats://localhost:4222", "", "", "$G", authHandler, zap.NewNop())
+			if err != nil {
+				t.Fatalf("Failed to create client: %v", err)
+			}
+			client.SetSigningKey(accountKey)
+			if err := client.SetScopedSigningKeys(map[string]nkeys.KeyPair{"orders-reader": scopedKey}, accountPubKey); err != nil {
+				t.Fatalf("SetScopedSigningKeys() error = %v", err)
+			}
+
+			userKey, _ := nkeys.CreateUser()
+			userPubKey, _ := userKey.PublicKey()
+			encoded, err := client.safeAuthorize(&jwt.AuthorizationRequest{
+				UserNkey:       userPubKey,
+				ConnectOptions: jwt.ConnectOptions{JWT: "valid.jwt.token"},
+			})
+
+			if tt.wantErr {
+				if err == nil || !strings.HasPrefix(err.Error(), internalAuth.ReasonUnknownRole.Message()) {
+					t.Errorf("Got error %v, want %q", err, internalAuth.ReasonUnknownRole.Message())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected authorization to succeed, got %v", err)
+			}
+			uc, err := jwt.DecodeUserClaims(encoded)
+			if err != nil {
+				t.Fatalf("Failed to decode user claims: %v", err)
+			}
+			if uc.Issuer != scopedPubKey {
+				t.Errorf("Issuer = %q, want scoped key %q", uc.Issuer, scopedPubKey)
+			}
+			if uc.IssuerAccount != accountPubKey {
+				t.Errorf("IssuerAccount = %q, want %q", uc.IssuerAccount, accountPubKey)
+			}
+			if !uc.HasEmptyPermissions() {
+				t.Errorf("Expected no permissions or limits, got %+v", uc.UserPermissionLimits)
+			}
+		})
+	}
+}
+
+// TestClient_SetScopedSigningKeysInvalidIssuer tests that the issuer must be an account public key
+func TestClient_SetScopedSigningKeysInvalidIssuer(t *testing.T) {
+	client, err := NewClient("nats://localhost:4222", "", "", "$G", &mockAuthHandler{}, zap.NewNop())
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	userKey, _ := nkeys.CreateUser()
+	userPubKey, _ := userKey.PublicKey()
+	if err := client.SetScopedSigningKeys(nil, userPubKey); err == nil {
+		t.Error("SetScopedSigningKeys() expected error for a user public key")
+	}
+}
+
+// TestLoadScopedSigningKeys tests loading scoped signing keys from a mounted Secret directory
+func TestLoadScopedSigningKeys(t *testing.T) {
+	dir := t.TempDir()
+	key, _ := nkeys.CreateAccount()
+	seed, _ := key.Seed()
+	for _, name := range []string{"orders-reader", "orders-writer.nk"} {
+		if err := os.WriteFile(filepath.Join(dir, name), seed, 0o600); err != nil {
+			t.Fatalf("Failed to write key: %v", err)
+		}
+	}
+	// Kubernetes Secret volumes hold their data in hidden directories
+	if err := os.Mkdir(filepath.Join(dir, "..data"), 0o700); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+
+	keys, err := LoadScopedSigningKeys(dir)
+	if err != nil {
+		t.Fatalf("LoadScopedSigningKeys() error = %v", err)
+	}
+	if len(keys) != 2 || keys["orders-reader"] == nil || keys["orders-writer"] == nil {
+		t.Errorf("LoadScopedSigningKeys() roles = %v, want orders-reader and orders-writer", keys)
+	}
+
+	if _, err := LoadScopedSigningKeys(t.TempDir()); err == nil {
+		t.Error("LoadScopedSigningKeys() expected error for an empty directory")
+	}
+}
+
 // TestClient_PermissionsMapping tests mapping auth response to NATS claims
 func TestClient_PermissionsMapping(t *testing.T) {
 	userKey, _ := nkeys.CreateUser()
@@ -467,6 +1237,33 @@ func contains(list jwt.StringList, s string) bool {
 	return false
 }
 
+// TestClient_UserPassword tests that a separate username and password authenticate the
+// connection and leave the URL untouched
+func TestClient_UserPassword(t *testing.T) {
+	client, err := NewClient("nats://nats:4222", "", "", "$G", &mockAuthHandler{}, zap.NewNop())
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	client.SetUserPassword("auth", "secret")
+
+	opts, err := client.configureAuthentication()
+	if err != nil {
+		t.Fatalf("configureAuthentication() error = %v", err)
+	}
+	var options natsclient.Options
+	for _, opt := range opts {
+		if err := opt(&options); err != nil {
+			t.Fatalf("option error = %v", err)
+		}
+	}
+	if options.User != "auth" || options.Password != "secret" {
+		t.Errorf("User, Password = %q, %q, want %q, %q", options.User, options.Password, "auth", "secret")
+	}
+	if client.url != "nats://nats:4222" {
+		t.Errorf("url = %q, want it unchanged", client.url)
+	}
+}
+
 // TestClient_WithValidCredentialsFile tests creating a client with a valid credentials file
 func TestClient_WithValidCredentialsFile(t *testing.T) {
 	// Create a temporary credentials file
@@ -601,3 +1398,51 @@ func TestClient_WithEmptyCredentialsFile(t *testing.T) {
 		t.Errorf("Client credsFile should be empty, got %q", client.credsFile)
 	}
 }
+
+// TestClient_TransportInformation tests that the connection's listener type and TLS state are
+// passed to the auth handler
+func TestClient_TransportInformation(t *testing.T) {
+	var got *internalAuth.AuthRequest
+	authHandler := &mockAuthHandler{
+		authorizeFunc: func(req *internalAuth.AuthRequest) *internalAuth.AuthResponse {
+			got = req
+			return &internalAuth.AuthResponse{Allowed: false, Reason: internalAuth.ReasonTransportDenied}
+		},
+	}
+	client, err := NewClient("nats://localhost:4222", "", "", "$G", authHandler, zap.NewNop())
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	signingKey, _ := nkeys.CreateAccount()
+	client.SetSigningKey(signingKey)
+
+	userKey, _ := nkeys.CreateUser()
+	userPubKey, _ := userKey.PublicKey()
+	tests := []struct {
+		name     string
+		info     jwt.ClientInformation
+		tls      *jwt.ClientTLS
+		wantType string
+		wantTLS  bool
+	}{
+		{name: "plain client", info: jwt.ClientInformation{Kind: "Client", Type: "nats"}, wantType: internalAuth.ConnectionNATS},
+		{name: "websocket over TLS", info: jwt.ClientInformation{Kind: "Client", Type: "websocket"}, tls: &jwt.ClientTLS{Version: "1.3"}, wantType: internalAuth.ConnectionWebSocket, wantTLS: true},
+		{name: "leafnode", info: jwt.ClientInformation{Kind: "Leafnode", Type: "nats"}, wantType: internalAuth.ConnectionLeafnode},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _ = client.safeAuthorize(&jwt.AuthorizationRequest{
+				UserNkey:          userPubKey,
+				ClientInformation: tt.info,
+				ConnectOptions:    jwt.ConnectOptions{JWT: "valid.jwt.token"},
+				TLS:               tt.tls,
+			})
+			if got == nil {
+				t.Fatal("auth handler not called")
+			}
+			if got.ConnectionType != tt.wantType || got.TLS != tt.wantTLS {
+				t.Errorf("ConnectionType, TLS = %q, %v; want %q, %v", got.ConnectionType, got.TLS, tt.wantType, tt.wantTLS)
+			}
+		})
+	}
+}
@@ -0,0 +1,310 @@
+package nats
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	natsclient "github.com/nats-io/nats.go"
+	"go.uber.org/zap"
+
+	"github.com/portswigger-tim/nats-k8s-oidc-callout/internal/auth"
+	httpmetrics "github.com/portswigger-tim/nats-k8s-oidc-callout/internal/httpserver"
+)
+
+// System account subjects the connection registry listens and sends requests on
+const (
+	connectEventSubject    = "$SYS.ACCOUNT.%s.CONNECT"
+	disconnectEventSubject = "$SYS.ACCOUNT.%s.DISCONNECT"
+	connzRequestSubject    = "$SYS.REQ.ACCOUNT.%s.CONNZ"
+)
+
+// connzWait is how long the registry collects CONNZ responses from the servers of the cluster
+const connzWait = 2 * time.Second
+
+// connectionEvent is the part of a $SYS.ACCOUNT.<account>.CONNECT or DISCONNECT event the
+// registry reads
+type connectionEvent struct {
+	Server struct {
+		ID string `json:"id"`
+	} `json:"server"`
+	Client struct {
+		ID   uint64 `json:"id"`
+		User string `json:"user"`
+	} `json:"client"`
+}
+
+// connzResponse is the part of a server's CONNZ response the registry reads
+type connzResponse struct {
+	Server struct {
+		ID string `json:"id"`
+	} `json:"server"`
+	Data struct {
+		Connections []struct {
+			CID  uint64 `json:"cid"`
+			User string `json:"authorized_user"`
+		} `json:"connections"`
+	} `json:"data"`
+}
+
+// connectionKey identifies a client connection in the cluster
+type connectionKey struct {
+	server string
+	cid    uint64
+}
+
+// RegistryStats describes the connections the registry tracks, for /debug/stats
+type RegistryStats struct {
+	Connections int       `json:"connections"`
+	Identities  int       `json:"identities"`
+	LastSync    time.Time `json:"lastSync,omitzero"`
+}
+
+// ConnectionRegistry tracks the open client connections of each identity across the NATS
+// cluster, from the connect and disconnect events of the system account. Clients are only
+// attributed to an identity when their user JWT is named after it, which the callout does for
+// clients it authorizes while the registry is set (see Client.SetConnectionLimit).
+type ConnectionRegistry struct {
+	url       string
+	credsFile string // credentials of a system account user
+	logger    *zap.Logger
+
+	mu          sync.Mutex
+	conn        *natsclient.Conn
+	accounts    []string
+	connections map[connectionKey]string // open connection -> identity
+	counts      map[string]int           // identity -> open connections
+	lastSync    time.Time
+}
+
+// NewConnectionRegistry creates a registry that connects to NATS with the credentials of a
+// system account user
+func NewConnectionRegistry(natsURL, credsFile string, logger *zap.Logger) *ConnectionRegistry {
+	return &ConnectionRegistry{
+		url:         natsURL,
+		credsFile:   credsFile,
+		logger:      logger,
+		connections: make(map[connectionKey]string),
+		counts:      make(map[string]int),
+	}
+}
+
+// Start connects to NATS, subscribes to the connection events of accounts and loads the
+// connections already open. Events missed while disconnected are recovered by loading the open
+// connections again after every reconnect.
+func (r *ConnectionRegistry) Start(accounts []string) error {
+	conn, err := natsclient.Connect(r.url,
+		natsclient.Name("nats-k8s-oidc-callout-registry"),
+		natsclient.UserCredentials(r.credsFile),
+		natsclient.Timeout(5*time.Second),
+		natsclient.MaxReconnects(-1),
+		natsclient.ReconnectHandler(func(*natsclient.Conn) {
+			go r.sync()
+		}),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to connect to NATS with the system account credentials: %w", err)
+	}
+
+	for _, account := range accounts {
+		if _, err := conn.Subscribe(fmt.Sprintf(connectEventSubject, account), r.handleConnect); err != nil {
+			conn.Close()
+			return fmt.Errorf("failed to subscribe to connect events of account %s: %w", account, err)
+		}
+		if _, err := conn.Subscribe(fmt.Sprintf(disconnectEventSubject, account), r.handleDisconnect); err != nil {
+			conn.Close()
+			return fmt.Errorf("failed to subscribe to disconnect events of account %s: %w", account, err)
+		}
+	}
+	if err := conn.Flush(); err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to subscribe to connection events: %w", err)
+	}
+
+	r.mu.Lock()
+	r.conn = conn
+	r.accounts = accounts
+	r.mu.Unlock()
+
+	r.sync()
+	return nil
+}
+
+// Count returns the open connections of an identity
+func (r *ConnectionRegistry) Count(identity string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.counts[identity]
+}
+
+// Stats returns the number of tracked connections and identities
+func (r *ConnectionRegistry) Stats() RegistryStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return RegistryStats{Connections: len(r.connections), Identities: len(r.counts), LastSync: r.lastSync}
+}
+
+// Close closes the registry's NATS connection
+func (r *ConnectionRegistry) Close() {
+	r.mu.Lock()
+	conn := r.conn
+	r.mu.Unlock()
+	if conn != nil {
+		conn.Close()
+	}
+}
+
+// handleConnect records a connection from a CONNECT event
+func (r *ConnectionRegistry) handleConnect(msg *natsclient.Msg) {
+	var event connectionEvent
+	if err := json.Unmarshal(msg.Data, &event); err != nil {
+		r.logger.Warn("invalid connect event", zap.String("subject", msg.Subject), zap.Error(err))
+		return
+	}
+	if event.Client.User == "" {
+		return
+	}
+	r.mu.Lock()
+	r.add(connectionKey{event.Server.ID, event.Client.ID}, event.Client.User)
+	r.mu.Unlock()
+}
+
+// handleDisconnect forgets a connection from a DISCONNECT event
+func (r *ConnectionRegistry) handleDisconnect(msg *natsclient.Msg) {
+	var event connectionEvent
+	if err := json.Unmarshal(msg.Data, &event); err != nil {
+		r.logger.Warn("invalid disconnect event", zap.String("subject", msg.Subject), zap.Error(err))
+		return
+	}
+	r.mu.Lock()
+	r.remove(connectionKey{event.Server.ID, event.Client.ID})
+	r.mu.Unlock()
+}
+
+// add records an open connection. Lock must be held.
+func (r *ConnectionRegistry) add(key connectionKey, identity string) {
+	if _, found := r.connections[key]; found {
+		return
+	}
+	r.connections[key] = identity
+	r.counts[identity]++
+	httpmetrics.SetRegistryConnections(len(r.connections))
+}
+
+// remove forgets a connection. Lock must be held.
+func (r *ConnectionRegistry) remove(key connectionKey) {
+	identity, found := r.connections[key]
+	if !found {
+		return
+	}
+	delete(r.connections, key)
+	if r.counts[identity]--; r.counts[identity] <= 0 {
+		delete(r.counts, identity)
+	}
+	httpmetrics.SetRegistryConnections(len(r.connections))
+}
+
+// sync loads the open connections of every account from the servers of the cluster. The
+// connections of each server that responds replace those recorded for it; servers that do not
+// respond within connzWait keep theirs.
+func (r *ConnectionRegistry) sync() {
+	r.mu.Lock()
+	conn, accounts := r.conn, r.accounts
+	r.mu.Unlock()
+	if conn == nil {
+		return
+	}
+
+	found := make(map[string]map[connectionKey]string) // server -> its open connections
+	for _, account := range accounts {
+		responses, err := r.connz(conn, account)
+		if err != nil {
+			r.logger.Warn("failed to load open connections", zap.String("account", account), zap.Error(err))
+			return
+		}
+		for _, resp := range responses {
+			if found[resp.Server.ID] == nil {
+				found[resp.Server.ID] = make(map[connectionKey]string)
+			}
+			for _, c := range resp.Data.Connections {
+				if c.User != "" {
+					found[resp.Server.ID][connectionKey{resp.Server.ID, c.CID}] = c.User
+				}
+			}
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for key := range r.connections {
+		if _, responded := found[key.server]; responded {
+			r.remove(key)
+		}
+	}
+	for _, connections := range found {
+		for key, identity := range connections {
+			r.add(key, identity)
+		}
+	}
+	r.lastSync = time.Now()
+	r.logger.Debug("loaded open connections", zap.Int("servers", len(found)), zap.Int("connections", len(r.connections)))
+}
+
+// connz collects the CONNZ responses of the servers with clients in an account
+func (r *ConnectionRegistry) connz(conn *natsclient.Conn, account string) ([]connzResponse, error) {
+	inbox := conn.NewInbox()
+	sub, err := conn.SubscribeSync(inbox)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = sub.Unsubscribe() }()
+
+	// Open connections with their authorized user, in pages large enough for any account
+	request := []byte(`{"auth":true,"state":0,"limit":100000}`)
+	if err := conn.PublishRequest(fmt.Sprintf(connzRequestSubject, account), inbox, request); err != nil {
+		return nil, err
+	}
+
+	var responses []connzResponse
+	deadline := time.Now().Add(connzWait)
+	for {
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			return responses, nil
+		}
+		msg, err := sub.NextMsg(wait)
+		if err != nil {
+			// The deadline passing ends the collection
+			return responses, nil
+		}
+		var resp connzResponse
+		if err := json.Unmarshal(msg.Data, &resp); err != nil {
+			r.logger.Warn("invalid CONNZ response", zap.Error(err))
+			continue
+		}
+		responses = append(responses, resp)
+	}
+}
+
+// SetConnectionLimit attributes the connections of the clients the callout authorizes to their
+// identity in registry, and denies ServiceAccounts holding max open connections with
+// auth.ReasonConnectionLimit (0 for no limit). ServiceAccounts may request a lower limit (see
+// auth.ConnectionLimitPolicy), or one of their own when max is 0. A nil registry disables it.
+func (c *Client) SetConnectionLimit(registry *ConnectionRegistry, max int) {
+	c.connections = registry
+	c.maxConns = max
+}
+
+// connectionLimitReached reports whether a ServiceAccount already holds the most connections
+// allowed. Non-Kubernetes identities are not limited.
+func (c *Client) connectionLimitReached(authResp *auth.AuthResponse) bool {
+	if c.connections == nil || authResp.Namespace == "" {
+		return false
+	}
+	limit := c.maxConns
+	if requested := authResp.MaxConnections; requested > 0 && (limit == 0 || requested < limit) {
+		limit = requested
+	}
+	return limit > 0 && c.connections.Count(authResp.Identity) >= limit
+}
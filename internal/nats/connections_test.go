@@ -0,0 +1,123 @@
+package nats
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/nats-io/jwt/v2"
+	natsclient "github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+	"go.uber.org/zap"
+
+	internalAuth "github.com/portswigger-tim/nats-k8s-oidc-callout/internal/auth"
+)
+
+// connectionEventMsg returns a connect or disconnect event of a client connection
+func connectionEventMsg(server string, cid uint64, user string) *natsclient.Msg {
+	return &natsclient.Msg{
+		Subject: "$SYS.ACCOUNT.TestAccount.CONNECT",
+		Data:    fmt.Appendf(nil, `{"server":{"id":%q},"client":{"id":%d,"user":%q}}`, server, cid, user),
+	}
+}
+
+// TestConnectionRegistry_Events tests that connections are counted per identity from the
+// connect and disconnect events
+func TestConnectionRegistry_Events(t *testing.T) {
+	registry := NewConnectionRegistry("nats://localhost:4222", "", zap.NewNop())
+
+	registry.handleConnect(connectionEventMsg("S1", 1, "orders/api"))
+	registry.handleConnect(connectionEventMsg("S2", 1, "orders/api"))
+	registry.handleConnect(connectionEventMsg("S1", 2, "billing/worker"))
+	registry.handleConnect(connectionEventMsg("S1", 1, "orders/api")) // redelivered
+	registry.handleConnect(connectionEventMsg("S1", 3, ""))           // not named after an identity
+
+	if got := registry.Count("orders/api"); got != 2 {
+		t.Errorf("Count(orders/api) = %d, want 2", got)
+	}
+	if stats := registry.Stats(); stats.Connections != 3 || stats.Identities != 2 {
+		t.Errorf("Stats() = %+v, want 3 connections of 2 identities", stats)
+	}
+
+	registry.handleDisconnect(connectionEventMsg("S2", 1, "orders/api"))
+	registry.handleDisconnect(connectionEventMsg("S1", 2, "billing/worker"))
+	registry.handleDisconnect(connectionEventMsg("S3", 9, "orders/api")) // never seen
+	if got := registry.Count("orders/api"); got != 1 {
+		t.Errorf("Count(orders/api) = %d after a disconnect, want 1", got)
+	}
+	if stats := registry.Stats(); stats.Connections != 1 || stats.Identities != 1 {
+		t.Errorf("Stats() = %+v, want 1 connection of 1 identity", stats)
+	}
+}
+
+// TestClient_ConnectionLimit tests that ServiceAccounts at their connection limit are denied,
+// and that issued user JWTs are named after the identity
+func TestClient_ConnectionLimit(t *testing.T) {
+	tests := []struct {
+		name      string
+		max       int
+		requested int
+		open      int
+		wantErr   bool
+	}{
+		{name: "under the limit", max: 2, open: 1},
+		{name: "at the limit", max: 2, open: 2, wantErr: true},
+		{name: "lower limit requested", max: 5, requested: 1, open: 1, wantErr: true},
+		{name: "higher limit requested", max: 1, requested: 5, open: 1, wantErr: true},
+		{name: "limit requested without a default", requested: 1, open: 1, wantErr: true},
+		{name: "no limit", open: 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authHandler := &mockAuthHandler{
+				authorizeFunc: func(req *internalAuth.AuthRequest) *internalAuth.AuthResponse {
+					return &internalAuth.AuthResponse{
+						Allowed:            true,
+						PublishPermissions: []string{"orders.>"},
+						Identity:           "orders/api",
+						Namespace:          "orders",
+						ServiceAccount:     "api",
+						MaxConnections:     tt.requested,
+						Reason:             internalAuth.ReasonAllowed,
+					}
+				},
+			}
+			client, err := NewClient("nats://localhost:4222", "", "", "$G", authHandler, zap.NewNop())
+			if err != nil {
+				t.Fatalf("Failed to create client: %v", err)
+			}
+			signingKey, _ := nkeys.CreateAccount()
+			client.SetSigningKey(signingKey)
+
+			registry := NewConnectionRegistry("nats://localhost:4222", "", zap.NewNop())
+			for i := range tt.open {
+				registry.handleConnect(connectionEventMsg("S1", uint64(i+1), "orders/api"))
+			}
+			client.SetConnectionLimit(registry, tt.max)
+
+			userKey, _ := nkeys.CreateUser()
+			userPubKey, _ := userKey.PublicKey()
+			encoded, err := client.safeAuthorize(&jwt.AuthorizationRequest{
+				UserNkey:       userPubKey,
+				ConnectOptions: jwt.ConnectOptions{JWT: "valid.jwt.token"},
+			})
+			if tt.wantErr {
+				if err == nil || !strings.HasPrefix(err.Error(), internalAuth.ReasonConnectionLimit.Message()) {
+					t.Errorf("Got error %v, want %q", err, internalAuth.ReasonConnectionLimit.Message())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected authorization to succeed, got %v", err)
+			}
+			uc, err := jwt.DecodeUserClaims(encoded)
+			if err != nil {
+				t.Fatalf("Failed to decode user JWT: %v", err)
+			}
+			if uc.Name != "orders/api" {
+				t.Errorf("user JWT name = %q, want orders/api", uc.Name)
+			}
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package nats
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// urlDialer dials the hosts of the configured NATS URLs in turn, whichever server the NATS
+// client picked from its pool. The hostnames are resolved anew by every dial, so the client
+// follows a Service to NATS pods that moved instead of retrying the pod IPs it discovered from
+// the cluster.
+type urlDialer struct {
+	dialer *net.Dialer
+	hosts  []string // host:port of each configured URL
+	next   atomic.Uint64
+}
+
+// newURLDialer creates a dialer for the comma-separated NATS URLs
+func newURLDialer(natsURL string, timeout time.Duration) (*urlDialer, error) {
+	d := &urlDialer{dialer: &net.Dialer{Timeout: timeout}}
+	for _, raw := range strings.Split(natsURL, ",") {
+		u, err := url.Parse(strings.TrimSpace(raw))
+		if err != nil || u.Hostname() == "" {
+			return nil, fmt.Errorf("invalid NATS URL %q", raw)
+		}
+		port := u.Port()
+		if port == "" {
+			port = "4222"
+		}
+		d.hosts = append(d.hosts, net.JoinHostPort(u.Hostname(), port))
+	}
+	return d, nil
+}
+
+// Dial implements nats.CustomDialer, ignoring the address of the server picked from the pool
+func (d *urlDialer) Dial(network, _ string) (net.Conn, error) {
+	host := d.hosts[(d.next.Add(1)-1)%uint64(len(d.hosts))]
+	return d.dialer.Dial(network, host)
+}
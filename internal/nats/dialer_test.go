@@ -0,0 +1,57 @@
+package nats
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestURLDialer tests that the dialer dials the configured URL whatever address it is given
+func TestURLDialer(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	dialer, err := newURLDialer("nats://localhost:"+port, time.Second)
+	if err != nil {
+		t.Fatalf("newURLDialer() error = %v", err)
+	}
+
+	// A discovered server whose pod has gone away
+	conn, err := dialer.Dial("tcp", "10.255.255.1:4222")
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer conn.Close()
+	if conn.RemoteAddr().String() != listener.Addr().String() {
+		t.Errorf("dialed %s, want the configured URL %s", conn.RemoteAddr(), listener.Addr())
+	}
+}
+
+// TestNewURLDialer tests parsing the comma-separated NATS URLs
+func TestNewURLDialer(t *testing.T) {
+	dialer, err := newURLDialer("nats://nats-0.nats:4222, tls://nats.example.com", time.Second)
+	if err != nil {
+		t.Fatalf("newURLDialer() error = %v", err)
+	}
+	if got := strings.Join(dialer.hosts, ","); got != "nats-0.nats:4222,nats.example.com:4222" {
+		t.Errorf("hosts = %s, want nats-0.nats:4222,nats.example.com:4222", got)
+	}
+
+	if _, err := newURLDialer("nats://:4222", time.Second); err == nil {
+		t.Error("expected an error for a URL without a host")
+	}
+}
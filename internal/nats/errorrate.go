@@ -0,0 +1,184 @@
+package nats
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/portswigger-tim/nats-k8s-oidc-callout/internal/auth"
+	httpmetrics "github.com/portswigger-tim/nats-k8s-oidc-callout/internal/httpserver"
+)
+
+// ErrorRateMonitor tracks the share of authorizations failing with internal errors over a
+// sliding window. Once the share reaches the threshold the monitor trips: the instance
+// reports not ready and the client leaves the callout queue group, so that the server routes
+// authorization requests to healthy replicas. The client rejoins after one window.
+type ErrorRateMonitor struct {
+	mu          sync.Mutex
+	threshold   float64
+	window      time.Duration
+	minRequests int
+	buckets     []rateBucket // one per second of the window, indexed by Unix second
+	trippedAt   time.Time
+	rate        float64 // failure share that tripped the monitor
+	now         func() time.Time
+}
+
+// rateBucket counts the authorizations of one second
+type rateBucket struct {
+	second int64
+	total  int
+	failed int
+}
+
+// NewErrorRateMonitor creates a monitor that trips once at least threshold (0 to 1) of the
+// authorizations in the window failed with internal errors. Windows with fewer than
+// minRequests authorizations never trip it.
+func NewErrorRateMonitor(threshold float64, window time.Duration, minRequests int) *ErrorRateMonitor {
+	seconds := int(window / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	return &ErrorRateMonitor{
+		threshold:   threshold,
+		window:      window,
+		minRequests: minRequests,
+		buckets:     make([]rateBucket, seconds),
+		now:         time.Now,
+	}
+}
+
+// Record records the outcome of an authorization and reports whether it tripped the monitor.
+// Outcomes are ignored while the monitor is tripped.
+func (m *ErrorRateMonitor) Record(failed bool) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.trippedAt.IsZero() {
+		return false
+	}
+	now := m.now()
+	second := now.Unix()
+	bucket := &m.buckets[second%int64(len(m.buckets))]
+	if bucket.second != second {
+		*bucket = rateBucket{second: second}
+	}
+	bucket.total++
+	if failed {
+		bucket.failed++
+	}
+
+	total, failures := 0, 0
+	for _, b := range m.buckets {
+		if second-b.second < int64(len(m.buckets)) {
+			total += b.total
+			failures += b.failed
+		}
+	}
+	if total < m.minRequests {
+		return false
+	}
+	rate := float64(failures) / float64(total)
+	if rate < m.threshold {
+		return false
+	}
+	m.trippedAt = now
+	m.rate = rate
+	return true
+}
+
+// Tripped reports whether the monitor has tripped, with a description of the failure rate.
+func (m *ErrorRateMonitor) Tripped() (bool, string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.trippedAt.IsZero() {
+		return false, ""
+	}
+	return true, fmt.Sprintf("%.0f%% of authorizations failed with internal errors over %s; out of the callout queue group for %s",
+		m.rate*100, m.window, m.now().Sub(m.trippedAt).Truncate(time.Second))
+}
+
+// Reset clears the tripped state and the recorded outcomes.
+func (m *ErrorRateMonitor) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.trippedAt = time.Time{}
+	m.rate = 0
+	clear(m.buckets)
+}
+
+// SetErrorRateMonitor makes the client leave the callout queue group while the monitor is
+// tripped, rejoining after the monitor's window. A nil monitor disables it.
+func (c *Client) SetErrorRateMonitor(monitor *ErrorRateMonitor) {
+	c.errorRate = monitor
+}
+
+// ErrorRateTripped reports whether the client has left the callout queue group because too
+// many authorizations failed with internal errors, with a description of the failure rate.
+func (c *Client) ErrorRateTripped() (bool, string) {
+	if c.errorRate == nil {
+		return false, ""
+	}
+	return c.errorRate.Tripped()
+}
+
+// recordOutcome feeds the outcome of an authorization to the error rate monitor, leaving the
+// queue group if it trips
+func (c *Client) recordOutcome(reason auth.ReasonCode) {
+	// Requests that panicked before a decision are recorded by safeAuthorize
+	if c.errorRate == nil || reason == "" {
+		return
+	}
+	if c.errorRate.Record(reason.Internal()) {
+		go c.leaveQueueGroup()
+	}
+}
+
+// leaveQueueGroup stops the callout service so the server routes authorization requests to
+// the other replicas, and schedules rejoining after the monitor's window
+func (c *Client) leaveQueueGroup() {
+	c.serviceMu.Lock()
+	defer c.serviceMu.Unlock()
+
+	if c.service == nil || c.closed {
+		return
+	}
+	_, message := c.errorRate.Tripped()
+	c.logger.Error("leaving the auth callout queue group",
+		zap.String("reason", message),
+		zap.Duration("rejoin_after", c.errorRate.window))
+	if err := c.service.Stop(); err != nil {
+		c.logger.Warn("failed to stop NATS service", zap.Error(err))
+	}
+	c.service = nil
+	c.trackWorker(false)
+	httpmetrics.SetErrorRateTripped(c.account, true)
+
+	time.AfterFunc(c.errorRate.window, c.rejoinQueueGroup)
+}
+
+// rejoinQueueGroup restarts the callout service after the client left the queue group,
+// retrying after another window if the service cannot be created
+func (c *Client) rejoinQueueGroup() {
+	c.serviceMu.Lock()
+	defer c.serviceMu.Unlock()
+
+	if c.closed {
+		return
+	}
+	service, err := c.newService()
+	if err != nil {
+		c.logger.Error("failed to rejoin the auth callout queue group", zap.Error(err))
+		time.AfterFunc(c.errorRate.window, c.rejoinQueueGroup)
+		return
+	}
+	c.service = service
+	c.trackWorker(true)
+	c.errorRate.Reset()
+	httpmetrics.SetErrorRateTripped(c.account, false)
+	c.logger.Info("rejoined the auth callout queue group")
+}
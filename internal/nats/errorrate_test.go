@@ -0,0 +1,97 @@
+package nats
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nkeys"
+	"go.uber.org/zap"
+
+	internalAuth "github.com/portswigger-tim/nats-k8s-oidc-callout/internal/auth"
+)
+
+// TestErrorRateMonitor tests tripping on the share of internal errors over the window
+func TestErrorRateMonitor(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	monitor := NewErrorRateMonitor(0.9, time.Minute, 10)
+	monitor.now = func() time.Time { return now }
+
+	// Too few requests to judge
+	for i := 0; i < 9; i++ {
+		if monitor.Record(true) {
+			t.Fatalf("tripped after %d requests, below the minimum", i+1)
+		}
+	}
+
+	// Outcomes older than the window are forgotten, so 18 failures are needed after 2
+	// successes to reach 90%
+	now = now.Add(time.Minute)
+	monitor.Record(false)
+	monitor.Record(false)
+	for i := 1; i <= 18; i++ {
+		now = now.Add(time.Second)
+		if tripped := monitor.Record(true); tripped != (i == 18) {
+			t.Fatalf("Record() after %d failures = %v, want %v", i, tripped, i == 18)
+		}
+	}
+
+	tripped, message := monitor.Tripped()
+	if !tripped || !strings.HasPrefix(message, "90% of authorizations failed") {
+		t.Errorf("Tripped() = %v, %q; want true with the failure rate", tripped, message)
+	}
+	if monitor.Record(true) {
+		t.Error("Record() tripped again while tripped")
+	}
+
+	monitor.Reset()
+	if tripped, _ := monitor.Tripped(); tripped {
+		t.Error("Tripped() = true after Reset()")
+	}
+	if monitor.Record(true) {
+		t.Error("Record() tripped on outcomes recorded before Reset()")
+	}
+}
+
+// TestClient_ErrorRate tests that internal errors trip the client's monitor and denials don't
+func TestClient_ErrorRate(t *testing.T) {
+	reason := internalAuth.ReasonTokenExpired
+	authHandler := &mockAuthHandler{
+		authorizeFunc: func(req *internalAuth.AuthRequest) *internalAuth.AuthResponse {
+			return &internalAuth.AuthResponse{Allowed: false, Reason: reason}
+		},
+	}
+
+	client, err := NewClient("nats://localhost:4222", "", "", "$G", authHandler, zap.NewNop())
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	signingKey, _ := nkeys.CreateAccount()
+	client.SetSigningKey(signingKey)
+	client.SetErrorRateMonitor(NewErrorRateMonitor(0.5, time.Minute, 4))
+
+	userKey, _ := nkeys.CreateUser()
+	userPubKey, _ := userKey.PublicKey()
+	req := &jwt.AuthorizationRequest{
+		UserNkey:       userPubKey,
+		ConnectOptions: jwt.ConnectOptions{JWT: "valid.jwt.token"},
+	}
+
+	for i := 0; i < 4; i++ {
+		_, _ = client.safeAuthorize(req)
+	}
+	if tripped, _ := client.ErrorRateTripped(); tripped {
+		t.Fatal("ErrorRateTripped() = true after client denials")
+	}
+
+	reason = internalAuth.ReasonInternalError
+	for i := 0; i < 4; i++ {
+		_, _ = client.safeAuthorize(req)
+	}
+	if tripped, message := client.ErrorRateTripped(); !tripped {
+		t.Errorf("ErrorRateTripped() = false after internal errors, want true")
+	} else if !strings.Contains(message, "50%") {
+		t.Errorf("ErrorRateTripped() message = %q, want the failure rate", message)
+	}
+}
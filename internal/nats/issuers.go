@@ -0,0 +1,87 @@
+package nats
+
+import (
+	"fmt"
+	"os"
+
+	"sigs.k8s.io/yaml"
+)
+
+// Issuer is an additional auth callout issuer account. Each issuer is served over its own
+// connection, whose account receives the authorization requests of clients connecting into
+// that account, and answered with the issuer's own signing key.
+type Issuer struct {
+	// Account is the NATS account authorized clients are assigned to (as NATS_ACCOUNT)
+	Account string `json:"account"`
+	// SigningKeyFile is the account signing key for this issuer (as NATS_SIGNING_KEY_FILE)
+	SigningKeyFile string `json:"signingKeyFile"`
+	// NewSigningKeyFile is the signing key a rotation moves to (as NATS_NEW_SIGNING_KEY_FILE)
+	NewSigningKeyFile string `json:"newSigningKeyFile,omitempty"`
+	// UserCredsFile and Token authenticate the issuer's connection; at most one may be set
+	UserCredsFile string `json:"userCredsFile,omitempty"`
+	Token         string `json:"token,omitempty"`
+	// ScopedKeysDir and IssuerAccount configure scoped signing key roles (as
+	// NATS_SCOPED_KEYS_DIR and NATS_ISSUER_ACCOUNT)
+	ScopedKeysDir string `json:"scopedKeysDir,omitempty"`
+	IssuerAccount string `json:"issuerAccount,omitempty"`
+}
+
+// LoadIssuersFile reads additional auth callout issuers from a YAML file:
+//
+//	issuers:
+//	  - account: BILLING
+//	    signingKeyFile: /etc/nats/billing/signing.key
+//	    userCredsFile: /etc/nats/billing/user.creds
+func LoadIssuersFile(path string) ([]Issuer, error) {
+	data, err := os.ReadFile(path) //nolint:gosec // path comes from configuration
+	if err != nil {
+		return nil, fmt.Errorf("failed to read issuers file: %w", err)
+	}
+
+	var file struct {
+		Issuers []Issuer `json:"issuers"`
+	}
+	if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse issuers file: %w", err)
+	}
+
+	accounts := make(map[string]bool, len(file.Issuers))
+	for i, issuer := range file.Issuers {
+		if issuer.Account == "" || issuer.SigningKeyFile == "" {
+			return nil, fmt.Errorf("issuer %d: account and signingKeyFile are required", i)
+		}
+		if issuer.IssuerAccount != "" && issuer.ScopedKeysDir == "" {
+			return nil, fmt.Errorf("issuer %s: issuerAccount requires scopedKeysDir", issuer.Account)
+		}
+		if accounts[issuer.Account] {
+			return nil, fmt.Errorf("issuer %s: account listed more than once", issuer.Account)
+		}
+		accounts[issuer.Account] = true
+	}
+	return file.Issuers, nil
+}
+
+// SetAccountIssuers lets ServiceAccounts that select an account with nats.io/account be placed
+// in the account of any of the given clients, one per issuer of the deployment. Their user JWTs
+// are signed with that issuer's signing key and carry its account as the audience, while the
+// authorization response is still signed by the client that received the request. The NATS
+// server must allow the callout to issue for those accounts (allowed_accounts in operator
+// mode). It must be called before Start.
+func (c *Client) SetAccountIssuers(clients []*Client) {
+	c.accountIssuers = make(map[string]*Client, len(clients))
+	for _, client := range clients {
+		if client != c {
+			c.accountIssuers[client.account] = client
+		}
+	}
+}
+
+// accountIssuer returns the client that issues user JWTs for an account selected by a
+// ServiceAccount: this client when none is selected or it is this client's own account, or nil
+// when no issuer serves it
+func (c *Client) accountIssuer(account string) *Client {
+	if account == "" || account == c.account {
+		return c
+	}
+	return c.accountIssuers[account]
+}
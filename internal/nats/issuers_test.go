@@ -0,0 +1,164 @@
+package nats
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nkeys"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	internalAuth "github.com/portswigger-tim/nats-k8s-oidc-callout/internal/auth"
+)
+
+// TestLoadIssuersFile tests parsing and validation of the issuers file
+func TestLoadIssuersFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []Issuer
+		errMsg  string
+	}{
+		{
+			name: "valid",
+			content: `issuers:
+  - account: BILLING
+    signingKeyFile: /etc/nats/billing/signing.key
+    userCredsFile: /etc/nats/billing/user.creds
+  - account: ORDERS
+    signingKeyFile: /etc/nats/orders/signing.key
+    token: secret
+`,
+			want: []Issuer{
+				{Account: "BILLING", SigningKeyFile: "/etc/nats/billing/signing.key", UserCredsFile: "/etc/nats/billing/user.creds"},
+				{Account: "ORDERS", SigningKeyFile: "/etc/nats/orders/signing.key", Token: "secret"},
+			},
+		},
+		{
+			name:    "missing signing key",
+			content: "issuers:\n  - account: BILLING\n",
+			errMsg:  "signingKeyFile",
+		},
+		{
+			name: "duplicate account",
+			content: `issuers:
+  - account: BILLING
+    signingKeyFile: /a
+  - account: BILLING
+    signingKeyFile: /b
+`,
+			errMsg: "more than once",
+		},
+		{
+			name:    "unknown field",
+			content: "issuers:\n  - account: BILLING\n    signingKeyFile: /a\n    url: nats://other:4222\n",
+			errMsg:  "parse",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "issuers.yaml")
+			if err := os.WriteFile(path, []byte(tt.content), 0o600); err != nil {
+				t.Fatalf("Failed to write issuers file: %v", err)
+			}
+
+			got, err := LoadIssuersFile(path)
+			if tt.errMsg != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+					t.Fatalf("LoadIssuersFile() error = %v, want error containing %q", err, tt.errMsg)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadIssuersFile() error = %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("LoadIssuersFile() = %+v, want %+v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("issuer %d = %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+// TestClient_AccountIssuers tests that a ServiceAccount selecting another issuer's account is
+// issued a user JWT for that account, signed with its key and logged in its name, and denied for
+// unknown accounts
+func TestClient_AccountIssuers(t *testing.T) {
+	selected := ""
+	authHandler := &mockAuthHandler{
+		authorizeFunc: func(req *internalAuth.AuthRequest) *internalAuth.AuthResponse {
+			return &internalAuth.AuthResponse{
+				Allowed:            true,
+				PublishPermissions: []string{"billing.>"},
+				Account:            selected,
+				Reason:             internalAuth.ReasonAllowed,
+			}
+		},
+	}
+	newClient := func(account string) (*Client, string) {
+		client, err := NewClient("nats://localhost:4222", "", "", account, authHandler, zap.NewNop())
+		if err != nil {
+			t.Fatalf("Failed to create client: %v", err)
+		}
+		signingKey, _ := nkeys.CreateAccount()
+		client.SetSigningKey(signingKey)
+		public, _ := signingKey.PublicKey()
+		return client, public
+	}
+	primary, primaryKey := newClient("APP")
+	billing, billingKey := newClient("BILLING")
+	primary.SetAccountIssuers([]*Client{primary, billing})
+	core, logs := observer.New(zapcore.InfoLevel)
+	primary.SetAccessLogger(zap.New(core))
+
+	issue := func() (*jwt.UserClaims, error) {
+		userKey, _ := nkeys.CreateUser()
+		userPubKey, _ := userKey.PublicKey()
+		encoded, err := primary.safeAuthorize(&jwt.AuthorizationRequest{
+			UserNkey:       userPubKey,
+			ConnectOptions: jwt.ConnectOptions{JWT: "valid.jwt.token"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		return jwt.DecodeUserClaims(encoded)
+	}
+
+	tests := []struct {
+		account      string
+		wantAudience string
+		wantIssuer   string
+	}{
+		{account: "", wantAudience: "APP", wantIssuer: primaryKey},
+		{account: "APP", wantAudience: "APP", wantIssuer: primaryKey},
+		{account: "BILLING", wantAudience: "BILLING", wantIssuer: billingKey},
+	}
+	for _, tt := range tests {
+		selected = tt.account
+		uc, err := issue()
+		if err != nil {
+			t.Fatalf("account %q: expected authorization to succeed, got %v", tt.account, err)
+		}
+		if uc.Audience != tt.wantAudience || uc.Issuer != tt.wantIssuer {
+			t.Errorf("account %q: audience, issuer = %s, %s; want %s, %s", tt.account, uc.Audience, uc.Issuer, tt.wantAudience, tt.wantIssuer)
+		}
+		entries := logs.TakeAll()
+		if len(entries) != 1 || entries[0].ContextMap()["account"] != tt.wantAudience {
+			t.Errorf("account %q: access log %v, want account %s", tt.account, entries, tt.wantAudience)
+		}
+	}
+
+	selected = "ORDERS"
+	if _, err := issue(); err == nil || !strings.HasPrefix(err.Error(), internalAuth.ReasonUnknownAccount.Message()) {
+		t.Errorf("Got error %v, want %q", err, internalAuth.ReasonUnknownAccount.Message())
+	}
+}
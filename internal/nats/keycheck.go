@@ -0,0 +1,118 @@
+package nats
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/nats-io/nkeys"
+	"go.uber.org/zap"
+
+	httpmetrics "github.com/portswigger-tim/nats-k8s-oidc-callout/internal/httpserver"
+)
+
+// keyCheck is the result of the last signing key self-verification
+type keyCheck struct {
+	err error
+}
+
+// VerifySigningKey checks that a key can sign authorization responses and user JWTs: it must
+// be an account key holding its seed, and a test payload it signs must verify against its
+// public key. A seed of the wrong type or a corrupted key otherwise only shows when the NATS
+// server rejects every response.
+func VerifySigningKey(key nkeys.KeyPair) error {
+	public, err := key.PublicKey()
+	if err != nil {
+		return fmt.Errorf("failed to get public key: %w", err)
+	}
+	if !nkeys.IsValidPublicAccountKey(public) {
+		return fmt.Errorf("%s is a %s key, not an account key", public, nkeys.Prefix(public))
+	}
+	if _, err := key.Seed(); err != nil {
+		return fmt.Errorf("%s has no private key: %w", public, err)
+	}
+
+	payload := make([]byte, 32)
+	if _, err := rand.Read(payload); err != nil {
+		return fmt.Errorf("failed to generate test payload: %w", err)
+	}
+	signature, err := key.Sign(payload)
+	if err != nil {
+		return fmt.Errorf("%s failed to sign a test payload: %w", public, err)
+	}
+	verifier, err := nkeys.FromPublicKey(public)
+	if err != nil {
+		return fmt.Errorf("invalid public key %s: %w", public, err)
+	}
+	if err := verifier.Verify(payload, signature); err != nil {
+		return fmt.Errorf("signature of %s does not verify against its public key: %w", public, err)
+	}
+	return nil
+}
+
+// CheckSigningKeys verifies the signing key and the scoped signing keys, recording the result
+// for SigningKeyError and in the nats_auth_signing_key_valid metric
+func (c *Client) CheckSigningKeys() error {
+	err := c.verifySigningKeys()
+	c.keyCheck.Store(&keyCheck{err: err})
+	httpmetrics.SetSigningKeyValid(c.account, err == nil)
+	return err
+}
+
+// verifySigningKeys verifies every signing key of the client, scoped keys in role order
+func (c *Client) verifySigningKeys() error {
+	if c.signingKey == nil {
+		return fmt.Errorf("signing key not set")
+	}
+	if err := VerifySigningKey(c.signingKey); err != nil {
+		return fmt.Errorf("signing key: %w", err)
+	}
+	if c.newSigningKey != nil {
+		if err := VerifySigningKey(c.newSigningKey); err != nil {
+			return fmt.Errorf("new signing key: %w", err)
+		}
+	}
+	roles := make([]string, 0, len(c.scopedKeys))
+	for role := range c.scopedKeys {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+	for _, role := range roles {
+		if err := VerifySigningKey(c.scopedKeys[role]); err != nil {
+			return fmt.Errorf("scoped signing key of role %s: %w", role, err)
+		}
+	}
+	return nil
+}
+
+// WatchSigningKeys verifies the signing keys every interval until the context is cancelled,
+// logging when they start or stop failing
+func (c *Client) WatchSigningKeys(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			failing := c.SigningKeyError() != nil
+			if err := c.CheckSigningKeys(); err != nil && !failing {
+				c.logger.Error("signing key self-verification failed", zap.Error(err))
+			} else if err == nil && failing {
+				c.logger.Info("signing key self-verification passed again")
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// SigningKeyError returns the error of the last signing key self-verification, or nil when it
+// passed or has not run
+func (c *Client) SigningKeyError() error {
+	if check := c.keyCheck.Load(); check != nil {
+		return check.err
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package nats
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/nats-io/nkeys"
+	"go.uber.org/zap"
+)
+
+// TestVerifySigningKey tests that only account keys holding their seed pass
+func TestVerifySigningKey(t *testing.T) {
+	accountKey, _ := nkeys.CreateAccount()
+	userKey, _ := nkeys.CreateUser()
+	accountPubKey, _ := accountKey.PublicKey()
+	publicOnly, _ := nkeys.FromPublicKey(accountPubKey)
+
+	tests := []struct {
+		name    string
+		key     nkeys.KeyPair
+		wantErr string
+	}{
+		{name: "account key", key: accountKey},
+		{name: "user key", key: userKey, wantErr: "not an account key"},
+		{name: "public key only", key: publicOnly, wantErr: "no private key"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := VerifySigningKey(tt.key)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("VerifySigningKey() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("VerifySigningKey() error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestClient_CheckSigningKeys tests that the result of the last check covers the scoped keys
+func TestClient_CheckSigningKeys(t *testing.T) {
+	client, err := NewClient("nats://localhost:4222", "", "", "$G", &mockAuthHandler{}, zap.NewNop())
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	accountKey, _ := nkeys.CreateAccount()
+	client.SetSigningKey(accountKey)
+
+	if err := client.SigningKeyError(); err != nil {
+		t.Errorf("SigningKeyError() before a check = %v, want nil", err)
+	}
+	if err := client.CheckSigningKeys(); err != nil {
+		t.Fatalf("CheckSigningKeys() error = %v", err)
+	}
+
+	userKey, _ := nkeys.CreateUser()
+	if err := client.SetScopedSigningKeys(map[string]nkeys.KeyPair{"orders-reader": userKey}, ""); err != nil {
+		t.Fatalf("SetScopedSigningKeys() error = %v", err)
+	}
+	if err := client.CheckSigningKeys(); err == nil || !strings.Contains(err.Error(), "role orders-reader") {
+		t.Errorf("CheckSigningKeys() error = %v, want the scoped key of orders-reader rejected", err)
+	}
+	if err := client.SigningKeyError(); err == nil {
+		t.Error("SigningKeyError() = nil, want the failed check")
+	}
+}
@@ -0,0 +1,142 @@
+package nats
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	httpmetrics "github.com/portswigger-tim/nats-k8s-oidc-callout/internal/httpserver"
+)
+
+// Load measures the authorization load of the instance across its clients: the requests in
+// flight, the workers handling them (one callout service per account in the queue group) and,
+// sampled every interval, the request rate and the share of worker time spent busy. Unlike
+// CPU, these rise with the authorization traffic itself, so they suit autoscaling.
+type Load struct {
+	mu        sync.Mutex
+	workers   int
+	inFlight  int
+	startSum  time.Duration // sum of the start times of the requests in flight, since epoch
+	busy      time.Duration // busy time of the completed requests
+	completed int64
+	epoch     time.Time
+	now       func() time.Time
+
+	// State at the last sample
+	sampledAt        time.Time
+	sampledBusy      time.Duration
+	sampledCompleted int64
+	last             LoadStats
+}
+
+// LoadStats is a sample of the authorization load
+type LoadStats struct {
+	Workers           int     `json:"workers"`
+	InFlight          int     `json:"inFlight"`
+	RequestsPerSecond float64 `json:"requestsPerSecond"`
+	Utilization       float64 `json:"utilization"` // share of worker time spent busy, 0 to 1
+}
+
+// NewLoad creates a load tracker
+func NewLoad() *Load {
+	now := time.Now()
+	return &Load{epoch: now, sampledAt: now, now: time.Now}
+}
+
+// Run samples the load every interval, exporting it as metrics, until the context is cancelled
+func (l *Load) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			stats := l.Sample()
+			httpmetrics.SetAuthLoad(stats.RequestsPerSecond, stats.Utilization)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Sample returns the load since the previous sample. Only Run should call it, as each call
+// starts a new interval.
+func (l *Load) Sample() LoadStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	elapsed := now.Sub(l.sampledAt)
+	// Requests still in flight count as busy up to now
+	busy := l.busy + time.Duration(l.inFlight)*now.Sub(l.epoch) - l.startSum
+
+	stats := LoadStats{Workers: l.workers, InFlight: l.inFlight}
+	if elapsed > 0 {
+		stats.RequestsPerSecond = float64(l.completed-l.sampledCompleted) / elapsed.Seconds()
+		if l.workers > 0 {
+			stats.Utilization = min(float64(busy-l.sampledBusy)/float64(elapsed*time.Duration(l.workers)), 1)
+		}
+	}
+	l.sampledAt, l.sampledBusy, l.sampledCompleted = now, busy, l.completed
+	l.last = stats
+	return stats
+}
+
+// Stats returns the current workers and requests in flight, with the request rate and
+// utilization of the last sample
+func (l *Load) Stats() LoadStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	stats := l.last
+	stats.Workers, stats.InFlight = l.workers, l.inFlight
+	return stats
+}
+
+// begin records the start of a request, returning the function recording its end
+func (l *Load) begin() func() {
+	l.mu.Lock()
+	start := l.now().Sub(l.epoch)
+	l.inFlight++
+	l.startSum += start
+	httpmetrics.SetAuthRequestsInFlight(l.inFlight)
+	l.mu.Unlock()
+
+	return func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		d := l.now().Sub(l.epoch) - start
+		l.inFlight--
+		l.startSum -= start
+		l.busy += d
+		l.completed++
+		httpmetrics.SetAuthRequestsInFlight(l.inFlight)
+		httpmetrics.AddAuthWorkerBusy(d)
+	}
+}
+
+// addWorkers adds to the number of workers, removing them when n is negative
+func (l *Load) addWorkers(n int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.workers += n
+	httpmetrics.SetAuthWorkers(l.workers)
+}
+
+// SetLoad records the client's authorizations, and its callout service as a worker, in load.
+// It must be called before Start. A nil load disables it.
+func (c *Client) SetLoad(load *Load) {
+	c.load = load
+}
+
+// trackWorker adds or removes the client's callout service from the load's workers
+func (c *Client) trackWorker(running bool) {
+	if c.load == nil {
+		return
+	}
+	if running {
+		c.load.addWorkers(1)
+	} else {
+		c.load.addWorkers(-1)
+	}
+}
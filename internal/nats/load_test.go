@@ -0,0 +1,52 @@
+package nats
+
+import (
+	"testing"
+	"time"
+)
+
+// TestLoad tests the request rate and worker utilization sampled from requests
+func TestLoad(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	load := NewLoad()
+	load.now = func() time.Time { return now }
+	load.epoch, load.sampledAt = now, now
+	load.addWorkers(2)
+
+	// One worker busy for 4s of a 10s interval, with a second request still in flight for 2s
+	end := load.begin()
+	now = now.Add(4 * time.Second)
+	end()
+	now = now.Add(4 * time.Second)
+	load.begin()
+	now = now.Add(2 * time.Second)
+
+	stats := load.Sample()
+	if stats.Workers != 2 || stats.InFlight != 1 {
+		t.Errorf("Sample() workers = %d, in flight = %d, want 2 and 1", stats.Workers, stats.InFlight)
+	}
+	if stats.RequestsPerSecond != 0.1 {
+		t.Errorf("Sample() rate = %v, want 0.1", stats.RequestsPerSecond)
+	}
+	if stats.Utilization != 0.3 {
+		t.Errorf("Sample() utilization = %v, want 0.3 (6s busy of 20s)", stats.Utilization)
+	}
+
+	if got := load.Stats(); got != stats {
+		t.Errorf("Stats() = %+v, want the last sample %+v", got, stats)
+	}
+
+	// The request still in flight keeps its worker busy in the next interval
+	now = now.Add(5 * time.Second)
+	stats = load.Sample()
+	if stats.RequestsPerSecond != 0 || stats.Utilization != 0.5 {
+		t.Errorf("Sample() = %+v, want no requests and 0.5 utilization", stats)
+	}
+
+	// Utilization is only meaningful with workers
+	load.addWorkers(-2)
+	now = now.Add(5 * time.Second)
+	if stats = load.Sample(); stats.Utilization != 0 {
+		t.Errorf("Sample() utilization without workers = %v, want 0", stats.Utilization)
+	}
+}
@@ -0,0 +1,63 @@
+package nats
+
+import (
+	"encoding/base64"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nkeys"
+)
+
+// Outcomes of verifying that a client holds its user nkey, recorded in the audit log
+const (
+	// NkeyVerified means the client signed the server nonce with the nkey it presented
+	NkeyVerified = "verified"
+	// NkeyAbsent means the client presented no nkey and signature, or the server sent no nonce
+	NkeyAbsent = "absent"
+	// NkeyInvalid means the client presented a signature that does not verify against its nkey
+	NkeyInvalid = "invalid"
+)
+
+// verifyNkey checks the client's signature of the server nonce against the nkey it presented
+// in CONNECT. The user JWT is issued for the server's own UserNkey, so the signature is what
+// shows the client holds the presented key: recorded with the decision, the same token used
+// from several nkeys stands out in the audit log.
+func verifyNkey(req *jwt.AuthorizationRequest) string {
+	opts := req.ConnectOptions
+	if opts.Nkey == "" && opts.SignedNonce == "" {
+		return NkeyAbsent
+	}
+	if req.ClientInformation.Nonce == "" {
+		return NkeyAbsent
+	}
+	if opts.Nkey == "" || opts.SignedNonce == "" {
+		return NkeyInvalid
+	}
+
+	// Clients encode the signature as the NATS server expects: raw URL-safe base64, or
+	// standard base64 from older clients
+	signature, err := base64.RawURLEncoding.DecodeString(opts.SignedNonce)
+	if err != nil {
+		if signature, err = base64.StdEncoding.DecodeString(opts.SignedNonce); err != nil {
+			return NkeyInvalid
+		}
+	}
+	if !nkeys.IsValidPublicUserKey(opts.Nkey) {
+		return NkeyInvalid
+	}
+	key, err := nkeys.FromPublicKey(opts.Nkey)
+	if err != nil {
+		return NkeyInvalid
+	}
+	if err := key.Verify([]byte(req.ClientInformation.Nonce), signature); err != nil {
+		return NkeyInvalid
+	}
+	return NkeyVerified
+}
+
+// SetRequireNkey controls whether clients must prove possession of their user nkey by
+// signing the server nonce. A signature that does not verify is always denied; when required,
+// a missing one is too, except for bearer user JWTs, which are meant for clients that cannot
+// sign.
+func (c *Client) SetRequireNkey(require bool) {
+	c.requireNkey = require
+}
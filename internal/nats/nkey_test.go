@@ -0,0 +1,128 @@
+package nats
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nkeys"
+	"go.uber.org/zap"
+
+	internalAuth "github.com/portswigger-tim/nats-k8s-oidc-callout/internal/auth"
+)
+
+// signedRequest returns an authorization request from a client presenting key and signing
+// the nonce with signer
+func signedRequest(t *testing.T, key, signer nkeys.KeyPair, nonce string) *jwt.AuthorizationRequest {
+	t.Helper()
+	public, _ := key.PublicKey()
+	signature, err := signer.Sign([]byte(nonce))
+	if err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+	serverKey, _ := nkeys.CreateUser()
+	userNkey, _ := serverKey.PublicKey()
+	return &jwt.AuthorizationRequest{
+		UserNkey:          userNkey,
+		ClientInformation: jwt.ClientInformation{Nonce: nonce},
+		ConnectOptions: jwt.ConnectOptions{
+			JWT:         "valid.jwt.token",
+			Nkey:        public,
+			SignedNonce: base64.RawURLEncoding.EncodeToString(signature),
+		},
+	}
+}
+
+// TestVerifyNkey tests the outcome of verifying the nonce signature
+func TestVerifyNkey(t *testing.T) {
+	clientKey, _ := nkeys.CreateUser()
+	otherKey, _ := nkeys.CreateUser()
+	accountKey, _ := nkeys.CreateAccount()
+
+	stdEncoded := signedRequest(t, clientKey, clientKey, "nonce-1")
+	signature, _ := base64.RawURLEncoding.DecodeString(stdEncoded.ConnectOptions.SignedNonce)
+	stdEncoded.ConnectOptions.SignedNonce = base64.StdEncoding.EncodeToString(signature)
+
+	noNonce := signedRequest(t, clientKey, clientKey, "nonce-1")
+	noNonce.ClientInformation.Nonce = ""
+
+	noSignature := signedRequest(t, clientKey, clientKey, "nonce-1")
+	noSignature.ConnectOptions.SignedNonce = ""
+
+	tests := []struct {
+		name string
+		req  *jwt.AuthorizationRequest
+		want string
+	}{
+		{name: "verified", req: signedRequest(t, clientKey, clientKey, "nonce-1"), want: NkeyVerified},
+		{name: "standard base64", req: stdEncoded, want: NkeyVerified},
+		{name: "token only", req: &jwt.AuthorizationRequest{ConnectOptions: jwt.ConnectOptions{JWT: "valid.jwt.token"}}, want: NkeyAbsent},
+		{name: "no nonce", req: noNonce, want: NkeyAbsent},
+		{name: "signed by another key", req: signedRequest(t, clientKey, otherKey, "nonce-1"), want: NkeyInvalid},
+		{name: "nkey without signature", req: noSignature, want: NkeyInvalid},
+		{name: "not a user key", req: signedRequest(t, accountKey, accountKey, "nonce-1"), want: NkeyInvalid},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := verifyNkey(tt.req); got != tt.want {
+				t.Errorf("verifyNkey() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestClient_RequireNkey tests that invalid signatures are always denied, and missing ones
+// only when required and the user JWT is not a bearer one
+func TestClient_RequireNkey(t *testing.T) {
+	clientKey, _ := nkeys.CreateUser()
+	otherKey, _ := nkeys.CreateUser()
+	tokenOnly := func() *jwt.AuthorizationRequest {
+		userKey, _ := nkeys.CreateUser()
+		userPubKey, _ := userKey.PublicKey()
+		return &jwt.AuthorizationRequest{UserNkey: userPubKey, ConnectOptions: jwt.ConnectOptions{JWT: "valid.jwt.token"}}
+	}
+
+	tests := []struct {
+		name    string
+		require bool
+		bearer  bool
+		req     *jwt.AuthorizationRequest
+		wantErr bool
+	}{
+		{name: "verified", require: true, req: signedRequest(t, clientKey, clientKey, "nonce")},
+		{name: "absent when not required", req: tokenOnly()},
+		{name: "absent when required", require: true, req: tokenOnly(), wantErr: true},
+		{name: "absent for bearer users", require: true, bearer: true, req: tokenOnly()},
+		{name: "invalid when not required", req: signedRequest(t, clientKey, otherKey, "nonce"), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authHandler := &mockAuthHandler{
+				authorizeFunc: func(req *internalAuth.AuthRequest) *internalAuth.AuthResponse {
+					return &internalAuth.AuthResponse{Allowed: true, Bearer: tt.bearer, Reason: internalAuth.ReasonAllowed}
+				},
+			}
+			client, err := NewClient("nats://localhost:4222", "", "", "$G", authHandler, zap.NewNop())
+			if err != nil {
+				t.Fatalf("Failed to create client: %v", err)
+			}
+			signingKey, _ := nkeys.CreateAccount()
+			client.SetSigningKey(signingKey)
+			client.SetRequireNkey(tt.require)
+
+			_, err = client.safeAuthorize(tt.req)
+			if tt.wantErr {
+				if err == nil || !strings.HasPrefix(err.Error(), internalAuth.ReasonNkeyUnverified.Message()) {
+					t.Errorf("Got error %v, want %q", err, internalAuth.ReasonNkeyUnverified.Message())
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("Expected authorization to succeed, got %v", err)
+			}
+		})
+	}
+}
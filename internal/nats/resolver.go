@@ -0,0 +1,262 @@
+package nats
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nkeys"
+	"go.uber.org/zap"
+
+	httpmetrics "github.com/portswigger-tim/nats-k8s-oidc-callout/internal/httpserver"
+)
+
+// maxAccountJWTSize bounds the account JWTs read from the resolver
+const maxAccountJWTSize = 1 << 20
+
+// AccountResolver fetches account JWTs from a NATS account resolver over HTTP, such as the
+// nats-account-server: the JWT of an account is served at the resolver URL followed by the
+// account's public key, as for the server's resolver: URL(...) setting. Fetched JWTs must be
+// valid and signed by an operator key, but the resolver is trusted to serve the operator's
+// accounts: the operator itself is not checked.
+type AccountResolver struct {
+	url    string
+	client *http.Client
+}
+
+// NewAccountResolver creates a resolver for a URL ending in the path account public keys are
+// appended to, e.g. http://nats-account-server:9090/jwt/v1/accounts/
+func NewAccountResolver(url string) *AccountResolver {
+	return &AccountResolver{url: url, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// Fetch returns the claims of an account's current JWT
+func (r *AccountResolver) Fetch(ctx context.Context, account string) (*jwt.AccountClaims, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.url+account, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid account resolver request: %w", err)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch account JWT: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("account resolver returned %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxAccountJWTSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read account JWT: %w", err)
+	}
+
+	claims, err := jwt.DecodeAccountClaims(strings.TrimSpace(string(body)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid account JWT: %w", err)
+	}
+	if claims.Subject != account {
+		return nil, fmt.Errorf("account resolver returned the JWT of account %s", claims.Subject)
+	}
+	if !nkeys.IsValidPublicOperatorKey(claims.Issuer) {
+		return nil, fmt.Errorf("account JWT is not issued by an operator")
+	}
+	vr := jwt.CreateValidationResults()
+	claims.Validate(vr)
+	for _, issue := range vr.Issues {
+		if issue.Blocking || issue.TimeCheck {
+			return nil, fmt.Errorf("invalid account JWT: %s", issue.Description)
+		}
+	}
+	return claims, nil
+}
+
+// accountState is what the account resolver last returned for the issuer account
+type accountState struct {
+	claims          *jwt.AccountClaims
+	fetched         time.Time
+	signingKeyOK    bool            // the signing key is the account's identity key or an unscoped signing key
+	newSigningKeyOK bool            // the same for the new signing key of a rotation
+	roles           map[string]bool // roles whose scoped signing key the JWT lists as scoped
+	limits          jwt.NatsLimits  // the account's limits, capping the default user limits
+	unknownScoped   []string        // roles whose scoped signing key the JWT does not list
+}
+
+// AccountStats describe the issuer account as last fetched from the account resolver
+type AccountStats struct {
+	Account      string         `json:"account"` // public key of the issuer account
+	Name         string         `json:"name,omitempty"`
+	Fetched      time.Time      `json:"fetched"`
+	SigningKeyOK bool           `json:"signingKeyOK"`
+	Roles        []string       `json:"roles"` // roles whose scoped signing key the JWT lists
+	Limits       jwt.NatsLimits `json:"limits"`
+}
+
+// issuer returns the public key of the issuer account: the one set with the scoped signing
+// keys or, by default, the signing key's
+func (c *Client) issuer() (string, error) {
+	if c.issuerAccount != "" {
+		return c.issuerAccount, nil
+	}
+	if c.signingKey == nil {
+		return "", fmt.Errorf("signing key not set")
+	}
+	issuer, err := c.signingKey.PublicKey()
+	if err != nil {
+		return "", fmt.Errorf("failed to get signing key public key: %w", err)
+	}
+	return issuer, nil
+}
+
+// SyncAccount fetches the issuer account's JWT from the resolver and applies it, keeping the
+// client consistent with the account as managed with nsc: scoped signing keys the JWT does
+// not list stop being used, so their roles are denied with unknown_role rather than issuing
+// user JWTs the server would reject, and the account's limits cap the default user limits.
+// A failed fetch keeps what was last applied. It must be called after SetSigningKey.
+func (c *Client) SyncAccount(ctx context.Context, resolver *AccountResolver) error {
+	account, err := c.issuer()
+	if err != nil {
+		return err
+	}
+	claims, err := resolver.Fetch(ctx, account)
+	if err != nil {
+		return fmt.Errorf("account %s: %w", account, err)
+	}
+	c.applyAccount(claims, time.Now())
+	return nil
+}
+
+// WatchAccount syncs the issuer account every interval until the context is cancelled,
+// logging failed fetches
+func (c *Client) WatchAccount(ctx context.Context, resolver *AccountResolver, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.SyncAccount(ctx, resolver); err != nil {
+				c.logger.Warn("failed to refresh account JWT from the resolver", zap.Error(err))
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// applyAccount checks the signing keys against an account JWT and publishes the result
+func (c *Client) applyAccount(claims *jwt.AccountClaims, fetched time.Time) {
+	state := &accountState{
+		claims:  claims,
+		fetched: fetched,
+		roles:   make(map[string]bool, len(c.scopedKeys)),
+		limits:  claims.Limits.NatsLimits,
+	}
+	state.signingKeyOK = trustsSigningKey(claims, c.signingKey)
+	if c.newSigningKey != nil {
+		state.newSigningKeyOK = trustsSigningKey(claims, c.newSigningKey)
+		httpmetrics.SetSigningKeyTrusted(c.account, rotationOldKey, state.signingKeyOK)
+		httpmetrics.SetSigningKeyTrusted(c.account, rotationNewKey, state.newSigningKeyOK)
+	}
+	for role, key := range c.scopedKeys {
+		public, err := key.PublicKey()
+		if err != nil {
+			continue
+		}
+		if scope, listed := claims.SigningKeys.GetScope(public); listed && scope != nil {
+			state.roles[role] = true
+		} else {
+			state.unknownScoped = append(state.unknownScoped, role)
+		}
+	}
+	slices.Sort(state.unknownScoped)
+
+	previous := c.accountJWT.Swap(state)
+	httpmetrics.SetAccountJWTLastRefresh(c.account, fetched)
+
+	// Log when the JWT changes rather than on every refresh
+	if previous != nil && previous.claims.ID == claims.ID {
+		return
+	}
+	c.logger.Info("applied account JWT from the resolver",
+		zap.String("issuer_account", claims.Subject),
+		zap.String("name", claims.Name),
+		zap.Int("roles", len(state.roles)),
+		zap.Int64("max_subscriptions", state.limits.Subs),
+		zap.Int64("max_payload", state.limits.Payload),
+		zap.Int64("max_data", state.limits.Data))
+	switch {
+	case c.newSigningKey != nil && state.newSigningKeyOK:
+		newKey, _ := c.newSigningKey.PublicKey()
+		if previous == nil || !previous.newSigningKeyOK {
+			c.logger.Info("the issuer account trusts the new signing key; signing with it",
+				zap.String("issuer_account", claims.Subject),
+				zap.String("new_signing_key", newKey))
+		}
+		if !state.signingKeyOK {
+			c.logger.Info("the issuer account no longer lists the old signing key; complete the rotation by making the new key the signing key",
+				zap.String("issuer_account", claims.Subject))
+		}
+	case c.newSigningKey != nil && state.signingKeyOK:
+		newKey, _ := c.newSigningKey.PublicKey()
+		c.logger.Warn("the issuer account does not list the new signing key yet; signing with the old key",
+			zap.String("issuer_account", claims.Subject),
+			zap.String("new_signing_key", newKey))
+	case !state.signingKeyOK:
+		c.logger.Error("signing key is not a signing key of the issuer account; the NATS server will reject issued user JWTs",
+			zap.String("issuer_account", claims.Subject))
+	}
+	if len(state.unknownScoped) > 0 {
+		c.logger.Warn("scoped signing keys not listed in the account JWT; their roles are denied",
+			zap.String("issuer_account", claims.Subject),
+			zap.Strings("roles", state.unknownScoped))
+	}
+}
+
+// scopedKey returns the signing key of a role, or nil when there is none or the account JWT
+// last fetched from the resolver does not list it
+func (c *Client) scopedKey(role string) nkeys.KeyPair {
+	if state := c.accountJWT.Load(); state != nil && !state.roles[role] {
+		return nil
+	}
+	return c.scopedKeys[role]
+}
+
+// defaultLimits returns the default user limits, capped by the account's limits once its JWT
+// has been fetched from the resolver
+func (c *Client) defaultLimits() jwt.NatsLimits {
+	state := c.accountJWT.Load()
+	if state == nil {
+		return c.limits
+	}
+	return jwt.NatsLimits{
+		Subs:    lowerLimit(c.limits.Subs, state.limits.Subs),
+		Payload: lowerLimit(c.limits.Payload, state.limits.Payload),
+		Data:    lowerLimit(c.limits.Data, state.limits.Data),
+	}
+}
+
+// AccountStats returns the issuer account as last fetched from the account resolver, and
+// false before it has been fetched
+func (c *Client) AccountStats() (AccountStats, bool) {
+	state := c.accountJWT.Load()
+	if state == nil {
+		return AccountStats{}, false
+	}
+	roles := make([]string, 0, len(state.roles))
+	for role := range state.roles {
+		roles = append(roles, role)
+	}
+	slices.Sort(roles)
+	return AccountStats{
+		Account:      state.claims.Subject,
+		Name:         state.claims.Name,
+		Fetched:      state.fetched,
+		SigningKeyOK: state.signingKeyOK,
+		Roles:        roles,
+		Limits:       state.limits,
+	}, true
+}
@@ -0,0 +1,175 @@
+package nats
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nkeys"
+	"go.uber.org/zap"
+
+	internalAuth "github.com/portswigger-tim/nats-k8s-oidc-callout/internal/auth"
+)
+
+// TestAccountResolver_Fetch tests that account JWTs are fetched by public key and rejected
+// when invalid, expired or for another account
+func TestAccountResolver_Fetch(t *testing.T) {
+	operatorKey, _ := nkeys.CreateOperator()
+	accountKey, _ := nkeys.CreateAccount()
+	accountPubKey, _ := accountKey.PublicKey()
+	otherKey, _ := nkeys.CreateAccount()
+	otherPubKey, _ := otherKey.PublicKey()
+
+	encode := func(subject string, signer nkeys.KeyPair, expires int64) string {
+		claims := jwt.NewAccountClaims(subject)
+		claims.Name = "APP"
+		claims.Expires = expires
+		token, err := claims.Encode(signer)
+		if err != nil {
+			t.Fatalf("Encode() error = %v", err)
+		}
+		return token
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		status  int
+		wantErr string
+	}{
+		{name: "valid", token: encode(accountPubKey, operatorKey, 0)},
+		{name: "not found", status: http.StatusNotFound, wantErr: "404"},
+		{name: "garbage", token: "not-a-jwt", wantErr: "invalid account JWT"},
+		{name: "other account", token: encode(otherPubKey, operatorKey, 0), wantErr: "JWT of account " + otherPubKey},
+		{name: "self-signed", token: encode(accountPubKey, accountKey, 0), wantErr: "not issued by an operator"},
+		{name: "expired", token: encode(accountPubKey, operatorKey, 1), wantErr: "expired"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/jwt/v1/accounts/"+accountPubKey {
+					http.NotFound(w, r)
+					return
+				}
+				if tt.status != 0 {
+					w.WriteHeader(tt.status)
+					return
+				}
+				_, _ = w.Write([]byte(tt.token))
+			}))
+			defer srv.Close()
+
+			claims, err := NewAccountResolver(srv.URL+"/jwt/v1/accounts/").Fetch(context.Background(), accountPubKey)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Fetch() error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Fetch() error = %v", err)
+			}
+			if claims.Subject != accountPubKey || claims.Name != "APP" {
+				t.Errorf("Fetch() = %s (%s), want %s (APP)", claims.Subject, claims.Name, accountPubKey)
+			}
+		})
+	}
+}
+
+// TestClient_SyncAccount tests that the account JWT from the resolver disables scoped signing
+// keys it does not list and caps the default user limits with the account's
+func TestClient_SyncAccount(t *testing.T) {
+	operatorKey, _ := nkeys.CreateOperator()
+	accountKey, _ := nkeys.CreateAccount()
+	accountPubKey, _ := accountKey.PublicKey()
+	readerKey, _ := nkeys.CreateAccount()
+	readerPubKey, _ := readerKey.PublicKey()
+	writerKey, _ := nkeys.CreateAccount()
+
+	account := jwt.NewAccountClaims(accountPubKey)
+	account.SigningKeys.AddScopedSigner(&jwt.UserScope{Kind: jwt.UserScopeType, Key: readerPubKey, Role: "orders-reader"})
+	account.Limits.Subs = 50
+	account.Limits.Payload = 4096
+	token, err := account.Encode(operatorKey)
+	if err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(token))
+	}))
+	defer srv.Close()
+
+	var role string
+	authHandler := &mockAuthHandler{
+		authorizeFunc: func(req *internalAuth.AuthRequest) *internalAuth.AuthResponse {
+			return &internalAuth.AuthResponse{Allowed: true, Role: role, Reason: internalAuth.ReasonAllowed}
+		},
+	}
+	client, err := NewClient("nats://localhost:4222", "", "", "$G", authHandler, zap.NewNop())
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	client.SetSigningKey(accountKey)
+	client.SetDefaultLimits(100, jwt.NoLimit, 1<<20)
+	keys := map[string]nkeys.KeyPair{"orders-reader": readerKey, "orders-writer": writerKey}
+	if err := client.SetScopedSigningKeys(keys, ""); err != nil {
+		t.Fatalf("SetScopedSigningKeys() error = %v", err)
+	}
+
+	if err := client.SyncAccount(context.Background(), NewAccountResolver(srv.URL+"/")); err != nil {
+		t.Fatalf("SyncAccount() error = %v", err)
+	}
+
+	stats, ok := client.AccountStats()
+	if !ok {
+		t.Fatal("AccountStats() not available after SyncAccount()")
+	}
+	if stats.Account != accountPubKey || !stats.SigningKeyOK {
+		t.Errorf("AccountStats() = %+v, want account %s with the signing key accepted", stats, accountPubKey)
+	}
+	if len(stats.Roles) != 1 || stats.Roles[0] != "orders-reader" {
+		t.Errorf("AccountStats() roles = %v, want [orders-reader]", stats.Roles)
+	}
+
+	authorize := func() (string, error) {
+		userKey, _ := nkeys.CreateUser()
+		userPubKey, _ := userKey.PublicKey()
+		return client.safeAuthorize(&jwt.AuthorizationRequest{
+			UserNkey:       userPubKey,
+			ConnectOptions: jwt.ConnectOptions{JWT: "valid.jwt.token"},
+		})
+	}
+
+	// The account's limits cap the defaults where they are lower
+	encoded, err := authorize()
+	if err != nil {
+		t.Fatalf("Expected authorization to succeed, got %v", err)
+	}
+	uc, err := jwt.DecodeUserClaims(encoded)
+	if err != nil {
+		t.Fatalf("Failed to decode user claims: %v", err)
+	}
+	if want := (jwt.NatsLimits{Subs: 50, Payload: 4096, Data: 1 << 20}); uc.NatsLimits != want {
+		t.Errorf("limits = %+v, want %+v", uc.NatsLimits, want)
+	}
+
+	// A scoped key listed in the account JWT signs its role's users
+	role = "orders-reader"
+	encoded, err = authorize()
+	if err != nil {
+		t.Fatalf("Expected authorization to succeed, got %v", err)
+	}
+	if uc, err = jwt.DecodeUserClaims(encoded); err != nil || uc.Issuer != readerPubKey {
+		t.Errorf("Issuer = %q (error %v), want scoped key %q", uc.Issuer, err, readerPubKey)
+	}
+
+	// A scoped key missing from the account JWT is no longer used
+	role = "orders-writer"
+	if _, err := authorize(); err == nil || !strings.HasPrefix(err.Error(), internalAuth.ReasonUnknownRole.Message()) {
+		t.Errorf("Got error %v, want %q", err, internalAuth.ReasonUnknownRole.Message())
+	}
+}
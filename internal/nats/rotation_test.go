@@ -0,0 +1,139 @@
+package nats
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nkeys"
+	"go.uber.org/zap"
+
+	internalAuth "github.com/portswigger-tim/nats-k8s-oidc-callout/internal/auth"
+)
+
+// TestClient_SigningKeyRotation tests that the new signing key is only used once the account
+// JWT lists it, naming the account as the issuer of the user JWTs and responses it signs
+func TestClient_SigningKeyRotation(t *testing.T) {
+	operatorKey, _ := nkeys.CreateOperator()
+	accountKey, _ := nkeys.CreateAccount()
+	accountPubKey, _ := accountKey.PublicKey()
+	newKey, _ := nkeys.CreateAccount()
+	newPubKey, _ := newKey.PublicKey()
+
+	var (
+		mu    sync.Mutex
+		token string
+	)
+	publish := func(signingKeys ...string) {
+		account := jwt.NewAccountClaims(accountPubKey)
+		account.SigningKeys.Add(signingKeys...)
+		encoded, err := account.Encode(operatorKey)
+		if err != nil {
+			t.Fatalf("Encode() error = %v", err)
+		}
+		mu.Lock()
+		token = encoded
+		mu.Unlock()
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		_, _ = w.Write([]byte(token))
+	}))
+	defer srv.Close()
+	resolver := NewAccountResolver(srv.URL + "/")
+
+	authHandler := &mockAuthHandler{
+		authorizeFunc: func(req *internalAuth.AuthRequest) *internalAuth.AuthResponse {
+			return &internalAuth.AuthResponse{Allowed: true, Reason: internalAuth.ReasonAllowed}
+		},
+	}
+	client, err := NewClient("nats://localhost:4222", "", "", "$G", authHandler, zap.NewNop())
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	client.SetSigningKey(accountKey)
+	client.SetNewSigningKey(newKey)
+	if err := client.CheckSigningKeys(); err != nil {
+		t.Fatalf("CheckSigningKeys() error = %v", err)
+	}
+
+	issue := func() *jwt.UserClaims {
+		t.Helper()
+		userKey, _ := nkeys.CreateUser()
+		userPubKey, _ := userKey.PublicKey()
+		encoded, err := client.safeAuthorize(&jwt.AuthorizationRequest{
+			UserNkey:       userPubKey,
+			ConnectOptions: jwt.ConnectOptions{JWT: "valid.jwt.token"},
+		})
+		if err != nil {
+			t.Fatalf("Expected authorization to succeed, got %v", err)
+		}
+		uc, err := jwt.DecodeUserClaims(encoded)
+		if err != nil {
+			t.Fatalf("Failed to decode user claims: %v", err)
+		}
+		return uc
+	}
+
+	// Until the account JWT has been checked, the old key signs
+	if uc := issue(); uc.Issuer != accountPubKey {
+		t.Errorf("Issuer = %s before the account JWT is fetched, want the old key %s", uc.Issuer, accountPubKey)
+	}
+	if rotation, ok := client.SigningKeyRotation(); !ok || rotation.Checked || rotation.SigningWith != rotationOldKey {
+		t.Errorf("SigningKeyRotation() = %+v, %v; want unchecked and signing with the old key", rotation, ok)
+	}
+
+	// The account does not list the new key yet
+	publish()
+	if err := client.SyncAccount(context.Background(), resolver); err != nil {
+		t.Fatalf("SyncAccount() error = %v", err)
+	}
+	if uc := issue(); uc.Issuer != accountPubKey {
+		t.Errorf("Issuer = %s while the new key is not trusted, want the old key %s", uc.Issuer, accountPubKey)
+	}
+	rotation, _ := client.SigningKeyRotation()
+	if !rotation.Checked || !rotation.OldKeyTrusted || rotation.NewKeyTrusted || rotation.SigningWith != rotationOldKey {
+		t.Errorf("SigningKeyRotation() = %+v, want the new key untrusted", rotation)
+	}
+
+	// Once it does, the new key signs on behalf of the account
+	publish(newPubKey)
+	if err := client.SyncAccount(context.Background(), resolver); err != nil {
+		t.Fatalf("SyncAccount() error = %v", err)
+	}
+	uc := issue()
+	if uc.Issuer != newPubKey || uc.IssuerAccount != accountPubKey {
+		t.Errorf("Issuer, IssuerAccount = %s, %s; want %s, %s", uc.Issuer, uc.IssuerAccount, newPubKey, accountPubKey)
+	}
+	rotation, _ = client.SigningKeyRotation()
+	if !rotation.NewKeyTrusted || rotation.SigningWith != rotationNewKey || rotation.NewKey != newPubKey {
+		t.Errorf("SigningKeyRotation() = %+v, want signing with the new key", rotation)
+	}
+
+	encoded, err := client.signResponse(jwt.NewAuthorizationResponseClaims("UDXU4RCSJNZOIQHZNWXHXORDPRTGNJAHAHFRGZNEEJCPQTT2M7NLCNF4"))
+	if err != nil {
+		t.Fatalf("signResponse() error = %v", err)
+	}
+	resp, err := jwt.DecodeAuthorizationResponseClaims(encoded)
+	if err != nil || resp.Issuer != newPubKey || resp.IssuerAccount != accountPubKey {
+		t.Errorf("response issued by %s for %s (error %v), want %s for %s", resp.Issuer, resp.IssuerAccount, err, newPubKey, accountPubKey)
+	}
+}
+
+// TestClient_SigningKeyRotationUnset tests that clients without a new signing key report no
+// rotation
+func TestClient_SigningKeyRotationUnset(t *testing.T) {
+	client, err := NewClient("nats://localhost:4222", "", "", "$G", &mockAuthHandler{}, zap.NewNop())
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	signingKey, _ := nkeys.CreateAccount()
+	client.SetSigningKey(signingKey)
+	if _, ok := client.SigningKeyRotation(); ok {
+		t.Error("SigningKeyRotation() reported a rotation without a new signing key")
+	}
+}
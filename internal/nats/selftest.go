@@ -0,0 +1,104 @@
+package nats
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/nats-io/jwt/v2"
+	natsclient "github.com/nats-io/nats.go"
+	"go.uber.org/zap"
+)
+
+// selfTestProbe is the one-time token of a running self-test, and whether the server has
+// sent an authorization request carrying it
+type selfTestProbe struct {
+	token string
+	seen  chan struct{}
+}
+
+// SelfTest verifies the auth callout end to end after Start: it connects to the server with a
+// one-time token, which the service answers with a user JWT that may neither publish nor
+// subscribe. The connection only succeeds if the server routes authorization requests to this
+// service and accepts responses signed with its signing key. The failure distinguishes the two
+// cases, which otherwise both surface as client timeouts. In operator mode, sentinelCredsFile
+// is the credentials of the user clients connect with to reach the callout.
+func (c *Client) SelfTest(ctx context.Context, sentinelCredsFile string) error {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return fmt.Errorf("failed to generate self-test token: %w", err)
+	}
+	probe := &selfTestProbe{token: hex.EncodeToString(buf), seen: make(chan struct{}, 1)}
+	c.probe.Store(probe)
+	defer c.probe.Store(nil)
+
+	// Without the service's own credentials the server sends the connection through the callout
+	probeURL, err := url.Parse(c.url)
+	if err != nil {
+		return fmt.Errorf("failed to parse NATS URL: %w", err)
+	}
+	probeURL.User = nil
+
+	timeout := 5 * time.Second
+	if deadline, ok := ctx.Deadline(); ok {
+		if timeout = time.Until(deadline); timeout <= 0 {
+			return ctx.Err()
+		}
+	}
+	opts := []natsclient.Option{
+		natsclient.Name("nats-k8s-oidc-callout-self-test"),
+		natsclient.Token(probe.token),
+		natsclient.Timeout(timeout),
+		natsclient.NoReconnect(),
+	}
+	if sentinelCredsFile != "" {
+		opts = append(opts, natsclient.UserCredentials(sentinelCredsFile))
+	}
+
+	conn, err := natsclient.Connect(probeURL.String(), opts...)
+	if err == nil {
+		conn.Close()
+		c.logger.Info("auth callout self-test passed")
+		return nil
+	}
+
+	select {
+	case <-probe.seen:
+		key, _ := c.activeSigningKey()
+		issuer, _ := key.PublicKey()
+		return fmt.Errorf("the NATS server rejected the signed authorization response; check that "+
+			"the auth_callout issuer is %s, the public key of the signing key, and that account %s is allowed: %w",
+			issuer, c.account, err)
+	default:
+		return fmt.Errorf("the NATS server sent no authorization request to this service; check the "+
+			"auth_callout auth_users and account, and the service's NATS credentials: %w", err)
+	}
+}
+
+// selfTestResponse answers the self-test's authorization request, if req carries its token,
+// with a user JWT that may neither publish nor subscribe
+func (c *Client) selfTestResponse(req *jwt.AuthorizationRequest, logger *zap.Logger) (string, bool, error) {
+	probe := c.probe.Load()
+	if probe == nil || subtle.ConstantTimeCompare([]byte(req.ConnectOptions.Token), []byte(probe.token)) != 1 {
+		return "", false, nil
+	}
+	select {
+	case probe.seen <- struct{}{}:
+	default:
+	}
+
+	logger.Debug("answering auth callout self-test request")
+	uc := jwt.NewUserClaims(req.UserNkey)
+	uc.Audience = c.account
+	uc.Pub.Deny.Add(">")
+	uc.Sub.Deny.Add(">")
+	uc.Expires = time.Now().Add(time.Minute).Unix()
+	key, issuerAccount := c.activeSigningKey()
+	uc.IssuerAccount = issuerAccount
+	encoded, err := uc.Encode(key)
+	return encoded, true, err
+}
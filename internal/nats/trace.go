@@ -0,0 +1,81 @@
+package nats
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/portswigger-tim/nats-k8s-oidc-callout/internal/auth"
+	"github.com/portswigger-tim/nats-k8s-oidc-callout/internal/logging"
+	"go.uber.org/zap"
+)
+
+// SetTrace enables tracing of the authorizations matching one of patterns: the full
+// authorization request, the claims of the presented token, the handler's decision and the
+// issued user claims are written to logger regardless of the log level, with credentials
+// redacted. A pattern is "*" for every authorization, an identity ("namespace/serviceaccount"
+// or the subject of a non-Kubernetes token), "namespace/*", or "name=<connection name>" to
+// catch clients whose token is rejected before an identity is known. A nil logger disables it.
+func (c *Client) SetTrace(logger *zap.Logger, patterns []string) {
+	c.traceLog, c.tracePatterns = logger, patterns
+}
+
+// traced reports whether an authorization matches a trace pattern
+func (c *Client) traced(req *jwt.AuthorizationRequest, identity string) bool {
+	if c.traceLog == nil {
+		return false
+	}
+	for _, pattern := range c.tracePatterns {
+		switch {
+		case pattern == "*":
+			return true
+		case strings.HasPrefix(pattern, "name="):
+			if req.ConnectOptions.Name != "" && req.ConnectOptions.Name == strings.TrimPrefix(pattern, "name=") {
+				return true
+			}
+		case strings.HasSuffix(pattern, "/*"):
+			if identity != "" && strings.HasPrefix(identity, strings.TrimSuffix(pattern, "*")) {
+				return true
+			}
+		case identity != "" && pattern == identity:
+			return true
+		}
+	}
+	return false
+}
+
+// logTrace writes the trace of a matching authorization; claims are nil for denials
+func (c *Client) logTrace(req *jwt.AuthorizationRequest, token, requestID string, authResp *auth.AuthResponse, claims *jwt.UserClaims) {
+	if !c.traced(req, authResp.Identity) {
+		return
+	}
+	fields := []zap.Field{
+		zap.String("request_id", requestID),
+		zap.Any("request", logging.RedactJSON(req)),
+		zap.Any("token_claims", tokenClaims(token)),
+		zap.Any("decision", logging.RedactJSON(authResp)),
+	}
+	if claims != nil {
+		fields = append(fields, zap.Any("user_claims", logging.RedactJSON(claims)))
+	}
+	c.traceLog.Info("authorization trace", fields...)
+}
+
+// tokenClaims decodes the payload of a JWT without verifying it, with credentials redacted,
+// or returns nil if it is not a JWT. The signature is never logged.
+func tokenClaims(token string) any {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil
+	}
+	var claims map[string]any
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil
+	}
+	return logging.RedactJSON(claims)
+}
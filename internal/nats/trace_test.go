@@ -0,0 +1,90 @@
+package nats
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nkeys"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	internalAuth "github.com/portswigger-tim/nats-k8s-oidc-callout/internal/auth"
+)
+
+// TestClient_Trace tests that matching authorizations are traced with credentials redacted
+func TestClient_Trace(t *testing.T) {
+	allowed := true
+	authHandler := &mockAuthHandler{
+		authorizeFunc: func(req *internalAuth.AuthRequest) *internalAuth.AuthResponse {
+			if !allowed {
+				return &internalAuth.AuthResponse{Reason: internalAuth.ReasonInvalidToken}
+			}
+			return &internalAuth.AuthResponse{
+				Allowed:              true,
+				PublishPermissions:   []string{"production.>"},
+				SubscribePermissions: []string{"_INBOX.>"},
+				Identity:             "production/app",
+				Reason:               internalAuth.ReasonAllowed,
+			}
+		},
+	}
+
+	core, logs := observer.New(zapcore.InfoLevel)
+	client, err := NewClient("nats://localhost:4222", "", "", "$G", authHandler, zap.NewNop())
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	signingKey, _ := nkeys.CreateAccount()
+	client.SetSigningKey(signingKey)
+	client.SetTrace(zap.New(core), []string{"production/*", "name=legacy-worker"})
+
+	payload := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"system:serviceaccount:production:app","aud":["nats"]}`))
+	token := "eyJhbGciOiJSUzI1NiJ9." + payload + ".c2lnbmF0dXJlLXNpZ25hdHVyZQ"
+	userKey, _ := nkeys.CreateUser()
+	userPubKey, _ := userKey.PublicKey()
+	req := &jwt.AuthorizationRequest{
+		UserNkey:       userPubKey,
+		ConnectOptions: jwt.ConnectOptions{JWT: token, Password: "hunter2"},
+	}
+	if _, err := client.safeAuthorize(req); err != nil {
+		t.Fatalf("safeAuthorize() error = %v", err)
+	}
+
+	entries := logs.TakeAll()
+	if len(entries) != 1 {
+		t.Fatalf("expected one trace of the matching identity, got %d", len(entries))
+	}
+	fields := entries[0].ContextMap()
+	connect := fields["request"].(map[string]any)["connect_opts"].(map[string]any)
+	if connect["pass"] != "***" || connect["jwt"] != "eyJhbGci...5hdHVyZQ (redacted)" {
+		t.Errorf("connect_opts = %v, want the password masked and the token shortened", connect)
+	}
+	if claims := fields["token_claims"].(map[string]any); claims["sub"] != "system:serviceaccount:production:app" {
+		t.Errorf("token_claims = %v, want the token's payload", claims)
+	}
+	if decision := fields["decision"].(map[string]any); decision["Identity"] != "production/app" {
+		t.Errorf("decision = %v, want the handler's response", decision)
+	}
+	if claims, ok := fields["user_claims"].(map[string]any); !ok || claims["sub"] != userPubKey {
+		t.Errorf("user_claims = %v, want the issued claims", fields["user_claims"])
+	}
+
+	// Rejected tokens have no identity, but can be traced by connection name
+	allowed = false
+	if _, err := client.safeAuthorize(req); err == nil {
+		t.Fatal("expected the invalid token to be denied")
+	}
+	req.ConnectOptions.Name = "legacy-worker"
+	if _, err := client.safeAuthorize(req); err == nil {
+		t.Fatal("expected the invalid token to be denied")
+	}
+	entries = logs.TakeAll()
+	if len(entries) != 1 {
+		t.Fatalf("expected one trace of the named connection, got %d", len(entries))
+	}
+	if _, found := entries[0].ContextMap()["user_claims"]; found {
+		t.Error("expected no user claims for a denial")
+	}
+}
@@ -0,0 +1,20 @@
+// Package natssubject validates NATS subjects, shared by the configuration and the
+// permission sources so that both accept the same subjects.
+package natssubject
+
+import "strings"
+
+// Valid reports whether a subject, possibly with wildcards, is well-formed: no empty tokens
+// or whitespace, and ">" only as the last token
+func Valid(subject string) bool {
+	if strings.ContainsAny(subject, " \t\r\n") {
+		return false
+	}
+	tokens := strings.Split(subject, ".")
+	for i, token := range tokens {
+		if token == "" || (token == ">" && i != len(tokens)-1) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,30 @@
+package natssubject
+
+import "testing"
+
+func TestValid(t *testing.T) {
+	tests := []struct {
+		subject string
+		want    bool
+	}{
+		{"orders.created", true},
+		{"orders.*", true},
+		{"orders.>", true},
+		{">", true},
+		{"$JS.API.INFO", true},
+		{"", false},
+		{"orders..created", false},
+		{".orders", false},
+		{"orders.", false},
+		{"orders.>.created", false},
+		{"orders created", false},
+		{"orders\tcreated", false},
+		{"orders.created\n", false},
+		{"orders.created\r", false},
+	}
+	for _, tt := range tests {
+		if got := Valid(tt.subject); got != tt.want {
+			t.Errorf("Valid(%q) = %v, want %v", tt.subject, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,257 @@
+// Package notify posts authorization denials to a webhook, such as a Slack incoming webhook,
+// so platform teams hear about misconfigured workloads before their developers do.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"text/template"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/portswigger-tim/nats-k8s-oidc-callout/internal/auth"
+	httpmetrics "github.com/portswigger-tim/nats-k8s-oidc-callout/internal/httpserver"
+	"github.com/portswigger-tim/nats-k8s-oidc-callout/internal/nats"
+)
+
+// Notifier defaults
+const (
+	queueSize   = 256
+	sendTimeout = 5 * time.Second
+	maxKeys     = 10000 // rate limit entries kept before expired ones are pruned
+)
+
+// DefaultTemplate renders a Slack-compatible message. Templates are rendered with a Denial and
+// may use the json function to quote strings.
+const DefaultTemplate = `{"text": {{ json (printf "NATS authorization denied for %s: %s (%d since the last notification)" (or .Identity "an unidentified client") .Reason .Count) }}}`
+
+// Notification results, the result label of nats_auth_denial_notifications_total
+const (
+	ResultSent       = "sent"
+	ResultFailed     = "failed"
+	ResultDropped    = "dropped"
+	ResultSuppressed = "suppressed"
+)
+
+// Denial is an authorization denial, as rendered by the template
+type Denial struct {
+	Identity       string // namespace/serviceaccount or token subject; empty when the token was not validated
+	Namespace      string
+	ServiceAccount string
+	Reason         auth.ReasonCode
+	Message        string // client-facing description of the reason
+	ConnectionName string
+	Count          int // denials of the identity for the reason since the last notification, including this one
+	Time           time.Time
+}
+
+// Notifier posts denials to a webhook from a background goroutine. Each identity is notified
+// at most once per interval for each reason; further denials are counted and reported with the
+// next notification. When the webhook falls behind, denials beyond the queue are dropped rather
+// than delaying authorizations.
+type Notifier struct {
+	url      string
+	template *template.Template
+	interval time.Duration
+	reasons  map[auth.ReasonCode]bool // when set, only denials for these reasons are notified
+	client   *http.Client
+	logger   *zap.Logger
+	now      func() time.Time
+
+	mu      sync.Mutex
+	last    map[string]time.Time // identity and reason -> last notification
+	pending map[string]int       // identity and reason -> denials since the last notification
+
+	queue     chan Denial
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// New starts a notifier posting to url, rendering each denial with tmpl (DefaultTemplate when
+// empty). Only denials for the given reasons are notified, or all of them when reasons is empty.
+func New(webhookURL, tmpl string, interval time.Duration, reasons []string, logger *zap.Logger) (*Notifier, error) {
+	if tmpl == "" {
+		tmpl = DefaultTemplate
+	}
+	parsed, err := template.New("denial").Funcs(template.FuncMap{"json": jsonString}).Parse(tmpl)
+	if err != nil {
+		return nil, fmt.Errorf("invalid denial notification template: %w", err)
+	}
+
+	n := &Notifier{
+		url:      webhookURL,
+		template: parsed,
+		interval: interval,
+		client:   &http.Client{Timeout: sendTimeout},
+		logger:   logger,
+		now:      time.Now,
+		last:     make(map[string]time.Time),
+		pending:  make(map[string]int),
+		queue:    make(chan Denial, queueSize),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if len(reasons) > 0 {
+		n.reasons = make(map[auth.ReasonCode]bool, len(reasons))
+		for _, reason := range reasons {
+			if !auth.ReasonCode(reason).Known() {
+				return nil, fmt.Errorf("unknown denial reason %q", reason)
+			}
+			n.reasons[auth.ReasonCode(reason)] = true
+		}
+	}
+	go n.run()
+	return n, nil
+}
+
+// WrapHandler notifies the denials made by h.
+func (n *Notifier) WrapHandler(h nats.AuthHandler) nats.AuthHandler {
+	return &notifyingHandler{next: h, notifier: n}
+}
+
+// Notify queues a denial, unless its identity was notified for the same reason within the
+// interval, in which case it is counted towards the next notification.
+func (n *Notifier) Notify(d Denial) {
+	if n.reasons != nil && !n.reasons[d.Reason] {
+		return
+	}
+	key := d.Identity + "\x00" + string(d.Reason)
+	now := n.now()
+
+	n.mu.Lock()
+	n.pending[key]++
+	if last, found := n.last[key]; found && now.Sub(last) < n.interval {
+		n.mu.Unlock()
+		httpmetrics.RecordDenialNotification(ResultSuppressed)
+		return
+	}
+	if len(n.last) >= maxKeys {
+		n.prune(now)
+	}
+	d.Count = n.pending[key]
+	n.last[key] = now
+	delete(n.pending, key)
+	n.mu.Unlock()
+
+	d.Time = now
+	if d.Message == "" {
+		d.Message = d.Reason.Message()
+	}
+	select {
+	case n.queue <- d:
+	default:
+		httpmetrics.RecordDenialNotification(ResultDropped)
+	}
+}
+
+// prune forgets the identities not notified within the interval. The caller holds the lock.
+func (n *Notifier) prune(now time.Time) {
+	for key, last := range n.last {
+		if now.Sub(last) >= n.interval {
+			delete(n.last, key)
+			delete(n.pending, key)
+		}
+	}
+}
+
+// Close sends the queued notifications and stops the notifier, giving up when the context ends
+func (n *Notifier) Close(ctx context.Context) error {
+	n.closeOnce.Do(func() { close(n.stop) })
+	select {
+	case <-n.done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("denial notifications did not finish: %w", ctx.Err())
+	}
+}
+
+// run posts queued denials until the notifier is closed
+func (n *Notifier) run() {
+	defer close(n.done)
+
+	for {
+		select {
+		case d := <-n.queue:
+			n.send(d)
+		case <-n.stop:
+			for {
+				select {
+				case d := <-n.queue:
+					n.send(d)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// send renders and posts a denial, recording the outcome
+func (n *Notifier) send(d Denial) {
+	if err := n.post(d); err != nil {
+		httpmetrics.RecordDenialNotification(ResultFailed)
+		n.logger.Warn("failed to send denial notification",
+			zap.String("identity", d.Identity),
+			zap.String("reason", string(d.Reason)),
+			zap.Error(err))
+		return
+	}
+	httpmetrics.RecordDenialNotification(ResultSent)
+}
+
+// post renders a denial with the template and posts it to the webhook
+func (n *Notifier) post(d Denial) error {
+	var body bytes.Buffer
+	if err := n.template.Execute(&body, d); err != nil {
+		return fmt.Errorf("failed to render template: %w", err)
+	}
+
+	resp, err := n.client.Post(n.url, "application/json", &body)
+	if err != nil {
+		// Drop the URL from the error, since for Slack it is a secret
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return urlErr.Err
+		}
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// jsonString quotes a value as JSON, for templates building JSON payloads
+func jsonString(v any) (string, error) {
+	encoded, err := json.Marshal(v)
+	return string(encoded), err
+}
+
+// notifyingHandler notifies the notifier of each denial
+type notifyingHandler struct {
+	next     nats.AuthHandler
+	notifier *Notifier
+}
+
+func (h *notifyingHandler) Authorize(req *auth.AuthRequest) *auth.AuthResponse {
+	resp := h.next.Authorize(req)
+	if !resp.Allowed {
+		h.notifier.Notify(Denial{
+			Identity:       resp.Identity,
+			Namespace:      resp.Namespace,
+			ServiceAccount: resp.ServiceAccount,
+			Reason:         resp.Reason,
+			ConnectionName: req.ConnectionName,
+		})
+	}
+	return resp
+}
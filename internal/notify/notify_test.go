@@ -0,0 +1,84 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/portswigger-tim/nats-k8s-oidc-callout/internal/auth"
+)
+
+// denyingHandler denies every request for the reason
+type denyingHandler struct {
+	reason auth.ReasonCode
+}
+
+func (h *denyingHandler) Authorize(req *auth.AuthRequest) *auth.AuthResponse {
+	return &auth.AuthResponse{Reason: h.reason, Identity: "orders/api", Namespace: "orders", ServiceAccount: "api"}
+}
+
+func TestNotifier_RateLimited(t *testing.T) {
+	var mu sync.Mutex
+	var messages []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var payload struct{ Text string }
+		if err := json.Unmarshal(body, &payload); err != nil {
+			t.Errorf("payload %s is not JSON: %v", body, err)
+		}
+		mu.Lock()
+		messages = append(messages, payload.Text)
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	notifier, err := New(server.URL, "", time.Minute, []string{string(auth.ReasonUnknownServiceAccount)}, zap.NewNop())
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	now := time.Unix(1700000000, 0)
+	notifier.now = func() time.Time { return now }
+
+	handler := notifier.WrapHandler(&denyingHandler{reason: auth.ReasonUnknownServiceAccount})
+	handler.Authorize(&auth.AuthRequest{})
+	handler.Authorize(&auth.AuthRequest{}) // suppressed within the interval
+	now = now.Add(time.Minute)
+	handler.Authorize(&auth.AuthRequest{})
+
+	// Reasons not listed are not notified
+	notifier.WrapHandler(&denyingHandler{reason: auth.ReasonTokenExpired}).Authorize(&auth.AuthRequest{})
+
+	if err := notifier.Close(context.Background()); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	want := []string{
+		"NATS authorization denied for orders/api: unknown_serviceaccount (1 since the last notification)",
+		"NATS authorization denied for orders/api: unknown_serviceaccount (2 since the last notification)",
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(messages) != len(want) {
+		t.Fatalf("messages = %q, want %q", messages, want)
+	}
+	for i := range want {
+		if messages[i] != want[i] {
+			t.Errorf("message %d = %q, want %q", i, messages[i], want[i])
+		}
+	}
+}
+
+func TestNew_Invalid(t *testing.T) {
+	if _, err := New("http://localhost", "{{ .Missing", time.Minute, nil, zap.NewNop()); err == nil {
+		t.Error("expected an invalid template to fail")
+	}
+	if _, err := New("http://localhost", "", time.Minute, []string{"token_expired", "typo"}, zap.NewNop()); err == nil {
+		t.Error("expected an unknown reason to fail")
+	}
+}
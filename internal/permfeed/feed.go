@@ -0,0 +1,163 @@
+// Package permfeed publishes an event on NATS whenever the permissions computed for a
+// ServiceAccount are added, changed or deleted, so external systems such as gateways and
+// documentation generators can follow the policy as it changes.
+package permfeed
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	natsclient "github.com/nats-io/nats.go"
+	"go.uber.org/zap"
+)
+
+// Event types
+const (
+	EventAdded   = "added"
+	EventChanged = "changed"
+	EventDeleted = "deleted"
+)
+
+// Source returns the current permissions keyed by "namespace/name", and whether they are
+// complete. Values are published as JSON.
+type Source func() (map[string]any, bool)
+
+// Event is published on <prefix>.<type>.<namespace>.<name> for each change
+type Event struct {
+	Type        string          `json:"type"`
+	Namespace   string          `json:"namespace"`
+	Name        string          `json:"name"`
+	Permissions json.RawMessage `json:"permissions,omitempty"`         // absent when deleted
+	Previous    json.RawMessage `json:"previousPermissions,omitempty"` // absent when added
+	Time        time.Time       `json:"time"`
+}
+
+// Feed compares the permissions of the ServiceAccounts with those it last saw and publishes
+// the differences. The first complete set is the baseline and publishes nothing, so restarts
+// do not replay every ServiceAccount.
+type Feed struct {
+	conn   *natsclient.Conn
+	prefix string
+	source Source
+	logger *zap.Logger
+	now    func() time.Time
+
+	known   map[string][]byte // permissions by "namespace/name" as last published; nil until the baseline
+	failing bool              // whether the last synchronization failed, so failures are logged once
+}
+
+// New creates a feed publishing the changes of source under the subject prefix on conn
+func New(conn *natsclient.Conn, prefix string, source Source, logger *zap.Logger) *Feed {
+	return &Feed{conn: conn, prefix: prefix, source: source, logger: logger, now: time.Now}
+}
+
+// Run compares the permissions every interval until the context is cancelled
+func (f *Feed) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		err := f.Sync()
+		switch {
+		case err != nil && !f.failing:
+			f.logger.Warn("failed to publish permission change events; retrying every interval", zap.Error(err))
+		case err == nil && f.failing:
+			f.logger.Info("publishing permission change events again")
+		}
+		f.failing = err != nil
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Sync publishes an event for each ServiceAccount whose permissions were added, changed or
+// deleted since the last call. Nothing happens until the source is complete. Changes whose
+// event could not be published are retried on the next call. It must not be called
+// concurrently.
+func (f *Feed) Sync() error {
+	entries, complete := f.source()
+	if !complete {
+		return nil
+	}
+
+	current := make(map[string][]byte, len(entries))
+	for identity, perms := range entries {
+		value, err := encode(perms)
+		if err != nil {
+			return fmt.Errorf("failed to encode permissions of %s: %w", identity, err)
+		}
+		current[identity] = value
+	}
+	if f.known == nil {
+		f.known = current
+		return nil
+	}
+
+	now := f.now()
+	var published int
+	for identity, value := range current {
+		old, found := f.known[identity]
+		if found && bytes.Equal(old, value) {
+			continue
+		}
+		event := Event{Type: EventAdded, Permissions: value, Time: now}
+		if found {
+			event.Type = EventChanged
+			event.Previous = old
+		}
+		if err := f.publish(identity, event); err != nil {
+			return err
+		}
+		f.known[identity] = value
+		published++
+	}
+	for identity, old := range f.known {
+		if _, found := current[identity]; found {
+			continue
+		}
+		if err := f.publish(identity, Event{Type: EventDeleted, Previous: old, Time: now}); err != nil {
+			return err
+		}
+		delete(f.known, identity)
+		published++
+	}
+
+	if published > 0 {
+		f.logger.Debug("published permission change events", zap.Int("events", published))
+	}
+	return nil
+}
+
+// publish sends the event of a ServiceAccount identified by "namespace/name"
+func (f *Feed) publish(identity string, event Event) error {
+	namespace, name, _ := strings.Cut(identity, "/")
+	event.Namespace, event.Name = namespace, name
+	data, err := encode(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s event of %s: %w", event.Type, identity, err)
+	}
+	subject := f.prefix + "." + event.Type + "." + namespace + "." + name
+	if err := f.conn.Publish(subject, data); err != nil {
+		return fmt.Errorf("failed to publish %s event of %s: %w", event.Type, identity, err)
+	}
+	return nil
+}
+
+// encode marshals a value as JSON without escaping the > wildcard, so events stay readable
+func encode(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
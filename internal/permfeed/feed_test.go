@@ -0,0 +1,99 @@
+package permfeed
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	natsclient "github.com/nats-io/nats.go"
+	"go.uber.org/zap"
+)
+
+func TestFeed(t *testing.T) {
+	srv, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Start()
+	if !srv.ReadyForConnections(5 * time.Second) {
+		t.Fatal("NATS server not ready")
+	}
+	defer srv.Shutdown()
+	conn, err := natsclient.Connect(srv.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	events := make(chan *natsclient.Msg, 10)
+	sub, err := conn.ChanSubscribe("permissions.>", events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = sub.Unsubscribe() }()
+
+	entries := map[string]any{
+		"orders/api":     map[string]any{"publish": []string{"orders.>"}},
+		"billing/worker": map[string]any{"publish": []string{"billing.>"}},
+	}
+	complete := false
+	feed := New(conn, "permissions", func() (map[string]any, bool) { return entries, complete }, zap.NewNop())
+
+	// Nothing is published until the source is complete, nor for the baseline
+	for _, ready := range []bool{false, true} {
+		complete = ready
+		if err := feed.Sync(); err != nil {
+			t.Fatalf("Sync() error = %v", err)
+		}
+	}
+
+	entries["orders/api"] = map[string]any{"publish": []string{"orders.>", "shared.>"}}
+	entries["shipping/api"] = map[string]any{"publish": []string{"shipping.>"}}
+	delete(entries, "billing/worker")
+	if err := feed.Sync(); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if err := conn.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(map[string]Event)
+	for range 3 {
+		select {
+		case msg := <-events:
+			var event Event
+			if err := json.Unmarshal(msg.Data, &event); err != nil {
+				t.Fatalf("invalid event %s: %v", msg.Data, err)
+			}
+			got[msg.Subject] = event
+		case <-time.After(5 * time.Second):
+			t.Fatalf("got %d events, want 3", len(got))
+		}
+	}
+
+	changed := got["permissions.changed.orders.api"]
+	if string(changed.Permissions) != `{"publish":["orders.>","shared.>"]}` ||
+		string(changed.Previous) != `{"publish":["orders.>"]}` {
+		t.Errorf("changed event = %+v, want the new and previous permissions", changed)
+	}
+	if added := got["permissions.added.shipping.api"]; added.Namespace != "shipping" || added.Name != "api" || added.Previous != nil {
+		t.Errorf("added event = %+v, want shipping/api without previous permissions", added)
+	}
+	if deleted := got["permissions.deleted.billing.worker"]; deleted.Type != EventDeleted || deleted.Permissions != nil {
+		t.Errorf("deleted event = %+v, want no permissions", deleted)
+	}
+
+	// Unchanged permissions publish nothing
+	if err := feed.Sync(); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if err := conn.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-events:
+		t.Errorf("unexpected event on %s: %s", msg.Subject, msg.Data)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
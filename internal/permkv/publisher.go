@@ -0,0 +1,184 @@
+// Package permkv publishes the permissions computed for each ServiceAccount to a NATS KV
+// bucket, giving NATS-side tooling and dashboards a live, queryable view of the policy
+// without access to the Kubernetes API.
+package permkv
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	natsclient "github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"go.uber.org/zap"
+)
+
+// requestTimeout bounds each synchronization with the bucket
+const requestTimeout = 30 * time.Second
+
+// Source returns the current permissions keyed by "namespace/name", and whether they are
+// complete. Values are published as JSON.
+type Source func() (map[string]any, bool)
+
+// Key returns the bucket key of a ServiceAccount. Namespaces cannot contain dots, so the
+// first token is always the namespace.
+func Key(namespace, name string) string {
+	return namespace + "." + name
+}
+
+// Publisher keeps a KV bucket in step with the permissions of the ServiceAccounts: one key
+// per ServiceAccount, written when its permissions change and deleted with it.
+type Publisher struct {
+	kv     jetstream.KeyValue
+	source Source
+	logger *zap.Logger
+
+	published map[string][]byte // values in the bucket by key; nil until loaded
+	failing   bool              // whether the last synchronization failed, so failures are logged once
+}
+
+// New opens the bucket, creating it with a single revision per key when it does not exist
+func New(ctx context.Context, conn *natsclient.Conn, bucket string, source Source, logger *zap.Logger) (*Publisher, error) {
+	js, err := jetstream.New(conn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create JetStream context: %w", err)
+	}
+
+	kv, err := js.KeyValue(ctx, bucket)
+	if errors.Is(err, jetstream.ErrBucketNotFound) {
+		kv, err = js.CreateKeyValue(ctx, jetstream.KeyValueConfig{
+			Bucket:      bucket,
+			Description: "ServiceAccount NATS permissions, published by nats-k8s-oidc-callout",
+			History:     1,
+		})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open permissions KV bucket %s: %w", bucket, err)
+	}
+	return &Publisher{kv: kv, source: source, logger: logger}, nil
+}
+
+// Run synchronizes the bucket every interval until the context is cancelled
+func (p *Publisher) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		p.syncLogged(ctx)
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// syncLogged synchronizes the bucket, logging the first of consecutive failures and the recovery
+func (p *Publisher) syncLogged(ctx context.Context) {
+	syncCtx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+	err := p.Sync(syncCtx)
+	switch {
+	case err != nil && !p.failing && ctx.Err() == nil:
+		p.logger.Warn("failed to publish permissions to the KV bucket; retrying every interval", zap.Error(err))
+	case err == nil && p.failing:
+		p.logger.Info("publishing permissions to the KV bucket again")
+	}
+	p.failing = err != nil
+}
+
+// Sync writes the permissions that changed since the last call and deletes the keys of
+// ServiceAccounts that no longer exist. Nothing is written until the source is complete, so
+// a partially loaded cache never removes keys. It must not be called concurrently.
+func (p *Publisher) Sync(ctx context.Context) error {
+	entries, complete := p.source()
+	if !complete {
+		return nil
+	}
+	if p.published == nil {
+		if err := p.load(ctx); err != nil {
+			return err
+		}
+	}
+
+	current := make(map[string][]byte, len(entries))
+	var written, deleted int
+	for identity, perms := range entries {
+		namespace, name, ok := strings.Cut(identity, "/")
+		if !ok {
+			continue
+		}
+		key := Key(namespace, name)
+		value, err := encode(perms)
+		if err != nil {
+			return fmt.Errorf("failed to encode permissions of %s: %w", identity, err)
+		}
+		current[key] = value
+		if bytes.Equal(p.published[key], value) {
+			continue
+		}
+		if _, err := p.kv.Put(ctx, key, value); err != nil {
+			return fmt.Errorf("failed to publish permissions of %s: %w", identity, err)
+		}
+		p.published[key] = value
+		written++
+	}
+	for key := range p.published {
+		if _, found := current[key]; found {
+			continue
+		}
+		if err := p.kv.Delete(ctx, key); err != nil {
+			return fmt.Errorf("failed to delete permissions key %s: %w", key, err)
+		}
+		delete(p.published, key)
+		deleted++
+	}
+
+	if written > 0 || deleted > 0 {
+		p.logger.Debug("published permissions to the KV bucket",
+			zap.Int("written", written),
+			zap.Int("deleted", deleted))
+	}
+	return nil
+}
+
+// load reads the values already in the bucket, so a restart only rewrites what changed
+func (p *Publisher) load(ctx context.Context) error {
+	watcher, err := p.kv.WatchAll(ctx, jetstream.IgnoreDeletes())
+	if err != nil {
+		return fmt.Errorf("failed to read the permissions KV bucket: %w", err)
+	}
+	defer func() {
+		_ = watcher.Stop()
+	}()
+
+	published := make(map[string][]byte)
+	for {
+		select {
+		case entry := <-watcher.Updates():
+			// A nil entry marks the end of the current values
+			if entry == nil {
+				p.published = published
+				return nil
+			}
+			published[entry.Key()] = entry.Value()
+		case <-ctx.Done():
+			return fmt.Errorf("failed to read the permissions KV bucket: %w", ctx.Err())
+		}
+	}
+}
+
+// encode marshals permissions as JSON without escaping the > wildcard, so values stay readable
+func encode(perms any) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(perms); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
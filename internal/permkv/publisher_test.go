@@ -0,0 +1,105 @@
+package permkv
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	natsclient "github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"go.uber.org/zap"
+)
+
+// startJetStream starts an in-process NATS server with JetStream and connects to it
+func startJetStream(t *testing.T) *natsclient.Conn {
+	t.Helper()
+	srv, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: -1, JetStream: true, StoreDir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Start()
+	if !srv.ReadyForConnections(5 * time.Second) {
+		t.Fatal("NATS server not ready")
+	}
+	t.Cleanup(srv.Shutdown)
+
+	conn, err := natsclient.Connect(srv.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(conn.Close)
+	return conn
+}
+
+func TestPublisher(t *testing.T) {
+	conn := startJetStream(t)
+	ctx := context.Background()
+
+	entries := map[string]any{
+		"orders/api":     map[string]any{"publish": []string{"orders.>"}},
+		"billing/worker": map[string]any{"publish": []string{"billing.>"}},
+	}
+	complete := false
+	source := func() (map[string]any, bool) { return entries, complete }
+
+	publisher, err := New(ctx, conn, "permissions", source, zap.NewNop())
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	js, _ := jetstream.New(conn)
+	kv, err := js.KeyValue(ctx, "permissions")
+	if err != nil {
+		t.Fatalf("bucket not created: %v", err)
+	}
+
+	// Nothing is published until the source is complete
+	if err := publisher.Sync(ctx); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if _, err := kv.Get(ctx, "orders.api"); !errors.Is(err, jetstream.ErrKeyNotFound) {
+		t.Errorf("Get(orders.api) error = %v, want not found before the source is complete", err)
+	}
+
+	complete = true
+	if err := publisher.Sync(ctx); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	entry, err := kv.Get(ctx, "orders.api")
+	if err != nil {
+		t.Fatalf("Get(orders.api) error = %v", err)
+	}
+	if string(entry.Value()) != `{"publish":["orders.>"]}` {
+		t.Errorf("orders.api = %s, want the permissions as JSON", entry.Value())
+	}
+
+	// Unchanged permissions are not rewritten; deleted ServiceAccounts are removed
+	revision := entry.Revision()
+	delete(entries, "billing/worker")
+	if err := publisher.Sync(ctx); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if entry, err := kv.Get(ctx, "orders.api"); err != nil || entry.Revision() != revision {
+		t.Errorf("orders.api rewritten although unchanged (err = %v)", err)
+	}
+	if _, err := kv.Get(ctx, "billing.worker"); !errors.Is(err, jetstream.ErrKeyNotFound) {
+		t.Errorf("Get(billing.worker) error = %v, want deleted", err)
+	}
+
+	// A new publisher, as after a restart, only writes what changed while it was away
+	entries["billing/worker"] = map[string]any{"publish": []string{"billing.>"}}
+	restarted, err := New(ctx, conn, "permissions", source, zap.NewNop())
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if err := restarted.Sync(ctx); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if entry, err := kv.Get(ctx, "orders.api"); err != nil || entry.Revision() != revision {
+		t.Errorf("orders.api rewritten after a restart (err = %v)", err)
+	}
+	if _, err := kv.Get(ctx, "billing.worker"); err != nil {
+		t.Errorf("Get(billing.worker) error = %v, want published again", err)
+	}
+}
@@ -0,0 +1,60 @@
+package shadow
+
+import (
+	"hash/fnv"
+
+	"github.com/portswigger-tim/nats-k8s-oidc-callout/internal/auth"
+	httpmetrics "github.com/portswigger-tim/nats-k8s-oidc-callout/internal/httpserver"
+)
+
+// Pipelines a canary lookup is served by, as reported in nats_auth_canary_lookups_total
+const (
+	PipelineStable = "stable"
+	PipelineCanary = "canary"
+)
+
+// Canary serves the permissions of a percentage of the identities from the canary provider and
+// the rest from the stable one. Identities are assigned by a hash of their name, so each always
+// gets the same pipeline, and raising the percentage only moves more identities to the canary.
+// Denied subjects come from the identity's pipeline too, so they always belong to the same
+// policy as its permissions. The other policies of the stable provider apply to every identity.
+type Canary struct {
+	auth.Policies
+	stable  auth.PermissionsProvider
+	canary  auth.PermissionsProvider
+	percent int
+}
+
+// NewCanary returns a provider routing percent (0-100) of the identities to canary.
+func NewCanary(stable, canary auth.PermissionsProvider, percent int) *Canary {
+	return &Canary{Policies: auth.Policies{Provider: stable}, stable: stable, canary: canary, percent: percent}
+}
+
+// GetPermissions returns the permissions of the identity's pipeline.
+func (c *Canary) GetPermissions(namespace, name string) (pubPerms, subPerms []string, found bool) {
+	pipeline, provider := c.pipeline(namespace, name)
+	pubPerms, subPerms, found = provider.GetPermissions(namespace, name)
+	httpmetrics.RecordCanaryLookup(pipeline, found)
+	return pubPerms, subPerms, found
+}
+
+// DeniedSubjects returns the denied subjects of the identity's pipeline.
+func (c *Canary) DeniedSubjects(namespace, name string) (publish, subscribe []string) {
+	_, provider := c.pipeline(namespace, name)
+	return auth.Policies{Provider: provider}.DeniedSubjects(namespace, name)
+}
+
+// pipeline returns the pipeline serving an identity and its provider
+func (c *Canary) pipeline(namespace, name string) (string, auth.PermissionsProvider) {
+	if c.Selected(namespace, name) {
+		return PipelineCanary, c.canary
+	}
+	return PipelineStable, c.stable
+}
+
+// Selected reports whether an identity is served by the canary pipeline.
+func (c *Canary) Selected(namespace, name string) bool {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(namespace + "/" + name))
+	return int(h.Sum32()%100) < c.percent
+}
@@ -0,0 +1,59 @@
+package shadow
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/portswigger-tim/nats-k8s-oidc-callout/internal/standalone"
+)
+
+// TestCanary_Routing tests that the percentage of identities and their pipeline are stable
+func TestCanary_Routing(t *testing.T) {
+	var stableIDs, canaryIDs []standalone.Identity
+	for i := 0; i < 1000; i++ {
+		sa := fmt.Sprintf("app-%d", i)
+		stableIDs = append(stableIDs, standalone.Identity{Namespace: "orders", ServiceAccount: sa, Publish: []string{"stable.>"}})
+		canaryIDs = append(canaryIDs, standalone.Identity{Namespace: "orders", ServiceAccount: sa, Publish: []string{"canary.>"}})
+	}
+	stable, canary := newProvider(t, stableIDs), newProvider(t, canaryIDs)
+
+	for _, percent := range []int{0, 10, 100} {
+		provider := NewCanary(stable, canary, percent)
+		routed := 0
+		for i := 0; i < 1000; i++ {
+			sa := fmt.Sprintf("app-%d", i)
+			pub, _, found := provider.GetPermissions("orders", sa)
+			if !found {
+				t.Fatalf("GetPermissions(%s) not found", sa)
+			}
+			selected := provider.Selected("orders", sa)
+			if (pub[0] == "canary.>") != selected {
+				t.Errorf("%s served %v, Selected() = %v", sa, pub, selected)
+			}
+			if selected {
+				routed++
+				// Raising the percentage keeps identities on the canary
+				if !NewCanary(stable, canary, percent+10).Selected("orders", sa) {
+					t.Errorf("%s left the canary when the percentage was raised", sa)
+				}
+			}
+		}
+		if want := percent * 10; routed < want-50 || routed > want+50 {
+			t.Errorf("%d%% canary routed %d of 1000 identities", percent, routed)
+		}
+	}
+}
+
+// TestCanary_DeniedSubjects tests that denied subjects come from the identity's pipeline
+func TestCanary_DeniedSubjects(t *testing.T) {
+	identities := []standalone.Identity{{Namespace: "orders", ServiceAccount: "api", Publish: []string{"orders.>"}}}
+	stable := denyingProvider{Provider: newProvider(t, identities), denyPub: []string{"stable.>"}}
+	canary := denyingProvider{Provider: newProvider(t, identities), denyPub: []string{"canary.>"}}
+
+	for percent, want := range map[int]string{0: "stable.>", 100: "canary.>"} {
+		deny, _ := NewCanary(stable, canary, percent).DeniedSubjects("orders", "api")
+		if len(deny) != 1 || deny[0] != want {
+			t.Errorf("%d%% canary denied %v, want %s", percent, deny, want)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+// Package shadow evaluates a secondary permissions source alongside the primary one, so that a
+// migration between sources (for example from ServiceAccount annotations to a static file) can
+// be verified against live traffic: in shadow mode without affecting decisions, or as a canary
+// deciding for a share of the identities.
+package shadow
+
+import (
+	"slices"
+
+	"go.uber.org/zap"
+
+	"github.com/portswigger-tim/nats-k8s-oidc-callout/internal/auth"
+	httpmetrics "github.com/portswigger-tim/nats-k8s-oidc-callout/internal/httpserver"
+)
+
+// Comparison results, as reported in nats_auth_shadow_comparisons_total
+const (
+	ResultMatch            = "match"
+	ResultMismatch         = "mismatch"
+	ResultMissingInShadow  = "missing_in_shadow"
+	ResultMissingInPrimary = "missing_in_primary"
+)
+
+// Provider serves permissions from the primary provider and compares every lookup with the
+// shadow provider, logging and counting the differences. Denied subjects are compared along with
+// the permissions. The other policies of the primary provider (see auth.Policies) are forwarded
+// unchanged.
+type Provider struct {
+	auth.Policies
+	primary auth.PermissionsProvider
+	shadow  auth.PermissionsProvider
+	logger  *zap.Logger
+}
+
+// Wrap returns a provider deciding with primary and comparing with shadow.
+func Wrap(primary, shadow auth.PermissionsProvider, logger *zap.Logger) *Provider {
+	return &Provider{Policies: auth.Policies{Provider: primary}, primary: primary, shadow: shadow, logger: logger}
+}
+
+// subjects are the permissions and denied subjects of an identity in one provider
+type subjects struct {
+	pub, sub, denyPub, denySub []string
+}
+
+// lookup returns an identity's subjects in a provider
+func lookup(provider auth.PermissionsProvider, namespace, name string) (subjects, bool) {
+	var s subjects
+	var found bool
+	s.pub, s.sub, found = provider.GetPermissions(namespace, name)
+	if found {
+		s.denyPub, s.denySub = auth.Policies{Provider: provider}.DeniedSubjects(namespace, name)
+	}
+	return s, found
+}
+
+// GetPermissions returns the primary provider's permissions, after comparing them and the denied
+// subjects with the shadow provider's.
+func (p *Provider) GetPermissions(namespace, name string) (pubPerms, subPerms []string, found bool) {
+	primary, found := lookup(p.primary, namespace, name)
+	shadow, shadowFound := lookup(p.shadow, namespace, name)
+
+	result := compare(found, shadowFound, primary, shadow)
+	httpmetrics.RecordShadowComparison(result)
+	if result != ResultMatch {
+		identity := name
+		if namespace != "" {
+			identity = namespace + "/" + name
+		}
+		onlyPrimaryPub, onlyShadowPub := difference(primary.pub, shadow.pub)
+		onlyPrimarySub, onlyShadowSub := difference(primary.sub, shadow.sub)
+		onlyPrimaryDenyPub, onlyShadowDenyPub := difference(primary.denyPub, shadow.denyPub)
+		onlyPrimaryDenySub, onlyShadowDenySub := difference(primary.denySub, shadow.denySub)
+		p.logger.Info("shadow permissions differ",
+			zap.String("identity", identity),
+			zap.String("result", result),
+			zap.Strings("publish_only_primary", onlyPrimaryPub),
+			zap.Strings("publish_only_shadow", onlyShadowPub),
+			zap.Strings("subscribe_only_primary", onlyPrimarySub),
+			zap.Strings("subscribe_only_shadow", onlyShadowSub),
+			zap.Strings("deny_publish_only_primary", onlyPrimaryDenyPub),
+			zap.Strings("deny_publish_only_shadow", onlyShadowDenyPub),
+			zap.Strings("deny_subscribe_only_primary", onlyPrimaryDenySub),
+			zap.Strings("deny_subscribe_only_shadow", onlyShadowDenySub))
+	}
+
+	return primary.pub, primary.sub, found
+}
+
+// compare classifies a lookup in both providers. Subjects are compared as sets.
+func compare(found, shadowFound bool, primary, shadow subjects) string {
+	switch {
+	case !found && !shadowFound:
+		return ResultMatch
+	case !shadowFound:
+		return ResultMissingInShadow
+	case !found:
+		return ResultMissingInPrimary
+	}
+	for _, pair := range [][2][]string{
+		{primary.pub, shadow.pub},
+		{primary.sub, shadow.sub},
+		{primary.denyPub, shadow.denyPub},
+		{primary.denySub, shadow.denySub},
+	} {
+		if onlyPrimary, onlyShadow := difference(pair[0], pair[1]); len(onlyPrimary)+len(onlyShadow) > 0 {
+			return ResultMismatch
+		}
+	}
+	return ResultMatch
+}
+
+// difference returns the subjects only in a and those only in b
+func difference(a, b []string) (onlyA, onlyB []string) {
+	for _, subject := range a {
+		if !slices.Contains(b, subject) {
+			onlyA = append(onlyA, subject)
+		}
+	}
+	for _, subject := range b {
+		if !slices.Contains(a, subject) {
+			onlyB = append(onlyB, subject)
+		}
+	}
+	return onlyA, onlyB
+}
@@ -0,0 +1,119 @@
+package shadow
+
+import (
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"github.com/portswigger-tim/nats-k8s-oidc-callout/internal/standalone"
+)
+
+func newProvider(t *testing.T, identities []standalone.Identity) *standalone.Provider {
+	t.Helper()
+	p, err := standalone.NewProvider(identities, zap.NewNop())
+	if err != nil {
+		t.Fatalf("NewProvider() error = %v", err)
+	}
+	return p
+}
+
+// TestProvider_GetPermissions tests that the primary decides and differences are reported
+func TestProvider_GetPermissions(t *testing.T) {
+	primary := newProvider(t, []standalone.Identity{
+		{Namespace: "orders", ServiceAccount: "api", Publish: []string{"orders.>", "events.>"}, Subscribe: []string{"_INBOX.>"}},
+		{Namespace: "orders", ServiceAccount: "worker", Publish: []string{"orders.>"}},
+		{Namespace: "orders", ServiceAccount: "legacy", Publish: []string{"orders.>"}},
+	})
+	secondary := newProvider(t, []standalone.Identity{
+		{Namespace: "orders", ServiceAccount: "api", Publish: []string{"events.>", "orders.>"}, Subscribe: []string{"_INBOX.>"}},
+		{Namespace: "orders", ServiceAccount: "worker", Publish: []string{"orders.created"}},
+		{Namespace: "orders", ServiceAccount: "new", Publish: []string{"orders.>"}},
+	})
+
+	tests := []struct {
+		name       string
+		sa         string
+		wantFound  bool
+		wantResult string
+	}{
+		{name: "same subjects in another order", sa: "api", wantFound: true, wantResult: ResultMatch},
+		{name: "different subjects", sa: "worker", wantFound: true, wantResult: ResultMismatch},
+		{name: "only in primary", sa: "legacy", wantFound: true, wantResult: ResultMissingInShadow},
+		{name: "only in shadow", sa: "new", wantFound: false, wantResult: ResultMissingInPrimary},
+		{name: "in neither", sa: "unknown", wantFound: false, wantResult: ResultMatch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			core, logs := observer.New(zapcore.InfoLevel)
+			provider := Wrap(primary, secondary, zap.New(core))
+
+			pub, _, found := provider.GetPermissions("orders", tt.sa)
+			wantPub, _, _ := primary.GetPermissions("orders", tt.sa)
+			if found != tt.wantFound || len(pub) != len(wantPub) {
+				t.Errorf("GetPermissions() = %v, %v; want the primary's %v, %v", pub, found, wantPub, tt.wantFound)
+			}
+
+			differs := logs.FilterMessage("shadow permissions differ").All()
+			if tt.wantResult == ResultMatch {
+				if len(differs) != 0 {
+					t.Errorf("logged %d differences, want none", len(differs))
+				}
+				return
+			}
+			if len(differs) != 1 {
+				t.Fatalf("logged %d differences, want 1", len(differs))
+			}
+			if result := differs[0].ContextMap()["result"]; result != tt.wantResult {
+				t.Errorf("result = %v, want %v", result, tt.wantResult)
+			}
+		})
+	}
+}
+
+// TestProvider_ForwardsPolicies tests that policies come from the primary provider
+func TestProvider_ForwardsPolicies(t *testing.T) {
+	primary := newProvider(t, []standalone.Identity{{Subject: "ci", Publish: []string{"ci.>"}, JetStreamAdmin: true}})
+	secondary := newProvider(t, nil)
+	provider := Wrap(primary, secondary, zap.NewNop())
+
+	if !provider.JetStreamAdmin("", "ci") {
+		t.Error("JetStreamAdmin() = false, want the primary's policy")
+	}
+	if !provider.HasSynced() {
+		t.Error("HasSynced() = false for a primary without a sync status")
+	}
+}
+
+// denyingProvider adds denied subjects to a standalone provider
+type denyingProvider struct {
+	*standalone.Provider
+	denyPub []string
+}
+
+func (p denyingProvider) DeniedSubjects(namespace, name string) (publish, subscribe []string) {
+	return p.denyPub, nil
+}
+
+// TestProvider_ComparesDeniedSubjects tests that providers granting the same subjects but denying
+// different ones are reported as a mismatch
+func TestProvider_ComparesDeniedSubjects(t *testing.T) {
+	identities := []standalone.Identity{{Namespace: "orders", ServiceAccount: "api", Publish: []string{"orders.>"}}}
+	primary := denyingProvider{Provider: newProvider(t, identities), denyPub: []string{"orders.admin.>"}}
+	secondary := denyingProvider{Provider: newProvider(t, identities)}
+
+	core, logs := observer.New(zapcore.InfoLevel)
+	provider := Wrap(primary, secondary, zap.New(core))
+	if _, _, found := provider.GetPermissions("orders", "api"); !found {
+		t.Fatal("GetPermissions() not found")
+	}
+	differs := logs.FilterMessage("shadow permissions differ").All()
+	if len(differs) != 1 || differs[0].ContextMap()["result"] != ResultMismatch {
+		t.Fatalf("logged %v, want one mismatch", differs)
+	}
+	if deny, _ := provider.DeniedSubjects("orders", "api"); len(deny) != 1 || deny[0] != "orders.admin.>" {
+		t.Errorf("DeniedSubjects() = %v, want the primary's", deny)
+	}
+}
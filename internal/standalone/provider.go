@@ -0,0 +1,132 @@
+// Package standalone provides a static, file-based permissions provider for running
+// the auth callout without a Kubernetes API server.
+package standalone
+
+import (
+	"fmt"
+	"os"
+
+	"go.uber.org/zap"
+	"sigs.k8s.io/yaml"
+)
+
+// File is the on-disk format of a static permissions file.
+//
+// Example:
+//
+//	identities:
+//	  - namespace: payments
+//	    serviceAccount: api
+//	    publish: ["payments.>"]
+//	    subscribe: ["_INBOX.>", "payments.>"]
+//	  - subject: ci-runner@example.com
+//	    publish: ["ci.>"]
+//	    subscribe: ["_INBOX.>", "ci.>"]
+//	    jetStreamAdmin: true
+type File struct {
+	Identities []Identity `json:"identities"`
+}
+
+// Identity maps a token identity to NATS permissions.
+// An identity is either a Kubernetes ServiceAccount (namespace + serviceAccount)
+// or, for non-Kubernetes OIDC tokens, the token subject.
+type Identity struct {
+	Namespace      string   `json:"namespace,omitempty"`
+	ServiceAccount string   `json:"serviceAccount,omitempty"`
+	Subject        string   `json:"subject,omitempty"`
+	Publish        []string `json:"publish,omitempty"`
+	Subscribe      []string `json:"subscribe,omitempty"`
+	JetStreamAdmin bool     `json:"jetStreamAdmin,omitempty"` // exempt from the JetStream API protection
+}
+
+// permissions holds the publish and subscribe permissions of a single identity
+type permissions struct {
+	publish   []string
+	subscribe []string
+	jsAdmin   bool
+}
+
+// Provider serves permissions from a static file. Permissions are granted exactly
+// as listed in the file; no namespace defaults or inbox patterns are added.
+type Provider struct {
+	perms  map[string]*permissions // key: "namespace/name" ("/subject" for non-Kubernetes identities)
+	logger *zap.Logger
+}
+
+// LoadFile reads a static permissions file and returns a Provider serving it.
+func LoadFile(path string, logger *zap.Logger) (*Provider, error) {
+	data, err := os.ReadFile(path) //nolint:gosec // path comes from configuration
+	if err != nil {
+		return nil, fmt.Errorf("failed to read permissions file: %w", err)
+	}
+
+	var file File
+	if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse permissions file: %w", err)
+	}
+
+	return NewProvider(file.Identities, logger)
+}
+
+// NewProvider creates a Provider from a list of identities.
+func NewProvider(identities []Identity, logger *zap.Logger) (*Provider, error) {
+	p := &Provider{
+		perms:  make(map[string]*permissions, len(identities)),
+		logger: logger,
+	}
+
+	for i, id := range identities {
+		key, err := identityKey(id)
+		if err != nil {
+			return nil, fmt.Errorf("identity %d: %w", i, err)
+		}
+		if _, exists := p.perms[key]; exists {
+			return nil, fmt.Errorf("identity %d: duplicate identity %q", i, key)
+		}
+		p.perms[key] = &permissions{
+			publish:   id.Publish,
+			subscribe: id.Subscribe,
+			jsAdmin:   id.JetStreamAdmin,
+		}
+	}
+
+	return p, nil
+}
+
+// GetPermissions retrieves the NATS permissions for an identity.
+// For non-Kubernetes identities namespace is empty and name is the token subject.
+func (p *Provider) GetPermissions(namespace, name string) (pubPerms, subPerms []string, found bool) {
+	key := namespace + "/" + name
+	perms, found := p.perms[key]
+	if !found {
+		p.logger.Debug("identity NOT found in permissions file", zap.String("key", key))
+		return nil, nil, false
+	}
+
+	return perms.publish, perms.subscribe, true
+}
+
+// JetStreamAdmin reports whether an identity is exempt from the JetStream API protection.
+func (p *Provider) JetStreamAdmin(namespace, name string) bool {
+	perms, found := p.perms[namespace+"/"+name]
+	return found && perms.jsAdmin
+}
+
+// Len returns the number of identities in the provider.
+func (p *Provider) Len() int {
+	return len(p.perms)
+}
+
+// identityKey validates an identity and returns its lookup key.
+func identityKey(id Identity) (string, error) {
+	switch {
+	case id.Subject != "" && (id.Namespace != "" || id.ServiceAccount != ""):
+		return "", fmt.Errorf("subject and namespace/serviceAccount are mutually exclusive")
+	case id.Subject != "":
+		return "/" + id.Subject, nil
+	case id.Namespace == "" || id.ServiceAccount == "":
+		return "", fmt.Errorf("either subject or both namespace and serviceAccount are required")
+	default:
+		return id.Namespace + "/" + id.ServiceAccount, nil
+	}
+}
@@ -0,0 +1,129 @@
+package standalone
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestLoadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "permissions.yaml")
+	content := `
+identities:
+  - namespace: payments
+    serviceAccount: api
+    publish: ["payments.>", "events.>"]
+    subscribe: ["_INBOX.>", "payments.>"]
+  - subject: ci-runner@example.com
+    publish: ["ci.>"]
+    jetStreamAdmin: true
+`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("failed to write permissions file: %v", err)
+	}
+
+	provider, err := LoadFile(path, zap.NewNop())
+	if err != nil {
+		t.Fatalf("LoadFile() error = %v", err)
+	}
+
+	if provider.Len() != 2 {
+		t.Errorf("Len() = %d, want 2", provider.Len())
+	}
+
+	pub, sub, found := provider.GetPermissions("payments", "api")
+	if !found {
+		t.Fatal("expected payments/api to be found")
+	}
+	if !equalStringSlices(pub, []string{"payments.>", "events.>"}) {
+		t.Errorf("pub = %v", pub)
+	}
+	if !equalStringSlices(sub, []string{"_INBOX.>", "payments.>"}) {
+		t.Errorf("sub = %v", sub)
+	}
+
+	pub, sub, found = provider.GetPermissions("", "ci-runner@example.com")
+	if !found {
+		t.Fatal("expected subject identity to be found")
+	}
+	if !equalStringSlices(pub, []string{"ci.>"}) {
+		t.Errorf("pub = %v", pub)
+	}
+	if len(sub) != 0 {
+		t.Errorf("sub = %v, want empty", sub)
+	}
+
+	if _, _, found := provider.GetPermissions("payments", "unknown"); found {
+		t.Error("expected unknown identity not to be found")
+	}
+
+	if !provider.JetStreamAdmin("", "ci-runner@example.com") || provider.JetStreamAdmin("payments", "api") {
+		t.Error("expected only the subject identity to be a JetStream administrator")
+	}
+}
+
+func TestLoadFile_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{
+			name:    "invalid yaml",
+			content: "identities: [",
+		},
+		{
+			name:    "unknown field",
+			content: "identities:\n  - subject: a\n    pubish: [x]\n",
+		},
+		{
+			name:    "missing identity",
+			content: "identities:\n  - publish: [x]\n",
+		},
+		{
+			name:    "namespace without service account",
+			content: "identities:\n  - namespace: a\n",
+		},
+		{
+			name:    "subject and namespace",
+			content: "identities:\n  - subject: a\n    namespace: b\n    serviceAccount: c\n",
+		},
+		{
+			name:    "duplicate identity",
+			content: "identities:\n  - subject: a\n  - subject: a\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "permissions.yaml")
+			if err := os.WriteFile(path, []byte(tt.content), 0o600); err != nil {
+				t.Fatalf("failed to write permissions file: %v", err)
+			}
+
+			if _, err := LoadFile(path, zap.NewNop()); err == nil {
+				t.Error("expected error, got nil")
+			}
+		})
+	}
+}
+
+func TestLoadFile_MissingFile(t *testing.T) {
+	if _, err := LoadFile("/nonexistent/permissions.yaml", zap.NewNop()); err == nil {
+		t.Error("expected error for missing file, got nil")
+	}
+}
+
+// Helper function to compare string slices
+func equalStringSlices(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,103 @@
+// Package watchdog detects authorization requests that never complete, such as goroutines
+// stuck on a deadlocked cache, so the liveness probe can fail and Kubernetes restarts the pod.
+package watchdog
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/portswigger-tim/nats-k8s-oidc-callout/internal/auth"
+	httpmetrics "github.com/portswigger-tim/nats-k8s-oidc-callout/internal/httpserver"
+	"github.com/portswigger-tim/nats-k8s-oidc-callout/internal/nats"
+)
+
+// Watchdog tracks in-flight authorization requests and reports when any has been
+// pending for longer than the threshold.
+type Watchdog struct {
+	mu        sync.Mutex
+	nextID    uint64
+	pending   map[uint64]time.Time // request ID -> start time
+	threshold time.Duration
+	now       func() time.Time
+}
+
+// New creates a watchdog that reports requests pending for longer than threshold as stuck.
+func New(threshold time.Duration) *Watchdog {
+	return &Watchdog{
+		pending:   make(map[uint64]time.Time),
+		threshold: threshold,
+		now:       time.Now,
+	}
+}
+
+// WrapHandler tracks every authorization request made through h.
+func (w *Watchdog) WrapHandler(h nats.AuthHandler) nats.AuthHandler {
+	return &watchedHandler{next: h, watchdog: w}
+}
+
+// begin records the start of a request and returns a function that records its completion
+func (w *Watchdog) begin() func() {
+	w.mu.Lock()
+	id := w.nextID
+	w.nextID++
+	w.pending[id] = w.now()
+	w.mu.Unlock()
+
+	return func() {
+		w.mu.Lock()
+		delete(w.pending, id)
+		w.mu.Unlock()
+	}
+}
+
+// Check returns an error when any request has been pending for longer than the threshold,
+// and publishes the number of stuck requests.
+func (w *Watchdog) Check() error {
+	w.mu.Lock()
+	now := w.now()
+	stuck := 0
+	var oldest time.Duration
+	for _, started := range w.pending {
+		if age := now.Sub(started); age > w.threshold {
+			stuck++
+			oldest = max(oldest, age)
+		}
+	}
+	w.mu.Unlock()
+
+	httpmetrics.SetStuckAuthRequests(stuck)
+	if stuck > 0 {
+		return fmt.Errorf("%d authorization request(s) pending longer than %s (oldest %s)",
+			stuck, w.threshold, oldest.Truncate(time.Second))
+	}
+	return nil
+}
+
+// Run checks for stuck requests every interval until ctx is cancelled, keeping the
+// metric current between liveness probes.
+func (w *Watchdog) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_ = w.Check()
+		}
+	}
+}
+
+// watchedHandler records each authorization request with the watchdog
+type watchedHandler struct {
+	next     nats.AuthHandler
+	watchdog *Watchdog
+}
+
+func (h *watchedHandler) Authorize(req *auth.AuthRequest) *auth.AuthResponse {
+	defer h.watchdog.begin()()
+	return h.next.Authorize(req)
+}
@@ -0,0 +1,51 @@
+package watchdog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/portswigger-tim/nats-k8s-oidc-callout/internal/auth"
+)
+
+// blockingHandler blocks until release is closed
+type blockingHandler struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (h *blockingHandler) Authorize(req *auth.AuthRequest) *auth.AuthResponse {
+	close(h.started)
+	<-h.release
+	return &auth.AuthResponse{Allowed: true}
+}
+
+func TestWatchdog_Check(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	w := New(30 * time.Second)
+	w.now = func() time.Time { return now }
+
+	h := &blockingHandler{started: make(chan struct{}), release: make(chan struct{})}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		w.WrapHandler(h).Authorize(&auth.AuthRequest{Token: "token"})
+	}()
+	<-h.started
+
+	if err := w.Check(); err != nil {
+		t.Errorf("expected no error for a recent request, got %v", err)
+	}
+
+	// A request pending beyond the threshold is reported as stuck
+	now = now.Add(time.Minute)
+	if err := w.Check(); err == nil {
+		t.Error("expected error for a request pending beyond the threshold")
+	}
+
+	// Completing the request clears it
+	close(h.release)
+	<-done
+	if err := w.Check(); err != nil {
+		t.Errorf("expected no error after the request completed, got %v", err)
+	}
+}
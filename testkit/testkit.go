@@ -0,0 +1,206 @@
+// Package testkit lets teams building NATS clients test against realistic auth callout
+// behavior in their own CI, without Kubernetes or an external NATS server.
+//
+// A Harness runs an in-process NATS server with auth callout enabled and the real
+// authorization handler and NATS callout client behind it. Token validation is replaced
+// by FakeValidator, and ServiceAccounts live in an in-memory PermissionStore that derives
+// permissions from annotations exactly as the Kubernetes cache does:
+//
+//	h := testkit.Start(t)
+//	h.AddServiceAccount("orders", "api", map[string]string{
+//		"nats.io/allowed-pub-subjects": "events.orders.>",
+//	})
+//	conn := h.Connect(t, h.Token("orders", "api"))
+package testkit
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	natsclient "github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/portswigger-tim/nats-k8s-oidc-callout/internal/auth"
+	"github.com/portswigger-tim/nats-k8s-oidc-callout/internal/embeddednats"
+	"github.com/portswigger-tim/nats-k8s-oidc-callout/internal/jwt"
+	"github.com/portswigger-tim/nats-k8s-oidc-callout/internal/k8s"
+	"github.com/portswigger-tim/nats-k8s-oidc-callout/internal/nats"
+)
+
+// ErrUnknownToken is returned by FakeValidator for tokens it did not issue.
+var ErrUnknownToken = errors.New("unknown token")
+
+// FakeValidator accepts opaque tokens it has issued in place of signed ServiceAccount JWTs.
+type FakeValidator struct {
+	mu     sync.RWMutex
+	tokens map[string]*jwt.Claims
+}
+
+// NewFakeValidator creates a validator with no issued tokens.
+func NewFakeValidator() *FakeValidator {
+	return &FakeValidator{tokens: make(map[string]*jwt.Claims)}
+}
+
+// Issue returns a new token identifying the given ServiceAccount.
+func (v *FakeValidator) Issue(namespace, serviceAccount string) string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic("testkit: failed to generate token: " + err.Error())
+	}
+	token := "testkit-" + hex.EncodeToString(b)
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.tokens[token] = &jwt.Claims{
+		Namespace:      namespace,
+		ServiceAccount: serviceAccount,
+		Subject:        "system:serviceaccount:" + namespace + ":" + serviceAccount,
+		ExpiresAt:      time.Now().Add(time.Hour),
+		IssuedAt:       time.Now(),
+	}
+	return token
+}
+
+// Revoke makes a previously issued token invalid.
+func (v *FakeValidator) Revoke(token string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	delete(v.tokens, token)
+}
+
+// Validate returns the claims for an issued token.
+func (v *FakeValidator) Validate(token string) (*jwt.Claims, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	claims, ok := v.tokens[token]
+	if !ok {
+		return nil, ErrUnknownToken
+	}
+	copied := *claims
+	return &copied, nil
+}
+
+// PermissionStore is an in-memory ServiceAccount permission source.
+type PermissionStore struct {
+	cache *k8s.Cache
+}
+
+// NewPermissionStore creates an empty permission store.
+func NewPermissionStore() *PermissionStore {
+	return &PermissionStore{cache: k8s.NewCache(zap.NewNop())}
+}
+
+// AddServiceAccount adds or replaces a ServiceAccount. Annotations use the same keys
+// as in Kubernetes (e.g. nats.io/allowed-pub-subjects) and produce the same permissions.
+func (s *PermissionStore) AddServiceAccount(namespace, name string, annotations map[string]string) {
+	s.cache.Upsert(&corev1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   namespace,
+			Name:        name,
+			Annotations: annotations,
+		},
+	})
+}
+
+// RemoveServiceAccount removes a ServiceAccount.
+func (s *PermissionStore) RemoveServiceAccount(namespace, name string) {
+	s.cache.Delete(namespace, name)
+}
+
+// GetPermissions returns the permissions for a ServiceAccount.
+func (s *PermissionStore) GetPermissions(namespace, name string) (pubPerms, subPerms []string, found bool) {
+	return s.cache.Get(namespace, name)
+}
+
+// Harness is a running NATS server backed by the auth callout service.
+type Harness struct {
+	Validator   *FakeValidator
+	Permissions *PermissionStore
+
+	server *embeddednats.Server
+	client *nats.Client
+}
+
+// Start starts a harness on an ephemeral port. It is shut down when the test finishes.
+func Start(t testing.TB) *Harness {
+	t.Helper()
+
+	signingKey, err := nkeys.CreateAccount()
+	if err != nil {
+		t.Fatalf("testkit: failed to create signing key: %v", err)
+	}
+	issuer, err := signingKey.PublicKey()
+	if err != nil {
+		t.Fatalf("testkit: failed to get signing key public key: %v", err)
+	}
+
+	h := &Harness{
+		Validator:   NewFakeValidator(),
+		Permissions: NewPermissionStore(),
+	}
+
+	h.server, err = embeddednats.Start(embeddednats.Options{Host: "127.0.0.1", Port: -1, Issuer: issuer}, zap.NewNop())
+	if err != nil {
+		t.Fatalf("testkit: failed to start NATS server: %v", err)
+	}
+	t.Cleanup(h.server.Shutdown)
+
+	authHandler := auth.NewHandler(h.Validator, h.Permissions)
+	h.client, err = nats.NewClient(h.server.ServiceURL(), "", "", "$G", authHandler, zap.NewNop())
+	if err != nil {
+		t.Fatalf("testkit: failed to create callout client: %v", err)
+	}
+	h.client.SetSigningKey(signingKey)
+	if err := h.client.Start(context.Background()); err != nil {
+		t.Fatalf("testkit: failed to start callout client: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = h.client.Shutdown(context.Background())
+	})
+
+	return h
+}
+
+// URL returns the URL NATS clients under test connect to.
+func (h *Harness) URL() string {
+	return h.server.ClientURL()
+}
+
+// AddServiceAccount adds or replaces a ServiceAccount (see PermissionStore.AddServiceAccount).
+func (h *Harness) AddServiceAccount(namespace, name string, annotations map[string]string) {
+	h.Permissions.AddServiceAccount(namespace, name, annotations)
+}
+
+// Token issues a token for a ServiceAccount (see FakeValidator.Issue).
+func (h *Harness) Token(namespace, serviceAccount string) string {
+	return h.Validator.Issue(namespace, serviceAccount)
+}
+
+// Connect connects to the harness with a token, failing the test if the connection
+// is rejected. The connection is closed when the test finishes.
+func (h *Harness) Connect(t testing.TB, token string, opts ...natsclient.Option) *natsclient.Conn {
+	t.Helper()
+
+	conn, err := h.Dial(token, opts...)
+	if err != nil {
+		t.Fatalf("testkit: failed to connect: %v", err)
+	}
+	t.Cleanup(conn.Close)
+	return conn
+}
+
+// Dial connects to the harness with a token and returns any connection error,
+// for tests that expect authorization to fail.
+func (h *Harness) Dial(token string, opts ...natsclient.Option) (*natsclient.Conn, error) {
+	opts = append([]natsclient.Option{natsclient.Token(token), natsclient.Timeout(5 * time.Second)}, opts...)
+	return natsclient.Connect(h.URL(), opts...)
+}
@@ -0,0 +1,87 @@
+package testkit
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	natsclient "github.com/nats-io/nats.go"
+)
+
+func TestHarness_AllowedSubjects(t *testing.T) {
+	h := Start(t)
+	h.AddServiceAccount("orders", "api", map[string]string{
+		"nats.io/allowed-pub-subjects": "events.>",
+		"nats.io/allowed-sub-subjects": "events.>",
+	})
+
+	conn := h.Connect(t, h.Token("orders", "api"))
+
+	// Default namespace scope and annotated subjects
+	for _, subject := range []string{"orders.created", "events.created"} {
+		sub, err := conn.SubscribeSync(subject)
+		if err != nil {
+			t.Fatalf("SubscribeSync(%s) error = %v", subject, err)
+		}
+		if err := conn.Publish(subject, []byte("hello")); err != nil {
+			t.Fatalf("Publish(%s) error = %v", subject, err)
+		}
+		if _, err := sub.NextMsg(2 * time.Second); err != nil {
+			t.Errorf("expected message on %s, got %v", subject, err)
+		}
+	}
+}
+
+func TestHarness_PublishOutsideGrantDenied(t *testing.T) {
+	h := Start(t)
+	h.AddServiceAccount("orders", "api", nil)
+
+	errCh := make(chan error, 1)
+	conn := h.Connect(t, h.Token("orders", "api"),
+		natsclient.ErrorHandler(func(_ *natsclient.Conn, _ *natsclient.Subscription, err error) {
+			select {
+			case errCh <- err:
+			default:
+			}
+		}))
+
+	if err := conn.Publish("billing.created", []byte("hello")); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+
+	select {
+	case err := <-errCh:
+		if !strings.Contains(strings.ToLower(err.Error()), "permissions violation") {
+			t.Errorf("expected permissions violation, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("expected permissions violation for publish outside namespace")
+	}
+}
+
+func TestHarness_RejectedConnections(t *testing.T) {
+	h := Start(t)
+	h.AddServiceAccount("orders", "api", nil)
+
+	revoked := h.Token("orders", "api")
+	h.Validator.Revoke(revoked)
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{name: "unknown token", token: "not-a-token"},
+		{name: "revoked token", token: revoked},
+		{name: "ServiceAccount not in store", token: h.Token("orders", "missing")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := h.Dial(tt.token, natsclient.Timeout(2*time.Second))
+			if err == nil {
+				conn.Close()
+				t.Fatal("expected connection to be rejected")
+			}
+		})
+	}
+}